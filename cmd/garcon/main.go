@@ -0,0 +1,84 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+// Package main provides the garcon command line tool
+// to validate the configuration files before starting the servers
+// and to replay the notifications that could not be sent.
+//
+//	garcon check-rules FILE...
+//	garcon replay-notifications FILE NOTIFIER_URL
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+const usage = `Usage:
+  garcon check-rules FILE...                     validate redirect/rewrite rules files
+  garcon replay-notifications FILE NOTIFIER_URL  resend the notifications of a dead-letter file
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "check-rules":
+		os.Exit(checkRules(os.Args[2:]))
+	case "replay-notifications":
+		os.Exit(replayNotifications(os.Args[2:]))
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown subcommand %q\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+// checkRules returns the exit code: 0 when all files are valid.
+func checkRules(files []string) int {
+	if len(files) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	code := 0
+	for _, file := range files {
+		rules, err := gc.LoadRules(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			code = 1
+			continue
+		}
+		fmt.Printf("%s: %d valid rules\n", file, len(rules))
+	}
+	return code
+}
+
+// replayNotifications returns the exit code: 0 when all the dead letters are sent.
+func replayNotifications(args []string) int {
+	if len(args) != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sent, err := gg.NewDeadLetterFile(args[0]).Replay(ctx, gg.NewNotifier(args[1]))
+	fmt.Printf("%s: %d notifications sent\n", args[0], sent)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// githubAppToken is an installation access token of a GitHub App.
+type githubAppToken struct {
+	expires time.Time
+	token   string
+}
+
+const (
+	defaultHTTPUser   = "git"
+	defaultGitHubAPI  = "https://api.github.com"
+	githubTokenMargin = 5 * time.Minute // renew the installation token before it expires
+	githubJWTLifetime = 9 * time.Minute // GitHub rejects the App JWT valid more than 10 minutes
+)
+
+var (
+	githubTokens   = map[string]githubAppToken{} // installation access tokens by app/installation
+	githubTokensMu sync.Mutex
+)
+
+// gitAuth returns the credentials of the repo from its parameters, nil for a public repo.
+// The parameters only name the environment variables and the files providing
+// the secrets: the configuration file never contains a secret.
+//
+//	[my-private-site]
+//	clone = "git@github.com:org/site.git"
+//	ssh-key = "/etc/gitwww/deploy_key"
+//	ssh-key-passphrase-env = "SITE_KEY_PASSPHRASE"
+//
+//	[other-site]
+//	clone = "https://gitlab.com/org/other.git"
+//	http-user = "oauth2"
+//	http-token-env = "GITLAB_TOKEN"   # or http-token-file = "/run/secrets/gitlab"
+//
+//	[app-site]
+//	github-app-id = "123456"
+//	github-app-installation = "7891011"
+//	github-app-key-file = "/etc/gitwww/app.private-key.pem"
+func gitAuth(params map[string]string) (transport.AuthMethod, error) {
+	for _, p := range []string{"http-token", "http-password", "ssh-key-passphrase"} {
+		if params[p] != "" {
+			return nil, errors.New("the secret " + p + " must not be in the configuration file, use " + p + "-env or " + p + "-file")
+		}
+	}
+
+	switch {
+	case params["github-app-id"] != "":
+		token, err := githubInstallationToken(params)
+		if err != nil {
+			return nil, err
+		}
+		return &githttp.BasicAuth{Username: "x-access-token", Password: token}, nil
+
+	case params["ssh-key"] != "":
+		passphrase, err := readSecret(params, "ssh-key-passphrase")
+		if err != nil {
+			return nil, err
+		}
+		keys, err := ssh.NewPublicKeysFromFile("git", params["ssh-key"], passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("ssh-key %s: %w", params["ssh-key"], err)
+		}
+		if file := params["ssh-known-hosts"]; file != "" {
+			keys.HostKeyCallback, err = ssh.NewKnownHostsCallback(file)
+			if err != nil {
+				return nil, fmt.Errorf("ssh-known-hosts %s: %w", file, err)
+			}
+		}
+		return keys, nil
+	}
+
+	token, err := readSecret(params, "http-token")
+	if err != nil || token == "" {
+		return nil, err
+	}
+	user := params["http-user"]
+	if user == "" {
+		user = defaultHTTPUser
+	}
+	return &githttp.BasicAuth{Username: user, Password: token}, nil
+}
+
+// readSecret reads the secret from the environment variable named by the parameter "<name>-env",
+// or from the file named by the parameter "<name>-file". The secret is empty when none is set.
+func readSecret(params map[string]string, name string) (string, error) {
+	if env := params[name+"-env"]; env != "" {
+		secret, ok := os.LookupEnv(env)
+		if !ok {
+			return "", errors.New(name + "-env: missing environment variable " + env)
+		}
+		return secret, nil
+	}
+	if file := params[name+"-file"]; file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("%s-file: %w", name, err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return "", nil
+}
+
+// githubInstallationToken returns an installation access token of the GitHub App (valid one hour),
+// renewed by signing a JWT with the private key of the App.
+func githubInstallationToken(params map[string]string) (string, error) {
+	appID := params["github-app-id"]
+	installation := params["github-app-installation"]
+	if installation == "" || params["github-app-key-file"] == "" {
+		return "", errors.New("github-app-id requires github-app-installation and github-app-key-file")
+	}
+
+	key := appID + "/" + installation
+	githubTokensMu.Lock()
+	defer githubTokensMu.Unlock()
+	if t, ok := githubTokens[key]; ok && time.Until(t.expires) > githubTokenMargin {
+		return t.token, nil
+	}
+
+	pem, err := os.ReadFile(params["github-app-key-file"])
+	if err != nil {
+		return "", fmt.Errorf("github-app-key-file: %w", err)
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(pem)
+	if err != nil {
+		return "", fmt.Errorf("github-app-key-file: %w", err)
+	}
+
+	now := time.Now()
+	appJWT, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{
+		Issuer:    appID,
+		IssuedAt:  jwt.NewNumericDate(now.Add(-time.Minute)), // tolerate clock drift
+		ExpiresAt: jwt.NewNumericDate(now.Add(githubJWTLifetime)),
+	}).SignedString(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	api := params["github-api"] // GitHub Enterprise: https://github.example.com/api/v3
+	if api == "" {
+		api = defaultGitHubAPI
+	}
+	url := strings.TrimSuffix(api, "/") + "/app/installations/" + installation + "/access_tokens"
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, http.NoBody)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+appJWT)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("GitHub App installation %s: %s", installation, resp.Status)
+	}
+
+	var body struct {
+		ExpiresAt time.Time `json:"expires_at"`
+		Token     string    `json:"token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("GitHub App installation %s: %w", installation, err)
+	}
+	if body.Token == "" {
+		return "", errors.New("GitHub App installation " + installation + ": empty token")
+	}
+
+	githubTokens[key] = githubAppToken{token: body.Token, expires: body.ExpiresAt}
+	slog.Debug("GitHub App token renewed", "installation", installation, "expires", body.ExpiresAt)
+	return body.Token, nil
+}
+
+// gitClone clones the URL of the repo parameter "clone" when the directory does not exist yet.
+func gitClone(dir string, params map[string]string) error {
+	url := params["clone"]
+	if url == "" || directoryExists(dir) {
+		return nil
+	}
+
+	auth, err := gitAuth(params)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Clone", "url", url, "dir", dir)
+	_, err = git.PlainClone(dir, false, &git.CloneOptions{URL: url, Auth: auth})
+	if err != nil {
+		os.RemoveAll(dir) // retry the clone on the next loop
+		return fmt.Errorf("clone %s: %w", url, err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	buildLogsDir         = ".gitwww/logs" // within the repo, ignored by Git (see openBuildLog)
+	buildLogExt          = ".log"
+	buildLogTimeLayout   = "20060102T150405.000000"
+	buildLogMaxSize      = 10 << 20 // the output beyond 10 MiB is dropped
+	defaultBuildLogsKeep = 20
+)
+
+type (
+	// buildLog is the log file of one buildDeploy: the steps (slog text records)
+	// and the output of the Git pull and of the build engine.
+	buildLog struct {
+		file      *os.File
+		logger    *slog.Logger
+		path      string
+		size      int64
+		truncated bool
+		mu        sync.Mutex
+	}
+
+	// buildLogs are the build logs being written, per repo.
+	buildLogs struct {
+		logs map[string]*buildLog
+		mu   sync.Mutex
+	}
+)
+
+// getBuildLogsKeep returns the number of build logs kept per repo:
+// the repo parameter "logs-keep", else the global setting, else 20.
+func (cfg *Cfg) getBuildLogsKeep(dir string) int {
+	keep, err := strconv.Atoi(cfg.Repositories[dir]["logs-keep"])
+	if err == nil && keep > 0 {
+		return keep
+	}
+	if cfg.LogsKeep > 0 {
+		return cfg.LogsKeep
+	}
+	return defaultBuildLogsKeep
+}
+
+// openBuildLog creates the file <repo>/.gitwww/logs/<time>.log receiving the output
+// of the build (see buildOutput) until closeBuildLog, and removes the oldest logs.
+// The failure to create the file does not prevent the build (nil is returned).
+func (cfg *Cfg) openBuildLog(dir string) *buildLog {
+	logsDir := filepath.Join(dir, buildLogsDir)
+	err := os.MkdirAll(logsDir, 0o755)
+	if err == nil {
+		// the logs must neither dirty the worktree nor trigger the snapshots
+		ignore := filepath.Join(filepath.Dir(logsDir), ".gitignore")
+		if !fileExists(ignore) {
+			err = os.WriteFile(ignore, []byte("*\n"), 0o644)
+		}
+	}
+	var file *os.File
+	if err == nil {
+		name := time.Now().UTC().Format(buildLogTimeLayout) + buildLogExt
+		file, err = os.OpenFile(filepath.Join(logsDir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	}
+	if err != nil {
+		slog.Warn("Cannot create the build log", "dir", logsDir, "err", err)
+		return nil
+	}
+
+	pruneFiles(logsDir, buildLogExt, cfg.getBuildLogsKeep(dir))
+
+	bl := &buildLog{file: file, path: file.Name()}
+	bl.logger = slog.New(slog.NewTextHandler(bl, nil))
+	if cfg.buildLogs != nil {
+		cfg.buildLogs.mu.Lock()
+		cfg.buildLogs.logs[dir] = bl
+		cfg.buildLogs.mu.Unlock()
+	}
+	return bl
+}
+
+// closeBuildLog records the result of the build and closes the file.
+func (cfg *Cfg) closeBuildLog(dir string, bl *buildLog, err error) {
+	if bl == nil {
+		return
+	}
+	if cfg.buildLogs != nil {
+		cfg.buildLogs.mu.Lock()
+		delete(cfg.buildLogs.logs, dir)
+		cfg.buildLogs.mu.Unlock()
+	}
+	if err != nil {
+		bl.logger.Error("KO", "err", err)
+	} else {
+		bl.logger.Info("OK")
+	}
+	e := bl.file.Close()
+	if e != nil {
+		slog.Warn("Cannot close the build log", "file", bl.path, "err", e)
+	}
+}
+
+// buildOutput returns the destination of the output of the build engines:
+// stderr, the dashboard logs and the build log of the repo.
+// The values of the build secrets are redacted (see newRedactWriter).
+func (cfg *Cfg) buildOutput(dir string) io.Writer {
+	secrets, _ := getBuildSecrets(cfg.Repositories[dir]) // the build fails before on error
+	writers := []io.Writer{os.Stderr}
+	if cfg.logs != nil {
+		writers = append(writers, cfg.logs) // build output in the dashboard logs
+	}
+	if bl := cfg.buildLogs.get(dir); bl != nil {
+		writers = append(writers, bl)
+	}
+	if len(writers) == 1 {
+		return newRedactWriter(os.Stderr, secrets)
+	}
+	return newRedactWriter(io.MultiWriter(writers...), secrets)
+}
+
+func (bls *buildLogs) get(dir string) *buildLog {
+	if bls == nil {
+		return nil
+	}
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	return bls.logs[dir]
+}
+
+// Write implements io.Writer, the output beyond buildLogMaxSize is dropped.
+// Write never fails to not interrupt the build.
+func (bl *buildLog) Write(p []byte) (int, error) {
+	if bl == nil {
+		return len(p), nil
+	}
+	bl.mu.Lock()
+	defer bl.mu.Unlock()
+
+	if bl.size+int64(len(p)) > buildLogMaxSize {
+		if !bl.truncated {
+			bl.truncated = true
+			_, _ = bl.file.WriteString("\n[gitwww] build log truncated at " + strconv.Itoa(buildLogMaxSize>>20) + " MiB\n")
+		}
+		return len(p), nil
+	}
+	n, err := bl.file.Write(p)
+	bl.size += int64(n)
+	if err != nil && !bl.truncated {
+		bl.truncated = true
+		slog.Warn("Cannot write the build log", "file", bl.path, "err", err)
+	}
+	return len(p), nil
+}
+
+// event records a pull/build/deploy event in the build log.
+func (bl *buildLog) event(e Event) {
+	if bl == nil {
+		return
+	}
+	attrs := []any{"type", e.Type, "result", e.Result, "ms", e.Duration}
+	for _, kv := range [][2]string{{"commit", e.Commit}, {"ref", e.Ref}, {"engine", e.Engine}, {"snapshot", e.Snapshot}, {"err", e.Error}} {
+		if kv[1] != "" {
+			attrs = append(attrs, kv[0], kv[1])
+		}
+	}
+	bl.logger.Info("Event", attrs...)
+}
+
+// lastBuildLog returns the path of the most recent build log of the repo.
+func lastBuildLog(dir string) (string, error) {
+	logsDir := filepath.Join(dir, buildLogsDir)
+	entries, err := os.ReadDir(logsDir)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), buildLogExt) {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 {
+		return "", errors.New("no build log")
+	}
+	return filepath.Join(logsDir, slices.Max(names)), nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/go-units"
+	"github.com/moby/moby/client"
+)
+
+// cacheIDArg is the build argument conveying the per-repo cache ID.
+// The Containerfile uses it to name its BuildKit cache mounts,
+// so the dependencies are kept across builds but not shared between repos:
+//
+//	ARG GITWWW_CACHE_ID
+//	RUN --mount=type=cache,id=${GITWWW_CACHE_ID}-npm,target=/root/.npm npm ci
+const cacheIDArg = "GITWWW_CACHE_ID"
+
+// getCache reports whether the repo enables the build cache mounts (parameter "cache").
+// The cache mounts require BuildKit.
+func (cfg *Cfg) getCache(dir string) bool {
+	c := cfg.Repositories[dir]["cache"]
+	return c == "1" || strings.Contains(strings.ToLower(c), "true")
+}
+
+// getCacheID returns the cache ID of the repo, derived from the image tag.
+func (cfg *Cfg) getCacheID(dir string) string {
+	return "gitwww-" + cfg.getTag(dir)
+}
+
+// getCacheMax returns the maximum size (bytes) of the cache mounts, zero means no limit.
+// The repo parameter "cache-max" takes precedence on the global one.
+func (cfg *Cfg) getCacheMax(dir string) int64 {
+	txt := cfg.Repositories[dir]["cache-max"]
+	if txt == "" {
+		txt = cfg.CacheMax
+	}
+	if txt == "" {
+		return 0
+	}
+	size, err := units.FromHumanSize(txt)
+	if err != nil {
+		slog.Warn("Invalid cache-max, expected a size like 5GB", "dir", dir, "cache-max", txt, "err", err)
+		return 0
+	}
+	return size
+}
+
+// setBuildCache enables BuildKit and passes the cache ID when the repo enables the cache.
+func (cfg *Cfg) setBuildCache(dir string, options *build.ImageBuildOptions) {
+	if !cfg.getCache(dir) {
+		return
+	}
+	options.Version = build.BuilderBuildKit
+	if options.BuildArgs == nil {
+		options.BuildArgs = make(map[string]*string, 1)
+	}
+	id := cfg.getCacheID(dir)
+	options.BuildArgs[cacheIDArg] = &id
+}
+
+// pruneBuildCache removes the least recently used cache mounts of the repo
+// until their total size is below the limit. Only the cache mounts named after
+// the cache ID of the repo are considered ("${GITWWW_CACHE_ID}" or "${GITWWW_CACHE_ID}-<name>"),
+// the cache of the other repos and of the other projects using the daemon is kept,
+// as well as the image layers.
+// The Podman cache mounts are not pruned: Podman does not report them per ID.
+func (cfg *Cfg) pruneBuildCache(ctx context.Context, cli *client.Client, dir string) {
+	maxSize := cfg.getCacheMax(dir)
+	if maxSize == 0 || !cfg.getCache(dir) {
+		return
+	}
+
+	du, err := cli.DiskUsage(ctx, types.DiskUsageOptions{Types: []types.DiskUsageObject{types.BuildCacheObject}})
+	if err != nil {
+		slog.Warn("DiskUsage", "dir", dir, "err", err)
+		return
+	}
+
+	records := repoCacheMounts(du.BuildCache, cfg.getCacheID(dir))
+	var total, reclaimed int64
+	for _, r := range records {
+		total += r.Size
+	}
+	deleted := 0
+	for _, r := range records { // least recently used first
+		if total <= maxSize {
+			break
+		}
+		report, err := cli.BuildCachePrune(ctx, build.CachePruneOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("id", r.ID)),
+		})
+		if err != nil {
+			slog.Warn("BuildCachePrune", "dir", dir, "id", r.ID, "err", err)
+			return
+		}
+		total -= r.Size
+		reclaimed += int64(report.SpaceReclaimed)
+		deleted += len(report.CachesDeleted)
+	}
+	if deleted == 0 {
+		return
+	}
+
+	slog.Info("Pruned build cache", "dir", dir,
+		"max", units.HumanSize(float64(maxSize)),
+		"entries", deleted,
+		"reclaimed", units.HumanSize(float64(reclaimed)))
+}
+
+// repoCacheMounts returns the unused cache mounts named after the cache ID,
+// the least recently used first. BuildKit describes a cache mount as
+// "cached mount <target> from <step> with id "<id>"".
+func repoCacheMounts(records []*build.CacheRecord, cacheID string) []*build.CacheRecord {
+	var mounts []*build.CacheRecord
+	for _, r := range records {
+		if r.Type != "exec.cachemount" || r.InUse {
+			continue
+		}
+		_, id, ok := strings.Cut(r.Description, ` with id "`)
+		if !ok {
+			continue
+		}
+		id = strings.TrimSuffix(id, `"`)
+		if id == cacheID || strings.HasPrefix(id, cacheID+"-") {
+			mounts = append(mounts, r)
+		}
+	}
+	slices.SortFunc(mounts, func(a, b *build.CacheRecord) int {
+		return lastUsed(a).Compare(lastUsed(b))
+	})
+	return mounts
+}
+
+func lastUsed(r *build.CacheRecord) time.Time {
+	if r.LastUsedAt != nil {
+		return *r.LastUsedAt
+	}
+	return r.CreatedAt
+}
@@ -6,7 +6,9 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -20,13 +22,31 @@ import (
 )
 
 type Cfg struct {
-	Repositories map[string]map[string]string `toml:"-"      yaml:"-"      comment:"Git repos to watch and their build arguments"`
-	Path         string                       `toml:"cfg"    yaml:"cfg"    comment:"\nConfiguration path: can be a directory or a TOML file"`
-	Repos        string                       `toml:"repos"  yaml:"repos"  comment:"\ndirectory containing the repositories to build/deploy (default /var/opt/garcon)"`
-	WWW          string                       `toml:"www"    yaml:"www"    comment:"\nfinal destination of the deployed static web file (default /var/opt/www)"`
-	Engine       string                       `toml:"engine" yaml:"engine" comment:"\none or two container management tools (separated by a comma) among docker and podman (default docker)"`
-	LogLevel     string                       `toml:"log"    yaml:"log"    comment:"\nlog verbosity level can be DEBUG, INFO, WARN and ERROR (default INFO)"`
-	Sleep        int                          `toml:"sleep"  yaml:"sleep"  comment:"\nseconds before checking new Git commits (default 10 seconds)"`
+	Repositories  map[string]map[string]string `toml:"-"      yaml:"-"      comment:"Git repos to watch and their build arguments"`
+	Path          string                       `toml:"cfg"    yaml:"cfg"    comment:"\nConfiguration path: can be a directory or a TOML file"`
+	Repos         string                       `toml:"repos"  yaml:"repos"  comment:"\ndirectory containing the repositories to build/deploy (default /var/opt/garcon)"`
+	WWW           string                       `toml:"www"    yaml:"www"    comment:"\nfinal destination of the deployed static web file (default /var/opt/www)"`
+	Engine        string                       `toml:"engine" yaml:"engine" comment:"\none or two container management tools (separated by a comma) among docker and podman (default docker), the repos having the parameter build-cmd use the engine cmd (no container)"`
+	LogLevel      string                       `toml:"log"    yaml:"log"    comment:"\nlog verbosity level can be DEBUG, INFO, WARN and ERROR (default INFO)"`
+	CacheMax      string                       `toml:"cache-max" yaml:"cache-max" comment:"\nmaximum size of the build cache mounts (e.g. 5GB) of each repo enabling cache=true, Docker only (default no limit)"`
+	Events        string                       `toml:"events" yaml:"events" comment:"\nJSONL file logging the pull/build/deploy events (default events.jsonl next to the configuration file)"`
+	Status        string                       `toml:"status" yaml:"status" comment:"\nlisten address of the status endpoint, e.g. localhost:8485 (default disabled)"`
+	Dashboard     string                       `toml:"dashboard" yaml:"dashboard" comment:"\nlisten address of the web dashboard, e.g. localhost:8486 (default disabled)"`
+	DashboardKey  string                       `toml:"dashboard-key" yaml:"-" comment:"\nHMAC-SHA256 key (64 hexadecimal digits) verifying the JWT of the dashboard users, use -token to create a JWT"`
+	Snapshots     string                       `toml:"snapshots" yaml:"snapshots" comment:"\ndirectory of the archives of the local changes discarded by the hard reset (default snapshots next to the configuration file)"`
+	Sleep         int                          `toml:"sleep"  yaml:"sleep"  comment:"\nseconds before checking new Git commits (default 10 seconds)"`
+	SnapshotsKeep int                          `toml:"snapshots-keep" yaml:"snapshots-keep" comment:"\nnumber of snapshots kept per repository (default 5)"`
+	Notify        string                       `toml:"notify" yaml:"-" comment:"\nnotifier of the deployments: Mattermost, Slack or Discord webhook URL, Telegram bot URL or smtp:// URL (default disabled), the environment variable GITWWW_NOTIFY takes precedence"`
+	Keep          int                          `toml:"keep" yaml:"keep" comment:"\nnumber of previous deployments kept per repository for the rollbacks, the repo parameter keep takes precedence (default 1)"`
+	Parallel      int                          `toml:"parallel" yaml:"parallel" comment:"\nnumber of repositories built concurrently, a repository is never built twice at the same time (default 1)"`
+	LogsKeep      int                          `toml:"logs-keep" yaml:"logs-keep" comment:"\nnumber of build logs kept per repository in <repo>/.gitwww/logs, the repo parameter logs-keep takes precedence (default 20)"`
+	events        *EventLog
+	jobs          chan job
+	logs          *logTail
+	locks         *repoLocks
+	repoList      *repoList
+	buildLogs     *buildLogs
+	notifier      *deployNotifier
 }
 
 const (
@@ -39,6 +59,8 @@ const (
 	GITWWW_CFG = "GITWWW_CFG"
 	GITWWW_WWW = "GITWWW_WWW"
 	GITWWW_LOG = "GITWWW_LOG"
+
+	GITWWW_NOTIFY = "GITWWW_NOTIFY"
 )
 
 //go:embed manpage.txt
@@ -59,6 +81,8 @@ func getCfg() (*Cfg, error) {
 	absolute := flag.Bool("ww", false, "overwrite an explicit version of the configuration file using absolute paths")
 	simplify := flag.Bool("www", false, "overwrite a simplified version of the configuration file")
 	clean := flag.Bool("wwww", false, "overwrite a very simplified version of the configuration file: use the minimum required repo parameters")
+	token := flag.String("token", "", "print a JWT (valid 30 days) for the given dashboard user and exit")
+	prerenderFlag := flag.Bool("prerender", false, "write the routes of the repo parameter prerender (comma-separated) into the www directories and exit")
 	flag.Parse()
 
 	if *doc {
@@ -236,6 +260,32 @@ func getCfg() (*Cfg, error) {
 		return nil, nil
 	}
 
+	if *token != "" {
+		jwt, err := cfg.newDashboardToken(*token)
+		if err != nil {
+			slog.Error("Cannot create the dashboard JWT", "err", err)
+			return nil, err
+		}
+		fmt.Println(jwt)
+		return nil, nil
+	}
+
+	if *prerenderFlag {
+		return nil, cfg.prerenderAll(context.Background())
+	}
+
+	switch flag.Arg(0) {
+	case "":
+	case "rollback":
+		return nil, cfg.rollbackCommand(flag.Args()[1:])
+	case "check":
+		return nil, cfg.checkCommand(flag.Args()[1:])
+	default:
+		err = errors.New("unknown command " + flag.Arg(0) + ", usage: gitwww [flags] [rollback <repo> [n] | check]")
+		slog.Error("Bad command line", "err", err)
+		return nil, err
+	}
+
 	return cfg, nil
 }
 
@@ -370,9 +420,14 @@ func (cfg *Cfg) absRepositories() map[string]string {
 			slog.Debug("skip no exist", "repo", repo)
 			continue
 		}
+		err = gitClone(abs, cfg.Repositories[repo])
+		if err != nil {
+			slog.Warn("Skip", "repo", repo, "err", err)
+			continue
+		}
 		file := cfg.findContainerfile(repo)
-		if file == "" {
-			slog.Debug("skip no Containerfile/Dockerfile", "repo", repo, "abs", abs)
+		if file == "" && cfg.Repositories[repo]["build-cmd"] == "" {
+			slog.Debug("skip no Containerfile/Dockerfile/build-cmd", "repo", repo, "abs", abs)
 			continue
 		}
 		slog.Debug("add", "repo", repo, "abs", abs)
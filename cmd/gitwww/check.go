@@ -87,15 +87,16 @@ func (cfg *Cfg) shouldDeploy(abs string, params map[string]string) *git.Reposito
 		return repo
 	}
 
-	branch, found := params["branch"]
-	if !found {
-		branch = "origin/main"
+	if !isBranchStrategy(params) {
+		err = fetchRemote(repo, params) // the tags are not fetched by the pull
+		if err != nil {
+			slog.Warn("Cannot fetch", "dir", abs, "err", err)
+		}
 	}
-	ref := plumbing.ReferenceName("refs/remotes/" + branch)
 
-	remoteRef, err := repo.Reference(ref, true)
+	target, ref, err := resolveRef(repo, params)
 	if err != nil {
-		slog.Warn("Cannot repo.Reference", "dir", abs, "ref", ref, "err", err)
+		slog.Warn("Cannot resolve", "dir", abs, "ref", ref, "err", err)
 		return nil
 	}
 
@@ -105,12 +106,12 @@ func (cfg *Cfg) shouldDeploy(abs string, params map[string]string) *git.Reposito
 		return nil
 	}
 
-	if remoteRef.Hash() == localRef.Hash() {
+	if target == localRef.Hash() {
 		return nil // same commit
 	}
 
-	slog.Info("shouldDeploy because new commit")
-	logHistory(repo, remoteRef.Hash(), localRef.Hash())
+	slog.Info("shouldDeploy because new commit", "ref", ref)
+	logHistory(repo, target, localRef.Hash())
 	return repo
 }
 
@@ -0,0 +1,213 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	engineCmd           = "cmd"
+	defaultBuildTimeout = 15 * time.Minute
+	buildWaitDelay      = 10 * time.Second // after the timeout, before closing the output pipes
+)
+
+// buildCommand builds the site without container: the repo parameter "build-cmd"
+// (e.g. "npm ci && npm run build", "hugo --minify", "zola build") runs with "sh -c"
+// in a copy of the worktree (without .git), then "dist-path" is copied into www.
+// The layout is the same as the container engines: www/<base of dist-path>/...
+// (e.g. www/public/index.html), so switching the engine does not change the URLs.
+//
+//	[my-blog]
+//	build-cmd = "hugo --minify"
+//	dist-path = "public"
+//	build-timeout = "5m"                  # default 15m
+//	build-env = "HUGO_ENV=production"     # space-separated KEY=value
+//	build-env-pass = "NPM_TOKEN"          # variables copied from the gitwww environment
+//
+// The command does not inherit the gitwww environment: only PATH, LANG, TZ, a HOME within
+// the working copy, the build-env variables, the build-env-pass ones and the build secrets
+// (see getBuildSecrets).
+func (cfg *Cfg) buildCommand(ctx context.Context, dir string, params map[string]string) error {
+	command := params["build-cmd"]
+	if command == "" {
+		return errors.New("engine=cmd requires the repo parameter build-cmd")
+	}
+
+	timeout := defaultBuildTimeout
+	if txt := params["build-timeout"]; txt != "" {
+		var err error
+		timeout, err = time.ParseDuration(txt)
+		if err != nil {
+			return fmt.Errorf("build-timeout: %w", err)
+		}
+	}
+
+	work, err := os.MkdirTemp("", "gitwww-build-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(work)
+
+	src := filepath.Join(work, "src")
+	err = copyTree(dir, src, ".git", ".gitwww")
+	if err != nil {
+		return fmt.Errorf("failed to copy the worktree: %w", err)
+	}
+	home := filepath.Join(work, "home")
+	err = os.Mkdir(home, 0o700)
+	if err != nil {
+		return err
+	}
+
+	env, err := buildEnv(params, home)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out := cfg.buildOutput(dir)
+	defer flushOutput(out)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = src
+	cmd.Env = env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.WaitDelay = buildWaitDelay
+	killProcessGroup(cmd)
+
+	start := time.Now()
+	slog.Info("buildCommand", "dir", dir, "cmd", command, "timeout", timeout)
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		return fmt.Errorf("build-cmd exceeds build-timeout=%s: %w", timeout, err)
+	}
+	if err != nil {
+		slog.Warn("buildCommand", "dir", dir, "cmd", command, "err", err)
+		return fmt.Errorf("build-cmd failed: %w", err)
+	}
+	slog.Info("✅ buildCommand OK", "dir", dir, "ms", since(start))
+
+	dist := params["dist-path"]
+	if dist == "" {
+		dist = "/dist"
+	}
+	dist = filepath.Join(src, dist) // dist-path is relative to the working copy
+	if !strings.HasPrefix(dist, src+string(filepath.Separator)) {
+		return errors.New("dist-path must be within the repo, got " + params["dist-path"])
+	}
+	if !directoryExists(dist) {
+		return errors.New("build-cmd did not create the dist-path " + strings.TrimPrefix(dist, src))
+	}
+
+	// same layout as the Docker archive and "podman cp": www/<base of dist-path>/...
+	www := cfg.getAbsWWW(dir)
+	newWWW := www + "--new"
+	os.RemoveAll(newWWW)
+	err = copyTree(dist, filepath.Join(newWWW, filepath.Base(dist)))
+	if err != nil {
+		os.RemoveAll(newWWW)
+		return fmt.Errorf("failed to copy the dist-path: %w", err)
+	}
+	return cfg.installWWW(dir, www, newWWW)
+}
+
+// buildEnv returns the environment of the build command.
+func buildEnv(params map[string]string, home string) ([]string, error) {
+	env := []string{"HOME=" + home, "CI=true"}
+	for _, name := range []string{"PATH", "LANG", "TZ"} {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	for kv := range strings.FieldsSeq(params["build-env"]) {
+		if !strings.Contains(kv, "=") {
+			return nil, errors.New("build-env: want KEY=value, got " + kv)
+		}
+		env = append(env, kv)
+	}
+	for name := range strings.FieldsFuncSeq(params["build-env-pass"], func(r rune) bool { return r == ',' || r == ' ' }) {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, errors.New("build-env-pass: missing environment variable " + name)
+		}
+		env = append(env, name+"="+v)
+	}
+	secrets, err := getBuildSecrets(params)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range secrets {
+		env = append(env, s.id+"="+s.value)
+	}
+	return env, nil
+}
+
+// copyTree copies the directories, the regular files and the symbolic links of src into dst,
+// skipping the top-level entries named skip.
+func copyTree(src, dst string, skip ...string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if slices.Contains(skip, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case d.Type().IsRegular():
+			return copyFile(path, target)
+		}
+		return nil // sockets, devices...
+	})
+}
+
+func copyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	return errors.Join(err, out.Close())
+}
@@ -0,0 +1,12 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// killProcessGroup does nothing: only the shell is killed on timeout.
+func killProcessGroup(*exec.Cmd) {}
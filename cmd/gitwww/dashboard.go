@@ -0,0 +1,298 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+	"github.com/lynxai-team/garcon/gwt"
+)
+
+//go:embed dashboard
+var dashboardFS embed.FS
+
+const (
+	jobBuild    = "build"
+	jobRollback = "rollback"
+
+	jobsQueueSize  = 10
+	logTailLines   = 500
+	dashboardToken = "30d"
+)
+
+// job is a build or rollback requested from the dashboard,
+// run by the main loop between two Git checks.
+type job struct {
+	dir    string
+	action string
+	user   string
+}
+
+// repoStatus is a repository listed by the dashboard.
+type repoStatus struct {
+	Last   *Event `json:"last,omitempty"`
+	Repo   string `json:"repo"`
+	WWW    string `json:"www"`
+	Tag    string `json:"tag"`
+	Branch string `json:"branch"`
+}
+
+// serveDashboard starts the dashboard HTTP server in background (when cfg.Dashboard is set).
+// The static files are served by gc.StaticWebServer, the API requires a JWT signed by DashboardKey.
+//
+//	GET  /api/repos                  repositories and their last event
+//	GET  /api/builds?n=50&repo=dir   last events (most recent first)
+//	GET  /api/logs?n=200             last log lines
+//	GET  /api/timings                duration quantiles of the pulls, builds and deploys
+//	POST /api/build?repo=dir         pull, build and deploy now
+//	POST /api/rollback?repo=dir      restore the previous deployed files
+func (cfg *Cfg) serveDashboard() {
+	if cfg.Dashboard == "" {
+		return
+	}
+
+	verifier, err := gwt.NewVerifier(cfg.DashboardKey, false)
+	if err != nil {
+		slog.Error("Dashboard disabled: invalid dashboard-key", "err", err)
+		return
+	}
+
+	dir, err := extractDashboard()
+	if err != nil {
+		slog.Error("Dashboard disabled: cannot extract the static files", "err", err)
+		return
+	}
+
+	cfg.jobs = make(chan job, jobsQueueSize)
+	cfg.logs = &logTail{max: logTailLines}
+	// a new handler because wrapping the default one would loop through the log package
+	var lvl slog.Level
+	_ = lvl.UnmarshalText([]byte(cfg.LogLevel))
+	text := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: lvl})
+	slog.SetDefault(slog.New(&tailHandler{Handler: text, tail: cfg.logs}))
+
+	ws := gc.NewStaticWebServer(gg.NewWriter(""), dir)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", ws.ServeFile("index.html", "text/html; charset=utf-8"))
+	mux.HandleFunc("GET /dashboard.js", ws.ServeDir("text/javascript; charset=utf-8"))
+	mux.HandleFunc("GET /dashboard.css", ws.ServeDir("text/css; charset=utf-8"))
+	mux.HandleFunc("GET /api/repos", requireJWT(verifier, cfg.handleRepos))
+	mux.HandleFunc("GET /api/builds", requireJWT(verifier, cfg.handleBuilds))
+	mux.HandleFunc("GET /api/logs", requireJWT(verifier, cfg.handleLogs))
+	mux.HandleFunc("GET /api/timings", requireJWT(verifier, cfg.handleTimings))
+	mux.HandleFunc("POST /api/build", requireJWT(verifier, cfg.handleJob(jobBuild)))
+	mux.HandleFunc("POST /api/rollback", requireJWT(verifier, cfg.handleJob(jobRollback)))
+
+	server := &http.Server{
+		Addr:              cfg.Dashboard,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	slog.Info("Dashboard", "url", "http://"+cfg.Dashboard+"/")
+	go func() {
+		err := server.ListenAndServe()
+		slog.Error("Dashboard stopped", "addr", cfg.Dashboard, "err", err)
+	}()
+}
+
+// extractDashboard copies the embedded static files into a temporary directory
+// because gc.StaticWebServer serves files from the disk.
+func extractDashboard() (string, error) {
+	dir, err := os.MkdirTemp("", "gitwww-dashboard-")
+	if err != nil {
+		return "", err
+	}
+	sub, err := fs.Sub(dashboardFS, "dashboard")
+	if err != nil {
+		return "", err
+	}
+	err = os.CopyFS(dir, sub)
+	if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// newDashboardToken creates a JWT accepted by the dashboard API.
+func (cfg *Cfg) newDashboardToken(user string) (string, error) {
+	if cfg.DashboardKey == "" {
+		return "", errors.New("missing dashboard-key in the configuration file")
+	}
+	tokenizer, err := gwt.NewHMAC(cfg.DashboardKey, false)
+	if err != nil {
+		return "", err
+	}
+	return tokenizer.GenAccessToken(dashboardToken, dashboardToken, user, nil, nil)
+}
+
+// requireJWT accepts the requests having a valid "Authorization: Bearer <JWT>".
+func requireJWT(verifier gwt.Verifier, next func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			http.Error(w, gwt.ErrNoAuthorization.Error(), http.StatusUnauthorized)
+			return
+		}
+		claims, err := verifier.Claims([]byte(token))
+		if err != nil {
+			slog.Warn("Dashboard rejects JWT", "ip", r.RemoteAddr, "err", err)
+			http.Error(w, "invalid JWT", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, claims.Username)
+	}
+}
+
+func (cfg *Cfg) handleRepos(w http.ResponseWriter, _ *http.Request, _ string) {
+	last := make(map[string]Event)
+	for _, e := range cfg.events.Last(eventsInMem) {
+		if _, ok := last[e.Repo]; !ok {
+			last[e.Repo] = e
+		}
+	}
+
+	repos := []repoStatus{}
+	for _, t := range cfg.knownRepos() {
+		rs := repoStatus{
+			Repo:   t.dir,
+			WWW:    t.params["www"],
+			Tag:    t.params["tag"],
+			Branch: t.params["branch"],
+		}
+		if e, ok := last[t.dir]; ok {
+			rs.Last = &e
+		}
+		repos = append(repos, rs)
+	}
+	writeJSON(w, repos)
+}
+
+func (cfg *Cfg) handleTimings(w http.ResponseWriter, _ *http.Request, _ string) {
+	writeJSON(w, cfg.events.Timings())
+}
+
+func (cfg *Cfg) handleBuilds(w http.ResponseWriter, r *http.Request, _ string) {
+	n, ok := queryN(w, r, defaultLastEvents)
+	if !ok {
+		return
+	}
+	repo := r.URL.Query().Get("repo")
+	if repo == "" {
+		writeJSON(w, cfg.events.Last(n))
+		return
+	}
+
+	events := []Event{}
+	for _, e := range cfg.events.Last(eventsInMem) {
+		if e.Repo == repo && len(events) < n {
+			events = append(events, e)
+		}
+	}
+	writeJSON(w, events)
+}
+
+func (cfg *Cfg) handleLogs(w http.ResponseWriter, r *http.Request, _ string) {
+	n, ok := queryN(w, r, 200)
+	if ok {
+		writeJSON(w, cfg.logs.Last(n))
+	}
+}
+
+func (cfg *Cfg) handleJob(action string) func(http.ResponseWriter, *http.Request, string) {
+	return func(w http.ResponseWriter, r *http.Request, user string) {
+		dir := r.URL.Query().Get("repo")
+		if !cfg.isRepo(dir) {
+			http.Error(w, "unknown repo", http.StatusNotFound)
+			return
+		}
+
+		select {
+		case cfg.jobs <- job{dir: dir, action: action, user: user}:
+			slog.Info("Dashboard queues "+action, "repo", dir, "user", user)
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.Error(w, "too many pending jobs", http.StatusServiceUnavailable)
+		}
+	}
+}
+
+func (cfg *Cfg) isRepo(dir string) bool {
+	for _, t := range cfg.knownRepos() {
+		if t.dir == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func queryN(w http.ResponseWriter, r *http.Request, def int) (int, bool) {
+	txt := r.URL.Query().Get("n")
+	if txt == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(txt)
+	if err != nil || n < 0 {
+		http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
+// waitJobs sleeps until the next Git check while running the dashboard jobs.
+func (cfg *Cfg) waitJobs(ctx context.Context) {
+	timer := time.NewTimer(time.Duration(cfg.Sleep) * time.Second)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		case j := <-cfg.jobs: // nil channel when the dashboard is disabled
+			cfg.runJob(ctx, j)
+		}
+	}
+}
+
+func (cfg *Cfg) runJob(ctx context.Context, j job) {
+	for _, t := range cfg.knownRepos() {
+		dir, params := t.dir, t.params
+		if dir != j.dir {
+			continue
+		}
+		slog.Info("Dashboard job", "action", j.action, "repo", dir, "user", j.user)
+		unlock := cfg.lockRepo(dir, params)
+		defer unlock()
+		switch j.action {
+		case jobBuild:
+			repo, err := git.PlainOpen(dir)
+			if err != nil {
+				slog.Warn("Cannot git.PlainOpen", "dir", dir, "err", err)
+				return
+			}
+			_ = cfg.buildDeploy(ctx, repo, dir, params) // the failure is recorded in the events
+		case jobRollback:
+			_ = cfg.rollback(dir, params, 1) // the failure is recorded in the events
+		}
+		return
+	}
+	slog.Warn("Dashboard job: repo no longer configured", "repo", j.dir, "action", j.action)
+}
@@ -0,0 +1,89 @@
+"use strict";
+
+const tokenKey = "gitwww-token";
+
+function api(method, path) {
+  return fetch(path, {
+    method: method,
+    headers: { Authorization: "Bearer " + (localStorage.getItem(tokenKey) || "") },
+  }).then((resp) => {
+    if (!resp.ok) {
+      return resp.text().then((txt) => { throw new Error(resp.status + " " + txt); });
+    }
+    return resp.status === 202 ? null : resp.json();
+  });
+}
+
+function cell(row, text, className) {
+  const td = row.insertCell();
+  td.textContent = text === undefined ? "" : text;
+  if (className) td.className = className;
+  return td;
+}
+
+function button(td, label, action, repo) {
+  const b = document.createElement("button");
+  b.textContent = label;
+  b.onclick = () => {
+    if (!confirm(label + " " + repo + "?")) return;
+    api("POST", "/api/" + action + "?repo=" + encodeURIComponent(repo)).then(refresh, showError);
+  };
+  td.appendChild(b);
+}
+
+function renderRepos(repos) {
+  const body = document.querySelector("#repos tbody");
+  body.replaceChildren();
+  for (const r of repos) {
+    const row = body.insertRow();
+    const last = r.last || {};
+    cell(row, r.repo);
+    cell(row, r.branch || "origin/main");
+    cell(row, last.type ? last.type + " " + last.result : "", last.result);
+    cell(row, (last.commit || "").slice(0, 8));
+    const td = cell(row, "");
+    button(td, "Build", "build", r.repo);
+    button(td, "Rollback", "rollback", r.repo);
+  }
+}
+
+function renderBuilds(events) {
+  const body = document.querySelector("#builds tbody");
+  body.replaceChildren();
+  for (const e of events) {
+    const row = body.insertRow();
+    cell(row, new Date(e.time).toLocaleString());
+    cell(row, e.repo);
+    cell(row, e.type);
+    cell(row, e.result, e.result);
+    cell(row, (e.duration_ms / 1000).toFixed(1) + " s");
+    cell(row, e.error);
+  }
+}
+
+function showError(err) {
+  const p = document.getElementById("error");
+  p.textContent = err.message;
+  p.hidden = false;
+}
+
+function refresh() {
+  document.getElementById("error").hidden = true;
+  Promise.all([api("GET", "/api/repos"), api("GET", "/api/builds?n=30"), api("GET", "/api/logs?n=200")])
+    .then(([repos, builds, logs]) => {
+      renderRepos(repos);
+      renderBuilds(builds);
+      const pre = document.getElementById("logs");
+      pre.textContent = logs.join("\n");
+      pre.scrollTop = pre.scrollHeight;
+    }, showError);
+}
+
+document.getElementById("login").onsubmit = (event) => {
+  event.preventDefault();
+  localStorage.setItem(tokenKey, document.getElementById("token").value.trim());
+  refresh();
+};
+
+refresh();
+setInterval(refresh, 10000);
@@ -7,17 +7,22 @@ package main
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/lynxai-team/garcon/gc"
 )
 
 // Log messages.
@@ -33,25 +38,44 @@ func logError(msg string) {
 // buildDeploy retrieves the new Git commits,
 // builds using the provided Containerfile,
 // and copies the files from the container image to the www directory.
-func (cfg *Cfg) buildDeploy(ctx context.Context, repo *git.Repository, dir string, params map[string]string) {
-	err := gitPull(repo, params)
-	if err != nil {
-		logError("KO git pull. Local changes might exist.")
-		return
+// The steps and the output of the pull and of the build go to the build log (see openBuildLog).
+// The caller holds the lock of the repo, see repoLocks.
+func (cfg *Cfg) buildDeploy(ctx context.Context, repo *git.Repository, dir string, params map[string]string) (err error) {
+	start := time.Now()
+	defer func() { cfg.notifyDeploy(repo, dir, params, start, err) }()
+
+	bl := cfg.openBuildLog(dir)
+	defer func() { cfg.closeBuildLog(dir, bl, err) }()
+	addEvent := func(e Event) {
+		if bl != nil {
+			e.Log = bl.path
+		}
+		bl.event(e)
+		cfg.events.Add(e)
 	}
 
-	engines, found := params["engine"]
-	if !found {
-		engines = cfg.Engine
+	ref, snapshot, err := cfg.gitPull(repo, dir, params)
+	commit := headCommit(repo)
+	pulled := newEvent(dir, EventPull, commit, "", start, err)
+	pulled.Ref = ref
+	pulled.Snapshot = snapshot
+	addEvent(pulled)
+	if err != nil {
+		logError("KO git pull. Local changes might exist.")
+		return fmt.Errorf("git pull: %w", err)
 	}
 
-	for engine := range strings.SplitSeq(engines, ",") {
+	engines := cfg.getEngines(params)
+	buildStart := time.Now()
+	var engine string
+	for engine = range strings.SplitSeq(engines, ",") {
 		switch engine {
 		case "docker":
 			err = cfg.buildDockerImage(ctx, dir)
+		case engineCmd:
+			err = cfg.buildCommand(ctx, dir, params)
 		case "podman":
-			// temporary disabled -- err = cfg.buildPodmanImage(ctx, dir)
-			logError("podman is disabled, please use Docker")
+			err = cfg.buildPodmanImage(ctx, dir)
 		default:
 			logError("Unexpected engine=" + engine)
 		}
@@ -59,29 +83,123 @@ func (cfg *Cfg) buildDeploy(ctx context.Context, repo *git.Repository, dir strin
 			break
 		}
 	}
+	built := newEvent(dir, EventBuild, commit, engine, buildStart, err)
+	built.Ref = ref
+	addEvent(built)
 
 	if err != nil {
 		logError("KO commit")
-		return
+		return fmt.Errorf("%s build: %w", engine, err)
+	}
+
+	// the new www is live: the prerendered routes, the _redirects and verify-url
+	// decide whether it stays, else the previous deployment is restored
+	err = prerender(ctx, params)
+	if err != nil {
+		err = fmt.Errorf("prerender: %w", err)
+	} else {
+		err = checkRedirects(params["www"])
+		if err != nil {
+			err = fmt.Errorf("invalid _redirects: %w", err)
+		} else {
+			err = verifyDeploy(ctx, params)
+		}
+	}
+
+	// the duration of the deploy event is the whole pull/build/deploy/verify time
+	deployed := newEvent(dir, EventDeploy, commit, engine, start, err)
+	deployed.Ref = ref
+	deployed.Size = dirSize(params["www"])
+	addEvent(deployed)
+
+	if err != nil {
+		logError("KO " + err.Error() + " => automatic rollback")
+		e := cfg.rollback(dir, params, 1)
+		if e != nil {
+			logError("KO rollback: " + e.Error())
+		}
+		if bl != nil {
+			bl.logger.Warn("Automatic rollback", "cause", err, "err", e)
+		}
+		return err
+	}
+	return nil
+}
+
+// checkRedirects validates the "_redirects" file generated by the site (if any),
+// the file is served by a gc.Rules middleware migrating the legacy URLs.
+func checkRedirects(www string) error {
+	if www == "" {
+		return nil
+	}
+	file := filepath.Join(www, "_redirects")
+	if _, err := os.Stat(file); err != nil {
+		return nil //nolint:nilerr // no _redirects file
+	}
+	rules, err := gc.LoadRules(file)
+	if err != nil {
+		return err
+	}
+	logMessage(file + ": " + strconv.Itoa(len(rules)) + " rules")
+	return nil
+}
+
+func newEvent(dir, typ, commit, engine string, start time.Time, err error) Event {
+	e := Event{
+		Repo:     dir,
+		Type:     typ,
+		Result:   ResultOK,
+		Commit:   commit,
+		Engine:   engine,
+		Duration: since(start),
+	}
+	if err != nil {
+		e.Result = ResultFailure
+		e.Error = err.Error()
 	}
+	return e
+}
+
+// headCommit returns the hash of the checked out commit.
+func headCommit(repo *git.Repository) string {
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	return head.Hash().String()
 }
 
 // gitPull pulls changes from the remote repository (or performs a `git reset --hard`).
-func gitPull(repo *git.Repository, params map[string]string) error {
+// Before the hard reset, the local changes are archived (see snapshotWorktree):
+// gitPull returns the path of this snapshot, if any.
+// With the repo parameter "ref", gitPull checks out the selected tag or commit (see resolveRef).
+// gitPull also returns the deployed ref: the branch, the tag or the pinned commit.
+func (cfg *Cfg) gitPull(repo *git.Repository, dir string, params map[string]string) (ref, snapshot string, err error) {
 	worktree, err := repo.Worktree()
 	if err != nil {
-		return err
+		return "", "", err
 	}
 
-	branch, found := params["branch"]
-	if !found {
-		branch = "origin/main"
+	branch := getBranch(params)
+	if !isBranchStrategy(params) {
+		return cfg.gitCheckout(repo, worktree, dir, params)
 	}
+
 	remote, _, found := strings.Cut(branch, "/")
 	if !found {
 		remote = "origin"
 	}
 
+	auth, err := gitAuth(params)
+	if err != nil {
+		return branch, "", err
+	}
+
+	var progress io.Writer // the messages of the Git server go to the build log
+	if bl := cfg.buildLogs.get(dir); bl != nil {
+		progress = bl
+	}
+
 	err = worktree.Pull(&git.PullOptions{
 		RemoteName:        remote,
 		Force:             true,
@@ -89,9 +207,9 @@ func gitPull(repo *git.Repository, params map[string]string) error {
 		ReferenceName:     "",
 		SingleBranch:      false,
 		Depth:             0,
-		Auth:              nil,
+		Auth:              auth,
 		RecurseSubmodules: 0,
-		Progress:          nil,
+		Progress:          progress,
 		InsecureSkipTLS:   false,
 		ClientCert:        nil,
 		ClientKey:         nil,
@@ -100,17 +218,64 @@ func gitPull(repo *git.Repository, params map[string]string) error {
 	})
 
 	if err == nil || errors.Is(err, git.NoErrAlreadyUpToDate) {
-		return nil
+		return branch, "", nil
+	}
+
+	snapshot, e := cfg.snapshotWorktree(repo, dir)
+	if e != nil {
+		return branch, "", fmt.Errorf("pull: %w, skip the hard reset because the snapshot of the local changes failed: %w", err, e)
+	}
+	if snapshot != "" {
+		slog.Warn("Hard reset discards the local changes, see the snapshot", "repo", dir, "snapshot", snapshot, "pull", err)
 	}
 
 	// If pulling fails, reset to origin/main
-	return worktree.Reset(&git.ResetOptions{
+	return branch, snapshot, worktree.Reset(&git.ResetOptions{
 		Mode:   git.HardReset,
 		Commit: plumbing.NewHash(branch),
 		Files:  nil,
 	})
 }
 
+// gitCheckout fetches the remote and checks out the commit selected by the repo parameter "ref"
+// (detached HEAD). The local changes are archived before being discarded.
+func (cfg *Cfg) gitCheckout(repo *git.Repository, worktree *git.Worktree, dir string, params map[string]string) (ref, snapshot string, err error) {
+	err = fetchRemote(repo, params)
+	if err != nil {
+		slog.Warn("Cannot fetch, use the local refs", "repo", dir, "err", err)
+	}
+
+	target, ref, err := resolveRef(repo, params)
+	if err != nil {
+		return ref, "", err
+	}
+
+	snapshot, err = cfg.snapshotWorktree(repo, dir)
+	if err != nil {
+		return ref, "", fmt.Errorf("skip the checkout of %s because the snapshot of the local changes failed: %w", ref, err)
+	}
+	if snapshot != "" {
+		slog.Warn("Checkout discards the local changes, see the snapshot", "repo", dir, "snapshot", snapshot, "ref", ref)
+	}
+
+	slog.Info("Checkout", "repo", dir, "ref", ref, "commit", target.String())
+	return ref, snapshot, worktree.Checkout(&git.CheckoutOptions{Hash: target, Force: true})
+}
+
+// getEngines returns the comma-separated engines trying to build the repo:
+// the repo parameter "engine", else cmd when "build-cmd" is set, else the global setting.
+func (cfg *Cfg) getEngines(params map[string]string) string {
+	engines, found := params["engine"]
+	switch {
+	case found:
+	case params["build-cmd"] != "":
+		engines = engineCmd
+	default:
+		engines = cfg.Engine
+	}
+	return engines
+}
+
 func (cfg *Cfg) getTarget(dir string) string {
 	return cfg.Repositories[dir]["target"]
 }
@@ -146,6 +311,9 @@ func (cfg *Cfg) getDockerBuildArgs(dir string) map[string]*string {
 
 	args := make(map[string]*string, len(params))
 	for k, v := range params {
+		if isSecretParam(k) {
+			continue // see getBuildSecrets
+		}
 		args[k] = &v
 	}
 	return args
@@ -0,0 +1,256 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deployment is a previous deployment kept next to the www directory,
+// named "<www>--<time>-<commit>" where time is when it has been replaced.
+type deployment struct {
+	Time   time.Time
+	Path   string
+	Commit string
+}
+
+const (
+	defaultKeep          = 1 // same as the historical "--old" directory
+	deploymentTimeLayout = "20060102T150405"
+	legacyOldSuffix      = "--old"
+)
+
+// getKeep returns the number of previous deployments kept for the repo:
+// the repo parameter "keep", else the global setting, else one.
+func (cfg *Cfg) getKeep(dir string) int {
+	keep, err := strconv.Atoi(cfg.Repositories[dir]["keep"])
+	if err == nil && keep > 0 {
+		return keep
+	}
+	if cfg.Keep > 0 {
+		return cfg.Keep
+	}
+	return defaultKeep
+}
+
+// listDeployments returns the previous deployments of www, the most recently replaced first.
+// The legacy "<www>--old" directory is the oldest one.
+func listDeployments(www string) []deployment {
+	entries, err := os.ReadDir(filepath.Dir(www))
+	if err != nil {
+		return nil
+	}
+	prefix := filepath.Base(www) + "--"
+	var deps []deployment
+	var legacy *deployment
+	for _, e := range entries {
+		suffix, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || !e.IsDir() {
+			continue
+		}
+		path := filepath.Join(filepath.Dir(www), e.Name())
+		if "--"+suffix == legacyOldSuffix {
+			legacy = &deployment{Path: path}
+			continue
+		}
+		stamp, commit, _ := strings.Cut(suffix, "-")
+		t, err := time.Parse(deploymentTimeLayout, stamp)
+		if err != nil {
+			continue // "--new", "--rollback"...
+		}
+		deps = append(deps, deployment{Path: path, Time: t, Commit: commit})
+	}
+	slices.SortFunc(deps, func(a, b deployment) int { return strings.Compare(b.Path, a.Path) })
+	if legacy != nil {
+		deps = append(deps, *legacy)
+	}
+	return deps
+}
+
+// archiveWWW renames the live www directory with the time and the commit of its deployment
+// and removes the previous deployments exceeding the retention (see getKeep).
+func (cfg *Cfg) archiveWWW(dir, www string) error {
+	if !directoryExists(www) {
+		return nil
+	}
+
+	commit := cfg.liveCommit(dir)
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	t := time.Now().UTC().Truncate(time.Second)
+	if deps := listDeployments(www); len(deps) > 0 && !t.After(deps[0].Time) {
+		t = deps[0].Time.Add(time.Second) // several deployments within the same second
+	}
+	archived := www + "--" + t.Format(deploymentTimeLayout)
+	if commit != "" {
+		archived += "-" + commit
+	}
+
+	err := os.Rename(www, archived)
+	if err != nil {
+		return err
+	}
+
+	deps := listDeployments(www)
+	for _, d := range deps[min(cfg.getKeep(dir), len(deps)):] {
+		err = os.RemoveAll(d.Path)
+		if err != nil {
+			slog.Warn("Cannot remove the previous deployment", "dir", d.Path, "err", err)
+		}
+	}
+	return nil
+}
+
+// liveCommit returns the commit of the files served in www:
+// the commit of the last deploy or successful rollback of the repo.
+// A failed deploy event (prerender, _redirects, verify-url) also installed its files,
+// they are live until the automatic rollback.
+func (cfg *Cfg) liveCommit(dir string) string {
+	if cfg.events == nil {
+		return ""
+	}
+	for _, e := range cfg.events.Last(eventsInMem) {
+		if e.Repo == dir && (e.Type == EventDeploy || (e.Type == EventRollback && e.Result == ResultOK)) {
+			return e.Commit
+		}
+	}
+	return ""
+}
+
+// rollback restores the n-th previous deployment (1 is the last replaced one),
+// the replaced files become the most recent previous deployment,
+// so a second rollback undoes the first one.
+func (cfg *Cfg) rollback(dir string, params map[string]string, n int) error {
+	start := time.Now()
+	www := params["www"]
+	restored, err := cfg.restoreDeployment(dir, www, n)
+
+	e := newEvent(dir, EventRollback, restored.Commit, "", start, err)
+	if err == nil {
+		e.Size = dirSize(www)
+	}
+	cfg.events.Add(e)
+	return err
+}
+
+func (cfg *Cfg) restoreDeployment(dir, www string, n int) (deployment, error) {
+	deps := listDeployments(www)
+	if len(deps) == 0 {
+		return deployment{}, errors.New("no previous deployment of " + filepath.Base(www))
+	}
+	if n < 1 || n > len(deps) {
+		return deployment{}, fmt.Errorf("rollback %d: only %d previous deployments of %s", n, len(deps), filepath.Base(www))
+	}
+	target := deps[n-1]
+
+	// move the target aside: archiveWWW may prune it
+	tmp := www + "--rollback"
+	err := os.RemoveAll(tmp)
+	if err != nil {
+		return target, err
+	}
+	err = os.Rename(target.Path, tmp)
+	if err != nil {
+		return target, err
+	}
+
+	err = cfg.archiveWWW(dir, www)
+	if err == nil {
+		err = os.Rename(tmp, www)
+	}
+	if err != nil {
+		_ = os.Rename(tmp, target.Path)
+		return target, err
+	}
+	slog.Info("Rollback", "www", www, "from", target.Path, "commit", target.Commit)
+	return target, nil
+}
+
+// installWWW replaces the live www by the new build (when valid, see validateWWW)
+// after its precompression (see precompressWWW), the replaced files are kept as the most recent previous deployment.
+func (cfg *Cfg) installWWW(dir, www, newWWW string) error {
+	err := validateWWW(newWWW, www)
+	if err != nil {
+		slog.Warn("Keep the current deployment", "dir", dir, "www", www, "err", err)
+		os.RemoveAll(newWWW)
+		return err
+	}
+
+	err = precompressWWW(newWWW, cfg.Repositories[dir])
+	if err != nil {
+		slog.Warn("Keep the current deployment", "dir", dir, "www", www, "err", err)
+		os.RemoveAll(newWWW)
+		return err
+	}
+
+	err = cfg.archiveWWW(dir, www)
+	if err != nil {
+		slog.Warn("archiveWWW", "dir", dir, "www", www, "err", err)
+		return fmt.Errorf("failed to archive www: %w", err)
+	}
+	err = os.Rename(newWWW, www)
+	if err != nil {
+		slog.Warn("Rename", "dir", dir, "newWWW", newWWW, "err", err)
+		return fmt.Errorf("failed to rename www: %w", err)
+	}
+	return nil
+}
+
+// validateWWW rejects a new build without any file, or without the index.html served by the live www.
+func validateWWW(newWWW, www string) error {
+	if dirSize(newWWW) == 0 {
+		return errors.New("the build produced an empty www directory")
+	}
+	if fileExists(filepath.Join(www, "index.html")) && !fileExists(filepath.Join(newWWW, "index.html")) {
+		return errors.New("the build produced a www directory without index.html")
+	}
+	return nil
+}
+
+// rollbackCommand implements "gitwww rollback <repo> [n]": restores the n-th previous deployment
+// (default 1) of the repo given by its directory or its name.
+// The rollback waits for the build of the repo running in the daemon, see lockRepo.
+func (cfg *Cfg) rollbackCommand(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: gitwww rollback <repo> [n]")
+	}
+	n := 1
+	if len(args) == 2 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return errors.New("rollback: n must be a positive integer, got " + args[1])
+		}
+	}
+
+	abs, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	cfg.events = openEventLog(cfg.getEventsPath())
+	for dir, params := range cfg.reposSeq() {
+		if dir == abs || filepath.Base(dir) == args[0] {
+			unlock := cfg.lockRepo(dir, params)
+			err = cfg.rollback(dir, params, n)
+			unlock()
+			if err != nil {
+				slog.Error("Rollback failed", "repo", dir, "err", err)
+			}
+			return err
+		}
+	}
+	err = errors.New("rollback: unknown repo " + args[0])
+	slog.Error("Rollback failed", "err", err)
+	return err
+}
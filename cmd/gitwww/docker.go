@@ -10,8 +10,10 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/exec"
 	"path/filepath"
 
 	"github.com/docker/docker/api/types/build"
@@ -26,6 +28,11 @@ import (
 func (cfg *Cfg) buildDockerImage(ctx context.Context, dir string) error {
 	imageName := cfg.getTag(dir)
 
+	secrets, err := getBuildSecrets(cfg.Repositories[dir])
+	if err != nil {
+		return err
+	}
+
 	// Configure build options
 	options := build.ImageBuildOptions{
 		Dockerfile:  cfg.findContainerfile(dir),
@@ -36,7 +43,10 @@ func (cfg *Cfg) buildDockerImage(ctx context.Context, dir string) error {
 		Target:      cfg.getTarget(dir), // Target specifies the build stage to target
 		BuildArgs:   cfg.getDockerBuildArgs(dir),
 	}
-	slog.Debug("buildDockerImage", "dir", dir, "options", omitZeroEmpty(options))
+	cfg.setBuildCache(dir, &options)
+	logged := options
+	logged.BuildArgs = redactBuildArgs(options.BuildArgs)
+	slog.Debug("buildDockerImage", "dir", dir, "options", omitZeroEmpty(logged))
 
 	// create client that reads DOCKER_HOST, DOCKER_TLS_VERIFY...
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
@@ -62,23 +72,19 @@ func (cfg *Cfg) buildDockerImage(ctx context.Context, dir string) error {
 	defer buildCtx.Close()
 
 	// Execute the build
-	resp, err := cli.ImageBuild(ctx, buildCtx, options)
-	if err != nil {
-		slog.Warn("buildDockerImage ImageBuild", "dir", dir, "err", err)
-		return fmt.Errorf("build failed: %w", err)
+	if len(secrets) > 0 {
+		err = cfg.buildDockerCLI(ctx, dir, buildCtx, secrets)
+	} else {
+		err = cfg.imageBuild(ctx, cli, dir, buildCtx, options)
 	}
-	defer resp.Body.Close()
-
-	// Use the official Docker function to decode and display the stream
-	termFd, isTerm := term.GetFdInfo(os.Stderr)
-	err = jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stderr, termFd, isTerm, decodeAux)
 	if err != nil {
-		slog.Warn("buildDockerImage", "dir", dir, "err", err)
 		return err
 	}
 
 	slog.Info("✅ buildDockerImage OK", "dir", dir)
 
+	cfg.pruneBuildCache(ctx, cli, dir)
+
 	// Create a temporary container from the image
 	containerResp, err := cli.ContainerCreate(ctx, &container.Config{Image: imageName}, nil, nil, nil, "")
 	if err != nil {
@@ -100,7 +106,6 @@ func (cfg *Cfg) buildDockerImage(ctx context.Context, dir string) error {
 	defer reader.Close()
 
 	www := cfg.getAbsWWW(dir)
-	oldWWW := www + "--old"
 	newWWW := www + "--new"
 
 	// Use go-archive Untar function
@@ -111,44 +116,99 @@ func (cfg *Cfg) buildDockerImage(ctx context.Context, dir string) error {
 		return fmt.Errorf("failed to extract files: %w", err)
 	}
 
-	os.RemoveAll(oldWWW)
-	os.Rename(www, oldWWW)
-	os.RemoveAll(www)
-	err = os.Rename(newWWW, www)
+	return cfg.installWWW(dir, www, newWWW)
+}
+
+// imageBuild builds the image with the Docker API and displays the build output.
+func (cfg *Cfg) imageBuild(ctx context.Context, cli *client.Client, dir string, buildCtx io.Reader, options build.ImageBuildOptions) error {
+	resp, err := cli.ImageBuild(ctx, buildCtx, options)
 	if err != nil {
-		slog.Warn("buildDockerImage Rename", "dir", dir, "newWWW", newWWW, "err", err)
-		return fmt.Errorf("failed to rename www: %w", err)
+		slog.Warn("buildDockerImage ImageBuild", "dir", dir, "err", err)
+		return fmt.Errorf("build failed: %w", err)
 	}
+	defer resp.Body.Close()
 
+	// Use the official Docker function to decode and display the stream
+	termFd, isTerm := term.GetFdInfo(os.Stderr)
+	out := cfg.buildOutput(dir)
+	defer flushOutput(out)
+	if out != os.Stderr {
+		isTerm = false // no terminal escape sequences in the dashboard logs and the build log
+	}
+	err = jsonmessage.DisplayJSONMessagesStream(resp.Body, out, termFd, isTerm, decodeAux)
+	if err != nil {
+		slog.Warn("buildDockerImage", "dir", dir, "err", err)
+		return err
+	}
 	return nil
 }
 
-// newTarOptionsFromDockerignore opens and reads a .dockerignore file from the specified path
+// buildDockerCLI builds the image with "docker build" (BuildKit) passing the build secrets
+// (see getBuildSecrets), the Docker API would require a BuildKit session.
+// The build context is the same tar archive as imageBuild, read from stdin.
+func (cfg *Cfg) buildDockerCLI(ctx context.Context, dir string, buildCtx io.Reader, secrets []buildSecret) error {
+	args := cfg.cliBuildFlags("docker", dir, secrets)
+	args = append(args, "--file", cfg.findContainerfile(dir), "--progress", "plain", "-")
+	slog.Debug("buildDockerCLI", "dir", dir, "args", redactCLIArgs(args))
+
+	out := cfg.buildOutput(dir)
+	defer flushOutput(out)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	cmd.Env = append(os.Environ(), "DOCKER_BUILDKIT=1")
+	cmd.Stdin = buildCtx
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err := cmd.Run()
+	if err != nil {
+		slog.Warn("buildDockerCLI", "dir", dir, "err", err)
+		return fmt.Errorf("build failed: %w", err)
+	}
+	return nil
+}
+
+// defaultIgnorePatterns exclude some common files from the build context
+// when the repo has neither .containerignore nor .dockerignore.
+var defaultIgnorePatterns = []string{
+	".astro/", ".editorconfig", ".env*", ".git", ".gitignore", ".gitwww",
+	".idea/", ".next", ".vscode/", "coverage*", "LICENSE", "Makefile",
+	"node_modules/", "npm-debug.log", "README.md",
+}
+
+// findIgnorefile returns the .containerignore file (preferred, as Podman and Buildah do)
+// or the .dockerignore file of the build context, empty when none exists.
+func findIgnorefile(dir string) string {
+	for _, name := range []string{".containerignore", ".dockerignore"} {
+		file := filepath.Join(dir, name)
+		if fileExists(file) {
+			return file
+		}
+	}
+	return ""
+}
+
+// newTarOptionsFromDockerignore opens and reads a .containerignore or .dockerignore file from the specified path
 // and returns an archive.TarOptions object with the parsed exclusion and inclusion patterns.
-// If the .dockerignore file does not exist, it returns an TarOptions excluding some common ignored files.
+// If the ignore file does not exist, it returns an TarOptions excluding some common ignored files.
 func newTarOptionsFromDockerignore(dir string) (*archive.TarOptions, error) {
 	// Default tar options
 	tarOptions := &archive.TarOptions{
-		ExcludePatterns: []string{
-			".astro/", ".editorconfig", ".env*", ".git", ".gitignore",
-			".idea/", ".next", ".vscode/", "coverage*", "LICENSE", "Makefile",
-			"node_modules/", "npm-debug.log", "README.md",
-		},
-		IncludeFiles: nil,
+		ExcludePatterns: defaultIgnorePatterns,
+		IncludeFiles:    nil,
 		// IncludeFiles is not used because the patternmatcher library,
 		// used internally by go-archive, will handle the distinction
 		// between exclusion and inclusion based on the '!' prefix within these patterns.
 		// (e.g. IncludeFiles is replaced by '!' within the ExcludePatterns entries)
 	}
 
-	// Attempt to open the .dockerignore file
-	file, err := os.Open(filepath.Join(dir, ".dockerignore"))
-	if err != nil {
-		// If the file does not exist, return an empty TarOptions with no error.
-		// This is typical behavior for .dockerignore files, where their absence
-		// means no specific exclusions/inclusions are applied.
+	// Attempt to open the .containerignore or .dockerignore file
+	name := findIgnorefile(dir)
+	if name == "" {
 		return tarOptions, nil
 	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
 	defer file.Close()
 
 	// Use ignorefile.ReadAll to parse the .dockerignore content into a slice of patterns.
@@ -156,7 +216,7 @@ func newTarOptionsFromDockerignore(dir string) (*archive.TarOptions, error) {
 	// as specified by the .dockerignore syntax.
 	tarOptions.ExcludePatterns, err = ignorefile.ReadAll(file)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read .dockerignore patterns from %s: %w", dir, err)
+		return nil, fmt.Errorf("failed to read the ignore patterns from %s: %w", name, err)
 	}
 
 	return tarOptions, nil
@@ -0,0 +1,226 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// Event types.
+const (
+	EventPull     = "pull"
+	EventBuild    = "build"
+	EventDeploy   = "deploy"
+	EventRollback = "rollback"
+)
+
+// Event results.
+const (
+	ResultOK      = "ok"
+	ResultFailure = "failure"
+)
+
+const (
+	eventsName    = "events.jsonl"
+	eventsMaxSize = 10 << 20 // rotate the JSONL file above 10 MiB
+	eventsInMem   = 1000     // number of events kept for the status endpoint
+)
+
+// Event is one line of the JSONL event log.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Repo     string    `json:"repo"`
+	Type     string    `json:"type"`
+	Result   string    `json:"result"`
+	Commit   string    `json:"commit,omitempty"`
+	Ref      string    `json:"ref,omitempty"` // deployed branch, tag or pinned commit (see the repo parameter "ref")
+	Engine   string    `json:"engine,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration int64     `json:"duration_ms"`
+	Size     int64     `json:"size,omitempty"`     // artifact size in bytes (deploy)
+	Snapshot string    `json:"snapshot,omitempty"` // archive of the local changes discarded by the hard reset (pull)
+	Log      string    `json:"log,omitempty"`      // build log of the pull/build/deploy (see openBuildLog)
+}
+
+// EventLog appends the events to a JSONL file and keeps the last ones in memory.
+// When the file exceeds eventsMaxSize, it is renamed with the ".1" suffix
+// (replacing the previous one) and a new file is started.
+type EventLog struct {
+	timings map[string]*gg.ExpHistogram // durations per event type
+	path    string
+	recent  []Event
+	mu      sync.Mutex
+}
+
+// Timing summarizes the durations of an event type (in milliseconds).
+type Timing struct {
+	Type  string  `json:"type"`
+	Count uint64  `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// getEventsPath returns the JSONL file, by default next to the configuration file.
+func (cfg *Cfg) getEventsPath() string {
+	if cfg.Events != "" {
+		return cfg.Events
+	}
+	return filepath.Join(filepath.Dir(cfg.Path), eventsName)
+}
+
+// openEventLog loads the last events from the file (if any).
+func openEventLog(path string) *EventLog {
+	el := &EventLog{path: path, timings: make(map[string]*gg.ExpHistogram)}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return el
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			el.keep(e)
+		}
+	}
+	return el
+}
+
+// Add timestamps the event, appends it to the file and logs it.
+func (el *EventLog) Add(e Event) {
+	if el == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	level := slog.LevelInfo
+	if e.Result != ResultOK {
+		level = slog.LevelWarn
+	}
+	slog.Log(context.Background(), level, "Event", "type", e.Type, "repo", e.Repo, "result", e.Result,
+		"commit", e.Commit, "ms", e.Duration, "size", e.Size, "snapshot", e.Snapshot, "err", e.Error)
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Warn("Event json.Marshal", "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	el.keep(e)
+	el.rotate()
+
+	f, err := os.OpenFile(el.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		slog.Warn("Cannot open event log", "file", el.path, "err", err)
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(line)
+	if err != nil {
+		slog.Warn("Cannot write event log", "file", el.path, "err", err)
+	}
+}
+
+// Last returns the last n events, the most recent first.
+func (el *EventLog) Last(n int) []Event {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	n = min(max(n, 0), len(el.recent))
+	last := make([]Event, n)
+	for i := range n {
+		last[i] = el.recent[len(el.recent)-1-i]
+	}
+	return last
+}
+
+// Timings returns the duration quantiles of each event type,
+// including the events loaded from the file at startup.
+func (el *EventLog) Timings() []Timing {
+	el.mu.Lock()
+	defer el.mu.Unlock()
+
+	timings := []Timing{}
+	for _, typ := range slices.Sorted(maps.Keys(el.timings)) {
+		s := el.timings[typ].Snapshot()
+		timings = append(timings, Timing{
+			Type:  typ,
+			Count: s.Count,
+			P50:   s.Quantile(0.5),
+			P90:   s.Quantile(0.9),
+			P99:   s.Quantile(0.99),
+			Max:   s.Max,
+		})
+	}
+	return timings
+}
+
+func (el *EventLog) keep(e Event) {
+	h := el.timings[e.Type]
+	if h == nil {
+		h = gg.NewExpHistogram(0)
+		el.timings[e.Type] = h
+	}
+	h.Record(float64(e.Duration))
+
+	if len(el.recent) >= eventsInMem {
+		el.recent = append(el.recent[:0], el.recent[len(el.recent)-eventsInMem+1:]...)
+	}
+	el.recent = append(el.recent, e)
+}
+
+func (el *EventLog) rotate() {
+	fi, err := os.Stat(el.path)
+	if err != nil || fi.Size() < eventsMaxSize {
+		return
+	}
+	err = os.Rename(el.path, el.path+".1")
+	if err != nil && !os.IsNotExist(err) {
+		slog.Warn("Cannot rotate event log", "file", el.path, "err", err)
+	}
+}
+
+// since returns the duration in milliseconds.
+func since(start time.Time) int64 {
+	return time.Since(start).Milliseconds()
+}
+
+// dirSize returns the total size of the regular files within dir.
+func dirSize(dir string) int64 {
+	var size int64
+	_ = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil //nolint:nilerr // ignore unreadable files
+		}
+		info, err := d.Info()
+		if err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,12 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+//go:build !unix
+
+package main
+
+// lockFile does nothing: only the operations within the daemon are serialized.
+func lockFile(string) (func(), error) {
+	return func() {}, nil
+}
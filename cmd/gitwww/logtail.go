@@ -0,0 +1,85 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logTail keeps the last log lines (slog records and build output) for the dashboard.
+type logTail struct {
+	lines   []string
+	partial []byte
+	max     int
+	mu      sync.Mutex
+}
+
+// Write implements io.Writer: the build output is split into lines.
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.add(string(bytes.TrimRight(t.partial[:i], "\r")))
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// Last returns the last n lines, the oldest first.
+func (t *logTail) Last(n int) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n = min(max(n, 0), len(t.lines))
+	return append([]string{}, t.lines[len(t.lines)-n:]...)
+}
+
+func (t *logTail) add(line string) {
+	if len(t.lines) >= t.max {
+		t.lines = append(t.lines[:0], t.lines[len(t.lines)-t.max+1:]...)
+	}
+	t.lines = append(t.lines, line)
+}
+
+// tailHandler copies the slog records into the logTail.
+type tailHandler struct {
+	slog.Handler
+	tail *logTail
+}
+
+func (h *tailHandler) Handle(ctx context.Context, r slog.Record) error {
+	var sb strings.Builder
+	sb.WriteString(r.Time.Format(time.TimeOnly))
+	sb.WriteString(" " + r.Level.String() + " " + r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		sb.WriteString(" " + a.String())
+		return true
+	})
+
+	h.tail.mu.Lock()
+	h.tail.add(sb.String())
+	h.tail.mu.Unlock()
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *tailHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &tailHandler{Handler: h.Handler.WithAttrs(attrs), tail: h.tail}
+}
+
+func (h *tailHandler) WithGroup(name string) slog.Handler {
+	return &tailHandler{Handler: h.Handler.WithGroup(name), tail: h.tail}
+}
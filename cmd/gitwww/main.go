@@ -8,9 +8,9 @@ import (
 	"context"
 	"iter"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
-	"time"
 )
 
 func main() {
@@ -22,21 +22,26 @@ func main() {
 		os.Exit(0)
 	}
 
+	cfg.events = openEventLog(cfg.getEventsPath())
+	cfg.locks = &repoLocks{}
+	cfg.repoList = &repoList{}
+	cfg.buildLogs = &buildLogs{logs: make(map[string]*buildLog)}
+	cfg.notifier = cfg.newDeployNotifier()
+	cfg.serveStatus()
+	cfg.serveDashboard()
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	for {
-		for dir, params := range cfg.reposSeq() {
-			repo := cfg.shouldDeploy(dir, params)
-			if repo != nil {
-				cfg.buildDeploy(ctx, repo, dir, params)
-			}
-		}
-
-		time.Sleep(time.Duration(cfg.Sleep) * time.Second)
+		cfg.deployAll(ctx)
+		cfg.waitJobs(ctx)
 	}
 }
 
+// reposSeq yields the buildable repos and a copy of their parameters completed with
+// containerfile, www and tag. It stats the repos and clones the missing ones,
+// the HTTP handlers use the list of the last cycle instead (see knownRepos).
 func (cfg *Cfg) reposSeq() iter.Seq2[string, map[string]string] {
 	return func(yield func(string, map[string]string) bool) {
 		if !filepath.IsAbs(cfg.Repos) || !filepath.IsAbs(cfg.WWW) {
@@ -56,11 +61,12 @@ func (cfg *Cfg) reposSeq() iter.Seq2[string, map[string]string] {
 
 		for dir, repo := range cfg.absRepositories() {
 			file := cfg.findContainerfile(repo)
-			if file == "" {
+			if file == "" && cfg.Repositories[repo]["build-cmd"] == "" {
 				continue
 			}
 
-			params := cfg.Repositories[repo]
+			// a copy: the builds read the configuration maps concurrently
+			params := maps.Clone(cfg.Repositories[repo])
 			if params == nil {
 				params = make(map[string]string, 3)
 			}
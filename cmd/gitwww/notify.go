@@ -0,0 +1,103 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// deployNotifier sends the deployment results, each repo has its own Muter
+// so a flapping repo is muted without muting the other repos.
+type deployNotifier struct {
+	next  gg.Notifier
+	muted map[string]*gc.MutedNotifier
+	mu    sync.Mutex
+}
+
+const (
+	notifyBurst  = 3         // notifications per repo and notifyWindow
+	notifyWindow = time.Hour // the next ones are muted, then summarized
+)
+
+// newDeployNotifier returns nil when no notifier is configured.
+// The environment variable GITWWW_NOTIFY takes precedence on the configuration file.
+func (cfg *Cfg) newDeployNotifier() *deployNotifier {
+	dsn := os.Getenv(GITWWW_NOTIFY)
+	if dsn == "" {
+		dsn = cfg.Notify
+	}
+	if dsn == "" {
+		return nil
+	}
+	return &deployNotifier{next: gg.NewNotifier(dsn), muted: make(map[string]*gc.MutedNotifier)}
+}
+
+// notifyDeploy sends the result of buildDeploy: repo, branch, commit message, duration and error.
+func (cfg *Cfg) notifyDeploy(repo *git.Repository, dir string, params map[string]string, start time.Time, err error) {
+	dn := cfg.notifier
+	if dn == nil {
+		return
+	}
+
+	name := filepath.Base(dir)
+	msg := gg.Message{Title: name + " deployed", Severity: gg.SeverityInfo}
+	if err != nil {
+		msg.Title = name + " deploy failed"
+		msg.Severity = gg.SeverityError
+	}
+
+	msg.Fields = append(msg.Fields,
+		gg.Field{Name: "repo", Value: dir},
+		gg.Field{Name: "branch", Value: getBranch(params)},
+	)
+	if commit := commitSummary(repo); commit != "" {
+		msg.Fields = append(msg.Fields, gg.Field{Name: "commit", Value: commit})
+	}
+	msg.Fields = append(msg.Fields, gg.Field{Name: "duration", Value: time.Since(start).Round(time.Second).String()})
+	if err != nil {
+		msg.Text = err.Error()
+	}
+
+	e := dn.muter(dir).NotifyMessage(msg)
+	if e != nil {
+		slog.Warn("Cannot notify", "repo", dir, "err", e)
+	}
+}
+
+func (dn *deployNotifier) muter(dir string) *gc.MutedNotifier {
+	dn.mu.Lock()
+	defer dn.mu.Unlock()
+	mn := dn.muted[dir]
+	if mn == nil {
+		mn = gc.NewMutedNotifier(dn.next, &gc.Muter{Threshold: notifyBurst, Window: notifyWindow})
+		dn.muted[dir] = mn
+	}
+	return mn
+}
+
+// commitSummary returns the short hash and the first line of the message of the checked out commit.
+func commitSummary(repo *git.Repository) string {
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	hash := head.Hash().String()[:7]
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return hash
+	}
+	subject, _, _ := strings.Cut(strings.TrimSpace(commit.Message), "\n")
+	return hash + " " + subject
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// repoLocks serializes the operations (check, build, rollback) on the same repo:
+// a repo is never built twice concurrently, the second build waits for the first one.
+type repoLocks struct {
+	locks map[string]*sync.Mutex
+	mu    sync.Mutex
+}
+
+// repoTask is a repo to check (and build) during a cycle.
+type repoTask struct {
+	params map[string]string
+	dir    string
+}
+
+// repoList is the list of the repos of the last cycle, read by the HTTP handlers.
+type repoList struct {
+	tasks []repoTask
+	mu    sync.RWMutex
+}
+
+// cycleStats aggregates the build durations (in milliseconds) of a cycle.
+type cycleStats struct {
+	builds  *gg.ExpHistogram
+	checked int
+	built   int
+	failed  int
+	mu      sync.Mutex
+}
+
+// lock locks the repo and returns the unlock function.
+func (rl *repoLocks) lock(dir string) func() {
+	rl.mu.Lock()
+	if rl.locks == nil {
+		rl.locks = make(map[string]*sync.Mutex)
+	}
+	m := rl.locks[dir]
+	if m == nil {
+		m = &sync.Mutex{}
+		rl.locks[dir] = m
+	}
+	rl.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock
+}
+
+// lockRepo locks the repo for the operations modifying its www (check, build, rollback):
+// repoLocks within the process, and the file "<www>.lock" across the processes
+// because "gitwww rollback" runs beside the daemon. It returns the unlock function.
+func (cfg *Cfg) lockRepo(dir string, params map[string]string) func() {
+	unlock := func() {}
+	if cfg.locks != nil {
+		unlock = cfg.locks.lock(dir)
+	}
+	unlockFile, err := lockFile(params["www"] + ".lock")
+	if err != nil {
+		slog.Warn("Cannot lock the www of the repo", "dir", dir, "www", params["www"], "err", err)
+		return unlock
+	}
+	return func() {
+		unlockFile()
+		unlock()
+	}
+}
+
+// getParallel returns the number of repos built concurrently (default 1).
+func (cfg *Cfg) getParallel() int {
+	return max(cfg.Parallel, 1)
+}
+
+// deployAll checks the repos and builds those having new commits,
+// up to cfg.Parallel repos at the same time.
+func (cfg *Cfg) deployAll(ctx context.Context) {
+	var tasks []repoTask
+	for dir, params := range cfg.reposSeq() {
+		tasks = append(tasks, repoTask{dir: dir, params: params})
+	}
+	cfg.repoList.set(tasks)
+
+	start := time.Now()
+	stats := cycleStats{builds: gg.NewExpHistogram(0), checked: len(tasks)}
+	pool := gg.NewPool(cfg.getParallel(), func(ctx context.Context, t repoTask) error {
+		unlock := cfg.lockRepo(t.dir, t.params)
+		defer unlock()
+
+		repo := cfg.shouldDeploy(t.dir, t.params)
+		if repo == nil {
+			return nil
+		}
+		buildStart := time.Now()
+		err := cfg.buildDeploy(ctx, repo, t.dir, t.params)
+		stats.add(time.Since(buildStart), err)
+		return err
+	})
+	_ = pool.Run(ctx, tasks) // the failures are already logged and recorded in the events
+
+	stats.log(time.Since(start), cfg.getParallel())
+}
+
+func (rl *repoList) set(tasks []repoTask) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.tasks = tasks
+}
+
+// knownRepos returns the repos of the last cycle, the parameters must not be modified.
+// The list is empty before the end of the first reposSeq.
+func (cfg *Cfg) knownRepos() []repoTask {
+	cfg.repoList.mu.RLock()
+	defer cfg.repoList.mu.RUnlock()
+	return cfg.repoList.tasks
+}
+
+func (s *cycleStats) add(d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.builds.Record(float64(d.Milliseconds()))
+	s.built++
+	if err != nil {
+		s.failed++
+	}
+}
+
+// log reports the cycle only when something has been built.
+func (s *cycleStats) log(elapsed time.Duration, parallel int) {
+	if s.built == 0 {
+		slog.Debug("Cycle", "repos", s.checked, "ms", elapsed.Milliseconds())
+		return
+	}
+	snap := s.builds.Snapshot()
+	slog.Info("Cycle",
+		"repos", s.checked,
+		"builds", s.built,
+		"failed", s.failed,
+		"parallel", parallel,
+		"ms", elapsed.Milliseconds(),
+		"build_sum_ms", int64(snap.Sum),
+		"build_p50_ms", int64(snap.Quantile(0.5)),
+		"build_max_ms", int64(snap.Max),
+	)
+}
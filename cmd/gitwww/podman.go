@@ -0,0 +1,142 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// buildPodmanImage builds the image with the podman command (Buildah under the hood, rootless
+// friendly, CONTAINER_HOST selects a remote Podman), then copies the dist-path of a temporary
+// container into www like buildDockerImage does.
+func (cfg *Cfg) buildPodmanImage(ctx context.Context, dir string) error {
+	imageName := cfg.getTag(dir)
+
+	secrets, err := getBuildSecrets(cfg.Repositories[dir])
+	if err != nil {
+		return err
+	}
+
+	file := cfg.findContainerfile(dir)
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+
+	args := cfg.cliBuildFlags("podman", dir, secrets)
+	args = append(args, "--file", file)
+
+	// same build context as the Docker path: without ignore file, exclude the common files
+	if findIgnorefile(dir) == "" {
+		ignore, err := os.CreateTemp("", "gitwww-*.containerignore")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(ignore.Name())
+		_, err = ignore.WriteString(strings.Join(defaultIgnorePatterns, "\n") + "\n")
+		err = errors.Join(err, ignore.Close())
+		if err != nil {
+			return err
+		}
+		args = append(args, "--ignorefile", ignore.Name())
+	}
+	args = append(args, dir)
+
+	slog.Debug("buildPodmanImage", "dir", dir, "args", redactCLIArgs(args))
+	err = cfg.podman(ctx, dir, nil, args...)
+	if err != nil {
+		slog.Warn("buildPodmanImage build", "dir", dir, "err", err)
+		return fmt.Errorf("build failed: %w", err)
+	}
+	slog.Info("✅ buildPodmanImage OK", "dir", dir)
+
+	// Create a temporary container from the image
+	var id bytes.Buffer
+	err = cfg.podman(ctx, dir, &id, "create", imageName)
+	if err != nil {
+		slog.Warn("buildPodmanImage create", "dir", dir, "err", err)
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+	containerID := strings.TrimSpace(id.String())
+	defer func() {
+		// Ensure container is removed after operation
+		_ = cfg.podman(context.WithoutCancel(ctx), dir, io.Discard, "rm", "--force", containerID)
+	}()
+
+	// Copy files from container to host, with the same layout as
+	// the archive of the Docker path: www/<base of dist-path>/...
+	www := cfg.getAbsWWW(dir)
+	newWWW := www + "--new"
+	os.RemoveAll(newWWW)
+	err = os.MkdirAll(newWWW, 0o755)
+	if err != nil {
+		return err
+	}
+	err = cfg.podman(ctx, dir, nil, "cp", containerID+":"+cfg.getDistPath(dir), newWWW)
+	if err != nil {
+		slog.Warn("buildPodmanImage cp", "dir", dir, "www", www, "err", err)
+		os.RemoveAll(newWWW)
+		return fmt.Errorf("failed to copy from container: %w", err)
+	}
+
+	return cfg.installWWW(dir, www, newWWW)
+}
+
+// cliBuildFlags returns the options of "podman build" and "docker build" (BuildKit),
+// without the Containerfile and the build context.
+func (cfg *Cfg) cliBuildFlags(engine, dir string, secrets []buildSecret) []string {
+	args := []string{"build", "--tag", cfg.getTag(dir)}
+	if target := cfg.getTarget(dir); target != "" {
+		args = append(args, "--target", target)
+	}
+	if cfg.getNoCache(dir) {
+		args = append(args, "--no-cache")
+	}
+	if engine == "podman" { // BuildKit does not create intermediate containers
+		args = append(args, "--rm="+strconv.FormatBool(cfg.getRemove(dir)))
+		if cfg.getForceRemove(dir) {
+			args = append(args, "--force-rm")
+		}
+	}
+	buildArgs := cfg.getDockerBuildArgs(dir)
+	if cfg.getCache(dir) {
+		id := cfg.getCacheID(dir)
+		if buildArgs == nil {
+			buildArgs = make(map[string]*string, 1)
+		}
+		buildArgs[cacheIDArg] = &id
+	}
+	for _, k := range slices.Sorted(maps.Keys(buildArgs)) {
+		args = append(args, "--build-arg", k+"="+*buildArgs[k])
+	}
+	for _, s := range secrets {
+		args = append(args, "--secret", s.flag())
+	}
+	return args
+}
+
+// podman runs the podman command, the output goes to stdout (nil means the build output of dir).
+func (cfg *Cfg) podman(ctx context.Context, dir string, stdout io.Writer, args ...string) error {
+	logs := cfg.buildOutput(dir)
+	defer flushOutput(logs)
+	if stdout == nil {
+		stdout = logs
+	}
+	cmd := exec.CommandContext(ctx, "podman", args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = logs
+	return cmd.Run()
+}
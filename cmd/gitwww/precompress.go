@@ -0,0 +1,167 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/gzip"
+
+	"github.com/lynxai-team/garcon/hh"
+)
+
+// The repo parameter "precompress" creates the *.br and *.gz siblings of the text files
+// of the new build. The StaticWebServer serves the *.br siblings to the browsers accepting them,
+// the *.gz siblings are only useful behind a reverse proxy serving them (e.g. Nginx gzip_static):
+//
+//	precompress = "br"            # or "true" (same as "br"), "gz", "br,gz"
+//	precompress-min = "1024"      # smaller files are not compressed (bytes, default 1 KiB)
+//	precompress-level = "9"       # default: the best compression of each format
+//
+// The siblings already produced by the build are kept, and a sibling
+// not smaller than its original file is removed.
+const defaultPrecompressMin = 1024
+
+// precompressExt lists the file extensions worth compressing.
+var precompressExt = map[string]bool{
+	".html": true, ".htm": true, ".css": true, ".js": true, ".mjs": true, ".svg": true,
+	".json": true, ".xml": true, ".txt": true, ".map": true, ".wasm": true,
+}
+
+// precompressWWW compresses the eligible files of newWWW according to the repo parameters.
+func precompressWWW(newWWW string, params map[string]string) error {
+	encodings, err := precompressEncodings(params["precompress"])
+	if err != nil || len(encodings) == 0 {
+		return err
+	}
+
+	minSize := int64(defaultPrecompressMin)
+	if txt := params["precompress-min"]; txt != "" {
+		minSize, err = strconv.ParseInt(txt, 10, 64)
+		if err != nil {
+			return fmt.Errorf("precompress-min: %w", err)
+		}
+	}
+
+	level := -1 // best compression
+	if txt := params["precompress-level"]; txt != "" {
+		level, err = strconv.Atoi(txt)
+		if err != nil {
+			return fmt.Errorf("precompress-level: %w", err)
+		}
+	}
+
+	start := time.Now()
+	var files, before, after int64
+	err = filepath.WalkDir(newWWW, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || !precompressExt[strings.ToLower(filepath.Ext(path))] {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Size() < minSize {
+			return nil
+		}
+		files++
+		for _, ext := range encodings {
+			if fileExists(path + ext) {
+				continue
+			}
+			size, err := compressFile(path, ext, encodingLevel(ext, level), info)
+			if err != nil {
+				return err
+			}
+			before += info.Size()
+			after += size
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("precompress: %w", err)
+	}
+	slog.Info("Precompress", "www", newWWW, "files", files, "encodings", encodings,
+		"before", before, "after", after, "ms", since(start))
+	return nil
+}
+
+// precompressEncodings parses the "precompress" parameter into file extensions.
+func precompressEncodings(txt string) ([]string, error) {
+	switch strings.ToLower(strings.TrimSpace(txt)) {
+	case "", "false", "no", "0":
+		return nil, nil
+	case "true", "yes", "1":
+		return []string{hh.BrotliExt}, nil // the only encoding negotiated by the StaticWebServer
+	}
+	var encodings []string
+	for name := range strings.FieldsFuncSeq(txt, func(r rune) bool { return r == ',' || r == ' ' }) {
+		switch strings.ToLower(name) {
+		case "br", "brotli":
+			encodings = append(encodings, hh.BrotliExt)
+		case "gz", "gzip":
+			encodings = append(encodings, hh.GZipExt)
+		default:
+			return nil, errors.New("precompress: unexpected encoding " + name + ", want br and/or gz")
+		}
+	}
+	return encodings, nil
+}
+
+// encodingLevel returns the best compression of the format when level is negative.
+func encodingLevel(ext string, level int) int {
+	if level >= 0 {
+		return level // hh clamps the level within the range of the format
+	}
+	if ext == hh.GZipExt {
+		return gzip.BestCompression
+	}
+	return brotli.BestCompression
+}
+
+// compressFile writes the sibling path+ext with the modification time of the original file,
+// and removes it when the compression does not save any byte. It returns the size of the sibling
+// (the original size when removed).
+func compressFile(path, ext string, level int, info fs.FileInfo) (int64, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+ext, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return 0, err
+	}
+	w, err := hh.Compressor(out, ext, level)
+	if err == nil {
+		_, err = io.Copy(w, in)
+		err = errors.Join(err, w.Close())
+	}
+	err = errors.Join(err, out.Close())
+	if err != nil {
+		os.Remove(path + ext)
+		return 0, err
+	}
+
+	compressed, err := os.Stat(path + ext)
+	if err != nil {
+		return 0, err
+	}
+	if compressed.Size() >= info.Size() {
+		return info.Size(), os.Remove(path + ext)
+	}
+	return compressed.Size(), os.Chtimes(path+ext, info.ModTime(), info.ModTime())
+}
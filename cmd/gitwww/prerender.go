@@ -0,0 +1,78 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+// getPrerender returns the routes of the repo parameter prerender (separated by commas).
+func getPrerender(params map[string]string) []string {
+	var routes []string
+	for route := range strings.SplitSeq(params["prerender"], ",") {
+		route = strings.TrimSpace(route)
+		if route != "" {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// prerender writes the routes of the repo parameter prerender into the www directory,
+// so the deep links of a single page application are served as static files
+// (with their Brotli siblings) without any fallback in the web server.
+func prerender(ctx context.Context, params map[string]string) error {
+	routes := getPrerender(params)
+	if len(routes) == 0 {
+		return nil
+	}
+	www := params["www"]
+	return gc.Prerender(ctx, spaHandler(www), routes, www)
+}
+
+// spaHandler serves the files of the www directory
+// and the index.html shell for the other routes.
+func spaHandler(www string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file := filepath.Join(www, filepath.FromSlash(filepath.Clean("/"+r.URL.Path)))
+		if info, err := os.Stat(file); err == nil && info.IsDir() {
+			file = filepath.Join(file, "index.html")
+		}
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			data, err = os.ReadFile(filepath.Join(www, "index.html"))
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	})
+}
+
+// prerenderAll prerenders the routes of all the repositories (flag -prerender).
+func (cfg *Cfg) prerenderAll(ctx context.Context) error {
+	var errs []error
+	for dir, params := range cfg.reposSeq() {
+		if len(getPrerender(params)) == 0 {
+			continue
+		}
+		err := prerender(ctx, params)
+		if err != nil {
+			slog.Error("Prerender", "repo", dir, "err", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
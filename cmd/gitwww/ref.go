@@ -0,0 +1,322 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// The repo parameter "ref" selects the deployed commit (default: the head of the branch):
+//
+//	ref = "semver:^1.4"       # the highest tag matching the semver constraint
+//	ref = "latest-tag"        # the highest semver tag reachable from the branch
+//	ref = "commit:3f2a9c1e"   # a pinned commit
+//
+// The semver constraints are comparators separated by spaces or commas (all must match):
+// "=1.2.3", ">=1.2", "<2", "^1.4" (>=1.4.0 <2.0.0), "^0.4" (>=0.4.0 <0.5.0),
+// "~1.4.2" (>=1.4.2 <1.5.0), "1.4.x", "*".
+// The pre-release tags (v2.0.0-rc1) are selected only when the constraint mentions a pre-release.
+const (
+	refSemver    = "semver:"
+	refCommit    = "commit:"
+	refLatestTag = "latest-tag"
+)
+
+type (
+	// semVersion is a parsed tag such as "v1.4.2" or "1.5.0-rc1".
+	semVersion struct {
+		pre                 string
+		major, minor, patch int
+	}
+
+	// semverConstraint is a conjunction of comparators.
+	semverConstraint struct {
+		match []func(semVersion) bool
+		pre   bool // accept the pre-release versions
+	}
+)
+
+// getBranch returns the remote branch of the repo parameter "branch" (default origin/main).
+func getBranch(params map[string]string) string {
+	branch, found := params["branch"]
+	if !found {
+		branch = "origin/main"
+	}
+	return branch
+}
+
+// isBranchStrategy is true when the deployed commit is the head of the branch (no "ref" parameter).
+func isBranchStrategy(params map[string]string) bool {
+	return params["ref"] == ""
+}
+
+// resolveRef returns the commit to deploy and its description recorded in the events:
+// the branch, the selected tag or the pinned commit.
+func resolveRef(repo *git.Repository, params map[string]string) (plumbing.Hash, string, error) {
+	branch := getBranch(params)
+	ref := params["ref"]
+
+	switch {
+	case ref == "":
+		r, err := repo.Reference(plumbing.ReferenceName("refs/remotes/"+branch), true)
+		if err != nil {
+			return plumbing.ZeroHash, branch, err
+		}
+		return r.Hash(), branch, nil
+
+	case strings.HasPrefix(ref, refCommit):
+		sha := strings.TrimSpace(strings.TrimPrefix(ref, refCommit))
+		hash, err := repo.ResolveRevision(plumbing.Revision(sha))
+		if err != nil {
+			return plumbing.ZeroHash, ref, fmt.Errorf("ref %s: %w", ref, err)
+		}
+		return *hash, ref, nil
+
+	case strings.HasPrefix(ref, refSemver):
+		c, err := parseConstraint(strings.TrimPrefix(ref, refSemver))
+		if err != nil {
+			return plumbing.ZeroHash, ref, fmt.Errorf("ref %s: %w", ref, err)
+		}
+		return highestTag(repo, c.matches, nil)
+
+	case ref == refLatestTag:
+		r, err := repo.Reference(plumbing.ReferenceName("refs/remotes/"+branch), true)
+		if err != nil {
+			return plumbing.ZeroHash, ref, err
+		}
+		head, err := repo.CommitObject(r.Hash())
+		if err != nil {
+			return plumbing.ZeroHash, ref, err
+		}
+		onBranch := func(c *object.Commit) bool {
+			ok, err := c.IsAncestor(head)
+			return err == nil && ok
+		}
+		return highestTag(repo, func(v semVersion) bool { return v.pre == "" }, onBranch)
+	}
+
+	return plumbing.ZeroHash, ref, errors.New("unexpected ref=" + ref + ", want semver:<constraint>, latest-tag or commit:<sha>")
+}
+
+// highestTag returns the commit of the highest semver tag accepted by match and reachable.
+func highestTag(repo *git.Repository, match func(semVersion) bool, reachable func(*object.Commit) bool) (plumbing.Hash, string, error) {
+	tags, err := repo.Tags()
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+
+	var (
+		best     semVersion
+		bestName string
+		bestHash plumbing.Hash
+	)
+	err = tags.ForEach(func(t *plumbing.Reference) error {
+		name := t.Name().Short()
+		v, ok := parseSemver(name)
+		if !ok || !match(v) || (bestName != "" && v.compare(best) <= 0) {
+			return nil
+		}
+		commit, err := peelTag(repo, t)
+		if err != nil || (reachable != nil && !reachable(commit)) {
+			return nil //nolint:nilerr // skip the tags not pointing to a commit
+		}
+		best, bestName, bestHash = v, name, commit.Hash
+		return nil
+	})
+	if err != nil {
+		return plumbing.ZeroHash, "", err
+	}
+	if bestName == "" {
+		return plumbing.ZeroHash, "", errors.New("no matching tag")
+	}
+	return bestHash, bestName, nil
+}
+
+// peelTag returns the commit of a lightweight or annotated tag.
+func peelTag(repo *git.Repository, t *plumbing.Reference) (*object.Commit, error) {
+	tag, err := repo.TagObject(t.Hash())
+	if err == nil {
+		return tag.Commit()
+	}
+	return repo.CommitObject(t.Hash())
+}
+
+// parseSemver accepts "v1.2.3", "1.2.3-rc1" and the incomplete "v1.2" (1.2.0).
+func parseSemver(s string) (semVersion, bool) {
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+") // drop the build metadata
+	s, pre, _ := strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return semVersion{}, false
+	}
+	var nums [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return semVersion{}, false
+		}
+		nums[i] = n
+	}
+	return semVersion{major: nums[0], minor: nums[1], patch: nums[2], pre: pre}, true
+}
+
+func (v semVersion) compare(o semVersion) int {
+	if c := cmp.Compare(v.major, o.major); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.minor, o.minor); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(v.patch, o.patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1 // 1.0.0 > 1.0.0-rc1
+	case o.pre == "":
+		return -1
+	}
+	return comparePrerelease(v.pre, o.pre)
+}
+
+// comparePrerelease compares the dot-separated identifiers (SemVer 11.4):
+// numerically when both are numbers (rc.9 < rc.10), the numbers before the other identifiers,
+// and a longer pre-release is greater when the common identifiers are equal (alpha < alpha.1).
+func comparePrerelease(a, b string) int {
+	ids, others := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range min(len(ids), len(others)) {
+		x, y := ids[i], others[i]
+		xNum, yNum := isNumeric(x), isNumeric(y)
+		var c int
+		switch {
+		case xNum && yNum:
+			c = cmp.Or(cmp.Compare(len(x), len(y)), strings.Compare(x, y)) // no leading zeros
+		case xNum:
+			c = -1
+		case yNum:
+			c = 1
+		default:
+			c = strings.Compare(x, y)
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(ids), len(others))
+}
+
+func isNumeric(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (c semverConstraint) matches(v semVersion) bool {
+	if v.pre != "" && !c.pre {
+		return false
+	}
+	for _, m := range c.match {
+		if !m(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseConstraint parses the comparators, see the "ref" parameter.
+func parseConstraint(txt string) (semverConstraint, error) {
+	var c semverConstraint
+	for item := range strings.FieldsFuncSeq(txt, func(r rune) bool { return r == ',' || r == ' ' }) {
+		op := item[:len(item)-len(strings.TrimLeft(item, "<>=^~"))]
+		ver := item[len(op):]
+
+		if ver == "*" || ver == "x" || ver == "X" {
+			continue
+		}
+		// "1.4.x" and "1.4" are ranges: count the specified numbers
+		n := 0
+		for p := range strings.SplitSeq(ver, ".") {
+			if p == "x" || p == "X" || p == "*" {
+				break
+			}
+			n++
+		}
+		ver = strings.NewReplacer(".x", "", ".X", "", ".*", "").Replace(ver)
+		v, ok := parseSemver(ver)
+		if !ok || n == 0 {
+			return c, errors.New("invalid version " + strconv.Quote(item))
+		}
+		if v.pre != "" {
+			c.pre = true
+		}
+
+		next := semVersion{major: v.major + 1} // upper bound of the range
+		switch {
+		case op == "^" && v.major == 0 && v.minor == 0 && n == 3:
+			next = semVersion{patch: v.patch + 1} // ^0.0.3 := =0.0.3
+		case op == "^" && v.major == 0 && n > 1:
+			next = semVersion{minor: v.minor + 1}
+		case (op == "~" || op == "") && n > 1:
+			next = semVersion{major: v.major, minor: v.minor + 1}
+		}
+		if op == "" && n == 3 {
+			op = "="
+		}
+
+		switch op {
+		case "=":
+			c.match = append(c.match, func(x semVersion) bool { return x.compare(v) == 0 })
+		case ">":
+			c.match = append(c.match, func(x semVersion) bool { return x.compare(v) > 0 })
+		case ">=":
+			c.match = append(c.match, func(x semVersion) bool { return x.compare(v) >= 0 })
+		case "<":
+			c.match = append(c.match, func(x semVersion) bool { return x.compare(v) < 0 })
+		case "<=":
+			c.match = append(c.match, func(x semVersion) bool { return x.compare(v) <= 0 })
+		case "^", "~", "":
+			c.match = append(c.match, func(x semVersion) bool {
+				return x.compare(v) >= 0 && semVersion{major: x.major, minor: x.minor, patch: x.patch}.compare(next) < 0
+			})
+		default:
+			return c, errors.New("invalid operator " + strconv.Quote(op))
+		}
+	}
+	return c, nil
+}
+
+// fetchRemote fetches the branches and the tags of the remote of the repo parameter "branch".
+func fetchRemote(repo *git.Repository, params map[string]string) error {
+	auth, err := gitAuth(params)
+	if err != nil {
+		return err
+	}
+	remote, _, found := strings.Cut(getBranch(params), "/")
+	if !found {
+		remote = "origin"
+	}
+	err = repo.Fetch(&git.FetchOptions{RemoteName: remote, Tags: git.AllTags, Auth: auth, Force: true})
+	if errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,174 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func TestSemVersion_compare(t *testing.T) {
+	t.Parallel()
+
+	// ascending order, the example of SemVer 11.4 completed with rc.9 < rc.10
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "1.0.0-rc.9", "1.0.0-rc.10", "1.0.0", "1.0.1", "1.1.0", "v2",
+	}
+	for i := range len(ordered) - 1 {
+		a, _ := parseSemver(ordered[i])
+		b, _ := parseSemver(ordered[i+1])
+		if a.compare(b) >= 0 || b.compare(a) <= 0 {
+			t.Errorf("want %s < %s", ordered[i], ordered[i+1])
+		}
+		if a.compare(a) != 0 {
+			t.Errorf("want %s == %s", ordered[i], ordered[i])
+		}
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		constraint string
+		match      []string
+		reject     []string
+	}{
+		{"^1.4", []string{"1.4.0", "1.9.3"}, []string{"1.3.9", "2.0.0", "1.5.0-rc.1"}},
+		{"^0.x", []string{"0.0.1", "0.9.9"}, []string{"1.0.0"}},
+		{"^0.4", []string{"0.4.0", "0.4.9"}, []string{"0.3.9", "0.5.0"}},
+		{"^0.0.3", []string{"0.0.3"}, []string{"0.0.4", "0.1.0"}},
+		{"~1.4.2", []string{"1.4.2", "1.4.9"}, []string{"1.4.1", "1.5.0"}},
+		{"~1", []string{"1.0.0", "1.9.0"}, []string{"2.0.0"}},
+		{"1.4.x", []string{"1.4.0", "1.4.7"}, []string{"1.3.0", "1.5.0"}},
+		{"1.4", []string{"1.4.0", "1.4.7"}, []string{"1.5.0"}},
+		{"1.4.2", []string{"1.4.2"}, []string{"1.4.3"}},
+		{">=1.2, <2", []string{"1.2.0", "1.9.9"}, []string{"1.1.9", "2.0.0"}},
+		{"*", []string{"0.1.0", "3.0.0"}, []string{"3.0.0-rc.1"}},
+		{">=2.0.0-rc.1", []string{"2.0.0-rc.1", "2.0.0-rc.10", "2.0.0"}, []string{"2.0.0-beta.5", "1.9.0"}},
+		{"^2.0.0-rc.2", []string{"2.0.0-rc.10", "2.1.0"}, []string{"2.0.0-rc.1", "3.0.0-rc.1"}},
+	}
+	for _, c := range cases {
+		con, err := parseConstraint(c.constraint)
+		if err != nil {
+			t.Errorf("parseConstraint(%q): %v", c.constraint, err)
+			continue
+		}
+		for _, txt := range c.match {
+			if v, _ := parseSemver(txt); !con.matches(v) {
+				t.Errorf("%q must match %s", c.constraint, txt)
+			}
+		}
+		for _, txt := range c.reject {
+			if v, _ := parseSemver(txt); con.matches(v) {
+				t.Errorf("%q must not match %s", c.constraint, txt)
+			}
+		}
+	}
+
+	for _, bad := range []string{"^y", "1.a", "!1.2", "1.2.3.4"} {
+		if _, err := parseConstraint(bad); err == nil {
+			t.Errorf("parseConstraint(%q) must fail", bad)
+		}
+	}
+}
+
+// newTaggedRepo creates an in-memory repository of linear commits, see addCommits.
+func newTaggedRepo(t *testing.T, names ...string) (*git.Repository, []plumbing.Hash) {
+	t.Helper()
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo, addCommits(t, repo, names...)
+}
+
+// addCommits adds linear commits (empty tree), each tagged by its name
+// (lightweight and annotated tags alternately, "" for an untagged commit).
+func addCommits(t *testing.T, repo *git.Repository, names ...string) []plumbing.Hash {
+	t.Helper()
+	store := func(o object.Object) plumbing.Hash {
+		obj := repo.Storer.NewEncodedObject()
+		err := o.Encode(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := repo.Storer.SetEncodedObject(obj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	sig := object.Signature{Name: "test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	tree := store(&object.Tree{})
+	var parents, commits []plumbing.Hash
+	for i, name := range names {
+		sig.When = sig.When.Add(time.Minute)
+		h := store(&object.Commit{Author: sig, Committer: sig, Message: name, TreeHash: tree, ParentHashes: parents})
+		commits = append(commits, h)
+		parents = []plumbing.Hash{h}
+		if name == "" {
+			continue
+		}
+		var opts *git.CreateTagOptions
+		if i%2 == 1 { // annotated tag
+			opts = &git.CreateTagOptions{Tagger: &sig, Message: name}
+		}
+		_, err := repo.CreateTag(name, h, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return commits
+}
+
+func TestResolveRef(t *testing.T) {
+	t.Parallel()
+
+	repo, commits := newTaggedRepo(t,
+		"v0.9.0", "v1.4.0", "v1.4.7", "v2.0.0-rc.9", "v2.0.0-rc.10", "v1.5.0", "", "v1.6.0")
+	head := commits[6]
+	err := repo.Storer.SetReference(plumbing.NewHashReference("refs/remotes/origin/main", head))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ref      string
+		wantDesc string
+		want     plumbing.Hash
+	}{
+		{"", "origin/main", head},
+		{"semver:^0.x", "v0.9.0", commits[0]},
+		{"semver:~1.4", "v1.4.7", commits[2]},
+		{"semver:1.4.x", "v1.4.7", commits[2]},
+		{"semver:^1.4", "v1.6.0", commits[7]},
+		{"semver:>=2.0.0-rc.1", "v2.0.0-rc.10", commits[4]},
+		{"latest-tag", "v1.5.0", commits[5]}, // v1.6.0 is not on the branch
+		{"commit:" + commits[1].String()[:8], "commit:" + commits[1].String()[:8], commits[1]},
+	}
+	for _, c := range cases {
+		got, desc, err := resolveRef(repo, map[string]string{"ref": c.ref})
+		if err != nil || got != c.want || desc != c.wantDesc {
+			t.Errorf("ref=%q: got %s %q err=%v want %s %q", c.ref, got, desc, err, c.want, c.wantDesc)
+		}
+	}
+
+	for _, bad := range []string{"semver:^3", "semver:^y", "commit:deadbeef", "tip"} {
+		if _, _, err := resolveRef(repo, map[string]string{"ref": bad}); err == nil {
+			t.Errorf("ref=%q must fail", bad)
+		}
+	}
+	if _, _, err := resolveRef(repo, map[string]string{"branch": "origin/nope"}); err == nil {
+		t.Error("a missing branch must fail")
+	}
+}
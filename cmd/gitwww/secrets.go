@@ -0,0 +1,225 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// The repo parameters "secret-<id>-env" and "secret-<id>-file" provide the build secrets
+// (registry credentials, API tokens fetching the data of the site...) from an environment
+// variable of gitwww or from a file. The secrets are not build arguments: they are not
+// stored in the image layers, nor in the logs.
+//
+//	[my-blog]
+//	secret-NPM_TOKEN-env = "BLOG_NPM_TOKEN"
+//	secret-CMS_TOKEN-file = "/etc/gitwww/cms-token"
+//
+// The Containerfile mounts them during a RUN instruction (BuildKit, Podman):
+//
+//	RUN --mount=type=secret,id=NPM_TOKEN,env=NPM_TOKEN npm ci
+//	RUN --mount=type=secret,id=CMS_TOKEN cat /run/secrets/CMS_TOKEN
+//
+// The engine cmd exports each secret as the environment variable named by its id.
+// The Docker engine builds with the docker CLI when the repo has secrets
+// because the Docker API requires a BuildKit session to pass them.
+const secretPrefix = "secret-"
+
+const redacted = "***"
+
+// buildSecret is a build secret: its id and its source (environment variable or file).
+type buildSecret struct {
+	id    string
+	env   string
+	file  string
+	value string
+}
+
+var secretID = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// getBuildSecrets reads the build secrets of the repo, sorted by id.
+func getBuildSecrets(params map[string]string) ([]buildSecret, error) {
+	var secrets []buildSecret
+	seen := make(map[string]bool)
+	for k := range params {
+		rest, ok := strings.CutPrefix(k, secretPrefix)
+		if !ok {
+			continue
+		}
+		id, isEnv := strings.CutSuffix(rest, "-env")
+		if !isEnv {
+			id, ok = strings.CutSuffix(rest, "-file")
+			if !ok {
+				return nil, errors.New(k + ": a build secret must not be a plain value, use " + k + "-env or " + k + "-file")
+			}
+		}
+		if !secretID.MatchString(id) {
+			return nil, errors.New(k + ": the secret id must be a valid variable name (letters, digits and underscores)")
+		}
+		if seen[id] {
+			return nil, errors.New(secretPrefix + id + "-env and " + secretPrefix + id + "-file: set only one source of the secret " + id)
+		}
+		seen[id] = true
+
+		value, err := readSecret(params, secretPrefix+id)
+		if err != nil {
+			return nil, err
+		}
+		s := buildSecret{id: id, value: value}
+		if isEnv {
+			s.env = params[k]
+		} else {
+			s.file = params[k]
+		}
+		secrets = append(secrets, s)
+	}
+	slices.SortFunc(secrets, func(a, b buildSecret) int { return strings.Compare(a.id, b.id) })
+	return secrets, nil
+}
+
+// flag returns the value of the option --secret of the docker and podman CLI.
+func (s buildSecret) flag() string {
+	if s.file != "" {
+		return "id=" + s.id + ",src=" + s.file
+	}
+	return "id=" + s.id + ",env=" + s.env
+}
+
+// isSecretParam reports whether the repo parameter configures a build secret,
+// such a parameter is not a build argument.
+func isSecretParam(name string) bool {
+	return strings.HasPrefix(name, secretPrefix)
+}
+
+// redactWriter replaces the secret values by "***" in the build output.
+// The end of a Write that may be the beginning of a secret is held until the next Write,
+// so a secret split across two writes is also redacted (see Flush).
+type redactWriter struct {
+	w       io.Writer
+	secrets [][]byte
+	held    []byte // the bytes of the previous Write starting a secret
+	mu      sync.Mutex
+}
+
+// newRedactWriter returns w when there is no secret to redact.
+func newRedactWriter(w io.Writer, secrets []buildSecret) io.Writer {
+	var values [][]byte
+	for _, s := range secrets {
+		if s.value != "" {
+			values = append(values, []byte(s.value))
+		}
+	}
+	if len(values) == 0 {
+		return w
+	}
+	// the longest secrets first: a secret may contain another one
+	slices.SortFunc(values, func(a, b []byte) int { return len(b) - len(a) })
+	return &redactWriter{w: w, secrets: values}
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	data := append(rw.held, p...)
+	out := make([]byte, 0, len(data))
+	i := 0
+	for i < len(data) {
+		if n := rw.match(data[i:]); n > 0 {
+			out = append(out, redacted...)
+			i += n
+			continue
+		}
+		if rw.startsSecret(data[i:]) {
+			break // hold the rest until the next Write
+		}
+		out = append(out, data[i])
+		i++
+	}
+	rw.held = slices.Clone(data[i:])
+
+	if len(out) > 0 {
+		_, err := rw.w.Write(out)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes the held bytes: the output ends with the beginning of a secret, not a secret.
+func (rw *redactWriter) Flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if len(rw.held) == 0 {
+		return nil
+	}
+	_, err := rw.w.Write(rw.held)
+	rw.held = nil
+	return err
+}
+
+// match returns the length of the secret at the beginning of data, zero if none.
+func (rw *redactWriter) match(data []byte) int {
+	for _, s := range rw.secrets {
+		if bytes.HasPrefix(data, s) {
+			return len(s)
+		}
+	}
+	return 0
+}
+
+// startsSecret reports whether data is shorter than a secret and is its beginning.
+func (rw *redactWriter) startsSecret(data []byte) bool {
+	for _, s := range rw.secrets {
+		if len(data) < len(s) && bytes.HasPrefix(s, data) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushOutput writes the bytes held by the build output, see redactWriter.
+func flushOutput(w io.Writer) {
+	if rw, ok := w.(*redactWriter); ok {
+		err := rw.Flush()
+		if err != nil {
+			slog.Warn("Cannot write the build output", "err", err)
+		}
+	}
+}
+
+// redactBuildArgs returns the names of the build arguments, their values are not logged.
+func redactBuildArgs(args map[string]*string) map[string]*string {
+	if len(args) == 0 {
+		return nil
+	}
+	v := redacted
+	out := make(map[string]*string, len(args))
+	for k := range args {
+		out[k] = &v
+	}
+	return out
+}
+
+// redactCLIArgs returns a copy of the arguments of "docker/podman build" to be logged,
+// without the values of the build arguments.
+func redactCLIArgs(args []string) []string {
+	out := slices.Clone(args)
+	for i := 1; i < len(out); i++ {
+		if out[i-1] == "--build-arg" {
+			k, _, _ := strings.Cut(out[i], "=")
+			out[i] = k + "=" + redacted
+		}
+	}
+	return out
+}
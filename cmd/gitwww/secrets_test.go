@@ -0,0 +1,42 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRedactWriter_SplitSecret(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	w := newRedactWriter(&buf, []buildSecret{{id: "TOKEN", value: "s3cr3t-t0k3n"}})
+
+	for _, p := range []string{"npm ci --token=s3cr", "3t-t0k3n\n", "done s3cr"} {
+		_, err := w.Write([]byte(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	flushOutput(w)
+
+	want := "npm ci --token=***\ndone s3cr"
+	if buf.String() != want {
+		t.Errorf("got %q want %q", buf.String(), want)
+	}
+}
+
+func TestGetBuildSecrets_DuplicateID(t *testing.T) {
+	t.Setenv("GITWWW_TEST_TOKEN", "x")
+	params := map[string]string{
+		"secret-TOKEN-env":  "GITWWW_TEST_TOKEN",
+		"secret-TOKEN-file": "/etc/gitwww/token",
+	}
+	_, err := getBuildSecrets(params)
+	if err == nil {
+		t.Error("want an error when a secret has both -env and -file")
+	}
+}
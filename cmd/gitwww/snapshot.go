@@ -0,0 +1,165 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+const (
+	defaultSnapshotsName = "snapshots"
+	defaultSnapshotsKeep = 5
+	snapshotExt          = ".tar.gz"
+)
+
+// getSnapshotsDir returns the directory of the worktree snapshots, by default next to the configuration file.
+func (cfg *Cfg) getSnapshotsDir() string {
+	if cfg.Snapshots != "" {
+		return cfg.Snapshots
+	}
+	return filepath.Join(filepath.Dir(cfg.Path), defaultSnapshotsName)
+}
+
+func (cfg *Cfg) getSnapshotsKeep() int {
+	if cfg.SnapshotsKeep > 0 {
+		return cfg.SnapshotsKeep
+	}
+	return defaultSnapshotsKeep
+}
+
+// snapshotWorktree archives the modified and untracked files of the worktree
+// before the hard reset destroys them. The archive also contains HEAD
+// (the hash of the checked out commit) to recover the local commits with
+// "git reset --hard <hash>" (until "git gc" prunes them) and the output of "git status".
+// snapshotWorktree returns an empty path when the worktree is clean.
+// Only the last snapshots of each repository are kept (see snapshots-keep).
+func (cfg *Cfg) snapshotWorktree(repo *git.Repository, dir string) (string, error) {
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return "", err
+	}
+	if status.IsClean() {
+		return "", nil
+	}
+
+	snapDir := filepath.Join(cfg.getSnapshotsDir(), filepath.Base(dir))
+	err = os.MkdirAll(snapDir, 0o700)
+	if err != nil {
+		return "", err
+	}
+	file := filepath.Join(snapDir, time.Now().UTC().Format("20060102-150405")+snapshotExt)
+
+	err = writeSnapshot(file, worktree.Filesystem.Root(), headCommit(repo), status)
+	if err != nil {
+		_ = os.Remove(file)
+		return "", err
+	}
+
+	pruneFiles(snapDir, snapshotExt, cfg.getSnapshotsKeep())
+	return file, nil
+}
+
+func writeSnapshot(file, root, head string, status git.Status) (err error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, f.Close()) }()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	now := time.Now()
+	meta := map[string]string{"HEAD": head + "\n", "status.txt": status.String()}
+	for _, name := range []string{"HEAD", "status.txt"} {
+		err = tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(meta[name])), ModTime: now})
+		if err == nil {
+			_, err = tw.Write([]byte(meta[name]))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, p := range slices.Sorted(maps.Keys(status)) {
+		err = addToSnapshot(tw, root, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return errors.Join(tw.Close(), gz.Close())
+}
+
+// addToSnapshot copies the worktree file under "worktree/", the deleted files are skipped.
+func addToSnapshot(tw *tar.Writer, root, p string) error {
+	abs := filepath.Join(root, filepath.FromSlash(p))
+	info, err := os.Lstat(abs)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil // symlinks and sub-modules are recorded in status.txt only
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = "worktree/" + p
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(abs)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, err = io.Copy(tw, src)
+	return err
+}
+
+// pruneFiles removes the oldest files having the extension ext (the names are timestamps).
+func pruneFiles(dir, ext string, keep int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		slog.Warn("Cannot list the files to prune", "dir", dir, "err", err)
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ext) {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names[:max(len(names)-keep, 0)] {
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			slog.Warn("Cannot remove the old file", "file", name, "err", err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultLastEvents = 50
+
+// serveStatus starts the status HTTP server in background (when cfg.Status is set).
+//
+//	GET /events?n=50   last events (most recent first)
+//	GET /status        last event of each repo
+//	GET /log?repo=dir  last build log of the repo (plain text)
+func (cfg *Cfg) serveStatus() {
+	if cfg.Status == "" {
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", cfg.handleEvents)
+	mux.HandleFunc("GET /status", cfg.handleStatus)
+	mux.HandleFunc("GET /log", cfg.handleBuildLog)
+
+	server := &http.Server{
+		Addr:              cfg.Status,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Second,
+		WriteTimeout:      10 * time.Second,
+	}
+
+	slog.Info("Status endpoint", "url", "http://"+cfg.Status+"/status")
+	go func() {
+		err := server.ListenAndServe()
+		slog.Error("Status endpoint stopped", "addr", cfg.Status, "err", err)
+	}()
+}
+
+func (cfg *Cfg) handleEvents(w http.ResponseWriter, r *http.Request) {
+	n := defaultLastEvents
+	if txt := r.URL.Query().Get("n"); txt != "" {
+		var err error
+		n, err = strconv.Atoi(txt)
+		if err != nil || n < 0 {
+			http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, cfg.events.Last(n))
+}
+
+func (cfg *Cfg) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	repos := make(map[string]Event)
+	for _, e := range cfg.events.Last(eventsInMem) {
+		if _, ok := repos[e.Repo]; !ok {
+			repos[e.Repo] = e
+		}
+	}
+	writeJSON(w, repos)
+}
+
+// handleBuildLog sends the last build log, possibly the one being written.
+func (cfg *Cfg) handleBuildLog(w http.ResponseWriter, r *http.Request) {
+	dir := r.URL.Query().Get("repo")
+	if !cfg.isRepo(dir) {
+		http.Error(w, "unknown repo", http.StatusNotFound)
+		return
+	}
+	file, err := lastBuildLog(dir)
+	if err != nil {
+		http.Error(w, "no build log", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	http.ServeFile(w, r, file)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		slog.Warn("Status endpoint", "err", err)
+	}
+}
@@ -0,0 +1,326 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/moby/moby/client"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Results of the checks of "gitwww check".
+const (
+	checkOK    = "ok"
+	checkWarn  = "warn"
+	checkError = "error"
+)
+
+const engineCheckTimeout = 10 * time.Second
+
+type (
+	// checkResult is one line of the report of "gitwww check".
+	checkResult struct {
+		Result string
+		Repo   string // empty for the global checks
+		Check  string
+		Detail string
+	}
+
+	checkReport []checkResult
+)
+
+func (rep *checkReport) add(result, repo, check, detail string) {
+	*rep = append(*rep, checkResult{Result: result, Repo: repo, Check: check, Detail: detail})
+}
+
+// addErr adds an error when err is not nil, else the OK detail.
+func (rep *checkReport) addErr(err error, repo, check, detail string) {
+	if err != nil {
+		rep.add(checkError, repo, check, err.Error())
+	} else {
+		rep.add(checkOK, repo, check, detail)
+	}
+}
+
+// checkCommand implements "gitwww check": validates the configuration without building
+// (configuration file, repo paths, Containerfile, branch refs, engines, www permissions,
+// duplicate tags), prints the report and returns an error when a check fails.
+func (cfg *Cfg) checkCommand(args []string) error {
+	if len(args) > 0 {
+		return errors.New("usage: gitwww check")
+	}
+
+	rep := cfg.check(context.Background())
+	rep.print(os.Stdout)
+
+	var errs, warns int
+	for _, r := range rep {
+		switch r.Result {
+		case checkError:
+			errs++
+		case checkWarn:
+			warns++
+		}
+	}
+	fmt.Printf("\n%d checks, %d errors, %d warnings\n", len(rep), errs, warns)
+	if errs > 0 {
+		return fmt.Errorf("check: %d errors", errs)
+	}
+	return nil
+}
+
+func (cfg *Cfg) check(ctx context.Context) checkReport {
+	var rep checkReport
+	cfg.checkFile(&rep)
+
+	if directoryExists(cfg.Repos) {
+		rep.add(checkOK, "", "repos", cfg.Repos)
+	} else {
+		rep.add(checkError, "", "repos", "directory does not exist: "+cfg.Repos)
+	}
+	rep.addErr(checkWritable(cfg.WWW), "", "www", cfg.WWW)
+
+	engines := make(map[string]bool)
+	tags := make(map[string][]string)
+	wwws := make(map[string][]string)
+	for _, repo := range cfg.checkedRepos() {
+		dir := cfg.checkRepo(&rep, repo)
+		if dir == "" {
+			continue
+		}
+		params := cfg.Repositories[repo]
+		for engine := range strings.SplitSeq(cfg.getEngines(params), ",") {
+			engines[engine] = true
+			if engine != engineCmd {
+				tags[cfg.getTag(repo)] = append(tags[cfg.getTag(repo)], dir)
+			}
+		}
+		www := cfg.getAbsWWW(repo)
+		wwws[www] = append(wwws[www], dir)
+	}
+
+	for _, tag := range slices.Sorted(maps.Keys(tags)) {
+		dirs := tags[tag]
+		dirs = slices.Compact(dirs) // the same repo for "docker,podman"
+		if len(dirs) > 1 {
+			rep.add(checkError, "", "tag", "the repos "+strings.Join(dirs, ", ")+" build the same image tag "+tag)
+		}
+	}
+	for _, www := range slices.Sorted(maps.Keys(wwws)) {
+		dirs := wwws[www]
+		if len(dirs) > 1 {
+			rep.add(checkError, "", "www", "the repos "+strings.Join(dirs, ", ")+" deploy into the same directory "+www)
+		}
+	}
+
+	for _, engine := range slices.Sorted(maps.Keys(engines)) {
+		detail, err := checkEngine(ctx, engine)
+		rep.addErr(err, "", "engine "+engine, detail)
+	}
+	return rep
+}
+
+// checkedRepos returns the repo tables and the sub-directories of repos having a Containerfile.
+func (cfg *Cfg) checkedRepos() []string {
+	repos := slices.Sorted(maps.Keys(cfg.Repositories))
+	configured := make(map[string]bool, len(repos))
+	for _, repo := range repos {
+		configured[cfg.Abs(repo)] = true
+	}
+	subDirs, _ := cfg.subDirectories()
+	for _, abs := range slices.Sorted(maps.Keys(subDirs)) {
+		if !configured[abs] {
+			repos = append(repos, abs)
+		}
+	}
+	return repos
+}
+
+// checkFile parses the configuration file again, reporting the unknown settings
+// and the repo tables ignored by getCfg.
+func (cfg *Cfg) checkFile(rep *checkReport) {
+	data, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		rep.add(checkWarn, "", "config", "default settings: "+err.Error())
+		return
+	}
+	pos := bytes.IndexByte(data, '[')
+	if pos < 0 {
+		pos = len(data)
+	}
+
+	var global Cfg
+	err = toml.NewDecoder(bytes.NewReader(data[:pos])).DisallowUnknownFields().Decode(&global)
+	var strict *toml.StrictMissingError
+	switch {
+	case errors.As(err, &strict):
+		var keys []string
+		for _, e := range strict.Errors {
+			keys = append(keys, strings.Join(e.Key(), "."))
+		}
+		rep.add(checkWarn, "", "config", "unknown settings: "+strings.Join(keys, ", "))
+	case err != nil:
+		rep.add(checkError, "", "config", err.Error())
+	default:
+		var tables map[string]map[string]string
+		err = toml.Unmarshal(data[pos:], &tables)
+		rep.addErr(err, "", "config", cfg.Path)
+	}
+}
+
+// checkRepo checks one repo, the returned dir is empty when the repo is disabled or unusable.
+func (cfg *Cfg) checkRepo(rep *checkReport, repo string) string {
+	params := cfg.Repositories[repo]
+	if strings.EqualFold(params["enable"], "false") {
+		rep.add(checkWarn, repo, "enable", "disabled")
+		return ""
+	}
+
+	dir := cfg.Abs(repo)
+	if dir == "" {
+		rep.add(checkError, repo, "path", "directory does not exist in "+cfg.Repos)
+		return ""
+	}
+
+	_, err := gitAuth(params)
+	rep.addErr(err, repo, "auth", "credentials available")
+
+	secrets, err := getBuildSecrets(params)
+	switch {
+	case err != nil:
+		rep.add(checkError, repo, "secrets", err.Error())
+	case len(secrets) > 0:
+		ids := make([]string, 0, len(secrets))
+		for _, s := range secrets {
+			ids = append(ids, s.id)
+		}
+		rep.add(checkOK, repo, "secrets", strings.Join(ids, ", "))
+	}
+
+	if !directoryExists(dir) {
+		rep.add(checkOK, repo, "path", dir+" will be cloned from "+params["clone"])
+		return dir // cannot check the Containerfile and the refs before the clone
+	}
+
+	r, err := git.PlainOpen(dir)
+	if err != nil {
+		rep.add(checkError, repo, "git", dir+": "+err.Error())
+		return ""
+	}
+	rep.add(checkOK, repo, "path", dir)
+
+	file := cfg.findContainerfile(repo)
+	switch {
+	case params["build-cmd"] != "":
+		rep.add(checkOK, repo, "build", "build-cmd = "+params["build-cmd"])
+	case file != "":
+		rep.add(checkOK, repo, "build", file)
+	default:
+		rep.add(checkError, repo, "build", "neither Containerfile/Dockerfile nor build-cmd")
+	}
+
+	hash, ref, err := resolveRef(r, params)
+	if err != nil {
+		rep.add(checkError, repo, "ref", ref+": "+err.Error())
+	} else {
+		rep.add(checkOK, repo, "ref", ref+" = "+hash.String()[:7])
+	}
+
+	if txt := params["build-timeout"]; txt != "" {
+		_, err = time.ParseDuration(txt)
+		rep.addErr(err, repo, "build-timeout", txt)
+	}
+	if txt := params["precompress"]; txt != "" {
+		_, err = precompressEncodings(txt)
+		rep.addErr(err, repo, "precompress", txt)
+	}
+	for _, name := range []string{"keep", "logs-keep"} {
+		if txt := params[name]; txt != "" {
+			if n, err := strconv.Atoi(txt); err != nil || n < 1 {
+				rep.add(checkError, repo, name, "want a positive integer, got "+txt)
+			}
+		}
+	}
+
+	www := cfg.getAbsWWW(repo)
+	rep.addErr(checkWritable(filepath.Dir(www)), repo, "www", www)
+	return dir
+}
+
+// checkWritable creates and removes a temporary file: the deployments rename directories there.
+func checkWritable(dir string) error {
+	if !directoryExists(dir) {
+		return errors.New("directory does not exist: " + dir)
+	}
+	f, err := os.CreateTemp(dir, ".gitwww-check-*")
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	return errors.Join(f.Close(), os.Remove(f.Name()))
+}
+
+// checkEngine connects to the Docker/Podman daemon, or looks for the shell of the cmd engine.
+func checkEngine(ctx context.Context, engine string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, engineCheckTimeout)
+	defer cancel()
+
+	switch engine {
+	case "docker":
+		cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			return "", err
+		}
+		defer cli.Close()
+		ping, err := cli.Ping(ctx)
+		if err != nil {
+			return "", err
+		}
+		return cli.DaemonHost() + " API " + ping.APIVersion, nil
+
+	case "podman":
+		var out bytes.Buffer
+		cmd := exec.CommandContext(ctx, "podman", "version", "--format", "{{.Server.Version}}")
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		err := cmd.Run()
+		if err != nil {
+			return "", fmt.Errorf("podman version: %w %s", err, strings.TrimSpace(out.String()))
+		}
+		return "podman " + strings.TrimSpace(out.String()), nil
+
+	case engineCmd:
+		sh, err := exec.LookPath("sh")
+		return sh, err
+	}
+	return "", errors.New("unexpected engine, want docker, podman or cmd")
+}
+
+// print writes the report as aligned columns.
+func (rep checkReport) print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, r := range rep {
+		repo := r.Repo
+		if repo == "" {
+			repo = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", strings.ToUpper(r.Result), repo, r.Check, r.Detail)
+	}
+	_ = tw.Flush()
+}
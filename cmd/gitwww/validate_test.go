@@ -0,0 +1,148 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// newCheckCfg returns a configuration whose Repos and WWW are temporary directories.
+func newCheckCfg(t *testing.T) *Cfg {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := &Cfg{
+		Path:         filepath.Join(dir, "gitwww.ini"),
+		Repos:        filepath.Join(dir, "repos"),
+		WWW:          filepath.Join(dir, "www"),
+		Engine:       "podman",
+		Repositories: map[string]map[string]string{},
+	}
+	for _, d := range []string{cfg.Repos, cfg.WWW} {
+		err := os.Mkdir(d, 0o750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cfg
+}
+
+// addRepo creates a Git repo in cfg.Repos with origin/main and the files (empty content).
+func addRepo(t *testing.T, cfg *Cfg, name string, params map[string]string, files ...string) {
+	t.Helper()
+	dir := filepath.Join(cfg.Repos, name)
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits := addCommits(t, repo, "")
+	err = repo.Storer.SetReference(plumbing.NewHashReference("refs/remotes/origin/main", commits[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		err = os.WriteFile(filepath.Join(dir, f), nil, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg.Repositories[name] = params
+}
+
+// find returns the details of the report lines of the repo and check having the result.
+func (rep checkReport) find(result, repo, check string) []string {
+	var details []string
+	for _, r := range rep {
+		if r.Result == result && r.Repo == repo && r.Check == check {
+			details = append(details, r.Detail)
+		}
+	}
+	return details
+}
+
+func TestCfg_check(t *testing.T) {
+	t.Parallel()
+
+	cfg := newCheckCfg(t)
+	addRepo(t, cfg, "a", map[string]string{"tag": "site"}, "Containerfile")
+	addRepo(t, cfg, "b", map[string]string{"tag": "site", "www": "b-www"}, "Dockerfile")
+	addRepo(t, cfg, "c", map[string]string{"tag": "c", "www": "a"}, "Containerfile")
+	addRepo(t, cfg, "d", map[string]string{"build-cmd": "make", "www": "d"})
+
+	rep := cfg.check(context.Background())
+
+	tags := rep.find(checkError, "", "tag")
+	if len(tags) != 1 || !strings.Contains(tags[0], "same image tag site") ||
+		!strings.Contains(tags[0], filepath.Join(cfg.Repos, "a")) || !strings.Contains(tags[0], filepath.Join(cfg.Repos, "b")) {
+		t.Errorf("want the duplicate tag of a and b, got %q", tags)
+	}
+
+	wwws := rep.find(checkError, "", "www")
+	if len(wwws) != 1 || !strings.HasSuffix(wwws[0], "same directory "+filepath.Join(cfg.WWW, "a")) {
+		t.Errorf("want the duplicate www of a and c, got %q", wwws)
+	}
+
+	if got := rep.find(checkWarn, "", "config"); len(got) != 1 {
+		t.Errorf("want a warning for the missing configuration file, got %q", got)
+	}
+	if got := rep.find(checkOK, "", "engine cmd"); len(got) != 1 {
+		t.Errorf("want the cmd engine checked once, got %q", got)
+	}
+	if got := rep.find(checkOK, "d", "build"); len(got) != 1 || got[0] != "build-cmd = make" {
+		t.Errorf("want the build-cmd of d, got %q", got)
+	}
+}
+
+func TestCfg_checkRepo(t *testing.T) {
+	t.Parallel()
+
+	cfg := newCheckCfg(t)
+	addRepo(t, cfg, "ok", nil, "Containerfile")
+	addRepo(t, cfg, "no-build", nil, "README.md")
+	addRepo(t, cfg, "bad-branch", map[string]string{"branch": "origin/nope"}, "Containerfile")
+	addRepo(t, cfg, "off", map[string]string{"enable": "false"}, "Containerfile")
+	cfg.Repositories["missing"] = nil
+
+	cases := []struct {
+		repo, result, check, detail string
+		wantDir                     bool
+	}{
+		{"ok", checkOK, "ref", "origin/main = ", true},
+		{"no-build", checkError, "build", "neither Containerfile/Dockerfile nor build-cmd", true},
+		{"bad-branch", checkError, "ref", "origin/nope: ", true},
+		{"off", checkWarn, "enable", "disabled", false},
+		{"missing", checkError, "path", "directory does not exist", false},
+	}
+	for _, c := range cases {
+		var rep checkReport
+		dir := cfg.checkRepo(&rep, c.repo)
+		if (dir != "") != c.wantDir {
+			t.Errorf("%s: dir=%q", c.repo, dir)
+		}
+		got := rep.find(c.result, c.repo, c.check)
+		if len(got) != 1 || !strings.HasPrefix(got[0], c.detail) {
+			t.Errorf("%s: want %s %s %q, got %q in %+v", c.repo, c.result, c.check, c.detail, got, rep)
+		}
+	}
+}
+
+func TestCheckEngine(t *testing.T) {
+	t.Parallel()
+
+	sh, err := checkEngine(context.Background(), engineCmd)
+	if err != nil || filepath.Base(sh) != "sh" {
+		t.Errorf("engine cmd: %q %v", sh, err)
+	}
+	_, err = checkEngine(context.Background(), "kubectl")
+	if err == nil {
+		t.Error("want an error for an unexpected engine")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	verifyAttempts = 3
+	verifyDelay    = 2 * time.Second  // between the attempts: let the web server notice the new files
+	verifyTimeout  = 10 * time.Second // per attempt
+	verifyMaxBody  = 10 << 20
+)
+
+// verifyDeploy fetches the repo parameter "verify-url" after the www swap:
+// the deploy is valid when the response is 200 OK and its body contains
+// the repo parameter "verify-marker" (if any). No "verify-url" means no verification.
+func verifyDeploy(ctx context.Context, params map[string]string) error {
+	url := params["verify-url"]
+	if url == "" {
+		return nil
+	}
+
+	var err error
+	for attempt := range verifyAttempts {
+		if attempt > 0 {
+			select {
+			case <-time.After(verifyDelay):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err = fetchAndCheck(ctx, url, params["verify-marker"])
+		if err == nil {
+			slog.Info("✅ verify-url OK", "url", url)
+			return nil
+		}
+		slog.Warn("verify-url", "url", url, "attempt", attempt+1, "err", err)
+	}
+	return fmt.Errorf("verify-url %s: %w", url, err)
+}
+
+func fetchAndCheck(ctx context.Context, url, marker string) error {
+	ctx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Cache-Control", "no-cache")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected status " + resp.Status)
+	}
+	if marker == "" {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, verifyMaxBody))
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(body, []byte(marker)) {
+		return fmt.Errorf("missing marker %q", marker)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 The contributors of Garcon.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lynxai-team/garcon/gwt"
+)
+
+// The encrypted markdown is an ASCII armor that survives the copy/paste
+// in ticketing systems and emails:
+//
+//	-----BEGIN MD-CODE ENCRYPTED MARKDOWN-----
+//	KDF: pbkdf2-sha256 600000 <salt in hex>
+//
+//	<base64 of nonce + AES-GCM ciphertext + tag, 64 columns>
+//	-----END MD-CODE ENCRYPTED MARKDOWN-----
+//
+// The secret is either a passphrase (the AES-256 key is derived with PBKDF2)
+// or a raw AES-256 key of 64 hexadecimal digits ("KDF: none").
+const (
+	envPassphrase    = "MD_CODE_PASSPHRASE"
+	armorBegin       = "-----BEGIN MD-CODE ENCRYPTED MARKDOWN-----"
+	armorEnd         = "-----END MD-CODE ENCRYPTED MARKDOWN-----"
+	kdfPrefix        = "KDF: "
+	kdfPBKDF2        = "pbkdf2-sha256"
+	kdfNone          = "none"
+	pbkdf2Iterations = 600_000
+	saltSize         = 16
+	aesKeySize       = 32
+	armorColumns     = 64
+)
+
+// encodingKeyMu protects gwt.EncodingKey, the key of the gwt AES-GCM helpers.
+var encodingKeyMu sync.Mutex
+
+var errNoSecret = errors.New("missing passphrase: set $" + envPassphrase + " or use -key-file")
+
+// readSecret returns the passphrase (or hexadecimal key) from the file, else from $MD_CODE_PASSPHRASE.
+// The secret is never read from a flag value to keep it out of the shell history.
+func readSecret(keyFile string) (string, error) {
+	secret := os.Getenv(envPassphrase)
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", err
+		}
+		secret = string(data)
+	}
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return "", errNoSecret
+	}
+	return secret, nil
+}
+
+// rawKey returns the AES-256 key when the secret is 64 hexadecimal digits.
+func rawKey(secret string) []byte {
+	if len(secret) != 2*aesKeySize {
+		return nil
+	}
+	key, err := hex.DecodeString(secret)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// encryptMarkdown encrypts the markdown into the ASCII armor.
+func encryptMarkdown(secret string, md []byte) ([]byte, error) {
+	kdf := kdfNone
+	key := rawKey(secret)
+	if key == nil {
+		salt := make([]byte, saltSize)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return nil, err
+		}
+		key, err = pbkdf2.Key(sha256.New, secret, salt, pbkdf2Iterations, aesKeySize)
+		if err != nil {
+			return nil, err
+		}
+		kdf = kdfPBKDF2 + " " + strconv.Itoa(pbkdf2Iterations) + " " + hex.EncodeToString(salt)
+	}
+
+	encodingKeyMu.Lock()
+	gwt.EncodingKey = key
+	ciphertext, err := gwt.AesGcmEncryptBin(md)
+	encodingKeyMu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(armorBegin + "\n" + kdfPrefix + kdf + "\n\n")
+	b64 := base64.StdEncoding.EncodeToString(ciphertext)
+	for len(b64) > armorColumns {
+		out.WriteString(b64[:armorColumns] + "\n")
+		b64 = b64[armorColumns:]
+	}
+	out.WriteString(b64 + "\n" + armorEnd + "\n")
+	return out.Bytes(), nil
+}
+
+// decryptMarkdown decrypts the ASCII armor produced by encryptMarkdown.
+func decryptMarkdown(secret string, armored []byte) ([]byte, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(armored))
+	var kdf string
+	var b64 strings.Builder
+	inside := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == armorBegin:
+			inside = true
+		case !inside || line == "":
+		case line == armorEnd:
+			inside = false
+		case strings.HasPrefix(line, kdfPrefix):
+			kdf = strings.TrimPrefix(line, kdfPrefix)
+		default:
+			b64.WriteString(line)
+		}
+	}
+	if kdf == "" || b64.Len() == 0 {
+		return nil, errors.New("not an encrypted markdown (missing " + armorBegin + ")")
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(b64.String())
+	if err != nil {
+		return nil, fmt.Errorf("corrupted encrypted markdown: %w", err)
+	}
+	if len(ciphertext) < 12+16 { // nonce + GCM tag
+		return nil, errors.New("corrupted encrypted markdown: too short")
+	}
+
+	key, err := armorKey(secret, kdf)
+	if err != nil {
+		return nil, err
+	}
+	encodingKeyMu.Lock()
+	gwt.EncodingKey = key
+	md, err := gwt.AesGcmDecryptBin(ciphertext)
+	encodingKeyMu.Unlock()
+	if err != nil {
+		return nil, errors.New("cannot decrypt the markdown: wrong passphrase/key or corrupted content")
+	}
+	return md, nil
+}
+
+// armorKey returns the AES key from the secret using the KDF line of the armor.
+func armorKey(secret, kdf string) ([]byte, error) {
+	fields := strings.Fields(kdf)
+	switch {
+	case len(fields) == 1 && fields[0] == kdfNone:
+		key := rawKey(secret)
+		if key == nil {
+			return nil, errors.New("the markdown was encrypted with a raw key: expected 64 hexadecimal digits")
+		}
+		return key, nil
+	case len(fields) == 3 && fields[0] == kdfPBKDF2:
+		iter, err := strconv.Atoi(fields[1])
+		if err != nil || iter <= 0 {
+			return nil, fmt.Errorf("invalid KDF iterations %q", fields[1])
+		}
+		salt, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid KDF salt: %w", err)
+		}
+		return pbkdf2.Key(sha256.New, secret, salt, iter, aesKeySize)
+	default:
+		return nil, fmt.Errorf("unsupported KDF %q", kdf)
+	}
+}
+
+// isEncrypted reports whether the markdown starts with the ASCII armor.
+func isEncrypted(head []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(head, " \t\r\n"), []byte(armorBegin))
+}
@@ -0,0 +1,84 @@
+// Copyright 2021 The contributors of Garcon.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{"a.go": "package a\n", "sub/b.txt": "secret sauce\n"})
+	mdPath := filepath.Join(t.TempDir(), "src.md")
+
+	gen := defaultConfig([]string{"-gen", "-regex", ".+", src, mdPath})
+	gen.encrypt = true
+	gen.secret = "correct horse battery staple"
+	err := gen.generateMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	armored, err := os.ReadFile(mdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isEncrypted(armored) || strings.Contains(string(armored), "secret sauce") {
+		t.Fatalf("want an encrypted armor, got:\n%s", armored)
+	}
+
+	dest := t.TempDir()
+	c := defaultConfig([]string{mdPath, dest})
+	err = c.extract()
+	if err == nil || !strings.Contains(err.Error(), "-decrypt") {
+		t.Fatalf("want error suggesting -decrypt, got %v", err)
+	}
+
+	c.decrypt = true
+	c.secret = "wrong"
+	err = c.extract()
+	if err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Fatalf("want wrong passphrase error, got %v", err)
+	}
+
+	c.secret = gen.secret
+	err = c.extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "sub", "b.txt"))
+	if err != nil || string(got) != "secret sauce\n" {
+		t.Fatalf("got %q err=%v", got, err)
+	}
+	err = c.verifyExtraction()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncryptRawKey(t *testing.T) {
+	t.Parallel()
+
+	key := strings.Repeat("0f", aesKeySize)
+	armored, err := encryptMarkdown(key, []byte("## File: a.go\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(armored), kdfPrefix+kdfNone) {
+		t.Errorf("want no KDF for a raw key:\n%s", armored)
+	}
+	md, err := decryptMarkdown(key, armored)
+	if err != nil || string(md) != "## File: a.go\n" {
+		t.Fatalf("md=%q err=%v", md, err)
+	}
+	_, err = decryptMarkdown("passphrase", armored)
+	if err == nil {
+		t.Error("want error when a passphrase is used for a raw key")
+	}
+}
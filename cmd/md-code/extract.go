@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	log "github.com/lynxai-team/emo"
@@ -21,17 +22,43 @@ import (
 func (c *Config) extract() error {
 	log.Printf("Extracting code blocs from %q -> %q", c.mdPath, c.folder)
 
+	if c.decrypt {
+		md, err := c.readMarkdown()
+		if err != nil {
+			return err
+		}
+		return c.extractFromReader(bytes.NewReader(md))
+	}
+
 	f, err := os.Open(c.mdPath)
 	if err != nil {
 		return fmt.Errorf("open %s: %w", c.mdPath, err)
 	}
 	defer f.Close()
 
-	return c.extractFromReader(f)
+	reader := bufio.NewReader(f)
+	head, _ := reader.Peek(len(armorBegin) + 8)
+	if isEncrypted(head) {
+		return fmt.Errorf("%s is encrypted: use -decrypt", c.mdPath)
+	}
+	return c.extractFromReader(reader)
+}
+
+// readMarkdown reads the input markdown, decrypted when -decrypt is set.
+func (c *Config) readMarkdown() ([]byte, error) {
+	data, err := os.ReadFile(c.mdPath)
+	if err != nil || !c.decrypt {
+		return data, err
+	}
+	md, err := decryptMarkdown(c.secret, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.mdPath, err)
+	}
+	return md, nil
 }
 
 func (c *Config) extractFromReader(reader io.Reader) error {
-	c.matcher = newMatcher(c.custom, c.fileRe)
+	c.matcher = newMatcher(c.custom, c.fileRe, c.matchers)
 
 	var lineNum int
 	var start int
@@ -101,6 +128,20 @@ func (c *Config) extractFromReader(reader io.Reader) error {
 	return nil
 }
 
+// allowedExt reports whether the extension of the filename is in the -allow-ext list.
+// The files without extension (Makefile, .bashrc...) are allowed only with -allow-ext '*'.
+func (c *Config) allowedExt(filename string) bool {
+	if c.allowExt == nil {
+		return true
+	}
+	base := filepath.Base(filename)
+	dot := strings.LastIndexByte(base, '.')
+	if dot <= 0 {
+		return false
+	}
+	return c.allowExt[strings.ToLower(base[dot+1:])]
+}
+
 // extractBloc creates the target file atomically, respects dry-run and
 // overwrite semantics and rejects any attempt to write outside of the output
 // folder (directory-traversal protection).
@@ -134,6 +175,18 @@ func (c *Config) extractBloc(data []byte, start, stop int) {
 		return
 	}
 
+	// Safe mode - reject the extensions not explicitly allowed (except in memory).
+	if c.sink == nil && !c.allowedExt(filename) {
+		log.Errorf("Skip %q because its extension is not in -allow-ext (%d lines) lang=%s %s:%d", filename, stop-start, c.matcher.lang, c.mdPath, start)
+		return
+	}
+
+	// Verification - keep the bloc in memory.
+	if c.sink != nil {
+		c.sink[sinkName(filename)] = slices.Clone(data)
+		return
+	}
+
 	// Dry-run - nothing to write.
 	if c.dryRun {
 		log.Checkf("dry-run %s (%d lines) lang=%s %s:%d", filename, stop-start, c.matcher.lang, c.mdPath, start)
@@ -159,9 +212,13 @@ func (c *Config) extractBloc(data []byte, start, stop int) {
 		}
 	}
 
-	err = os.WriteFile(cleanTarget, data, 0o600)
+	if c.writer != "" {
+		err = c.runWriter(cleanTarget, filename, data, start)
+	} else {
+		err = os.WriteFile(cleanTarget, data, 0o600)
+	}
 	if err != nil {
-		log.Errorf("os.WriteFile: %s - Skip %q (%d lines) lang=%s %s:%d", err, filename, stop-start, c.matcher.lang, c.mdPath, start)
+		log.Errorf("write: %s - Skip %q (%d lines) lang=%s %s:%d", err, filename, stop-start, c.matcher.lang, c.mdPath, start)
 		return
 	}
 
@@ -187,6 +187,38 @@ package main
 	assertNoFiles(t, dest)
 }
 
+// Safe mode - only the extensions of -allow-ext are written.
+func TestAllowExt(t *testing.T) {
+	t.Parallel()
+	md := "## File: main.go\n\n```go\npackage main\n```\n\n" +
+		"## File: install.sh\n\n```sh\nrm -rf /\n```\n\n" +
+		"## File: app.service\n\n```ini\n[Service]\n```\n\n" +
+		"## File: Makefile\n\n```make\nall:\n```\n"
+	mdPath := writeMD(t, md)
+
+	dest := t.TempDir()
+	c := defaultConfig([]string{mdPath, dest})
+	err := c.extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dest)
+	if err != nil || len(entries) != 1 || entries[0].Name() != "main.go" {
+		t.Fatalf("default -allow-ext must only write main.go, got %v %v", entries, err)
+	}
+
+	dest = t.TempDir()
+	c = defaultConfig([]string{"-allow-ext", "go, .SH", mdPath, dest})
+	err = c.extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertFileExists(t, filepath.Join(dest, "install.sh"), "rm -rf /\n")
+	if c.count != 2 {
+		t.Errorf("want main.go and install.sh, got %d files", c.count)
+	}
+}
+
 // 8️⃣  All flag - extract blocs without filename.
 func TestAllFlag(t *testing.T) {
 	t.Parallel()
@@ -5,6 +5,7 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
@@ -150,11 +151,15 @@ func (c *Config) generateMarkdown() error {
 	log.Printf("Generating markdown %s from folder %s", c.mdPath, c.folder)
 
 	var w *bufio.Writer
+	var plain bytes.Buffer // markdown to encrypt (-encrypt)
+	p := newProgress(os.Stderr, c.progress, c.folder, c.all)
+	c.report = p
 
 	// Walk the folder tree in lexical order for deterministic output.
 	err := filepath.WalkDir(c.folder, func(path string, entry fs.DirEntry, walkErr error) error {
 		if walkErr != nil {
 			log.Stopf("SKIP file %q because err: %s", path, walkErr)
+			p.skip(skipWalkError)
 			return nil
 		}
 
@@ -162,10 +167,12 @@ func (c *Config) generateMarkdown() error {
 			if !c.all {
 				if entry.Name()[0] == '.' {
 					log.Stopf("SKIP dot %q, use --all to include it", path)
+					p.skip(skipFolder)
 					return filepath.SkipDir
 				}
 				if slices.Contains(ignoreFolders, entry.Name()) {
 					log.Stopf("SKIP folder %q, use --all to include it", path)
+					p.skip(skipFolder)
 					return filepath.SkipDir
 				}
 			}
@@ -175,6 +182,7 @@ func (c *Config) generateMarkdown() error {
 
 		if !c.custom.MatchString(path) {
 			log.Stopf("SKIP file %q does not match regex %q", path, c.custom)
+			p.skip(skipRegex)
 			return nil
 		}
 
@@ -182,15 +190,18 @@ func (c *Config) generateMarkdown() error {
 		if !c.all {
 			if entry.Name()[0] == '.' {
 				log.Stopf("SKIP dot %q, use --all to include it", path)
+				p.skip(skipDot)
 				return nil
 			}
 			if slices.Contains(ignoreFiles, entry.Name()) {
 				log.Stopf("SKIP file %q, use --all to include it", path)
+				p.skip(skipIgnored)
 				return nil
 			}
 			for _, suffix := range ignoreSuffixes {
 				if strings.HasSuffix(entry.Name(), suffix) {
 					log.Stopf("SKIP file %q (ext %s), use --all to include it", path, suffix)
+					p.skip(skipSuffix)
 					return nil
 				}
 			}
@@ -199,20 +210,24 @@ func (c *Config) generateMarkdown() error {
 		isBinary, err := isBinaryFile(path)
 		if err != nil {
 			log.Stopf("SKIP file %q cannot be accessed err=%s", path, err)
+			p.skip(skipUnread)
 			return nil
 		}
 		if isBinary {
 			log.Stopf("SKIP file %q is binary (first bytes are not UTF-8)", path)
+			p.skip(skipBinary)
 			return nil
 		}
 
 		abs, err := filepath.Abs(path)
 		if err != nil {
 			log.Stopf("SKIP file %q ERROR filepath.Abs: %s", path, err)
+			p.skip(skipWalkError)
 			return nil // should never happen
 		}
 		if abs == c.mdPath {
 			log.Stopf("SKIP output file %q", path)
+			p.skip(skipOutput)
 			return nil
 		}
 
@@ -220,6 +235,7 @@ func (c *Config) generateMarkdown() error {
 		rel, err := filepath.Rel(c.folder, path)
 		if err != nil {
 			log.Stopf("SKIP file %q ERROR filepath.Rel: %s", path, err)
+			p.skip(skipWalkError)
 			return nil // should never happen
 		}
 		rel = filepath.ToSlash(rel)
@@ -238,12 +254,19 @@ func (c *Config) generateMarkdown() error {
 						return fmt.Errorf("output file %s already exists (use -overwrite to replace)", c.mdPath)
 					}
 				}
-				f, err := os.Create(c.mdPath)
-				if err != nil {
-					return fmt.Errorf("create %s: %w", c.mdPath, err)
+				if c.encrypt {
+					out = &plain // encrypted and written at the end
+				} else {
+					f, err := os.Create(c.mdPath)
+					if err != nil {
+						return fmt.Errorf("create %s: %w", c.mdPath, err)
+					}
+					// defer f.Close()
+					out = f
 				}
-				// defer f.Close()
-				out = f
+			}
+			if c.tee != nil {
+				out = io.MultiWriter(out, c.tee)
 			}
 			w = bufio.NewWriter(out)
 		}
@@ -262,6 +285,7 @@ func (c *Config) generateMarkdown() error {
 		}
 
 		// Stream file contents into the markdown.
+		var size int64
 		f, err := os.Open(path)
 		if err != nil {
 			fmt.Fprintf(w, "error os.Open(%s) %v\n", path, err)
@@ -269,7 +293,8 @@ func (c *Config) generateMarkdown() error {
 			// If we cannot read a file, just skip it.
 			return nil
 		} else {
-			_, copyErr := io.Copy(w, f)
+			var copyErr error
+			size, copyErr = io.Copy(w, f)
 			closeErr := f.Close()
 			if copyErr != nil {
 				log.Warnf("error os.Copy %q %v\n", path, copyErr)
@@ -286,8 +311,11 @@ func (c *Config) generateMarkdown() error {
 		}
 
 		c.count++
+		c.files = append(c.files, rel)
+		p.include(size)
 		return nil
 	})
+	p.summary()
 
 	if err != nil {
 		return fmt.Errorf("walk %s: %w", c.folder, err)
@@ -300,6 +328,18 @@ func (c *Config) generateMarkdown() error {
 		}
 	}
 
+	if c.encrypt && plain.Len() > 0 {
+		armored, err := encryptMarkdown(c.secret, plain.Bytes())
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(c.mdPath, armored, 0o600)
+		if err != nil {
+			return fmt.Errorf("write %s: %w", c.mdPath, err)
+		}
+		log.Printf("Encrypted markdown %s (%d bytes)", c.mdPath, len(armored))
+	}
+
 	return nil
 }
 
@@ -8,8 +8,10 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -25,6 +27,9 @@ const (
 	defaultFence  = "```"
 	defaultHeader = "## File: "
 	defaultRegex  = "[\\/A-Za-z0-9._-]{3,}[A-Za-z0-9]\\b"
+	// defaultAllowExt excludes the executable and configuration files (sh, service, desktop...)
+	// that untrusted markdown could use to run code on the host.
+	defaultAllowExt = "go,mod,sum,md,txt,ts,tsx,js,jsx,mjs,css,scss,html,svg,json,yaml,yml,toml,py,rs,java,kt,c,h,cpp,hpp,cs,sql,proto,graphql,csv"
 
 	usage = `md-code - extract or generate fenced code blocs.
 
@@ -74,6 +79,17 @@ This can be changed with -header <text>.
   md-code -gen -header "` + "#" + `# ` + "(" + `"  =>  "## (path/file.go)"
   md-code -gen -header "` + "#" + `# ` + "*" + `*" =>  "## **path/file.go**"
 
+Share an encrypted snapshot (passphrase from $MD_CODE_PASSPHRASE or -key-file):
+
+  md-code -gen -encrypt src src.md
+  md-code -decrypt src.md out
+
+Extract untrusted markdown: only the files having an extension
+of -allow-ext are written (the default set excludes the scripts,
+the systemd units and the desktop entries). Allow the shell scripts:
+
+  md-code -allow-ext go,md,sh untrusted.md out
+
 OPTIONS
 
 `
@@ -84,7 +100,15 @@ OPTIONS
 type Config struct {
 	custom    *regexp.Regexp
 	matcher   *matcher
+	matchers  []*regexp.Regexp // user matchers loaded from -matchers
 	fileRe    string
+	writer    string            // shell command writing each extracted file (-writer)
+	secret    string            // passphrase or hexadecimal AES-256 key (-encrypt/-decrypt)
+	report    *progress         // statistics of the last generation
+	sink      map[string][]byte // in-memory extraction (verify), nil means write the files
+	tee       io.Writer         // copy of the generated markdown (verify)
+	files     []string          // files included by the generation
+	allowExt  map[string]bool   // extensions allowed by the extraction, nil means any (-allow-ext '*')
 	mdPath    string
 	folder    string
 	fence     string
@@ -92,7 +116,11 @@ type Config struct {
 	all       bool
 	dryRun    bool
 	overwrite bool
-	count     int // number of generated/extracted files
+	verify    bool
+	encrypt   bool
+	decrypt   bool
+	progress  bool // show the generation progress on stderr
+	count     int  // number of generated/extracted files
 }
 
 // defaultConfig creates a stub configuration for testing.
@@ -106,13 +134,21 @@ func defaultConfig(arguments []string) *Config {
 // It aborts the program with a helpful message on any error.
 func parseFlags(flags *flag.FlagSet, arguments []string) (bool, *Config) {
 	var (
-		fence     = flags.String("fence", defaultFence, "fence used to delimit code blocs (must be ≥3 backticks)")
-		header    = flags.String("header", defaultHeader, "text printed before each generated code bloc")
-		regex     = flags.String("regex", defaultRegex, "regular expression that a filename must match")
-		all       = flags.Bool("all", false, "extract code blocs that have no explicit filename")
-		dryRun    = flags.Bool("dry-run", false, "run without writing any files")
-		gen       = flags.Bool("gen", false, "generate a markdown file from a folder tree")
-		overwrite = flags.Bool("overwrite", false, "overwrite existing files")
+		fence      = flags.String("fence", defaultFence, "fence used to delimit code blocs (must be ≥3 backticks)")
+		header     = flags.String("header", defaultHeader, "text printed before each generated code bloc")
+		regex      = flags.String("regex", defaultRegex, "regular expression that a filename must match")
+		all        = flags.Bool("all", false, "extract code blocs that have no explicit filename")
+		dryRun     = flags.Bool("dry-run", false, "run without writing any files")
+		gen        = flags.Bool("gen", false, "generate a markdown file from a folder tree")
+		overwrite  = flags.Bool("overwrite", false, "overwrite existing files")
+		matchers   = flags.String("matchers", "", "file of extra filename regexes, one per line ({file} = filename capture group)")
+		verify     = flags.Bool("verify", false, "run the opposite conversion in memory and fail if the round trip is lossy")
+		writer     = flags.String("writer", "", "shell command writing each extracted file from stdin (see $MD_CODE_FILE)")
+		encrypt    = flags.Bool("encrypt", false, "generation: encrypt the markdown (AES-256-GCM, passphrase from $"+envPassphrase+")")
+		decrypt    = flags.Bool("decrypt", false, "extraction: decrypt the markdown produced by -gen -encrypt")
+		noProgress = flags.Bool("no-progress", false, "generation: do not show the progress on stderr (CI), the summary is still printed")
+		keyFile    = flags.String("key-file", "", "file containing the passphrase or the 64 hex digits AES-256 key (default $"+envPassphrase+")")
+		allowExt   = flags.String("allow-ext", defaultAllowExt, "extraction: comma-separated extensions of the files allowed to be written, '*' allows any file")
 	)
 	vv.SetCustomVersionFlag(flags, "", "")
 	flags.Usage = func() { fmt.Fprintf(flags.Output(), usage); flags.PrintDefaults() }
@@ -208,7 +244,36 @@ func parseFlags(flags *flag.FlagSet, arguments []string) (bool, *Config) {
 		log.Fatalf("regexp.Compile(%s): %v", expr, err)
 	}
 
+	if *encrypt && !*gen {
+		flags.Usage()
+		log.Fatal("-encrypt requires -gen (use -decrypt to extract an encrypted markdown)")
+	}
+	if *decrypt && *gen {
+		flags.Usage()
+		log.Fatal("-decrypt is for the extraction mode (use -encrypt with -gen)")
+	}
+	var secret string
+	if *encrypt || *decrypt {
+		secret, err = readSecret(*keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var userExprs []*regexp.Regexp
+	if *matchers != "" && !*gen {
+		userExprs, err = loadMatchers(*matchers, *regex)
+		if err != nil {
+			log.Fatalf("-matchers %s: %v", *matchers, err)
+		}
+		log.Printf("Loaded %d filename matchers from %s", len(userExprs), *matchers)
+	}
+
 	c := &Config{
+		allowExt:  parseAllowExt(*allowExt),
+		matchers:  userExprs,
+		writer:    *writer,
+		secret:    secret,
 		mdPath:    absPath,
 		folder:    absFolder,
 		fence:     *fence,
@@ -218,16 +283,40 @@ func parseFlags(flags *flag.FlagSet, arguments []string) (bool, *Config) {
 		all:       *all,
 		dryRun:    *dryRun,
 		overwrite: *overwrite,
+		verify:    *verify,
+		encrypt:   *encrypt,
+		decrypt:   *decrypt,
+		progress:  !*noProgress,
 	}
 
 	return *gen, c
 }
 
+// parseAllowExt converts "go,.md, ts" to a set of lower-case extensions without dot.
+// "*" allows any extension (returns nil).
+func parseAllowExt(list string) map[string]bool {
+	allow := make(map[string]bool)
+	for ext := range strings.SplitSeq(list, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext == "*" {
+			return nil
+		}
+		if ext != "" {
+			allow[ext] = true
+		}
+	}
+	return allow
+}
+
 // main entry point.
 func main() {
 	gen, c := parseFlags(flag.CommandLine, os.Args[1:])
 
 	if gen {
+		var md bytes.Buffer
+		if c.verify {
+			c.tee = &md
+		}
 		err := c.generateMarkdown()
 		if err != nil {
 			log.Fatalf("generation failed: %v", err)
@@ -237,6 +326,12 @@ func main() {
 		} else {
 			log.NotFoundf("Nothing generated: no file with regex=%s in path %q", c.fileRe, c.folder)
 		}
+		if c.verify {
+			err = c.verifyGeneration(md.Bytes())
+			if err != nil {
+				log.Fatalf("verify: %v", err)
+			}
+		}
 		return
 	}
 
@@ -245,6 +340,12 @@ func main() {
 		log.Fatalf("extraction failed: %v", err)
 	}
 	log.Resultf("Extracted %d files in %s", c.count, c.folder)
+	if c.verify {
+		err = c.verifyExtraction()
+		if err != nil {
+			log.Fatalf("verify: %v", err)
+		}
+	}
 
 	results, err := collectResults(c.folder)
 	if err != nil {
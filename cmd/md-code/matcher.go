@@ -13,29 +13,31 @@ import (
 // in the two lines preceding a fenced bloc.  The patterns are ordered from most
 // specific to most generic.
 type matcher struct {
-	exprs [5]*regexp.Regexp // compiled regexes
-	prev  [5]string         // buffer with two lines before + one line after
-	lang  string            // language tag of the opening fence
-	idx   int               // index of the next slot in prev
+	exprs []*regexp.Regexp // compiled regexes
+	prev  [5]string        // buffer with two lines before + one line after
+	lang  string           // language tag of the opening fence
+	idx   int              // index of the next slot in prev
 }
 
 // newMatcher builds a matcher based on the current Config.
-func newMatcher(custom *regexp.Regexp, fileRe string) *matcher {
+// The user matchers (-matchers file) are tried after the header pattern
+// and before the built-in patterns.
+func newMatcher(custom *regexp.Regexp, fileRe string, user []*regexp.Regexp) *matcher {
 	// The header pattern uses the user-supplied header text verbatim.
+	exprs := append([]*regexp.Regexp{custom}, user...)
 	return &matcher{
-		exprs: [5]*regexp.Regexp{
-			custom,
-			regexp.MustCompile(`\b[Ff]ile: (` + fileRe + `)\b`),
-			regexp.MustCompile("[( ]`(" + fileRe + ")`"),
-			regexp.MustCompile(`^// (` + fileRe + `)$`),
-			regexp.MustCompile(`^#+ (` + fileRe + `)$`),
+		exprs: append(exprs,
+			regexp.MustCompile(`\b[Ff]ile: (`+fileRe+`)\b`),
+			regexp.MustCompile("[( ]`("+fileRe+")`"),
+			regexp.MustCompile(`^// (`+fileRe+`)$`),
+			regexp.MustCompile(`^#+ (`+fileRe+`)$`),
 			// regexp.MustCompile(`^\*\*(` + fileRe + `)\*\*$`),
 			// regexp.MustCompile(`^#+\s+(` + fileRe + `)`),
 			// regexp.MustCompile("^#+[\\s0-9.]*\\s+`(" + fileRe + ")`"),
 			// regexp.MustCompile(`^//\s+(` + fileRe + `) - `),
 			// regexp.MustCompile(`^#+\s+\((` + fileRe + `)\)$`),
 			// regexp.MustCompile(`^#*\s*\*\*(` + fileRe + `)\*\*`),
-		},
+		),
 	}
 }
 
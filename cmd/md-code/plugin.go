@@ -0,0 +1,88 @@
+// Copyright 2021 The contributors of Garcon.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filePlaceholder is replaced by the filename capture group in the custom matchers.
+const filePlaceholder = "{file}"
+
+// loadMatchers reads the custom filename matchers: one regular expression per line,
+// blank lines and lines starting with # are ignored.
+// The placeholder {file} is replaced by a capture group of the filename regex (-regex),
+// else the expression must contain its own capture group for the filename:
+//
+//	# Listing 3.2: path/file.go
+//	^Listing [0-9.]+: {file}$
+//	^<!-- save as (\S+) -->$
+func loadMatchers(path, fileRe string) ([]*regexp.Regexp, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMatchers(f, fileRe)
+}
+
+func parseMatchers(r io.Reader, fileRe string) ([]*regexp.Regexp, error) {
+	var exprs []*regexp.Regexp
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		expr := strings.ReplaceAll(line, filePlaceholder, "("+fileRe+")")
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", n, err))
+			continue
+		}
+		if re.NumSubexp() < 1 {
+			errs = append(errs, fmt.Errorf("line %d: %q has no capture group for the filename (use %s)", n, line, filePlaceholder))
+			continue
+		}
+		exprs = append(exprs, re)
+	}
+
+	errs = append(errs, scanner.Err())
+	return exprs, errors.Join(errs...)
+}
+
+// runWriter delegates the writing of an extracted bloc to the user command (-writer).
+// The command is run by "sh -c" with the bloc on its standard input
+// and the environment variables:
+//
+//	MD_CODE_FILE    destination path (the directory already exists)
+//	MD_CODE_NAME    filename as found in the Markdown
+//	MD_CODE_LANG    language tag of the fence
+//	MD_CODE_SOURCE  Markdown path
+//	MD_CODE_LINE    line of the opening fence
+func (c *Config) runWriter(target, filename string, data []byte, start int) error {
+	cmd := exec.Command("sh", "-c", c.writer)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"MD_CODE_FILE="+target,
+		"MD_CODE_NAME="+filename,
+		"MD_CODE_LANG="+c.matcher.lang,
+		"MD_CODE_SOURCE="+c.mdPath,
+		"MD_CODE_LINE="+strconv.Itoa(start),
+	)
+	return cmd.Run()
+}
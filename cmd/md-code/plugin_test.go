@@ -0,0 +1,79 @@
+// Copyright 2021 The contributors of Garcon.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchersFile(t *testing.T) {
+	t.Parallel()
+
+	exprs, err := parseMatchers(strings.NewReader(`
+# book conventions
+^Listing [0-9.]+: {file}$
+^<!-- save as (\S+) -->$
+`), defaultRegex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exprs) != 2 {
+		t.Fatalf("got %d matchers, want 2", len(exprs))
+	}
+
+	md := "Listing 3.2: cmd/app/main.go\n\n```go\npackage main\n```\n"
+	mdPath := writeMD(t, md)
+	dest := t.TempDir()
+	c := defaultConfig([]string{mdPath, dest})
+	c.matchers = exprs
+
+	err = c.extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(dest, "cmd", "app", "main.go"))
+	if err != nil {
+		t.Fatalf("file has not been extracted: %v", err)
+	}
+}
+
+func TestMatchersFileInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := parseMatchers(strings.NewReader("^Listing: no-group$\n^bad[$\n"), defaultRegex)
+	if err == nil {
+		t.Fatal("want error")
+	}
+	for _, want := range []string{"line 1", "line 2"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not report %s", err, want)
+		}
+	}
+}
+
+func TestWriterHook(t *testing.T) {
+	t.Parallel()
+
+	md := "## File: hello.go\n\n```go\npackage main\n```\n"
+	mdPath := writeMD(t, md)
+	dest := t.TempDir()
+	c := defaultConfig([]string{mdPath, dest})
+	c.writer = `{ echo "// $MD_CODE_NAME ($MD_CODE_LANG) line $MD_CODE_LINE"; cat; } > "$MD_CODE_FILE"`
+
+	err := c.extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(filepath.Join(dest, "hello.go"))
+	if err != nil {
+		t.Fatalf("file has not been written by the hook: %v", err)
+	}
+	want := "// hello.go (go) line 3\npackage main\n"
+	if string(got) != want {
+		t.Errorf("got %q want %q", got, want)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2021 The contributors of Garcon.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Skip reasons reported in the generation summary.
+const (
+	skipWalkError = "walk error"
+	skipFolder    = "ignored folders (not files)"
+	skipRegex     = "not matching -regex"
+	skipDot       = "dot files"
+	skipIgnored   = "ignored names"
+	skipSuffix    = "ignored extensions"
+	skipUnread    = "unreadable"
+	skipBinary    = "binary"
+	skipOutput    = "output file"
+)
+
+// progress reports the generation of large folders on stderr
+// (files processed, bytes, ETA) and prints the final summary.
+type progress struct {
+	out      io.Writer
+	skipped  map[string]int
+	start    time.Time
+	last     time.Time
+	total    int // files found by the pre-scan, zero when the progress is not shown
+	files    int // processed files: included + skipped
+	included int
+	bytes    int64
+	show     bool
+	tty      bool
+}
+
+// newProgress pre-scans the folder to estimate the ETA when show is true.
+func newProgress(out io.Writer, show bool, folder string, all bool) *progress {
+	p := &progress{
+		out:     out,
+		skipped: map[string]int{},
+		start:   time.Now(),
+		show:    show,
+	}
+	if f, ok := out.(*os.File); ok {
+		fi, err := f.Stat()
+		p.tty = err == nil && fi.Mode()&os.ModeCharDevice != 0
+	}
+	if show {
+		p.total = countFiles(folder, all)
+	}
+	return p
+}
+
+// countFiles counts the files that generateMarkdown will visit (same folder rules).
+func countFiles(folder string, all bool) int {
+	n := 0
+	filepath.WalkDir(folder, func(_ string, entry fs.DirEntry, err error) error {
+		switch {
+		case err != nil:
+		case !entry.IsDir():
+			n++
+		case !all && (entry.Name()[0] == '.' || slices.Contains(ignoreFolders, entry.Name())):
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return n
+}
+
+func (p *progress) skip(reason string) {
+	p.skipped[reason]++
+	if reason != skipFolder {
+		p.files++
+	}
+	p.refresh()
+}
+
+func (p *progress) include(size int64) {
+	p.included++
+	p.files++
+	p.bytes += size
+	p.refresh()
+}
+
+// refresh prints the progress line, at most every 200 ms on a terminal
+// and every 5 seconds otherwise (CI logs).
+func (p *progress) refresh() {
+	if !p.show {
+		return
+	}
+	interval := 5 * time.Second
+	if p.tty {
+		interval = 200 * time.Millisecond
+	}
+	now := time.Now()
+	if now.Sub(p.last) < interval {
+		return
+	}
+	p.last = now
+
+	line := fmt.Sprintf("%d/%d files %s", p.files, p.total, humanBytes(p.bytes))
+	if p.files > 0 && p.total > p.files {
+		elapsed := now.Sub(p.start)
+		eta := time.Duration(float64(elapsed) * float64(p.total-p.files) / float64(p.files))
+		line += " ETA " + eta.Round(time.Second).String()
+	}
+	if p.tty {
+		fmt.Fprint(p.out, "\r"+line+"\x1b[K")
+	} else {
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// summary ends the progress line and prints the included and skipped files by reason.
+func (p *progress) summary() {
+	if p.show && p.tty && !p.last.IsZero() {
+		fmt.Fprintln(p.out)
+	}
+
+	n := 0
+	for reason, count := range p.skipped {
+		if reason != skipFolder {
+			n += count
+		}
+	}
+	fmt.Fprintf(p.out, "Summary: %d files included (%s), %d skipped, in %s\n",
+		p.included, humanBytes(p.bytes), n, time.Since(p.start).Round(time.Millisecond))
+
+	reasons := make([]string, 0, len(p.skipped))
+	for reason := range p.skipped {
+		reasons = append(reasons, reason)
+	}
+	slices.SortFunc(reasons, func(a, b string) int {
+		if p.skipped[a] != p.skipped[b] {
+			return p.skipped[b] - p.skipped[a]
+		}
+		return strings.Compare(a, b)
+	})
+	for _, reason := range reasons {
+		fmt.Fprintf(p.out, "  %6d %s\n", p.skipped[reason], reason)
+	}
+}
+
+// humanBytes formats a size using the binary prefixes.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
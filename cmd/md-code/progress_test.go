@@ -0,0 +1,59 @@
+// Copyright 2021 The contributors of Garcon.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerationSummary(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a.go":              "package a\n",
+		"b.txt":             "hello\n",
+		".env":              "X=1\n",
+		"node_modules/x.js": "x\n",
+	})
+	err := os.WriteFile(filepath.Join(src, "img.bin"), []byte{0xff, 0xfe, 0x00}, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := defaultConfig([]string{"-gen", "-dry-run", "-regex", ".+", src})
+	err = c.generateMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := c.report
+	if p.total != 4 || p.files != 4 || p.included != 2 || p.bytes != int64(len("package a\nhello\n")) {
+		t.Errorf("total=%d files=%d included=%d bytes=%d", p.total, p.files, p.included, p.bytes)
+	}
+	if p.skipped[skipDot] != 1 || p.skipped[skipBinary] != 1 || p.skipped[skipFolder] != 1 {
+		t.Errorf("skipped=%v", p.skipped)
+	}
+
+	var out bytes.Buffer
+	p.out = &out
+	p.summary()
+	if !strings.HasPrefix(out.String(), "Summary: 2 files included (16 B), 2 skipped") {
+		t.Errorf("summary:\n%s", out.String())
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	t.Parallel()
+
+	for n, want := range map[int64]string{0: "0 B", 1023: "1023 B", 1536: "1.5 KiB", 5 << 30: "5.0 GiB"} {
+		if got := humanBytes(n); got != want {
+			t.Errorf("humanBytes(%d)=%q want %q", n, got, want)
+		}
+	}
+}
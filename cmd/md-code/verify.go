@@ -0,0 +1,140 @@
+// Copyright 2021 The contributors of Garcon.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	log "github.com/lynxai-team/emo"
+)
+
+// verifyExtraction regenerates in memory a Markdown from the output folder,
+// extracts it again and compares the files with the code blocs of the input Markdown.
+func (c *Config) verifyExtraction() error {
+	if c.dryRun {
+		log.Warn("verify: nothing to verify in dry-run mode")
+		return nil
+	}
+
+	input, err := c.readMarkdown()
+	if err != nil {
+		return err
+	}
+	want, err := c.extractInMemory(input, c.custom)
+	if err != nil {
+		return err
+	}
+
+	var md bytes.Buffer
+	gen := *c
+	gen.custom, err = regexp.Compile(c.fileRe)
+	if err != nil {
+		return err
+	}
+	gen.all = true // the extracted dot files must be regenerated too
+	gen.dryRun = true
+	gen.tee = &md
+	err = gen.generateMarkdown()
+	if err != nil {
+		return err
+	}
+
+	got, err := c.extractInMemory(md.Bytes(), nil)
+	if err != nil {
+		return err
+	}
+	return compareRoundTrip(want, got)
+}
+
+// verifyGeneration extracts in memory the generated Markdown
+// and compares the code blocs with the source files.
+func (c *Config) verifyGeneration(md []byte) error {
+	want := make(map[string][]byte, len(c.files))
+	for _, rel := range c.files {
+		data, err := os.ReadFile(filepath.Join(c.folder, filepath.FromSlash(rel)))
+		if err != nil {
+			return err
+		}
+		want[rel] = data
+	}
+
+	got, err := c.extractInMemory(md, nil)
+	if err != nil {
+		return err
+	}
+	return compareRoundTrip(want, got)
+}
+
+// extractInMemory returns the code blocs (filename -> content) of the Markdown.
+// A nil custom regex means the filename lines written by the generation (-header).
+func (c *Config) extractInMemory(md []byte, custom *regexp.Regexp) (map[string][]byte, error) {
+	x := *c
+	x.custom = custom
+	if custom == nil {
+		var err error
+		x.custom, err = regexp.Compile(genFilenameLine(regexp.QuoteMeta(c.header), c.fileRe))
+		if err != nil {
+			return nil, err
+		}
+	}
+	x.sink = make(map[string][]byte)
+	x.writer = ""
+	err := x.extractFromReader(bytes.NewReader(md))
+	return x.sink, err
+}
+
+// compareRoundTrip reports the files missing or different after the round trip.
+// The trailing newlines are not significant.
+func compareRoundTrip(want, got map[string][]byte) error {
+	var report []string
+	names := make([]string, 0, len(want))
+	for name := range want {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		g, ok := got[name]
+		if !ok {
+			report = append(report, "missing "+name)
+			continue
+		}
+		w := bytes.TrimRight(want[name], "\r\n")
+		g = bytes.TrimRight(g, "\r\n")
+		if !bytes.Equal(w, g) {
+			report = append(report, fmt.Sprintf("differs %s from line %d", name, firstDiffLine(w, g)))
+		}
+	}
+
+	if len(report) > 0 {
+		return fmt.Errorf("lossy round trip (%d/%d files):\n  %s", len(report), len(want), strings.Join(report, "\n  "))
+	}
+	log.Resultf("verify: round trip OK for %d files", len(want))
+	return nil
+}
+
+func firstDiffLine(a, b []byte) int {
+	line := 1
+	for i := range min(len(a), len(b)) {
+		if a[i] != b[i] {
+			return line
+		}
+		if a[i] == '\n' {
+			line++
+		}
+	}
+	return line
+}
+
+// sinkName normalizes the filename used as key of Config.sink.
+func sinkName(filename string) string {
+	return path.Clean(filepath.ToSlash(filename))
+}
@@ -0,0 +1,68 @@
+// Copyright 2021 The contributors of Garcon.
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyExtraction(t *testing.T) {
+	t.Parallel()
+
+	md := "## File: a.go\n\n```go\npackage a\n```\n\n## File: sub/.env\n\n```sh\nX=1\n```\n"
+	mdPath := writeMD(t, md)
+	dest := t.TempDir()
+	c := defaultConfig([]string{"-allow-ext", "*", mdPath, dest}) // .env is not in the default -allow-ext
+
+	err := c.extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.verifyExtraction()
+	if err != nil {
+		t.Fatalf("round trip should be OK: %v", err)
+	}
+
+	// the existing file is kept (no -overwrite) => lossy
+	err = os.WriteFile(filepath.Join(dest, "a.go"), []byte("package b\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.verifyExtraction()
+	if err == nil || !strings.Contains(err.Error(), "differs a.go from line 1") {
+		t.Fatalf("want lossy a.go, got %v", err)
+	}
+}
+
+func TestVerifyGeneration(t *testing.T) {
+	t.Parallel()
+
+	src := t.TempDir()
+	err := os.WriteFile(filepath.Join(src, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a lone closing fence inside a file breaks the round trip
+	err = os.WriteFile(filepath.Join(src, "doc.md"), []byte("text\n```\nmore\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := defaultConfig([]string{"-gen", "-dry-run", src})
+	var md bytes.Buffer
+	flags.tee = &md
+	err = flags.generateMarkdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = flags.verifyGeneration(md.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "doc.md") || strings.Contains(err.Error(), "main.go") {
+		t.Fatalf("want lossy doc.md only, got %v", err)
+	}
+}
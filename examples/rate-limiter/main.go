@@ -7,6 +7,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -51,44 +53,51 @@ const (
 
 	// MaxBytesToRead prevents wasting memory/CPU when receiving an abnormally huge response from Deribit API.
 	maxBytesToRead = 2_000_000
+
+	// instrumentsTTL is the duration the instruments are reused before being revalidated.
+	instrumentsTTL = 10 * time.Second
 )
 
 var log = emo.NewZone("app")
 
 func main() {
 	ar := gc.NewAdaptiveRate("Deribit", adaptiveMinSleepTime)
+	ar.Cache = gc.NewResponseCache(instrumentsTTL) // the instruments rarely change
+	coins := []string{"BTC", "ETH", "SOL"}
 	count := 0
 	for range 1000 {
-		instruments, err := query(ar, "BTC")
-		if err != nil {
-			log.Fatal(err)
-		}
-		count += instruments
-		instruments, err = query(ar, "ETH")
-		if err != nil {
-			log.Fatal(err)
-		}
-		count += instruments
-		instruments, err = query(ar, "SOL")
+		instruments, err := query(&ar, coins)
 		if err != nil {
 			log.Fatal(err)
 		}
 		count += instruments
 	}
+	ar.LogStats()
+	ar.Cache.LogStats()
 	fmt.Printf("fetched %d instruments from Deribit \n", count)
 }
 
-func query(ar gc.AdaptiveRate, coin string) (int, error) {
+// query fetches the instruments of the coins concurrently.
+func query(ar *gc.AdaptiveRate, coins []string) (int, error) {
 	const api = "https://deribit.com/api/v2/public/get_instruments?currency="
 	const opts = "&expired=false&kind=option"
-	url := api + coin + opts
-	log.Info("Deribit " + url)
 
-	var result instrumentsResult
-	err := ar.Get(coin, url, &result, maxBytesToRead)
+	results := make([]instrumentsResult, len(coins))
+	requests := make([]gc.Req, len(coins))
+	for i, coin := range coins {
+		url := api + coin + opts
+		log.Info("Deribit " + url)
+		requests[i] = gc.Req{Symbol: coin, URL: url, Msg: &results[i], MaxBytes: maxBytesToRead}
+	}
+
+	err := errors.Join(ar.GetParallel(context.Background(), requests, len(coins))...)
 	if err != nil {
 		return 0, err
 	}
 
-	return len(result.Result), nil
+	count := 0
+	for _, r := range results {
+		count += len(r.Result)
+	}
+	return count, nil
 }
@@ -0,0 +1,172 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type (
+	// ConnTracker follows the state of the HTTP connections (http.Server.ConnState)
+	// to count them and to close the idle ones during the shutdown:
+	//
+	//	ct := gc.NewConnTracker()
+	//	g.ServerName.ExportConnTracker(ct) // gauges and counters on /metrics
+	//	server := gc.Server(h, 8080, ct.ConnState)
+	//	...
+	//	server.SetKeepAlivesEnabled(false)
+	//	err := ct.Drain(ctx) // closes the idle connections until all are closed
+	//
+	// The hijacked connections (WebSocket...) are no longer tracked.
+	ConnTracker struct {
+		conns       map[net.Conn]http.ConnState
+		transitions [http.StateClosed + 1]atomic.Uint64
+		mu          sync.Mutex
+	}
+
+	// ConnStats are the current connections and the number of transitions per state
+	// ("new", "active", "idle", "hijacked" and "closed").
+	ConnStats struct {
+		Transitions map[string]uint64 `json:"transitions"`
+		Open        int               `json:"open"`
+		Active      int               `json:"active"`
+		Idle        int               `json:"idle"`
+	}
+)
+
+// drainPeriod is the interval between two closings of the idle connections in Drain.
+const drainPeriod = 50 * time.Millisecond
+
+// NewConnTracker creates a ConnTracker, see also ServerName.ExportConnTracker.
+func NewConnTracker() *ConnTracker {
+	return &ConnTracker{conns: make(map[net.Conn]http.ConnState)}
+}
+
+// ConnState is the http.Server.ConnState callback.
+func (ct *ConnTracker) ConnState(c net.Conn, cs http.ConnState) {
+	if cs >= 0 && int(cs) < len(ct.transitions) {
+		ct.transitions[cs].Add(1)
+	}
+	ct.mu.Lock()
+	switch cs {
+	case http.StateHijacked, http.StateClosed:
+		delete(ct.conns, c)
+	default:
+		ct.conns[c] = cs
+	}
+	ct.mu.Unlock()
+}
+
+// Stats returns the number of connections in each state and the transition counters.
+func (ct *ConnTracker) Stats() ConnStats {
+	s := ConnStats{Transitions: make(map[string]uint64, len(ct.transitions))}
+	for cs := range ct.transitions {
+		s.Transitions[http.ConnState(cs).String()] = ct.transitions[cs].Load()
+	}
+
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	s.Open = len(ct.conns)
+	for _, cs := range ct.conns {
+		switch cs {
+		case http.StateActive:
+			s.Active++
+		case http.StateIdle:
+			s.Idle++
+		}
+	}
+	return s
+}
+
+// CloseIdle closes the idle (keep-alive) connections and returns their number.
+// The connections are marked closed by the server when it detects the closing.
+func (ct *ConnTracker) CloseIdle() int {
+	var idle []net.Conn
+	ct.mu.Lock()
+	for c, cs := range ct.conns {
+		if cs == http.StateIdle {
+			idle = append(idle, c)
+		}
+	}
+	ct.mu.Unlock()
+
+	for _, c := range idle {
+		err := c.Close()
+		if err != nil {
+			log.Warn("ConnTracker: close idle connection", c.RemoteAddr(), err)
+		}
+	}
+	return len(idle)
+}
+
+// Drain closes the idle connections, and then the connections becoming idle
+// after their in-flight request, until no connection remains or ctx is done.
+// Stop accepting the new connections before (http.Server.Close of the listener,
+// or SetKeepAlivesEnabled(false) to close the connections after their response).
+func (ct *ConnTracker) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPeriod)
+	defer ticker.Stop()
+
+	closed := 0
+	for {
+		closed += ct.CloseIdle()
+		s := ct.Stats()
+		if s.Open == 0 {
+			log.Info("ConnTracker drained, closed idle connections:", closed)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			log.Warnf("ConnTracker drain interrupted: %d connections remain (%d active), closed %d idle connections",
+				s.Open, s.Active, closed)
+			return context.Cause(ctx)
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExportConnTracker publishes the connection gauges (open, active and idle)
+// and the per-state transition counters on /metrics.
+// The metrics in_flight_connections and conn_*_total are kept for compatibility.
+func (ns ServerName) ExportConnTracker(ct *ConnTracker) {
+	ns = ns.RespectPromNamingRule()
+	gauge := func(name, help string, value func(ConnStats) int) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: string(ns),
+			Subsystem: "http",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(value(ct.Stats())) })
+	}
+	gauge("open_connections", "Number of open connections", func(s ConnStats) int { return s.Open })
+	gauge("active_connections", "Number of connections handling a request", func(s ConnStats) int { return s.Active })
+	gauge("idle_connections", "Number of keep-alive connections waiting for a request", func(s ConnStats) int { return s.Idle })
+	gauge("in_flight_connections", "Number of current active connections", func(s ConnStats) int { return s.Open })
+
+	counter := func(name, help string, cs http.ConnState, labels prometheus.Labels) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   string(ns),
+			Subsystem:   "http",
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		}, func() float64 { return float64(ct.transitions[cs].Load()) })
+	}
+	for cs := http.StateNew; cs <= http.StateClosed; cs++ {
+		counter("conn_transitions_total", "Connection state transitions since startup", cs, prometheus.Labels{"state": cs.String()})
+	}
+	counter("conn_new_total", "Total initiated connections since startup", http.StateNew, nil)
+	counter("conn_req_total", "Total requested connections since startup", http.StateActive, nil)
+	counter("conn_res_total", "Total responded connections since startup", http.StateIdle, nil)
+	counter("conn_hij_total", "Total hijacked connections since startup", http.StateHijacked, nil)
+}
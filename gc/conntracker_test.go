@@ -0,0 +1,120 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestConnTracker(t *testing.T) {
+	t.Parallel()
+
+	ct := gc.NewConnTracker()
+	release := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	srv.Config.ConnState = ct.ConnState
+	srv.Start()
+	defer srv.Close()
+
+	// two keep-alive connections: one idle, one active
+	get := func(client *http.Client, path string) {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	idleClient := &http.Client{Transport: &http.Transport{}}
+	get(idleClient, "/")
+	done := make(chan struct{})
+	go func() {
+		get(&http.Client{Transport: &http.Transport{}}, "/slow")
+		close(done)
+	}()
+
+	waitFor(t, func() bool { s := ct.Stats(); return s.Open == 2 && s.Active == 1 && s.Idle == 1 })
+	if s := ct.Stats(); s.Transitions["new"] != 2 || s.Transitions["active"] != 2 {
+		t.Errorf("stats=%+v", s)
+	}
+
+	if n := ct.CloseIdle(); n != 1 {
+		t.Errorf("CloseIdle closed %d connections, want 1", n)
+	}
+
+	// Drain waits for the active connection, closed when it becomes idle
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	drained := make(chan error)
+	go func() { drained <- ct.Drain(ctx) }()
+	close(release)
+	<-done
+	if err := <-drained; err != nil {
+		t.Fatal(err)
+	}
+	if s := ct.Stats(); s.Open != 0 || s.Transitions["closed"] != 2 {
+		t.Errorf("after Drain: %+v", s)
+	}
+}
+
+func TestConnTracker_DrainTimeout(t *testing.T) {
+	t.Parallel()
+
+	ct := gc.NewConnTracker()
+	release := make(chan struct{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	srv.Config.ConnState = ct.ConnState
+	srv.Start()
+	defer srv.Close()
+	defer close(release)
+
+	go func() {
+		resp, err := http.Get(srv.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	waitFor(t, func() bool { return ct.Stats().Active == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := ct.Drain(ctx); err == nil {
+		t.Error("Drain must fail when an active connection remains")
+	}
+}
+
+func TestExportConnTracker(t *testing.T) {
+	t.Parallel()
+
+	ct := gc.NewConnTracker()
+	gc.ServerName("conntracker-test").ExportConnTracker(ct)
+	ct.ConnState(nil, http.StateNew)
+
+	rec := httptest.NewRecorder()
+	gc.New().ExporterHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	for _, want := range []string{
+		`conntracker_test_http_open_connections 1`,
+		`conntracker_test_http_conn_transitions_total{state="new"} 1`,
+		`conntracker_test_http_conn_new_total 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("missing %q in /metrics", want)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// Contracts registers the expected request and response types of the routes,
+	// to detect the drift between the front-end and the handlers:
+	// in dev mode, MiddlewareContracts checks the actual JSON traffic against the Go types,
+	// and TypeScript generates the client types from the same Go types.
+	//
+	//	contracts := gc.NewContracts()
+	//	gc.Contract[CreateUser, User](contracts, "POST /api/users")
+	//	gc.Contract[gc.NoBody, []User](contracts, "GET /api/users")
+	//	handler := g.MiddlewareContracts(contracts)(mux)
+	//
+	//	// go generate: write the TypeScript types of the front-end
+	//	contracts.TypeScript(file)
+	Contracts struct {
+		mux        *http.ServeMux // route matching only
+		routes     map[string]route
+		mu         sync.RWMutex
+		violations atomic.Uint64
+	}
+
+	// NoBody is the type of the routes without request (or response) body.
+	NoBody struct{}
+
+	route struct {
+		request  reflect.Type
+		response reflect.Type
+	}
+)
+
+// maxContractBody is the maximum size of the checked bodies, larger bodies are not checked.
+const maxContractBody = 1 << 20
+
+//nolint:gochecknoglobals // constant types
+var (
+	noBodyType = reflect.TypeFor[NoBody]()
+	timeType   = reflect.TypeFor[time.Time]()
+)
+
+// NewContracts creates an empty registry of contracts.
+func NewContracts() *Contracts {
+	return &Contracts{
+		mux:    http.NewServeMux(),
+		routes: map[string]route{},
+	}
+}
+
+// Contract registers the request type Req and the response type Resp of the route.
+// The pattern has the syntax of http.ServeMux, like "POST /api/items/{id}".
+// Use NoBody when the route has no request or response body.
+func Contract[Req, Resp any](c *Contracts, pattern string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.routes[pattern]; !ok {
+		c.mux.HandleFunc(pattern, func(http.ResponseWriter, *http.Request) {})
+	}
+	c.routes[pattern] = route{request: reflect.TypeFor[Req](), response: reflect.TypeFor[Resp]()}
+}
+
+// Violations returns the number of contract violations detected since the creation.
+func (c *Contracts) Violations() uint64 {
+	return c.violations.Load()
+}
+
+// MiddlewareContracts checks the traffic against the contracts in dev mode only,
+// else the middleware does nothing.
+func (g *Garcon) MiddlewareContracts(c *Contracts) gg.Middleware {
+	if !g.devMode {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return c.Middleware
+}
+
+// Middleware decodes the JSON bodies of the registered routes into the expected types
+// and logs the violations: unknown fields, wrong JSON types, missing fields (without omitempty).
+// Only the successful (2xx) JSON responses are checked.
+// The traffic is not modified, the violations are only logged.
+func (c *Contracts) Middleware(next http.Handler) http.Handler {
+	log.Info("MiddlewareContracts checks the JSON traffic, routes:", len(c.routes))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mu.RLock()
+		_, pattern := c.mux.Handler(r)
+		rt, ok := c.routes[pattern]
+		c.mu.RUnlock()
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Body != nil && r.Body != http.NoBody {
+			body, err := io.ReadAll(io.LimitReader(r.Body, maxContractBody+1))
+			r.Body = readCloser{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if err == nil && len(body) <= maxContractBody {
+				c.check(r, pattern, "request", rt.request, body)
+			}
+		}
+
+		rec := &contractRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if rec.status >= 200 && rec.status < 300 && !rec.overflow &&
+			strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+			c.check(r, pattern, "response", rt.response, rec.body.Bytes())
+		}
+	})
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// contractRecorder keeps a copy of the response body (up to maxContractBody).
+type contractRecorder struct {
+	http.ResponseWriter
+
+	body     bytes.Buffer
+	status   int
+	overflow bool
+}
+
+func (rec *contractRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *contractRecorder) Write(b []byte) (int, error) {
+	if !rec.overflow {
+		if rec.body.Len()+len(b) > maxContractBody {
+			rec.overflow = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying ResponseWriter.
+func (rec *contractRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func (c *Contracts) check(r *http.Request, pattern, what string, t reflect.Type, body []byte) {
+	err := validateJSON(t, body)
+	if err != nil {
+		c.violations.Add(1)
+		log.Warnf("Contract %q %s violation: %v (%s %s)", pattern, what, err, r.Method, gg.Sanitize(r.URL.Path))
+	}
+}
+
+// validateJSON checks the JSON body against the Go type.
+func validateJSON(t reflect.Type, body []byte) error {
+	body = bytes.TrimSpace(body)
+	if t == noBodyType {
+		if len(body) > 0 {
+			return errors.New("unexpected body")
+		}
+		return nil
+	}
+	if len(body) == 0 {
+		return errors.New("missing body")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(reflect.New(t).Interface())
+	if err != nil {
+		return err
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		return nil // null
+	}
+	var missing []string
+	for _, f := range jsonFields(t) {
+		if _, ok := fields[f.name]; !ok && !f.optional {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing fields %v", missing)
+	}
+	return nil
+}
+
+type jsonField struct {
+	typ      reflect.Type
+	name     string
+	optional bool
+}
+
+// jsonFields returns the fields as encoded by encoding/json (tags, embedded structs).
+func jsonFields(t reflect.Type) []jsonField {
+	var fields []jsonField
+	for f := range t.Fields() {
+		if !f.IsExported() && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				fields = append(fields, jsonFields(ft)...)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		optional := strings.Contains(opts, "omitempty") || strings.Contains(opts, "omitzero")
+		fields = append(fields, jsonField{typ: ft, name: name, optional: optional})
+	}
+	return fields
+}
+
+// TypeScript writes the TypeScript interfaces of the registered types
+// and the Routes interface mapping each route to its request and response types.
+func (c *Contracts) TypeScript(w io.Writer) error {
+	c.mu.RLock()
+	patterns := make([]string, 0, len(c.routes))
+	for p := range c.routes {
+		patterns = append(patterns, p)
+	}
+	slices.Sort(patterns)
+
+	gen := tsGenerator{defined: map[reflect.Type]string{}, names: map[string]bool{}}
+	var routes strings.Builder
+	routes.WriteString("export interface Routes {\n")
+	for _, p := range patterns {
+		rt := c.routes[p]
+		fmt.Fprintf(&routes, "  %s: { request: %s; response: %s };\n", tsKey(p), gen.ref(rt.request), gen.ref(rt.response))
+	}
+	routes.WriteString("}\n")
+	c.mu.RUnlock()
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by gc.Contracts.TypeScript. DO NOT EDIT.\n\n")
+	out.Write(gen.out.Bytes())
+	out.WriteString(routes.String())
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+type tsGenerator struct {
+	defined map[reflect.Type]string
+	names   map[string]bool
+	out     bytes.Buffer
+}
+
+// ref returns the TypeScript type expression of t, defining the named structs as interfaces.
+func (gen *tsGenerator) ref(t reflect.Type) string {
+	if t == noBodyType {
+		return "void"
+	}
+	if t == timeType {
+		return "string"
+	}
+	if t.Implements(reflect.TypeFor[json.Marshaler]()) {
+		return "unknown"
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return gen.ref(t.Elem()) + " | null"
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "string" // base64
+		}
+		elem := gen.ref(t.Elem())
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case reflect.Map:
+		return "Record<string, " + gen.ref(t.Elem()) + ">"
+	case reflect.Struct:
+		return gen.structRef(t)
+	default:
+		return "unknown"
+	}
+}
+
+func (gen *tsGenerator) structRef(t reflect.Type) string {
+	if name, ok := gen.defined[t]; ok {
+		return name
+	}
+	if t.Name() == "" {
+		return gen.body(t, "")
+	}
+
+	name := t.Name()
+	if i := strings.IndexByte(name, '['); i > 0 { // generic type
+		name = name[:i]
+	}
+	base := name
+	for n := 2; gen.names[name]; n++ {
+		name = base + strconv.Itoa(n) // same name in different packages
+	}
+	gen.names[name] = true
+	gen.defined[t] = name // before the fields: recursive types
+	body := gen.body(t, "")
+	fmt.Fprintf(&gen.out, "export interface %s %s\n\n", name, body)
+	return name
+}
+
+func (gen *tsGenerator) body(t reflect.Type, indent string) string {
+	var b strings.Builder
+	b.WriteString("{\n")
+	for _, f := range jsonFields(t) {
+		optional := ""
+		if f.optional {
+			optional = "?"
+		}
+		fmt.Fprintf(&b, "%s  %s%s: %s;\n", indent, tsKey(f.name), optional, gen.ref(f.typ))
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+// tsKey quotes the property name when it is not a valid identifier.
+func tsKey(name string) string {
+	for i, r := range name {
+		if r != '_' && r != '$' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			key, _ := json.Marshal(name)
+			return string(key)
+		}
+	}
+	return name
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+type (
+	contractUser struct {
+		Created time.Time         `json:"created"`
+		Manager *contractUser     `json:"manager,omitempty"`
+		Labels  map[string]string `json:"labels,omitempty"`
+		Name    string            `json:"name"`
+		Roles   []string          `json:"roles"`
+		ID      int               `json:"id"`
+	}
+
+	createUser struct {
+		Name  string `json:"name"`
+		Email string `json:"e-mail,omitempty"`
+	}
+)
+
+func TestContracts(t *testing.T) {
+	t.Parallel()
+
+	contracts := gc.NewContracts()
+	gc.Contract[createUser, contractUser](contracts, "POST /api/users")
+	gc.Contract[gc.NoBody, []contractUser](contracts, "GET /api/users/{id}")
+
+	respBody := `{"created":"2024-01-01T00:00:00Z","name":"x","roles":[],"id":1}`
+	handler := contracts.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`[` + respBody + `]`))
+			return
+		}
+		w.Write([]byte(respBody))
+	}))
+
+	send := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send(http.MethodPost, "/api/users", `{"name":"jane"}`)
+	send(http.MethodGet, "/api/users/7", "")
+	send(http.MethodGet, "/other", "")
+	if n := contracts.Violations(); n != 0 {
+		t.Fatalf("want no violation, got %d", n)
+	}
+
+	send(http.MethodPost, "/api/users", `{"name":"jane","admin":true}`) // unknown field
+	send(http.MethodPost, "/api/users", `{"name":42}`)                  // wrong type
+	send(http.MethodPost, "/api/users", `{"e-mail":"j@x.co"}`)          // missing name
+	if n := contracts.Violations(); n != 3 {
+		t.Errorf("want 3 violations, got %d", n)
+	}
+
+	var ts strings.Builder
+	err := contracts.TypeScript(&ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"export interface contractUser {\n  created: string;\n  manager?: contractUser | null;\n" +
+			"  labels?: Record<string, string>;\n  name: string;\n  roles: string[];\n  id: number;\n}",
+		`"e-mail"?: string;`,
+		`"GET /api/users/{id}": { request: void; response: contractUser[] };`,
+		`"POST /api/users": { request: createUser; response: contractUser };`,
+	} {
+		if !strings.Contains(ts.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, ts.String())
+		}
+	}
+}
@@ -56,6 +56,11 @@ func DevOrigins() []*url.URL {
 }
 
 func newCORS(allowedOrigins, methods, headers []string, debug bool) *cors.Cors {
+	return newCORSFunc(allowOriginFunc(allowedOrigins), methods, headers, debug)
+}
+
+// newCORSFunc creates the CORS handler checking the origins with allowOrigin.
+func newCORSFunc(allowOrigin func(string) bool, methods, headers []string, debug bool) *cors.Cors {
 	if len(methods) == 0 {
 		// original default: http.MethodGet, http.MethodPost, http.MethodHead
 		methods = []string{http.MethodGet, http.MethodPost, http.MethodDelete}
@@ -67,7 +72,7 @@ func newCORS(allowedOrigins, methods, headers []string, debug bool) *cors.Cors {
 
 	options := cors.Options{
 		AllowedOrigins:             nil,
-		AllowOriginFunc:            allowOriginFunc(allowedOrigins),
+		AllowOriginFunc:            allowOrigin,
 		AllowOriginRequestFunc:     nil,
 		AllowOriginVaryRequestFunc: nil,
 		AllowedMethods:             methods,
@@ -0,0 +1,161 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSpec is a parsed cron expression of five fields: minute hour day-of-month month day-of-week.
+// Each field accepts "*", a value, a range "1-5", a list "1,15" and a step "*/10" or "8-18/2".
+// The day-of-week is 0-7 (0 and 7 are Sunday). When both day fields are restricted,
+// a day matching either of them is selected (like the Vixie cron).
+// The descriptors @yearly (@annually), @monthly, @weekly, @daily (@midnight) and @hourly are supported.
+type CronSpec struct {
+	minute, hour, dom, month, dow uint64 // bit sets
+	domStar, dowStar              bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+}
+
+//nolint:gochecknoglobals // constant table
+var cronFields = [5]cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day-of-month", 1, 31},
+	{"month", 1, 12},
+	{"day-of-week", 0, 7},
+}
+
+//nolint:gochecknoglobals // constant table
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// maxCronYears bounds the search of the next activation (e.g. "0 0 30 2 *" never matches).
+const maxCronYears = 5
+
+// ParseCron parses a cron expression, see CronSpec.
+func ParseCron(expr string) (CronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return CronSpec{}, errors.New("cron " + strconv.Quote(expr) + ": want 5 fields (minute hour day-of-month month day-of-week)")
+	}
+
+	var sets [5]uint64
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return CronSpec{}, errors.New("cron " + strconv.Quote(expr) + ": " + err.Error())
+		}
+		sets[i] = set
+	}
+
+	dow := sets[4]
+	if dow&(1<<7) != 0 {
+		dow |= 1 // 7 is Sunday
+	}
+	return CronSpec{
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     dow,
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+func parseCronField(field string, f cronField) (uint64, error) {
+	var set uint64
+	for part := range strings.SplitSeq(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step <= 0 {
+				return 0, errors.New(f.name + ": invalid step " + strconv.Quote(part))
+			}
+		}
+
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			lo, err = strconv.Atoi(loStr)
+			if err != nil {
+				return 0, errors.New(f.name + ": invalid value " + strconv.Quote(part))
+			}
+			hi = lo
+			if isRange {
+				hi, err = strconv.Atoi(hiStr)
+				if err != nil {
+					return 0, errors.New(f.name + ": invalid range " + strconv.Quote(part))
+				}
+			} else if hasStep {
+				hi = f.max // "5/15" means "5-59/15"
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return 0, errors.New(f.name + ": " + strconv.Quote(part) +
+				" out of range [" + strconv.Itoa(f.min) + "-" + strconv.Itoa(f.max) + "]")
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+// Next returns the first activation time strictly after t (minute precision, in the location of t).
+// Next returns the zero time when the expression never matches.
+func (c CronSpec) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxCronYears, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c CronSpec) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
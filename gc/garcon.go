@@ -8,7 +8,10 @@
 package gc
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
+	"html/template"
 	"net"
 	"net/http"
 	"net/url"
@@ -26,13 +29,21 @@ import (
 )
 
 type Garcon struct {
-	ServerName     ServerName
-	Writer         gg.Writer
-	docURL         string
-	urls           []*url.URL
-	allowedOrigins []string
-	pprofPort      int
-	devMode        bool
+	ServerName       ServerName
+	Writer           gg.Writer
+	maintenancePage  *template.Template
+	connTracker      *ConnTracker
+	pprof            *PProf
+	docURL           string
+	pprofToken       string
+	urls             []*url.URL
+	allowedOrigins   []string
+	listeners        []Listener
+	hooks            []Hook
+	pprofPort        int
+	shutdownTimeout  time.Duration
+	maintenanceRetry time.Duration
+	devMode          bool
 }
 
 var log = emo.NewZone("garcon")
@@ -47,7 +58,9 @@ func New(opts ...Option) *Garcon {
 		}
 	}
 
-	StartPProfServer(g.pprofPort)
+	if g.pprofPort != 0 {
+		startPProfServer(g.pprofPort, g.PProf())
+	}
 
 	// namespace fallback = retrieve it from first URL
 	if g.ServerName == "" && len(g.urls) > 0 {
@@ -114,6 +127,13 @@ func WithPProf(port int) Option {
 	}
 }
 
+// WithPProfToken requires the token to access the PProf endpoints (see WithPProf and PProf).
+func WithPProfToken(token string) Option {
+	return func(g *Garcon) {
+		g.pprofToken = token
+	}
+}
+
 func WithURLs(addresses ...string) Option {
 	return func(g *Garcon) {
 		g.urls = gg.ParseURLs(addresses)
@@ -158,7 +178,7 @@ func Server(h http.Handler, port int, connState ...func(net.Conn, http.ConnState
 		ConnState:                    connState[0],
 		ErrorLog:                     log.Default(),
 		BaseContext:                  nil,
-		ConnContext:                  nil,
+		ConnContext:                  ConnContext, // see SendTuning
 	}
 }
 
@@ -213,6 +233,23 @@ func (g *Garcon) IncorruptibleCheckerBin(secretKeyBin []byte, maxAge int, setIP
 		log.Panic("Missing URLs => Set first the URLs with gc.WithURLs()")
 	}
 
+	weakKey := gwt.IsWeakKey(secretKeyBin)
+	g.OnStart(g.selfCheckName(), func(context.Context) error {
+		if g.devMode {
+			return nil
+		}
+		var errs []error
+		if g.urls[0].Scheme != "https" && g.urls[0].Hostname() != "localhost" {
+			errs = append(errs, errors.New("Incorruptible cookie is sent without the Secure flag in production: "+
+				"use an https URL in WithURLs (or WithDev in development)"))
+		}
+		if weakKey {
+			errs = append(errs, errors.New("Incorruptible secret key is an example constant or a trivial pattern: "+
+				"generate a random key (e.g. openssl rand -hex 16) or use WithDev in development"))
+		}
+		return errors.Join(errs...)
+	})
+
 	cookieName := string(g.ServerName)
 	return incorruptible.New(g.Writer.WriteErr, g.urls, secretKeyBin, cookieName, maxAge, setIP)
 }
@@ -227,7 +264,17 @@ func (g *Garcon) JWTChecker(keyTxt string, planPerm ...any) *gwt.JWTChecker {
 		log.Panic("Missing URLs => Set first the URLs with gc.WithURLs()")
 	}
 
-	return gwt.NewJWTChecker(g.Writer, g.urls, keyTxt, g.ServerName.String(), planPerm...)
+	ck := gwt.NewJWTChecker(g.Writer, g.urls, keyTxt, g.ServerName.String(), planPerm...)
+	g.OnStart(g.selfCheckName(), func(context.Context) error {
+		return ck.SelfCheck(!g.devMode)
+	})
+	return ck
+}
+
+// selfCheckName returns a unique name for the startup hook verifying the configuration of a TokenChecker:
+// Garcon.Run fails fast on a misconfiguration (see gwt.JWTChecker.SelfCheck).
+func (g *Garcon) selfCheckName() string {
+	return "self-check " + strconv.Itoa(len(g.hooks))
 }
 
 func (g *Garcon) MiddlewareServerHeader(serverName ...string) gg.Middleware {
@@ -241,6 +288,6 @@ func (g *Garcon) MiddlewareServerHeader(serverName ...string) gg.Middleware {
 	return vv.MiddlewareServerHeader(version)
 }
 
-func (g *Garcon) NewContactForm(redirectURL, notifierURL string) wf.WebForm {
-	return wf.NewContactForm(redirectURL, notifierURL)
+func (g *Garcon) NewContactForm(redirectURL, notifierURL string, opts ...wf.Option) wf.WebForm {
+	return wf.NewContactForm(redirectURL, notifierURL, opts...)
 }
@@ -0,0 +1,188 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Hook is a resource (DB pool, cache, scheduler, notifier...) started by Garcon.Run
+// before the listeners and stopped after the graceful shutdown of the listeners.
+type Hook struct {
+	Start func(ctx context.Context) error // optional
+	Stop  func(ctx context.Context) error // optional
+	Name  string
+	// After lists the hooks started before this one, and stopped after this one.
+	After []string
+	// Timeout bounds the duration of Start and of Stop (default 30s).
+	Timeout time.Duration
+}
+
+// defaultHookTimeout is the maximum duration of Hook.Start and Hook.Stop.
+const defaultHookTimeout = 30 * time.Second
+
+// WithHook registers a resource started and stopped by Garcon.Run.
+func WithHook(h Hook) Option {
+	return func(g *Garcon) {
+		g.AddHook(h)
+	}
+}
+
+// AddHook registers a resource started and stopped by Garcon.Run.
+// A hook having the same Name as a registered one is merged into it:
+// the non-nil functions and the non-zero timeout replace the previous ones,
+// the dependencies are appended.
+//
+//	g.AddHook(gc.Hook{Name: "db", Start: db.Open, Stop: db.Close, Timeout: 10 * time.Second})
+//	g.AddHook(gc.Hook{Name: "cache", Start: cache.Warm, After: []string{"db"}})
+//	g.OnStop("scheduler", scheduler.Stop, "db", "cache")
+func (g *Garcon) AddHook(h Hook) {
+	for i := range g.hooks {
+		prev := &g.hooks[i]
+		if prev.Name != h.Name {
+			continue
+		}
+		if h.Start != nil {
+			prev.Start = h.Start
+		}
+		if h.Stop != nil {
+			prev.Stop = h.Stop
+		}
+		if h.Timeout > 0 {
+			prev.Timeout = h.Timeout
+		}
+		prev.After = append(prev.After, h.After...)
+		return
+	}
+	g.hooks = append(g.hooks, h)
+}
+
+// OnStart registers the function opening the resource name,
+// after the resources listed in after.
+func (g *Garcon) OnStart(name string, start func(ctx context.Context) error, after ...string) {
+	g.AddHook(Hook{Name: name, Start: start, After: after})
+}
+
+// OnStop registers the function closing the resource name,
+// before the resources listed in after.
+func (g *Garcon) OnStop(name string, stop func(ctx context.Context) error, after ...string) {
+	g.AddHook(Hook{Name: name, Stop: stop, After: after})
+}
+
+// sortHooks returns the hooks in the start order: each hook after its dependencies,
+// the registration order is kept for the independent hooks.
+func sortHooks(hooks []Hook) ([]Hook, error) {
+	index := make(map[string]int, len(hooks))
+	for i, h := range hooks {
+		index[h.Name] = i
+	}
+	for _, h := range hooks {
+		for _, dep := range h.After {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("hook %q depends on the unknown hook %q", h.Name, dep)
+			}
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(hooks))
+	sorted := make([]Hook, 0, len(hooks))
+
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("hook dependency cycle: %v", append(slices.Clip(path), hooks[i].Name))
+		}
+		state[i] = visiting
+		for _, dep := range hooks[i].After {
+			err := visit(index[dep], append(slices.Clip(path), hooks[i].Name))
+			if err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		sorted = append(sorted, hooks[i])
+		return nil
+	}
+
+	for i := range hooks {
+		err := visit(i, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// startHooks starts the hooks in dependency order and returns the started ones.
+// When a hook fails, the already started hooks are stopped in reverse order.
+func startHooks(ctx context.Context, hooks []Hook) ([]Hook, error) {
+	sorted, err := sortHooks(hooks)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, h := range sorted {
+		if h.Start == nil {
+			continue
+		}
+		start := time.Now()
+		err = runHook(ctx, h, h.Start)
+		if err != nil {
+			err = fmt.Errorf("start %s: %w", h.Name, err)
+			return nil, errors.Join(err, stopHooks(context.WithoutCancel(ctx), sorted[:i]))
+		}
+		log.Infof("Hook %s started in %s", h.Name, time.Since(start).Round(time.Millisecond))
+	}
+	return sorted, nil
+}
+
+// stopHooks stops the hooks in reverse order, even when some of them fail.
+func stopHooks(ctx context.Context, started []Hook) error {
+	var errs []error
+	for _, h := range slices.Backward(started) {
+		if h.Stop == nil {
+			continue
+		}
+		err := runHook(ctx, h, h.Stop)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("stop %s: %w", h.Name, err))
+			continue
+		}
+		log.Info("Hook", h.Name, "stopped")
+	}
+	return errors.Join(errs...)
+}
+
+// runHook runs fn with the hook timeout. A function ignoring the context
+// is abandoned when the timeout expires.
+func runHook(ctx context.Context, h Hook, fn func(context.Context) error) error {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timeout after %s: %w", timeout, context.Cause(ctx))
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+type hookRecorder struct {
+	events []string
+	mu     sync.Mutex
+}
+
+func (hr *hookRecorder) fn(event string, err error) func(context.Context) error {
+	return func(context.Context) error {
+		hr.mu.Lock()
+		hr.events = append(hr.events, event)
+		hr.mu.Unlock()
+		return err
+	}
+}
+
+func TestGarcon_Run_hooks(t *testing.T) {
+	t.Parallel()
+
+	var hr hookRecorder
+	g := gc.New(
+		gc.WithListener(gc.Listener{Addr: freeAddr(t), Handler: http.NotFoundHandler()}),
+		gc.WithHook(gc.Hook{Name: "scheduler", Start: hr.fn("start scheduler", nil), After: []string{"cache", "db"}}),
+	)
+	g.OnStart("cache", hr.fn("start cache", nil), "db")
+	g.OnStop("cache", hr.fn("stop cache", nil))
+	g.OnStart("db", hr.fn("start db", nil))
+	g.OnStop("db", hr.fn("stop db", nil))
+	g.OnStop("scheduler", hr.fn("stop scheduler", nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- g.Run(ctx) }()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	err := <-result
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"start db", "start cache", "start scheduler", "stop scheduler", "stop cache", "stop db"}
+	if !slices.Equal(hr.events, want) {
+		t.Errorf("events=%v want %v", hr.events, want)
+	}
+}
+
+func TestGarcon_Run_hookErrors(t *testing.T) {
+	t.Parallel()
+
+	failure := errors.New("cannot connect")
+	cases := []struct {
+		hooks  []gc.Hook
+		name   string
+		errMsg string
+		events []string
+	}{
+		{
+			name: "start failure stops the started hooks",
+			hooks: []gc.Hook{
+				{Name: "db"},
+				{Name: "cache", After: []string{"db"}},
+				{Name: "queue", After: []string{"cache"}},
+			},
+			errMsg: "start queue: cannot connect",
+			events: []string{"start db", "start cache", "start queue", "stop cache", "stop db"},
+		},
+		{
+			name:   "unknown dependency",
+			hooks:  []gc.Hook{{Name: "cache", After: []string{"redis"}}},
+			errMsg: `hook "cache" depends on the unknown hook "redis"`,
+		},
+		{
+			name:   "cycle",
+			hooks:  []gc.Hook{{Name: "a", After: []string{"b"}}, {Name: "b", After: []string{"a"}}},
+			errMsg: "hook dependency cycle: [a b a]",
+		},
+		{
+			name: "timeout",
+			hooks: []gc.Hook{{Name: "slow", Timeout: 10 * time.Millisecond, Start: func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}}},
+			errMsg: "start slow: ",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			var hr hookRecorder
+			g := gc.New(gc.WithListener(gc.Listener{Addr: freeAddr(t), Handler: http.NotFoundHandler()}))
+			for _, h := range c.hooks {
+				if h.Start == nil && c.events != nil {
+					var err error
+					if h.Name == "queue" {
+						err = failure
+					}
+					h.Start = hr.fn("start "+h.Name, err)
+					h.Stop = hr.fn("stop "+h.Name, nil)
+				}
+				g.AddHook(h)
+			}
+
+			err := g.Run(context.Background())
+			if err == nil || !strings.Contains(err.Error(), c.errMsg) {
+				t.Fatalf("want error %q, got %v", c.errMsg, err)
+			}
+			if !slices.Equal(hr.events, c.events) {
+				t.Errorf("events=%v want %v", hr.events, c.events)
+			}
+		})
+	}
+}
@@ -0,0 +1,486 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// CacheStore keeps the responses of the HTTPCache: in memory (MemoryCacheStore)
+	// or shared by several instances (RedisCacheStore).
+	CacheStore interface {
+		// Get returns the value, ok is false when missing or expired.
+		Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+		Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+		Delete(ctx context.Context, key string) error
+		// DeletePrefix removes all the keys starting with prefix.
+		DeletePrefix(ctx context.Context, prefix string) error
+	}
+
+	// HTTPCache caches the GET responses (status, headers and body) of the expensive endpoints:
+	//
+	//	store := gc.NewMemoryCacheStore(1000)
+	//	r.With(g.MiddlewareCache(store, time.Minute, nil)).Get("/api/stats", stats)
+	//
+	//	// after an update, invalidate one key or a prefix (the default key is host + URI)
+	//	err := store.DeletePrefix(ctx, "example.com/api/stats")
+	//
+	// The response directives max-age and s-maxage override the TTL. The responses having
+	// no-store, no-cache, private, Set-Cookie or "Vary: *" are not stored, neither are the
+	// responses to a request having Authorization or Cookie (the user may be authenticated
+	// by a JWT cookie), unless public or s-maxage.
+	// A request "Cache-Control: no-cache" refreshes the cached response,
+	// "no-store" bypasses the cache. The Vary header selects a variant per request headers.
+	HTTPCache struct {
+		store   CacheStore
+		keyFunc func(*http.Request) string
+		metrics atomic.Pointer[prometheus.CounterVec]
+		// MaxBodySize is the size of the largest response stored (default 1 MiB).
+		MaxBodySize int
+		ttl         time.Duration
+		hits        atomic.Uint64
+		misses      atomic.Uint64
+		bypasses    atomic.Uint64
+	}
+
+	// HTTPCacheStats is a snapshot of the HTTPCache metrics.
+	HTTPCacheStats struct {
+		Hits     uint64  `json:"hits"`
+		Misses   uint64  `json:"misses"`
+		Bypasses uint64  `json:"bypasses"` // requests not cacheable
+		HitRate  float64 `json:"hit_rate"` // hits / (hits + misses)
+	}
+
+	// cacheEntry is the stored response, or the list of the Vary headers
+	// when the response has variants (stored under the key extended by the header values).
+	cacheEntry struct {
+		Stored time.Time   `json:"t"`
+		Header http.Header `json:"h,omitempty"`
+		Body   []byte      `json:"b,omitempty"`
+		Vary   []string    `json:"v,omitempty"`
+		Status int         `json:"s,omitempty"`
+	}
+
+	// cacheRecorder copies the response in a buffer while sending it.
+	cacheRecorder struct {
+		http.ResponseWriter
+		header http.Header // snapshot at WriteHeader
+		body   bytes.Buffer
+		max    int
+		status int
+		over   bool // body larger than max
+	}
+
+	// MemoryCacheStore is a CacheStore in memory, bounded by MaxEntries.
+	MemoryCacheStore struct {
+		entries    map[string]memoryCacheEntry
+		MaxEntries int // when reached, the expired entries are purged, then Set evicts the entry expiring first
+		mu         sync.RWMutex
+	}
+
+	memoryCacheEntry struct {
+		expires time.Time
+		value   []byte
+	}
+)
+
+// ErrCacheStoreFull is returned by MemoryCacheStore.SetIfAbsent when all the entries are live.
+var ErrCacheStoreFull = errors.New("cache store full")
+
+const (
+	defaultCacheBodySize = 1 << 20
+	defaultCacheEntries  = 1000
+	cacheVarySeparator   = "\x00"
+	cacheResultHit       = "hit"
+	cacheResultMiss      = "miss"
+	cacheResultBypass    = "bypass"
+	cacheStatusHeader    = "X-Cache"
+	noCacheDirective     = -1
+)
+
+// MiddlewareCache creates an HTTPCache publishing its hit and miss counters on /metrics.
+// A nil keyFunc uses the host and the request URI.
+func (g *Garcon) MiddlewareCache(store CacheStore, ttl time.Duration, keyFunc func(*http.Request) string) gg.Middleware {
+	c := NewHTTPCache(store, ttl, keyFunc)
+	g.ServerName.ExportHTTPCache(c)
+	return c.Middleware
+}
+
+// NewHTTPCache creates an HTTPCache, see Middleware.
+// A nil keyFunc uses the host and the request URI.
+func NewHTTPCache(store CacheStore, ttl time.Duration, keyFunc func(*http.Request) string) *HTTPCache {
+	if store == nil {
+		log.Panic("HTTPCache requires a CacheStore")
+	}
+	if ttl <= 0 {
+		log.Panic("HTTPCache requires a positive TTL, got", ttl)
+	}
+	if keyFunc == nil {
+		keyFunc = func(r *http.Request) string { return r.Host + r.URL.RequestURI() }
+	}
+	return &HTTPCache{store: store, keyFunc: keyFunc, ttl: ttl, MaxBodySize: defaultCacheBodySize}
+}
+
+// Middleware serves the cached responses and stores the cacheable ones.
+// The X-Cache response header is HIT or MISS.
+func (c *HTTPCache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqCC := r.Header.Get("Cache-Control")
+		if r.Method != http.MethodGet || strings.Contains(reqCC, "no-store") {
+			c.count(cacheResultBypass)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := c.keyFunc(r)
+		if !strings.Contains(reqCC, "no-cache") && c.serveCached(w, r, key) {
+			c.count(cacheResultHit)
+			return
+		}
+		c.count(cacheResultMiss)
+
+		rec := &cacheRecorder{ResponseWriter: w, max: c.MaxBodySize, status: http.StatusOK}
+		w.Header().Set(cacheStatusHeader, "MISS")
+		next.ServeHTTP(rec, r)
+		if rec.header == nil {
+			rec.header = w.Header().Clone() // WriteHeader not called
+		}
+		c.save(r, key, rec)
+	})
+}
+
+// serveCached writes the cached response, returns false on a cache miss.
+func (c *HTTPCache) serveCached(w http.ResponseWriter, r *http.Request, key string) bool {
+	entry, ok := c.get(r.Context(), key)
+	if ok && len(entry.Vary) > 0 {
+		entry, ok = c.get(r.Context(), variantKey(key, entry.Vary, r))
+	}
+	if !ok || entry.Status == 0 {
+		return false
+	}
+
+	h := w.Header()
+	for k, v := range entry.Header {
+		h[k] = v
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(entry.Stored).Seconds())))
+	h.Set(cacheStatusHeader, "HIT")
+	w.WriteHeader(entry.Status)
+	_, err := w.Write(entry.Body)
+	if err != nil {
+		log.Warn("HTTPCache:", err)
+	}
+	return true
+}
+
+func (c *HTTPCache) get(ctx context.Context, key string) (cacheEntry, bool) {
+	var entry cacheEntry
+	data, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		log.Warn("HTTPCache: get", key, err)
+		return entry, false
+	}
+	if !ok {
+		return entry, false
+	}
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		log.Warn("HTTPCache: corrupted entry", key, err)
+		return entry, false
+	}
+	return entry, true
+}
+
+// save stores the recorded response when cacheable.
+func (c *HTTPCache) save(r *http.Request, key string, rec *cacheRecorder) {
+	ttl, ok := c.cacheable(r, rec)
+	if !ok {
+		return
+	}
+
+	entry := cacheEntry{Status: rec.status, Header: rec.header, Body: rec.body.Bytes(), Stored: time.Now()}
+	entry.Header.Del(cacheStatusHeader)
+	if vary := varyHeaders(rec.header); len(vary) > 0 {
+		c.set(r.Context(), key, cacheEntry{Vary: vary, Stored: entry.Stored}, ttl)
+		key = variantKey(key, vary, r)
+	}
+	c.set(r.Context(), key, entry, ttl)
+}
+
+func (c *HTTPCache) set(ctx context.Context, key string, entry cacheEntry, ttl time.Duration) {
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = c.store.Set(ctx, key, data, ttl)
+	}
+	if err != nil {
+		log.Warn("HTTPCache: set", key, err)
+	}
+}
+
+// cacheable returns the TTL of the response, ok is false when the response must not be stored.
+func (c *HTTPCache) cacheable(r *http.Request, rec *cacheRecorder) (time.Duration, bool) {
+	switch rec.status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return 0, false
+	}
+	if rec.over || rec.header.Get("Set-Cookie") != "" || rec.header.Get("Vary") == "*" {
+		return 0, false
+	}
+
+	cc := rec.header.Get("Cache-Control")
+	if strings.Contains(cc, "no-store") || strings.Contains(cc, "no-cache") || strings.Contains(cc, "private") {
+		return 0, false
+	}
+	sMaxAge := cacheDirective(cc, "s-maxage")
+	if hasCredentials(r) && sMaxAge == noCacheDirective && !strings.Contains(cc, "public") {
+		return 0, false
+	}
+
+	ttl := c.ttl
+	if sMaxAge != noCacheDirective {
+		ttl = time.Duration(sMaxAge) * time.Second
+	} else if maxAge := cacheDirective(cc, "max-age"); maxAge != noCacheDirective {
+		ttl = time.Duration(maxAge) * time.Second
+	}
+	return ttl, ttl > 0
+}
+
+// hasCredentials reports whether the response may depend on the user.
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// cacheDirective returns the seconds of the Cache-Control directive, or noCacheDirective.
+func cacheDirective(cc, name string) int {
+	for d := range strings.SplitSeq(cc, ",") {
+		v, found := strings.CutPrefix(strings.TrimSpace(d), name+"=")
+		if !found {
+			continue
+		}
+		n, err := strconv.Atoi(strings.Trim(v, `"`))
+		if err == nil && n >= 0 {
+			return n
+		}
+	}
+	return noCacheDirective
+}
+
+// varyHeaders returns the canonical names of the Vary header, sorted.
+func varyHeaders(h http.Header) []string {
+	var names []string
+	for _, v := range h.Values("Vary") {
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// variantKey extends the key with the values of the request headers selected by Vary.
+// The variants start with the key, so they are also removed by DeletePrefix(key).
+func variantKey(key string, vary []string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(key)
+	for _, name := range vary {
+		b.WriteString(cacheVarySeparator)
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+func (c *HTTPCache) count(result string) {
+	switch result {
+	case cacheResultHit:
+		c.hits.Add(1)
+	case cacheResultMiss:
+		c.misses.Add(1)
+	default:
+		c.bypasses.Add(1)
+	}
+	if m := c.metrics.Load(); m != nil {
+		m.WithLabelValues(result).Inc()
+	}
+}
+
+// Stats returns the counters since the creation of the HTTPCache.
+func (c *HTTPCache) Stats() HTTPCacheStats {
+	s := HTTPCacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Bypasses: c.bypasses.Load()}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRate = float64(s.Hits) / float64(total)
+	}
+	return s
+}
+
+// LogStats prints the counters in the logs.
+func (c *HTTPCache) LogStats() {
+	s := c.Stats()
+	log.Infof("HTTPCache hits=%d misses=%d bypasses=%d hit-rate=%.1f%%", s.Hits, s.Misses, s.Bypasses, 100*s.HitRate)
+}
+
+// ExportHTTPCache publishes http_cache_requests_total{result="hit|miss|bypass"} on /metrics.
+// The HTTPCaches of the same ServerName share the counter.
+func (ns ServerName) ExportHTTPCache(c *HTTPCache) {
+	ns = ns.RespectPromNamingRule()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: string(ns),
+		Subsystem: "http_cache",
+		Name:      "requests_total",
+		Help:      "Number of requests by cache result (hit, miss or bypass)",
+	}, []string{"result"})
+
+	err := prometheus.Register(counter)
+	if err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if !errors.As(err, &are) {
+			log.Panic("ExportHTTPCache", err)
+		}
+		counter, _ = are.ExistingCollector.(*prometheus.CounterVec)
+	}
+	c.metrics.Store(counter)
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.header == nil {
+		rec.status = status
+		rec.header = rec.ResponseWriter.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *cacheRecorder) Write(b []byte) (int, error) {
+	if rec.header == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.over {
+		if rec.body.Len()+len(b) > rec.max {
+			rec.over = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying ResponseWriter.
+func (rec *cacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// NewMemoryCacheStore creates a MemoryCacheStore, zero maxEntries defaults to 1000.
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	if maxEntries <= 0 {
+		maxEntries = defaultCacheEntries
+	}
+	return &MemoryCacheStore{entries: map[string]memoryCacheEntry{}, MaxEntries: maxEntries}
+}
+
+// Get returns the value when not expired.
+func (s *MemoryCacheStore) Get(_ context.Context, key string) ([]byte, bool, error) {
+	s.mu.RLock()
+	e, ok := s.entries[key]
+	s.mu.RUnlock()
+	if !ok || time.Now().After(e.expires) {
+		return nil, false, nil
+	}
+	return e.value, true, nil
+}
+
+// Set stores the value during ttl.
+// When MaxEntries is reached, the expired entries are removed, else the entry expiring first.
+func (s *MemoryCacheStore) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.entries[key]; !exists && s.full(now) {
+		s.evictFirstExpiring()
+	}
+	s.entries[key] = memoryCacheEntry{value: value, expires: now.Add(ttl)}
+	return nil
+}
+
+// SetIfAbsent stores the value during ttl only when the key is missing or expired.
+// SetIfAbsent never evicts a live entry (an idempotent request being processed):
+// it returns ErrCacheStoreFull when MaxEntries is reached.
+func (s *MemoryCacheStore) SetIfAbsent(_ context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, exists := s.entries[key]
+	if exists && now.Before(e.expires) {
+		return false, nil
+	}
+	if !exists && s.full(now) {
+		return false, ErrCacheStoreFull
+	}
+	s.entries[key] = memoryCacheEntry{value: value, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// full removes the expired entries when MaxEntries is reached
+// and reports whether the store is still full. The caller must lock s.mu.
+func (s *MemoryCacheStore) full(now time.Time) bool {
+	if len(s.entries) < s.MaxEntries {
+		return false
+	}
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+	return len(s.entries) >= s.MaxEntries
+}
+
+// evictFirstExpiring removes the entry expiring first. The caller must lock s.mu.
+func (s *MemoryCacheStore) evictFirstExpiring() {
+	var first string
+	var expires time.Time
+	for k, e := range s.entries {
+		if expires.IsZero() || e.expires.Before(expires) {
+			first, expires = k, e.expires
+		}
+	}
+	delete(s.entries, first)
+}
+
+// Delete removes the key.
+func (s *MemoryCacheStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// DeletePrefix removes the keys starting with prefix.
+func (s *MemoryCacheStore) DeletePrefix(_ context.Context, prefix string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.entries, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func cachedHandler(t *testing.T, store gc.CacheStore) (http.Handler, *atomic.Int64, *gc.HTTPCache) {
+	t.Helper()
+	var calls atomic.Int64
+	c := gc.NewHTTPCache(store, time.Minute, nil)
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private")
+		case "/lang":
+			w.Header().Set("Vary", "Accept-Language")
+			io.WriteString(w, r.Header.Get("Accept-Language")+" ")
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "call "+strconv.FormatInt(n, 10))
+	}))
+	return h, &calls, c
+}
+
+func serve(h http.Handler, method, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, http.NoBody)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPCache(t *testing.T) {
+	t.Parallel()
+
+	store := gc.NewMemoryCacheStore(0)
+	h, calls, c := cachedHandler(t, store)
+
+	w := serve(h, http.MethodGet, "/api")
+	if w.Header().Get("X-Cache") != "MISS" || w.Body.String() != "call 1" {
+		t.Fatalf("first request: %v %q", w.Header(), w.Body)
+	}
+	w = serve(h, http.MethodGet, "/api")
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "call 1" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("second request should hit: %v %q", w.Header(), w.Body)
+	}
+
+	// request directives
+	if w = serve(h, http.MethodGet, "/api", "Cache-Control", "no-cache"); w.Body.String() != "call 2" {
+		t.Errorf("no-cache should refresh, got %q", w.Body)
+	}
+	if w = serve(h, http.MethodGet, "/api"); w.Body.String() != "call 2" {
+		t.Errorf("want the refreshed response, got %q", w.Body)
+	}
+	if w = serve(h, http.MethodPost, "/api"); w.Body.String() != "call 3" {
+		t.Errorf("POST must bypass, got %q", w.Body)
+	}
+
+	// not cacheable responses
+	for _, target := range []string{"/private", "/error"} {
+		serve(h, http.MethodGet, target)
+		before := calls.Load()
+		serve(h, http.MethodGet, target)
+		if calls.Load() != before+1 {
+			t.Errorf("%s must not be cached", target)
+		}
+	}
+	// the responses to an authenticated user are not shared with the other users
+	for _, header := range [][]string{{"Authorization", "Bearer jane"}, {"Cookie", "jwt=jane"}} {
+		mine := serve(h, http.MethodGet, "/me", header...).Body.String()
+		if w = serve(h, http.MethodGet, "/me"); w.Body.String() == mine {
+			t.Errorf("the response to %s must not be cached: %q", header[0], mine)
+		}
+		_ = store.Delete(context.Background(), "example.com/me")
+	}
+
+	serve(h, http.MethodGet, "/missing")
+	if w = serve(h, http.MethodGet, "/missing"); w.Code != http.StatusNotFound || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("404 should be cached: %d %v", w.Code, w.Header())
+	}
+
+	// Vary
+	fr := serve(h, http.MethodGet, "/lang", "Accept-Language", "fr").Body.String()
+	en := serve(h, http.MethodGet, "/lang", "Accept-Language", "en").Body.String()
+	if fr == en {
+		t.Errorf("the variants must differ: %q %q", fr, en)
+	}
+	if w = serve(h, http.MethodGet, "/lang", "Accept-Language", "fr"); w.Body.String() != fr {
+		t.Errorf("want the fr variant %q, got %q", fr, w.Body)
+	}
+
+	// manual invalidation
+	err := store.DeletePrefix(context.Background(), "example.com/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w = serve(h, http.MethodGet, "/api"); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("want a miss after invalidation, got %v", w.Header())
+	}
+
+	s := c.Stats()
+	if s.Hits == 0 || s.Misses == 0 || s.Bypasses != 1 || s.HitRate <= 0 || s.HitRate >= 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestHTTPCache_Metrics(t *testing.T) {
+	t.Parallel()
+
+	g := gc.New(gc.WithServerName("cache-test"))
+	h := g.MiddlewareCache(gc.NewMemoryCacheStore(10), time.Minute, nil)(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	serve(h, http.MethodGet, "/")
+	serve(h, http.MethodGet, "/")
+
+	w := serve(gc.New().ExporterHandler(), http.MethodGet, "/metrics")
+	body := w.Body.String()
+	for _, want := range []string{
+		`cache_test_http_cache_requests_total{result="hit"} 1`,
+		`cache_test_http_cache_requests_total{result="miss"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+// fakeRedis serves GET, SET, DEL, SCAN and PING from a map.
+func fakeRedis(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	data := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					conn.Write([]byte(redisReply(data, args)))
+					mu.Unlock()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		_, err = r.ReadString('\n') // $len
+		if err != nil {
+			return nil, err
+		}
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(line, "\r\n")
+	}
+	return args, nil
+}
+
+func redisReply(data map[string]string, args []string) string {
+	switch args[0] {
+	case "PING":
+		return "+PONG\r\n"
+	case "SET":
+		data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "GET":
+		v, ok := data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	case "DEL":
+		for _, k := range args[1:] {
+			delete(data, k)
+		}
+		return ":1\r\n"
+	case "SCAN":
+		prefix := strings.TrimSuffix(args[3], "*")
+		var keys []string
+		for k := range data {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, "$"+strconv.Itoa(len(k))+"\r\n"+k+"\r\n")
+			}
+		}
+		return "*2\r\n$1\r\n0\r\n*" + strconv.Itoa(len(keys)) + "\r\n" + strings.Join(keys, "")
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisCacheStore(t *testing.T) {
+	t.Parallel()
+
+	store, err := gc.NewRedisCacheStore("redis://"+fakeRedis(t), "test:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	h, calls, _ := cachedHandler(t, store)
+	serve(h, http.MethodGet, "/api/a")
+	serve(h, http.MethodGet, "/api/b")
+	if w := serve(h, http.MethodGet, "/api/a"); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "call 1" {
+		t.Errorf("want a hit from Redis: %v %q", w.Header(), w.Body)
+	}
+
+	err = store.DeletePrefix(context.Background(), "example.com/api/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(h, http.MethodGet, "/api/a")
+	if calls.Load() != 3 {
+		t.Errorf("want 3 calls after invalidation, got %d", calls.Load())
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// IdempotencyStore is a CacheStore able to create a key atomically
+	// (the MemoryCacheStore and the RedisCacheStore implement it).
+	IdempotencyStore interface {
+		CacheStore
+		// SetIfAbsent stores the value only when the key is missing, ok reports whether it was stored.
+		SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (ok bool, err error)
+	}
+
+	// idempotentResponse is the stored response, Status is zero while the first request is processed.
+	idempotentResponse struct {
+		Header      http.Header `json:"h,omitempty"`
+		Fingerprint string      `json:"f"`
+		Body        []byte      `json:"b,omitempty"`
+		Status      int         `json:"s,omitempty"`
+	}
+
+	// hashingReader hashes the request body while the handler reads it.
+	hashingReader struct {
+		io.ReadCloser
+		h hash.Hash
+	}
+)
+
+const (
+	// IdempotencyKeyHeader is the request header of MiddlewareIdempotency.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set to "true" on the replayed responses.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	maxIdempotencyKey     = 255
+	maxIdempotentResponse = 1 << 20
+	idempotencyKeyPrefix  = "idempotency:"
+)
+
+// MiddlewareIdempotency uses the Writer of Garcon to respond the errors.
+func (g *Garcon) MiddlewareIdempotency(store IdempotencyStore, ttl time.Duration) gg.Middleware {
+	return MiddlewareIdempotency(g.Writer, store, ttl)
+}
+
+// MiddlewareIdempotency makes the POST and PATCH requests having an Idempotency-Key header
+// safe against the client retries (payments, webhooks...):
+//
+//	store := gc.NewMemoryCacheStore(10000) // or a RedisCacheStore shared by the instances
+//	r.With(g.MiddlewareIdempotency(store, 24*time.Hour)).Post("/api/payments", pay)
+//
+// The first request is processed and its response is stored during ttl,
+// the retries receive the same response with the header "Idempotent-Replayed: true".
+// The key is scoped by the method, the path and the Authorization and Cookie headers.
+// Use a store dedicated to MiddlewareIdempotency: a full MemoryCacheStore rejects
+// the new keys with 503 Service Unavailable instead of evicting the live ones.
+//
+// A retry arriving while the first request is processed is rejected with 409 Conflict,
+// a key reused with another request body with 422 Unprocessable Entity.
+// The 5xx responses (and the responses larger than 1 MiB) are not stored: the client may retry.
+// When the store is unavailable, the requests are rejected with 503 Service Unavailable.
+func MiddlewareIdempotency(gw gg.Writer, store IdempotencyStore, ttl time.Duration) gg.Middleware {
+	if store == nil {
+		log.Panic("MiddlewareIdempotency requires an IdempotencyStore")
+	}
+	if ttl <= 0 {
+		log.Panic("MiddlewareIdempotency requires a positive TTL, got", ttl)
+	}
+	log.Info("MiddlewareIdempotency stores the responses during", ttl)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			idemKey := r.Header.Get(IdempotencyKeyHeader)
+			if idemKey == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(idemKey) > maxIdempotencyKey {
+				gw.WriteErr(w, r, http.StatusBadRequest, "Idempotency-Key too long", "max", maxIdempotencyKey)
+				return
+			}
+
+			key := idempotencyStoreKey(r, idemKey)
+			ctx := r.Context()
+			processing, err := json.Marshal(idempotentResponse{})
+			if err != nil {
+				gw.WriteErr(w, r, http.StatusInternalServerError, "Cannot serialize", "error", err)
+				return
+			}
+
+			created, err := store.SetIfAbsent(ctx, key, processing, ttl)
+			if err != nil {
+				log.Warn("MiddlewareIdempotency:", err)
+				gw.WriteErr(w, r, http.StatusServiceUnavailable, "Idempotency store unavailable")
+				return
+			}
+			if !created {
+				replayIdempotent(gw, w, r, store, key)
+				return
+			}
+
+			hr := &hashingReader{ReadCloser: r.Body, h: sha256.New()}
+			r.Body = hr
+			rec := &cacheRecorder{ResponseWriter: w, max: maxIdempotentResponse, status: http.StatusOK}
+			stored := false
+			defer func() {
+				if !stored { // also on panic: release the key
+					err := store.Delete(context.WithoutCancel(ctx), key)
+					if err != nil {
+						log.Warn("MiddlewareIdempotency:", err)
+					}
+				}
+			}()
+
+			next.ServeHTTP(rec, r)
+			if rec.header == nil {
+				rec.header = w.Header().Clone()
+			}
+			if rec.status >= http.StatusInternalServerError || rec.over {
+				return
+			}
+
+			data, err := json.Marshal(idempotentResponse{
+				Status:      rec.status,
+				Header:      rec.header,
+				Body:        rec.body.Bytes(),
+				Fingerprint: hr.sum(),
+			})
+			if err == nil {
+				err = store.Set(context.WithoutCancel(ctx), key, data, ttl)
+			}
+			if err != nil {
+				log.Warn("MiddlewareIdempotency:", err)
+				return
+			}
+			stored = true
+		})
+	}
+}
+
+// replayIdempotent writes the stored response of a retry.
+func replayIdempotent(gw gg.Writer, w http.ResponseWriter, r *http.Request, store IdempotencyStore, key string) {
+	data, ok, err := store.Get(r.Context(), key)
+	if err != nil {
+		log.Warn("MiddlewareIdempotency:", err)
+		gw.WriteErr(w, r, http.StatusServiceUnavailable, "Idempotency store unavailable")
+		return
+	}
+
+	var resp idempotentResponse
+	if ok {
+		err = json.Unmarshal(data, &resp)
+	}
+	if !ok || err != nil || resp.Status == 0 {
+		// the first request is still processed (or has just failed)
+		w.Header().Set("Retry-After", "1")
+		gw.WriteErr(w, r, http.StatusConflict, "A request with the same Idempotency-Key is being processed")
+		return
+	}
+
+	hr := hashingReader{ReadCloser: r.Body, h: sha256.New()}
+	if hr.sum() != resp.Fingerprint {
+		gw.WriteErr(w, r, http.StatusUnprocessableEntity, "Idempotency-Key reused with another request body")
+		return
+	}
+
+	h := w.Header()
+	for k, v := range resp.Header {
+		h[k] = v
+	}
+	h.Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(resp.Status)
+	_, err = w.Write(resp.Body)
+	if err != nil {
+		log.Warn("MiddlewareIdempotency:", err)
+	}
+}
+
+// idempotencyStoreKey scopes the Idempotency-Key by method, path and credentials.
+func idempotencyStoreKey(r *http.Request, idemKey string) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	io.WriteString(h, r.Header.Get("Authorization")+"\n")
+	io.WriteString(h, r.Header.Get("Cookie")+"\n")
+	io.WriteString(h, idemKey)
+	return idempotencyKeyPrefix + base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func (hr *hashingReader) Read(p []byte) (int, error) {
+	n, err := hr.ReadCloser.Read(p)
+	hr.h.Write(p[:n])
+	return n, err
+}
+
+// sum hashes the remaining body not read by the handler, and returns the hash of the whole body.
+func (hr *hashingReader) sum() string {
+	_, err := io.Copy(hr.h, hr.ReadCloser)
+	if err != nil {
+		log.Warn("MiddlewareIdempotency: body", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(hr.h.Sum(nil))
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestMiddlewareIdempotency(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int64
+	release := make(chan struct{})
+	mw := gc.MiddlewareIdempotency(gg.Writer(""), gc.NewMemoryCacheStore(0), time.Minute)
+	h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if string(body) == "slow" {
+			<-release
+		}
+		if string(body) == "fail" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Location", "/payments/"+strconv.FormatInt(n, 10))
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "payment "+strconv.FormatInt(n, 10))
+	}))
+
+	post := func(key, body string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set(gc.IdempotencyKeyHeader, key)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("k1", "10 EUR")
+	if w.Code != http.StatusCreated || w.Body.String() != "payment 1" {
+		t.Fatalf("first: %d %q", w.Code, w.Body)
+	}
+	w = post("k1", "10 EUR")
+	if w.Code != http.StatusCreated || w.Body.String() != "payment 1" ||
+		w.Header().Get("Location") != "/payments/1" || w.Header().Get(gc.IdempotentReplayedHeader) != "true" {
+		t.Errorf("retry should replay: %d %q %v", w.Code, w.Body, w.Header())
+	}
+	if w = post("k1", "99 EUR"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("another body: want 422 got %d", w.Code)
+	}
+	if w = post("k1", "10 EUR", "Authorization", "Bearer other"); w.Body.String() != "payment 2" {
+		t.Errorf("another user must not get the replay, got %q", w.Body)
+	}
+	if w = post("k1", "10 EUR", "Cookie", "jwt=other"); w.Body.String() != "payment 3" {
+		t.Errorf("another cookie user must not get the replay, got %q", w.Body)
+	}
+	if w = post("", "10 EUR"); w.Body.String() != "payment 4" {
+		t.Errorf("no key: want a new payment, got %q", w.Body)
+	}
+
+	// 5xx are not stored
+	post("k2", "fail")
+	before := calls.Load()
+	post("k2", "fail")
+	if calls.Load() != before+1 {
+		t.Error("a 5xx response must not be replayed")
+	}
+
+	// concurrent duplicate
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- post("k3", "slow") }()
+	for calls.Load() != before+2 {
+		time.Sleep(time.Millisecond)
+	}
+	if w = post("k3", "slow"); w.Code != http.StatusConflict {
+		t.Errorf("concurrent duplicate: want 409 got %d", w.Code)
+	}
+	close(release)
+	if w = <-done; w.Code != http.StatusCreated {
+		t.Errorf("first slow request: want 201 got %d", w.Code)
+	}
+}
+
+func TestMemoryCacheStore_Full(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store := gc.NewMemoryCacheStore(2)
+	mustSet := func(key string, ttl time.Duration) {
+		t.Helper()
+		ok, err := store.SetIfAbsent(ctx, key, []byte(key), ttl)
+		if !ok || err != nil {
+			t.Fatal(key, ok, err)
+		}
+	}
+	mustSet("processing", time.Hour)
+	mustSet("stored", 2*time.Hour)
+
+	// the live idempotency keys are never evicted
+	ok, err := store.SetIfAbsent(ctx, "new", []byte("new"), time.Hour)
+	if ok || !errors.Is(err, gc.ErrCacheStoreFull) {
+		t.Errorf("full store: want ErrCacheStoreFull, got ok=%v err=%v", ok, err)
+	}
+	for _, k := range []string{"processing", "stored"} {
+		if _, ok, _ := store.Get(ctx, k); !ok {
+			t.Errorf("%s must be kept", k)
+		}
+	}
+
+	// Set evicts the entry expiring first
+	err = store.Set(ctx, "cached", []byte("cached"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := store.Get(ctx, "processing"); ok {
+		t.Error("the entry expiring first should be evicted")
+	}
+	for _, k := range []string{"stored", "cached"} {
+		if _, ok, _ := store.Get(ctx, k); !ok {
+			t.Errorf("%s must be kept", k)
+		}
+	}
+}
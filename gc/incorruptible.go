@@ -0,0 +1,77 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/lynxai-team/incorruptible"
+)
+
+// UserKey is the Incorruptible value index storing the username.
+// The other application values should use the indexes from 1 to incorruptible.MaxValues-1.
+const UserKey = 0
+
+var ErrUserKey = errors.New("the index 0 (UserKey) is reserved for the username")
+
+// ValuesFromCtx gets the decoded Incorruptible token
+// stored in the request context by the Set, Chk and Vet middlewares.
+// ValuesFromCtx is the Incorruptible counterpart of gwt.PermFromCtx.
+func ValuesFromCtx(r *http.Request) (incorruptible.TValues, bool) {
+	return incorruptible.FromCtx(r)
+}
+
+// UserFromCtx gets the username stored in the Incorruptible token
+// (see StoreInToken). The boolean is false when there is no username.
+func UserFromCtx(r *http.Request) (string, bool) {
+	tv, ok := incorruptible.FromCtx(r)
+	if !ok {
+		return "", false
+	}
+	user, err := tv.String(UserKey)
+	if err != nil || user == "" {
+		return "", false
+	}
+	return user, true
+}
+
+// StoreInToken sets a new Incorruptible cookie conveying the username and the other small values
+// (an Incorruptible token is limited to incorruptible.MaxValues values).
+// StoreInToken returns the request with the updated token in its context,
+// so the next handlers can use UserFromCtx and ValuesFromCtx.
+//
+//	r, err := gc.StoreInToken(w, r, inc, "jane", incorruptible.String(1, "fr"), incorruptible.Bool(2, true))
+func StoreInToken(w http.ResponseWriter, r *http.Request, inc *incorruptible.Incorruptible, user string, kv ...incorruptible.KVal) (*http.Request, error) {
+	for _, v := range kv {
+		if kvKey(v) == UserKey {
+			return r, ErrUserKey
+		}
+	}
+
+	kv = append([]incorruptible.KVal{incorruptible.String(UserKey, user)}, kv...)
+	cookie, tv, err := inc.NewCookie(r, kv...)
+	if err != nil {
+		return r, err
+	}
+
+	http.SetCookie(w, cookie)
+	return tv.ToCtx(r), nil
+}
+
+func kvKey(v incorruptible.KVal) int {
+	switch k := v.(type) {
+	case incorruptible.KString:
+		return k.Key
+	case incorruptible.KUint64:
+		return k.Key
+	case incorruptible.KInt64:
+		return k.Key
+	case incorruptible.KBool:
+		return k.Key
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lynxai-team/incorruptible"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestStoreInToken(t *testing.T) {
+	t.Parallel()
+
+	g := gc.New(gc.WithURLs("http://localhost:8080/myapp"))
+	inc := g.IncorruptibleChecker("00112233445566778899aabbccddeeff", 60, false)
+
+	r := httptest.NewRequest(http.MethodGet, "/myapp", http.NoBody)
+	w := httptest.NewRecorder()
+
+	if _, ok := gc.UserFromCtx(r); ok {
+		t.Error("UserFromCtx must return false without token")
+	}
+
+	_, err := gc.StoreInToken(w, r, inc, "jane", incorruptible.String(gc.UserKey, "john"))
+	if !errors.Is(err, gc.ErrUserKey) {
+		t.Errorf("want ErrUserKey, got %v", err)
+	}
+
+	r2, err := gc.StoreInToken(w, r, inc, "jane", incorruptible.String(1, "fr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, ok := gc.UserFromCtx(r2); !ok || user != "jane" {
+		t.Errorf("UserFromCtx = %q, %v", user, ok)
+	}
+
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("want 1 cookie, got %d", len(cookies))
+	}
+
+	// a new request conveying the cookie goes through the Chk middleware
+	r3 := httptest.NewRequest(http.MethodGet, "/myapp", http.NoBody)
+	r3.AddCookie(cookies[0])
+	var lang, user string
+	h := inc.Chk(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		user, _ = gc.UserFromCtx(r)
+		tv, ok := gc.ValuesFromCtx(r)
+		if ok {
+			lang = tv.StringIfAny(1)
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), r3)
+
+	if user != "jane" || lang != "fr" {
+		t.Errorf("got user=%q lang=%q", user, lang)
+	}
+}
@@ -0,0 +1,304 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"sync"
+
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+// JSON-RPC 2.0 pre-defined error codes.
+const (
+	RPCParseError     gerr.Code = -32700
+	RPCInvalidRequest gerr.Code = -32600
+	RPCMethodNotFound gerr.Code = -32601
+	RPCInvalidParams  gerr.Code = -32602
+	RPCInternalError  gerr.Code = -32603
+)
+
+type (
+	// JSONRPC is a JSON-RPC 2.0 server over HTTP POST, to build MCP-style services on Garcon:
+	//
+	//	rpc := gc.NewJSONRPC()
+	//	gc.RPCMethod(rpc, "sum", func(ctx context.Context, p []int) (int, error) { ... })
+	//	gc.RPCMethod(rpc, "user.get", func(ctx context.Context, p GetUser) (*User, error) {
+	//		return nil, gerr.New(gerr.NotFound, "unknown user", "id", p.ID)
+	//	})
+	//	mux.Handle("POST /rpc", rpc)
+	//
+	// The batch requests are decoded and answered as a stream, one call at a time.
+	// The errors returned by the methods are converted into JSON-RPC error objects:
+	// a gerr.Error keeps its code, message and params (redacted), the other errors
+	// become "internal error" (-32603) without leaking their details.
+	JSONRPC struct {
+		methods map[string]rpcMethod
+		// MaxBodySize limits the size of the request body (default 1 MiB).
+		MaxBodySize int64
+		// MaxBatch limits the number of calls of a batch request (default 100).
+		MaxBatch int
+		mu       sync.RWMutex
+	}
+
+	rpcMethod func(ctx context.Context, params json.RawMessage) (any, error)
+
+	rpcRequest struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		ID      json.RawMessage `json:"id"` // nil for a notification
+	}
+
+	rpcResponse struct {
+		Error   *rpcError       `json:"error,omitempty"`
+		JSONRPC string          `json:"jsonrpc"`
+		Result  json.RawMessage `json:"result,omitempty"`
+		ID      json.RawMessage `json:"id"`
+	}
+
+	rpcError struct {
+		Data    map[string]any `json:"data,omitempty"`
+		Message string         `json:"message"`
+		Code    gerr.Code      `json:"code"`
+	}
+)
+
+//nolint:gochecknoglobals // constant
+var jsonNull = json.RawMessage("null")
+
+// NewJSONRPC creates a JSON-RPC server without methods.
+func NewJSONRPC() *JSONRPC {
+	return &JSONRPC{
+		methods:     map[string]rpcMethod{},
+		MaxBodySize: 1 << 20,
+		MaxBatch:    100,
+	}
+}
+
+// RPCMethod registers the method name, its params are decoded into P
+// (by-position params as a slice or array, by-name params as a struct or a map).
+// Use NoBody when the method has no params.
+func RPCMethod[P, R any](s *JSONRPC, name string, fn func(ctx context.Context, params P) (R, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[name] = func(ctx context.Context, raw json.RawMessage) (any, error) {
+		var params P
+		if len(raw) > 0 && !bytes.Equal(raw, jsonNull) {
+			err := json.Unmarshal(raw, &params)
+			if err != nil {
+				return nil, gerr.Wrap(err, RPCInvalidParams, "invalid params", "method", name)
+			}
+		}
+		return fn(ctx, params)
+	}
+}
+
+func (s *JSONRPC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := bufio.NewReader(http.MaxBytesReader(w, r.Body, s.MaxBodySize))
+	first, err := firstNonSpace(body)
+	if err != nil {
+		s.writeOne(w, rpcFailure(nil, gerr.Wrap(err, RPCParseError, "parse error")))
+		return
+	}
+
+	dec := json.NewDecoder(body)
+	if first != '[' {
+		var req rpcRequest
+		err = dec.Decode(&req)
+		if err != nil {
+			s.writeOne(w, rpcFailure(nil, gerr.Wrap(err, RPCParseError, "parse error")))
+			return
+		}
+		resp := s.call(r.Context(), &req)
+		if resp == nil { // notification
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		s.writeOne(w, resp)
+		return
+	}
+
+	s.serveBatch(r.Context(), w, dec)
+}
+
+// Dispatch answers a JSON-RPC message (single call or batch) received outside
+// of the HTTP request/response cycle, e.g. the MCP messages answered through a SSE stream.
+// The ctx is passed to the methods (keep the context of the HTTP request to keep its values).
+// The response is nil when the message contains only notifications.
+func (s *JSONRPC) Dispatch(ctx context.Context, msg []byte) []byte {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", bytes.NewReader(msg))
+	if err != nil {
+		return nil
+	}
+	rec := &prerenderRecorder{header: http.Header{}, status: http.StatusOK}
+	s.ServeHTTP(rec, r)
+	if rec.status == http.StatusNoContent {
+		return nil
+	}
+	return bytes.TrimSpace(rec.body.Bytes())
+}
+
+// serveBatch streams the responses of the batch: the "[" is written with the first response,
+// a batch of notifications gets "204 No Content".
+func (s *JSONRPC) serveBatch(ctx context.Context, w http.ResponseWriter, dec *json.Decoder) {
+	_, err := dec.Token() // [
+	if err != nil {
+		s.writeOne(w, rpcFailure(nil, gerr.Wrap(err, RPCParseError, "parse error")))
+		return
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	started := false
+	write := func(resp *rpcResponse) {
+		if started {
+			bw.WriteByte(',')
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			bw.WriteByte('[')
+			started = true
+		}
+		enc.Encode(resp)
+	}
+
+	n := 0
+	for dec.More() {
+		n++
+		var req rpcRequest
+		err = dec.Decode(&req)
+		switch {
+		case err != nil:
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+				write(rpcFailure(nil, gerr.Wrap(err, RPCParseError, "parse error")))
+				n = -1 // stop: the stream cannot be resynchronized
+			} else {
+				write(rpcFailure(nil, gerr.Wrap(err, RPCInvalidRequest, "invalid request")))
+			}
+		case n > s.MaxBatch:
+			write(rpcFailure(req.ID, gerr.New(RPCInvalidRequest, "batch too large", "max", s.MaxBatch)))
+		default:
+			if resp := s.call(ctx, &req); resp != nil {
+				write(resp)
+			}
+		}
+		if n < 0 {
+			break
+		}
+	}
+
+	switch {
+	case n == 0:
+		s.writeOne(w, rpcFailure(nil, gerr.New(RPCInvalidRequest, "empty batch")))
+	case !started:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		bw.WriteByte(']')
+		bw.Flush()
+	}
+}
+
+// call invokes the method, the response is nil for a notification.
+func (s *JSONRPC) call(ctx context.Context, req *rpcRequest) (resp *rpcResponse) {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcFailure(req.ID, gerr.New(RPCInvalidRequest, "invalid request", "jsonrpc", req.JSONRPC))
+	}
+
+	s.mu.RLock()
+	method, ok := s.methods[req.Method]
+	s.mu.RUnlock()
+	if !ok {
+		if req.ID == nil {
+			return nil
+		}
+		return rpcFailure(req.ID, gerr.New(RPCMethodNotFound, "method not found", "method", req.Method))
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			err := gerr.Recovered(v)
+			log.Warn("JSON-RPC panic in", req.Method, err)
+			resp = nil
+			if req.ID != nil {
+				resp = rpcFailure(req.ID, err)
+			}
+		}
+	}()
+
+	result, err := method(ctx, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		return rpcFailure(req.ID, err)
+	}
+	raw, err := json.Marshal(result)
+	if err != nil {
+		return rpcFailure(req.ID, err)
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: raw, ID: req.ID}
+}
+
+// rpcFailure converts err into a JSON-RPC error response.
+func rpcFailure(id json.RawMessage, err error) *rpcResponse {
+	if id == nil {
+		id = jsonNull
+	}
+	e := &rpcError{Code: RPCInternalError, Message: "internal error"}
+	var gErr *gerr.Error
+	if errors.As(err, &gErr) && gErr.Code != gerr.ServerErr {
+		e.Code = gErr.Code
+		e.Message = gErr.Message
+		e.Data = gerr.RedactParams(gErr.Data.Params)
+		clientErr := gErr.Code == RPCParseError || gErr.Code == RPCInvalidParams || gErr.Code == RPCInvalidRequest
+		if clientErr && gErr.Data.Cause != nil {
+			e.Data = maps.Clone(e.Data)
+			if e.Data == nil {
+				e.Data = map[string]any{}
+			}
+			e.Data["cause"] = gErr.Data.Cause.Error()
+		}
+	} else {
+		log.Warn("JSON-RPC internal error:", err)
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: e, ID: id}
+}
+
+func (*JSONRPC) writeOne(w http.ResponseWriter, resp *rpcResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		log.Warn("JSON-RPC write", err)
+	}
+}
+
+// firstNonSpace peeks the first significant byte of the body.
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, r.UnreadByte()
+	}
+}
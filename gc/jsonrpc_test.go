@@ -0,0 +1,78 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+func TestJSONRPC(t *testing.T) {
+	t.Parallel()
+
+	rpc := gc.NewJSONRPC()
+	gc.RPCMethod(rpc, "sum", func(_ context.Context, p []int) (int, error) {
+		total := 0
+		for _, n := range p {
+			total += n
+		}
+		return total, nil
+	})
+	gc.RPCMethod(rpc, "user.get", func(_ context.Context, p struct{ ID int }) (string, error) {
+		if p.ID != 1 {
+			return "", gerr.New(gerr.NotFound, "unknown user", "id", p.ID)
+		}
+		return "alice", nil
+	})
+	gc.RPCMethod(rpc, "fail", func(context.Context, gc.NoBody) (any, error) {
+		return nil, errors.New("db password=secret")
+	})
+	gc.RPCMethod(rpc, "panic", func(context.Context, gc.NoBody) (any, error) {
+		panic("boom")
+	})
+
+	post := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		rpc.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rpc", strings.NewReader(body)))
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	cases := []struct {
+		body string
+		want string
+		code int
+	}{
+		{`{"jsonrpc":"2.0","method":"sum","params":[1,2,3],"id":1}`, `{"jsonrpc":"2.0","result":6,"id":1}`, 200},
+		{`{"jsonrpc":"2.0","method":"user.get","params":{"ID":1},"id":"a"}`, `{"jsonrpc":"2.0","result":"alice","id":"a"}`, 200},
+		{`{"jsonrpc":"2.0","method":"user.get","params":{"ID":2},"id":2}`, `{"error":{"data":{"id":2},"message":"unknown user","code":-32143},"jsonrpc":"2.0","id":2}`, 200},
+		{`{"jsonrpc":"2.0","method":"sum","params":{"a":1},"id":3}`, `"code":-32602`, 200},
+		{`{"jsonrpc":"2.0","method":"nope","id":4}`, `{"error":{"data":{"method":"nope"},"message":"method not found","code":-32601},"jsonrpc":"2.0","id":4}`, 200},
+		{`{"jsonrpc":"2.0","method":"fail","id":5}`, `{"error":{"message":"internal error","code":-32603},"jsonrpc":"2.0","id":5}`, 200},
+		{`{"jsonrpc":"2.0","method":"panic","id":6}`, `{"error":{"message":"internal error","code":-32603},"jsonrpc":"2.0","id":6}`, 200},
+		{`{"jsonrpc":"1.0","method":"sum","id":7}`, `"code":-32600`, 200},
+		{`{"jsonrpc":"2.0","method":"sum","params":[1]}`, ``, 204},
+		{`{"jsonrpc":"2.0","method"`, `"code":-32700`, 200},
+		{`[]`, `"code":-32600`, 200},
+		{`[{"jsonrpc":"2.0","method":"sum"},{"jsonrpc":"2.0","method":"nope"}]`, ``, 204},
+		{
+			` [{"jsonrpc":"2.0","method":"sum","params":[2,2],"id":1}, 1, {"jsonrpc":"2.0","method":"sum","params":[1]}, {"jsonrpc":"2.0","method":"sum","params":[3,3],"id":2}]`,
+			`[{"jsonrpc":"2.0","result":4,"id":1}` + "\n" + `,{"error":{"data":{"cause":"json: cannot unmarshal number into Go value of type gc.rpcRequest"},"message":"invalid request","code":-32600},"jsonrpc":"2.0","id":null}` + "\n" + `,{"jsonrpc":"2.0","result":6,"id":2}` + "\n" + `]`,
+			200,
+		},
+	}
+	for _, c := range cases {
+		code, body := post(c.body)
+		if code != c.code || (c.want == "" && body != "") || !strings.Contains(body, c.want) {
+			t.Errorf("%s\n got %d %s\nwant %d %s", c.body, code, body, c.code, c.want)
+		}
+	}
+}
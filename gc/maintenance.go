@@ -0,0 +1,176 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"html/template"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// maintenanceAllowed are the probe endpoints always served during the maintenance.
+//
+//nolint:gochecknoglobals // constant list
+var maintenanceAllowed = []string{"/health", "/healthz", "/ready", "/readyz", "/metrics"}
+
+// defaultMaintenancePage is the 503 page, the data are MaintenanceData.
+//
+//nolint:gochecknoglobals // constant template
+var defaultMaintenancePage = template.Must(template.New("maintenance").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width,initial-scale=1">
+<title>{{.ServerName}} – Maintenance</title></head>
+<body style="font-family:sans-serif;text-align:center;margin-top:15vh">
+<h1>{{.ServerName}} is under maintenance</h1>
+<p>We will be back soon{{with .RetryAfter}}, please retry in {{.}}{{end}}.</p>
+</body></html>
+`))
+
+// MaintenanceData is the data of the template customizing the maintenance page.
+type MaintenanceData struct {
+	ServerName string
+	RetryAfter time.Duration
+}
+
+// WithMaintenancePage customizes the 503 page of MiddlewareMaintenance (the template receives
+// a MaintenanceData) and its Retry-After header (default 5 minutes). A nil page keeps the default page.
+func WithMaintenancePage(page *template.Template, retryAfter time.Duration) Option {
+	return func(g *Garcon) {
+		g.maintenancePage = page
+		g.maintenanceRetry = retryAfter
+	}
+}
+
+// MiddlewareMaintenance responds "503 Service Unavailable" with Retry-After
+// while flag is true: the browsers receive the maintenance page (see WithMaintenancePage),
+// the API clients a JSON error. The probe endpoints (/health, /healthz, /ready, /readyz, /metrics)
+// and the allowlist are still served: an entry ending with "/" is a path prefix
+// (e.g. "/admin/"), else the path must match exactly.
+//
+//	var maintenance atomic.Bool
+//	mw := gg.NewChain(g.MiddlewareMaintenance(&maintenance, "/admin/"))
+//	mux.Handle("/admin/maintenance", ck.Chk(gc.MaintenanceToggle(&maintenance)))
+//	g.MaintenanceOnSIGHUP(&maintenance) // kill -HUP toggles the maintenance mode
+func (g *Garcon) MiddlewareMaintenance(flag *atomic.Bool, allowlist ...string) gg.Middleware {
+	page := g.maintenancePage
+	if page == nil {
+		page = defaultMaintenancePage
+	}
+	retry := g.maintenanceRetry
+	if retry <= 0 {
+		retry = 5 * time.Minute
+	}
+
+	var buf bytes.Buffer
+	err := page.Execute(&buf, MaintenanceData{ServerName: string(g.ServerName), RetryAfter: retry})
+	if err != nil {
+		log.Panic("MiddlewareMaintenance cannot render the maintenance page:", err)
+	}
+	html := buf.Bytes()
+	retryAfter := strconv.Itoa(int(retry.Seconds()))
+	allowed := slices.Concat(allowlist, maintenanceAllowed)
+
+	log.Info("MiddlewareMaintenance Retry-After=" + retryAfter + "s allow=" + strings.Join(allowlist, ","))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !flag.Load() || maintenanceAllow(allowed, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Retry-After", retryAfter)
+			w.Header().Set("Cache-Control", "no-store")
+			if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+				g.Writer.WriteErr(w, r, http.StatusServiceUnavailable, "Service under maintenance")
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write(html)
+		})
+	}
+}
+
+func maintenanceAllow(allowlist []string, urlPath string) bool {
+	for _, a := range allowlist {
+		if urlPath == a || (strings.HasSuffix(a, "/") && strings.HasPrefix(urlPath, a)) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaintenanceToggle switches the maintenance mode at runtime,
+// protect it with an authentication middleware (e.g. JWTChecker.Chk):
+//
+//	GET    -> {"maintenance":false}
+//	POST   -> enables the maintenance mode
+//	DELETE -> disables the maintenance mode
+func MaintenanceToggle(flag *atomic.Bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			if !flag.Swap(true) {
+				log.Warning("Maintenance mode enabled by", gg.Sanitize(r.RemoteAddr))
+			}
+		case http.MethodDelete:
+			if flag.Swap(false) {
+				log.Info("Maintenance mode disabled by", gg.Sanitize(r.RemoteAddr))
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(`{"maintenance":` + strconv.FormatBool(flag.Load()) + "}\n"))
+	})
+}
+
+// MaintenanceOnSIGHUP registers a hook toggling the maintenance mode
+// each time the process receives SIGHUP, while Garcon.Run is running.
+func (g *Garcon) MaintenanceOnSIGHUP(flag *atomic.Bool) {
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	g.AddHook(Hook{
+		Name: "maintenance SIGHUP",
+		Start: func(context.Context) error {
+			signal.Notify(sig, syscall.SIGHUP)
+			go func() {
+				for {
+					select {
+					case <-done:
+						return
+					case <-sig:
+						enabled := !flag.Load()
+						for !flag.CompareAndSwap(!enabled, enabled) {
+							enabled = !flag.Load()
+						}
+						log.Warning("SIGHUP: maintenance mode =", enabled)
+					}
+				}
+			}()
+			return nil
+		},
+		Stop: func(context.Context) error {
+			signal.Stop(sig)
+			close(done)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestMiddlewareMaintenance(t *testing.T) {
+	t.Parallel()
+
+	page := template.Must(template.New("").Parse("<h1>{{.ServerName}} back in {{.RetryAfter}}</h1>"))
+	g := gc.New(gc.WithServerName("shop"), gc.WithMaintenancePage(page, time.Minute))
+
+	var flag atomic.Bool
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("ok")) })
+	h := g.MiddlewareMaintenance(&flag, "/admin/", "/status")(ok)
+	toggle := gc.MaintenanceToggle(&flag)
+
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, http.NoBody)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := get("/", "text/html"); w.Code != http.StatusOK {
+		t.Fatalf("maintenance disabled: status %d", w.Code)
+	}
+
+	toggle.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/admin/maintenance", http.NoBody))
+	if !flag.Load() {
+		t.Fatal("POST must enable the maintenance mode")
+	}
+
+	w := get("/shop", "text/html,*/*")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("status=%d Retry-After=%q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if body := w.Body.String(); body != "<h1>shop back in 1m0s</h1>" {
+		t.Errorf("page = %q", body)
+	}
+	w = get("/api/items", "application/json")
+	if w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "maintenance") {
+		t.Errorf("API: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	for _, path := range []string{"/healthz", "/readyz", "/admin/users", "/status"} {
+		if w := get(path, ""); w.Code != http.StatusOK {
+			t.Errorf("%s must be allowed, got status %d", path, w.Code)
+		}
+	}
+	if w := get("/status/x", ""); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("/status/x must be blocked, got status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	toggle.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/admin/maintenance", http.NoBody))
+	if flag.Load() || strings.TrimSpace(w.Body.String()) != `{"maintenance":false}` {
+		t.Errorf("DELETE must disable the maintenance mode, got %s", w.Body.String())
+	}
+}
@@ -5,7 +5,12 @@
 package gc
 
 import (
+	"slices"
+	"strconv"
+	"sync"
 	"time"
+
+	"github.com/lynxai-team/garcon/gg"
 )
 
 // Muter can be used to limit the logger/alerting verbosity.
@@ -14,6 +19,12 @@ import (
 // to return to normal situation.
 // Muter uses the Hysteresis principle: https://wikiless.org/wiki/Hysteresis
 // Similar wording: quieter, stopper, limiter, reducer, inhibitor, mouth-closer.
+//
+// When Window is set, Muter uses a sliding time window instead:
+// at most Threshold events are accepted within any Window duration,
+// the Muter un-mutes as soon as the oldest events leave the Window.
+//
+// Muter is safe for concurrent use. See MutedNotifier to suppress the alert storms.
 type Muter struct {
 	// quietTime is the first call of successive Decrement()
 	// without any Increment(). quietTime is used to
@@ -37,13 +48,29 @@ type Muter struct {
 	// dropped is the number of Increment() calls after state became muted.
 	dropped int
 
+	// Window (optional) enables the sliding time window mode: max Threshold events per Window.
+	Window time.Duration
+
+	// times are the accepted events within the Window (sliding window mode only).
+	times []time.Time
+
+	mu sync.Mutex
+
 	// muted represent the Muter state.
 	muted bool
 }
 
 // Increment increments the internal counter and returns false when in muted state.
 // Every RemindMuteState calls, Increment also returns the number of times Increment has been called.
+// In the sliding window mode, Increment returns 1 when the event reaches the limit of the Window.
 func (m *Muter) Increment() (ok bool, dropped int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Window > 0 {
+		return m.incrementWindow(time.Now())
+	}
+
 	m.counter++
 
 	if m.muted {
@@ -69,7 +96,16 @@ func (m *Muter) Increment() (ok bool, dropped int) {
 
 // Decrement decrements the internal counter and switches to un-muted state
 // when counter reaches zero or after NoAlertDuration.
+// In the sliding window mode, Decrement switches to un-muted state when the Window has room again,
+// and returns the time the Muter has been muted.
 func (m *Muter) Decrement() (ok bool, _ time.Time, dropped int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Window > 0 {
+		return m.decrementWindow(time.Now())
+	}
+
 	if !m.muted {
 		return false, time.Time{}, 0 // already un-muted, do nothing
 	}
@@ -93,3 +129,126 @@ func (m *Muter) Decrement() (ok bool, _ time.Time, dropped int) {
 
 	return true, m.quietTime, m.dropped
 }
+
+// Muted reports the Muter state.
+func (m *Muter) Muted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.muted
+}
+
+func (m *Muter) incrementWindow(now time.Time) (ok bool, dropped int) {
+	m.slide(now)
+	limit := max(m.Threshold, 1)
+
+	if len(m.times) >= limit {
+		if !m.muted {
+			m.muted = true
+			m.dropped = 0
+			m.quietTime = now
+		}
+		m.dropped++
+		if (m.RemindMuteState == 0) || (m.dropped%m.RemindMuteState) > 0 {
+			return false, -1
+		}
+		return true, m.dropped
+	}
+
+	m.muted = false
+	m.dropped = 0
+	m.times = append(m.times, now)
+	if len(m.times) == limit {
+		return true, 1
+	}
+	return true, 0
+}
+
+func (m *Muter) decrementWindow(now time.Time) (ok bool, _ time.Time, dropped int) {
+	if !m.muted {
+		return false, time.Time{}, 0
+	}
+	m.slide(now)
+	if len(m.times) >= max(m.Threshold, 1) {
+		return false, time.Time{}, 0
+	}
+	m.muted = false
+	dropped = m.dropped
+	m.dropped = 0
+	return true, m.quietTime, dropped
+}
+
+// slide forgets the events older than the Window.
+func (m *Muter) slide(now time.Time) {
+	i := 0
+	for i < len(m.times) && now.Sub(m.times[i]) >= m.Window {
+		i++
+	}
+	m.times = append(m.times[:0], m.times[i:]...)
+}
+
+// MutedNotifier is a Notifier suppressing the alert storms with a Muter:
+//
+//	n := gc.NewMutedNotifier(telegram, &gc.Muter{Threshold: 5, Window: time.Hour})
+//	err := n.Notify("disk almost full") // at most 5 alerts per hour
+//
+// The first alert accepted after a muted period reports the number of muted alerts.
+// With the hysteresis mode (no Window), call Recover when the situation is back to normal.
+type MutedNotifier struct {
+	next  gg.Notifier
+	muter *Muter
+}
+
+// NewMutedNotifier creates a MutedNotifier.
+func NewMutedNotifier(n gg.Notifier, m *Muter) *MutedNotifier {
+	return &MutedNotifier{next: n, muter: m}
+}
+
+// Notify sends the message with the SeverityInfo unless the Muter is muted.
+func (mn *MutedNotifier) Notify(msg string) error {
+	return mn.NotifyMessage(gg.Message{Text: msg})
+}
+
+// NotifyMessage sends the message unless the Muter is muted.
+func (mn *MutedNotifier) NotifyMessage(msg gg.Message) error {
+	// the caller may share the backing array of msg.Fields (e.g. with other notifiers)
+	msg.Fields = slices.Clip(msg.Fields)
+
+	if mn.muter.Window > 0 {
+		unmuted, since, dropped := mn.muter.Decrement() // the Window may have room again
+		if unmuted && dropped > 0 {
+			msg.Fields = append(msg.Fields, mutedField(dropped, since))
+		}
+	}
+
+	ok, dropped := mn.muter.Increment()
+	switch {
+	case !ok:
+		return nil
+	case dropped == 1:
+		msg.Fields = append(msg.Fields, gg.Field{Name: "muted", Value: "next alerts are muted"})
+	case dropped > 1:
+		msg.Fields = append(msg.Fields, gg.Field{Name: "muted", Value: strconv.Itoa(dropped) + " alerts so far"})
+	}
+	return gg.NotifyMessage(mn.next, msg)
+}
+
+// Recover decrements the Muter and, when it switches to un-muted state,
+// notifies the number of muted alerts.
+func (mn *MutedNotifier) Recover() error {
+	unmuted, since, dropped := mn.muter.Decrement()
+	if !unmuted || dropped <= 0 {
+		return nil
+	}
+	return gg.NotifyMessage(mn.next, gg.Message{
+		Title:  "Alerts un-muted",
+		Fields: []gg.Field{mutedField(dropped, since)},
+	})
+}
+
+func mutedField(dropped int, since time.Time) gg.Field {
+	value := strconv.Itoa(dropped) + " muted alerts"
+	if !since.IsZero() {
+		value += " since " + since.Format(time.DateTime)
+	}
+	return gg.Field{Name: "muted", Value: value}
+}
@@ -0,0 +1,122 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestMuter_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	m := &gc.Muter{Threshold: 10, Window: time.Hour}
+	var accepted sync.WaitGroup
+	var mu sync.Mutex
+	n := 0
+	for range 100 {
+		accepted.Go(func() {
+			if ok, _ := m.Increment(); ok {
+				mu.Lock()
+				n++
+				mu.Unlock()
+			}
+		})
+	}
+	accepted.Wait()
+	if n != 10 || !m.Muted() {
+		t.Errorf("accepted %d events, want 10 (muted=%v)", n, m.Muted())
+	}
+}
+
+func TestMuter_Window(t *testing.T) {
+	t.Parallel()
+
+	const window = 50 * time.Millisecond
+	m := &gc.Muter{Threshold: 2, Window: window}
+
+	results := make([]int, 0, 4)
+	for range 4 {
+		ok, dropped := m.Increment()
+		if !ok {
+			dropped = -1
+		}
+		results = append(results, dropped)
+	}
+	if want := []int{0, 1, -1, -1}; !slices.Equal(results, want) {
+		t.Errorf("Increment results %v want %v", results, want)
+	}
+
+	if ok, _, _ := m.Decrement(); ok {
+		t.Error("Decrement must not un-mute while the window is full")
+	}
+	time.Sleep(window)
+	ok, since, dropped := m.Decrement()
+	if !ok || dropped != 2 || since.IsZero() || m.Muted() {
+		t.Errorf("Decrement after the window: ok=%v since=%v dropped=%d", ok, since, dropped)
+	}
+}
+
+func TestMutedNotifier(t *testing.T) {
+	t.Parallel()
+
+	const window = 50 * time.Millisecond
+	notifier := make(chanNotifier, 10)
+	n := gc.NewMutedNotifier(notifier, &gc.Muter{Threshold: 2, Window: window})
+
+	for range 5 {
+		if err := n.Notify("disk full"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(notifier) != 2 {
+		t.Fatalf("sent %d alerts, want 2", len(notifier))
+	}
+	<-notifier
+	if msg := <-notifier; !strings.Contains(msg, "next alerts are muted") {
+		t.Errorf("second alert %q", msg)
+	}
+
+	time.Sleep(window)
+	if err := n.Notify("disk full"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := <-notifier; !strings.Contains(msg, "3 muted alerts since") {
+		t.Errorf("alert after the window %q", msg)
+	}
+}
+
+func TestMutedNotifier_SharedFields(t *testing.T) {
+	t.Parallel()
+
+	notifier := make(chanNotifier, 10)
+	muted := []*gc.MutedNotifier{
+		gc.NewMutedNotifier(notifier, &gc.Muter{Threshold: 1}),
+		gc.NewMutedNotifier(notifier, &gc.Muter{Threshold: 1}),
+	}
+
+	fields := make([]gg.Field, 1, 4)
+	fields[0] = gg.Field{Name: "repo", Value: "blog"}
+	for range 2 {
+		var wg sync.WaitGroup
+		for _, n := range muted {
+			wg.Go(func() {
+				if err := n.NotifyMessage(gg.Message{Title: "deploy failed", Fields: fields}); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+		wg.Wait()
+	}
+	if fields[:2][1] != (gg.Field{}) {
+		t.Errorf("the caller's array is modified: %+v", fields[:2])
+	}
+}
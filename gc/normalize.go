@@ -0,0 +1,144 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// SlashPolicy selects the canonical form of the URL paths without extension:
+// "/blog/" or "/blog". The paths with an extension ("/style.css") never end with a slash.
+type SlashPolicy int
+
+const (
+	// SlashKeep does not redirect: "/blog" and "/blog/" are two URLs.
+	SlashKeep SlashPolicy = iota
+	// SlashAdd redirects "/blog" to "/blog/".
+	SlashAdd
+	// SlashRemove redirects "/blog/" to "/blog".
+	SlashRemove
+)
+
+// PathNormalization redirects the duplicate-content URLs to their canonical form,
+// the search engines index one URL per page.
+type PathNormalization struct {
+	Slash SlashPolicy
+	// Lowercase redirects "/Blog/Post" to "/blog/post".
+	// The served files must be lowercase, see CheckLowercase.
+	Lowercase bool
+}
+
+// Canonical returns the canonical form of the URL path.
+func (pn PathNormalization) Canonical(urlPath string) string {
+	if pn.Lowercase {
+		urlPath = strings.ToLower(urlPath)
+	}
+	if urlPath == "/" || urlPath == "" {
+		return urlPath
+	}
+
+	switch pn.Slash {
+	case SlashAdd:
+		if !strings.HasSuffix(urlPath, "/") && extIndex(urlPath) == len(urlPath) {
+			urlPath += "/"
+		}
+	case SlashRemove:
+		urlPath = strings.TrimRight(urlPath, "/")
+		if urlPath == "" {
+			urlPath = "/"
+		}
+	case SlashKeep:
+	}
+	return urlPath
+}
+
+// redirect responds a permanent redirection to the canonical path (keeping the query string)
+// and returns true when the requested path is not canonical.
+// The status 308 (instead of 301) makes the clients keep the method and the body of a POST.
+func (pn PathNormalization) redirect(w http.ResponseWriter, r *http.Request) bool {
+	canonical := pn.Canonical(r.URL.Path)
+	if canonical == r.URL.Path {
+		return false
+	}
+
+	// "//evil.com/x" must not redirect to the host evil.com
+	canonical = "/" + strings.TrimLeft(canonical, "/")
+	target := (&url.URL{Path: canonical, RawQuery: r.URL.RawQuery}).String()
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, target, status)
+	return true
+}
+
+// MiddlewareNormalizePath redirects the requests to the canonical URL path
+// (see PathNormalization). Use StaticWebServer.WithPathNormalization for a static site,
+// it also checks the files are reachable.
+func MiddlewareNormalizePath(pn PathNormalization) gg.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !pn.redirect(w, r) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// WithPathNormalization returns a copy of the StaticWebServer redirecting
+// to the canonical URL paths in ServeSite:
+//
+//	ws := g.NewStaticWebServer("dist").WithPathNormalization(gc.PathNormalization{Slash: gc.SlashRemove, Lowercase: true})
+//	mux.HandleFunc("/", ws.ServeSite())
+//
+// With SlashRemove, "/blog" serves the file "blog/index.html".
+// With Lowercase, WithPathNormalization panics when the files in Dir
+// are not lowercase (they would never be served), see CheckLowercase.
+func (ws StaticWebServer) WithPathNormalization(pn PathNormalization) StaticWebServer {
+	if pn.Lowercase {
+		err := CheckLowercase(ws.Dir)
+		if err != nil {
+			log.Panic("WithPathNormalization:", err)
+		}
+	}
+	ws.Normalization = &pn
+	return ws
+}
+
+// CheckLowercase returns an error listing the files and directories having an uppercase letter,
+// and the names colliding once lowercased (e.g. "Team.html" and "team.html").
+func CheckLowercase(dir string) error {
+	var errs []error
+	seen := make(map[string]string)
+	err := filepath.WalkDir(dir, func(p string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		lower := strings.ToLower(rel)
+		if other, ok := seen[lower]; ok {
+			errs = append(errs, errors.New(rel+" collides with "+other))
+		} else if lower != rel {
+			errs = append(errs, errors.New(rel+" is not lowercase"))
+		}
+		seen[lower] = rel
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
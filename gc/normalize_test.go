@@ -0,0 +1,155 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestPathNormalization_Canonical(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		pn   gc.PathNormalization
+		path string
+		want string
+	}{
+		{gc.PathNormalization{}, "/Blog/", "/Blog/"},
+		{gc.PathNormalization{Slash: gc.SlashAdd}, "/blog", "/blog/"},
+		{gc.PathNormalization{Slash: gc.SlashAdd}, "/style.css", "/style.css"},
+		{gc.PathNormalization{Slash: gc.SlashRemove}, "/blog//", "/blog"},
+		{gc.PathNormalization{Slash: gc.SlashRemove}, "/", "/"},
+		{gc.PathNormalization{Slash: gc.SlashRemove, Lowercase: true}, "/Blog/Post/", "/blog/post"},
+	}
+	for _, c := range cases {
+		if got := c.pn.Canonical(c.path); got != c.want {
+			t.Errorf("%+v Canonical(%q)=%q want %q", c.pn, c.path, got, c.want)
+		}
+	}
+}
+
+func TestMiddlewareNormalizePath(t *testing.T) {
+	t.Parallel()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) })
+	h := gc.MiddlewareNormalizePath(gc.PathNormalization{Slash: gc.SlashRemove, Lowercase: true})(ok)
+
+	cases := []struct {
+		method, url string
+		status      int
+		location    string
+	}{
+		{http.MethodGet, "/api/items", http.StatusOK, ""},
+		{http.MethodGet, "/API/Items/?q=A", http.StatusMovedPermanently, "/api/items?q=A"},
+		{http.MethodPost, "/api/items/", http.StatusPermanentRedirect, "/api/items"},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(c.method, c.url, http.NoBody))
+		if rec.Code != c.status || rec.Header().Get("Location") != c.location {
+			t.Errorf("%s %s: status=%d Location=%q", c.method, c.url, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestMiddlewareNormalizePath_OpenRedirect(t *testing.T) {
+	t.Parallel()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("ok")) })
+	cases := []struct {
+		slash    gc.SlashPolicy
+		url      string
+		location string
+	}{
+		{gc.SlashAdd, "//evil.com/x", "/evil.com/x/"},
+		{gc.SlashAdd, "///evil.com/x?a=1", "/evil.com/x/?a=1"},
+		{gc.SlashRemove, "//evil.com/", "/evil.com"},
+		{gc.SlashRemove, "/\\evil.com/", "/%5Cevil.com"},
+	}
+	for _, c := range cases {
+		h := gc.MiddlewareNormalizePath(gc.PathNormalization{Slash: c.slash})(ok)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.url, http.NoBody))
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != c.location {
+			t.Errorf("%s: status=%d Location=%q want %q", c.url, rec.Code, rec.Header().Get("Location"), c.location)
+		}
+	}
+}
+
+func TestStaticWebServer_WithPathNormalization(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, f := range []string{"index.html", "blog/index.html", "style.css"} {
+		file := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(f), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ws := gc.NewStaticWebServer(gg.NewWriter(""), dir).
+		WithPathNormalization(gc.PathNormalization{Slash: gc.SlashRemove, Lowercase: true})
+	h := ws.ServeSite()
+
+	cases := []struct {
+		url      string
+		status   int
+		location string
+		body     string
+	}{
+		{"/blog", http.StatusOK, "", "blog/index.html"},
+		{"/blog/", http.StatusMovedPermanently, "/blog", ""},
+		{"/Blog", http.StatusMovedPermanently, "/blog", ""},
+		{"/", http.StatusOK, "", "index.html"},
+		{"/STYLE.css", http.StatusMovedPermanently, "/style.css", ""},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, c.url, http.NoBody))
+		if rec.Code != c.status || rec.Header().Get("Location") != c.location ||
+			(c.body != "" && rec.Body.String() != c.body) {
+			t.Errorf("%s: status=%d Location=%q body=%q", c.url, rec.Code, rec.Header().Get("Location"), rec.Body.String())
+		}
+	}
+}
+
+func TestCheckLowercase(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	if err := gc.CheckLowercase(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"team.html", "Team.html", "img/Logo.png"} {
+		file := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := gc.CheckLowercase(dir)
+	if err == nil {
+		t.Fatal("CheckLowercase must report the uppercase files")
+	}
+	for _, want := range []string{"team.html collides with Team.html", "img/Logo.png is not lowercase"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in %v", want, err)
+		}
+	}
+}
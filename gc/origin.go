@@ -0,0 +1,264 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// originMeta is stored in the metadata directory (see metaPath)
+// to remember when and how each cached file has been fetched.
+type originMeta struct {
+	Fetched      time.Time `json:"fetched"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	ContentType  string    `json:"content_type,omitempty"`
+}
+
+type originPull struct {
+	client  *http.Client
+	origin  *url.URL
+	ws      *StaticWebServer
+	metaDir string
+	group   singleflight.Group
+	ttl     time.Duration
+	maxSize int64
+}
+
+const defaultMaxOriginSize = 1 << 30
+
+var (
+	errOriginStatus   = errors.New("unexpected origin status")
+	errOriginNotFound = errors.New("not found on origin")
+	errOriginTooLarge = errors.New("origin file too large")
+)
+
+// ServeOrigin turns the StaticWebServer into a caching edge of the origin URL (mini-CDN).
+// On a cache miss, the file is fetched from the origin and stored in ws.Dir.
+// The next requests are served from the disk during ttl.
+// Then the file is revalidated using the origin validators (ETag, Last-Modified).
+// When the origin is down, the stale file is still served.
+// Concurrent misses on the same path trigger a single origin request.
+// The origin 404 and 410 are answered 404 (and the cached file is removed),
+// the other origin failures are answered 502 Bad Gateway,
+// including a file larger than ws.MaxOriginSize.
+//
+// The metadata of the cached files (fetch time, validators, Content-Type)
+// is stored in the sibling directory ws.Dir+".origin" (same relative paths),
+// outside the cached files to never collide with an origin file.
+func (ws *StaticWebServer) ServeOrigin(originURL string, ttl time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	origin, err := url.Parse(originURL)
+	if err != nil || (origin.Scheme != "http" && origin.Scheme != "https") {
+		log.Panic("WebServer: ServeOrigin wants an http(s) URL but got", originURL, err)
+	}
+
+	op := &originPull{
+		client:  &http.Client{Transport: RTMiddlewareRequestID(gg.DefaultResolver.Transport()), Timeout: time.Minute},
+		origin:  origin,
+		ws:      ws,
+		metaDir: filepath.Clean(ws.Dir) + ".origin",
+		ttl:     ttl,
+		maxSize: ws.MaxOriginSize,
+	}
+	if op.maxSize <= 0 {
+		op.maxSize = defaultMaxOriginSize
+	}
+
+	log.Info("WebServer: pull from origin " + origin.String() + " cache=" + ws.Dir + " ttl=" + ttl.String())
+	return op.serve
+}
+
+func (op *originPull) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		op.ws.Writer.WriteErr(w, r, http.StatusMethodNotAllowed, "only GET and HEAD are supported")
+		return
+	}
+	if op.ws.Writer.TraversalPath(w, r) {
+		return
+	}
+
+	urlPath := r.URL.Path
+	if strings.HasSuffix(urlPath, "/") {
+		urlPath += "index.html"
+	}
+	relPath := filepath.FromSlash(path.Clean("/" + urlPath))
+	absPath := filepath.Join(op.ws.Dir, relPath)
+	metaPath := filepath.Join(op.metaDir, relPath)
+
+	meta, fresh := op.lookup(absPath, metaPath)
+	if !fresh {
+		v, err, _ := op.group.Do(absPath, func() (any, error) {
+			return op.pull(r, urlPath, absPath, metaPath, meta)
+		})
+		switch {
+		case err == nil:
+			meta, _ = v.(*originMeta)
+		case errors.Is(err, errOriginNotFound):
+			if meta != nil {
+				_ = os.Remove(absPath)
+				_ = os.Remove(metaPath)
+			}
+			op.ws.Writer.WriteErr(w, r, http.StatusNotFound, "file not found")
+			return
+		case meta != nil:
+			log.Warn("WebServer: origin failed, serve stale", absPath, err)
+		default:
+			log.Warn("WebServer: origin failed", err)
+			op.ws.Writer.WriteErr(w, r, http.StatusBadGateway, "origin server unavailable")
+			return
+		}
+	}
+
+	if meta.ContentType != "" {
+		w.Header().Set("Content-Type", meta.ContentType)
+	}
+	if meta.ETag != "" {
+		w.Header().Set("ETag", meta.ETag)
+	}
+	op.ws.send(w, r, absPath)
+}
+
+// lookup returns the metadata of the cached file (nil if not cached)
+// and whether the file is still fresh.
+func (op *originPull) lookup(absPath, metaPath string) (*originMeta, bool) {
+	buf, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, false
+	}
+	var meta originMeta
+	err = json.Unmarshal(buf, &meta)
+	if err != nil {
+		return nil, false
+	}
+	_, err = os.Stat(absPath)
+	if err != nil {
+		return nil, false
+	}
+	return &meta, time.Since(meta.Fetched) < op.ttl
+}
+
+// pull fetches the file from the origin, using the validators of the stale file (if any).
+func (op *originPull) pull(r *http.Request, urlPath, absPath, metaPath string, stale *originMeta) (*originMeta, error) {
+	u := *op.origin
+	u.Path = path.Join(op.origin.Path, urlPath)
+	u.RawQuery = ""
+
+	req, err := http.NewRequestWithContext(context.WithoutCancel(r.Context()), http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if stale != nil {
+		if stale.ETag != "" {
+			req.Header.Set("If-None-Match", stale.ETag)
+		}
+		if stale.LastModified != "" {
+			req.Header.Set("If-Modified-Since", stale.LastModified)
+		}
+	}
+
+	resp, err := op.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && stale != nil:
+		stale.Fetched = time.Now()
+		log.Out("304 origin", u.String())
+		return stale, writeMeta(metaPath, stale)
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, fmt.Errorf("%w %s from %s", errOriginNotFound, resp.Status, u.String())
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w %s from %s", errOriginStatus, resp.Status, u.String())
+	case resp.ContentLength > op.maxSize:
+		return nil, fmt.Errorf("%w: %d bytes from %s", errOriginTooLarge, resp.ContentLength, u.String())
+	}
+
+	meta := &originMeta{
+		Fetched:      time.Now(),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}
+
+	// one more byte to detect a body exceeding maxSize (without Content-Length)
+	body := &sizeLimitedReader{r: io.LimitReader(resp.Body, op.maxSize+1), max: op.maxSize}
+	n, err := writeAtomic(absPath, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w from %s", err, u.String())
+	}
+	log.Out("200 origin", u.String(), gg.ConvertSize64(n))
+
+	return meta, writeMeta(metaPath, meta)
+}
+
+// sizeLimitedReader fails with errOriginTooLarge when reading more than max bytes,
+// so writeAtomic removes the partial file.
+type sizeLimitedReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (l *sizeLimitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return n, errOriginTooLarge
+	}
+	return n, err
+}
+
+func writeMeta(metaPath string, meta *originMeta) error {
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	_, err = writeAtomic(metaPath, strings.NewReader(string(buf)))
+	return err
+}
+
+// writeAtomic writes into a temporary file and then renames it
+// so the concurrent readers never see a partial file.
+func writeAtomic(absPath string, src io.Reader) (int64, error) {
+	dir := filepath.Dir(absPath)
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name()) // no effect after a successful rename
+
+	n, err := io.Copy(tmp, src)
+	if err != nil {
+		tmp.Close()
+		return n, err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return n, err
+	}
+	return n, os.Rename(tmp.Name(), absPath)
+}
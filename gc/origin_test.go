@@ -0,0 +1,156 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestStaticWebServer_ServeOrigin(t *testing.T) {
+	t.Parallel()
+
+	var pulls, notModified atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pulls.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.URL.Path != "/assets/app.css" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "text/css")
+		_, _ = w.Write([]byte("body{}"))
+	}))
+
+	ws := gc.NewStaticWebServer(gg.NewWriter(""), t.TempDir())
+	ttl := 200 * time.Millisecond
+	edge := httptest.NewServer(http.HandlerFunc(ws.ServeOrigin(origin.URL, ttl)))
+	defer edge.Close()
+
+	get := func(path string) (int, string, string) {
+		t.Helper()
+		resp, err := http.Get(edge.URL + path) //nolint:noctx // test
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+	}
+
+	check := func(step string, wantPulls int32) {
+		t.Helper()
+		status, ct, body := get("/assets/app.css")
+		if status != http.StatusOK || ct != "text/css" || body != "body{}" {
+			t.Errorf("%s: got status=%d Content-Type=%q body=%q", step, status, ct, body)
+		}
+		if got := pulls.Load(); got != wantPulls {
+			t.Errorf("%s: origin pulls=%d want %d", step, got, wantPulls)
+		}
+	}
+
+	check("miss", 1)
+	check("hit", 1)
+
+	status, _, _ := get("/missing.css")
+	if status != http.StatusNotFound {
+		t.Errorf("missing file on origin: got status=%d want 404", status)
+	}
+
+	time.Sleep(ttl)
+	check("revalidate", 3)
+	if notModified.Load() != 1 {
+		t.Error("stale file should be revalidated with If-None-Match")
+	}
+
+	origin.Close()
+	time.Sleep(ttl)
+	check("stale-if-error", 3)
+
+	status, _, _ = get("/missing.css")
+	if status != http.StatusBadGateway {
+		t.Errorf("missing file with origin down: got status=%d want 502", status)
+	}
+}
+
+func TestStaticWebServer_ServeOrigin_limits(t *testing.T) {
+	t.Parallel()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.css", "/app.css.origin.json":
+			_, _ = w.Write([]byte(r.URL.Path))
+		case "/big.bin":
+			_, _ = w.Write([]byte(strings.Repeat("x", 24)))
+		case "/chunked.bin":
+			for range 24 {
+				_, _ = w.Write([]byte("x"))
+				w.(http.Flusher).Flush() // no Content-Length
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer origin.Close()
+
+	dir := t.TempDir()
+	ws := gc.NewStaticWebServer(gg.NewWriter(""), dir)
+	ws.MaxOriginSize = 20
+	edge := httptest.NewServer(http.HandlerFunc(ws.ServeOrigin(origin.URL, time.Minute)))
+	defer edge.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(edge.URL + path) //nolint:noctx // test
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	// a file named like the metadata must not collide with the metadata
+	for range 2 {
+		for _, p := range []string{"/app.css", "/app.css.origin.json"} {
+			status, body := get(p)
+			if status != http.StatusOK || body != p {
+				t.Errorf("GET %s: got status=%d body=%q", p, status, body)
+			}
+		}
+	}
+
+	for _, p := range []string{"/big.bin", "/chunked.bin"} {
+		status, _ := get(p)
+		if status != http.StatusBadGateway {
+			t.Errorf("GET %s larger than MaxOriginSize: got status=%d want 502", p, status)
+		}
+		_, err := os.Stat(filepath.Join(dir, p))
+		if !os.IsNotExist(err) {
+			t.Errorf("%s larger than MaxOriginSize must not be cached: %v", p, err)
+		}
+	}
+}
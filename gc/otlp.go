@@ -0,0 +1,384 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Exemplar labels linking an observation to a trace (W3C Trace Context).
+const (
+	exemplarTraceID = "trace_id"
+	exemplarSpanID  = "span_id"
+)
+
+// WithOTLPMetrics pushes periodically the metrics of the Prometheus default registry
+// to an OpenTelemetry collector using OTLP/HTTP (JSON encoding).
+// The histogram buckets keep their exemplars, linking the latencies to the trace IDs.
+// When endpoint is empty, the standard environment variables are used:
+// OTEL_EXPORTER_OTLP_METRICS_ENDPOINT, else OTEL_EXPORTER_OTLP_ENDPOINT + "/v1/metrics".
+// The default interval is one minute.
+//
+//	middleware, connState := g.StartExporter(9093,
+//		gc.WithOTLPMetrics("http://otel-collector:4318/v1/metrics", 30*time.Second))
+func WithOTLPMetrics(endpoint string, interval time.Duration) ProbeOption {
+	if endpoint == "" {
+		endpoint = gg.EnvStr("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
+	}
+	if endpoint == "" {
+		if base := gg.EnvStr("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/metrics"
+		}
+	}
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return func(h *exporterHandler) {
+		if endpoint == "" {
+			log.Warn("WithOTLPMetrics: no endpoint => disable OTLP metrics")
+			return
+		}
+		h.otlp = &otlpExporter{
+			client:   gg.DefaultResolver.Client(10 * time.Second),
+			gatherer: prometheus.DefaultGatherer,
+			endpoint: endpoint,
+			interval: interval,
+			start:    time.Now(),
+		}
+	}
+}
+
+type otlpExporter struct {
+	start    time.Time
+	client   *http.Client
+	gatherer prometheus.Gatherer
+	endpoint string
+	service  string
+	interval time.Duration
+}
+
+func (e *otlpExporter) run() {
+	log.Infof("OTLP metrics push to %s every %s", e.endpoint, e.interval)
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := e.push(context.Background())
+		if err != nil {
+			log.Warn("OTLP metrics:", err)
+		}
+	}
+}
+
+func (e *otlpExporter) push(ctx context.Context) error {
+	families, err := e.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(e.payload(families, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("POST %s: %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding, see https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding
+// The 64-bit integers are encoded as strings, the trace and span IDs in hexadecimal.
+type (
+	otlpPayload struct {
+		ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+	}
+	otlpResourceMetrics struct {
+		Resource     otlpResource       `json:"resource"`
+		ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+	}
+	otlpResource struct {
+		Attributes []otlpKV `json:"attributes"`
+	}
+	otlpScopeMetrics struct {
+		Scope   otlpScope    `json:"scope"`
+		Metrics []otlpMetric `json:"metrics"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpKV struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpMetric struct {
+		Sum         *otlpSum       `json:"sum,omitempty"`
+		Gauge       *otlpGauge     `json:"gauge,omitempty"`
+		Histogram   *otlpHistogram `json:"histogram,omitempty"`
+		Summary     *otlpSummary   `json:"summary,omitempty"`
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+	}
+	otlpSum struct {
+		DataPoints             []otlpNumberPoint `json:"dataPoints"`
+		AggregationTemporality int               `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	}
+	otlpGauge struct {
+		DataPoints []otlpNumberPoint `json:"dataPoints"`
+	}
+	otlpNumberPoint struct {
+		Attributes        []otlpKV       `json:"attributes,omitempty"`
+		Exemplars         []otlpExemplar `json:"exemplars,omitempty"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+		TimeUnixNano      string         `json:"timeUnixNano"`
+		AsDouble          float64        `json:"asDouble"`
+	}
+	otlpHistogram struct {
+		DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+		AggregationTemporality int                  `json:"aggregationTemporality"`
+	}
+	otlpHistogramPoint struct {
+		Attributes        []otlpKV       `json:"attributes,omitempty"`
+		BucketCounts      []string       `json:"bucketCounts"`
+		ExplicitBounds    []float64      `json:"explicitBounds"`
+		Exemplars         []otlpExemplar `json:"exemplars,omitempty"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		TimeUnixNano      string         `json:"timeUnixNano"`
+		Count             string         `json:"count"`
+		Sum               float64        `json:"sum"`
+	}
+	otlpSummary struct {
+		DataPoints []otlpSummaryPoint `json:"dataPoints"`
+	}
+	otlpSummaryPoint struct {
+		Attributes        []otlpKV       `json:"attributes,omitempty"`
+		QuantileValues    []otlpQuantile `json:"quantileValues"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		TimeUnixNano      string         `json:"timeUnixNano"`
+		Count             string         `json:"count"`
+		Sum               float64        `json:"sum"`
+	}
+	otlpQuantile struct {
+		Quantile float64 `json:"quantile"`
+		Value    float64 `json:"value"`
+	}
+	otlpExemplar struct {
+		FilteredAttributes []otlpKV `json:"filteredAttributes,omitempty"`
+		TimeUnixNano       string   `json:"timeUnixNano"`
+		TraceID            string   `json:"traceId,omitempty"`
+		SpanID             string   `json:"spanId,omitempty"`
+		AsDouble           float64  `json:"asDouble"`
+	}
+)
+
+// cumulative is the OTLP AggregationTemporality of the Prometheus metrics.
+const cumulative = 2
+
+func (e *otlpExporter) payload(families []*dto.MetricFamily, now time.Time) otlpPayload {
+	start := unixNano(e.start)
+	ts := unixNano(now)
+
+	metrics := make([]otlpMetric, 0, len(families))
+	for _, mf := range families {
+		m := otlpMetric{Name: mf.GetName(), Description: mf.GetHelp()}
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			m.Sum = &otlpSum{AggregationTemporality: cumulative, IsMonotonic: true}
+			for _, pm := range mf.GetMetric() {
+				p := numberPoint(pm, pm.GetCounter().GetValue(), start, ts)
+				if ex := otlpExemplars(pm.GetCounter().GetExemplar()); ex != nil {
+					p.Exemplars = ex
+				}
+				m.Sum.DataPoints = appendFinite(m.Sum.DataPoints, p)
+			}
+		case dto.MetricType_GAUGE, dto.MetricType_UNTYPED:
+			m.Gauge = &otlpGauge{}
+			for _, pm := range mf.GetMetric() {
+				v := pm.GetGauge().GetValue()
+				if pm.Untyped != nil {
+					v = pm.GetUntyped().GetValue()
+				}
+				m.Gauge.DataPoints = appendFinite(m.Gauge.DataPoints, numberPoint(pm, v, "", ts))
+			}
+		case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+			m.Histogram = &otlpHistogram{AggregationTemporality: cumulative}
+			for _, pm := range mf.GetMetric() {
+				m.Histogram.DataPoints = append(m.Histogram.DataPoints, histogramPoint(pm, start, ts))
+			}
+		case dto.MetricType_SUMMARY:
+			m.Summary = &otlpSummary{}
+			for _, pm := range mf.GetMetric() {
+				m.Summary.DataPoints = append(m.Summary.DataPoints, summaryPoint(pm, start, ts))
+			}
+		default:
+			continue
+		}
+		metrics = append(metrics, m)
+	}
+
+	return otlpPayload{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: []otlpKV{kv("service.name", e.service)}},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope:   otlpScope{Name: "github.com/lynxai-team/garcon"},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+func numberPoint(pm *dto.Metric, v float64, start, ts string) otlpNumberPoint {
+	return otlpNumberPoint{
+		Attributes:        attributes(pm.GetLabel()),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		AsDouble:          v,
+	}
+}
+
+// appendFinite drops the NaN and infinite values: they cannot be encoded in JSON.
+func appendFinite(points []otlpNumberPoint, p otlpNumberPoint) []otlpNumberPoint {
+	if math.IsNaN(p.AsDouble) || math.IsInf(p.AsDouble, 0) {
+		return points
+	}
+	return append(points, p)
+}
+
+// histogramPoint converts the cumulative Prometheus buckets
+// into the OTLP bucket counts (one more bucket for +Inf).
+func histogramPoint(pm *dto.Metric, start, ts string) otlpHistogramPoint {
+	h := pm.GetHistogram()
+	p := otlpHistogramPoint{
+		Attributes:        attributes(pm.GetLabel()),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		Count:             strconv.FormatUint(h.GetSampleCount(), 10),
+		Sum:               h.GetSampleSum(),
+		ExplicitBounds:    []float64{},
+	}
+
+	var prev uint64
+	for _, b := range h.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), +1) {
+			continue
+		}
+		p.ExplicitBounds = append(p.ExplicitBounds, b.GetUpperBound())
+		p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(b.GetCumulativeCount()-prev, 10))
+		prev = b.GetCumulativeCount()
+		p.Exemplars = append(p.Exemplars, otlpExemplars(b.GetExemplar())...)
+	}
+	p.BucketCounts = append(p.BucketCounts, strconv.FormatUint(h.GetSampleCount()-prev, 10))
+	return p
+}
+
+func summaryPoint(pm *dto.Metric, start, ts string) otlpSummaryPoint {
+	s := pm.GetSummary()
+	p := otlpSummaryPoint{
+		Attributes:        attributes(pm.GetLabel()),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      ts,
+		Count:             strconv.FormatUint(s.GetSampleCount(), 10),
+		Sum:               s.GetSampleSum(),
+		QuantileValues:    []otlpQuantile{},
+	}
+	for _, q := range s.GetQuantile() {
+		if !math.IsNaN(q.GetValue()) { // NaN when no observation
+			p.QuantileValues = append(p.QuantileValues, otlpQuantile{q.GetQuantile(), q.GetValue()})
+		}
+	}
+	return p
+}
+
+func otlpExemplars(ex *dto.Exemplar) []otlpExemplar {
+	if ex == nil {
+		return nil
+	}
+	e := otlpExemplar{AsDouble: ex.GetValue()}
+	if ex.GetTimestamp() != nil {
+		e.TimeUnixNano = unixNano(ex.GetTimestamp().AsTime())
+	}
+	for _, l := range ex.GetLabel() {
+		switch l.GetName() {
+		case exemplarTraceID:
+			e.TraceID = l.GetValue()
+		case exemplarSpanID:
+			e.SpanID = l.GetValue()
+		default:
+			e.FilteredAttributes = append(e.FilteredAttributes, kv(l.GetName(), l.GetValue()))
+		}
+	}
+	return []otlpExemplar{e}
+}
+
+func attributes(labels []*dto.LabelPair) []otlpKV {
+	if len(labels) == 0 {
+		return nil
+	}
+	attrs := make([]otlpKV, len(labels))
+	for i, l := range labels {
+		attrs[i] = kv(l.GetName(), l.GetValue())
+	}
+	return attrs
+}
+
+func kv(key, value string) otlpKV {
+	return otlpKV{Key: key, Value: otlpValue{StringValue: value}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// traceParent extracts the trace and span IDs from the W3C "traceparent" header:
+// version-traceid-spanid-flags, for example:
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func traceParent(r *http.Request) (traceID, spanID string) {
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", ""
+	}
+	if !isHexNonZero(parts[1]) || !isHexNonZero(parts[2]) {
+		return "", ""
+	}
+	return parts[1], parts[2]
+}
+
+func isHexNonZero(s string) bool {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	for _, c := range b {
+		if c != 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestOTLPExporter_push(t *testing.T) {
+	t.Parallel()
+
+	reg := prometheus.NewRegistry()
+	hist := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "latency_seconds",
+		Buckets: []float64{0.1, 1},
+	}, []string{"code"})
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{Name: "empty", Objectives: map[float64]float64{0.5: 0.05}})
+	reg.MustRegister(hist, summary)
+
+	r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	r.Header.Set("traceparent", testTraceparent)
+	observeWithTrace(hist.WithLabelValues("200"), r, 0.5)
+	observeWithTrace(hist.WithLabelValues("200"), httptest.NewRequest(http.MethodGet, "/", http.NoBody), 0.05)
+	observeWithTrace(hist.WithLabelValues("200"), r, 3)
+
+	var got otlpPayload
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := json.Unmarshal(body, &got)
+		if err != nil || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer collector.Close()
+
+	e := &otlpExporter{
+		client:   collector.Client(),
+		gatherer: reg,
+		endpoint: collector.URL + "/v1/metrics",
+		service:  "test",
+		start:    time.Now(),
+	}
+	err := e.push(context.Background())
+	if err != nil {
+		t.Fatal(err) // the summary without observation has NaN quantiles
+	}
+
+	metrics := got.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	i := slices.IndexFunc(metrics, func(m otlpMetric) bool { return m.Name == "latency_seconds" })
+	if i < 0 || metrics[i].Histogram == nil {
+		t.Fatalf("missing histogram in %+v", metrics)
+	}
+	p := metrics[i].Histogram.DataPoints[0]
+	if p.Count != "3" || !slices.Equal(p.BucketCounts, []string{"1", "1", "1"}) || !slices.Equal(p.ExplicitBounds, []float64{0.1, 1}) {
+		t.Errorf("count=%s buckets=%v bounds=%v", p.Count, p.BucketCounts, p.ExplicitBounds)
+	}
+	if len(p.Exemplars) != 1 || p.Exemplars[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || p.Exemplars[0].SpanID != "00f067aa0ba902b7" {
+		t.Errorf("exemplars=%+v", p.Exemplars)
+	}
+}
+
+func Test_traceParent(t *testing.T) {
+	t.Parallel()
+
+	for header, want := range map[string]string{
+		testTraceparent: "4bf92f3577b34da6a3ce929d0e0e4736",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01": "",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-01":                  "",
+		"":                                                        "",
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		r.Header.Set("traceparent", header)
+		if got, _ := traceParent(r); got != want {
+			t.Errorf("traceParent(%q)=%q want %q", header, got, want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// PostureReport is the TLS and security posture of a Listener,
+	// a lightweight "SSL Labs" check logged by Garcon.Run (see Garcon.Posture).
+	PostureReport struct {
+		Listener     string            `json:"listener"`
+		MinVersion   string            `json:"min_version"`
+		MaxVersion   string            `json:"max_version"`
+		HSTS         string            `json:"hsts,omitempty"`
+		CipherSuites []string          `json:"cipher_suites"`
+		Certificates []CertPosture     `json:"certificates,omitempty"`
+		Cookies      []CookiePosture   `json:"cookies,omitempty"`
+		Headers      map[string]string `json:"headers"`
+		Missing      []string          `json:"missing_headers,omitempty"`
+		Warnings     []string          `json:"warnings,omitempty"`
+	}
+
+	// CertPosture describes a certificate of the chain.
+	CertPosture struct {
+		NotAfter time.Time `json:"not_after"`
+		Subject  string    `json:"subject"`
+		Issuer   string    `json:"issuer"`
+		DNSNames []string  `json:"dns_names,omitempty"`
+		Days     int       `json:"days_left"`
+	}
+
+	// CookiePosture describes a cookie set by the response to "HEAD /".
+	CookiePosture struct {
+		Name     string `json:"name"`
+		SameSite string `json:"same_site"`
+		Secure   bool   `json:"secure"`
+		HTTPOnly bool   `json:"http_only"`
+	}
+)
+
+const (
+	// certExpiryWarning is the remaining validity below which the certificate expiry is reported.
+	certExpiryWarning = 30 * 24 * time.Hour
+	// hstsMinAge is the minimum HSTS max-age (6 months) required by SSL Labs for the grade A+.
+	hstsMinAge = 15768000
+)
+
+// securityHeaders are the response headers checked by the posture report.
+//
+//nolint:gochecknoglobals // constant list
+var securityHeaders = []string{
+	"Strict-Transport-Security",
+	"Content-Security-Policy",
+	"X-Content-Type-Options",
+	"X-Frame-Options",
+	"Referrer-Policy",
+	"Permissions-Policy",
+}
+
+// Posture returns the report of each TLS listener (see Listener.TLSConfig and Listener.CertFile).
+// The security headers and the cookies are those of the response to a "HEAD /"
+// sent through the middleware chain of the listener.
+func (g *Garcon) Posture() []PostureReport {
+	return g.posture(true)
+}
+
+// posture returns the reports, checking the response to "HEAD /" only when withResponse is true.
+func (g *Garcon) posture(withResponse bool) []PostureReport {
+	var reports []PostureReport
+	for _, l := range g.listeners {
+		if l.CertFile == "" && l.TLSConfig == nil {
+			continue
+		}
+		report := listenerPosture(l, withResponse)
+		if !g.devMode && len(g.urls) > 0 && g.urls[0].Scheme != "https" {
+			report.warn("the first URL " + g.urls[0].String() + " is not https: the cookies are sent without the Secure flag")
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// LogPosture logs the posture of the TLS listeners, one warning per weak setting.
+// LogPosture sends "HEAD /" through the handler of each TLS listener (see Posture):
+// call it when the handlers have no side effects (upstream calls, rate limiters, SSE...).
+func (g *Garcon) LogPosture() {
+	logPosture(g.posture(true))
+}
+
+// logTLSPosture logs the TLS settings and the certificates without calling the handlers,
+// this is the posture logged by Run.
+func (g *Garcon) logTLSPosture() {
+	logPosture(g.posture(false))
+}
+
+func logPosture(reports []PostureReport) {
+	for _, p := range reports {
+		headers := ""
+		if p.Headers != nil {
+			headers = fmt.Sprintf(", HSTS %q, %d/%d security headers", p.HSTS, len(p.Headers), len(securityHeaders))
+		}
+		log.Infof("Posture %s: %s-%s, %d cipher suites, %d certificates%s",
+			p.Listener, p.MinVersion, p.MaxVersion, len(p.CipherSuites), len(p.Certificates), headers)
+		for _, w := range p.Warnings {
+			log.Warnf("Posture %s: %s", p.Listener, w)
+		}
+	}
+}
+
+// PostureHandler responds the posture reports in JSON,
+// to be served by an internal Listener (the report reveals the weaknesses).
+func (g *Garcon) PostureHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(g.Posture())
+		if err != nil {
+			log.Warn("Posture encode", err)
+		}
+	}
+}
+
+// ListenerPosture checks the TLS settings, the certificates
+// and the security headers of the listener.
+func ListenerPosture(l Listener) PostureReport {
+	return listenerPosture(l, true)
+}
+
+func listenerPosture(l Listener, withResponse bool) PostureReport {
+	report := PostureReport{Listener: l.Name}
+	cfg := l.TLSConfig
+	if cfg == nil {
+		cfg = &tls.Config{} //nolint:gosec // MinVersion is checked below
+	}
+
+	report.checkVersions(cfg)
+	report.checkCipherSuites(cfg)
+	report.checkCertificates(l, cfg)
+	if withResponse {
+		report.checkResponse(l)
+	}
+	return report
+}
+
+func (p *PostureReport) warn(msg string) {
+	p.Warnings = append(p.Warnings, msg)
+}
+
+func (p *PostureReport) checkVersions(cfg *tls.Config) {
+	minVersion := cfg.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12 // default of the Go servers
+	}
+	maxVersion := cfg.MaxVersion
+	if maxVersion == 0 {
+		maxVersion = tls.VersionTLS13
+	}
+	p.MinVersion = tls.VersionName(minVersion)
+	p.MaxVersion = tls.VersionName(maxVersion)
+
+	if minVersion < tls.VersionTLS12 {
+		p.warn("MinVersion " + p.MinVersion + " is deprecated (RFC 8996): use TLS 1.2 or above")
+	}
+	if maxVersion < tls.VersionTLS13 {
+		p.warn("TLS 1.3 is disabled by MaxVersion " + p.MaxVersion)
+	}
+}
+
+// checkCipherSuites lists the TLS 1.2 suites (the TLS 1.3 ones are not configurable).
+func (p *PostureReport) checkCipherSuites(cfg *tls.Config) {
+	if cfg.CipherSuites == nil {
+		for _, cs := range tls.CipherSuites() {
+			p.CipherSuites = append(p.CipherSuites, cs.Name)
+		}
+		return
+	}
+
+	insecure := make(map[uint16]bool)
+	for _, cs := range tls.InsecureCipherSuites() {
+		insecure[cs.ID] = true
+	}
+	for _, id := range cfg.CipherSuites {
+		name := tls.CipherSuiteName(id)
+		p.CipherSuites = append(p.CipherSuites, name)
+		switch {
+		case insecure[id]:
+			p.warn("insecure cipher suite " + name)
+		case !strings.Contains(name, "ECDHE"):
+			p.warn("cipher suite " + name + " without forward secrecy")
+		case strings.Contains(name, "CBC"):
+			p.warn("cipher suite " + name + " using the CBC mode")
+		}
+	}
+}
+
+func (p *PostureReport) checkCertificates(l Listener, cfg *tls.Config) {
+	certs := slices.Clip(cfg.Certificates)
+	if l.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+		if err != nil {
+			p.warn("cannot load the certificate: " + err.Error())
+			return
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		if cfg.GetCertificate == nil {
+			p.warn("no certificate")
+		}
+		return // certificates provided on demand (e.g. ACME): cannot be checked at startup
+	}
+
+	now := time.Now()
+	for _, cert := range certs {
+		for i, der := range cert.Certificate {
+			x, err := x509.ParseCertificate(der)
+			if err != nil {
+				p.warn("cannot parse the certificate: " + err.Error())
+				continue
+			}
+			cp := CertPosture{
+				NotAfter: x.NotAfter,
+				Subject:  x.Subject.String(),
+				Issuer:   x.Issuer.String(),
+				DNSNames: x.DNSNames,
+				Days:     int(x.NotAfter.Sub(now).Hours() / 24),
+			}
+			p.Certificates = append(p.Certificates, cp)
+
+			left := x.NotAfter.Sub(now)
+			switch {
+			case left <= 0:
+				p.warn("certificate " + cp.Subject + " has expired on " + x.NotAfter.Format(time.DateOnly))
+			case left < certExpiryWarning:
+				p.warn(fmt.Sprintf("certificate %s expires in %d days", cp.Subject, cp.Days))
+			}
+			if i == 0 && len(cert.Certificate) == 1 && x.Subject.String() == x.Issuer.String() {
+				p.warn("self-signed certificate " + cp.Subject)
+			}
+		}
+	}
+}
+
+// checkResponse sends "HEAD /" through the middleware chain to check the headers and the cookies.
+func (p *PostureReport) checkResponse(l Listener) {
+	p.Headers = map[string]string{}
+	if l.Handler == nil {
+		return
+	}
+
+	r, err := http.NewRequest(http.MethodHead, "https://localhost/", http.NoBody)
+	if err != nil {
+		p.warn("cannot create the request HEAD /: " + err.Error())
+		return
+	}
+	r.RemoteAddr = "127.0.0.1:0"
+	r.TLS = &tls.ConnectionState{Version: tls.VersionTLS13, HandshakeComplete: true}
+	rec := &headerRecorder{header: http.Header{}}
+	func() {
+		defer func() {
+			if v := recover(); v != nil {
+				p.warn(fmt.Sprint("HEAD / panicked: ", v))
+			}
+		}()
+		l.Chain.Then(l.Handler).ServeHTTP(rec, r)
+	}()
+
+	for _, name := range securityHeaders {
+		if v := rec.header.Get(name); v != "" {
+			p.Headers[name] = v
+		} else {
+			p.Missing = append(p.Missing, name)
+		}
+	}
+	if len(p.Missing) > 0 {
+		p.warn("missing security headers: " + strings.Join(p.Missing, ", "))
+	}
+	p.checkHSTS(rec.header.Get("Strict-Transport-Security"))
+
+	resp := http.Response{Header: rec.header}
+	for _, c := range resp.Cookies() {
+		cp := CookiePosture{Name: c.Name, SameSite: sameSiteName(c.SameSite), Secure: c.Secure, HTTPOnly: c.HttpOnly}
+		p.Cookies = append(p.Cookies, cp)
+		if !c.Secure {
+			p.warn("cookie " + c.Name + " without the Secure flag")
+		}
+		if c.SameSite != http.SameSiteLaxMode && c.SameSite != http.SameSiteStrictMode {
+			p.warn("cookie " + c.Name + " with SameSite=" + cp.SameSite)
+		}
+	}
+}
+
+func (p *PostureReport) checkHSTS(hsts string) {
+	p.HSTS = hsts
+	if hsts == "" {
+		return // already reported as a missing header
+	}
+	for directive := range strings.SplitSeq(hsts, ";") {
+		value, ok := strings.CutPrefix(strings.TrimSpace(strings.ToLower(directive)), "max-age=")
+		if !ok {
+			continue
+		}
+		age, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err != nil || age < hstsMinAge {
+			p.warn("HSTS max-age below 6 months: " + hsts)
+		}
+		return
+	}
+	p.warn("HSTS without max-age: " + hsts)
+}
+
+func sameSiteName(s http.SameSite) string {
+	switch s {
+	case http.SameSiteLaxMode:
+		return "Lax"
+	case http.SameSiteStrictMode:
+		return "Strict"
+	case http.SameSiteNoneMode:
+		return "None"
+	case http.SameSiteDefaultMode:
+	}
+	return "default"
+}
+
+// headerRecorder is a ResponseWriter keeping the headers and discarding the body.
+type headerRecorder struct {
+	header http.Header
+}
+
+func (hr *headerRecorder) Header() http.Header { return hr.header }
+
+func (hr *headerRecorder) Write(b []byte) (int, error) { return len(b), nil }
+
+func (hr *headerRecorder) WriteHeader(int) {}
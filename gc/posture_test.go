@@ -0,0 +1,146 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func selfSignedCert(t *testing.T, validity time.Duration) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(validity),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestListenerPosture(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=3600")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "x", HttpOnly: true})
+	})
+	report := gc.ListenerPosture(gc.Listener{
+		Name:    "api",
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			MinVersion:   tls.VersionTLS10,
+			CipherSuites: []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			Certificates: []tls.Certificate{selfSignedCert(t, 10*24*time.Hour)},
+		},
+	})
+
+	if report.MinVersion != "TLS 1.0" || report.MaxVersion != "TLS 1.3" || len(report.CipherSuites) != 2 {
+		t.Errorf("report=%+v", report)
+	}
+	if len(report.Certificates) != 1 || report.Certificates[0].Days != 9 {
+		t.Errorf("certificates=%+v", report.Certificates)
+	}
+	if report.Headers["X-Content-Type-Options"] != "nosniff" || !slices.Contains(report.Missing, "Content-Security-Policy") {
+		t.Errorf("headers=%v missing=%v", report.Headers, report.Missing)
+	}
+	if len(report.Cookies) != 1 || report.Cookies[0].Secure || !report.Cookies[0].HTTPOnly {
+		t.Errorf("cookies=%+v", report.Cookies)
+	}
+
+	warnings := strings.Join(report.Warnings, "\n")
+	for _, want := range []string{
+		"MinVersion TLS 1.0 is deprecated",
+		"cipher suite TLS_RSA_WITH_AES_128_CBC_SHA", // insecure or without forward secrecy depending on the Go version
+		"expires in 9 days",
+		"self-signed certificate",
+		"missing security headers",
+		"HSTS max-age below 6 months",
+		"cookie session without the Secure flag",
+		"cookie session with SameSite=default",
+	} {
+		if !strings.Contains(warnings, want) {
+			t.Errorf("missing warning %q in:\n%s", want, warnings)
+		}
+	}
+}
+
+func TestPostureHandler(t *testing.T) {
+	t.Parallel()
+
+	g := gc.New(
+		gc.WithListener(gc.Listener{Name: "http", Addr: ":8080", Handler: http.NotFoundHandler()}),
+		gc.WithListener(gc.Listener{
+			Name:      "https",
+			Addr:      ":8443",
+			Handler:   http.NotFoundHandler(),
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t, 365*24*time.Hour)}},
+		}),
+	)
+
+	rec := httptest.NewRecorder()
+	g.PostureHandler()(rec, httptest.NewRequest(http.MethodGet, "/posture", http.NoBody))
+	var reports []gc.PostureReport
+	err := json.Unmarshal(rec.Body.Bytes(), &reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Listener != "https" || reports[0].MinVersion != "TLS 1.2" {
+		t.Errorf("reports=%+v", reports)
+	}
+}
+
+func TestGarcon_Run_posture(t *testing.T) {
+	t.Parallel()
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	var calls atomic.Int64
+	g := gc.New(gc.WithListener(gc.Listener{
+		Name:      "https",
+		Addr:      busy.Addr().String(), // Run fails once the posture is logged
+		Handler:   http.HandlerFunc(func(http.ResponseWriter, *http.Request) { calls.Add(1) }),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t, 365*24*time.Hour)}},
+	}))
+
+	err = g.Run(context.Background())
+	if err == nil {
+		t.Fatal("want the listen error")
+	}
+	if calls.Load() != 0 {
+		t.Errorf("Run must not send requests through the handlers, got %d calls", calls.Load())
+	}
+}
@@ -5,13 +5,22 @@
 package gc
 
 import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
 	"net/http"
 	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/pkg/profile"
+
+	"github.com/lynxai-team/garcon/gg"
 )
 
 // ProbeCPU is used like the following:
@@ -30,11 +39,174 @@ import (
 // or using one single command line:
 //
 //	go run github.com/google/pprof@latest -http=: cpu.pprof
+//
+// See also Profiler to periodically snapshot the heap, goroutine, mutex and block profiles.
 func ProbeCPU() interface{ Stop() } {
 	log.Info("Probing CPU. To visualize the profile: pprof -http=: cpu.pprof")
 	return profile.Start(profile.ProfilePath("."))
 }
 
+// PProf serves the /debug/pprof/* endpoints,
+// optionally protected by a token and disabled at runtime:
+//
+//	g := gc.New(gc.WithPProf(6063), gc.WithPProfToken(os.Getenv("PPROF_TOKEN")))
+//	g.PProf().Disable() // enable it only when investigating
+//
+// The token is sent either in the header "Authorization: Bearer <token>"
+// or in the query string (for the pprof tool that cannot set headers):
+//
+//	go tool pprof "http://localhost:6063/debug/pprof/heap?token=xxx"
+//
+// The capture endpoints download a profile as an attachment:
+//
+//	curl -OJ -H "Authorization: Bearer xxx" "http://localhost:6063/debug/pprof/capture/cpu?seconds=20"
+//	curl -OJ -H "Authorization: Bearer xxx" http://localhost:6063/debug/pprof/capture/heap
+//	pprof -http=: cpu-20060102-150405.pprof
+type PProf struct {
+	router  http.Handler
+	token   string
+	enabled atomic.Bool
+}
+
+const (
+	defaultCaptureSeconds = 30
+	maxCaptureSeconds     = 120
+)
+
+// NewPProf creates an enabled PProf handler, an empty token disables the authentication.
+func NewPProf(token string) *PProf {
+	p := &PProf{token: token}
+	p.enabled.Store(true)
+
+	r := chi.NewRouter()
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.HandleFunc("/debug/pprof/capture/{profile}", p.capture)
+	r.NotFound(pprof.Index) // also serves /debug/pprof/{heap,goroutine,block...}
+	p.router = r
+	return p
+}
+
+// PProf returns the PProf handler of the PProf server (see WithPProf),
+// also usable in another Listener (e.g. an admin one).
+func (g *Garcon) PProf() *PProf {
+	if g.pprof == nil {
+		g.pprof = NewPProf(g.pprofToken)
+	}
+	return g.pprof
+}
+
+// Enable serves the endpoints.
+func (p *PProf) Enable() {
+	p.enabled.Store(true)
+	log.Info("PProf endpoints enabled")
+}
+
+// Disable responds 404 to all requests.
+func (p *PProf) Disable() {
+	p.enabled.Store(false)
+	log.Info("PProf endpoints disabled")
+}
+
+// Enabled reports whether the endpoints are served.
+func (p *PProf) Enabled() bool {
+	return p.enabled.Load()
+}
+
+// ServeHTTP checks the endpoints are enabled and the token before serving the request.
+func (p *PProf) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.enabled.Load() {
+		gg.WriteErr(w, r, http.StatusNotFound, "PProf endpoints are disabled")
+		return
+	}
+
+	if p.token != "" {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(p.token)) != 1 {
+			gg.WriteErr(w, r, http.StatusUnauthorized, "PProf requires a valid token")
+			log.Warn("PProf rejects", r.RemoteAddr, r.Method, gg.Sanitize(r.URL.Path))
+			return
+		}
+	}
+
+	p.router.ServeHTTP(w, r)
+}
+
+// capture collects the profile during the requested seconds
+// (the default is 30 seconds for the CPU profile, and a snapshot for the other profiles),
+// and sends it as a downloadable file.
+func (p *PProf) capture(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "profile")
+	seconds, err := captureSeconds(r, name)
+	if err != nil {
+		gg.WriteErr(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	switch {
+	case name == "cpu":
+		err = rpprof.StartCPUProfile(&buf)
+		if err != nil {
+			gg.WriteErr(w, r, http.StatusConflict, "Cannot capture CPU profile", "err", err.Error())
+			return
+		}
+		log.Infof("PProf captures the CPU profile during %ds", seconds)
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-r.Context().Done():
+		}
+		rpprof.StopCPUProfile()
+		if r.Context().Err() != nil {
+			return
+		}
+	case rpprof.Lookup(name) == nil:
+		gg.WriteErr(w, r, http.StatusNotFound, "Unknown profile", "profile", gg.Sanitize(name))
+		return
+	case seconds > 0:
+		pprof.Handler(name).ServeHTTP(w, r) // delta profile
+		return
+	default:
+		if name == "heap" {
+			runtime.GC() // up-to-date statistics
+		}
+		err = rpprof.Lookup(name).WriteTo(&buf, 0)
+		if err != nil {
+			gg.WriteErr(w, r, http.StatusInternalServerError, "Cannot write profile", "err", err.Error())
+			return
+		}
+	}
+
+	file := name + "-" + time.Now().UTC().Format("20060102-150405") + ".pprof"
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+file+`"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		log.Warn("PProf send", file, err)
+	}
+}
+
+func captureSeconds(r *http.Request, name string) (int, error) {
+	str := r.URL.Query().Get("seconds")
+	if str == "" {
+		if name == "cpu" {
+			return defaultCaptureSeconds, nil
+		}
+		return 0, nil
+	}
+	seconds, err := strconv.Atoi(str)
+	if err != nil || seconds < 0 || seconds > maxCaptureSeconds || (seconds == 0 && name == "cpu") {
+		return 0, errors.New("seconds must be within [1.." + strconv.Itoa(maxCaptureSeconds) + "]")
+	}
+	return seconds, nil
+}
+
 // StartPProfServer starts a PProf server in background.
 // Endpoints usage example:
 //
@@ -49,26 +221,23 @@ func ProbeCPU() interface{ Stop() } {
 //
 //	wget http://localhost:31415/debug/pprof/trace
 //	pprof -http=: trace
+//
+// See WithPProfToken to require a token.
 func StartPProfServer(port int) {
+	startPProfServer(port, NewPProf(""))
+}
+
+func startPProfServer(port int, p *PProf) {
 	if port == 0 {
 		return // Disable PProf endpoints /debug/pprof/*
 	}
 
 	addr := "localhost:" + strconv.Itoa(port)
-	h := pProfHandler()
-
-	go runPProfServer(addr, h)
-}
+	if p.token == "" {
+		log.Warn("PProf endpoints are not protected by a token, see WithPProfToken")
+	}
 
-// pProfHandler serves the /debug/pprof/* endpoints.
-func pProfHandler() http.Handler {
-	r := chi.NewRouter()
-	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
-	r.NotFound(pprof.Index) // also serves /debug/pprof/{heap,goroutine,block...}
-	return r
+	go runPProfServer(addr, p)
 }
 
 func runPProfServer(addr string, handler http.Handler) {
@@ -80,7 +249,7 @@ func runPProfServer(addr string, handler http.Handler) {
 		TLSConfig:                    nil,
 		ReadTimeout:                  time.Second,
 		ReadHeaderTimeout:            time.Second,
-		WriteTimeout:                 time.Minute,
+		WriteTimeout:                 (maxCaptureSeconds + 10) * time.Second, // the capture lasts up to maxCaptureSeconds
 		IdleTimeout:                  time.Second,
 		MaxHeaderBytes:               444, // 444 bytes should be enough
 		TLSNextProto:                 nil,
@@ -0,0 +1,64 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestPProf(t *testing.T) {
+	t.Parallel()
+
+	p := gc.NewPProf("secret")
+	get := func(url, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, url, http.NoBody)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, r)
+		return rec
+	}
+
+	cases := []struct {
+		url, token string
+		status     int
+	}{
+		{"/debug/pprof/cmdline", "", http.StatusUnauthorized},
+		{"/debug/pprof/cmdline", "wrong", http.StatusUnauthorized},
+		{"/debug/pprof/cmdline", "secret", http.StatusOK},
+		{"/debug/pprof/cmdline?token=secret", "", http.StatusOK},
+		{"/debug/pprof/capture/nope", "secret", http.StatusNotFound},
+		{"/debug/pprof/capture/cpu?seconds=0", "secret", http.StatusBadRequest},
+		{"/debug/pprof/capture/heap?seconds=1000", "secret", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		if rec := get(c.url, c.token); rec.Code != c.status {
+			t.Errorf("%s token=%q: status=%d want %d", c.url, c.token, rec.Code, c.status)
+		}
+	}
+
+	for _, url := range []string{"/debug/pprof/capture/heap", "/debug/pprof/capture/cpu?seconds=1"} {
+		rec := get(url, "secret")
+		if rec.Code != http.StatusOK || rec.Body.Len() == 0 ||
+			!strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment; filename=") {
+			t.Errorf("%s: status=%d len=%d headers=%v", url, rec.Code, rec.Body.Len(), rec.Header())
+		}
+	}
+
+	p.Disable()
+	if rec := get("/debug/pprof/cmdline", "secret"); p.Enabled() || rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: status=%d", rec.Code)
+	}
+	p.Enable()
+	if rec := get("/debug/pprof/cmdline", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("enabled again: status=%d", rec.Code)
+	}
+}
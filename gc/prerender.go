@@ -0,0 +1,165 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Prerender requests each route from the handler (in process, without network)
+// and writes the responses into outDir, as a static site generation step:
+//
+//	"/"          -> outDir/index.html
+//	"/blog/"     -> outDir/blog/index.html
+//	"/about"     -> outDir/about/index.html
+//	"/feed.xml"  -> outDir/feed.xml
+//
+// The compressible documents (HTML, CSS, JS, JSON, XML, SVG, text)
+// also get a Brotli sibling (*.br) served by the StaticWebServer.
+// A route not responding "200 OK" is not written,
+// the returned error joins the errors of all the failed routes.
+func Prerender(ctx context.Context, handler http.Handler, routes []string, outDir string) error {
+	var errs []error
+	written := 0
+	for _, route := range routes {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		err := prerender(ctx, handler, route, outDir)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("prerender %s: %w", route, err))
+			continue
+		}
+		written++
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		log.Warnf("Prerender %d/%d routes in %s: %v", written, len(routes), outDir, err)
+	} else {
+		log.Infof("Prerender %d routes in %s", written, outDir)
+	}
+	return err
+}
+
+func prerender(ctx context.Context, handler http.Handler, route, outDir string) error {
+	file, err := prerenderFile(route)
+	if err != nil {
+		return err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, route, http.NoBody)
+	if err != nil {
+		return err
+	}
+	r.RemoteAddr = "127.0.0.1:0"
+	r.Header.Set("Accept", "text/html,*/*")
+	rec := &prerenderRecorder{header: http.Header{}, status: http.StatusOK}
+	handler.ServeHTTP(rec, r)
+	if rec.status != http.StatusOK {
+		return fmt.Errorf("status %d", rec.status)
+	}
+	if enc := rec.header.Get("Content-Encoding"); enc != "" {
+		return fmt.Errorf("unexpected Content-Encoding %q", enc)
+	}
+
+	abs := filepath.Join(outDir, filepath.FromSlash(file))
+	err = os.MkdirAll(filepath.Dir(abs), 0o755)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(abs, rec.body.Bytes(), 0o644) //nolint:gosec // public web files
+	if err != nil {
+		return err
+	}
+
+	if !compressible(rec.header.Get("Content-Type"), file) {
+		err = os.Remove(abs + ".br") // stale sibling
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var br bytes.Buffer
+	w := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	_, err = w.Write(rec.body.Bytes())
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(abs+".br", br.Bytes(), 0o644) //nolint:gosec // public web files
+}
+
+// prerenderFile converts the route into the relative file path.
+func prerenderFile(route string) (string, error) {
+	if !strings.HasPrefix(route, "/") || strings.ContainsAny(route, "?#\\") {
+		return "", errors.New("route must be an absolute path without query")
+	}
+	for segment := range strings.SplitSeq(route, "/") {
+		if segment == ".." {
+			return "", errors.New("route must not contain '..'")
+		}
+	}
+
+	file := path.Clean(route)
+	if strings.HasSuffix(route, "/") || path.Ext(file) == "" {
+		file = path.Join(file, "index.html")
+	}
+	return strings.TrimPrefix(file, "/"), nil
+}
+
+// compressible reports whether the document benefits from the Brotli compression.
+func compressible(contentType, file string) bool {
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(file))
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "json"),
+		strings.HasSuffix(mediaType, "xml"),
+		strings.HasSuffix(mediaType, "javascript"),
+		mediaType == "image/svg+xml":
+		return true
+	default:
+		return false
+	}
+}
+
+// prerenderRecorder keeps the response in memory.
+type prerenderRecorder struct {
+	header      http.Header
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool
+}
+
+func (rec *prerenderRecorder) Header() http.Header { return rec.header }
+
+func (rec *prerenderRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+}
+
+func (rec *prerenderRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.body.Write(b)
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestPrerender(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("<h1>home</h1>"))
+	})
+	mux.HandleFunc("GET /blog/{slug}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(strings.Repeat("<p>"+r.PathValue("slug")+"</p>", 100)))
+	})
+	mux.HandleFunc("GET /logo.png", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G'})
+	})
+
+	dir := t.TempDir()
+	err := gc.Prerender(context.Background(), mux, []string{"/", "/blog/hello", "/logo.png", "/missing", "/../etc/passwd"}, dir)
+	if err == nil || !strings.Contains(err.Error(), "/missing: status 404") || !strings.Contains(err.Error(), "..") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for file, want := range map[string]string{
+		"index.html":            "<h1>home</h1>",
+		"blog/hello/index.html": strings.Repeat("<p>hello</p>", 100),
+		"logo.png":              "\x89PNG",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q err=%v", file, got, err)
+		}
+	}
+
+	br, err := os.ReadFile(filepath.Join(dir, "blog/hello/index.html.br"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, err := io.ReadAll(brotli.NewReader(bytes.NewReader(br)))
+	if err != nil || string(html) != strings.Repeat("<p>hello</p>", 100) {
+		t.Errorf("brotli sibling: %q err=%v", html, err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "logo.png.br")); err == nil {
+		t.Error("want no Brotli sibling for a PNG")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "missing")); err == nil {
+		t.Error("want no file for a failed route")
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// ProfilerConfig is the configuration of the Profiler,
+	// see ProfilerFromEnv for the environment variables.
+	ProfilerConfig struct {
+		// Dir is the rotating directory of the profiles.
+		Dir string `env:"DIR,default=profiles"`
+		// PushURL (optional) is the object store receiving the profiles, see HTTPProfileStore.
+		PushURL string `env:"PUSH_URL"`
+		// Profiles are among heap, allocs, goroutine, mutex, block, threadcreate and cpu.
+		Profiles []string `env:"PROFILES,default=heap,goroutine"`
+		// Period between two snapshots, zero disables the Profiler.
+		Period time.Duration `env:"PERIOD,default=0"`
+		// CPUDuration is the duration of the CPU profile (bounded by Period).
+		CPUDuration time.Duration `env:"CPU_DURATION,default=10s"`
+		// MaxBytes and MaxFiles limit the directory size: the oldest profiles are removed.
+		MaxBytes int64 `env:"MAX_BYTES,default=104857600"`
+		MaxFiles int   `env:"MAX_FILES,default=500"`
+		// BlockRate is the block profile rate (nanoseconds) set when the "block" profile is enabled.
+		BlockRate int `env:"BLOCK_RATE,default=10000"`
+		// MutexFraction is the mutex profile fraction set when the "mutex" profile is enabled.
+		MutexFraction int `env:"MUTEX_FRACTION,default=100"`
+	}
+
+	// ProfileStore receives a copy of each profile, e.g. an object store bucket.
+	ProfileStore interface {
+		PutProfile(ctx context.Context, name string, data []byte) error
+	}
+
+	// HTTPProfileStore uploads the profiles with "PUT <URL>/<name>":
+	// a pre-signed bucket URL, a WebDAV folder, a MinIO bucket...
+	HTTPProfileStore struct {
+		Client *http.Client // default http.DefaultClient
+		Header http.Header  // e.g. Authorization
+		URL    string
+	}
+
+	// Profiler is a companion of ProbeCPU periodically writing snapshots of the
+	// heap, goroutine, mutex, block (and optionally CPU) profiles in a rotating directory,
+	// optionally pushed to a ProfileStore:
+	//
+	//	p, err := gc.ProfilerFromEnv() // PROFILER_PERIOD=5m PROFILER_PROFILES=heap,goroutine,cpu
+	//	if err != nil {
+	//		log.Fatal(err)
+	//	}
+	//	g.AddHook(p.Hook())
+	//
+	// To visualize a profile:
+	//
+	//	pprof -http=: profiles/20060102-150405-heap.pprof
+	Profiler struct {
+		store ProfileStore
+		cfg   ProfilerConfig
+	}
+)
+
+const profileExt = ".pprof"
+
+// NewProfiler creates a Profiler, store is optional.
+func NewProfiler(cfg ProfilerConfig, store ProfileStore) *Profiler {
+	if cfg.Dir == "" {
+		cfg.Dir = "profiles"
+	}
+	for _, name := range cfg.Profiles {
+		if name != "cpu" && rpprof.Lookup(name) == nil {
+			log.Panic("Profiler: unknown profile", name)
+		}
+	}
+	return &Profiler{store: store, cfg: cfg}
+}
+
+// ProfilerFromEnv creates a Profiler from the environment variables
+// PROFILER_DIR, PROFILER_PROFILES, PROFILER_PERIOD, PROFILER_CPU_DURATION,
+// PROFILER_MAX_BYTES, PROFILER_MAX_FILES, PROFILER_BLOCK_RATE, PROFILER_MUTEX_FRACTION
+// and PROFILER_PUSH_URL (see ProfilerConfig).
+func ProfilerFromEnv() (*Profiler, error) {
+	cfg, err := gg.LoadEnv[ProfilerConfig]("PROFILER_")
+	if err != nil {
+		return nil, err
+	}
+	var store ProfileStore
+	if cfg.PushURL != "" {
+		store = &HTTPProfileStore{URL: cfg.PushURL}
+	}
+	return NewProfiler(cfg, store), nil
+}
+
+// Hook returns the Hook running the Profiler from Garcon.Run until the shutdown.
+func (p *Profiler) Hook() Hook {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	return Hook{
+		Name: "profiler",
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				defer close(done)
+				p.Run(ctx)
+			}()
+			return nil
+		},
+		Stop: func(context.Context) error {
+			cancel()
+			<-done
+			return nil
+		},
+	}
+}
+
+// Run takes a snapshot every Period until ctx is done.
+func (p *Profiler) Run(ctx context.Context) {
+	if p.cfg.Period <= 0 {
+		log.Info("Profiler disabled, set PROFILER_PERIOD to enable it")
+		return
+	}
+
+	if slices.Contains(p.cfg.Profiles, "block") {
+		runtime.SetBlockProfileRate(p.cfg.BlockRate)
+		defer runtime.SetBlockProfileRate(0)
+	}
+	if slices.Contains(p.cfg.Profiles, "mutex") {
+		prev := runtime.SetMutexProfileFraction(p.cfg.MutexFraction)
+		defer runtime.SetMutexProfileFraction(prev)
+	}
+
+	log.Infof("Profiler writes %v every %v in %s", p.cfg.Profiles, p.cfg.Period, p.cfg.Dir)
+	ticker := time.NewTicker(p.cfg.Period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := p.Snapshot(ctx)
+		if err != nil {
+			log.Warn("Profiler:", err)
+		}
+	}
+}
+
+// Snapshot writes the profiles, pushes them to the store
+// and removes the oldest files exceeding the size limits.
+// Snapshot returns the written files.
+func (p *Profiler) Snapshot(ctx context.Context) ([]string, error) {
+	err := os.MkdirAll(p.cfg.Dir, 0o750)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := time.Now().UTC().Format("20060102-150405") + "-"
+	var files []string
+	var errs []error
+	for _, name := range p.cfg.Profiles {
+		data, err := p.collect(ctx, name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		file := filepath.Join(p.cfg.Dir, prefix+name+profileExt)
+		err = os.WriteFile(file, data, 0o600)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		files = append(files, file)
+
+		if p.store != nil {
+			err = p.store.PutProfile(ctx, filepath.Base(file), data)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("push %s: %w", filepath.Base(file), err))
+			}
+		}
+	}
+
+	errs = append(errs, p.prune())
+	return files, errors.Join(errs...)
+}
+
+func (p *Profiler) collect(ctx context.Context, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	if name != "cpu" {
+		if name == "heap" {
+			runtime.GC() // up-to-date statistics
+		}
+		err := rpprof.Lookup(name).WriteTo(&buf, 0)
+		return buf.Bytes(), err
+	}
+
+	err := rpprof.StartCPUProfile(&buf)
+	if err != nil {
+		return nil, err // another CPU profile is running (ProbeCPU, PProf...)
+	}
+	d := p.cfg.CPUDuration
+	if d <= 0 || d > p.cfg.Period {
+		d = p.cfg.Period
+	}
+	timer := time.NewTimer(d)
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+	timer.Stop()
+	rpprof.StopCPUProfile()
+	return buf.Bytes(), nil
+}
+
+// prune removes the oldest profiles (the names start with the timestamp)
+// while the directory exceeds MaxFiles or MaxBytes.
+func (p *Profiler) prune() error {
+	entries, err := os.ReadDir(p.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	type profile struct {
+		name string
+		size int64
+	}
+	var profiles []profile
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), profileExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // removed meanwhile
+		}
+		profiles = append(profiles, profile{e.Name(), info.Size()})
+		total += info.Size()
+	}
+	slices.SortFunc(profiles, func(a, b profile) int { return strings.Compare(a.name, b.name) })
+
+	var errs []error
+	for len(profiles) > 0 &&
+		((p.cfg.MaxFiles > 0 && len(profiles) > p.cfg.MaxFiles) || (p.cfg.MaxBytes > 0 && total > p.cfg.MaxBytes)) {
+		err = os.Remove(filepath.Join(p.cfg.Dir, profiles[0].name))
+		if err != nil {
+			errs = append(errs, err)
+		}
+		total -= profiles[0].size
+		profiles = profiles[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// PutProfile uploads the profile with "PUT <URL>/<name>".
+func (s *HTTPProfileStore) PutProfile(ctx context.Context, name string, data []byte) error {
+	url := strings.TrimSuffix(s.URL, "/") + "/" + name
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("PUT " + url + ": " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestProfiler_Snapshot(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var pushed []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Method != http.MethodPut || len(body) == 0 || r.Header.Get("Authorization") != "Bearer x" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		pushed = append(pushed, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	for _, old := range []string{"20000101-000000-heap.pprof", "20000101-000001-heap.pprof", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, old), []byte("old"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := &gc.HTTPProfileStore{URL: srv.URL + "/bucket/", Header: http.Header{"Authorization": {"Bearer x"}}}
+	p := gc.NewProfiler(gc.ProfilerConfig{
+		Dir:      dir,
+		Profiles: []string{"heap", "goroutine"},
+		Period:   time.Minute,
+		MaxFiles: 3,
+	}, store)
+
+	files, err := p.Snapshot(context.Background())
+	if err != nil || len(files) != 2 {
+		t.Fatalf("files=%v err=%v", files, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 4 || slices.Contains(names, "20000101-000000-heap.pprof") || !slices.Contains(names, "notes.txt") {
+		t.Errorf("the oldest profile must be removed: %v", names)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(pushed) != 2 || !strings.HasPrefix(pushed[0], "/bucket/") || !strings.HasSuffix(pushed[0], "-heap.pprof") {
+		t.Errorf("pushed=%v", pushed)
+	}
+}
+
+func TestProfilerFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROFILER_DIR", dir)
+	t.Setenv("PROFILER_PROFILES", "allocs,cpu")
+	t.Setenv("PROFILER_PERIOD", "1h")
+	t.Setenv("PROFILER_CPU_DURATION", "50ms")
+
+	p, err := gc.ProfilerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := p.Snapshot(context.Background())
+	if err != nil || len(files) != 2 || !strings.HasSuffix(files[1], "-cpu.pprof") {
+		t.Errorf("files=%v err=%v", files, err)
+	}
+}
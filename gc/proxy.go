@@ -0,0 +1,196 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// ReverseProxy forwards the requests under Prefix to a backend (upstream),
+// so Garcon can front a backend API and serve the static files in the same process:
+//
+//	api := g.NewReverseProxy("/api", "http://localhost:8081")
+//	mux.Handle("/api/", chain.Then(api)) // "/api/users" -> "http://localhost:8081/users"
+//	mux.Handle("/", ws.ServeDir("text/html; charset=utf-8"))
+//	g.AddHook(api.HealthHook("/health", 5*time.Second))
+//
+// The Prefix is stripped from the path and sent in the X-Forwarded-Prefix header.
+// The X-Forwarded-For/Host/Proto headers sent by the client are replaced.
+// The WebSocket upgrades are passed through: the middlewares wrapping
+// the http.ResponseWriter must implement http.Hijacker or Unwrap,
+// and the server WriteTimeout also bounds the WebSocket connections.
+type ReverseProxy struct {
+	Writer   gg.Writer
+	proxy    *httputil.ReverseProxy
+	upstream *url.URL
+	Prefix   string
+	// PreserveHost sends the Host requested by the client instead of the upstream host.
+	PreserveHost bool
+	unhealthy    atomic.Bool
+}
+
+// NewReverseProxy creates a ReverseProxy, see NewReverseProxy.
+func (g *Garcon) NewReverseProxy(prefix, upstream string) *ReverseProxy {
+	return NewReverseProxy(g.Writer, prefix, upstream)
+}
+
+// NewReverseProxy creates a ReverseProxy forwarding the requests under prefix to the upstream URL.
+// The path of the upstream URL (if any) is prepended to the forwarded path.
+func NewReverseProxy(gw gg.Writer, prefix, upstream string) *ReverseProxy {
+	u, err := url.Parse(upstream)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		log.Panic("ReverseProxy wants an http(s) upstream URL but got", upstream, err)
+	}
+
+	rp := &ReverseProxy{
+		Writer:   gw,
+		Prefix:   strings.TrimSuffix(prefix, "/"),
+		upstream: u,
+	}
+	rp.proxy = &httputil.ReverseProxy{
+		Rewrite:      rp.rewrite,
+		ErrorHandler: rp.errorHandler,
+	}
+
+	log.Info("ReverseProxy " + rp.Prefix + "/ -> " + u.Redacted())
+	return rp
+}
+
+func (rp *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rp.Prefix != "" && r.URL.Path != rp.Prefix && !strings.HasPrefix(r.URL.Path, rp.Prefix+"/") {
+		rp.Writer.WriteErr(w, r, http.StatusNotFound, "path outside the proxy prefix", "prefix", rp.Prefix)
+		return
+	}
+	if rp.unhealthy.Load() {
+		w.Header().Set("Retry-After", "5")
+		rp.Writer.WriteErr(w, r, http.StatusServiceUnavailable, "upstream server is unhealthy")
+		return
+	}
+	rp.proxy.ServeHTTP(w, r)
+}
+
+func (rp *ReverseProxy) rewrite(pr *httputil.ProxyRequest) {
+	pr.Out.URL.Path = stripPrefix(pr.In.URL.Path, rp.Prefix)
+	pr.Out.URL.RawPath = ""
+	if pr.In.URL.RawPath != "" {
+		pr.Out.URL.RawPath = stripPrefix(pr.In.URL.RawPath, rp.Prefix)
+	}
+	pr.SetURL(rp.upstream)
+	pr.SetXForwarded()
+	if rp.Prefix != "" {
+		pr.Out.Header.Set("X-Forwarded-Prefix", rp.Prefix)
+	}
+	if rp.PreserveHost {
+		pr.Out.Host = pr.In.Host
+	}
+	if id := gerr.RequestID(pr.In.Context()); id != "" {
+		pr.Out.Header.Set(gerr.RequestIDHeader, id) // replaces an invalid ID sent by the client
+	}
+}
+
+func stripPrefix(p, prefix string) string {
+	p = strings.TrimPrefix(p, prefix)
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+func (rp *ReverseProxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		return // the client has gone
+	}
+	log.Warn("ReverseProxy", rp.upstream.Host, err)
+	rp.Writer.WriteErr(w, r, http.StatusBadGateway, "upstream server unavailable")
+}
+
+// Healthy reports whether the last health check has succeeded (true without health check).
+func (rp *ReverseProxy) Healthy() bool {
+	return !rp.unhealthy.Load()
+}
+
+// Probe is a ProbeFunction for WithReadinessProbes.
+func (rp *ReverseProxy) Probe() []byte {
+	if rp.Healthy() {
+		return nil
+	}
+	return []byte(`{"upstream":"` + rp.upstream.Host + `","status":"unhealthy"}`)
+}
+
+// CheckHealth sends a GET request to the upstream path every interval until ctx is done.
+// While the upstream does not respond with a 2xx status,
+// the ReverseProxy responds "503 Service Unavailable" without forwarding the requests.
+func (rp *ReverseProxy) CheckHealth(ctx context.Context, path string, interval time.Duration) {
+	u := *rp.upstream
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	client := gg.DefaultResolver.Client(interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		rp.check(ctx, client, u.String())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (rp *ReverseProxy) check(ctx context.Context, client *http.Client, healthURL string) {
+	healthy := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, http.NoBody)
+	if err == nil {
+		var resp *http.Response
+		resp, err = client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			healthy = resp.StatusCode >= 200 && resp.StatusCode < 300
+		}
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	wasUnhealthy := rp.unhealthy.Swap(!healthy)
+	switch {
+	case !healthy && !wasUnhealthy:
+		log.Warning("ReverseProxy upstream unhealthy", healthURL, err)
+	case healthy && wasUnhealthy:
+		log.Info("ReverseProxy upstream healthy again", healthURL)
+	}
+}
+
+// HealthHook returns the Hook running CheckHealth from Garcon.Run until the shutdown.
+func (rp *ReverseProxy) HealthHook(path string, interval time.Duration) Hook {
+	var cancel context.CancelFunc
+	done := make(chan struct{})
+	return Hook{
+		Name: "proxy " + rp.upstream.Host,
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go func() {
+				defer close(done)
+				rp.CheckHealth(ctx, path, interval)
+			}()
+			return nil
+		},
+		Stop: func(context.Context) error {
+			cancel()
+			<-done
+			return nil
+		},
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestReverseProxy(t *testing.T) {
+	t.Parallel()
+
+	var healthy atomic.Bool
+	healthy.Store(true)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/health":
+			if !healthy.Load() {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		case r.Header.Get("Upgrade") == "websocket":
+			conn, rw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+			rw.Flush()
+			line, _ := rw.ReadString('\n')
+			rw.WriteString("echo " + line)
+			rw.Flush()
+		default:
+			w.Write([]byte(r.URL.Path + " " + r.Header.Get("X-Forwarded-Prefix") + " " + r.Header.Get("X-Forwarded-For")))
+		}
+	}))
+	defer upstream.Close()
+
+	rp := gc.NewReverseProxy(gg.NewWriter(""), "/api/", upstream.URL+"/v1")
+	front := httptest.NewServer(rp)
+	defer front.Close()
+
+	get := func(path string, header ...string) (int, string) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, front.URL+path, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := get("/api/users/42", "X-Forwarded-For", "6.6.6.6")
+	if status != http.StatusOK || body != "/v1/users/42 /api 127.0.0.1" {
+		t.Errorf("status=%d body=%q", status, body)
+	}
+	if status, _ = get("/apiv2/users"); status != http.StatusNotFound {
+		t.Errorf("want 404 outside the prefix, got %d", status)
+	}
+
+	// WebSocket passthrough
+	conn, err := net.Dial("tcp", strings.TrimPrefix(front.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	io.WriteString(conn, "GET /api/ws HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("want 101, got %v %v", resp, err)
+	}
+	io.WriteString(conn, "ping\n")
+	line, err := reader.ReadString('\n')
+	if err != nil || line != "echo ping\n" {
+		t.Fatalf("line=%q err=%v", line, err)
+	}
+
+	// health check
+	healthy.Store(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rp.CheckHealth(ctx, "/health", 10*time.Millisecond)
+	waitFor(t, func() bool { return !rp.Healthy() })
+	if status, _ = get("/api/users"); status != http.StatusServiceUnavailable || rp.Probe() == nil {
+		t.Errorf("want 503 when unhealthy, got %d", status)
+	}
+	healthy.Store(true)
+	waitFor(t, rp.Healthy)
+	if status, _ = get("/api/users"); status != http.StatusOK {
+		t.Errorf("want 200 when healthy again, got %d", status)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for range 200 {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not reached")
+}
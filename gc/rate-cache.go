@@ -0,0 +1,200 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// ResponseCache keeps the GET responses of an AdaptiveRate in memory, keyed by URL,
+	// so the polling loops do not refetch the unchanged payloads:
+	//
+	//	ar := gc.NewAdaptiveRate("Deribit", time.Millisecond)
+	//	ar.Cache = gc.NewResponseCache(30 * time.Second)
+	//
+	// A fresh response (younger than TTL) is decoded without sending any request
+	// and without sleeping. When the TTL expires, a response having an ETag
+	// or a Last-Modified header is revalidated with If-None-Match or If-Modified-Since:
+	// a "304 Not Modified" refreshes the cached response and costs no body transfer.
+	// The responses with "Cache-Control: no-store" are not cached.
+	//
+	// The key is the URL only: do not share a ResponseCache between
+	// requests whose responses depend on other headers (Authorization...).
+	ResponseCache struct {
+		entries     map[string]cachedResponse
+		TTL         time.Duration
+		MaxEntries  int // when reached, the expired entries are purged, then all the entries
+		mu          sync.RWMutex
+		hits        atomic.Uint64
+		revalidated atomic.Uint64
+		misses      atomic.Uint64
+	}
+
+	// ResponseCacheStats is a snapshot of the ResponseCache metrics.
+	ResponseCacheStats struct {
+		Hits        uint64 `json:"hits"`        // fresh responses, no request sent
+		Revalidated uint64 `json:"revalidated"` // 304 Not Modified responses
+		Misses      uint64 `json:"misses"`      // responses fetched with a body
+		Entries     int    `json:"entries"`
+	}
+
+	cachedResponse struct {
+		expires      time.Time
+		etag         string
+		lastModified string
+		body         []byte
+	}
+)
+
+const defaultResponseCacheEntries = 1000
+
+// NewResponseCache creates a ResponseCache. A zero ttl defaults to one second.
+func NewResponseCache(ttl time.Duration) *ResponseCache {
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	return &ResponseCache{
+		entries:    map[string]cachedResponse{},
+		TTL:        ttl,
+		MaxEntries: defaultResponseCacheEntries,
+	}
+}
+
+// cacheable reports whether the response of req can be cached:
+// GET requests without conditional headers set by the caller.
+func (rc *ResponseCache) cacheable(req *http.Request) bool {
+	return rc != nil && req.Method == http.MethodGet &&
+		req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == ""
+}
+
+func (rc *ResponseCache) get(key string) (cachedResponse, bool) {
+	rc.mu.RLock()
+	entry, ok := rc.entries[key]
+	rc.mu.RUnlock()
+	return entry, ok
+}
+
+// decodeFresh decodes the cached response into msg when still fresh.
+// It returns false when the request must be sent.
+func (rc *ResponseCache) decodeFresh(req *http.Request, msg any) (bool, error) {
+	if !rc.cacheable(req) {
+		return false, nil
+	}
+	entry, ok := rc.get(req.URL.String())
+	if !ok || time.Now().After(entry.expires) {
+		return false, nil
+	}
+	rc.hits.Add(1)
+	return true, json.Unmarshal(entry.body, msg)
+}
+
+// conditional returns the request with the validators of the stale cached response,
+// or req itself when there is nothing to revalidate.
+func (rc *ResponseCache) conditional(req *http.Request) *http.Request {
+	if !rc.cacheable(req) {
+		return req
+	}
+	entry, ok := rc.get(req.URL.String())
+	if !ok || (entry.etag == "" && entry.lastModified == "") {
+		return req
+	}
+	r := req.Clone(req.Context())
+	if entry.etag != "" {
+		r.Header.Set("If-None-Match", entry.etag)
+	}
+	if entry.lastModified != "" {
+		r.Header.Set("If-Modified-Since", entry.lastModified)
+	}
+	return r
+}
+
+// notModified extends the freshness of the cached response and returns its body.
+func (rc *ResponseCache) notModified(key string) ([]byte, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	entry, ok := rc.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry.expires = time.Now().Add(rc.TTL)
+	rc.entries[key] = entry
+	rc.revalidated.Add(1)
+	return entry.body, true
+}
+
+// store keeps the body of a successful response.
+func (rc *ResponseCache) store(key string, header http.Header, body []byte) {
+	rc.misses.Add(1)
+	if strings.Contains(header.Get("Cache-Control"), "no-store") {
+		return
+	}
+	now := time.Now()
+	entry := cachedResponse{
+		expires:      now.Add(rc.TTL),
+		etag:         header.Get("ETag"),
+		lastModified: header.Get("Last-Modified"),
+		body:         body,
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if len(rc.entries) >= rc.MaxEntries {
+		rc.purge(now)
+	}
+	rc.entries[key] = entry
+}
+
+// purge removes the expired entries that cannot be revalidated,
+// or all the entries when the cache is still full.
+// The caller must lock rc.mu.
+func (rc *ResponseCache) purge(now time.Time) {
+	for k, e := range rc.entries {
+		if now.After(e.expires) && e.etag == "" && e.lastModified == "" {
+			delete(rc.entries, k)
+		}
+	}
+	if len(rc.entries) >= rc.MaxEntries {
+		clear(rc.entries)
+	}
+}
+
+// Invalidate removes the cached response of the URL.
+func (rc *ResponseCache) Invalidate(url string) {
+	rc.mu.Lock()
+	delete(rc.entries, url)
+	rc.mu.Unlock()
+}
+
+// Flush removes all the cached responses.
+func (rc *ResponseCache) Flush() {
+	rc.mu.Lock()
+	clear(rc.entries)
+	rc.mu.Unlock()
+}
+
+// Stats returns the counters since the creation of the ResponseCache.
+func (rc *ResponseCache) Stats() ResponseCacheStats {
+	rc.mu.RLock()
+	n := len(rc.entries)
+	rc.mu.RUnlock()
+	return ResponseCacheStats{
+		Hits:        rc.hits.Load(),
+		Revalidated: rc.revalidated.Load(),
+		Misses:      rc.misses.Load(),
+		Entries:     n,
+	}
+}
+
+// LogStats prints the counters in the logs.
+func (rc *ResponseCache) LogStats() {
+	s := rc.Stats()
+	log.Infof("ResponseCache hits=%d revalidated=%d misses=%d entries=%d", s.Hits, s.Revalidated, s.Misses, s.Entries)
+}
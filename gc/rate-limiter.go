@@ -5,11 +5,18 @@
 package gc
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -21,8 +28,21 @@ type (
 	ReqLimiter struct {
 		visitors    map[string]*visitor
 		initLimiter *rate.Limiter
+		waits       *gg.ExpHistogram // delays imposed by the limiter
 		writer      gg.Writer
+		rejected    atomic.Uint64
 		mu          sync.Mutex
+		devMode     bool
+	}
+
+	// RateLimiterStats are the counters and the delay quantiles of a ReqLimiter.
+	RateLimiterStats struct {
+		Visitors int           `json:"visitors"`
+		Accepted uint64        `json:"accepted"`
+		Rejected uint64        `json:"rejected"` // 429 responses
+		WaitP50  time.Duration `json:"wait_p50"`
+		WaitP99  time.Duration `json:"wait_p99"`
+		WaitMax  time.Duration `json:"wait_max"`
 	}
 
 	visitor struct {
@@ -32,13 +52,15 @@ type (
 )
 
 const (
-	factorInitialNextSleep  = 2
-	factorIncreaseMinSleep  = 32  // higher, the change is slower
-	factorDecreaseMinSleep  = 512 // higher, the change is slower
-	factorIncreaseNextSleep = 2   // higher, the change is faster
-	factorDecreaseNextSleep = 8   // higher, the change is slower
-	maxAlpha                = 16
-	printDebug              = false
+	factorInitialNextSleep = 2
+	defaultAdaptiveAlpha   = 0.2  // weight of the new sleep duration in NextSleep
+	factorMinSleepAlpha    = 8    // MinSleep changes 8 times slower than NextSleep
+	probeMinSleep          = 0.95 // stabilized => probe a 5% shorter MinSleep
+	maxBoost               = 16
+	maxTries               = 88
+	defaultJitter          = 0.25 // up to +25% random sleep to desynchronize the clients
+	defaultMaxBackoff      = time.Minute
+	printDebug             = false
 )
 
 func (g *Garcon) MiddlewareRateLimiter(settings ...int) gg.Middleware {
@@ -74,8 +96,21 @@ func NewRateLimiter(writer gg.Writer, maxReqBurst, maxReqPerMinute int, devMode
 		writer:      writer,
 		visitors:    make(map[string]*visitor),
 		initLimiter: rate.NewLimiter(rate.Limit(ratePerSecond), maxReqBurst),
+		waits:       gg.NewExpHistogram(0),
 		mu:          sync.Mutex{},
+		devMode:     devMode,
+	}
+}
+
+// SetLimits changes the burst and the rate at runtime (doubled in dev mode as NewRateLimiter does).
+func (rl *ReqLimiter) SetLimits(maxReqBurst, maxReqPerMinute int) {
+	if rl.devMode {
+		maxReqBurst *= 2
+		maxReqPerMinute *= 2
 	}
+	rl.initLimiter.SetBurst(maxReqBurst)
+	rl.initLimiter.SetLimit(rate.Limit(float64(maxReqPerMinute) / 60))
+	log.Infof("RateLimiter burst=%v rate=%.2f/s", maxReqBurst, float64(maxReqPerMinute)/60)
 }
 
 func (rl *ReqLimiter) MiddlewareRateLimiter(next http.Handler) http.Handler {
@@ -95,9 +130,11 @@ func (rl *ReqLimiter) MiddlewareRateLimiter(next http.Handler) http.Handler {
 
 		limiter := rl.getVisitor(ip)
 
+		start := time.Now()
 		err = limiter.Wait(r.Context())
 		if err != nil {
 			if r.Context().Err() == nil {
+				rl.rejected.Add(1)
 				rl.writer.WriteErr(w, r, http.StatusTooManyRequests, "Too Many Requests",
 					"advice", "Please contact the team support is this is annoying")
 				log.Out("429", r.RemoteAddr, r.Method, r.RequestURI, "ERROR:", err)
@@ -106,11 +143,37 @@ func (rl *ReqLimiter) MiddlewareRateLimiter(next http.Handler) http.Handler {
 			}
 			return
 		}
+		rl.waits.RecordDuration(time.Since(start))
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// Stats returns the number of accepted and rejected requests
+// and the quantiles of the delays imposed to the accepted requests.
+func (rl *ReqLimiter) Stats() RateLimiterStats {
+	rl.mu.Lock()
+	visitors := len(rl.visitors)
+	rl.mu.Unlock()
+
+	s := rl.waits.Snapshot()
+	return RateLimiterStats{
+		Visitors: visitors,
+		Accepted: s.Count,
+		Rejected: rl.rejected.Load(),
+		WaitP50:  time.Duration(s.Quantile(0.5) * float64(time.Second)),
+		WaitP99:  time.Duration(s.Quantile(0.99) * float64(time.Second)),
+		WaitMax:  time.Duration(s.Max * float64(time.Second)),
+	}
+}
+
+// LogStats prints the counters and the delay quantiles in the logs.
+func (rl *ReqLimiter) LogStats() {
+	s := rl.Stats()
+	log.Infof("RateLimiter visitors=%d accepted=%d rejected=%d wait p50=%s p99=%s max=%s",
+		s.Visitors, s.Accepted, s.Rejected, s.WaitP50, s.WaitP99, s.WaitMax)
+}
+
 func (rl *ReqLimiter) removeOldVisitors() {
 	for ; true; <-time.NewTicker(1 * time.Minute).C {
 		rl.mu.Lock()
@@ -143,21 +206,78 @@ func (rl *ReqLimiter) getVisitor(ip string) *rate.Limiter {
 	return v.limiter
 }
 
+// defaultRateClient is the client of the AdaptiveRate without Client,
+// the retries do not query the DNS again.
+//
+//nolint:gochecknoglobals // shared client reusing the connections
+var defaultRateClient = gg.DefaultResolver.Client(0)
+
 // AdaptiveRate continuously adjusts the timing between requests
 // to prevent the API responds "429 Too Many Requests".
 // AdaptiveRate increases/decreases the rate
 // depending on absence/presence of the 429 status code.
+//
+// The throttled (429 and 503) and failed requests are retried
+// with a jittered exponential backoff. The header Retry-After
+// (seconds or HTTP date) is honored: the retry waits at least the requested duration,
+// but Get gives up when the server asks to wait more than MaxBackoff.
+//
+// The sleep durations are smoothed by exponentially weighted geometric means
+// (see gg.GeoEWMA), so a burst of slow or throttled responses does not
+// make the rate oscillate. The tuning knobs can be changed after NewAdaptiveRate:
+//
+//	ar := gc.NewAdaptiveRate("Deribit", time.Millisecond)
+//	ar.Alpha = 0.1                   // smoother (default 0.2)
+//	ar.Floor = time.Millisecond      // never sleep less
+//	ar.Ceiling = 5 * time.Second     // never sleep more
+//	ar.MaxBackoff = 30 * time.Second // longest sleep between two retries (default 1 minute)
+//	ar.Jitter = 0.5                  // up to +50% random sleep (default 0.25)
+//	ar.Client = &http.Client{Timeout: 10 * time.Second, Transport: proxyTransport}
+//	ar.Cache = gc.NewResponseCache(time.Minute) // GET responses kept 1 minute, then revalidated
 type AdaptiveRate struct {
+	// Client sends the requests (nil means a client resolving the hosts with gg.DefaultResolver).
+	Client *http.Client
+	// Cache keeps the GET responses (nil means no cache), see ResponseCache.
+	Cache     *ResponseCache
 	Name      string
 	NextSleep time.Duration
 	MinSleep  time.Duration
+	// Floor and Ceiling bound NextSleep and MinSleep (zero means no bound).
+	Floor   time.Duration
+	Ceiling time.Duration
+	// MaxBackoff bounds the sleep duration between two retries.
+	MaxBackoff time.Duration
+	// Alpha is the weight (0 < Alpha ≤ 1) of the last sleep duration in the averages.
+	Alpha float64
+	// Jitter is the maximum random fraction added to the backoff duration.
+	Jitter float64
+	next   gg.GeoEWMA
+	min    gg.GeoEWMA
+	stats  AdaptiveRateStats
+}
+
+// AdaptiveRateStats are the counters and the current sleep durations of an AdaptiveRate.
+type AdaptiveRateStats struct {
+	Name       string        `json:"name"`
+	NextSleep  time.Duration `json:"next_sleep"`
+	MinSleep   time.Duration `json:"min_sleep"`
+	LastSleep  time.Duration `json:"last_sleep"`  // last sleep before a request
+	RetryAfter time.Duration `json:"retry_after"` // last Retry-After received
+	Requests   int64         `json:"requests"`    // sent requests, including the retries
+	Retries    int64         `json:"retries"`
+	Throttled  int64         `json:"throttled"` // 429 and 503 responses
+	Failures   int64         `json:"failures"`  // no response (network errors)
+	GiveUps    int64         `json:"give_ups"`  // Retry-After longer than MaxBackoff
 }
 
 func NewAdaptiveRate(name string, d time.Duration) AdaptiveRate {
 	ar := AdaptiveRate{
-		Name:      name,
-		NextSleep: d * factorInitialNextSleep,
-		MinSleep:  d,
+		Name:       name,
+		NextSleep:  d * factorInitialNextSleep,
+		MinSleep:   d,
+		Alpha:      defaultAdaptiveAlpha,
+		Jitter:     defaultJitter,
+		MaxBackoff: defaultMaxBackoff,
 	}
 
 	ar.LogStats()
@@ -165,30 +285,322 @@ func NewAdaptiveRate(name string, d time.Duration) AdaptiveRate {
 	return ar
 }
 
+// Get sends a GET request and decodes the JSON response into msg.
 func (ar *AdaptiveRate) Get(symbol, url string, msg any, maxBytes ...int) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("GET %s %s: %w", ar.Name, symbol, err)
+	}
+	return ar.do(req, symbol, msg, maxBytes...)
+}
+
+// Post sends the body (JSON by default, else set the Content-Type in header)
+// and decodes the JSON response into msg.
+func (ar *AdaptiveRate) Post(ctx context.Context, symbol, url string, header http.Header, body []byte, msg any, maxBytes ...int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("POST %s %s: %w", ar.Name, symbol, err)
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return ar.do(req, symbol, msg, maxBytes...)
+}
+
+// Do sends the request (any method, headers and body) and decodes the JSON response into msg.
+// The context of the request cancels the sleeps and the retries.
+// A request having a body is retried only when req.GetBody is set,
+// as done by http.NewRequest for bytes.Reader, bytes.Buffer and strings.Reader.
+func (ar *AdaptiveRate) Do(req *http.Request, msg any, maxBytes ...int) error {
+	return ar.do(req, req.Method+" "+req.URL.Path, msg, maxBytes...)
+}
+
+func (ar *AdaptiveRate) do(req *http.Request, symbol string, msg any, maxBytes ...int) error {
+	if hit, err := ar.Cache.decodeFresh(req, msg); hit {
+		return err
+	}
+
+	ctx := req.Context()
 	var err error
+	var retryAfter time.Duration
 	d := ar.NextSleep
-	for try, status := 1, http.StatusTooManyRequests; (try < 88) && (status == http.StatusTooManyRequests || status == http.StatusTeapot); try++ {
+	try := 1
+	for status := http.StatusTooManyRequests; try < maxTries && retryable(status); try++ {
 		if try > 1 {
 			previous := d
-			alpha := int64(maxAlpha * ar.MinSleep / d)
-			d *= time.Duration(try)
-			d += time.Duration(alpha) * ar.MinSleep
-			log.Infof("%s Get %s #%d sleep=%s (+%s) alpha=%d n=%s min=%s",
-				ar.Name, symbol, try, d, d-previous, alpha, ar.NextSleep, ar.MinSleep)
+			d = ar.backoff(d, retryAfter)
+			if d < 0 {
+				ar.stats.GiveUps++
+				log.Warningf("%s %s gives up: Retry-After=%s > MaxBackoff=%s",
+					ar.Name, symbol, retryAfter, ar.MaxBackoff)
+				return err
+			}
+			ar.stats.Retries++
+			log.Infof("%s %s #%d sleep=%s (+%s) retry-after=%s n=%s min=%s",
+				ar.Name, symbol, try, d, d-previous, retryAfter, ar.NextSleep, ar.MinSleep)
+		}
+
+		r, e := replay(req, try)
+		if e != nil {
+			return errors.Join(err, e)
+		}
+		if !sleep(ctx, d) {
+			return ctx.Err()
+		}
+		ar.stats.LastSleep = d
+		ar.stats.Requests++
+		status, retryAfter, err = ar.send(r, symbol, msg, maxBytes...)
+		switch {
+		case status == http.StatusTeapot:
+			ar.stats.Failures++
+		case retryable(status):
+			ar.stats.Throttled++
+		}
+		if retryAfter > 0 {
+			ar.stats.RetryAfter = retryAfter
 		}
-		time.Sleep(d)
-		status, err = ar.get(symbol, url, msg, maxBytes...)
 	}
 
-	ar.adjust(d)
+	ar.adjust(d, try-1)
 
 	return err
 }
 
+// replay returns the request to send at the given try, with a new body for the retries.
+func replay(req *http.Request, try int) (*http.Request, error) {
+	if try == 1 || req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, fmt.Errorf("cannot retry %s %s: the request body cannot be replayed (no GetBody)", req.Method, req.URL.Path)
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r := req.Clone(req.Context())
+	r.Body = body
+	return r, nil
+}
+
+// sleep returns false when ctx is canceled before the end of the duration.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Req is a request scheduled by GetParallel (GET when Method is empty).
+// The JSON response is decoded into Msg.
+type Req struct {
+	Msg      any
+	Header   http.Header
+	Symbol   string
+	Method   string
+	URL      string
+	Body     []byte
+	MaxBytes int // zero means the default limit of gg.DecodeJSONResponse
+}
+
+func (r *Req) newRequest(ctx context.Context) (*http.Request, error) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	var body io.Reader = http.NoBody
+	if r.Body != nil {
+		body = bytes.NewReader(r.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, r.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range r.Header {
+		req.Header[k] = values
+	}
+	return req, nil
+}
+
+// reqResult is reported by a GetParallel worker.
+type reqResult struct {
+	err        error
+	index      int
+	status     int
+	retryAfter time.Duration
+}
+
+// GetParallel sends the requests using up to maxConcurrency workers
+// while keeping the aggregate rate within the adaptive budget:
+// two requests are started at least NextSleep apart.
+// A throttled request is retried with the backoff of Get, and delays all the next requests.
+//
+// The returned errors are in the same order as the requests (nil on success).
+// When ctx is canceled, the pending requests are not sent and their error is ctx.Err().
+// GetParallel returns when all the started requests are completed.
+//
+// AdaptiveRate is not safe for concurrent use: do not call Get or GetParallel concurrently.
+func (ar *AdaptiveRate) GetParallel(ctx context.Context, requests []Req, maxConcurrency int) []error {
+	maxConcurrency = max(maxConcurrency, 1)
+	errs := make([]error, len(requests))
+	tries := make([]int, len(requests))
+	sleeps := make([]time.Duration, len(requests)) // per-request backoff
+	queue := make([]int, 0, len(requests))
+	for i := range requests {
+		if ar.Cache != nil {
+			req, err := requests[i].newRequest(ctx)
+			if err == nil {
+				var hit bool
+				hit, errs[i] = ar.Cache.decodeFresh(req, requests[i].Msg)
+				if hit {
+					continue // fresh response => no request
+				}
+			}
+		}
+		queue = append(queue, i)
+	}
+
+	results := make(chan reqResult, maxConcurrency)
+	inflight := 0
+	nextStart := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for len(queue) > 0 || inflight > 0 {
+		var ready <-chan time.Time // nil => do not start a new request
+		if len(queue) > 0 && inflight < maxConcurrency && ctx.Err() == nil {
+			timer.Reset(time.Until(nextStart))
+			ready = timer.C
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, i := range queue {
+				errs[i] = ctx.Err()
+			}
+			queue = nil
+			// wait for the in-flight requests (canceled by ctx)
+			for ; inflight > 0; inflight-- {
+				res := <-results
+				errs[res.index] = res.err
+			}
+
+		case <-ready:
+			i := queue[0]
+			queue = queue[1:]
+			if sleeps[i] == 0 {
+				sleeps[i] = ar.NextSleep
+			}
+			tries[i]++
+			inflight++
+			ar.stats.Requests++
+			ar.stats.LastSleep = ar.NextSleep
+			nextStart = time.Now().Add(ar.NextSleep)
+			go func(i int, r Req) {
+				res := reqResult{index: i}
+				req, err := r.newRequest(ctx)
+				if err != nil {
+					res.err = err
+				} else {
+					res.status, res.retryAfter, res.err = ar.send(req, r.Symbol, r.Msg, maxBytesArgs(r.MaxBytes)...)
+				}
+				results <- res
+			}(i, requests[i])
+
+		case res := <-results:
+			inflight--
+			i := res.index
+			errs[i] = res.err
+			if res.retryAfter > 0 {
+				ar.stats.RetryAfter = res.retryAfter
+			}
+			if !retryable(res.status) || ctx.Err() != nil {
+				if res.err == nil {
+					ar.adjust(sleeps[i], tries[i])
+				}
+				continue
+			}
+
+			if res.status == http.StatusTeapot {
+				ar.stats.Failures++
+			} else {
+				ar.stats.Throttled++
+			}
+			if tries[i] >= maxTries {
+				continue
+			}
+			d := ar.backoff(sleeps[i], res.retryAfter)
+			if d < 0 {
+				ar.stats.GiveUps++
+				log.Warningf("%s GetParallel %s gives up: Retry-After=%s > MaxBackoff=%s",
+					ar.Name, requests[i].Symbol, res.retryAfter, ar.MaxBackoff)
+				continue
+			}
+			ar.stats.Retries++
+			sleeps[i] = d
+			if t := time.Now().Add(d); t.After(nextStart) {
+				nextStart = t // throttled => slow down all the workers
+			}
+			queue = append([]int{i}, queue...)
+			log.Infof("%s GetParallel %s #%d sleep=%s retry-after=%s n=%s min=%s",
+				ar.Name, requests[i].Symbol, tries[i]+1, d, res.retryAfter, ar.NextSleep, ar.MinSleep)
+		}
+	}
+
+	return errs
+}
+
+func maxBytesArgs(maxBytes int) []int {
+	if maxBytes > 0 {
+		return []int{maxBytes}
+	}
+	return nil
+}
+
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable || status == http.StatusTeapot
+}
+
+// backoff doubles the previous sleep duration (plus a boost when it is short compared to MinSleep),
+// adds the random jitter and honors Retry-After.
+// backoff returns a negative duration when Retry-After exceeds MaxBackoff.
+func (ar *AdaptiveRate) backoff(d, retryAfter time.Duration) time.Duration {
+	maxBackoff := ar.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+	if retryAfter > maxBackoff {
+		return -1
+	}
+
+	boost := int64(maxBoost * ar.MinSleep / max(d, 1))
+	d = 2*d + time.Duration(boost)*ar.MinSleep
+	if ar.Jitter > 0 {
+		d += time.Duration(rand.Float64() * ar.Jitter * float64(d))
+	}
+	return max(min(d, maxBackoff), retryAfter)
+}
+
+// Stats returns the counters and the current sleep durations,
+// for example to log them or to export them as metrics.
+func (ar *AdaptiveRate) Stats() AdaptiveRateStats {
+	s := ar.stats
+	s.Name = ar.Name
+	s.NextSleep = ar.NextSleep
+	s.MinSleep = ar.MinSleep
+	return s
+}
+
 func (ar *AdaptiveRate) LogStats() {
-	log.Infof("%s Adjusted sleep durations: min=%s next=%s",
-		ar.Name, ar.MinSleep, ar.NextSleep)
+	log.Infof("%s Adjusted sleep durations: min=%s next=%s requests=%d retries=%d throttled=%d failures=%d",
+		ar.Name, ar.MinSleep, ar.NextSleep, ar.stats.Requests, ar.stats.Retries, ar.stats.Throttled, ar.stats.Failures)
 }
 
 func (ar *AdaptiveRate) logIncrease(prevMin, prevNext time.Duration) {
@@ -205,43 +617,69 @@ func (ar *AdaptiveRate) logDecrease(reduce time.Duration) {
 	}
 }
 
-func (ar *AdaptiveRate) adjust(d time.Duration) {
-	const fim = factorIncreaseMinSleep - 1
-	const fin = factorIncreaseNextSleep - 1
-	const fdn = factorDecreaseNextSleep - 1
+// adjust updates the averages with the sleep duration d
+// that succeeded after the given number of tries.
+func (ar *AdaptiveRate) adjust(d time.Duration, tries int) {
+	if ar.Alpha <= 0 || ar.Alpha > 1 {
+		ar.Alpha = defaultAdaptiveAlpha
+	}
+	if !ar.next.Primed() {
+		ar.next.Set(ar.NextSleep.Seconds())
+		ar.min.Set(ar.MinSleep.Seconds())
+	}
+	ar.next.Alpha = ar.Alpha
+	ar.min.Alpha = ar.Alpha / factorMinSleepAlpha
 
-	if d > ar.NextSleep {
+	if tries > 1 {
+		// throttled: the more retries, the more weight for the longer duration
 		prevNext := ar.NextSleep
 		prevMin := ar.MinSleep
-		ar.NextSleep = (ar.NextSleep + fin*d) / factorIncreaseNextSleep
-		ar.MinSleep = (d + fim*ar.MinSleep) / factorIncreaseMinSleep
+		ar.NextSleep = ar.clamp(ar.next.AddWeighted(d.Seconds(), float64(tries-1)))
+		ar.MinSleep = ar.clamp(ar.min.Add(d.Seconds()))
 		ar.logIncrease(prevMin, prevNext)
 		return
 	}
 
-	// gap is used to detect stabilized sleep duration
-	gap := ar.NextSleep - ar.MinSleep
+	// success at first try: converge to MinSleep
+	ar.NextSleep = ar.clamp(ar.next.Add(ar.MinSleep.Seconds()))
 
-	ar.NextSleep = (ar.MinSleep + fdn*ar.NextSleep) / factorDecreaseNextSleep
+	// stabilized: try to reduce slowly the "min sleep time"
+	if gap := ar.NextSleep - ar.MinSleep; gap < ar.MinSleep/factorMinSleepAlpha {
+		prevMin := ar.MinSleep
+		ar.MinSleep = ar.clamp(ar.min.Add(probeMinSleep * ar.MinSleep.Seconds()))
+		ar.logDecrease(prevMin - ar.MinSleep)
+	}
+}
 
-	// try to reduce slowly the "min sleep time"
-	if reduce := ar.MinSleep / factorDecreaseMinSleep; gap < reduce {
-		ar.MinSleep -= reduce
-		ar.logDecrease(reduce)
+// clamp converts the seconds into a duration within [Floor, Ceiling].
+func (ar *AdaptiveRate) clamp(seconds float64) time.Duration {
+	d := time.Duration(seconds * float64(time.Second))
+	if ar.Floor > 0 {
+		d = max(d, ar.Floor)
 	}
+	if ar.Ceiling > 0 {
+		d = min(d, ar.Ceiling)
+	}
+	return d
 }
 
-func (ar *AdaptiveRate) get(symbol, url string, msg any, maxBytes ...int) (int, error) {
-	resp, err := http.Get(url)
+func (ar *AdaptiveRate) send(req *http.Request, symbol string, msg any, maxBytes ...int) (int, time.Duration, error) {
+	client := ar.Client
+	if client == nil {
+		client = defaultRateClient
+	}
+	cacheable := ar.Cache.cacheable(req)
+	key := req.URL.String()
+	resp, err := client.Do(ar.Cache.conditional(req))
 	// tentative fix for SIGSEV error
 	// I think it's because we access resp.Status without checking if it's nim
 
 	if err != nil && resp != nil {
-		return resp.StatusCode, fmt.Errorf("GET %s %s: %w", ar.Name, symbol, err)
+		return resp.StatusCode, 0, fmt.Errorf("%s %s %s: %w", req.Method, ar.Name, symbol, err)
 	} else if err != nil {
 		// if no response we can try again using the teapot
 		log.Info("we would have had an error")
-		return http.StatusTeapot, fmt.Errorf("GET %s %s: %w", ar.Name, symbol, err)
+		return http.StatusTeapot, 0, fmt.Errorf("%s %s %s: %w", req.Method, ar.Name, symbol, err)
 		/*
 						;,'
 				_o_    ;:;'
@@ -254,14 +692,56 @@ func (ar *AdaptiveRate) get(symbol, url string, msg any, maxBytes ...int) (int,
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return resp.StatusCode, errors.New("too Many Requests " + symbol)
+	if retryable(resp.StatusCode) {
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		return resp.StatusCode, retryAfter, errors.New(http.StatusText(resp.StatusCode) + " " + symbol)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return resp.StatusCode, 0, fmt.Errorf("%s %s %s: %s", req.Method, ar.Name, symbol, resp.Status)
+	}
+
+	if !cacheable {
+		err = gg.DecodeJSONResponse(resp, msg, maxBytes...)
+		if err != nil {
+			return resp.StatusCode, 0, fmt.Errorf("decode book %s: %w", symbol, err)
+		}
+		return resp.StatusCode, 0, nil
+	}
+
+	var body []byte
+	if resp.StatusCode == http.StatusNotModified {
+		var ok bool
+		body, ok = ar.Cache.notModified(key)
+		if !ok {
+			return resp.StatusCode, 0, fmt.Errorf("%s %s %s: %s but no cached response", req.Method, ar.Name, symbol, resp.Status)
+		}
+	} else {
+		body, err = gg.ReadResponse(resp, maxBytes...)
+		if err != nil {
+			return resp.StatusCode, 0, fmt.Errorf("read book %s: %w", symbol, err)
+		}
+		ar.Cache.store(key, resp.Header, body)
 	}
 
-	err = gg.DecodeJSONResponse(resp, msg, maxBytes...)
+	err = json.Unmarshal(body, msg)
 	if err != nil {
-		return resp.StatusCode, fmt.Errorf("decode book %s: %w", symbol, err)
+		return resp.StatusCode, 0, fmt.Errorf("decode book %s: %w", symbol, err)
 	}
+	return resp.StatusCode, 0, nil
+}
 
-	return resp.StatusCode, nil
+// parseRetryAfter converts the header Retry-After (delay in seconds or HTTP date)
+// into a duration, zero when absent or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return 0
+	}
+	return max(date.Sub(now), 0)
 }
@@ -0,0 +1,268 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestAdaptiveRate_Get(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if calls.Add(1)%4 == 1 { // throttle one request out of four
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	ar := gc.NewAdaptiveRate("test", 100*time.Microsecond)
+	ar.Floor = 50 * time.Microsecond
+	ar.Ceiling = 2 * time.Millisecond
+
+	var next []time.Duration
+	for range 12 {
+		var msg struct{ OK bool }
+		err := ar.Get("sym", server.URL, &msg)
+		if err != nil || !msg.OK {
+			t.Fatalf("err=%v msg=%+v", err, msg)
+		}
+		if ar.NextSleep < ar.Floor || ar.NextSleep > ar.Ceiling || ar.MinSleep < ar.Floor || ar.MinSleep > ar.Ceiling {
+			t.Fatalf("NextSleep=%s MinSleep=%s out of [%s, %s]", ar.NextSleep, ar.MinSleep, ar.Floor, ar.Ceiling)
+		}
+		next = append(next, ar.NextSleep)
+	}
+
+	// the first Get was throttled => NextSleep increased, then decreased with the successes
+	if next[0] <= 200*time.Microsecond || next[1] >= next[0] || next[2] >= next[1] {
+		t.Errorf("NextSleep history %v", next)
+	}
+}
+
+func TestAdaptiveRate_RetryAfter(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch {
+		case r.URL.Path == "/long":
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"ok":true}`))
+		}
+	}))
+	defer server.Close()
+
+	ar := gc.NewAdaptiveRate("test", 100*time.Microsecond)
+	ar.MaxBackoff = 10 * time.Millisecond
+
+	var msg struct{ OK bool }
+	err := ar.Get("sym", server.URL, &msg)
+	if err != nil || !msg.OK {
+		t.Fatalf("want 503 retried, got err=%v msg=%+v", err, msg)
+	}
+
+	err = ar.Get("sym", server.URL+"/long", &msg)
+	if err == nil {
+		t.Fatal("want error when Retry-After exceeds MaxBackoff")
+	}
+
+	s := ar.Stats()
+	if s.Requests != 3 || s.Retries != 1 || s.Throttled != 2 || s.GiveUps != 1 || s.RetryAfter != 120*time.Second {
+		t.Errorf("stats=%+v", s)
+	}
+	if s.NextSleep != ar.NextSleep || s.LastSleep <= 0 {
+		t.Errorf("stats=%+v NextSleep=%s", s, ar.NextSleep)
+	}
+}
+
+func TestAdaptiveRate_GetParallel(t *testing.T) {
+	t.Parallel()
+
+	var calls, running, maxRunning atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for old := maxRunning.Load(); n > old && !maxRunning.CompareAndSwap(old, n); old = maxRunning.Load() {
+		}
+		time.Sleep(2 * time.Millisecond)
+
+		if calls.Add(1) == 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"id":"` + r.URL.Query().Get("id") + `"}`))
+	}))
+	defer server.Close()
+
+	ar := gc.NewAdaptiveRate("test", 100*time.Microsecond)
+
+	type msg struct{ ID string }
+	msgs := make([]msg, 10)
+	requests := make([]gc.Req, len(msgs))
+	for i := range requests {
+		id := strconv.Itoa(i)
+		requests[i] = gc.Req{Symbol: id, URL: server.URL + "?id=" + id, Msg: &msgs[i]}
+	}
+
+	errs := ar.GetParallel(context.Background(), requests, 3)
+	for i, err := range errs {
+		if err != nil || msgs[i].ID != strconv.Itoa(i) {
+			t.Errorf("#%d err=%v msg=%+v", i, err, msgs[i])
+		}
+	}
+	if m := maxRunning.Load(); m > 3 {
+		t.Errorf("want at most 3 concurrent requests, got %d", m)
+	}
+	if s := ar.Stats(); s.Requests != 11 || s.Retries != 1 || s.Throttled != 1 {
+		t.Errorf("stats=%+v", s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	errs = ar.GetParallel(ctx, requests, 3)
+	for i, err := range errs {
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("#%d want context.Canceled, got %v", i, err)
+		}
+	}
+}
+
+func TestAdaptiveRate_PostDo(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if calls.Add(1) == 1 { // throttle the first request
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if r.Header.Get("X-Api-Key") != "k" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"method":"` + r.Method + `","echo":` + string(body) + `}`))
+	}))
+	defer server.Close()
+
+	ar := gc.NewAdaptiveRate("test", 100*time.Microsecond)
+	ar.Client = &http.Client{Timeout: time.Second}
+
+	var msg struct {
+		Method string
+		Echo   struct{ N int }
+	}
+	err := ar.Post(context.Background(), "post", server.URL, http.Header{"X-Api-Key": {"k"}}, []byte(`{"n":7}`), &msg)
+	if err != nil || msg.Method != http.MethodPost || msg.Echo.N != 7 {
+		t.Fatalf("want the body replayed after 429, got err=%v msg=%+v", err, msg)
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPut, server.URL, strings.NewReader(`{"n":8}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Api-Key", "k")
+	req.Header.Set("Content-Type", "application/json")
+	err = ar.Do(req, &msg)
+	if err != nil || msg.Method != http.MethodPut || msg.Echo.N != 8 {
+		t.Fatalf("err=%v msg=%+v", err, msg)
+	}
+
+	// 401 is not retried
+	err = ar.Post(context.Background(), "no-key", server.URL, nil, []byte(`{}`), &msg)
+	if err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("want 401 error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = ar.Post(ctx, "canceled", server.URL, nil, nil, &msg)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want context.Canceled, got %v", err)
+	}
+}
+
+func TestAdaptiveRate_Cache(t *testing.T) {
+	t.Parallel()
+
+	var calls, notModified atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`{"n":` + strconv.Itoa(len(r.URL.Query().Get("q"))) + `}`))
+	}))
+	defer server.Close()
+
+	ar := gc.NewAdaptiveRate("test", 100*time.Microsecond)
+	ar.Cache = gc.NewResponseCache(50 * time.Millisecond)
+
+	var msg struct{ N int }
+	for range 3 {
+		msg.N = 0
+		err := ar.Get("cached", server.URL+"?q=abc", &msg)
+		if err != nil || msg.N != 3 {
+			t.Fatalf("err=%v msg=%+v", err, msg)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("want 1 request (fresh cache), got %d", n)
+	}
+
+	time.Sleep(60 * time.Millisecond) // stale => revalidation
+	msg.N = 0
+	err := ar.Get("cached", server.URL+"?q=abc", &msg)
+	if err != nil || msg.N != 3 || notModified.Load() != 1 {
+		t.Fatalf("want 304 decoded from cache, got err=%v msg=%+v 304=%d", err, msg, notModified.Load())
+	}
+
+	results := make([]struct{ N int }, 2)
+	requests := []gc.Req{
+		{Symbol: "cached", URL: server.URL + "?q=abc", Msg: &results[0]},
+		{Symbol: "new", URL: server.URL + "?q=abcdef", Msg: &results[1]},
+	}
+	err = errors.Join(ar.GetParallel(context.Background(), requests, 2)...)
+	if err != nil || results[0].N != 3 || results[1].N != 6 {
+		t.Fatalf("err=%v results=%+v", err, results)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("want 3 requests, got %d", n)
+	}
+
+	s := ar.Cache.Stats()
+	if s.Hits != 3 || s.Revalidated != 1 || s.Misses != 2 || s.Entries != 2 {
+		t.Errorf("stats=%+v", s)
+	}
+
+	// POST responses are not cached
+	err = ar.Post(context.Background(), "post", server.URL, nil, []byte(`{}`), &msg)
+	if err != nil || calls.Load() != 4 {
+		t.Errorf("err=%v calls=%d", err, calls.Load())
+	}
+}
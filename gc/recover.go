@@ -0,0 +1,109 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// MiddlewareRecover uses the Writer of Garcon to respond the error.
+func (g *Garcon) MiddlewareRecover(notifiers ...gg.Notifier) gg.Middleware {
+	return MiddlewareRecover(g.Writer, notifiers...)
+}
+
+// MiddlewareRecover catches the panics of the next handlers.
+// The panic is converted into a gerr.ServerErr (see gerr.Recovered) including the stack,
+// logged, sent to the notifiers (if any) and responded as a 500 error
+// (JSON or problem details depending on the Accept header, see gg.Writer.WriteError).
+// The response does not contain the panic value nor the stack, only the request ID.
+// When the next handler has already written the response header,
+// the error cannot be responded: the response is aborted (http.ErrAbortHandler)
+// after the logging and the notification.
+// The http.ErrAbortHandler panic is propagated to let the server abort the response.
+func MiddlewareRecover(gw gg.Writer, notifiers ...gg.Notifier) gg.Middleware {
+	log.Info("MiddlewareRecover notifiers:", len(notifiers))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &recoverWriter{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if e, ok := v.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+					panic(v)
+				}
+
+				err := gerr.Recovered(v)
+				if id := gerr.RequestID(r.Context()); id != "" {
+					err.Data.Params["request_id"] = id
+				}
+
+				log.Error("panic", ipMethodURLSafe(r)+requestIDSuffix(r), err.Data.Params["panic"], "in", err.Data.FileLine)
+				log.Debug("stack\n", err.Data.Params["stack"])
+
+				if len(notifiers) > 0 {
+					msg := "panic " + r.Method + " " + gg.Sanitize(r.URL.Path) + ": " + err.Data.Cause.Error() + " in " + err.Data.FileLine
+					ctx := context.WithoutCancel(r.Context())
+					go func() {
+						for _, n := range notifiers {
+							e := gg.NotifyCtx(ctx, n, msg)
+							if e != nil {
+								log.Warn("MiddlewareRecover notify", e)
+							}
+						}
+					}()
+				}
+
+				if rw.wroteHeader {
+					panic(http.ErrAbortHandler) // too late to respond the error
+				}
+				gw.WriteError(w, r, err)
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// recoverWriter records whether the response header has been sent.
+type recoverWriter struct {
+	http.ResponseWriter
+
+	wroteHeader bool
+}
+
+func (rw *recoverWriter) WriteHeader(status int) {
+	rw.ResponseWriter.WriteHeader(status)
+	if status >= http.StatusOK || status == http.StatusSwitchingProtocols {
+		rw.wroteHeader = true // not the informational 1xx responses
+	}
+}
+
+func (rw *recoverWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Unwrap allows http.ResponseController to reach the underlying ResponseWriter.
+func (rw *recoverWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// ReadFrom preserves the sendfile optimization of the underlying ResponseWriter.
+func (rw *recoverWriter) ReadFrom(src io.Reader) (int64, error) {
+	rw.wroteHeader = true
+	if rf, ok := rw.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(src)
+	}
+	return io.Copy(writerOnly{rw.ResponseWriter}, src)
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type chanNotifier chan string
+
+func (n chanNotifier) Notify(msg string) error {
+	n <- msg
+	return nil
+}
+
+func TestMiddlewareRecover(t *testing.T) {
+	t.Parallel()
+
+	notifier := make(chanNotifier, 1)
+	boom := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		var m map[string]int
+		m["boom"]++ // panic: assignment to entry in nil map
+	})
+
+	h := gg.NewChain(gc.MiddlewareRequestID, gc.MiddlewareRecover(gg.NewWriter(""), notifier)).Then(boom)
+
+	r := httptest.NewRequest(http.MethodGet, "/crash", http.NoBody)
+	r.Header.Set(gerr.RequestIDHeader, "req-42")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("status=%d want 500", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "req-42") {
+		t.Error("response should contain the request ID", body)
+	}
+	if strings.Contains(body, "nil map") {
+		t.Error("response must not leak the panic value", body)
+	}
+
+	select {
+	case msg := <-notifier:
+		if !strings.HasPrefix(msg, "[req-42] panic GET /crash: assignment to entry in nil map in ") ||
+			!strings.Contains(msg, "recover_test.go:") {
+			t.Error("unexpected notification", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("missing notification")
+	}
+}
+
+func TestMiddlewareRecover_ErrAbortHandler(t *testing.T) {
+	t.Parallel()
+
+	abort := http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic(http.ErrAbortHandler) })
+	h := gc.MiddlewareRecover(gg.NewWriter(""))(abort)
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler { //nolint:errorlint // panic value
+			t.Errorf("want http.ErrAbortHandler, got %v", v)
+		}
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+}
+
+func TestMiddlewareRecover_HeaderWritten(t *testing.T) {
+	t.Parallel()
+
+	late := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte("partial"))
+		panic("too late")
+	})
+	h := gc.MiddlewareRecover(gg.NewWriter(""))(late)
+	w := httptest.NewRecorder()
+
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler { //nolint:errorlint // panic value
+			t.Errorf("want http.ErrAbortHandler, got %v", v)
+		}
+		if w.Code != http.StatusAccepted || w.Body.String() != "partial" {
+			t.Errorf("the error must not be appended to the response: status=%d body=%q", w.Code, w.Body.String())
+		}
+	}()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+}
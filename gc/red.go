@@ -0,0 +1,274 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RED measures the Rate, the Errors and the Duration of the requests per route,
+// over the rolling windows of 1 minute, 5 minutes and 1 hour,
+// for the lightweight deployments without Prometheus:
+//
+//	red := gc.NewRED()
+//	mux.Handle("GET /stats", red) // JSON dashboard, protect it (JWT, IP allow-list...)
+//	handler := red.Middleware(mux)
+//
+// The route is the pattern of the http.ServeMux (r.Pattern), else the method
+// and the first path segment ("GET /assets/*") to bound the number of routes.
+// An error is a 5xx response, the 4xx responses are counted apart.
+type RED struct {
+	routes map[string]*redRoute
+	start  time.Time
+	// MaxRoutes bounds the memory (about 35 KB per route), the next routes are grouped into "other".
+	MaxRoutes int
+	mu        sync.RWMutex
+}
+
+const (
+	redBucketWidth = 10 * time.Second
+	redBuckets     = int(time.Hour / redBucketWidth)
+	redAllRoutes   = "*"
+	redOtherRoutes = "other"
+)
+
+// redBounds are the upper bounds (milliseconds) of the latency histogram.
+//
+//nolint:gochecknoglobals // constant list
+var redBounds = [...]float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, math.Inf(1)}
+
+//nolint:gochecknoglobals // constant list
+var redWindows = []struct {
+	name string
+	d    time.Duration
+}{{"1m", time.Minute}, {"5m", 5 * time.Minute}, {"1h", time.Hour}}
+
+type (
+	// REDWindow is the summary of a route over a window.
+	REDWindow struct {
+		Requests     uint64  `json:"requests"`
+		Rate         float64 `json:"rate"` // requests per second
+		Errors       uint64  `json:"errors"`
+		ClientErrors uint64  `json:"client_errors"`
+		ErrorRatio   float64 `json:"error_ratio"`
+		AvgMs        float64 `json:"avg_ms"`
+		P50Ms        float64 `json:"p50_ms"`
+		P95Ms        float64 `json:"p95_ms"`
+		P99Ms        float64 `json:"p99_ms"`
+	}
+
+	// REDReport is the JSON document served by RED.
+	REDReport struct {
+		Routes map[string]map[string]REDWindow `json:"routes"` // route -> window -> summary
+		Uptime string                          `json:"uptime"`
+	}
+
+	redRoute struct {
+		buckets [redBuckets]redBucket
+		mu      sync.Mutex
+	}
+
+	redBucket struct {
+		hist         [len(redBounds)]uint32
+		epoch        int64 // index of the 10s period, to detect the stale buckets
+		requests     uint64
+		errors       uint64
+		clientErrors uint64
+		sumMs        float64
+	}
+)
+
+// NewRED creates a RED collector.
+func NewRED() *RED {
+	return &RED{
+		routes:    map[string]*redRoute{},
+		start:     time.Now(),
+		MaxRoutes: 200,
+	}
+}
+
+// Middleware measures the requests.
+func (red *RED) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, StatusCode: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		red.Record(redRouteName(r), rec.StatusCode, time.Since(start))
+	})
+}
+
+func redRouteName(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	segment, _, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if found {
+		segment += "/*"
+	}
+	return r.Method + " /" + segment
+}
+
+// Record adds a measure, for the handlers not using Middleware.
+func (red *RED) Record(route string, status int, d time.Duration) {
+	now := time.Now()
+	red.route(route).add(now, status, d)
+	red.route(redAllRoutes).add(now, status, d)
+}
+
+func (red *RED) route(name string) *redRoute {
+	red.mu.RLock()
+	rr, ok := red.routes[name]
+	red.mu.RUnlock()
+	if ok {
+		return rr
+	}
+
+	red.mu.Lock()
+	defer red.mu.Unlock()
+	rr, ok = red.routes[name]
+	if ok {
+		return rr
+	}
+	if len(red.routes) >= red.MaxRoutes && name != redAllRoutes {
+		name = redOtherRoutes
+		if rr, ok = red.routes[name]; ok {
+			return rr
+		}
+	}
+	rr = &redRoute{}
+	red.routes[name] = rr
+	return rr
+}
+
+func (rr *redRoute) add(now time.Time, status int, d time.Duration) {
+	epoch := now.UnixNano() / int64(redBucketWidth)
+	ms := float64(d) / float64(time.Millisecond)
+	i, _ := slices.BinarySearch(redBounds[:], ms)
+
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	b := &rr.buckets[epoch%int64(redBuckets)]
+	if b.epoch != epoch {
+		*b = redBucket{epoch: epoch}
+	}
+	b.requests++
+	b.sumMs += ms
+	b.hist[i]++
+	switch {
+	case status >= http.StatusInternalServerError:
+		b.errors++
+	case status >= http.StatusBadRequest:
+		b.clientErrors++
+	}
+}
+
+// window sums the buckets of the last d.
+func (rr *redRoute) window(now time.Time, d time.Duration) REDWindow {
+	last := now.UnixNano() / int64(redBucketWidth)
+	first := last - int64(d/redBucketWidth) + 1
+
+	var sum redBucket
+	rr.mu.Lock()
+	for epoch := first; epoch <= last; epoch++ {
+		b := &rr.buckets[epoch%int64(redBuckets)]
+		if b.epoch != epoch {
+			continue
+		}
+		sum.requests += b.requests
+		sum.errors += b.errors
+		sum.clientErrors += b.clientErrors
+		sum.sumMs += b.sumMs
+		for i, n := range b.hist {
+			sum.hist[i] += n
+		}
+	}
+	rr.mu.Unlock()
+
+	w := REDWindow{
+		Requests:     sum.requests,
+		Rate:         round3(float64(sum.requests) / d.Seconds()),
+		Errors:       sum.errors,
+		ClientErrors: sum.clientErrors,
+	}
+	if sum.requests > 0 {
+		w.ErrorRatio = round3(float64(sum.errors) / float64(sum.requests))
+		w.AvgMs = round3(sum.sumMs / float64(sum.requests))
+		w.P50Ms = sum.quantile(0.50)
+		w.P95Ms = sum.quantile(0.95)
+		w.P99Ms = sum.quantile(0.99)
+	}
+	return w
+}
+
+// quantile interpolates linearly within the histogram bucket.
+// The last bucket (unbounded) reports its lower bound.
+func (b *redBucket) quantile(q float64) float64 {
+	rank := q * float64(b.requests)
+	var cumul float64
+	for i, n := range b.hist {
+		if n == 0 {
+			continue
+		}
+		if cumul+float64(n) >= rank {
+			lower := 0.0
+			if i > 0 {
+				lower = redBounds[i-1]
+			}
+			upper := redBounds[i]
+			if math.IsInf(upper, 1) {
+				return lower
+			}
+			return round3(lower + (upper-lower)*(rank-cumul)/float64(n))
+		}
+		cumul += float64(n)
+	}
+	return 0
+}
+
+func round3(x float64) float64 {
+	return math.Round(x*1000) / 1000
+}
+
+// Report computes the summary of all the routes ("*" is the total).
+func (red *RED) Report() REDReport {
+	now := time.Now()
+	red.mu.RLock()
+	routes := make(map[string]*redRoute, len(red.routes))
+	for name, rr := range red.routes {
+		routes[name] = rr
+	}
+	red.mu.RUnlock()
+
+	report := REDReport{
+		Routes: make(map[string]map[string]REDWindow, len(routes)),
+		Uptime: now.Sub(red.start).Round(time.Second).String(),
+	}
+	for name, rr := range routes {
+		windows := make(map[string]REDWindow, len(redWindows))
+		for _, win := range redWindows {
+			windows[win.name] = rr.window(now, win.d)
+		}
+		report.Routes[name] = windows
+	}
+	return report
+}
+
+// ServeHTTP responds the REDReport in JSON.
+func (red *RED) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(red.Report())
+	if err != nil {
+		log.Warn("RED encode", err)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestRED(t *testing.T) {
+	t.Parallel()
+
+	red := gc.NewRED()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "0" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+	mux.HandleFunc("GET /missing", http.NotFound)
+	handler := red.Middleware(mux)
+
+	for _, path := range []string{"/api/items/1", "/api/items/2", "/api/items/3", "/api/items/0", "/missing", "/assets/app.js"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	}
+	red.Record("job", http.StatusOK, 3*time.Second)
+
+	w := httptest.NewRecorder()
+	red.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", http.NoBody))
+	var report gc.REDReport
+	err := json.Unmarshal(w.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := report.Routes["GET /api/items/{id}"]["1m"]
+	if items.Requests != 4 || items.Errors != 1 || items.ErrorRatio != 0.25 || items.Rate != 0.067 {
+		t.Errorf("items=%+v", items)
+	}
+	if m := report.Routes["GET /missing"]["5m"]; m.ClientErrors != 1 || m.Errors != 0 {
+		t.Errorf("missing=%+v", m)
+	}
+	if _, ok := report.Routes["GET /assets/*"]; !ok {
+		t.Errorf("want the route without pattern grouped by first segment, got %v", report.Routes)
+	}
+	if all := report.Routes["*"]["1h"]; all.Requests != 7 {
+		t.Errorf("total=%+v", all)
+	}
+	if job := report.Routes["job"]["1m"]; job.P50Ms < 2000 || job.P50Ms > 5000 || job.AvgMs != 3000 {
+		t.Errorf("job=%+v", job)
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// RedisCacheStore is a CacheStore shared by several instances, speaking the RESP protocol
+	// of Redis (and compatible servers: Valkey, KeyDB, Dragonfly...) without dependency:
+	//
+	//	store, err := gc.NewRedisCacheStore("redis://:password@redis:6379/0", "myapi:")
+	//	if err != nil {
+	//		log.Fatal(err)
+	//	}
+	//	r.With(g.MiddlewareCache(store, time.Minute, nil)).Get("/api/stats", stats)
+	//
+	// The keys are prefixed by the namespace. The connections are kept in a small pool.
+	RedisCacheStore struct {
+		pool      chan *redisConn
+		addr      string
+		password  string
+		username  string
+		namespace string
+		db        int
+		// Timeout bounds each command when ctx has no deadline (default one second).
+		Timeout time.Duration
+	}
+
+	redisConn struct {
+		conn net.Conn
+		r    *bufio.Reader
+	}
+
+	// redisError is an error reply of the server.
+	redisError string
+)
+
+const (
+	redisPoolSize    = 8
+	redisScanCount   = "500"
+	defaultRedisPort = "6379"
+)
+
+// NewRedisCacheStore parses the URL "redis://[user:password@]host[:port][/db]"
+// and checks the connection with a PING.
+func NewRedisCacheStore(redisURL, namespace string) (*RedisCacheStore, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, errors.New("Redis URL must start with redis:// got " + u.Scheme)
+	}
+
+	s := &RedisCacheStore{
+		pool:      make(chan *redisConn, redisPoolSize),
+		addr:      u.Host,
+		namespace: namespace,
+		Timeout:   time.Second,
+	}
+	if u.Port() == "" {
+		s.addr = net.JoinHostPort(u.Hostname(), defaultRedisPort)
+	}
+	if u.User != nil {
+		s.username = u.User.Username()
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		s.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, errors.New("invalid Redis database " + strconv.Quote(db))
+		}
+	}
+
+	_, err = s.do(context.Background(), "PING")
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get returns the value, ok is false when the key is missing or expired.
+func (s *RedisCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	v, err := s.do(ctx, "GET", s.namespace+key)
+	if err != nil || v == nil {
+		return nil, false, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("Redis GET: unexpected reply %T", v)
+	}
+	return b, true, nil
+}
+
+// Set stores the value during ttl (millisecond precision).
+func (s *RedisCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	ms := max(ttl.Milliseconds(), 1)
+	_, err := s.do(ctx, "SET", s.namespace+key, value, "PX", strconv.FormatInt(ms, 10))
+	return err
+}
+
+// SetIfAbsent stores the value during ttl only when the key is missing (SET NX).
+func (s *RedisCacheStore) SetIfAbsent(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	ms := max(ttl.Milliseconds(), 1)
+	v, err := s.do(ctx, "SET", s.namespace+key, value, "NX", "PX", strconv.FormatInt(ms, 10))
+	return v != nil, err
+}
+
+// Delete removes the key.
+func (s *RedisCacheStore) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, "DEL", s.namespace+key)
+	return err
+}
+
+// DeletePrefix iterates over the keys starting with prefix (SCAN) and removes them.
+func (s *RedisCacheStore) DeletePrefix(ctx context.Context, prefix string) error {
+	pattern := redisGlobEscape(s.namespace+prefix) + "*"
+	cursor := "0"
+	for {
+		v, err := s.do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", redisScanCount)
+		if err != nil {
+			return err
+		}
+		reply, ok := v.([]any)
+		if !ok || len(reply) != 2 {
+			return errors.New("Redis SCAN: unexpected reply")
+		}
+		next, _ := reply[0].([]byte)
+		keys, _ := reply[1].([]any)
+		if len(keys) > 0 {
+			_, err = s.do(ctx, append([]any{"DEL"}, keys...)...)
+			if err != nil {
+				return err
+			}
+		}
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			return nil
+		}
+	}
+}
+
+// Close closes the idle connections.
+func (s *RedisCacheStore) Close() error {
+	var errs []error
+	for {
+		select {
+		case c := <-s.pool:
+			errs = append(errs, c.conn.Close())
+		default:
+			return errors.Join(errs...)
+		}
+	}
+}
+
+// do sends the command and returns the reply: nil, int64, []byte, string or []any.
+func (s *RedisCacheStore) do(ctx context.Context, args ...any) (any, error) {
+	c, err := s.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(s.Timeout)
+	}
+	err = c.conn.SetDeadline(deadline)
+	if err == nil {
+		err = c.write(args)
+	}
+	var reply any
+	if err == nil {
+		reply, err = c.read()
+	}
+
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		c.conn.Close() // the connection state is unknown
+		return nil, err
+	}
+	select {
+	case s.pool <- c:
+	default:
+		c.conn.Close()
+	}
+	return reply, err
+}
+
+// conn returns an idle connection, or dials and authenticates a new one.
+func (s *RedisCacheStore) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-s.pool:
+		return c, nil
+	default:
+	}
+
+	var d net.Dialer
+	d.Timeout = s.Timeout
+	conn, err := d.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn)}
+	err = conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	if err == nil && s.password != "" {
+		args := []any{"AUTH", s.password}
+		if s.username != "" {
+			args = []any{"AUTH", s.username, s.password}
+		}
+		err = c.command(args...)
+	}
+	if err == nil && s.db != 0 {
+		err = c.command("SELECT", strconv.Itoa(s.db))
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *redisConn) command(args ...any) error {
+	err := c.write(args)
+	if err != nil {
+		return err
+	}
+	_, err = c.read()
+	return err
+}
+
+// write sends the command as a RESP array of bulk strings.
+func (c *redisConn) write(args []any) error {
+	buf := make([]byte, 0, 64)
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, "\r\n"...)
+	for _, a := range args {
+		var b []byte
+		switch v := a.(type) {
+		case string:
+			b = []byte(v)
+		case []byte:
+			b = v
+		default:
+			return fmt.Errorf("Redis: unsupported argument %T", a)
+		}
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(b)), 10)
+		buf = append(buf, "\r\n"...)
+		buf = append(buf, b...)
+		buf = append(buf, "\r\n"...)
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// read parses a RESP2 reply.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("Redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err // nil bulk string: missing key
+		}
+		b := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			items[i], err = c.read()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, errors.New("Redis: unexpected reply " + strconv.Quote(line))
+}
+
+func (e redisError) Error() string { return "Redis: " + string(e) }
+
+// redisGlobEscape escapes the special characters of the MATCH pattern.
+func redisGlobEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,292 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/lynxai-team/emo"
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// ReloadableConfig contains the settings applied without restarting the server.
+	//
+	//	# garcon.toml
+	//	origins = ["https://example.com", "https://admin.example.com"]
+	//	policy  = "auth.rego"
+	//	log     = "debug"
+	//	[rate-limit]
+	//	burst      = 20
+	//	per-minute = 80
+	ReloadableConfig struct {
+		// Policy is the path of the authorization policy file, the subscribers reload their policy engine.
+		Policy string `toml:"policy" yaml:"policy"`
+		// LogLevel "debug" enables the verbose logs, the other levels disable them.
+		LogLevel string `toml:"log" yaml:"log"`
+		// Origins are the CORS allowed origins (prefixes), empty means the origins of WithURLs.
+		Origins   []string        `toml:"origins"    yaml:"origins"`
+		RateLimit RateLimitConfig `toml:"rate-limit" yaml:"rate-limit"`
+	}
+
+	// RateLimitConfig is the setting of MiddlewareRateLimiter, zero means the default value.
+	RateLimitConfig struct {
+		Burst     int `toml:"burst"      yaml:"burst"`
+		PerMinute int `toml:"per-minute" yaml:"per-minute"`
+	}
+
+	// ReloadEvent is published to the subscribers after each successful reload.
+	ReloadEvent struct {
+		Old, New *ReloadableConfig
+		// Changed lists the modified settings: "origins", "policy", "log" and "rate-limit".
+		Changed []string
+	}
+
+	// Reloader watches a configuration file (TOML or YAML) and atomically swaps the
+	// reloadable settings: the middlewares created by the Reloader always use the
+	// last valid configuration. An invalid file is logged and the previous settings are kept.
+	//
+	//	rl, err := g.NewReloader("garcon.toml")   // the watch is started by g.Run
+	//	chain := gg.NewChain(rl.MiddlewareCORS(nil, nil), rl.MiddlewareRateLimiter())
+	//	rl.Subscribe(func(e gc.ReloadEvent) {
+	//		if slices.Contains(e.Changed, "policy") { reloadPolicy(e.New.Policy) }
+	//	})
+	Reloader struct {
+		cfg      atomic.Pointer[ReloadableConfig]
+		modTime  time.Time
+		writer   gg.Writer
+		path     string
+		urls     []string // default CORS origins
+		subs     []func(ReloadEvent)
+		limiters []*ReqLimiter
+		// Interval is the period of the file modification check (default 2 seconds).
+		Interval time.Duration
+		mu       sync.Mutex
+		devMode  bool
+	}
+)
+
+// NewReloader loads the configuration file and registers the hook watching it during g.Run.
+func (g *Garcon) NewReloader(path string) (*Reloader, error) {
+	rl, err := NewReloader(g.Writer, path, g.allowedOrigins, g.devMode)
+	if err != nil {
+		return nil, err
+	}
+	var cancel context.CancelFunc
+	g.AddHook(Hook{
+		Name: "reloader " + path,
+		Start: func(context.Context) error {
+			var ctx context.Context
+			ctx, cancel = context.WithCancel(context.Background())
+			go rl.Watch(ctx)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			cancel()
+			return nil
+		},
+	})
+	return rl, nil
+}
+
+// NewReloader loads the configuration file, the default CORS origins are used when
+// the file does not define any origin. Call Watch to apply the file modifications.
+func NewReloader(gw gg.Writer, path string, origins []string, devMode bool) (*Reloader, error) {
+	rl := &Reloader{
+		writer:   gw,
+		path:     path,
+		urls:     slices.Clone(origins),
+		Interval: 2 * time.Second,
+		devMode:  devMode,
+	}
+	cfg, modTime, err := rl.load()
+	if err != nil {
+		return nil, err
+	}
+	rl.modTime = modTime
+	rl.cfg.Store(cfg)
+	applyLogLevel(cfg.LogLevel)
+	log.Info("Reloader", path, "origins=", cfg.Origins, "rate-limit=", cfg.RateLimit)
+	return rl, nil
+}
+
+// Config returns the current settings (do not modify them).
+func (rl *Reloader) Config() *ReloadableConfig { return rl.cfg.Load() }
+
+// Subscribe registers a function called after each reload.
+func (rl *Reloader) Subscribe(fn func(ReloadEvent)) {
+	rl.mu.Lock()
+	rl.subs = append(rl.subs, fn)
+	rl.mu.Unlock()
+}
+
+// Watch checks the modification time of the file every Interval and reloads it, until ctx is done.
+func (rl *Reloader) Watch(ctx context.Context) {
+	ticker := time.NewTicker(rl.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(rl.path)
+			if err != nil {
+				log.Warn("Reloader", err)
+				continue
+			}
+			rl.mu.Lock()
+			changed := !info.ModTime().Equal(rl.modTime)
+			rl.mu.Unlock()
+			if changed {
+				err = rl.Reload()
+				if err != nil {
+					log.Warn("Reloader keeps the previous settings:", err)
+				}
+			}
+		}
+	}
+}
+
+// Reload reads the file, swaps the settings and notifies the subscribers.
+// On error, the previous settings are kept.
+func (rl *Reloader) Reload() error {
+	cfg, modTime, err := rl.load()
+	rl.mu.Lock()
+	rl.modTime = modTime // do not retry an invalid file until its next modification
+	if err != nil {
+		rl.mu.Unlock()
+		return err
+	}
+	old := rl.cfg.Swap(cfg)
+	limiters := slices.Clone(rl.limiters)
+	subs := slices.Clone(rl.subs)
+	rl.mu.Unlock()
+
+	e := ReloadEvent{Old: old, New: cfg, Changed: changedSettings(old, cfg)}
+	if slices.Contains(e.Changed, "log") {
+		applyLogLevel(cfg.LogLevel)
+	}
+	if slices.Contains(e.Changed, "rate-limit") {
+		for _, l := range limiters {
+			l.SetLimits(rateLimits(cfg.RateLimit))
+		}
+	}
+	log.Info("Reloader", rl.path, "changed:", e.Changed)
+	for _, fn := range subs {
+		fn(e)
+	}
+	return nil
+}
+
+func (rl *Reloader) load() (*ReloadableConfig, time.Time, error) {
+	info, err := os.Stat(rl.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := os.ReadFile(rl.path)
+	if err != nil {
+		return nil, info.ModTime(), err
+	}
+
+	var cfg ReloadableConfig
+	switch filepath.Ext(rl.path) {
+	case ".toml":
+		err = toml.Unmarshal(data, &cfg)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &cfg)
+	default:
+		err = errors.New("configuration file must be .toml, .yaml or .yml: " + rl.path)
+	}
+	if err != nil {
+		return nil, info.ModTime(), err
+	}
+	if len(cfg.Origins) == 0 {
+		cfg.Origins = rl.urls
+	}
+	cfg.Origins = slices.Clone(cfg.Origins)
+	InsertSchema(cfg.Origins)
+	if cfg.RateLimit.Burst < 0 || cfg.RateLimit.PerMinute < 0 {
+		return nil, info.ModTime(), errors.New("rate-limit must be positive")
+	}
+	return &cfg, info.ModTime(), nil
+}
+
+func changedSettings(old, cfg *ReloadableConfig) []string {
+	var changed []string
+	if !slices.Equal(old.Origins, cfg.Origins) {
+		changed = append(changed, "origins")
+	}
+	if old.Policy != cfg.Policy {
+		changed = append(changed, "policy")
+	}
+	if old.LogLevel != cfg.LogLevel {
+		changed = append(changed, "log")
+	}
+	if old.RateLimit != cfg.RateLimit {
+		changed = append(changed, "rate-limit")
+	}
+	return changed
+}
+
+func applyLogLevel(level string) {
+	if level == "" {
+		return // keep the verbosity of the application
+	}
+	emo.GlobalVerbosity(level == "debug" || level == "DEBUG")
+}
+
+// rateLimits applies the defaults of MiddlewareRateLimiter.
+func rateLimits(c RateLimitConfig) (burst, perMinute int) {
+	burst, perMinute = c.Burst, c.PerMinute
+	if burst == 0 {
+		burst = 20
+	}
+	if perMinute == 0 {
+		perMinute = 4 * burst
+	}
+	return burst, perMinute
+}
+
+// MiddlewareCORS is the CORS middleware allowing the origins of the current settings.
+func (rl *Reloader) MiddlewareCORS(methods, headers []string) gg.Middleware {
+	allow := func(origin string) bool {
+		for _, prefix := range rl.cfg.Load().Origins {
+			if strings.HasPrefix(origin, prefix) {
+				return true
+			}
+		}
+		log.Security("CORS Refuse", origin)
+		return false
+	}
+	c := newCORSFunc(allow, methods, headers, rl.devMode)
+	if c.Log != nil {
+		c.Log = corsLogger{}
+	}
+	return c.Handler
+}
+
+// MiddlewareRateLimiter is the rate limiter middleware using the current settings.
+func (rl *Reloader) MiddlewareRateLimiter() gg.Middleware {
+	burst, perMinute := rateLimits(rl.cfg.Load().RateLimit)
+	limiter := NewRateLimiter(rl.writer, burst, perMinute, rl.devMode)
+	rl.mu.Lock()
+	rl.limiters = append(rl.limiters, &limiter)
+	rl.mu.Unlock()
+	return func(next http.Handler) http.Handler {
+		return limiter.MiddlewareRateLimiter(next)
+	}
+}
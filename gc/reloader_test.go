@@ -0,0 +1,72 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestReloader(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "garcon.toml")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("origins = [\"https://a.example\"]\n[rate-limit]\nburst = 5\n")
+
+	rl, err := gc.NewReloader("", path, []string{"http://localhost:"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := rl.Config(); c.RateLimit.Burst != 5 || !slices.Equal(c.Origins, []string{"https://a.example"}) {
+		t.Fatalf("config = %+v", c)
+	}
+
+	ok := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	h := rl.MiddlewareCORS(nil, nil)(ok)
+	allowed := func(origin string) bool {
+		r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Header().Get("Access-Control-Allow-Origin") == origin
+	}
+	if !allowed("https://a.example") || allowed("https://b.example") {
+		t.Fatal("CORS must allow only https://a.example")
+	}
+
+	var events []gc.ReloadEvent
+	rl.Subscribe(func(e gc.ReloadEvent) { events = append(events, e) })
+
+	write("origins = [\"https://b.example\"]\npolicy = \"authz.rego\"\n[rate-limit]\nburst = 5\n")
+	if err = rl.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if allowed("https://a.example") || !allowed("https://b.example") {
+		t.Error("CORS must allow only https://b.example after the reload")
+	}
+	if len(events) != 1 || !slices.Equal(events[0].Changed, []string{"origins", "policy"}) {
+		t.Errorf("events = %+v", events)
+	}
+
+	write("origins = [") // invalid => keep the previous settings
+	if err = rl.Reload(); err == nil {
+		t.Error("want an error for an invalid file")
+	}
+	if rl.Config().Policy != "authz.rego" || len(events) != 1 {
+		t.Error("an invalid file must not change the settings")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// maxRequestIDLen limits the size of the request ID received from clients.
+const maxRequestIDLen = 64
+
+// MiddlewareRequestID puts a request ID in the request context and in the response header.
+// The ID is taken from the incoming "X-Request-ID" header (set by a trusted proxy)
+// when valid, else a random one is generated.
+// The logging middlewares (MiddlewareLogRequest...) already do that:
+// MiddlewareRequestID is for the servers not logging the requests.
+// The gg.Writer responses contain the ID of the request context,
+// and ReverseProxy and ServeOrigin forward it to the upstream servers.
+// Use gerr.RequestID(r.Context()) to read the ID,
+// gerr.NewCtx() to add it in the errors,
+// gg.NotifyCtx() to add it in the notifications,
+// and RTMiddlewareRequestID to forward it with the other http.Client.
+func MiddlewareRequestID(next http.Handler) http.Handler {
+	log.Info("MiddlewareRequestID sets the header " + gerr.RequestIDHeader)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = withRequestID(w, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RTMiddlewareRequestID forwards the request ID of the context
+// within the "X-Request-ID" header of the outbound request.
+func RTMiddlewareRequestID(next http.RoundTripper) http.RoundTripper {
+	return gg.RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		id := gerr.RequestID(r.Context())
+		if id != "" && r.Header.Get(gerr.RequestIDHeader) == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set(gerr.RequestIDHeader, id)
+		}
+		return next.RoundTrip(r)
+	})
+}
+
+// withRequestID ensures the request has an ID, only once in the middleware chain.
+func withRequestID(w http.ResponseWriter, r *http.Request) (*http.Request, string) {
+	id := gerr.RequestID(r.Context())
+	if id != "" {
+		return r, id
+	}
+
+	id = r.Header.Get(gerr.RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+
+	w.Header().Set(gerr.RequestIDHeader, id)
+	return r.WithContext(gerr.WithRequestID(r.Context(), id)), id
+}
+
+// validRequestID accepts only [0-9A-Za-z._-] to keep the logs clean.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case '0' <= c && c <= '9', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
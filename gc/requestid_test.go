@@ -0,0 +1,121 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestMiddlewareRequestID(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generated", "", false},
+		{"forwarded", "abc-123_x.y", true},
+		{"rejected", "bad id with spaces", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			var inCtx string
+			var errParam any
+			h := gc.MiddlewareLogRequest(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+				inCtx = gerr.RequestID(r.Context())
+				errParam = gerr.NewCtx(r.Context(), gerr.Invalid, "test").Data.Params["request_id"]
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			if c.incoming != "" {
+				r.Header.Set(gerr.RequestIDHeader, c.incoming)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			got := w.Header().Get(gerr.RequestIDHeader)
+			if got == "" || got != inCtx || errParam != got {
+				t.Errorf("header=%q ctx=%q gerr=%v", got, inCtx, errParam)
+			}
+			if c.keep != (got == c.incoming) {
+				t.Errorf("incoming=%q got=%q", c.incoming, got)
+			}
+		})
+	}
+}
+
+func TestRTMiddlewareRequestID(t *testing.T) {
+	t.Parallel()
+
+	var upstream string
+	rt := gg.NewRTChain(gc.RTMiddlewareRequestID).ThenFunc(func(r *http.Request) (*http.Response, error) {
+		upstream = r.Header.Get(gerr.RequestIDHeader)
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+
+	ctx := gerr.WithRequestID(context.Background(), "id42")
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://upstream/", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rt.RoundTrip(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if upstream != "id42" {
+		t.Errorf("upstream got request ID %q", upstream)
+	}
+}
+
+func TestRequestID_Chain(t *testing.T) {
+	t.Parallel()
+
+	var upstreamID string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamID = r.Header.Get(gerr.RequestIDHeader)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer upstream.Close()
+
+	gw := gg.NewWriter("")
+	mux := http.NewServeMux()
+	mux.Handle("/api/", gc.NewReverseProxy(gw, "/api", upstream.URL))
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		gw.WriteError(w, r, gerr.New(gerr.Invalid, "bad input")) // no NewCtx
+	})
+	h := gc.MiddlewareRequestID(mux)
+
+	r := httptest.NewRequest(http.MethodGet, "/fail", http.NoBody)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	id := w.Header().Get(gerr.RequestIDHeader)
+	if id == "" || !strings.Contains(w.Body.String(), `"request_id":"`+id+`"`) {
+		t.Errorf("error response should contain the request ID %q: %s", id, w.Body.String())
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/api/users", http.NoBody)
+	r.Header.Set(gerr.RequestIDHeader, "bad id with spaces")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	id = w.Header().Get(gerr.RequestIDHeader)
+	if w.Code != http.StatusNoContent || id == "" || upstreamID != id {
+		t.Errorf("status=%d upstream ID=%q want %q", w.Code, upstreamID, id)
+	}
+}
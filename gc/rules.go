@@ -0,0 +1,363 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// Rule is a redirection or an internal rewrite of the URL path.
+// The path matches either the Prefix or the Regexp.
+// A rule having a Host only applies to the requests for this host.
+type Rule struct {
+	Regexp *regexp.Regexp
+	Host   string
+	Prefix string
+	Target string
+	Line   int
+	// Status is the redirection code (301, 302, 307 or 308).
+	// Zero means an internal rewrite.
+	Status int
+}
+
+// Rules is an ordered list of rules: the first matching rule wins.
+type Rules []Rule
+
+var ErrRules = errors.New("invalid rules")
+
+// LoadRules reads and validates a rules file.
+// Each line contains three or four fields:
+//
+//	# comments and blank lines are ignored
+//	redirect 301 /old-blog/          /blog/
+//	redirect 302 ~^/p/([0-9]+)$      /posts/$1
+//	rewrite      /docs/              /documentation/
+//	rewrite      ~^/u/([a-z]+)$      /users/$1
+//
+// A pattern starting with "~" is a regular expression,
+// the target may then contain $1, $2... else the pattern is a path prefix
+// replaced by the target (the rest of the path is kept).
+// A pattern starting with "https://host" (or "http://host") only matches the requests
+// for this host (the scheme is ignored), to route the virtual hosts:
+//
+//	redirect 308 https://old.example.com/  https://example.com/
+//	rewrite      https://docs.example.com/ /docs/
+//
+// Redirections keep the query string.
+// A file named "_redirects" is parsed with the Netlify syntax (see ParseRedirects).
+func LoadRules(file string) (Rules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if filepath.Base(file) == "_redirects" {
+		return ParseRedirects(f)
+	}
+	return ParseRules(f)
+}
+
+// Add appends a rule: a zero or 200 status is an internal rewrite,
+// the pattern and the target have the syntax of LoadRules.
+//
+//	var rules gc.Rules
+//	err := rules.Add(301, "/old-blog/", "/blog/")
+//	err = rules.Add(0, "~^/u/([a-z]+)$", "/users/$1")
+func (rules *Rules) Add(status int, pattern, target string) error {
+	fields := []string{"rewrite", pattern, target}
+	if status != 0 && status != http.StatusOK {
+		fields = []string{"redirect", strconv.Itoa(status), pattern, target}
+	}
+	rule, err := parseRule(fields)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRules, err)
+	}
+	*rules = append(*rules, rule)
+	return nil
+}
+
+// ParseRules reads the rules (see LoadRules) and reports all the invalid lines.
+func ParseRules(r io.Reader) (Rules, error) {
+	return parseLines(r, parseRule)
+}
+
+// ParseRedirects reads the rules in the syntax of the Netlify "_redirects" file,
+// to deploy the sites generated for Netlify or Cloudflare Pages:
+//
+//	# from                     to                       [status]
+//	/old-blog/*                /blog/:splat
+//	/news/:year/:slug          /blog/:year/:slug        302
+//	/app/*                     /index.html              200
+//	https://old.example.com/*  https://example.com/:splat 301!
+//
+// The status defaults to 301, 200 is an internal rewrite.
+// A "from" without placeholder matches the exact path (with or without a trailing slash).
+// The force flag "!" is accepted and ignored: the rules are always applied before the router.
+// The proxying (200 to another site), the query and the country conditions are not supported.
+func ParseRedirects(r io.Reader) (Rules, error) {
+	return parseLines(r, parseRedirect)
+}
+
+func parseLines(r io.Reader, parse func([]string) (Rule, error)) (Rules, error) {
+	var rules Rules
+	var errs []error
+	seen := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule, err := parse(strings.Fields(line))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: line %d: %w", ErrRules, n, err))
+			continue
+		}
+		rule.Line = n
+
+		pattern := rule.pattern()
+		if prev, ok := seen[pattern]; ok {
+			errs = append(errs, fmt.Errorf("%w: line %d: pattern %q already used at line %d", ErrRules, n, pattern, prev))
+			continue
+		}
+		seen[pattern] = n
+
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return rules, errors.Join(errs...)
+}
+
+func parseRule(fields []string) (Rule, error) {
+	var rule Rule
+	switch {
+	case len(fields) == 4 && fields[0] == "redirect":
+		status, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return rule, fmt.Errorf("redirect status %q: %w", fields[1], err)
+		}
+		switch status {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return rule, fmt.Errorf("redirect status %d is not 301, 302, 307 or 308", status)
+		}
+		rule.Status = status
+		fields = fields[2:]
+	case len(fields) == 3 && fields[0] == "rewrite":
+		fields = fields[1:]
+	default:
+		return rule, errors.New(`want "redirect <status> <pattern> <target>" or "rewrite <pattern> <target>"`)
+	}
+
+	pattern, target := fields[0], fields[1]
+	if rest, ok := cutScheme(pattern); ok {
+		host, path, found := strings.Cut(rest, "/")
+		if host == "" {
+			return rule, fmt.Errorf("missing host in %q", pattern)
+		}
+		rule.Host = strings.ToLower(host)
+		pattern = "/"
+		if found {
+			pattern += path
+		}
+	}
+	if rule.Status == 0 && target[0] != '/' {
+		return rule, fmt.Errorf("rewrite target %q must be a path starting with /", target)
+	}
+	rule.Target = target
+
+	if expr, ok := strings.CutPrefix(pattern, "~"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return rule, err
+		}
+		rule.Regexp = re
+		return rule, nil
+	}
+
+	if pattern[0] != '/' {
+		return rule, fmt.Errorf("prefix %q must start with /", pattern)
+	}
+	rule.Prefix = pattern
+	return rule, nil
+}
+
+// placeholder matches the ":name" parameters of the Netlify syntax.
+var placeholder = regexp.MustCompile(`:[A-Za-z_][A-Za-z0-9_]*`) //nolint:gochecknoglobals // compiled once
+
+// parseRedirect converts a line of a "_redirects" file to a rule using a regular expression.
+func parseRedirect(fields []string) (Rule, error) {
+	var rule Rule
+	if len(fields) < 2 || len(fields) > 3 {
+		return rule, errors.New(`want "<from> <to> [status]" (query and country conditions are not supported)`)
+	}
+
+	rule.Status = http.StatusMovedPermanently
+	if len(fields) == 3 {
+		status, err := strconv.Atoi(strings.TrimSuffix(fields[2], "!"))
+		if err != nil {
+			return rule, fmt.Errorf("status %q: %w", fields[2], err)
+		}
+		switch status {
+		case http.StatusOK:
+			rule.Status = 0
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			rule.Status = status
+		default:
+			return rule, fmt.Errorf("status %d is not 200, 301, 302, 307 or 308", status)
+		}
+	}
+
+	from, to := fields[0], fields[1]
+	if rest, ok := cutScheme(from); ok {
+		host, path, _ := strings.Cut(rest, "/")
+		if host == "" {
+			return rule, fmt.Errorf("missing host in %q", from)
+		}
+		rule.Host = strings.ToLower(host)
+		from = "/" + path
+	}
+	if from[0] != '/' {
+		return rule, fmt.Errorf("from %q must start with / or https://", from)
+	}
+	if rule.Status == 0 && to[0] != '/' {
+		return rule, fmt.Errorf("proxying to %q is not supported, a rewrite target must start with /", to)
+	}
+
+	// convert the placeholders and the trailing "*" into named groups
+	expr := "^"
+	if path, ok := strings.CutSuffix(from, "*"); ok {
+		expr += placeholders(path) + "(?P<splat>.*)$"
+	} else {
+		expr += placeholders(strings.TrimSuffix(from, "/")) + "/?$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return rule, err
+	}
+	rule.Regexp = re
+
+	names := re.SubexpNames()
+	rule.Target = placeholder.ReplaceAllStringFunc(strings.ReplaceAll(to, "$", "$$"), func(p string) string {
+		if slices.Contains(names, p[1:]) {
+			return "${" + p[1:] + "}"
+		}
+		return p
+	})
+	return rule, nil
+}
+
+// placeholders quotes the path and replaces the ":name" placeholders by named groups.
+func placeholders(path string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range placeholder.FindAllStringIndex(path, -1) {
+		b.WriteString(regexp.QuoteMeta(path[last:m[0]]))
+		b.WriteString("(?P<" + path[m[0]+1:m[1]] + ">[^/]+)")
+		last = m[1]
+	}
+	b.WriteString(regexp.QuoteMeta(path[last:]))
+	return b.String()
+}
+
+// pattern is the normalized pattern detecting the duplicated rules.
+func (rule *Rule) pattern() string {
+	pattern := rule.Prefix
+	if rule.Regexp != nil {
+		pattern = "~" + rule.Regexp.String()
+	}
+	if rule.Host != "" {
+		pattern = "//" + rule.Host + pattern
+	}
+	return pattern
+}
+
+func cutScheme(pattern string) (string, bool) {
+	if rest, ok := strings.CutPrefix(pattern, "https://"); ok {
+		return rest, true
+	}
+	return strings.CutPrefix(pattern, "http://")
+}
+
+// Match returns the first rule (without Host) matching the path and the resulting target.
+func (rules Rules) Match(path string) (*Rule, string) {
+	return rules.MatchHost("", path)
+}
+
+// MatchHost returns the first rule matching the host (without port) and the path,
+// and the resulting target.
+func (rules Rules) MatchHost(host, path string) (*Rule, string) {
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Host != "" && !strings.EqualFold(rule.Host, host) {
+			continue
+		}
+		if rule.Regexp != nil {
+			m := rule.Regexp.FindStringSubmatchIndex(path)
+			if m != nil {
+				return rule, string(rule.Regexp.ExpandString(nil, rule.Target, path, m))
+			}
+		} else if rest, ok := strings.CutPrefix(path, rule.Prefix); ok {
+			return rule, rule.Target + rest
+		}
+	}
+	return nil, ""
+}
+
+// MiddlewareRules loads the rules file and returns the middleware applying them.
+// MiddlewareRules panics when the file is invalid,
+// use LoadRules (or "garcon check-rules") to validate the file beforehand.
+func MiddlewareRules(file string) gg.Middleware {
+	rules, err := LoadRules(file)
+	if err != nil {
+		log.Panic("MiddlewareRules", err)
+	}
+	log.Infof("MiddlewareRules %s: %d rules", file, len(rules))
+	return rules.Middleware
+}
+
+// Middleware redirects or rewrites the request path depending on the first matching rule.
+// Use it before the router (outermost in the chain) to migrate the legacy URLs.
+// A rewrite is applied once: the rewritten path is not matched again.
+func (rules Rules) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		rule, target := rules.MatchHost(host, r.URL.Path)
+		switch {
+		case rule == nil:
+			next.ServeHTTP(w, r)
+		case rule.Status != 0:
+			if r.URL.RawQuery != "" {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, rule.Status)
+		default:
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = target
+			r2.URL.RawPath = ""
+			next.ServeHTTP(w, r2)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+const rulesFile = `
+# legacy URLs
+redirect 301 /old-blog/          /blog/
+redirect 302 ~^/p/([0-9]+)$      /posts/$1
+rewrite      /docs/              /documentation/
+rewrite      ~^/u/([a-z]+)$      /users/$1
+`
+
+func TestRules_Middleware(t *testing.T) {
+	t.Parallel()
+
+	rules, err := gc.ParseRules(strings.NewReader(rulesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	})
+	h := rules.Middleware(echo)
+
+	cases := []struct {
+		url        string
+		wantStatus int
+		want       string // Location or rewritten path
+	}{
+		{"/old-blog/2020/hello?lang=fr", http.StatusMovedPermanently, "/blog/2020/hello?lang=fr"},
+		{"/p/42", http.StatusFound, "/posts/42"},
+		{"/p/abc", http.StatusOK, "/p/abc"},
+		{"/docs/install", http.StatusOK, "/documentation/install"},
+		{"/u/bob", http.StatusOK, "/users/bob"},
+		{"/other", http.StatusOK, "/other"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.url, http.NoBody))
+
+			if w.Code != c.wantStatus {
+				t.Errorf("status=%d want %d", w.Code, c.wantStatus)
+			}
+			got := w.Body.String()
+			if c.wantStatus != http.StatusOK {
+				got = w.Header().Get("Location")
+			}
+			if got != c.want {
+				t.Errorf("got %q want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseRules_Invalid(t *testing.T) {
+	t.Parallel()
+
+	const invalid = `
+redirect 303 /a /b
+rewrite ~^/(x $1
+rewrite /c https://example.com/
+redirect 301 /d
+rewrite /e /f
+rewrite /e /g
+`
+	_, err := gc.ParseRules(strings.NewReader(invalid))
+	if !errors.Is(err, gc.ErrRules) {
+		t.Fatalf("want ErrRules, got %v", err)
+	}
+	for _, line := range []string{"line 2:", "line 3:", "line 4:", "line 5:", "line 7:"} {
+		if !strings.Contains(err.Error(), line) {
+			t.Errorf("missing error for %s in %v", line, err)
+		}
+	}
+	if strings.Contains(err.Error(), "line 6:") {
+		t.Errorf("line 6 is valid: %v", err)
+	}
+}
+
+const redirectsFile = `
+# Netlify _redirects
+/old-blog/*                /blog/:splat
+/news/:year/:slug          /blog/:year/:slug     302
+/about                     /team                 308!
+/app/*                     /index.html           200
+https://old.example.com/*  https://example.com/:splat
+`
+
+func TestParseRedirects(t *testing.T) {
+	t.Parallel()
+
+	rules, err := gc.ParseRedirects(strings.NewReader(redirectsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = rules.Add(0, "https://docs.example.com/", "/docs/"); err != nil {
+		t.Fatal(err)
+	}
+
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.URL.Path))
+	})
+	h := rules.Middleware(echo)
+
+	cases := []struct {
+		url        string
+		wantStatus int
+		want       string // Location or rewritten path
+	}{
+		{"/old-blog/2020/hello", http.StatusMovedPermanently, "/blog/2020/hello"},
+		{"/news/2024/launch?ref=x", http.StatusFound, "/blog/2024/launch?ref=x"},
+		{"/news/2024", http.StatusOK, "/news/2024"},
+		{"/about", http.StatusPermanentRedirect, "/team"},
+		{"/about/", http.StatusPermanentRedirect, "/team"},
+		{"/about/more", http.StatusOK, "/about/more"},
+		{"/app/settings", http.StatusOK, "/index.html"},
+		{"http://old.example.com/a/b", http.StatusMovedPermanently, "https://example.com/a/b"},
+		{"http://docs.example.com:8080/install", http.StatusOK, "/docs/install"},
+		{"http://www.example.com/install", http.StatusOK, "/install"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.url, func(t *testing.T) {
+			t.Parallel()
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.url, http.NoBody))
+
+			if w.Code != c.wantStatus {
+				t.Errorf("status=%d want %d", w.Code, c.wantStatus)
+			}
+			got := w.Body.String()
+			if c.wantStatus != http.StatusOK {
+				got = w.Header().Get("Location")
+			}
+			if got != c.want {
+				t.Errorf("got %q want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestParseRedirects_Invalid(t *testing.T) {
+	t.Parallel()
+
+	const invalid = `
+/a /b 404
+/c https://example.com/ 200
+/d /e 301 Country=fr
+/f /g
+/f /h
+`
+	_, err := gc.ParseRedirects(strings.NewReader(invalid))
+	if !errors.Is(err, gc.ErrRules) {
+		t.Fatalf("want ErrRules, got %v", err)
+	}
+	for _, line := range []string{"line 2:", "line 3:", "line 4:", "line 6:"} {
+		if !strings.Contains(err.Error(), line) {
+			t.Errorf("missing error for %s in %v", line, err)
+		}
+	}
+}
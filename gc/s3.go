@@ -0,0 +1,522 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// S3Config is the configuration of the S3Storage,
+	// see S3StorageFromEnv for the environment variables.
+	S3Config struct {
+		// Endpoint is the base URL of the service, e.g. "https://s3.eu-west-3.amazonaws.com"
+		// or "http://minio:9000". The bucket is addressed in the path (path-style).
+		Endpoint  string `env:"ENDPOINT,default=https://s3.amazonaws.com"`
+		Bucket    string `env:"BUCKET,required"`
+		Region    string `env:"REGION,default=us-east-1"`
+		AccessKey string `env:"ACCESS_KEY"` // empty means anonymous requests (public bucket)
+		SecretKey string `env:"SECRET_KEY,secret"`
+		// UploadPrefix is the key prefix of the files saved as an UploadStore.
+		UploadPrefix string `env:"UPLOAD_PREFIX,default=uploads/"`
+		// CacheDir (optional) keeps a local copy of the hot objects.
+		CacheDir string `env:"CACHE_DIR"`
+		// CacheTTL is the period during which the metadata is not revalidated.
+		CacheTTL time.Duration `env:"CACHE_TTL,default=10s"`
+		// CacheMaxBytes bounds the size of CacheDir: the least recently used objects are removed.
+		CacheMaxBytes int64 `env:"CACHE_MAX_BYTES,default=268435456"`
+		// CacheMaxObject is the size of the largest object copied in CacheDir.
+		CacheMaxObject int64 `env:"CACHE_MAX_OBJECT,default=8388608"`
+	}
+
+	// S3Storage reads the objects of an S3-compatible bucket (AWS, MinIO, Garage, R2...)
+	// for the StaticWebServer (see WithStorage) and saves the files of the UploadHandler.
+	// The requests are signed with AWS Signature Version 4.
+	//
+	// The metadata (including the missing objects) is cached in memory during CacheTTL.
+	// When CacheDir is set, the objects are also copied on the local disk while they are served,
+	// and revalidated with a conditional GET (If-None-Match) after CacheTTL.
+	// Call Flush after a deployment to serve the new objects immediately.
+	S3Storage struct {
+		Client  *http.Client // default http.DefaultClient
+		objects map[string]*s3Object
+		cfg     S3Config
+		cached  int64 // bytes in CacheDir
+		mu      sync.Mutex
+	}
+
+	// s3Object is the cached metadata of an object.
+	s3Object struct {
+		checked time.Time
+		lastUse time.Time
+		err     error  // fs.ErrNotExist when missing
+		file    string // copy in CacheDir, empty when not cached
+		info    ObjectInfo
+	}
+
+	// s3CacheReader copies the object in a temporary file of CacheDir while it is read.
+	s3CacheReader struct {
+		rc   io.ReadCloser
+		tmp  *os.File
+		s    *S3Storage
+		key  string
+		info ObjectInfo
+		n    int64
+	}
+
+	// sizedReader provides the Content-Length of the PUT request.
+	sizedReader struct {
+		io.Reader
+		size int64
+	}
+)
+
+const (
+	s3CacheExt       = ".s3obj"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	amzDateFormat    = "20060102T150405Z"
+	defaultS3Entries = 10000
+)
+
+// NewS3Storage creates an S3Storage and empties the cached objects of a previous run.
+func NewS3Storage(cfg S3Config) *S3Storage {
+	if cfg.Bucket == "" {
+		log.Panic("S3Storage requires a Bucket")
+	}
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" {
+		log.Panic("S3Storage: invalid Endpoint", cfg.Endpoint, err)
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	s := &S3Storage{objects: map[string]*s3Object{}, cfg: cfg}
+	if cfg.CacheDir != "" {
+		err = os.MkdirAll(cfg.CacheDir, 0o750)
+		if err != nil {
+			log.Panic("S3Storage: cache directory", err)
+		}
+		s.removeCachedFiles()
+	}
+	log.Infof("S3Storage %s/%s cache=%q", cfg.Endpoint, cfg.Bucket, cfg.CacheDir)
+	return s
+}
+
+// S3StorageFromEnv creates an S3Storage from the environment variables
+// S3_ENDPOINT, S3_BUCKET (required), S3_REGION, S3_ACCESS_KEY, S3_SECRET_KEY (or S3_SECRET_KEY_FILE),
+// S3_UPLOAD_PREFIX, S3_CACHE_DIR, S3_CACHE_TTL, S3_CACHE_MAX_BYTES and S3_CACHE_MAX_OBJECT
+// (see S3Config).
+func S3StorageFromEnv() (*S3Storage, error) {
+	cfg, err := gg.LoadEnv[S3Config]("S3_")
+	if err != nil {
+		return nil, err
+	}
+	return NewS3Storage(cfg), nil
+}
+
+// Stat returns the metadata of the object, from the cache when still fresh.
+func (s *S3Storage) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if o, ok := s.fresh(key); ok {
+		return o.info, o.err
+	}
+
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+	info, err := s3Result(resp, key)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		s.update(key, info, err)
+	}
+	return info, err
+}
+
+// Open returns the object from CacheDir when still fresh, else from the bucket.
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	o, ok := s.fresh(key)
+	if ok && o.err != nil {
+		return nil, o.info, o.err
+	}
+	if ok && o.file != "" {
+		f, err := os.Open(o.file)
+		if err == nil {
+			return f, o.info, nil
+		}
+	}
+
+	var header http.Header
+	if o.file != "" {
+		header = http.Header{"If-None-Match": {o.info.ETag}}
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, header, nil)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		s.update(key, o.info, nil)
+		f, err := os.Open(o.file)
+		if err != nil {
+			return nil, o.info, err
+		}
+		return f, o.info, nil
+	}
+
+	info, err := s3Result(resp, key)
+	if err != nil {
+		resp.Body.Close()
+		if errors.Is(err, fs.ErrNotExist) {
+			s.update(key, info, err)
+		}
+		return nil, info, err
+	}
+	s.update(key, info, nil)
+
+	if s.cfg.CacheDir == "" || info.Size < 0 || info.Size > s.cfg.CacheMaxObject {
+		return resp.Body, info, nil
+	}
+	tmp, err := os.CreateTemp(s.cfg.CacheDir, ".tmp-*")
+	if err != nil {
+		log.Warn("S3Storage: cache", err)
+		return resp.Body, info, nil
+	}
+	return &s3CacheReader{rc: resp.Body, tmp: tmp, s: s, key: key, info: info}, info, nil
+}
+
+// Save uploads the file as UploadPrefix+name, implementing the UploadStore.
+// The content is first written in a temporary file because S3 requires the Content-Length.
+func (s *S3Storage) Save(ctx context.Context, name, contentType string, r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp("", "s3-upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	key := s.cfg.UploadPrefix + name
+	header := http.Header{"Content-Type": {contentType}}
+	resp, err := s.do(ctx, http.MethodPut, key, header, &sizedReader{tmp, size})
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	_, err = s3Result(resp, key)
+	if err != nil {
+		return "", err
+	}
+	s.Invalidate(key)
+	return key, nil
+}
+
+// Delete removes the object, implementing the UploadStore.
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	s.Invalidate(key)
+	_, err = s3Result(resp, key)
+	return err
+}
+
+// Invalidate removes the cached metadata and the cached copy of the object.
+func (s *S3Storage) Invalidate(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o := s.objects[key]; o != nil {
+		s.removeFile(o)
+		delete(s.objects, key)
+	}
+}
+
+// Flush removes all the cached metadata and objects.
+func (s *S3Storage) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.objects {
+		s.removeFile(o)
+	}
+	clear(s.objects)
+}
+
+// fresh returns a copy of the cached metadata, ok is false when missing or expired.
+func (s *S3Storage) fresh(key string) (o s3Object, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.objects[key]
+	if p == nil {
+		return o, false
+	}
+	p.lastUse = time.Now()
+	return *p, time.Since(p.checked) < s.cfg.CacheTTL
+}
+
+// update stores the revalidated metadata. The cached copy is removed when the object has changed.
+func (s *S3Storage) update(key string, info ObjectInfo, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	o := s.objects[key]
+	if o == nil {
+		if len(s.objects) >= defaultS3Entries {
+			s.purge(now)
+		}
+		o = &s3Object{}
+		s.objects[key] = o
+	} else if err != nil || o.info.ETag != info.ETag {
+		s.removeFile(o)
+	}
+	o.info, o.err = info, err
+	o.checked, o.lastUse = now, now
+}
+
+// purge removes the expired metadata of the objects not cached in CacheDir.
+// The caller must lock s.mu.
+func (s *S3Storage) purge(now time.Time) {
+	for k, o := range s.objects {
+		if o.file == "" && now.Sub(o.checked) > s.cfg.CacheTTL {
+			delete(s.objects, k)
+		}
+	}
+}
+
+// removeFile removes the cached copy of the object. The caller must lock s.mu.
+func (s *S3Storage) removeFile(o *s3Object) {
+	if o.file == "" {
+		return
+	}
+	err := os.Remove(o.file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Warn("S3Storage: cache", err)
+	}
+	s.cached -= o.info.Size
+	o.file = ""
+}
+
+// addFile moves the complete temporary file into the cache and evicts the least recently used objects.
+func (s *S3Storage) addFile(key string, info ObjectInfo, tmp string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := s.objects[key]
+	if o == nil || o.file != "" || o.info.ETag != info.ETag {
+		_ = os.Remove(tmp) // already cached, or changed meanwhile
+		return
+	}
+	sum := sha256.Sum256([]byte(key))
+	file := filepath.Join(s.cfg.CacheDir, hex.EncodeToString(sum[:])+s3CacheExt)
+	err := os.Rename(tmp, file)
+	if err != nil {
+		log.Warn("S3Storage: cache", err)
+		_ = os.Remove(tmp)
+		return
+	}
+	o.file = file
+	s.cached += info.Size
+
+	if s.cached <= s.cfg.CacheMaxBytes {
+		return
+	}
+	var lru []*s3Object
+	for _, c := range s.objects {
+		if c.file != "" {
+			lru = append(lru, c)
+		}
+	}
+	slices.SortFunc(lru, func(a, b *s3Object) int { return a.lastUse.Compare(b.lastUse) })
+	for _, c := range lru {
+		if s.cached <= s.cfg.CacheMaxBytes {
+			break
+		}
+		s.removeFile(c)
+	}
+}
+
+// removeCachedFiles empties CacheDir from the objects of a previous run.
+func (s *S3Storage) removeCachedFiles() {
+	entries, err := os.ReadDir(s.cfg.CacheDir)
+	if err != nil {
+		log.Warn("S3Storage: cache", err)
+		return
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), s3CacheExt) || strings.HasPrefix(e.Name(), ".tmp-") {
+			_ = os.Remove(filepath.Join(s.cfg.CacheDir, e.Name()))
+		}
+	}
+}
+
+func (c *s3CacheReader) Read(p []byte) (int, error) {
+	n, err := c.rc.Read(p)
+	if n > 0 && c.tmp != nil {
+		_, e := c.tmp.Write(p[:n])
+		if e != nil {
+			log.Warn("S3Storage: cache", e)
+			c.abandon()
+		}
+	}
+	c.n += int64(n)
+	return n, err
+}
+
+// Close keeps the copy only when the whole object has been read.
+func (c *s3CacheReader) Close() error {
+	err := c.rc.Close()
+	if c.tmp == nil {
+		return err
+	}
+	if c.n != c.info.Size {
+		c.abandon()
+		return err
+	}
+	e := c.tmp.Close()
+	if e != nil {
+		log.Warn("S3Storage: cache", e)
+		_ = os.Remove(c.tmp.Name())
+		return err
+	}
+	c.s.addFile(c.key, c.info, c.tmp.Name())
+	return err
+}
+
+func (c *s3CacheReader) abandon() {
+	c.tmp.Close()
+	_ = os.Remove(c.tmp.Name())
+	c.tmp = nil
+}
+
+// do sends the signed request. body is nil or a sizedReader.
+func (s *S3Storage) do(ctx context.Context, method, key string, header http.Header, body *sizedReader) (*http.Response, error) {
+	u, err := url.Parse(s.cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	base := u.EscapedPath()
+	u.Path += "/" + s.cfg.Bucket + "/" + key
+	u.RawPath = base + "/" + s3Escape(s.cfg.Bucket) + "/" + s3Escape(key)
+
+	var r io.Reader
+	if body != nil {
+		r = body.Reader
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = body.size
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// s3Result converts the response status into an error, and the headers into ObjectInfo.
+func s3Result(resp *http.Response, key string) (ObjectInfo, error) {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ObjectInfo{}, fmt.Errorf("s3 %s: %w", key, fs.ErrNotExist)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return ObjectInfo{}, fmt.Errorf("s3 %s %s: %s", resp.Request.Method, key, resp.Status)
+	}
+	info := ObjectInfo{ETag: resp.Header.Get("ETag"), Size: resp.ContentLength}
+	info.ModTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// sign adds the AWS Signature Version 4 headers, the payload is not signed.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	if s.cfg.AccessKey == "" {
+		return // anonymous
+	}
+
+	amzDate := now.UTC().Format(amzDateFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonical := req.Method + "\n" +
+		req.URL.EscapedPath() + "\n" +
+		"\n" + // no query string
+		"host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + unsignedPayload + "\n" +
+		"x-amz-date:" + amzDate + "\n\n" +
+		strings.Join(signed, ";") + "\n" +
+		unsignedPayload
+
+	scope := amzDate[:8] + "/" + s.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.cfg.SecretKey)
+	for _, part := range []string{amzDate[:8], s.cfg.Region, "s3", "aws4_request", toSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKey+"/"+scope+
+		", SignedHeaders="+strings.Join(signed, ";")+", Signature="+hex.EncodeToString(key))
+}
+
+// s3Escape encodes all the bytes except the unreserved characters and the slash.
+func s3Escape(s string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := range len(s) {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hexDigits[c>>4])
+		b.WriteByte(hexDigits[c&15])
+	}
+	return b.String()
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // ETag of the fake bucket
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// fakeBucket is a minimal S3 server (path-style) storing the objects in memory.
+type fakeBucket struct {
+	objects map[string][]byte
+	gets    atomic.Int64
+	mu      sync.Mutex
+}
+
+func (b *fakeBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		b.objects[key] = data
+		return
+	case http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	data, ok := b.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	sum := md5.Sum(data) //nolint:gosec // ETag
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat))
+	if r.Method == http.MethodGet {
+		b.gets.Add(1)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method == http.MethodGet {
+		w.Write(data)
+	}
+}
+
+func newS3(t *testing.T, cacheDir string) (*gc.S3Storage, *fakeBucket) {
+	t.Helper()
+	bucket := &fakeBucket{objects: map[string][]byte{
+		"www/index.html":    []byte("<h1>home</h1>"),
+		"www/app.css":       []byte("body{}"),
+		"www/app.css.br":    []byte("brotli"),
+		"www/img/logo.png":  []byte("png"),
+		"www/img/logo.avif": []byte("avif"),
+		"www/404.html":      []byte("missing"),
+	}}
+	srv := httptest.NewServer(bucket)
+	t.Cleanup(srv.Close)
+
+	s3 := gc.NewS3Storage(gc.S3Config{
+		Endpoint:       srv.URL,
+		Bucket:         "bucket",
+		AccessKey:      "AK",
+		SecretKey:      "SK",
+		CacheDir:       cacheDir,
+		CacheTTL:       time.Minute,
+		CacheMaxBytes:  1 << 20,
+		CacheMaxObject: 1 << 10,
+	})
+	return s3, bucket
+}
+
+func TestStaticWebServer_Storage(t *testing.T) {
+	t.Parallel()
+
+	s3, _ := newS3(t, "")
+	ws := gc.NewStaticWebServer(gg.Writer(""), "www").WithStorage(s3).WithErrorPages("404.html", "")
+
+	get := func(handler http.HandlerFunc, target string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, http.NoBody)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+
+	site := ws.ServeSite()
+	w := get(site, "/")
+	if w.Code != http.StatusOK || w.Body.String() != "<h1>home</h1>" || w.Header().Get("ETag") == "" {
+		t.Fatalf("index: %d %q %v", w.Code, w.Body, w.Header())
+	}
+
+	w2 := get(site, "/", "If-None-Match", w.Header().Get("ETag"))
+	if w2.Code != http.StatusNotModified || w2.Body.Len() != 0 {
+		t.Errorf("want 304, got %d %q", w2.Code, w2.Body)
+	}
+
+	w = get(site, "/app.css", "Accept-Encoding", "gzip, br")
+	if w.Body.String() != "brotli" || w.Header().Get("Content-Encoding") != "br" {
+		t.Errorf("want the Brotli sibling, got %q %v", w.Body, w.Header())
+	}
+
+	w = get(ws.ServeImages(), "/img/logo.png", "Accept", "image/avif,image/webp")
+	if w.Body.String() != "avif" || w.Header().Get("Content-Type") != "image/avif" {
+		t.Errorf("want the AVIF sibling, got %q %v", w.Body, w.Header())
+	}
+
+	w = get(site, "/nope.html")
+	if w.Code != http.StatusNotFound || w.Body.String() != "missing" {
+		t.Errorf("want the 404 page, got %d %q", w.Code, w.Body)
+	}
+}
+
+func TestS3Storage_Cache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s3, bucket := newS3(t, dir)
+	ctx := context.Background()
+
+	for range 3 {
+		rc, info, err := s3.Open(ctx, "www/app.css")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		if string(data) != "body{}" || info.Size != 6 {
+			t.Fatalf("unexpected %q %+v", data, info)
+		}
+	}
+	if n := bucket.gets.Load(); n != 1 {
+		t.Errorf("want 1 GET (then served from the cache), got %d", n)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("want 1 cached object, got %d", len(entries))
+	}
+
+	s3.Flush()
+	entries, _ = os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Flush should empty the cache, got %d files", len(entries))
+	}
+}
+
+func TestS3Storage_UploadStore(t *testing.T) {
+	t.Parallel()
+
+	s3, bucket := newS3(t, "")
+	ctx := context.Background()
+
+	var store gc.UploadStore = s3
+	key, err := store.Save(ctx, "abc.png", "image/png", strings.NewReader("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != "abc.png" || string(bucket.objects[key]) != "data" {
+		t.Errorf("unexpected key %q objects=%v", key, bucket.objects)
+	}
+
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s3.Stat(ctx, key); err == nil {
+		t.Error("object should be deleted")
+	}
+}
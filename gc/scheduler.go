@@ -0,0 +1,334 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+type (
+	// Scheduler runs background jobs periodically (interval or cron expression)
+	// from Garcon.Run until the shutdown:
+	//
+	//	s := g.NewScheduler() // started and stopped by g.Run, metrics on /metrics
+	//	err := s.Add(gc.Job{Name: "jwks", Every: time.Hour, Jitter: time.Minute, Run: rotateJWKS})
+	//	err = s.Add(gc.Job{Name: "sitemap", Cron: "30 3 * * *", Timeout: 5 * time.Minute, Run: regenerate})
+	//
+	// A run is skipped when the previous one is still running (no overlap).
+	// A panic is recovered as a gerr.ServerErr and counted as a failure.
+	Scheduler struct {
+		metrics atomic.Pointer[schedulerMetrics]
+		ctx     context.Context //nolint:containedctx // canceled by Stop
+		cancel  context.CancelFunc
+		jobs    []*job
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+	}
+
+	// Job is a background task of the Scheduler. Set either Every or Cron.
+	Job struct {
+		// Run is the task, ctx is canceled after Timeout or when the Scheduler stops.
+		Run func(ctx context.Context) error
+		// Name identifies the job in the logs, the metrics and the stats.
+		Name string
+		// Cron is a cron expression (see CronSpec), e.g. "*/15 * * * *" or "@daily".
+		Cron string
+		// Every is the interval between two runs.
+		Every time.Duration
+		// Jitter (optional) delays each run by a random duration up to Jitter
+		// to spread the load of several instances.
+		Jitter time.Duration
+		// Timeout (optional) bounds the duration of each run.
+		Timeout time.Duration
+		// Immediate also runs the job when the Scheduler starts.
+		Immediate bool
+	}
+
+	// JobStats are the counters and the last run of a Job.
+	JobStats struct {
+		LastRun      time.Time `json:"last_run,omitzero"`
+		Next         time.Time `json:"next,omitzero"`
+		Name         string    `json:"name"`
+		LastDuration string    `json:"last_duration,omitempty"`
+		LastError    string    `json:"last_error,omitempty"`
+		Runs         uint64    `json:"runs"`
+		Failures     uint64    `json:"failures"`
+		Skipped      uint64    `json:"skipped"`
+		Running      bool      `json:"running"`
+	}
+
+	job struct {
+		last     JobStats // protected by Scheduler.mu
+		cron     CronSpec
+		def      Job
+		runs     atomic.Uint64
+		failures atomic.Uint64
+		skipped  atomic.Uint64
+		running  atomic.Bool
+	}
+
+	schedulerMetrics struct {
+		runs     *prometheus.CounterVec
+		duration *prometheus.HistogramVec
+		skipped  *prometheus.CounterVec
+	}
+)
+
+// NewScheduler creates a Scheduler started and stopped by Garcon.Run,
+// and publishes its metrics on /metrics (see ServerName.ExportScheduler).
+func (g *Garcon) NewScheduler() *Scheduler {
+	s := NewScheduler()
+	g.ServerName.ExportScheduler(s)
+	g.AddHook(s.Hook())
+	return s
+}
+
+// NewScheduler creates a Scheduler, see Start or Hook.
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Add validates the job and schedules it (immediately when the Scheduler is running).
+func (s *Scheduler) Add(j Job) error {
+	if j.Run == nil || j.Name == "" {
+		return errors.New("scheduler: a job requires a Name and a Run function")
+	}
+	if (j.Every > 0) == (j.Cron != "") {
+		return errors.New("scheduler: job " + j.Name + " requires either Every or Cron")
+	}
+
+	jj := &job{def: j, last: JobStats{Name: j.Name}}
+	if j.Cron != "" {
+		var err error
+		jj.cron, err = ParseCron(j.Cron)
+		if err != nil {
+			return errors.New("scheduler: job " + j.Name + ": " + err.Error())
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.jobs {
+		if other.def.Name == j.Name {
+			return errors.New("scheduler: duplicated job " + j.Name)
+		}
+	}
+	s.jobs = append(s.jobs, jj)
+	if s.ctx != nil {
+		s.wg.Go(func() { s.loop(s.ctx, jj) })
+	}
+	return nil
+}
+
+// Start launches the jobs in background.
+func (s *Scheduler) Start(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx != nil {
+		return errors.New("scheduler already started")
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	for _, j := range s.jobs {
+		s.wg.Go(func() { s.loop(s.ctx, j) })
+	}
+	log.Info("Scheduler started", len(s.jobs), "jobs")
+	return nil
+}
+
+// Stop cancels the running jobs and waits for them until ctx is done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel := s.cancel
+	s.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return errors.New("scheduler: jobs still running: " + context.Cause(ctx).Error())
+	}
+}
+
+// Hook returns the Hook running the Scheduler from Garcon.Run until the shutdown.
+func (s *Scheduler) Hook() Hook {
+	return Hook{Name: "scheduler", Start: s.Start, Stop: s.Stop}
+}
+
+// loop waits for the next activation of the job until ctx is done.
+func (s *Scheduler) loop(ctx context.Context, j *job) {
+	if j.def.Immediate {
+		s.trigger(ctx, j)
+	}
+
+	for {
+		next := j.next(time.Now())
+		if next.IsZero() {
+			log.Warn("Scheduler: job", j.def.Name, "never runs:", j.def.Cron)
+			return
+		}
+		s.mu.Lock()
+		j.last.Next = next
+		s.mu.Unlock()
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.trigger(ctx, j)
+	}
+}
+
+// next returns the next activation time including the jitter.
+func (j *job) next(now time.Time) time.Time {
+	var next time.Time
+	if j.def.Every > 0 {
+		next = now.Add(j.def.Every)
+	} else {
+		next = j.cron.Next(now)
+		if next.IsZero() {
+			return next
+		}
+	}
+	if j.def.Jitter > 0 {
+		next = next.Add(rand.N(j.def.Jitter))
+	}
+	return next
+}
+
+// trigger runs the job in background unless the previous run is not finished.
+func (s *Scheduler) trigger(ctx context.Context, j *job) {
+	if !j.running.CompareAndSwap(false, true) {
+		j.skipped.Add(1)
+		if m := s.metrics.Load(); m != nil {
+			m.skipped.WithLabelValues(j.def.Name).Inc()
+		}
+		log.Warn("Scheduler: skip job", j.def.Name, "because the previous run is still running")
+		return
+	}
+	s.wg.Go(func() {
+		defer j.running.Store(false)
+		s.run(ctx, j)
+	})
+}
+
+// run executes the job, recovers its panic and records the result.
+func (s *Scheduler) run(ctx context.Context, j *job) {
+	if j.def.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, j.def.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := runJob(ctx, j.def.Run)
+	d := time.Since(start)
+
+	j.runs.Add(1)
+	result := "ok"
+	if err != nil {
+		result = "error"
+		j.failures.Add(1)
+		log.Warnf("Scheduler: job %s failed after %v: %v", j.def.Name, d, err)
+	} else {
+		log.Debugf("Scheduler: job %s done in %v", j.def.Name, d)
+	}
+	if m := s.metrics.Load(); m != nil {
+		m.runs.WithLabelValues(j.def.Name, result).Inc()
+		m.duration.WithLabelValues(j.def.Name).Observe(d.Seconds())
+	}
+
+	s.mu.Lock()
+	j.last.LastRun = start
+	j.last.LastDuration = d.String()
+	j.last.LastError = ""
+	if err != nil {
+		j.last.LastError = err.Error()
+	}
+	s.mu.Unlock()
+}
+
+func runJob(ctx context.Context, fn func(context.Context) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			e := gerr.Recovered(v)
+			log.Error("Scheduler: panic", e.Data.Params["panic"], "in", e.Data.FileLine)
+			log.Debug("stack\n", e.Data.Params["stack"])
+			err = e
+		}
+	}()
+	return fn(ctx)
+}
+
+// Stats returns the counters of the jobs in the order of Add.
+func (s *Scheduler) Stats() []JobStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := make([]JobStats, len(s.jobs))
+	for i, j := range s.jobs {
+		stats[i] = j.last
+		stats[i].Runs = j.runs.Load()
+		stats[i].Failures = j.failures.Load()
+		stats[i].Skipped = j.skipped.Load()
+		stats[i].Running = j.running.Load()
+	}
+	return stats
+}
+
+// LogStats logs the counters of each job.
+func (s *Scheduler) LogStats() {
+	for _, st := range s.Stats() {
+		log.Infof("Scheduler job %s runs=%d failures=%d skipped=%d last=%s next=%s",
+			st.Name, st.Runs, st.Failures, st.Skipped, st.LastDuration, st.Next.Format(time.DateTime))
+	}
+}
+
+// ExportScheduler publishes the job metrics on /metrics:
+// job_runs_total{job,result}, job_duration_seconds{job} and job_skipped_total{job}.
+func (ns ServerName) ExportScheduler(s *Scheduler) {
+	ns = ns.RespectPromNamingRule()
+	s.metrics.Store(&schedulerMetrics{
+		runs: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: string(ns),
+			Subsystem: "job",
+			Name:      "runs_total",
+			Help:      "Number of job runs by result (ok or error)",
+		}, []string{"job", "result"}),
+		duration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: string(ns),
+			Subsystem: "job",
+			Name:      "duration_seconds",
+			Help:      "Duration of the job runs",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"job"}),
+		skipped: promauto.NewCounterVec(prometheus.CounterOpts{
+			Namespace: string(ns),
+			Subsystem: "job",
+			Name:      "skipped_total",
+			Help:      "Number of runs skipped because the previous run was not finished",
+		}, []string{"job"}),
+	})
+}
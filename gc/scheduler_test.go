@@ -0,0 +1,126 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestParseCron(t *testing.T) {
+	t.Parallel()
+
+	from := time.Date(2025, time.January, 31, 10, 17, 30, 0, time.UTC) // Friday
+	cases := []struct {
+		expr string
+		want string
+	}{
+		{"* * * * *", "2025-01-31 10:18"},
+		{"*/15 * * * *", "2025-01-31 10:30"},
+		{"0 3 * * *", "2025-02-01 03:00"},
+		{"@hourly", "2025-01-31 11:00"},
+		{"30 8 * * 1-5", "2025-02-03 08:30"}, // next Monday
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+		{"0 12 1 * 7", "2025-02-01 12:00"}, // day 1 or Sunday
+		{"5/20 9-17/4 * 3 *", "2025-03-01 09:05"},
+	}
+	for _, c := range cases {
+		spec, err := gc.ParseCron(c.expr)
+		if err != nil {
+			t.Errorf("%q: %v", c.expr, err)
+			continue
+		}
+		if got := spec.Next(from).Format("2006-01-02 15:04"); got != c.want {
+			t.Errorf("%q: Next=%s want %s", c.expr, got, c.want)
+		}
+	}
+
+	for _, bad := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := gc.ParseCron(bad); err == nil {
+			t.Errorf("%q must be rejected", bad)
+		}
+	}
+	if spec, _ := gc.ParseCron("0 0 30 2 *"); !spec.Next(from).IsZero() {
+		t.Error("February 30 never happens")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	t.Parallel()
+
+	s := gc.NewScheduler()
+	gc.ServerName("scheduler-test").ExportScheduler(s)
+
+	release := make(chan struct{})
+	jobs := []gc.Job{
+		{Name: "tick", Every: 5 * time.Millisecond, Run: func(context.Context) error { return nil }},
+		{Name: "slow", Every: 5 * time.Millisecond, Immediate: true, Run: func(ctx context.Context) error {
+			select {
+			case <-release:
+			case <-ctx.Done():
+			}
+			return ctx.Err()
+		}},
+		{Name: "panic", Every: time.Hour, Immediate: true, Run: func(context.Context) error { panic("boom") }},
+		{Name: "timeout", Every: time.Hour, Immediate: true, Timeout: time.Millisecond, Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+	}
+	for _, j := range jobs {
+		if err := s.Add(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, bad := range []gc.Job{
+		{Name: "tick", Every: time.Second, Run: jobs[0].Run},
+		{Name: "both", Every: time.Second, Cron: "@daily", Run: jobs[0].Run},
+		{Name: "cron", Cron: "bad", Run: jobs[0].Run},
+	} {
+		if err := s.Add(bad); err == nil {
+			t.Errorf("job %s must be rejected", bad.Name)
+		}
+	}
+
+	err := s.Start(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		st := s.Stats()
+		return st[0].Runs >= 3 && st[1].Skipped >= 2 && st[2].Failures == 1 && st[3].Failures == 1
+	})
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = s.Stop(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	st := s.Stats()
+	if !strings.Contains(st[2].LastError, "boom") || !strings.Contains(st[3].LastError, "deadline") || st[1].Running {
+		t.Errorf("stats=%+v", st)
+	}
+
+	rec := httptest.NewRecorder()
+	gc.New().ExporterHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	for _, want := range []string{
+		`scheduler_test_job_runs_total{job="panic",result="error"} 1`,
+		`scheduler_test_job_skipped_total{job="slow"}`,
+		`scheduler_test_job_duration_seconds_count{job="tick"}`,
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("missing %q in /metrics", want)
+		}
+	}
+}
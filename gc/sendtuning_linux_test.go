@@ -0,0 +1,70 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+// noDelay returns the TCP_NODELAY option of the connection.
+func noDelay(tb testing.TB, c net.Conn) bool {
+	tb.Helper()
+	raw, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var v int
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		v, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	})
+	if err != nil || sockErr != nil {
+		tb.Fatal(err, sockErr)
+	}
+	return v != 0
+}
+
+func TestSendTuning_DisableNoDelay(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), 1<<12)
+	err := os.WriteFile(filepath.Join(dir, "big.bin"), content, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type connKey struct{}
+	ws := gc.NewStaticWebServer("", dir).WithSendTuning(&gc.SendTuning{DisableNoDelay: true})
+	serve := ws.ServeDir("application/octet-stream")
+	afterSend := make(chan bool, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serve(w, r)
+		c, _ := r.Context().Value(connKey{}).(net.Conn)
+		afterSend <- noDelay(t, c)
+	}))
+	ts.Config.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		return gc.ConnContext(context.WithValue(ctx, connKey{}, c), c)
+	}
+	ts.Start()
+	defer ts.Close()
+
+	if body := get(t, ts); !bytes.Equal(body, content) {
+		t.Fatalf("got %d bytes want %d", len(body), len(content))
+	}
+	if !<-afterSend {
+		t.Error("TCP_NODELAY must be set again after the send for the next responses")
+	}
+}
@@ -0,0 +1,12 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package gc
+
+import "net"
+
+// setCork does nothing: TCP_CORK is specific to Linux.
+func setCork(*net.TCPConn, bool) error { return nil }
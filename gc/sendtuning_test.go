@@ -0,0 +1,97 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+// plainWriter hides the io.ReaderFrom of the ResponseWriter, like many third-party middlewares.
+type plainWriter struct{ http.ResponseWriter }
+
+func newSendServer(tb testing.TB, size int, tuning *gc.SendTuning, wrap bool) (*httptest.Server, []byte) {
+	tb.Helper()
+	dir := tb.TempDir()
+	content := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	err := os.WriteFile(filepath.Join(dir, "big.bin"), content, 0o600)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	ws := gc.NewStaticWebServer("", dir).WithSendTuning(tuning)
+	var h http.Handler = http.HandlerFunc(ws.ServeDir("application/octet-stream"))
+	if wrap {
+		next := h
+		h = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { next.ServeHTTP(plainWriter{w}, r) })
+	}
+	ts := httptest.NewUnstartedServer(h)
+	ts.Config.ConnContext = gc.ConnContext
+	ts.Start()
+	tb.Cleanup(ts.Close)
+	return ts, content
+}
+
+func get(tb testing.TB, ts *httptest.Server) []byte {
+	tb.Helper()
+	resp, err := ts.Client().Get(ts.URL + "/big.bin")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return body
+}
+
+func TestStaticWebServer_SendTuning(t *testing.T) {
+	t.Parallel()
+
+	for _, wrap := range []bool{false, true} {
+		tuning := &gc.SendTuning{BufferSize: 64 << 10, Cork: true}
+		ts, content := newSendServer(t, 1<<20, tuning, wrap)
+		if body := get(t, ts); !bytes.Equal(body, content) {
+			t.Fatalf("wrap=%v: got %d bytes want %d", wrap, len(body), len(content))
+		}
+		want := gc.SendStats{Sendfile: 1, Bytes: uint64(len(content))}
+		if wrap {
+			want = gc.SendStats{Copies: 1, Bytes: uint64(len(content))}
+		}
+		if s := tuning.Stats(); s != want {
+			t.Errorf("wrap=%v: stats=%+v want %+v", wrap, s, want)
+		}
+	}
+}
+
+// BenchmarkStaticSend compares sendfile with the buffered copies (see SendTuning).
+func BenchmarkStaticSend(b *testing.B) {
+	cases := []struct {
+		name   string
+		tuning *gc.SendTuning
+		wrap   bool
+	}{
+		{"sendfile", &gc.SendTuning{}, false},
+		{"copy-32KiB", &gc.SendTuning{}, true},
+		{"copy-1MiB", &gc.SendTuning{BufferSize: 1 << 20}, true},
+	}
+	for _, c := range cases {
+		b.Run(c.name, func(b *testing.B) {
+			ts, content := newSendServer(b, 64<<20, c.tuning, c.wrap)
+			b.SetBytes(int64(len(content)))
+			for b.Loop() {
+				get(b, ts)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// Listener declares one of the HTTP servers run by Garcon.Run:
+// each listener has its own address, middleware chain and TLS settings.
+type Listener struct {
+	Handler   http.Handler
+	TLSConfig *tls.Config
+	Name      string // used in logs and errors: "api", "admin", "exporter"...
+	Addr      string // ":8080", "127.0.0.1:9000"...
+	CertFile  string // enables HTTPS with KeyFile (or TLSConfig.Certificates)
+	KeyFile   string
+	Chain     gg.Chain
+}
+
+// defaultShutdownTimeout is the time given to the in-flight requests when stopping.
+const defaultShutdownTimeout = 30 * time.Second
+
+// WithListener adds an HTTP server to run with Garcon.Run.
+//
+//	g := gc.New(
+//		gc.WithListener(gc.Listener{Name: "api", Addr: ":8080", Chain: public, Handler: api}),
+//		gc.WithListener(gc.Listener{Name: "admin", Addr: "127.0.0.1:8081", Chain: internal, Handler: admin}),
+//	)
+//	g.AddListener(gc.Listener{Name: "exporter", Addr: ":9093", Handler: g.ExporterHandler()})
+//	err := g.Run(context.Background())
+func WithListener(l Listener) Option {
+	return func(g *Garcon) {
+		g.AddListener(l)
+	}
+}
+
+// WithShutdownTimeout sets the maximum duration of the graceful shutdown (default 30s).
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(g *Garcon) {
+		g.shutdownTimeout = d
+	}
+}
+
+// AddListener adds an HTTP server to run with Garcon.Run,
+// useful when the handler depends on the Garcon instance.
+func (g *Garcon) AddListener(l Listener) {
+	if l.Name == "" {
+		l.Name = l.Addr
+	}
+	g.listeners = append(g.listeners, l)
+}
+
+// Run starts the hooks (see AddHook) in dependency order, then all the listeners,
+// and blocks until ctx is done, SIGINT or SIGTERM is received, or a server fails.
+// Then all the servers are gracefully shut down together,
+// and finally the hooks are stopped in reverse order.
+// The TLS settings and the certificates of the TLS listeners are logged at startup,
+// the handlers are not called: use LogPosture to also check the security headers.
+// Run returns nil after a normal shutdown (ctx done or signal).
+func (g *Garcon) Run(ctx context.Context) error {
+	if len(g.listeners) == 0 {
+		return errors.New("no listener: use gc.WithListener()")
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hooks, err := startHooks(ctx, g.hooks)
+	if err != nil {
+		return err
+	}
+
+	g.logTLSPosture()
+
+	servers := make([]*http.Server, len(g.listeners))
+	done := make(chan error, len(g.listeners))
+	for i, l := range g.listeners {
+		servers[i] = newListenerServer(l)
+		if g.connTracker != nil {
+			servers[i].ConnState = g.connTracker.ConnState
+		}
+		go func() { done <- serveListener(servers[i], l) }()
+	}
+
+	var errs []error
+	running := len(servers)
+	select {
+	case <-ctx.Done():
+		log.Info("Run: shutting down", len(servers), "servers:", context.Cause(ctx))
+	case err := <-done:
+		running--
+		errs = append(errs, err)
+		log.Warn("Run: shutting down the other servers because", err)
+	}
+
+	timeout := g.shutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+
+	for i, srv := range servers {
+		err := srv.Shutdown(shutdownCtx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("shutdown %s: %w", g.listeners[i].Name, err))
+		}
+	}
+	for ; running > 0; running-- {
+		errs = append(errs, <-done)
+	}
+
+	errs = append(errs, stopHooks(context.WithoutCancel(ctx), hooks))
+
+	return errors.Join(errs...)
+}
+
+func newListenerServer(l Listener) *http.Server {
+	srv := Server(l.Chain.Then(l.Handler), 0)
+	srv.Addr = l.Addr
+	srv.TLSConfig = l.TLSConfig
+	return &srv
+}
+
+// serveListener returns nil when the server is stopped by Shutdown.
+func serveListener(srv *http.Server, l Listener) error {
+	var err error
+	if l.CertFile != "" || l.TLSConfig != nil {
+		log.Printf("Server %s listening on https://localhost%s", l.Name, l.Addr)
+		err = srv.ListenAndServeTLS(l.CertFile, l.KeyFile)
+	} else {
+		log.Printf("Server %s listening on http://localhost%s", l.Name, l.Addr)
+		err = srv.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return fmt.Errorf("server %s %s: %w", l.Name, l.Addr, err)
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func header(name, value string) gg.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(name, value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestGarcon_Run(t *testing.T) {
+	t.Parallel()
+
+	hello := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { io.WriteString(w, "hello") })
+	api, admin := freeAddr(t), freeAddr(t)
+	g := gc.New(
+		gc.WithListener(gc.Listener{Name: "api", Addr: api, Chain: gg.NewChain(header("X-Side", "public")), Handler: hello}),
+		gc.WithListener(gc.Listener{Name: "admin", Addr: admin, Chain: gg.NewChain(header("X-Side", "internal")), Handler: hello}),
+		gc.WithShutdownTimeout(time.Second),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error)
+	go func() { result <- g.Run(ctx) }()
+
+	for addr, want := range map[string]string{api: "public", admin: "internal"} {
+		var resp *http.Response
+		var err error
+		for range 50 { // wait for the server start
+			resp, err = http.Get("http://" + addr + "/")
+			if err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("X-Side"); got != want {
+			t.Errorf("%s: X-Side=%q want %q", addr, got, want)
+		}
+	}
+
+	cancel()
+	err := <-result
+	if err != nil {
+		t.Fatalf("want nil after a normal shutdown, got %v", err)
+	}
+}
+
+func TestGarcon_Run_failure(t *testing.T) {
+	t.Parallel()
+
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	g := gc.New(
+		gc.WithListener(gc.Listener{Name: "api", Addr: freeAddr(t), Handler: http.NotFoundHandler()}),
+		gc.WithListener(gc.Listener{Name: "admin", Addr: busy.Addr().String(), Handler: http.NotFoundHandler()}),
+	)
+
+	err = g.Run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "server admin") {
+		t.Fatalf("want the listen error of admin, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// minSignedURLSecret is the minimum length of the secret of MiddlewareSignedURL.
+const minSignedURLSecret = 16
+
+// MiddlewareSignedURL uses the Writer of Garcon to respond the error.
+func (g *Garcon) MiddlewareSignedURL(secret []byte) gg.Middleware {
+	return MiddlewareSignedURL(g.Writer, secret)
+}
+
+// MiddlewareSignedURL serves only the requests having a valid and unexpired
+// signed URL (see gg.SignURL), e.g. the private assets uploaded by the users:
+//
+//	private := g.NewStaticWebServer("uploads")
+//	r.With(g.MiddlewareSignedURL(secret)).Get("/uploads/*", private.ServeSite())
+//
+//	// in an API handler behind the token checker
+//	link, err := gg.SignURL(secret, "/uploads/"+file, 15*time.Minute)
+//
+// The invalid signatures are rejected with 403 Forbidden, the expired links with 410 Gone.
+func MiddlewareSignedURL(gw gg.Writer, secret []byte) gg.Middleware {
+	if len(secret) < minSignedURLSecret {
+		log.Panic("MiddlewareSignedURL wants a secret of at least", minSignedURLSecret, "bytes, got", len(secret))
+	}
+	log.Info("MiddlewareSignedURL serves the signed URLs only")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := gg.VerifySignedURL(secret, r.URL)
+			switch {
+			case err == nil:
+				// the signed content must not be kept by the shared caches after the expiry
+				w.Header().Set("Cache-Control", "private")
+				next.ServeHTTP(w, r)
+			case errors.Is(err, gg.ErrURLExpired):
+				gw.WriteErr(w, r, http.StatusGone, "Link has expired")
+			default:
+				gw.WriteErr(w, r, http.StatusForbidden, "Invalid signed URL")
+				log.Warn("MiddlewareSignedURL rejects", ipMethodURLSafe(r))
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestMiddlewareSignedURL(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("0123456789abcdef")
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("private")) })
+	h := gc.MiddlewareSignedURL(gg.NewWriter(""), secret)(ok)
+
+	valid, err := gg.SignURL(secret, "/uploads/a.pdf", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := gg.SignURL(secret, "/uploads/a.pdf", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for url, status := range map[string]int{
+		valid:            http.StatusOK,
+		expired:          http.StatusGone,
+		"/uploads/a.pdf": http.StatusForbidden,
+	} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, http.NoBody))
+		if rec.Code != status {
+			t.Errorf("%s: status=%d want %d", url, rec.Code, status)
+		}
+	}
+}
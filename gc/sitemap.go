@@ -0,0 +1,258 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sitemap builds the sitemap.xml of the static pages (*.html files of the StaticWebServer directories)
+// and of the dynamic routes, and serves the robots.txt:
+//
+//	sm := g.NewSitemap()
+//	sm.AddStatic(ws)                         // ws := g.NewStaticWebServer("dist")
+//	sm.Add("/products", time.Time{})         // dynamic route, without lastmod
+//	sm.Disallow = []string{"/admin/", "/api/"}
+//	mux.Handle("GET /sitemap.xml", sm)
+//	mux.Handle("GET /robots.txt", sm.Robots())
+//
+// In dev mode (WithDev), robots.txt blocks all the crawlers.
+// The sitemap is rebuilt at most once per TTL (default 1 hour), the lastmod of a
+// static page is the modification time of its file.
+type Sitemap struct {
+	built  time.Time
+	xml    []byte
+	routes []SitemapURL
+	dirs   []sitemapDir
+	// BaseURL is the scheme, host and optional path prefix of the site (the first URL of WithURLs).
+	BaseURL string
+	// Disallow lists the path prefixes excluded from the sitemap and disallowed in robots.txt.
+	Disallow []string
+	// TTL is the duration the sitemap is cached (default 1 hour).
+	TTL time.Duration
+	mu  sync.Mutex
+	dev bool
+}
+
+// SitemapURL is an entry of the sitemap, the zero fields are omitted.
+type SitemapURL struct {
+	LastMod    time.Time `xml:"-"`
+	Loc        string    `xml:"loc"`
+	LastModStr string    `xml:"lastmod,omitempty"`
+	ChangeFreq string    `xml:"changefreq,omitempty"` // always hourly daily weekly monthly yearly never
+	Priority   float64   `xml:"priority,omitempty"`   // from 0.0 to 1.0
+}
+
+type sitemapDir struct {
+	dir    string
+	prefix string
+	skip   []string // error pages
+}
+
+// maxSitemapURLs is the limit of the sitemap protocol.
+const maxSitemapURLs = 50000
+
+// NewSitemap creates a Sitemap for the first URL of WithURLs,
+// robots.txt disallows everything in dev mode.
+func (g *Garcon) NewSitemap() *Sitemap {
+	return NewSitemap(strings.TrimSuffix(g.urls[0].String(), "/"), g.devMode)
+}
+
+// NewSitemap creates a Sitemap, the baseURL (e.g. "https://example.com/docs") prefixes the paths.
+func NewSitemap(baseURL string, dev bool) *Sitemap {
+	return &Sitemap{BaseURL: strings.TrimSuffix(baseURL, "/"), TTL: time.Hour, dev: dev}
+}
+
+// AddStatic adds the *.html files of the StaticWebServer directory,
+// except its error pages: "index.html" becomes the URL of its directory.
+// The optional prefix is the URL path where the directory is served.
+func (s *Sitemap) AddStatic(ws StaticWebServer, prefix ...string) {
+	d := sitemapDir{dir: ws.Dir, skip: []string{ws.NotFoundPage, ws.ErrorPage}}
+	if len(prefix) > 0 {
+		d.prefix = strings.TrimSuffix(prefix[0], "/")
+	}
+	s.mu.Lock()
+	s.dirs = append(s.dirs, d)
+	s.built = time.Time{}
+	s.mu.Unlock()
+}
+
+// Add adds a dynamic route (path relative to BaseURL), lastMod may be zero.
+func (s *Sitemap) Add(urlPath string, lastMod time.Time) {
+	s.AddURL(SitemapURL{Loc: urlPath, LastMod: lastMod})
+}
+
+// AddURL adds a dynamic route with its change frequency and priority.
+// The Loc is a path relative to BaseURL.
+func (s *Sitemap) AddURL(u SitemapURL) {
+	s.mu.Lock()
+	s.routes = append(s.routes, u)
+	s.built = time.Time{}
+	s.mu.Unlock()
+}
+
+// ServeHTTP responds the sitemap.xml.
+func (s *Sitemap) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	if s.xml == nil || time.Since(s.built) > s.TTL {
+		s.xml = s.build()
+		s.built = time.Now()
+	}
+	doc := s.xml
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Header().Set("Cache-Control", "public,max-age=3600")
+	w.Write(doc)
+}
+
+// URLs returns the entries of the sitemap (static pages and dynamic routes) sorted by Loc.
+func (s *Sitemap) URLs() []SitemapURL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.urls()
+}
+
+func (s *Sitemap) urls() []SitemapURL {
+	var urls []SitemapURL
+	for _, d := range s.dirs {
+		urls = append(urls, d.scan()...)
+	}
+	urls = append(urls, s.routes...)
+
+	seen := make(map[string]bool, len(urls))
+	kept := urls[:0]
+	for _, u := range urls {
+		if !strings.HasPrefix(u.Loc, "/") {
+			u.Loc = "/" + u.Loc
+		}
+		if seen[u.Loc] || s.disallowed(u.Loc) {
+			continue
+		}
+		seen[u.Loc] = true
+		kept = append(kept, u)
+	}
+	slices.SortFunc(kept, func(a, b SitemapURL) int { return strings.Compare(a.Loc, b.Loc) })
+	return kept
+}
+
+func (s *Sitemap) disallowed(urlPath string) bool {
+	for _, prefix := range s.Disallow {
+		if strings.HasPrefix(urlPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// scan lists the HTML pages of the directory.
+func (d sitemapDir) scan() []SitemapURL {
+	var urls []SitemapURL
+	err := filepath.WalkDir(d.dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			if p != d.dir && strings.HasPrefix(e.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if filepath.Ext(p) != ".html" {
+			return nil
+		}
+		rel, err := filepath.Rel(d.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if slices.Contains(d.skip, rel) {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		loc := d.prefix + "/" + rel
+		if path.Base(rel) == "index.html" {
+			loc = strings.TrimSuffix(loc, "index.html")
+		}
+		urls = append(urls, SitemapURL{Loc: loc, LastMod: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		log.Warn("Sitemap scan", d.dir, err)
+	}
+	return urls
+}
+
+func (s *Sitemap) build() []byte {
+	urls := s.urls()
+	if len(urls) > maxSitemapURLs {
+		log.Warnf("Sitemap truncated to %d URLs (got %d)", maxSitemapURLs, len(urls))
+		urls = urls[:maxSitemapURLs]
+	}
+	for i := range urls {
+		urls[i].Loc = s.BaseURL + urls[i].Loc
+		if !urls[i].LastMod.IsZero() {
+			urls[i].LastModStr = urls[i].LastMod.UTC().Format(time.RFC3339)
+		}
+	}
+
+	doc := struct {
+		XMLName xml.Name     `xml:"urlset"`
+		XMLNS   string       `xml:"xmlns,attr"`
+		URLs    []SitemapURL `xml:"url"`
+	}{XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: urls}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	err := enc.Encode(doc)
+	if err != nil {
+		log.Warn("Sitemap encode", err)
+	}
+	buf.WriteByte('\n')
+	log.Infof("Sitemap %d URLs", len(urls))
+	return buf.Bytes()
+}
+
+// Robots returns the handler of robots.txt: in dev mode all the crawlers are blocked,
+// else the Disallow prefixes are listed with the location of the sitemap
+// (served at sitemapPath, default "/sitemap.xml").
+func (s *Sitemap) Robots(sitemapPath ...string) http.Handler {
+	sitemap := "/sitemap.xml"
+	if len(sitemapPath) > 0 {
+		sitemap = sitemapPath[0]
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		var b strings.Builder
+		b.WriteString("User-agent: *\n")
+		if s.dev {
+			b.WriteString("Disallow: /\n")
+		} else {
+			for _, prefix := range s.Disallow {
+				b.WriteString("Disallow: " + prefix + "\n")
+			}
+			if len(s.Disallow) == 0 {
+				b.WriteString("Allow: /\n")
+			}
+			b.WriteString("\nSitemap: " + s.BaseURL + sitemap + "\n")
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(b.String()))
+	})
+}
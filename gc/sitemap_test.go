@@ -0,0 +1,86 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestSitemap(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, f := range []string{"index.html", "404.html", "about.html", "blog/index.html", "admin/index.html", "app.js"} {
+		p := filepath.Join(dir, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mtime := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "about.html"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	sm := gc.NewSitemap("https://example.com/", false)
+	sm.AddStatic(gc.StaticWebServer{Dir: dir, NotFoundPage: "404.html"})
+	sm.Add("/products", time.Time{})
+	sm.Add("/about.html", time.Time{}) // duplicate
+	sm.Disallow = []string{"/admin/"}
+
+	var locs []string
+	for _, u := range sm.URLs() {
+		locs = append(locs, u.Loc)
+	}
+	want := "/ /about.html /blog/ /products"
+	if got := strings.Join(locs, " "); got != want {
+		t.Errorf("URLs = %q want %q", got, want)
+	}
+
+	w := httptest.NewRecorder()
+	sm.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sitemap.xml", http.NoBody))
+	body := w.Body.String()
+	for _, s := range []string{
+		`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`,
+		"<loc>https://example.com/blog/</loc>",
+		"<loc>https://example.com/about.html</loc>\n    <lastmod>2024-05-06T07:08:09Z</lastmod>",
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("sitemap.xml does not contain %q:\n%s", s, body)
+		}
+	}
+	if strings.Contains(body, "admin") || strings.Contains(body, "404") {
+		t.Errorf("sitemap.xml contains excluded pages:\n%s", body)
+	}
+
+	w = httptest.NewRecorder()
+	sm.Robots().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", http.NoBody))
+	want = "User-agent: *\nDisallow: /admin/\n\nSitemap: https://example.com/sitemap.xml\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("robots.txt = %q want %q", got, want)
+	}
+}
+
+func TestSitemap_RobotsDev(t *testing.T) {
+	t.Parallel()
+
+	sm := gc.NewSitemap("http://localhost:8080", true)
+	w := httptest.NewRecorder()
+	sm.Robots().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/robots.txt", http.NoBody))
+	want := "User-agent: *\nDisallow: /\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("robots.txt = %q want %q", got, want)
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// SSEEvent is a Server-Sent Event. The ID is set by SSEBroker.Publish.
+	SSEEvent struct {
+		ID    string
+		Event string // optional event type, "message" by default in the browser
+		Data  string
+	}
+
+	// SSEBroker dispatches the published events to the clients connected to SSEHandler.
+	// Each client has its own buffer: a client too slow to consume its buffer
+	// is disconnected (the other clients are not slowed down),
+	// and the browser reconnects with the Last-Event-ID header
+	// to receive the missed events from the replay window.
+	//
+	//	broker := gc.NewSSEBroker()
+	//	mux.Handle("GET /events", gc.SSEHandler(broker))
+	//	g.OnStop("sse", func(context.Context) error { broker.Close(); return nil })
+	//	...
+	//	broker.PublishJSON("price", Price{Symbol: "BTC", Value: 97000})
+	SSEBroker struct {
+		clients map[chan SSEEvent]struct{}
+		history []SSEEvent // replay window, oldest first
+		// Heartbeat is the period of the comments keeping the idle connections alive (default 15s).
+		Heartbeat time.Duration
+		// BufferSize is the number of events buffered per client (default 32).
+		BufferSize int
+		// ReplaySize is the number of the last events replayed on reconnection (default 100).
+		ReplaySize int
+		// Retry is the reconnection delay sent to the browser (zero means the browser default).
+		Retry     time.Duration
+		lastID    uint64
+		mu        sync.Mutex
+		published atomic.Uint64
+		dropped   atomic.Uint64
+		closed    bool
+	}
+
+	// SSEStats is a snapshot of the SSEBroker metrics.
+	SSEStats struct {
+		Clients   int    `json:"clients"`
+		Published uint64 `json:"published"`
+		Dropped   uint64 `json:"dropped"` // slow clients disconnected
+	}
+)
+
+// NewSSEBroker creates a SSEBroker with the default settings.
+func NewSSEBroker() *SSEBroker {
+	return &SSEBroker{
+		clients:    map[chan SSEEvent]struct{}{},
+		Heartbeat:  15 * time.Second,
+		BufferSize: 32,
+		ReplaySize: 100,
+	}
+}
+
+// Publish sends the event to all the connected clients and returns its ID.
+func (b *SSEBroker) Publish(event, data string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	e := SSEEvent{ID: strconv.FormatUint(b.lastID, 10), Event: event, Data: data}
+	if b.ReplaySize > 0 {
+		if len(b.history) >= b.ReplaySize {
+			b.history = append(b.history[:0], b.history[len(b.history)-b.ReplaySize+1:]...)
+		}
+		b.history = append(b.history, e)
+	}
+
+	for ch := range b.clients {
+		select {
+		case ch <- e:
+		default: // buffer full => disconnect the slow client
+			delete(b.clients, ch)
+			close(ch)
+			b.dropped.Add(1)
+		}
+	}
+	b.published.Add(1)
+	return e.ID
+}
+
+// PublishJSON publishes v encoded in JSON.
+func (b *SSEBroker) PublishJSON(event string, v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return b.Publish(event, string(data)), nil
+}
+
+// Subscribe registers a client and returns its channel (closed when the client is dropped
+// or the broker is closed), the events published after lastEventID (replay window),
+// and the function unregistering the client.
+func (b *SSEBroker) Subscribe(lastEventID string) (<-chan SSEEvent, []SSEEvent, func()) {
+	ch := make(chan SSEEvent, max(b.BufferSize, 1))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, nil, func() {}
+	}
+	b.clients[ch] = struct{}{}
+
+	var replay []SSEEvent
+	if last, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+		for _, e := range b.history {
+			if id, _ := strconv.ParseUint(e.ID, 10, 64); id > last {
+				replay = append(replay, e)
+			}
+		}
+	}
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		if _, ok := b.clients[ch]; ok {
+			delete(b.clients, ch)
+			close(ch)
+		}
+		b.mu.Unlock()
+	}
+	return ch, replay, unsubscribe
+}
+
+// Close disconnects all the clients and rejects the new ones, for the graceful shutdown.
+func (b *SSEBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.clients {
+		close(ch)
+	}
+	clear(b.clients)
+}
+
+// Stats returns the counters since the creation of the SSEBroker.
+func (b *SSEBroker) Stats() SSEStats {
+	b.mu.Lock()
+	n := len(b.clients)
+	b.mu.Unlock()
+	return SSEStats{
+		Clients:   n,
+		Published: b.published.Load(),
+		Dropped:   b.dropped.Load(),
+	}
+}
+
+// LogStats prints the counters in the logs.
+func (b *SSEBroker) LogStats() {
+	s := b.Stats()
+	log.Infof("SSEBroker clients=%d published=%d dropped=%d", s.Clients, s.Published, s.Dropped)
+}
+
+// SSEHandler streams the events of the broker (text/event-stream).
+// Each event is flushed immediately. The server WriteTimeout is postponed
+// before every write (as StreamingHandler does), the heartbeats keep
+// the idle connections alive through the proxies.
+func SSEHandler(b *SSEBroker) http.Handler {
+	log.Info("SSEHandler heartbeat=" + b.Heartbeat.String())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events, replay, unsubscribe := b.Subscribe(r.Header.Get("Last-Event-ID"))
+		defer unsubscribe()
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no") // disable the Nginx buffering
+		w.WriteHeader(http.StatusOK)
+
+		heartbeat := b.Heartbeat
+		if heartbeat <= 0 {
+			heartbeat = 15 * time.Second
+		}
+		sw := &sseWriter{
+			rc:      http.NewResponseController(w),
+			bw:      bufio.NewWriter(w),
+			timeout: 2 * heartbeat,
+		}
+
+		if b.Retry > 0 {
+			sw.bw.WriteString("retry: " + strconv.FormatInt(b.Retry.Milliseconds(), 10) + "\n\n")
+		}
+		for _, e := range replay {
+			sw.write(e)
+		}
+		if sw.flush() != nil {
+			return
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case e, ok := <-events:
+				if !ok {
+					return // dropped or broker closed
+				}
+				sw.write(e)
+			case <-ticker.C:
+				sw.bw.WriteString(": ping\n\n")
+			}
+			if sw.flush() != nil {
+				return
+			}
+		}
+	})
+}
+
+type sseWriter struct {
+	rc      *http.ResponseController
+	bw      *bufio.Writer
+	timeout time.Duration
+}
+
+// write encodes the event, the multi-line data is split into several "data:" fields.
+func (sw *sseWriter) write(e SSEEvent) {
+	if e.ID != "" {
+		sw.bw.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		sw.bw.WriteString("event: " + strings.ReplaceAll(e.Event, "\n", "") + "\n")
+	}
+	for line := range strings.SplitSeq(strings.ReplaceAll(e.Data, "\r\n", "\n"), "\n") {
+		sw.bw.WriteString("data: " + line + "\n")
+	}
+	sw.bw.WriteByte('\n')
+}
+
+func (sw *sseWriter) flush() error {
+	err := sw.rc.SetWriteDeadline(deadline(sw.timeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	err = sw.bw.Flush()
+	if err != nil {
+		return err
+	}
+	err = sw.rc.Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
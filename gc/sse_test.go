@@ -0,0 +1,107 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestSSEHandler(t *testing.T) {
+	t.Parallel()
+
+	broker := gc.NewSSEBroker()
+	broker.Heartbeat = 20 * time.Millisecond
+	server := httptest.NewServer(gc.SSEHandler(broker))
+	defer server.Close()
+
+	broker.Publish("", "one")
+	broker.Publish("tick", "two")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type=%q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return strings.Join(lines, "|")
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+	}
+
+	if got := readEvent(); got != "id: 2|event: tick|data: two" {
+		t.Errorf("replay: got %q", got)
+	}
+
+	waitFor(t, func() bool { return broker.Stats().Clients == 1 })
+	broker.Publish("", "multi\nline")
+	for {
+		got := readEvent()
+		if got == ": ping" {
+			continue // heartbeat
+		}
+		if got != "id: 3|data: multi|data: line" {
+			t.Errorf("live: got %q", got)
+		}
+		break
+	}
+
+	broker.Close()
+	waitFor(t, func() bool { return broker.Stats().Clients == 0 })
+}
+
+func TestSSEBroker_SlowClient(t *testing.T) {
+	t.Parallel()
+
+	broker := gc.NewSSEBroker()
+	broker.BufferSize = 1
+	broker.ReplaySize = 2
+	events, _, unsubscribe := broker.Subscribe("")
+	defer unsubscribe()
+
+	for i := range 3 {
+		broker.Publish("", string(rune('a'+i)))
+	}
+	<-events // buffered "a"
+	if _, ok := <-events; ok {
+		t.Error("want the slow client disconnected")
+	}
+	if s := broker.Stats(); s.Dropped != 1 || s.Clients != 0 || s.Published != 3 {
+		t.Errorf("stats=%+v", s)
+	}
+
+	_, replay, cancel := broker.Subscribe("0")
+	defer cancel()
+	if len(replay) != 2 || replay[0].Data != "b" || replay[1].ID != "3" {
+		t.Errorf("want the 2 last events, got %+v", replay)
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// StartupGate keeps the readiness failing until the registered initialization tasks
+// (migrations, cache warm-up, JWKS fetch...) report done. Unlike WarmUp, the tasks are
+// run by their owner (another package, a hook...) which only reports the completion:
+//
+//	gate := gc.NewStartupGate()
+//	jwks := gate.Register("jwks", 30*time.Second)
+//	gate.Go(ctx, "migrations", 5*time.Minute, migrate)
+//	chain, connState := g.StartExporter(9093, gc.WithStartupGate(gate))
+//	...
+//	jwks.Done(fetchJWKS(ctx)) // from anywhere
+//
+// A task not done before its timeout is reported as "timeout": the readiness keeps failing
+// and the liveness fails too, so the orchestrator restarts the stuck instance.
+// A failed task (Done with an error) only keeps the readiness failing:
+// restarting the instance would most likely fail the same way, in a loop.
+type StartupGate struct {
+	started time.Time
+	tasks   []*StartupTask
+	mu      sync.Mutex
+}
+
+// StartupTask is an initialization task registered in a StartupGate.
+// The state is shared with the WarmUp tasks, plus the state "timeout".
+type StartupTask struct {
+	gate    *StartupGate
+	timer   *time.Timer
+	timeout time.Duration
+	taskState
+}
+
+// StartupStatus is the JSON health report of the StartupGate.
+type StartupStatus struct {
+	Tasks   []StartupTaskStatus `json:"tasks"`
+	Elapsed string              `json:"elapsed"`
+	Ready   bool                `json:"ready"`
+}
+
+// StartupTaskStatus is the state of a task: "pending", "done", "failed" or "timeout".
+type StartupTaskStatus struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Duration string `json:"duration,omitempty"`
+	Timeout  string `json:"timeout,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+const startupTimeout = "timeout"
+
+// NewStartupGate creates an empty StartupGate: without tasks, the instance is ready.
+func NewStartupGate() *StartupGate {
+	return &StartupGate{started: time.Now()}
+}
+
+// Register adds a pending task, the timeout starts now (zero means no timeout).
+// The owner of the task must call Done once.
+func (sg *StartupGate) Register(name string, timeout time.Duration) *StartupTask {
+	t := &StartupTask{gate: sg, timeout: timeout, taskState: taskState{start: time.Now(), name: name, state: warmUpPending}}
+	sg.mu.Lock()
+	sg.tasks = append(sg.tasks, t)
+	sg.mu.Unlock()
+
+	if timeout > 0 {
+		t.timer = time.AfterFunc(timeout, t.expire)
+	}
+	log.Info("StartupGate registers", name, "timeout=", timeout)
+	return t
+}
+
+// Go registers the task and runs fn in a goroutine with a context bounded by the timeout.
+func (sg *StartupGate) Go(ctx context.Context, name string, timeout time.Duration, fn func(context.Context) error) *StartupTask {
+	t := sg.Register(name, timeout)
+	go func() {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		t.Done(fn(ctx))
+	}()
+	return t
+}
+
+// Done reports the completion of the task: a nil error makes the task done,
+// else the task is failed. A late Done (after the timeout) is still recorded.
+// Only the first call is taken into account.
+func (t *StartupTask) Done(err error) {
+	sg := t.gate
+	sg.mu.Lock()
+	if t.finished() {
+		sg.mu.Unlock()
+		return
+	}
+	late := t.state == startupTimeout
+	t.finish(err)
+	sg.mu.Unlock()
+
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+
+	switch {
+	case err != nil:
+		log.Warnf("StartupGate %s failed after %v: %v", t.name, t.duration, err)
+	case late:
+		log.Warnf("StartupGate %s done after %v, beyond its timeout %v", t.name, t.duration, t.timeout)
+	default:
+		log.Infof("StartupGate %s done in %v", t.name, t.duration)
+	}
+}
+
+func (t *StartupTask) expire() {
+	sg := t.gate
+	sg.mu.Lock()
+	expired := t.state == warmUpPending
+	err := errors.New("not done within " + t.timeout.String())
+	if expired {
+		t.state = startupTimeout
+		t.err = err
+	}
+	sg.mu.Unlock()
+
+	if expired {
+		log.Warnf("StartupGate %s: %v", t.name, err)
+	}
+}
+
+// Ready reports whether all the tasks are successfully done.
+func (sg *StartupGate) Ready() bool {
+	return sg.Status().Ready
+}
+
+// Status returns the state of every task.
+func (sg *StartupGate) Status() StartupStatus {
+	sg.mu.Lock()
+	defer sg.mu.Unlock()
+
+	s := StartupStatus{
+		Tasks:   make([]StartupTaskStatus, len(sg.tasks)),
+		Elapsed: time.Since(sg.started).String(),
+		Ready:   true,
+	}
+	for i, t := range sg.tasks {
+		ts := t.status()
+		s.Tasks[i] = StartupTaskStatus{Name: ts.Name, State: ts.State, Duration: ts.Duration, Error: ts.Error}
+		if t.timeout > 0 {
+			s.Tasks[i].Timeout = t.timeout.String()
+		}
+		if t.state != warmUpDone {
+			s.Ready = false
+		}
+	}
+	return s
+}
+
+// Probe is a startup ProbeFunction responding the JSON status until all the tasks are done.
+func (sg *StartupGate) Probe() []byte {
+	s := sg.Status()
+	if s.Ready {
+		return nil
+	}
+	return marshalStartup(s)
+}
+
+// LivenessProbe is a ProbeFunction failing when a task has timed out (the instance is stuck).
+// A failed task does not fail the liveness, see StartupGate.
+func (sg *StartupGate) LivenessProbe() []byte {
+	s := sg.Status()
+	for _, t := range s.Tasks {
+		if t.State == startupTimeout {
+			return marshalStartup(s)
+		}
+	}
+	return nil
+}
+
+func marshalStartup(s StartupStatus) []byte {
+	b, err := json.Marshal(map[string]StartupStatus{"startup": s})
+	if err != nil {
+		return []byte(`{"startup":"in progress"}`)
+	}
+	return b
+}
+
+// WithStartupGate makes the readiness fail until the tasks of the gate are done
+// (see WithStartupProbes) and the liveness fail when a task has timed out.
+func WithStartupGate(sg *StartupGate) ProbeOption {
+	return func(h *exporterHandler) {
+		WithStartupProbes(sg.Probe)(h)
+		WithLivenessProbes(sg.LivenessProbe)(h)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestStartupGate(t *testing.T) {
+	t.Parallel()
+
+	gate := gc.NewStartupGate()
+	jwks := gate.Register("jwks", time.Minute)
+	release := make(chan struct{})
+	gate.Go(context.Background(), "migrations", time.Minute, func(context.Context) error {
+		<-release
+		return nil
+	})
+
+	h := gc.New().ExporterHandler(gc.WithStartupGate(gate))
+	get := func(path string) (int, gc.StartupStatus) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+		var body struct {
+			Startup gc.StartupStatus `json:"startup"`
+		}
+		if rec.Body.Len() > 0 {
+			err := json.Unmarshal(rec.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, body.Startup
+	}
+
+	code, status := get("/readyz")
+	if code != http.StatusServiceUnavailable || len(status.Tasks) != 2 || status.Tasks[0].State != "pending" || status.Tasks[0].Timeout != "1m0s" {
+		t.Fatalf("pending: code=%d status=%+v", code, status)
+	}
+	if code, _ = get("/healthz"); code != http.StatusOK {
+		t.Errorf("liveness must succeed while the tasks are pending: code=%d", code)
+	}
+
+	jwks.Done(nil)
+	close(release)
+	for !gate.Ready() {
+		time.Sleep(time.Millisecond)
+	}
+	for _, path := range []string{"/readyz", "/startupz"} {
+		if code, _ = get(path); code != http.StatusOK {
+			t.Errorf("%s code=%d after the tasks are done", path, code)
+		}
+	}
+}
+
+func TestStartupGate_Timeout(t *testing.T) {
+	t.Parallel()
+
+	gate := gc.NewStartupGate()
+	slow := gate.Register("cache", 10*time.Millisecond)
+	h := gc.New().ExporterHandler(gc.WithStartupGate(gate))
+
+	for gate.Status().Tasks[0].State != "timeout" {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", http.NoBody))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("liveness must fail after the timeout: code=%d", rec.Code)
+	}
+
+	// a late completion makes the instance ready
+	slow.Done(nil)
+	if !gate.Ready() || gate.LivenessProbe() != nil {
+		t.Errorf("status=%+v", gate.Status())
+	}
+
+	failed := gate.Register("migrations", 0)
+	failed.Done(errors.New("schema v3 missing"))
+	// a failed task gates the readiness only: a restart would fail again
+	if s := gate.Status(); s.Ready || s.Tasks[1].State != "failed" || gate.LivenessProbe() != nil {
+		t.Errorf("status=%+v", s)
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/sync/singleflight"
+)
+
+type (
+	// StatCache caches the metadata of the static files (size, modification time, existence)
+	// to avoid the redundant syscalls on the hot paths: the StaticWebServer checks
+	// the *.br and *.avif siblings and stats the served file for every request.
+	// The missing files are cached too, so the absent siblings cost nothing.
+	// Concurrent misses for the same path share a single os.Stat.
+	//
+	// The cached metadata may be stale during TTL after a file is modified:
+	// Watch invalidates the entries on the filesystem events, else call Invalidate
+	// or Flush after a deployment (rebuild, git pull...).
+	//
+	// The size and the modification time of a served file come from the opened file (fstat),
+	// never from the cache. The files are still opened per request: an *os.File has its own
+	// read offset and cannot be shared by concurrent responses.
+	StatCache struct {
+		entries    map[string]statEntry
+		watcher    *fsnotify.Watcher
+		group      singleflight.Group
+		TTL        time.Duration
+		MaxEntries int // when reached, the expired entries are purged, then all the entries
+		mu         sync.RWMutex
+		hits       atomic.Uint64
+		misses     atomic.Uint64
+	}
+
+	// StatCacheStats is a snapshot of the StatCache metrics.
+	StatCacheStats struct {
+		Hits    uint64 `json:"hits"`
+		Misses  uint64 `json:"misses"`
+		Entries int    `json:"entries"`
+	}
+
+	// fileMeta is the cached result of os.Stat.
+	fileMeta struct {
+		modTime time.Time
+		err     error
+		size    int64
+	}
+
+	statEntry struct {
+		expires time.Time
+		meta    fileMeta
+	}
+)
+
+const defaultStatCacheEntries = 10000
+
+// NewStatCache creates a StatCache. A zero ttl defaults to one second.
+func NewStatCache(ttl time.Duration) *StatCache {
+	if ttl <= 0 {
+		ttl = time.Second
+	}
+	return &StatCache{
+		entries:    map[string]statEntry{},
+		TTL:        ttl,
+		MaxEntries: defaultStatCacheEntries,
+	}
+}
+
+// stat returns the metadata of the file, from the cache when still fresh.
+func (sc *StatCache) stat(absPath string) fileMeta {
+	now := time.Now()
+	sc.mu.RLock()
+	entry, ok := sc.entries[absPath]
+	sc.mu.RUnlock()
+	if ok && now.Before(entry.expires) {
+		sc.hits.Add(1)
+		return entry.meta
+	}
+
+	sc.misses.Add(1)
+	v, _, _ := sc.group.Do(absPath, func() (any, error) {
+		var meta fileMeta
+		fi, err := os.Stat(absPath)
+		if err != nil {
+			meta.err = err
+		} else {
+			meta.size = fi.Size()
+			meta.modTime = fi.ModTime()
+		}
+
+		sc.mu.Lock()
+		if len(sc.entries) >= sc.MaxEntries {
+			sc.purge(now)
+		}
+		sc.entries[absPath] = statEntry{meta: meta, expires: time.Now().Add(sc.TTL)}
+		sc.mu.Unlock()
+		return meta, nil
+	})
+	meta, _ := v.(fileMeta)
+	return meta
+}
+
+// purge removes the expired entries, or all the entries when none is expired.
+// The caller must lock sc.mu.
+func (sc *StatCache) purge(now time.Time) {
+	for k, e := range sc.entries {
+		if now.After(e.expires) {
+			delete(sc.entries, k)
+		}
+	}
+	if len(sc.entries) >= sc.MaxEntries {
+		clear(sc.entries)
+	}
+}
+
+// Invalidate removes the cached metadata of the file and of its *.br sibling,
+// or of all the files within the directory when absPath ends with a slash.
+func (sc *StatCache) Invalidate(absPath string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if strings.HasSuffix(absPath, "/") {
+		for k := range sc.entries {
+			if strings.HasPrefix(k, absPath) {
+				delete(sc.entries, k)
+			}
+		}
+		return
+	}
+	delete(sc.entries, absPath)
+	delete(sc.entries, absPath+".br")
+}
+
+// Watch invalidates the cached metadata when a file within dir (or its sub-directories)
+// is created, modified, renamed or removed. dir must be the Dir of the StaticWebServer.
+// The TTL still bounds the staleness when an event is missed, for example when the whole
+// dir is replaced by a rename: its parent directory is not watched. Close stops watching.
+func (sc *StatCache) Watch(dir string) error {
+	sc.mu.Lock()
+	if sc.watcher == nil {
+		w, err := fsnotify.NewWatcher()
+		if err != nil {
+			sc.mu.Unlock()
+			return err
+		}
+		sc.watcher = w
+		go sc.watch(w)
+	}
+	w := sc.watcher
+	sc.mu.Unlock()
+
+	return watchTree(w, filepath.Clean(dir))
+}
+
+// Close stops watching the directories.
+func (sc *StatCache) Close() error {
+	sc.mu.Lock()
+	w := sc.watcher
+	sc.watcher = nil
+	sc.mu.Unlock()
+	if w == nil {
+		return nil
+	}
+	return w.Close()
+}
+
+// watchTree watches dir and its sub-directories (fsnotify is not recursive).
+func watchTree(w *fsnotify.Watcher, dir string) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return w.Add(p)
+		}
+		return nil
+	})
+}
+
+func (sc *StatCache) watch(w *fsnotify.Watcher) {
+	for {
+		select {
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			sc.Invalidate(ev.Name)
+			if ev.Has(fsnotify.Remove) || ev.Has(fsnotify.Rename) {
+				sc.Invalidate(ev.Name + "/") // may be a directory
+			}
+			if ev.Has(fsnotify.Create) {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					err = watchTree(w, ev.Name)
+					if err != nil {
+						log.Warn("StatCache: watch", err)
+					}
+					sc.Invalidate(ev.Name + "/") // the files created before the watch
+				}
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Warn("StatCache: watch", err)
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				sc.Flush() // the lost events may concern any file
+			}
+		}
+	}
+}
+
+// Flush removes all the cached entries.
+func (sc *StatCache) Flush() {
+	sc.mu.Lock()
+	clear(sc.entries)
+	sc.mu.Unlock()
+}
+
+// Stats returns the counters since the creation of the StatCache.
+func (sc *StatCache) Stats() StatCacheStats {
+	sc.mu.RLock()
+	n := len(sc.entries)
+	sc.mu.RUnlock()
+	return StatCacheStats{
+		Hits:    sc.hits.Load(),
+		Misses:  sc.misses.Load(),
+		Entries: n,
+	}
+}
+
+// LogStats prints the counters in the logs.
+func (sc *StatCache) LogStats() {
+	s := sc.Stats()
+	log.Infof("StatCache hits=%d misses=%d entries=%d", s.Hits, s.Misses, s.Entries)
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// Storage is the backend of a StaticWebServer serving the files
+	// from elsewhere than the local filesystem, e.g. an S3-compatible bucket (see S3Storage).
+	// The keys are the slash-separated paths without leading slash.
+	Storage interface {
+		// Stat returns the metadata of the object, or an error wrapping fs.ErrNotExist.
+		Stat(ctx context.Context, key string) (ObjectInfo, error)
+		// Open returns the content and the metadata of the object.
+		Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	}
+
+	// ObjectInfo is the metadata of a Storage object.
+	ObjectInfo struct {
+		ModTime time.Time
+		ETag    string // quoted entity tag, sent as is to the clients
+		Size    int64
+	}
+)
+
+// WithStorage returns a copy of the StaticWebServer serving the objects of the Storage
+// instead of the local files. Dir becomes the key prefix (e.g. "site" or "")
+// and the StatCache and SendTuning are not used:
+//
+//	s3, err := gc.S3StorageFromEnv() // S3_ENDPOINT, S3_BUCKET...
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	ws := g.NewStaticWebServer("www").WithStorage(s3)
+//
+// The *.br and *.avif siblings are negotiated as with the local files,
+// and the ETag of the objects enables the conditional requests (If-None-Match).
+func (ws StaticWebServer) WithStorage(s Storage) StaticWebServer {
+	ws.Storage = s
+	return ws
+}
+
+// storageKey converts the path of the file into a Storage key.
+func storageKey(absPath string) string {
+	return strings.TrimPrefix(absPath, "/")
+}
+
+// readFile reads a whole file, e.g. the error page.
+func (ws *StaticWebServer) readFile(r *http.Request, absPath string) ([]byte, error) {
+	if ws.Storage == nil {
+		return os.ReadFile(absPath)
+	}
+	rc, _, err := ws.Storage.Open(r.Context(), storageKey(absPath))
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// sendObject is the counterpart of send for the Storage.
+func (ws *StaticWebServer) sendObject(w http.ResponseWriter, r *http.Request, absPath string) {
+	key := storageKey(absPath)
+	info, err := ws.Storage.Stat(r.Context(), key)
+
+	// if client (browser) supports Brotli and the *.br object is present
+	// => send the *.br object
+	if err == nil && strings.Contains(r.Header.Get("Accept-Encoding"), "br") {
+		if brInfo, e := ws.Storage.Stat(r.Context(), key+".br"); e == nil {
+			w.Header().Set("Content-Encoding", "br")
+			key, info = key+".br", brInfo
+		}
+	}
+
+	var rc io.ReadCloser
+	if err == nil && !notModified(r, info.ETag) {
+		rc, info, err = ws.Storage.Open(r.Context(), key)
+	}
+	if err != nil {
+		log.Warn("WebServer:", err)
+		status, page := http.StatusNotFound, ws.NotFoundPage
+		if !errors.Is(err, fs.ErrNotExist) {
+			status, page = http.StatusInternalServerError, ws.ErrorPage
+		}
+		ws.writeErrorPage(w, r, status, page)
+		log.Out(strconv.Itoa(status), r.RemoteAddr, r.Method, key, err)
+		return
+	}
+
+	h := w.Header()
+	if info.ETag != "" {
+		h.Set("ETag", info.ETag)
+	}
+	if !info.ModTime.IsZero() {
+		h.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if rc == nil {
+		h.Del("Content-Type")
+		h.Del("Content-Encoding")
+		w.WriteHeader(http.StatusNotModified)
+		log.Out("304", r.RemoteAddr, r.Method, key)
+		return
+	}
+	defer func() {
+		e := rc.Close()
+		if e != nil {
+			log.Warn("WebServer: Close()", e)
+		}
+	}()
+
+	h.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+	n, err := io.Copy(w, rc)
+	if err != nil {
+		log.Warn("WebServer: Copy("+key+")", err)
+	} else {
+		log.Out("200", r.RemoteAddr, r.Method, key, gg.ConvertSize64(n))
+	}
+}
+
+// notModified reports whether the If-None-Match header of the request matches the ETag.
+func notModified(r *http.Request, etag string) bool {
+	inm := r.Header.Get("If-None-Match")
+	if etag == "" || inm == "" {
+		return false
+	}
+	if strings.TrimSpace(inm) == "*" {
+		return true
+	}
+	for tag := range strings.SplitSeq(inm, ",") {
+		// weak comparison (RFC 9110 §13.1.2)
+		if strings.TrimPrefix(strings.TrimSpace(tag), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
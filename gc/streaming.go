@@ -0,0 +1,95 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// WithTimeouts overrides the server ReadTimeout and WriteTimeout for one handler,
+// typically a large upload or download endpoint.
+// A zero duration removes the deadline.
+// WithTimeouts relies on http.ResponseController:
+// the ResponseWriter wrappers must implement the Unwrap() method.
+func WithTimeouts(read, write time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		err := rc.SetReadDeadline(deadline(read))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Warn("WithTimeouts SetReadDeadline", err)
+		}
+		err = rc.SetWriteDeadline(deadline(write))
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			log.Warn("WithTimeouts SetWriteDeadline", err)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StreamingHandler is for long responses (large file downloads, Server-Sent Events...)
+// that would be killed by the server WriteTimeout.
+// Rather than removing the deadline, StreamingHandler postpones it before every chunk:
+// the stream lives as long as the client reads each chunk within chunkTimeout.
+// Each chunk is flushed immediately to the client.
+func StreamingHandler(chunkTimeout time.Duration, next http.Handler) http.Handler {
+	log.Info("StreamingHandler chunkTimeout=" + chunkTimeout.String())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &streamWriter{
+			ResponseWriter: w,
+			rc:             http.NewResponseController(w),
+			timeout:        chunkTimeout,
+		}
+		sw.extend()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+type streamWriter struct {
+	http.ResponseWriter
+
+	rc      *http.ResponseController
+	timeout time.Duration
+}
+
+func (sw *streamWriter) extend() {
+	err := sw.rc.SetWriteDeadline(deadline(sw.timeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Warn("StreamingHandler SetWriteDeadline", err)
+	}
+}
+
+func (sw *streamWriter) Write(b []byte) (int, error) {
+	sw.extend()
+	n, err := sw.ResponseWriter.Write(b)
+	if err != nil {
+		return n, err
+	}
+	err = sw.rc.Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		err = nil
+	}
+	return n, err
+}
+
+// Flush implements http.Flusher for the handlers using the old interface.
+func (sw *streamWriter) Flush() {
+	sw.extend()
+	_ = sw.rc.Flush()
+}
+
+// Unwrap is used by http.ResponseController.
+func (sw *streamWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+func deadline(d time.Duration) time.Time {
+	if d <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+// slowStream writes 6 chunks during about 300 ms.
+var slowStream = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+	for range 6 {
+		_, err := w.Write([]byte("chunk\n"))
+		if err != nil {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+})
+
+func getBody(t *testing.T, h http.Handler) (string, error) {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(h)
+	server.Config.WriteTimeout = 100 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestStreamingHandler(t *testing.T) {
+	t.Parallel()
+
+	const want = "chunk\nchunk\nchunk\nchunk\nchunk\nchunk\n"
+
+	body, err := getBody(t, gc.StreamingHandler(100*time.Millisecond, slowStream))
+	if err != nil || body != want {
+		t.Errorf("StreamingHandler got %q err=%v", body, err)
+	}
+
+	body, err = getBody(t, gc.WithTimeouts(0, time.Second, slowStream))
+	if err != nil || body != want {
+		t.Errorf("WithTimeouts got %q err=%v", body, err)
+	}
+
+	body, _ = getBody(t, slowStream)
+	if body == want {
+		t.Error("the server WriteTimeout should have interrupted the stream")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// Templates renders the html/template files of a directory:
+//
+//	templates/
+//	├── layouts/base.html     {{define "layout"}}<html>…{{block "content" .}}{{end}}…</html>{{end}}
+//	├── partials/nav.html     {{define "nav"}}…{{end}}
+//	├── index.html            {{define "content"}}…{{template "nav" .}}…{{end}}
+//	└── blog/post.html        page "blog/post"
+//
+// Each page is parsed with all the layouts and partials: the page overrides the blocks
+// of the template "layout" (the page is rendered alone when no layout is defined).
+// The parsed templates are cached, in dev mode (WithDev) they are reloaded
+// when a file of the directory changes.
+//
+//	tpl := g.Templates("templates")
+//	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+//		tpl.Render(w, "index", data)
+//	})
+type Templates struct {
+	pages   map[string]*template.Template
+	lastMod time.Time
+	// Funcs are the functions available in the templates, set them before the first Render.
+	Funcs  template.FuncMap
+	Writer gg.Writer
+	dir    string
+	files  int
+	mu     sync.RWMutex
+	dev    bool
+}
+
+// layoutName is the template executed when defined by a file of the layouts directory.
+const layoutName = "layout"
+
+// Templates creates the Templates of dir, reloaded on change in dev mode.
+func (g *Garcon) Templates(dir string) *Templates {
+	return NewTemplates(g.Writer, dir, g.devMode)
+}
+
+// NewTemplates creates the Templates of dir, parsed on the first Render.
+// When dev is true, the files are reloaded on change.
+func NewTemplates(gw gg.Writer, dir string, dev bool) *Templates {
+	return &Templates{Writer: gw, dir: dir, dev: dev}
+}
+
+// Render executes the page (path relative to dir, without the ".html" extension)
+// and writes the HTML with the Content-Type. The page is fully rendered before writing:
+// on error, the client receives a clean "500 Internal Server Error" page
+// (with the template error in dev mode) and the error is returned.
+func (t *Templates) Render(w http.ResponseWriter, name string, data any) error {
+	page, err := t.page(name)
+	if err == nil {
+		var buf bytes.Buffer
+		if page.Lookup(layoutName) != nil {
+			err = page.ExecuteTemplate(&buf, layoutName, data)
+		} else {
+			err = page.Execute(&buf, data)
+		}
+		if err == nil {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+			_, err = w.Write(buf.Bytes())
+			return err
+		}
+		err = gerr.Wrap(err, gerr.ServerErr, "cannot render template", "page", name)
+	}
+
+	log.Warn("Templates", err)
+	msg := "cannot render the page"
+	if t.dev {
+		msg = err.Error()
+	}
+	t.Writer.WriteHTMLError(w, nil, http.StatusInternalServerError, msg)
+	return err
+}
+
+// page returns the parsed page, (re)loading the templates when required.
+func (t *Templates) page(name string) (*template.Template, error) {
+	t.mu.RLock()
+	pages := t.pages
+	t.mu.RUnlock()
+
+	if pages == nil || (t.dev && t.changed()) {
+		var err error
+		pages, err = t.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	page, ok := pages[name]
+	if !ok {
+		return nil, gerr.New(gerr.NotFound, "template not found", "page", name, "dir", t.dir)
+	}
+	return page, nil
+}
+
+// changed reports whether a file has been modified, added or removed since the last load.
+func (t *Templates) changed() bool {
+	lastMod, files, err := scanTemplates(t.dir)
+	if err != nil {
+		return true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return files != t.files || lastMod.After(t.lastMod)
+}
+
+func scanTemplates(dir string) (lastMod time.Time, files int, err error) {
+	err = filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(d.Name()) != ".html" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files++
+		if info.ModTime().After(lastMod) {
+			lastMod = info.ModTime()
+		}
+		return nil
+	})
+	return lastMod, files, err
+}
+
+// load parses all the pages, each with the layouts and the partials.
+func (t *Templates) load() (map[string]*template.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lastMod, files, err := scanTemplates(t.dir)
+	if err != nil {
+		return nil, gerr.Wrap(err, gerr.ConfigErr, "cannot read the templates", "dir", t.dir)
+	}
+	if t.pages != nil && files == t.files && !lastMod.After(t.lastMod) {
+		return t.pages, nil // loaded by a concurrent request
+	}
+
+	var shared, pages []string
+	err = filepath.WalkDir(t.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(p) != ".html" {
+			return err
+		}
+		rel, err := filepath.Rel(t.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if strings.HasPrefix(rel, "layouts/") || strings.HasPrefix(rel, "partials/") {
+			shared = append(shared, p)
+		} else {
+			pages = append(pages, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, gerr.Wrap(err, gerr.ConfigErr, "cannot read the templates", "dir", t.dir)
+	}
+
+	base := template.New("").Funcs(t.Funcs)
+	for _, file := range shared {
+		_, err = parseFile(base, filepath.Base(file), file)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	parsed := make(map[string]*template.Template, len(pages))
+	for _, rel := range pages {
+		set, err := base.Clone()
+		if err != nil {
+			return nil, gerr.Wrap(err, gerr.ConfigErr, "cannot clone the layouts")
+		}
+		page, err := parseFile(set, rel, filepath.Join(t.dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return nil, err
+		}
+		parsed[strings.TrimSuffix(rel, path.Ext(rel))] = page
+	}
+
+	t.pages = parsed
+	t.lastMod = lastMod
+	t.files = files
+	log.Infof("Templates %d pages and %d layouts/partials from %s", len(pages), len(shared), t.dir)
+	return parsed, nil
+}
+
+// parseFile parses the file as the template name associated with the set.
+func parseFile(set *template.Template, name, file string) (*template.Template, error) {
+	text, err := os.ReadFile(file)
+	if err != nil {
+		return nil, gerr.Wrap(err, gerr.ConfigErr, "cannot read the template", "file", file)
+	}
+	tpl, err := set.New(name).Parse(string(text))
+	if err != nil {
+		return nil, gerr.Wrap(err, gerr.ConfigErr, "cannot parse the template", "file", file)
+	}
+	return tpl, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestTemplates(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, text string) {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0o755)
+		if err := os.WriteFile(file, []byte(text), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("layouts/base.html", `{{define "layout"}}<main>{{template "nav"}}{{block "content" .}}default{{end}}</main>{{end}}`)
+	write("partials/nav.html", `{{define "nav"}}<nav/>{{end}}`)
+	write("index.html", `{{define "content"}}Hello {{.}}{{end}}`)
+	write("blog/post.html", `{{define "content"}}Post {{.}}{{end}}`)
+	write("broken.html", `{{define "content"}}{{.Missing.Field}}{{end}}`)
+
+	render := func(tpl *gc.Templates, name string, data any) (int, string) {
+		w := httptest.NewRecorder()
+		tpl.Render(w, name, data)
+		return w.Code, w.Body.String()
+	}
+
+	tpl := gc.NewTemplates(gg.NewWriter(""), dir, true)
+	if code, body := render(tpl, "index", "<b>"); code != http.StatusOK || body != "<main><nav/>Hello &lt;b&gt;</main>" {
+		t.Errorf("index: %d %q", code, body)
+	}
+	if code, body := render(tpl, "blog/post", 42); code != http.StatusOK || body != "<main><nav/>Post 42</main>" {
+		t.Errorf("blog/post: %d %q", code, body)
+	}
+	if code, body := render(tpl, "broken", 1); code != http.StatusInternalServerError || !strings.Contains(body, "Missing") {
+		t.Errorf("broken: %d %q", code, body)
+	}
+	if code, _ := render(tpl, "nope", nil); code != http.StatusInternalServerError {
+		t.Errorf("unknown page: %d", code)
+	}
+
+	// hot reload in dev mode
+	write("index.html", `{{define "content"}}Bye {{.}}{{end}}`)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "index.html"), future, future)
+	if _, body := render(tpl, "index", "x"); body != "<main><nav/>Bye x</main>" {
+		t.Errorf("dev mode must reload, got %q", body)
+	}
+
+	// cached in prod
+	prod := gc.NewTemplates(gg.NewWriter(""), dir, false)
+	render(prod, "index", "x")
+	write("index.html", `{{define "content"}}Changed{{end}}`)
+	later := future.Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "index.html"), later, later)
+	if _, body := render(prod, "index", "x"); body != "<main><nav/>Bye x</main>" {
+		t.Errorf("prod mode must cache, got %q", body)
+	}
+	if _, body := render(prod, "broken", 1); strings.Contains(body, "Missing") {
+		t.Errorf("prod mode must hide the template error, got %q", body)
+	}
+}
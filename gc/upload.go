@@ -0,0 +1,348 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// UploadStore saves the uploaded files: a local directory (DirStore)
+	// or an S3-compatible bucket.
+	UploadStore interface {
+		// Save streams r into the new file name and returns its location.
+		// Save must not leave a partial file when r fails.
+		Save(ctx context.Context, name, contentType string, r io.Reader) (location string, err error)
+		// Delete removes a file saved by Save.
+		Delete(ctx context.Context, location string) error
+	}
+
+	// DirStore saves the uploaded files in a local directory.
+	// The file is first written in a hidden temporary file of Dir,
+	// and renamed when complete.
+	DirStore struct {
+		Dir string
+	}
+
+	// UploadHandler receives the files of a "multipart/form-data" request
+	// and streams them to the UploadStore without buffering them in memory:
+	//
+	//	up := g.NewUploadHandler(gc.DirStore{Dir: "uploads"}, "image/png", "image/jpeg", "application/pdf")
+	//	up.MaxFileSize = 20 << 20
+	//	r.With(g.Checker.Chk).Post("/api/upload", up.ServeHTTP)
+	//
+	// The MIME type is detected from the content (magic bytes), the file
+	// extension and the Content-Type provided by the client are ignored.
+	// The stored file name is random, its extension derives from the detected type.
+	// When a file is rejected, the files already stored by the request are deleted.
+	UploadHandler struct {
+		// Store receives the files.
+		Store UploadStore
+		// OnProgress (optional) is called during the upload of each file.
+		OnProgress func(UploadProgress)
+		// Field (optional) restricts the upload to the parts of this form field.
+		Field string
+		// AllowedTypes are the accepted MIME types, "image/*" accepts all the images.
+		AllowedTypes []string
+		// MaxFileSize limits the size of each file (default 10 MiB).
+		MaxFileSize int64
+		// MaxFiles limits the number of files per request (default 10).
+		MaxFiles int
+		gw       gg.Writer
+	}
+
+	// UploadedFile describes a file stored by the UploadHandler.
+	UploadedFile struct {
+		Field       string `json:"field"`
+		Filename    string `json:"filename"` // provided by the client, informative only
+		ContentType string `json:"content_type"`
+		Location    string `json:"location"` // returned by the UploadStore
+		Size        int64  `json:"size"`
+	}
+
+	// UploadProgress is reported every 256 KiB and at the end of each file.
+	UploadProgress struct {
+		Field    string
+		Filename string
+		Bytes    int64 // received bytes of the current file
+		Total    int64 // Content-Length of the request, -1 when unknown
+		Done     bool  // the file is complete
+	}
+
+	// uploadReader counts the bytes, enforces the size limit and reports the progress.
+	uploadReader struct {
+		r        io.Reader
+		report   func(UploadProgress)
+		progress UploadProgress
+		max      int64
+		reported int64
+	}
+)
+
+const (
+	defaultMaxFileSize = 10 << 20
+	defaultMaxFiles    = 10
+	sniffLen           = 512 // bytes read by http.DetectContentType
+	uploadProgressStep = 256 << 10
+	maxFormValueSize   = 64 << 10 // non-file fields are discarded
+)
+
+var errUploadTooLarge = errors.New("file exceeds the size limit")
+
+// NewUploadHandler uses the Writer of Garcon to respond the errors.
+func (g *Garcon) NewUploadHandler(store UploadStore, allowedTypes ...string) *UploadHandler {
+	return NewUploadHandler(g.Writer, store, allowedTypes...)
+}
+
+// NewUploadHandler creates an UploadHandler accepting the allowedTypes (at least one).
+func NewUploadHandler(gw gg.Writer, store UploadStore, allowedTypes ...string) *UploadHandler {
+	if store == nil {
+		log.Panic("NewUploadHandler requires an UploadStore")
+	}
+	if len(allowedTypes) == 0 {
+		log.Panic("NewUploadHandler requires at least one allowed MIME type")
+	}
+	log.Info("UploadHandler accepts", allowedTypes)
+	return &UploadHandler{
+		Store:        store,
+		AllowedTypes: allowedTypes,
+		MaxFileSize:  defaultMaxFileSize,
+		MaxFiles:     defaultMaxFiles,
+		gw:           gw,
+	}
+}
+
+// ServeHTTP responds 201 Created with {"files":[...]} (see UploadedFile),
+// or the error of Receive. The request body is limited to MaxFiles × MaxFileSize
+// (plus a margin for the multipart headers and the other form fields).
+func (uh *UploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	maxFiles, maxSize := uh.limits()
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxFiles)*(maxSize+maxFormValueSize)+maxFormValueSize)
+
+	files, err := uh.Receive(r)
+	if err != nil {
+		var gErr *gerr.Error
+		if errors.As(err, &gErr) && gErr.Code == gerr.ServerErr {
+			log.Warn("UploadHandler", ipMethodURLSafe(r), err)
+		}
+		uh.gw.WriteError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	uh.gw.WriteJSON(w, http.StatusCreated, map[string]any{"files": files})
+}
+
+// Receive stores the files of the request and returns them.
+// The error is a gerr.Error: Invalid (malformed request or no file),
+// TooLarge, Unsupported (MIME type not allowed), UserAbort or ServerErr (store failure).
+func (uh *UploadHandler) Receive(r *http.Request) ([]UploadedFile, error) {
+	maxFiles, maxSize := uh.limits()
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, gerr.Wrap(err, gerr.Invalid, "Expect a multipart/form-data request")
+	}
+
+	var files []UploadedFile
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			uh.cleanup(r.Context(), files)
+			return nil, uploadReadErr(r, err, "Malformed multipart body")
+		}
+
+		if part.FileName() == "" || (uh.Field != "" && part.FormName() != uh.Field) {
+			_, err = io.Copy(io.Discard, io.LimitReader(part, maxFormValueSize))
+			part.Close()
+			if err != nil {
+				uh.cleanup(r.Context(), files)
+				return nil, uploadReadErr(r, err, "Malformed multipart body")
+			}
+			continue
+		}
+
+		if len(files) == maxFiles {
+			part.Close()
+			uh.cleanup(r.Context(), files)
+			return nil, gerr.New(gerr.Invalid, "Too many files, maximum is "+strconv.Itoa(maxFiles))
+		}
+
+		f, err := uh.save(r, part, maxSize)
+		part.Close()
+		if err != nil {
+			uh.cleanup(r.Context(), files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	if len(files) == 0 {
+		return nil, gerr.New(gerr.Invalid, "No file in the request")
+	}
+	return files, nil
+}
+
+func (uh *UploadHandler) limits() (maxFiles int, maxSize int64) {
+	maxFiles = uh.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultMaxFiles
+	}
+	maxSize = uh.MaxFileSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxFileSize
+	}
+	return maxFiles, maxSize
+}
+
+// save sniffs the MIME type of the part and streams it to the Store.
+func (uh *UploadHandler) save(r *http.Request, part *multipart.Part, maxSize int64) (UploadedFile, error) {
+	f := UploadedFile{Field: part.FormName(), Filename: part.FileName()}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return f, uploadReadErr(r, err, "Cannot read "+f.Filename)
+	}
+	head = head[:n]
+	if n == 0 {
+		return f, gerr.New(gerr.Invalid, "Empty file "+f.Filename)
+	}
+
+	f.ContentType, _, _ = mime.ParseMediaType(http.DetectContentType(head))
+	if !uh.allowed(f.ContentType) {
+		return f, gerr.New(gerr.Unsupported, "File type "+f.ContentType+" is not allowed",
+			"filename", f.Filename, "content_type", f.ContentType)
+	}
+
+	ur := &uploadReader{
+		r:        io.MultiReader(bytes.NewReader(head), part),
+		max:      maxSize,
+		report:   uh.OnProgress,
+		progress: UploadProgress{Field: f.Field, Filename: f.Filename, Total: r.ContentLength},
+	}
+
+	f.Location, err = uh.Store.Save(r.Context(), randomName(f.ContentType), f.ContentType, ur)
+	f.Size = ur.progress.Bytes
+	if err != nil {
+		if errors.Is(err, errUploadTooLarge) {
+			return f, gerr.New(gerr.TooLarge, "File "+f.Filename+" exceeds "+strconv.FormatInt(maxSize, 10)+" bytes",
+				"filename", f.Filename, "max", maxSize)
+		}
+		return f, uploadReadErr(r, err, "Cannot store "+f.Filename)
+	}
+
+	ur.progress.Done = true
+	if ur.report != nil {
+		ur.report(ur.progress)
+	}
+	return f, nil
+}
+
+// allowed matches the MIME type against the AllowedTypes, including the "image/*" wildcards.
+func (uh *UploadHandler) allowed(contentType string) bool {
+	for _, t := range uh.AllowedTypes {
+		if t == contentType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(t, "/*"); ok && strings.HasPrefix(contentType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanup deletes the files already stored when the request fails.
+func (uh *UploadHandler) cleanup(ctx context.Context, files []UploadedFile) {
+	ctx = context.WithoutCancel(ctx) // also when the client has gone
+	for _, f := range files {
+		err := uh.Store.Delete(ctx, f.Location)
+		if err != nil {
+			log.Warn("UploadHandler cannot delete", f.Location, err)
+		}
+	}
+}
+
+// uploadReadErr distinguishes the client errors from the store failures.
+func uploadReadErr(r *http.Request, err error, msg string) *gerr.Error {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxErr):
+		return gerr.Wrap(err, gerr.TooLarge, "Request exceeds "+strconv.FormatInt(maxErr.Limit, 10)+" bytes")
+	case r.Context().Err() != nil:
+		return gerr.Wrap(err, gerr.UserAbort, "Upload canceled")
+	case errors.Is(err, io.ErrUnexpectedEOF), strings.Contains(err.Error(), "multipart"):
+		return gerr.Wrap(err, gerr.Invalid, msg)
+	default:
+		return gerr.Wrap(err, gerr.ServerErr, msg)
+	}
+}
+
+// randomName returns a random file name with the extension of the MIME type.
+func randomName(contentType string) string {
+	name := strings.ToLower(rand.Text())
+	exts, _ := mime.ExtensionsByType(contentType)
+	if len(exts) > 0 {
+		name += exts[0]
+	}
+	return name
+}
+
+func (ur *uploadReader) Read(p []byte) (int, error) {
+	n, err := ur.r.Read(p)
+	ur.progress.Bytes += int64(n)
+	if ur.progress.Bytes > ur.max {
+		return n, errUploadTooLarge
+	}
+	if ur.report != nil && ur.progress.Bytes-ur.reported >= uploadProgressStep {
+		ur.reported = ur.progress.Bytes
+		ur.report(ur.progress)
+	}
+	return n, err
+}
+
+// Save writes the file in a temporary file of Dir, then renames it.
+// The temporary file is removed on failure.
+func (s DirStore) Save(_ context.Context, name, _ string, r io.Reader) (string, error) {
+	err := os.MkdirAll(s.Dir, 0o750)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(s.Dir, ".upload-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.Copy(tmp, r)
+	err = errors.Join(err, tmp.Close())
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.Dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return name, nil
+}
+
+// Delete removes the file from Dir.
+func (s DirStore) Delete(_ context.Context, location string) error {
+	return os.Remove(filepath.Join(s.Dir, filepath.Base(location)))
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+//nolint:gochecknoglobals // test data
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func multipartBody(t *testing.T, files map[string][]byte) (*bytes.Buffer, string) {
+	t.Helper()
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	err := mw.WriteField("title", "holidays")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		fw, err := mw.CreateFormFile("file", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	err = mw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &buf, mw.FormDataContentType()
+}
+
+func upload(t *testing.T, uh *gc.UploadHandler, files map[string][]byte) *httptest.ResponseRecorder {
+	t.Helper()
+	body, contentType := multipartBody(t, files)
+	r := httptest.NewRequest(http.MethodPost, "/upload", body)
+	r.Header.Set("Content-Type", contentType)
+	w := httptest.NewRecorder()
+	uh.ServeHTTP(w, r)
+	return w
+}
+
+func dirFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestUploadHandler(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	uh := gc.NewUploadHandler(gg.Writer(""), gc.DirStore{Dir: dir}, "image/*")
+	var last gc.UploadProgress
+	uh.OnProgress = func(p gc.UploadProgress) { last = p }
+
+	data := append(bytes.Clone(pngHeader), bytes.Repeat([]byte{0}, 300<<10)...)
+	w := upload(t, uh, map[string][]byte{"photo.jpg": data}) // extension ignored
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body)
+	}
+
+	var resp struct{ Files []gc.UploadedFile }
+	err := json.Unmarshal(w.Body.Bytes(), &resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Files) != 1 {
+		t.Fatalf("want 1 file, got %+v", resp.Files)
+	}
+	f := resp.Files[0]
+	if f.ContentType != "image/png" || f.Filename != "photo.jpg" || f.Size != int64(len(data)) || !strings.HasSuffix(f.Location, ".png") {
+		t.Errorf("unexpected file %+v", f)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, f.Location))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("stored file differs, err=%v", err)
+	}
+	if !last.Done || last.Bytes != int64(len(data)) {
+		t.Errorf("unexpected last progress %+v", last)
+	}
+}
+
+func TestUploadHandler_Rejects(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		files map[string][]byte
+		name  string
+		want  int
+	}{
+		{name: "magic bytes", want: http.StatusUnsupportedMediaType, files: map[string][]byte{
+			"a.png": pngHeader,
+			"b.png": []byte("#!/bin/sh\nrm -rf /\n"),
+		}},
+		{name: "size", want: http.StatusRequestEntityTooLarge, files: map[string][]byte{
+			"big.png": append(bytes.Clone(pngHeader), make([]byte, 2000)...),
+		}},
+		{name: "no file", want: http.StatusBadRequest, files: nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			uh := gc.NewUploadHandler(gg.Writer(""), gc.DirStore{Dir: dir}, "image/png")
+			uh.MaxFileSize = 1000
+
+			w := upload(t, uh, c.files)
+			if w.Code != c.want {
+				t.Errorf("want %d got %d body=%s", c.want, w.Code, w.Body)
+			}
+			if files := dirFiles(t, dir); len(files) > 0 {
+				t.Errorf("files not cleaned up: %v", files)
+			}
+		})
+	}
+}
+
+func TestUploadHandler_NotMultipart(t *testing.T) {
+	t.Parallel()
+
+	uh := gc.NewUploadHandler(gg.Writer(""), gc.DirStore{Dir: t.TempDir()}, "image/png")
+	r := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader(`{"file":"x"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	uh.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("want 400 got %d", w.Code)
+	}
+}
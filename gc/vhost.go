@@ -0,0 +1,168 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// VHostMux dispatches the requests to independent sites depending on the Host header,
+	// each site having its own middleware chain (TokenChecker, rate limiter, CORS...):
+	//
+	//	vh := g.NewVHostMux()
+	//	vh.Handle("api.example.com", api, g.MiddlewareCORS(), ck.Vet)
+	//	vh.Handle("*.example.com", blog)                 // any other sub-domain
+	//	n, err := vh.HandleWWW("/var/opt/www")           // one site per directory named as the host
+	//	g.AddListener(gc.Listener{Addr: ":8080", Handler: vh})
+	//
+	// The port of the Host header is ignored. A wildcard "*.example.com" matches
+	// the sub-domains (not "example.com"), the longest wildcard wins.
+	// The unknown hosts are served by Fallback, or rejected with 421 Misdirected Request.
+	// The sites can be added while serving (e.g. after a gitwww deployment).
+	VHostMux struct {
+		// Fallback (optional) serves the requests for the unknown hosts.
+		Fallback  http.Handler
+		hosts     map[string]http.Handler
+		wildcards []vhost // sorted by decreasing suffix length
+		writer    gg.Writer
+		mu        sync.RWMutex
+	}
+
+	vhost struct {
+		handler http.Handler
+		suffix  string // ".example.com"
+	}
+)
+
+// NewVHostMux creates an empty VHostMux.
+func (g *Garcon) NewVHostMux() *VHostMux {
+	return NewVHostMux(g.Writer)
+}
+
+// NewVHostMux creates an empty VHostMux.
+func NewVHostMux(gw gg.Writer) *VHostMux {
+	return &VHostMux{hosts: make(map[string]http.Handler), writer: gw}
+}
+
+// Handle registers the site of the host (or "*.domain") wrapped by the middlewares.
+// Handle panics when the host is already registered.
+func (vh *VHostMux) Handle(host string, h http.Handler, chain ...gg.Middleware) {
+	host = normalizeHost(host)
+	if host == "" || h == nil {
+		log.Panic("VHostMux.Handle: empty host or nil handler")
+	}
+	h = gg.NewChain(chain...).Then(h)
+
+	vh.mu.Lock()
+	defer vh.mu.Unlock()
+
+	if suffix, ok := strings.CutPrefix(host, "*"); ok {
+		if slices.ContainsFunc(vh.wildcards, func(v vhost) bool { return v.suffix == suffix }) {
+			log.Panic("VHostMux.Handle: host already registered:", host)
+		}
+		vh.wildcards = append(vh.wildcards, vhost{handler: h, suffix: suffix})
+		slices.SortStableFunc(vh.wildcards, func(a, b vhost) int { return len(b.suffix) - len(a.suffix) })
+	} else {
+		if _, ok := vh.hosts[host]; ok {
+			log.Panic("VHostMux.Handle: host already registered:", host)
+		}
+		vh.hosts[host] = h
+	}
+	log.Info("VHostMux", host)
+}
+
+// HandleDir registers the static site of the host served from dir (see StaticWebServer.ServeSite).
+func (vh *VHostMux) HandleDir(host, dir string, chain ...gg.Middleware) {
+	ws := NewStaticWebServer(vh.writer, dir)
+	vh.Handle(host, http.HandlerFunc(ws.ServeSite()), chain...)
+}
+
+// HandleWWW registers a static site for each sub-directory of root named as a host
+// (containing a dot, e.g. "/var/opt/www/example.com"), as gitwww deploys them
+// when the "www" parameter of the repository is the host name.
+// The hosts already registered are skipped: call HandleWWW again after a new deployment.
+// HandleWWW returns the number of new sites.
+func (vh *VHostMux) HandleWWW(root string, chain ...gg.Middleware) (int, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		host := normalizeHost(e.Name())
+		if !e.IsDir() || !strings.Contains(host, ".") || strings.HasPrefix(host, ".") || vh.has(host) {
+			continue
+		}
+		vh.HandleDir(host, filepath.Join(root, e.Name()), chain...)
+		n++
+	}
+	return n, nil
+}
+
+// Hosts returns the registered hosts, the wildcards last.
+func (vh *VHostMux) Hosts() []string {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	hosts := make([]string, 0, len(vh.hosts)+len(vh.wildcards))
+	for h := range vh.hosts {
+		hosts = append(hosts, h)
+	}
+	slices.Sort(hosts)
+	for _, w := range vh.wildcards {
+		hosts = append(hosts, "*"+w.suffix)
+	}
+	return hosts
+}
+
+func (vh *VHostMux) has(host string) bool {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	_, ok := vh.hosts[host]
+	return ok
+}
+
+// handler returns the site of the host, or nil.
+func (vh *VHostMux) handler(host string) http.Handler {
+	vh.mu.RLock()
+	defer vh.mu.RUnlock()
+	if h, ok := vh.hosts[host]; ok {
+		return h
+	}
+	for _, w := range vh.wildcards {
+		if strings.HasSuffix(host, w.suffix) {
+			return w.handler
+		}
+	}
+	return nil
+}
+
+func (vh *VHostMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := vh.handler(normalizeHost(r.Host))
+	switch {
+	case h != nil:
+		h.ServeHTTP(w, r)
+	case vh.Fallback != nil:
+		vh.Fallback.ServeHTTP(w, r)
+	default:
+		vh.writer.WriteErr(w, r, http.StatusMisdirectedRequest, "Unknown host", "host", gg.Sanitize(r.Host))
+	}
+}
+
+// normalizeHost removes the port and the trailing dot, and converts to lower case.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
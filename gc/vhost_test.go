@@ -0,0 +1,87 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestVHostMux(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	for _, f := range []string{"example.com/index.html", "example.com/blog/index.html", "docs.example.com/guide.txt", "tmp/x.html"} {
+		file := filepath.Join(root, filepath.FromSlash(f))
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(f), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vh := gc.NewVHostMux(gg.NewWriter(""))
+	api := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("api")) })
+	private := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	vh.Handle("API.example.com", api, private)
+	vh.Handle("*.example.com", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = w.Write([]byte("wildcard")) }))
+
+	n, err := vh.HandleWWW(root)
+	if err != nil || n != 2 {
+		t.Fatalf("HandleWWW n=%d err=%v", n, err)
+	}
+	if n, _ = vh.HandleWWW(root); n != 0 {
+		t.Errorf("second HandleWWW registered %d sites", n)
+	}
+	if want := []string{"api.example.com", "docs.example.com", "example.com", "*.example.com"}; !slices.Equal(vh.Hosts(), want) {
+		t.Errorf("Hosts=%v want %v", vh.Hosts(), want)
+	}
+
+	cases := []struct {
+		url  string
+		auth bool
+		code int
+		want string
+	}{
+		{"http://example.com/", false, http.StatusOK, "example.com/index.html"},
+		{"http://example.com:8080/blog/", false, http.StatusOK, "example.com/blog/index.html"},
+		{"http://example.com/blog", false, http.StatusMovedPermanently, ""},
+		{"http://docs.example.com/guide.txt", false, http.StatusOK, "docs.example.com/guide.txt"},
+		{"http://api.example.com/items", false, http.StatusUnauthorized, ""},
+		{"http://api.example.com/items", true, http.StatusOK, "api"},
+		{"http://www.example.com/", false, http.StatusOK, "wildcard"},
+		{"http://tmp/x.html", false, http.StatusMisdirectedRequest, ""},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, c.url, http.NoBody)
+		if c.auth {
+			r.Header.Set("Authorization", "Bearer x")
+		}
+		w := httptest.NewRecorder()
+		vh.ServeHTTP(w, r)
+		if w.Code != c.code {
+			t.Errorf("%s status=%d want %d", c.url, w.Code, c.code)
+		}
+		if c.want != "" && w.Body.String() != c.want {
+			t.Errorf("%s body=%q want %q", c.url, w.Body.String(), c.want)
+		}
+	}
+}
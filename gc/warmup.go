@@ -0,0 +1,230 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// WarmUp runs the startup tasks (cache priming, template parsing, index building...)
+// while the readiness probe fails, so the orchestrators (Kubernetes...)
+// do not route the traffic to a cold instance.
+//
+//	warm := gc.NewWarmUp()
+//	warm.Add("templates", parseTemplates)
+//	warm.Add("cache", primeCache)
+//	g.AddListener(gc.Listener{Name: "exporter", Addr: ":9093", Handler: g.ExporterHandler(gc.WithWarmUp(warm))})
+//	go warm.Run(ctx)
+//
+// A failed task keeps the instance unready.
+type WarmUp struct {
+	started time.Time
+	tasks   []*warmUpTask
+	mu      sync.Mutex
+	running bool
+}
+
+// WarmUpStatus is the progress reported by the readiness probe.
+type WarmUpStatus struct {
+	Tasks     []WarmUpTaskStatus `json:"tasks"`
+	Elapsed   string             `json:"elapsed,omitempty"`
+	Completed int                `json:"completed"`
+	Total     int                `json:"total"`
+	Ready     bool               `json:"ready"`
+}
+
+// WarmUpTaskStatus is the state of a warm-up task: "pending", "running", "done" or "failed".
+type WarmUpTaskStatus struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Duration string `json:"duration,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type (
+	warmUpTask struct {
+		fn func(context.Context) error
+		taskState
+	}
+
+	// taskState is the state of a WarmUp or StartupGate task,
+	// protected by the mutex of its owner.
+	taskState struct {
+		start    time.Time
+		err      error
+		name     string
+		state    string
+		duration time.Duration
+	}
+)
+
+const (
+	warmUpPending = "pending"
+	warmUpRunning = "running"
+	warmUpDone    = "done"
+	warmUpFailed  = "failed"
+)
+
+// NewWarmUp creates an empty WarmUp: without tasks, the instance is ready.
+func NewWarmUp() *WarmUp {
+	return &WarmUp{}
+}
+
+// Add registers a task, Add must be called before Run.
+func (w *WarmUp) Add(name string, fn func(context.Context) error) {
+	w.mu.Lock()
+	w.tasks = append(w.tasks, &warmUpTask{fn: fn, taskState: taskState{name: name, state: warmUpPending}})
+	w.mu.Unlock()
+}
+
+// Run runs all the tasks concurrently and logs the progress.
+// Run returns when all the tasks are finished (or ctx is done),
+// the returned error joins the errors of the failed tasks.
+func (w *WarmUp) Run(ctx context.Context) error {
+	w.mu.Lock()
+	if w.running {
+		w.mu.Unlock()
+		return errors.New("WarmUp.Run already called")
+	}
+	w.running = true
+	w.started = time.Now()
+	tasks := w.tasks
+	w.mu.Unlock()
+
+	log.Infof("WarmUp: %d tasks", len(tasks))
+
+	var wg sync.WaitGroup
+	for _, t := range tasks {
+		wg.Go(func() { w.run(ctx, t) })
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, t := range tasks {
+		if t.err != nil {
+			errs = append(errs, fmt.Errorf("warm-up %s: %w", t.name, t.err))
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		log.Warnf("WarmUp failed after %v: %v", time.Since(w.started), err)
+	} else {
+		log.Infof("WarmUp done in %v: ready", time.Since(w.started))
+	}
+	return err
+}
+
+func (w *WarmUp) run(ctx context.Context, t *warmUpTask) {
+	w.mu.Lock()
+	t.begin()
+	w.mu.Unlock()
+
+	err := ctx.Err()
+	if err == nil {
+		err = t.fn(ctx)
+	}
+
+	w.mu.Lock()
+	t.finish(err)
+	completed := 0
+	for _, other := range w.tasks {
+		if other.finished() {
+			completed++
+		}
+	}
+	total := len(w.tasks)
+	w.mu.Unlock()
+
+	if err != nil {
+		log.Warnf("WarmUp %d/%d %s failed in %v: %v", completed, total, t.name, t.duration, err)
+	} else {
+		log.Infof("WarmUp %d/%d %s done in %v", completed, total, t.name, t.duration)
+	}
+}
+
+func (t *taskState) begin() {
+	t.state = warmUpRunning
+	t.start = time.Now()
+}
+
+// finish records the result of the task: done, or failed when err is not nil.
+func (t *taskState) finish(err error) {
+	t.duration = time.Since(t.start)
+	t.err = err
+	t.state = warmUpDone
+	if err != nil {
+		t.state = warmUpFailed
+	}
+}
+
+func (t *taskState) finished() bool {
+	return t.state == warmUpDone || t.state == warmUpFailed
+}
+
+// status reports the state, the duration (so far while running) and the error of the task.
+func (t *taskState) status() WarmUpTaskStatus {
+	s := WarmUpTaskStatus{Name: t.name, State: t.state}
+	switch t.state {
+	case warmUpRunning:
+		s.Duration = time.Since(t.start).String()
+	case warmUpDone, warmUpFailed:
+		s.Duration = t.duration.String()
+	}
+	if t.err != nil {
+		s.Error = t.err.Error()
+	}
+	return s
+}
+
+// Ready reports whether all the tasks are successfully done.
+func (w *WarmUp) Ready() bool {
+	return w.Status().Ready
+}
+
+// Status returns the progress and the duration of every task.
+func (w *WarmUp) Status() WarmUpStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := WarmUpStatus{
+		Total: len(w.tasks),
+		Tasks: make([]WarmUpTaskStatus, len(w.tasks)),
+	}
+	if !w.started.IsZero() {
+		s.Elapsed = time.Since(w.started).String()
+	}
+	for i, t := range w.tasks {
+		s.Tasks[i] = t.status()
+		if t.state == warmUpDone {
+			s.Completed++
+		}
+	}
+	s.Ready = s.Completed == s.Total
+	return s
+}
+
+// Probe is a readiness ProbeFunction responding the progress until the warm-up is done.
+func (w *WarmUp) Probe() []byte {
+	s := w.Status()
+	if s.Ready {
+		return nil
+	}
+	b, err := json.Marshal(map[string]WarmUpStatus{"warmup": s})
+	if err != nil {
+		return []byte(`{"warmup":"in progress"}`)
+	}
+	return b
+}
+
+// WithWarmUp makes the readiness endpoint (/ready or /readyz) fail
+// until the warm-up is done, responding the progress of the tasks.
+func WithWarmUp(w *WarmUp) ProbeOption {
+	return WithReadinessProbes(w.Probe)
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+)
+
+func TestWarmUp(t *testing.T) {
+	t.Parallel()
+
+	warm := gc.NewWarmUp()
+	release := make(chan struct{})
+	warm.Add("templates", func(context.Context) error { return nil })
+	warm.Add("cache", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+
+	h := gc.New().ExporterHandler(gc.WithWarmUp(warm))
+	readyz := func() (int, gc.WarmUpStatus) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", http.NoBody))
+		var body struct {
+			WarmUp gc.WarmUpStatus `json:"warmup"`
+		}
+		if rec.Body.Len() > 0 {
+			err := json.Unmarshal(rec.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, body.WarmUp
+	}
+
+	code, status := readyz()
+	if code != http.StatusServiceUnavailable || status.Total != 2 || status.Tasks[0].State != "pending" {
+		t.Fatalf("before Run: code=%d status=%+v", code, status)
+	}
+
+	done := make(chan error)
+	go func() { done <- warm.Run(context.Background()) }()
+
+	for warm.Status().Completed < 1 { // wait for "templates"
+		time.Sleep(time.Millisecond)
+	}
+	code, status = readyz()
+	if code != http.StatusServiceUnavailable || status.Completed != 1 || status.Tasks[1].State != "running" {
+		t.Fatalf("during Run: code=%d status=%+v", code, status)
+	}
+
+	close(release)
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ = readyz()
+	if code != http.StatusOK || !warm.Ready() {
+		t.Fatalf("after Run: code=%d", code)
+	}
+}
+
+func TestWarmUp_Failure(t *testing.T) {
+	t.Parallel()
+
+	errIndex := errors.New("index corrupted")
+	warm := gc.NewWarmUp()
+	warm.Add("index", func(context.Context) error { return errIndex })
+
+	err := warm.Run(context.Background())
+	if !errors.Is(err, errIndex) {
+		t.Fatalf("Run: %v", err)
+	}
+	status := warm.Status()
+	if status.Ready || status.Tasks[0].State != "failed" || status.Tasks[0].Error != errIndex.Error() {
+		t.Errorf("status=%+v", status)
+	}
+	if warm.Probe() == nil {
+		t.Error("a failed warm-up must keep the instance unready")
+	}
+}
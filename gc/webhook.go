@@ -0,0 +1,315 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// WebhookScheme authenticates the webhooks of a provider and extracts the event type.
+	// See GitHubWebhook, GitLabWebhook and StripeWebhook.
+	WebhookScheme struct {
+		// Verify checks the signature of the raw body. The returned timestamp
+		// (zero when the scheme does not sign one) is checked against the replay window.
+		Verify func(r *http.Request, body []byte) (signed time.Time, err error)
+		// Event returns the event type and the delivery ID (empty when unknown).
+		Event func(r *http.Request, body []byte) (typ, id string)
+		// Name identifies the provider in the logs.
+		Name string
+	}
+
+	// WebhookEvent is a verified webhook delivery.
+	WebhookEvent struct {
+		Received time.Time
+		Header   http.Header
+		Type     string
+		ID       string // delivery ID, used to ignore the duplicated deliveries
+		Body     []byte // raw body, as signed by the provider
+	}
+
+	// WebhookFunc processes a WebhookEvent. An error responds 500 so the provider retries later.
+	WebhookFunc func(ctx context.Context, ev WebhookEvent) error
+
+	// WebhookHandler receives the webhooks of a provider, verifies their signature
+	// and dispatches them to the handlers registered by event type:
+	//
+	//	wh := g.NewWebhookHandler(gc.GitHubWebhook(secret))
+	//	gc.OnWebhook(wh, "push", func(ctx context.Context, ev gc.WebhookEvent, push GitHubPush) error {
+	//		return rebuild(ctx, push.Ref)
+	//	})
+	//	mux.Handle("POST /hooks/github", wh)
+	//
+	// The deliveries signed out of the ReplayWindow are rejected, and a delivery ID
+	// already processed during the ReplayWindow is acknowledged without dispatch.
+	// The events without handler (e.g. the GitHub "ping") are acknowledged with 202 Accepted.
+	WebhookHandler struct {
+		handlers map[string]WebhookFunc
+		seen     map[string]time.Time // delivery ID -> processing time
+		scheme   WebhookScheme
+		gw       gg.Writer
+		// ReplayWindow is the tolerance of the signed timestamp and the retention of the delivery IDs (default 5 minutes).
+		ReplayWindow time.Duration
+		// MaxBodySize limits the payload (default 5 MiB).
+		MaxBodySize int64
+		mu          sync.Mutex
+	}
+)
+
+const (
+	defaultReplayWindow   = 5 * time.Minute
+	defaultWebhookBody    = 5 << 20
+	webhookAnyEvent       = "*"
+	maxWebhookDeliveryIDs = 100000
+	errWebhookNoSecret    = "webhook scheme requires a secret"
+)
+
+var errWebhookSignature = errors.New("invalid webhook signature")
+
+// NewWebhookHandler uses the Writer of Garcon to respond the errors.
+func (g *Garcon) NewWebhookHandler(scheme WebhookScheme) *WebhookHandler {
+	return NewWebhookHandler(g.Writer, scheme)
+}
+
+// NewWebhookHandler creates a WebhookHandler, see On and OnWebhook.
+func NewWebhookHandler(gw gg.Writer, scheme WebhookScheme) *WebhookHandler {
+	if scheme.Verify == nil || scheme.Event == nil {
+		log.Panic("NewWebhookHandler requires the Verify and Event functions of the scheme", scheme.Name)
+	}
+	return &WebhookHandler{
+		handlers:     map[string]WebhookFunc{},
+		seen:         map[string]time.Time{},
+		scheme:       scheme,
+		gw:           gw,
+		ReplayWindow: defaultReplayWindow,
+		MaxBodySize:  defaultWebhookBody,
+	}
+}
+
+// On registers the handler of the event type, "*" handles the events without specific handler.
+func (wh *WebhookHandler) On(eventType string, fn WebhookFunc) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if _, ok := wh.handlers[eventType]; ok {
+		log.Panic("WebhookHandler", wh.scheme.Name, "already handles the event", eventType)
+	}
+	wh.handlers[eventType] = fn
+}
+
+// OnWebhook registers a handler receiving the JSON payload decoded into T.
+// A payload not matching T responds 400 Bad Request (the provider should not retry).
+func OnWebhook[T any](wh *WebhookHandler, eventType string, fn func(ctx context.Context, ev WebhookEvent, payload T) error) {
+	wh.On(eventType, func(ctx context.Context, ev WebhookEvent) error {
+		var payload T
+		err := json.Unmarshal(ev.Body, &payload)
+		if err != nil {
+			return gerr.Wrap(err, gerr.Invalid, "Cannot decode the "+ev.Type+" payload")
+		}
+		return fn(ctx, ev, payload)
+	})
+}
+
+// ServeHTTP verifies and dispatches the webhook.
+func (wh *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, wh.MaxBodySize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			wh.gw.WriteErr(w, r, http.StatusRequestEntityTooLarge, "Webhook payload too large", "max", maxErr.Limit)
+			return
+		}
+		wh.gw.WriteErr(w, r, http.StatusBadRequest, "Cannot read the webhook payload")
+		return
+	}
+
+	now := time.Now()
+	signed, err := wh.scheme.Verify(r, body)
+	if err == nil && !signed.IsZero() && (now.Sub(signed) > wh.ReplayWindow || signed.Sub(now) > wh.ReplayWindow) {
+		err = errors.New("signed at " + signed.Format(time.RFC3339) + ", outside the replay window")
+	}
+	if err != nil {
+		log.Warn("WebhookHandler", wh.scheme.Name, "rejects", ipMethodURLSafe(r), err)
+		wh.gw.WriteErr(w, r, http.StatusUnauthorized, "Invalid webhook signature")
+		return
+	}
+
+	ev := WebhookEvent{Received: now, Header: r.Header, Body: body}
+	ev.Type, ev.ID = wh.scheme.Event(r, body)
+
+	fn, duplicate := wh.handler(ev, now)
+	switch {
+	case duplicate:
+		log.Info("WebhookHandler", wh.scheme.Name, "ignores the duplicated delivery", ev.ID)
+		gg.WriteOK(w, "status", "duplicate")
+		return
+	case fn == nil:
+		log.Debug("WebhookHandler", wh.scheme.Name, "no handler for", ev.Type)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	err = runWebhook(r.Context(), fn, ev)
+	if err != nil {
+		log.Warn("WebhookHandler", wh.scheme.Name, ev.Type, ev.ID, err)
+		wh.gw.WriteError(w, r, err)
+		return
+	}
+	wh.markSeen(ev.ID, now)
+	gg.WriteOK(w, "event", ev.Type)
+}
+
+// handler returns the handler of the event, and whether the delivery ID has already been processed.
+func (wh *WebhookHandler) handler(ev WebhookEvent, now time.Time) (WebhookFunc, bool) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if ev.ID != "" {
+		if t, ok := wh.seen[ev.ID]; ok && now.Sub(t) < wh.ReplayWindow {
+			return nil, true
+		}
+	}
+	fn, ok := wh.handlers[ev.Type]
+	if !ok {
+		fn = wh.handlers[webhookAnyEvent]
+	}
+	return fn, false
+}
+
+func (wh *WebhookHandler) markSeen(id string, now time.Time) {
+	if id == "" {
+		return
+	}
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if len(wh.seen) >= maxWebhookDeliveryIDs {
+		for k, t := range wh.seen {
+			if now.Sub(t) >= wh.ReplayWindow {
+				delete(wh.seen, k)
+			}
+		}
+	}
+	wh.seen[id] = now
+}
+
+func runWebhook(ctx context.Context, fn WebhookFunc, ev WebhookEvent) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = gerr.Recovered(v)
+		}
+	}()
+	return fn(ctx, ev)
+}
+
+// GitHubWebhook verifies the header X-Hub-Signature-256 (HMAC-SHA256 of the body).
+// The event type is X-GitHub-Event (push, pull_request, ping...), the ID is X-GitHub-Delivery.
+// Gitea and Forgejo send the same headers.
+func GitHubWebhook(secret []byte) WebhookScheme {
+	if len(secret) == 0 {
+		log.Panic("GitHubWebhook:", errWebhookNoSecret)
+	}
+	return WebhookScheme{
+		Name: "GitHub",
+		Verify: func(r *http.Request, body []byte) (time.Time, error) {
+			sig, ok := strings.CutPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+			if !ok || !validHMAC(secret, body, sig) {
+				return time.Time{}, errWebhookSignature
+			}
+			return time.Time{}, nil
+		},
+		Event: func(r *http.Request, _ []byte) (string, string) {
+			return r.Header.Get("X-GitHub-Event"), r.Header.Get("X-GitHub-Delivery")
+		},
+	}
+}
+
+// GitLabWebhook compares the header X-Gitlab-Token with the secret token (GitLab does not sign the body).
+// The event type is X-Gitlab-Event ("Push Hook", "Tag Push Hook"...), the ID is X-Gitlab-Event-UUID.
+func GitLabWebhook(token string) WebhookScheme {
+	if token == "" {
+		log.Panic("GitLabWebhook:", errWebhookNoSecret)
+	}
+	return WebhookScheme{
+		Name: "GitLab",
+		Verify: func(r *http.Request, _ []byte) (time.Time, error) {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(token)) != 1 {
+				return time.Time{}, errWebhookSignature
+			}
+			return time.Time{}, nil
+		},
+		Event: func(r *http.Request, _ []byte) (string, string) {
+			return r.Header.Get("X-Gitlab-Event"), r.Header.Get("X-Gitlab-Event-UUID")
+		},
+	}
+}
+
+// StripeWebhook verifies the header Stripe-Signature "t=<unix>,v1=<hex>"
+// (HMAC-SHA256 of "<t>.<body>", several v1 during a secret rotation).
+// The signed timestamp is checked against the replay window.
+// The event type and ID are the "type" and "id" fields of the JSON payload.
+func StripeWebhook(secret []byte) WebhookScheme {
+	if len(secret) == 0 {
+		log.Panic("StripeWebhook:", errWebhookNoSecret)
+	}
+	return WebhookScheme{
+		Name: "Stripe",
+		Verify: func(r *http.Request, body []byte) (time.Time, error) {
+			var ts string
+			var sigs []string
+			for item := range strings.SplitSeq(r.Header.Get("Stripe-Signature"), ",") {
+				k, v, _ := strings.Cut(strings.TrimSpace(item), "=")
+				switch k {
+				case "t":
+					ts = v
+				case "v1":
+					sigs = append(sigs, v)
+				}
+			}
+			unix, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return time.Time{}, errWebhookSignature
+			}
+			signed := append([]byte(ts+"."), body...)
+			for _, sig := range sigs {
+				if validHMAC(secret, signed, sig) {
+					return time.Unix(unix, 0), nil
+				}
+			}
+			return time.Time{}, errWebhookSignature
+		},
+		Event: func(_ *http.Request, body []byte) (string, string) {
+			var ev struct {
+				Type string `json:"type"`
+				ID   string `json:"id"`
+			}
+			_ = json.Unmarshal(body, &ev)
+			return ev.Type, ev.ID
+		},
+	}
+}
+
+// validHMAC compares the hexadecimal signature with the HMAC-SHA256 of the data in constant time.
+func validHMAC(secret, data []byte, hexSig string) bool {
+	sig, err := hex.DecodeString(hexSig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
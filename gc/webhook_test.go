@@ -0,0 +1,124 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func hmacHex(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func postWebhook(h http.Handler, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/hook", strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestWebhookHandler_GitHub(t *testing.T) {
+	t.Parallel()
+
+	type push struct {
+		Ref string `json:"ref"`
+	}
+	var refs []string
+	wh := gc.NewWebhookHandler(gg.Writer(""), gc.GitHubWebhook([]byte("s3cr3t")))
+	gc.OnWebhook(wh, "push", func(_ context.Context, _ gc.WebhookEvent, p push) error {
+		refs = append(refs, p.Ref)
+		return nil
+	})
+	wh.On("release", func(context.Context, gc.WebhookEvent) error {
+		return errors.New("database down")
+	})
+
+	body := `{"ref":"refs/heads/main"}`
+	sig := "sha256=" + hmacHex("s3cr3t", body)
+
+	cases := []struct {
+		name, body, sig, event, id string
+		want                       int
+	}{
+		{"valid", body, sig, "push", "d1", http.StatusOK},
+		{"duplicate", body, sig, "push", "d1", http.StatusOK},
+		{"bad signature", body, "sha256=" + hmacHex("wrong", body), "push", "d2", http.StatusUnauthorized},
+		{"tampered", `{"ref":"refs/heads/evil"}`, sig, "push", "d3", http.StatusUnauthorized},
+		{"no handler", body, sig, "ping", "d4", http.StatusAccepted},
+		{"handler error", body, sig, "release", "d5", http.StatusInternalServerError},
+		{"bad payload", `[1]`, "sha256=" + hmacHex("s3cr3t", `[1]`), "push", "d6", http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		w := postWebhook(wh, c.body, "X-Hub-Signature-256", c.sig, "X-GitHub-Event", c.event, "X-GitHub-Delivery", c.id)
+		if w.Code != c.want {
+			t.Errorf("%s: want %d got %d %s", c.name, c.want, w.Code, w.Body)
+		}
+	}
+	if len(refs) != 1 || refs[0] != "refs/heads/main" {
+		t.Errorf("want one dispatched push, got %v", refs)
+	}
+}
+
+func TestWebhookHandler_Stripe(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	wh := gc.NewWebhookHandler(gg.Writer(""), gc.StripeWebhook([]byte("whsec")))
+	wh.On("*", func(_ context.Context, ev gc.WebhookEvent) error {
+		got = append(got, ev.Type+"/"+ev.ID)
+		return nil
+	})
+
+	body := `{"id":"evt_1","type":"invoice.paid"}`
+	sign := func(ts time.Time) string {
+		t := strconv.FormatInt(ts.Unix(), 10)
+		return "t=" + t + ",v1=" + hmacHex("old", t+"."+body) + ",v1=" + hmacHex("whsec", t+"."+body)
+	}
+
+	if w := postWebhook(wh, body, "Stripe-Signature", sign(time.Now())); w.Code != http.StatusOK {
+		t.Errorf("valid: want 200 got %d %s", w.Code, w.Body)
+	}
+	if w := postWebhook(wh, body, "Stripe-Signature", sign(time.Now().Add(-time.Hour))); w.Code != http.StatusUnauthorized {
+		t.Errorf("replayed: want 401 got %d", w.Code)
+	}
+	if w := postWebhook(wh, body, "Stripe-Signature", "v1="+hmacHex("whsec", body)); w.Code != http.StatusUnauthorized {
+		t.Errorf("no timestamp: want 401 got %d", w.Code)
+	}
+	if len(got) != 1 || got[0] != "invoice.paid/evt_1" {
+		t.Errorf("unexpected dispatch %v", got)
+	}
+}
+
+func TestWebhookHandler_GitLab(t *testing.T) {
+	t.Parallel()
+
+	wh := gc.NewWebhookHandler(gg.Writer(""), gc.GitLabWebhook("token"))
+	wh.On("Push Hook", func(context.Context, gc.WebhookEvent) error { return nil })
+
+	if w := postWebhook(wh, "{}", "X-Gitlab-Token", "token", "X-Gitlab-Event", "Push Hook"); w.Code != http.StatusOK {
+		t.Errorf("valid: want 200 got %d", w.Code)
+	}
+	if w := postWebhook(wh, "{}", "X-Gitlab-Token", "nope", "X-Gitlab-Event", "Push Hook"); w.Code != http.StatusUnauthorized {
+		t.Errorf("bad token: want 401 got %d", w.Code)
+	}
+}
@@ -5,7 +5,10 @@
 package gc
 
 import (
+	"errors"
 	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"path"
@@ -20,6 +23,24 @@ import (
 type StaticWebServer struct {
 	Writer gg.Writer
 	Dir    string
+	// NotFoundPage is the document (relative to Dir, e.g. "404.html")
+	// served with the status 404 when the requested file is missing.
+	// Empty means a plain "Not Found" text.
+	NotFoundPage string
+	// ErrorPage is the document (e.g. "50x.html") served with the status 500
+	// when the requested file cannot be read.
+	ErrorPage string
+	// Cache (optional) avoids the redundant Stat syscalls, see WithStatCache.
+	Cache *StatCache
+	// Tuning (optional) adjusts the sending of the large files, see WithSendTuning.
+	Tuning *SendTuning
+	// Normalization (optional) redirects to the canonical URL paths in ServeSite, see WithPathNormalization.
+	Normalization *PathNormalization
+	// Storage (optional) replaces the local filesystem, Dir becomes the key prefix, see WithStorage.
+	Storage Storage
+	// MaxOriginSize limits the size of a file pulled by ServeOrigin.
+	// Zero means 1 GiB.
+	MaxOriginSize int64
 }
 
 // NewStaticWebServer creates a StaticWebServer.
@@ -29,7 +50,51 @@ func (g *Garcon) NewStaticWebServer(dir string) StaticWebServer {
 
 // NewStaticWebServer creates a StaticWebServer.
 func NewStaticWebServer(gw gg.Writer, dir string) StaticWebServer {
-	return StaticWebServer{gw, dir}
+	return StaticWebServer{Writer: gw, Dir: dir}
+}
+
+// WithErrorPages returns a copy of the StaticWebServer serving the custom error documents
+// (relative to Dir) instead of the plain text errors, like Netlify or GitHub Pages do:
+//
+//	ws := g.NewStaticWebServer("dist").WithErrorPages("404.html", "50x.html")
+func (ws StaticWebServer) WithErrorPages(notFound, serverError string) StaticWebServer {
+	ws.NotFoundPage = notFound
+	ws.ErrorPage = serverError
+	return ws
+}
+
+// WithStatCache returns a copy of the StaticWebServer caching the metadata of the files
+// (size, modification time, existence of the *.br and *.avif siblings).
+// The StatCache can be shared by several StaticWebServers:
+//
+//	cache := gc.NewStatCache(time.Second)
+//	err := cache.Watch("dist") // invalidate on the filesystem events
+//	ws := g.NewStaticWebServer("dist").WithStatCache(cache)
+//	// or after a new deployment
+//	cache.Flush()
+func (ws StaticWebServer) WithStatCache(sc *StatCache) StaticWebServer {
+	ws.Cache = sc
+	return ws
+}
+
+// exists reports whether the file may exist: always true without cache and without Storage.
+func (ws *StaticWebServer) exists(r *http.Request, absPath string) bool {
+	if ws.Storage != nil {
+		_, err := ws.Storage.Stat(r.Context(), storageKey(absPath))
+		return err == nil
+	}
+	return ws.Cache == nil || ws.Cache.stat(absPath).err == nil
+}
+
+// isDir reports whether the path is a directory.
+// In a Storage, a directory is a prefix containing an index.html object.
+func (ws *StaticWebServer) isDir(r *http.Request, absPath string) bool {
+	if ws.Storage != nil {
+		_, err := ws.Storage.Stat(r.Context(), storageKey(absPath+"/index.html"))
+		return err == nil
+	}
+	info, err := os.Stat(absPath)
+	return err == nil && info.IsDir()
 }
 
 const avifContentType = "image/avif"
@@ -125,31 +190,136 @@ func (ws *StaticWebServer) ServeAssets() func(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// ServeSite serves a whole static site (e.g. deployed by gitwww):
+// a directory is served with its index.html (short Cache-Control),
+// the CSS and fonts with an aggressive Cache-Control (as ServeAssets),
+// the other files with the Content-Type of their extension.
+// A directory requested without trailing slash is redirected, except with SlashRemove
+// (see WithPathNormalization).
+func (ws *StaticWebServer) ServeSite() func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ws.Writer.TraversalPath(w, r) {
+			return
+		}
+
+		slash := SlashAdd
+		if ws.Normalization != nil {
+			if ws.Normalization.redirect(w, r) {
+				return
+			}
+			slash = ws.Normalization.Slash
+		}
+
+		urlPath := r.URL.Path
+		extPos := extIndex(urlPath)
+		if extPos == len(urlPath) {
+			if !strings.HasSuffix(urlPath, "/") {
+				if ws.isDir(r, path.Join(ws.Dir, urlPath)) {
+					if slash != SlashRemove {
+						http.Redirect(w, r, urlPath+"/", http.StatusMovedPermanently)
+						return
+					}
+					urlPath += "/index.html"
+					extPos = len(urlPath) - len("html")
+				}
+			} else {
+				urlPath += "index.html"
+				extPos = len(urlPath) - len("html")
+			}
+		}
+
+		ext := urlPath[extPos:]
+		contentType := assetContentType(ext)
+		if contentType != "" {
+			w.Header().Set("Cache-Control", "public,max-age=31536000,immutable")
+		} else {
+			w.Header().Set("Cache-Control", "public,max-age=3600")
+			contentType = mime.TypeByExtension("." + ext)
+		}
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+
+		ws.send(w, r, path.Join(ws.Dir, urlPath))
+	}
+}
+
 func (ws *StaticWebServer) openFile(w http.ResponseWriter, r *http.Request, absPath string) (*os.File, string) {
 	// if client (browser) supports Brotli and the *.br file is present
 	// => send the *.br file
 	accept := r.Header.Get("Accept-Encoding")
 	if strings.Contains(accept, "br") {
 		brotli := absPath + ".br"
-		file, err := os.Open(brotli)
-		if err == nil {
-			w.Header().Set("Content-Encoding", "br")
-			return file, brotli
+		if ws.exists(r, brotli) {
+			file, err := os.Open(brotli)
+			if err == nil {
+				w.Header().Set("Content-Encoding", "br")
+				return file, brotli
+			}
 		}
 	}
 
-	file, err := os.Open(absPath)
+	var file *os.File
+	var err error
+	if ws.Cache != nil {
+		err = ws.Cache.stat(absPath).err // do not open a missing file
+	}
+	if err == nil {
+		file, err = os.Open(absPath)
+	}
 	if err != nil {
 		log.Warn("WebServer:", err)
-		http.Error(w, "Not Found", http.StatusNotFound)
-		log.Out("404", r.RemoteAddr, r.Method, absPath, err)
+		status, page := http.StatusNotFound, ws.NotFoundPage
+		if !errors.Is(err, fs.ErrNotExist) {
+			status, page = http.StatusInternalServerError, ws.ErrorPage
+		}
+		ws.writeErrorPage(w, r, status, page)
+		log.Out(strconv.Itoa(status), r.RemoteAddr, r.Method, absPath, err)
 		return nil, ""
 	}
 
 	return file, absPath
 }
 
+// writeErrorPage responds the error document with the status code,
+// or the plain status text when the document is not configured or not readable.
+// The headers set for the requested file (Content-Type, Cache-Control) are replaced.
+func (ws *StaticWebServer) writeErrorPage(w http.ResponseWriter, r *http.Request, status int, page string) {
+	var doc []byte
+	if page != "" {
+		var err error
+		doc, err = ws.readFile(r, path.Join(ws.Dir, page))
+		if err != nil {
+			log.Warn("WebServer: error page", err)
+			doc = nil
+		}
+	}
+
+	if doc == nil {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	h := w.Header()
+	h.Del("Content-Encoding")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Set("Content-Length", strconv.Itoa(len(doc)))
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		_, err := w.Write(doc)
+		if err != nil {
+			log.Warn("WebServer: error page", err)
+		}
+	}
+}
+
 func (ws *StaticWebServer) send(w http.ResponseWriter, r *http.Request, absPath string) {
+	if ws.Storage != nil {
+		ws.sendObject(w, r, absPath)
+		return
+	}
+
 	file, absPath := ws.openFile(w, r, absPath)
 	if file == nil {
 		return
@@ -162,6 +332,7 @@ func (ws *StaticWebServer) send(w http.ResponseWriter, r *http.Request, absPath
 		}
 	}()
 
+	// fstat the opened file: the cached metadata may be stale when the file has been replaced
 	fi, err := file.Stat()
 	if err != nil {
 		log.Warn("WebServer: Stat("+absPath+")", err)
@@ -172,7 +343,12 @@ func (ws *StaticWebServer) send(w http.ResponseWriter, r *http.Request, absPath
 		// to handle the headers Range If-Range Etag and Content-Range.
 	}
 
-	n, err := io.Copy(w, file)
+	var n int64
+	if ws.Tuning != nil {
+		n, err = ws.Tuning.copyFile(w, r, file)
+	} else {
+		n, err = io.Copy(w, file)
+	}
 	if err != nil {
 		log.Warn("WebServer: Copy("+absPath+")", err)
 	} else {
@@ -189,8 +365,15 @@ func (ws *StaticWebServer) avifPath(r *http.Request, extPos int) (absPath string
 	if strings.Contains(accept, avifContentType) {
 		imgFile := r.URL.Path[:extPos] + "avif"
 		absPath = path.Join(ws.Dir, imgFile)
-		_, err := os.Stat(absPath)
-		if err == nil {
+		if ws.Storage != nil {
+			if ws.exists(r, absPath) {
+				return absPath
+			}
+		} else if ws.Cache != nil {
+			if ws.Cache.stat(absPath).err == nil {
+				return absPath
+			}
+		} else if _, err := os.Stat(absPath); err == nil {
 			return absPath
 		}
 	}
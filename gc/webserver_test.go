@@ -6,7 +6,19 @@
 package gc
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
 )
 
 func Test_extIndex(t *testing.T) {
@@ -49,3 +61,202 @@ func Test_extIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestStaticWebServer_ErrorPages(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "404.html"), []byte("<h1>Lost?</h1>"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		page     string
+		wantBody string
+		wantType string
+	}{
+		{"custom", "404.html", "<h1>Lost?</h1>", "text/html; charset=utf-8"},
+		{"missing page", "nope.html", "Not Found\n", "text/plain; charset=utf-8"},
+		{"default", "", "Not Found\n", "text/plain; charset=utf-8"},
+	}
+	for _, c := range cases {
+		ws := NewStaticWebServer(gg.NewWriter(""), dir).WithErrorPages(c.page, "")
+		rec := httptest.NewRecorder()
+		ws.ServeDir("text/css; charset=utf-8")(rec, httptest.NewRequest(http.MethodGet, "/style.css", http.NoBody))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d", c.name, rec.Code)
+		}
+		if rec.Body.String() != c.wantBody {
+			t.Errorf("%s: body %q want %q", c.name, rec.Body.String(), c.wantBody)
+		}
+		if got := rec.Header().Get("Content-Type"); got != c.wantType {
+			t.Errorf("%s: Content-Type %q want %q", c.name, got, c.wantType)
+		}
+	}
+}
+
+// pageStorage is a Storage of in-memory objects,
+// failing with errDisk for the objects whose content is "broken".
+type pageStorage map[string]string
+
+var errDisk = errors.New("disk failure")
+
+func (ps pageStorage) Stat(_ context.Context, key string) (ObjectInfo, error) {
+	content, ok := ps[key]
+	switch {
+	case !ok:
+		return ObjectInfo{}, fs.ErrNotExist
+	case content == "broken":
+		return ObjectInfo{}, errDisk
+	}
+	return ObjectInfo{Size: int64(len(content))}, nil
+}
+
+func (ps pageStorage) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	info, err := ps.Stat(ctx, key)
+	if err != nil {
+		return nil, info, err
+	}
+	return io.NopCloser(strings.NewReader(ps[key])), info, nil
+}
+
+func TestStaticWebServer_ErrorPages_Storage(t *testing.T) {
+	t.Parallel()
+
+	// the error pages exist only in the Storage, not in the local "www" directory
+	storage := pageStorage{
+		"www/404.html":   "<h1>Lost?</h1>",
+		"www/50x.html":   "<h1>Oops</h1>",
+		"www/broken.css": "broken",
+	}
+	ws := NewStaticWebServer(gg.NewWriter(""), "www").WithStorage(storage).WithErrorPages("404.html", "50x.html")
+
+	cases := []struct {
+		urlPath    string
+		wantBody   string
+		wantStatus int
+	}{
+		{"/missing.css", "<h1>Lost?</h1>", http.StatusNotFound},
+		{"/broken.css", "<h1>Oops</h1>", http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		ws.ServeDir("text/css; charset=utf-8")(rec, httptest.NewRequest(http.MethodGet, c.urlPath, http.NoBody))
+		if rec.Code != c.wantStatus || rec.Body.String() != c.wantBody {
+			t.Errorf("%s: got %d %q want %d %q", c.urlPath, rec.Code, rec.Body.String(), c.wantStatus, c.wantBody)
+		}
+	}
+}
+
+func TestStaticWebServer_StatCache(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	css := filepath.Join(dir, "style.css")
+	err := os.WriteFile(css, []byte("body{}"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := NewStatCache(time.Hour)
+	ws := NewStaticWebServer(gg.NewWriter(""), dir).WithStatCache(cache)
+	get := func(urlPath string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, urlPath, http.NoBody)
+		r.Header.Set("Accept-Encoding", "gzip, br")
+		ws.ServeDir("text/css; charset=utf-8")(rec, r)
+		return rec
+	}
+
+	for range 3 {
+		rec := get("/style.css")
+		if rec.Code != http.StatusOK || rec.Body.String() != "body{}" || rec.Header().Get("Content-Length") != "6" {
+			t.Fatalf("status=%d body=%q headers=%v", rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+	// first request: miss for style.css.br and style.css, then only hits (2 lookups per request)
+	if s := cache.Stats(); s.Misses != 2 || s.Hits != 4 || s.Entries != 2 {
+		t.Errorf("stats=%+v", s)
+	}
+
+	// the missing files are cached: no Open syscall
+	if rec := get("/nope.css"); rec.Code != http.StatusNotFound {
+		t.Errorf("status=%d", rec.Code)
+	}
+
+	// a new file is visible only after the invalidation
+	err = os.WriteFile(css+".br", []byte("brotli"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/style.css"); rec.Header().Get("Content-Encoding") != "" {
+		t.Error("want stale cache before Invalidate")
+	}
+	cache.Invalidate(css)
+	if rec := get("/style.css"); rec.Header().Get("Content-Encoding") != "br" || rec.Body.String() != "brotli" {
+		t.Errorf("want the brotli file after Invalidate, got headers=%v body=%q", rec.Header(), rec.Body.String())
+	}
+
+	// Content-Length comes from the opened file, not from the cache
+	err = os.WriteFile(css+".br", []byte("longer brotli"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/style.css"); rec.Header().Get("Content-Length") != "13" || rec.Body.String() != "longer brotli" {
+		t.Errorf("want the size of the replaced file, got headers=%v body=%q", rec.Header(), rec.Body.String())
+	}
+
+	cache.Flush()
+	if s := cache.Stats(); s.Entries != 0 {
+		t.Errorf("stats after Flush=%+v", s)
+	}
+}
+
+func TestStatCache_Watch(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cache := NewStatCache(time.Hour)
+	err := cache.Watch(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cache.Close() })
+
+	page := filepath.Join(dir, "blog", "index.html")
+	if cache.stat(page).err == nil {
+		t.Fatal("the page does not exist yet")
+	}
+
+	// the new directory is watched, then the new file invalidates the cached absence
+	err = os.Mkdir(filepath.Dir(page), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(page, []byte("<html>"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return cache.stat(page).err == nil })
+
+	err = os.Remove(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return cache.stat(page).err != nil })
+}
+
+// waitFor polls cond during one second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for range 100 {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met after one second")
+}
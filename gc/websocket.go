@@ -0,0 +1,631 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc
+
+import (
+	"bufio"
+	"crypto/sha1" //nolint:gosec // required by RFC 6455, not used for security
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// WebSocket opcodes and close codes (RFC 6455).
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+
+	WSCloseNormal       = 1000
+	WSCloseGoingAway    = 1001
+	WSCloseProtocol     = 1002
+	WSCloseInvalidData  = 1007
+	WSClosePolicy       = 1008
+	WSCloseTooBig       = 1009
+	WSCloseInternalErr  = 1011
+	wsAcceptGUID        = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxControlPayload = 125
+)
+
+var (
+	ErrWSClosed       = errors.New("websocket connection closed")
+	ErrWSBackpressure = errors.New("websocket send buffer full (slow client disconnected)")
+)
+
+type (
+	// WebSocketUpgrader upgrades the HTTP requests to WebSocket connections (RFC 6455)
+	// registered in a WSHub. The token (Incorruptible or JWT, cookie or Authorization header)
+	// is validated by the TokenChecker before the upgrade, so the handlers can read
+	// the permissions from the request context of the connection (WSConn.Request).
+	//
+	//	hub := gc.NewWSHub()
+	//	ws := g.NewWebSocketUpgrader(hub, jwtChecker)
+	//	ws.OnMessage = func(c *gc.WSConn, msg []byte) { hub.Broadcast("chat", msg) }
+	//	ws.OnConnect = func(c *gc.WSConn) error { c.Join("chat"); return nil }
+	//	mux.Handle("GET /ws", g.MiddlewareLogDuration()(ws.Handler()))
+	//	g.OnStop("websocket", func(context.Context) error { hub.Close(); return nil })
+	//
+	// The handler returns when the connection is closed:
+	// MiddlewareLogDuration logs the duration of the WebSocket session
+	// and the exporter counts the connection as hijacked.
+	WebSocketUpgrader struct {
+		Hub     *WSHub
+		Checker TokenChecker // optional
+		Writer  gg.Writer
+		// OnConnect is called after the upgrade, a returned error closes the connection.
+		OnConnect func(c *WSConn) error
+		// OnMessage is called for each text or binary message, sequentially per connection.
+		OnMessage func(c *WSConn, msg []byte)
+		// OnClose is called when the connection is closed.
+		OnClose func(c *WSConn)
+		// checkOrigin rejects the cross-site WebSocket hijacking (CSWSH).
+		checkOrigin func(origin string) bool
+		// SendBuffer is the number of messages queued per connection (default 64).
+		SendBuffer int
+		// MaxMessageSize is the maximum size of a received message (default 64 KiB).
+		MaxMessageSize int
+		// PingInterval is the period of the pings, the connection is closed
+		// when nothing is received during two periods (default 30s).
+		PingInterval time.Duration
+		// WriteTimeout bounds the writing of a message (default 10s).
+		WriteTimeout time.Duration
+	}
+
+	// WSHub tracks the connections and their rooms.
+	WSHub struct {
+		conns     map[*WSConn]struct{}
+		rooms     map[string]map[*WSConn]struct{}
+		mu        sync.RWMutex
+		accepted  atomic.Uint64
+		messages  atomic.Uint64
+		broadcast atomic.Uint64
+		dropped   atomic.Uint64
+	}
+
+	// WSHubStats is a snapshot of the WSHub metrics.
+	WSHubStats struct {
+		Connections int    `json:"connections"`
+		Rooms       int    `json:"rooms"`
+		Accepted    uint64 `json:"accepted"`
+		Messages    uint64 `json:"messages"`  // received messages
+		Broadcast   uint64 `json:"broadcast"` // messages queued by Broadcast
+		Dropped     uint64 `json:"dropped"`   // slow clients disconnected
+	}
+
+	// WSConn is an upgraded WebSocket connection.
+	// Send, SendJSON, Join, Leave and Close are safe for concurrent use.
+	WSConn struct {
+		// Request is the upgraded request, its context contains the decoded token.
+		Request *http.Request
+		conn    net.Conn
+		br      *bufio.Reader
+		hub     *WSHub
+		send    chan wsMessage
+		done    chan struct{}
+		rooms   map[string]struct{} // protected by hub.mu
+		u       *WebSocketUpgrader
+		wmu     sync.Mutex
+		once    sync.Once
+	}
+
+	wsMessage struct {
+		data   []byte
+		opcode byte
+	}
+)
+
+// NewWSHub creates an empty WSHub.
+func NewWSHub() *WSHub {
+	return &WSHub{
+		conns: map[*WSConn]struct{}{},
+		rooms: map[string]map[*WSConn]struct{}{},
+	}
+}
+
+// NewWebSocketUpgrader creates a WebSocketUpgrader accepting the origins of Garcon (see WithURLs).
+// The checker (nil for public endpoints) validates the token before the upgrade.
+func (g *Garcon) NewWebSocketUpgrader(hub *WSHub, checker TokenChecker) *WebSocketUpgrader {
+	return NewWebSocketUpgrader(g.Writer, hub, checker, slices.Clone(g.allowedOrigins)...)
+}
+
+// NewWebSocketUpgrader creates a WebSocketUpgrader.
+// The browsers sending an Origin header outside allowedOrigins are rejected (empty means all).
+func NewWebSocketUpgrader(gw gg.Writer, hub *WSHub, checker TokenChecker, allowedOrigins ...string) *WebSocketUpgrader {
+	return &WebSocketUpgrader{
+		Hub:            hub,
+		Checker:        checker,
+		Writer:         gw,
+		checkOrigin:    allowOriginFunc(allowedOrigins),
+		SendBuffer:     64,
+		MaxMessageSize: 64 << 10,
+		PingInterval:   30 * time.Second,
+		WriteTimeout:   10 * time.Second,
+	}
+}
+
+// Handler returns the HTTP handler upgrading the requests,
+// behind the token verification (Vet) when the Checker is set.
+func (u *WebSocketUpgrader) Handler() http.Handler {
+	if u.Checker == nil {
+		return u
+	}
+	return u.Checker.Vet(u)
+}
+
+// ServeHTTP upgrades the request without token verification (see Handler)
+// and serves the connection until it is closed.
+func (u *WebSocketUpgrader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, err := u.checkHandshake(r)
+	if err != nil {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		u.Writer.WriteErr(w, r, http.StatusBadRequest, "WebSocket handshake: "+err.Error())
+		return
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !u.checkOrigin(origin) {
+		u.Writer.WriteErr(w, r, http.StatusForbidden, "WebSocket origin not allowed")
+		return
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		log.Warn("WebSocket hijack", err)
+		u.Writer.WriteErr(w, r, http.StatusInternalServerError, "WebSocket upgrade not supported")
+		return
+	}
+	defer conn.Close()
+
+	// remove the server deadlines (ReadTimeout, WriteTimeout)
+	conn.SetDeadline(time.Time{})
+	_, err = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(key) + "\r\n\r\n")
+	if err == nil {
+		err = brw.Flush()
+	}
+	if err != nil {
+		return
+	}
+
+	c := &WSConn{
+		Request: r,
+		conn:    conn,
+		br:      brw.Reader,
+		hub:     u.Hub,
+		send:    make(chan wsMessage, max(u.SendBuffer, 1)),
+		done:    make(chan struct{}),
+		rooms:   map[string]struct{}{},
+		u:       u,
+	}
+	u.Hub.add(c)
+	defer u.Hub.remove(c)
+
+	if u.OnConnect != nil {
+		err = u.OnConnect(c)
+		if err != nil {
+			c.closeWith(WSClosePolicy, err.Error())
+			return
+		}
+	}
+	if u.OnClose != nil {
+		defer u.OnClose(c)
+	}
+
+	go c.writePump()
+	c.readLoop()
+}
+
+func (u *WebSocketUpgrader) checkHandshake(r *http.Request) (string, error) {
+	switch {
+	case r.Method != http.MethodGet:
+		return "", errors.New("method must be GET")
+	case !headerContainsToken(r.Header, "Connection", "upgrade"):
+		return "", errors.New("missing Connection: Upgrade")
+	case !headerContainsToken(r.Header, "Upgrade", "websocket"):
+		return "", errors.New("missing Upgrade: websocket")
+	case r.Header.Get("Sec-WebSocket-Version") != "13":
+		return "", errors.New("unsupported Sec-WebSocket-Version (want 13)")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 16 {
+		return "", errors.New("invalid Sec-WebSocket-Key")
+	}
+	return key, nil
+}
+
+func headerContainsToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + wsAcceptGUID)) //nolint:gosec // RFC 6455
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Send queues a text message. When the queue of this slow client is full,
+// the connection is closed and Send returns ErrWSBackpressure.
+func (c *WSConn) Send(msg []byte) error {
+	return c.queue(wsMessage{opcode: wsText, data: msg})
+}
+
+// SendBinary queues a binary message (see Send).
+func (c *WSConn) SendBinary(msg []byte) error {
+	return c.queue(wsMessage{opcode: wsBinary, data: msg})
+}
+
+// SendJSON queues v encoded in JSON as a text message.
+func (c *WSConn) SendJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(data)
+}
+
+func (c *WSConn) queue(m wsMessage) error {
+	select {
+	case <-c.done:
+		return ErrWSClosed
+	default:
+	}
+	select {
+	case c.send <- m:
+		return nil
+	default:
+		c.hub.dropped.Add(1)
+		go c.closeWith(WSClosePolicy, "slow consumer")
+		return ErrWSBackpressure
+	}
+}
+
+// Join adds the connection to the room.
+func (c *WSConn) Join(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	members := c.hub.rooms[room]
+	if members == nil {
+		members = map[*WSConn]struct{}{}
+		c.hub.rooms[room] = members
+	}
+	members[c] = struct{}{}
+	c.rooms[room] = struct{}{}
+}
+
+// Leave removes the connection from the room.
+func (c *WSConn) Leave(room string) {
+	c.hub.mu.Lock()
+	defer c.hub.mu.Unlock()
+	c.hub.leave(c, room)
+}
+
+// Close sends the close frame (normal closure) and closes the connection.
+func (c *WSConn) Close() {
+	c.closeWith(WSCloseNormal, "")
+}
+
+func (c *WSConn) closeWith(code int, reason string) {
+	c.once.Do(func() {
+		close(c.done)
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason[:min(len(reason), wsMaxControlPayload-2)]...)
+		_ = c.writeFrame(wsClose, payload)
+		_ = c.conn.Close()
+	})
+}
+
+// writePump sends the queued messages and the pings.
+func (c *WSConn) writePump() {
+	ticker := time.NewTicker(c.u.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case m := <-c.send:
+			err := c.writeFrame(m.opcode, m.data)
+			if err != nil {
+				c.closeWith(WSCloseGoingAway, "")
+				return
+			}
+		case <-ticker.C:
+			err := c.writeFrame(wsPing, nil)
+			if err != nil {
+				c.closeWith(WSCloseGoingAway, "")
+				return
+			}
+		}
+	}
+}
+
+// writeFrame writes an unmasked and unfragmented frame (server to client).
+func (c *WSConn) writeFrame(opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode // FIN
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.u.WriteTimeout))
+	_, err := (&net.Buffers{header, payload}).WriteTo(c.conn)
+	return err
+}
+
+// readLoop reads the messages until the connection is closed.
+func (c *WSConn) readLoop() {
+	var message []byte
+	var messageOpcode byte
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(2 * c.u.PingInterval))
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			var code wsCloseError
+			if errors.As(err, &code) {
+				c.closeWith(int(code), err.Error())
+			} else {
+				c.closeWith(WSCloseGoingAway, "")
+			}
+			return
+		}
+
+		switch opcode {
+		case wsPing:
+			err = c.writeFrame(wsPong, payload)
+			if err != nil {
+				c.closeWith(WSCloseGoingAway, "")
+				return
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.closeWith(closeReplyCode(payload), "")
+			return
+		case wsText, wsBinary:
+			if message != nil {
+				c.closeWith(WSCloseProtocol, "new message before the end of the fragmented one")
+				return
+			}
+			messageOpcode = opcode
+			message = payload
+		case wsContinuation:
+			if message == nil {
+				c.closeWith(WSCloseProtocol, "unexpected continuation frame")
+				return
+			}
+			if len(message)+len(payload) > c.u.MaxMessageSize {
+				c.closeWith(WSCloseTooBig, "message too big")
+				return
+			}
+			message = append(message, payload...)
+		default:
+			c.closeWith(WSCloseProtocol, "unknown opcode")
+			return
+		}
+
+		if !fin {
+			if message == nil {
+				message = []byte{}
+			}
+			continue
+		}
+		if messageOpcode == wsText && !utf8.Valid(message) {
+			c.closeWith(WSCloseInvalidData, "invalid UTF-8")
+			return
+		}
+		c.hub.messages.Add(1)
+		if c.u.OnMessage != nil {
+			c.u.OnMessage(c, message)
+		}
+		message = nil
+	}
+}
+
+// closeReplyCode returns the code echoed to the close frame of the client:
+// the client code when valid, else WSCloseProtocol (RFC 6455 section 7.4),
+// or WSCloseInvalidData when the reason is not UTF-8.
+func closeReplyCode(payload []byte) int {
+	switch len(payload) {
+	case 0:
+		return WSCloseNormal
+	case 1:
+		return WSCloseProtocol
+	}
+	if !utf8.Valid(payload[2:]) {
+		return WSCloseInvalidData
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return code
+	case code >= 3000 && code <= 4999: // registered and private codes
+		return code
+	default: // 1004-1006 and 1015 must not be sent, the others are not assigned
+		return WSCloseProtocol
+	}
+}
+
+type wsCloseError int
+
+func (e wsCloseError) Error() string {
+	switch int(e) {
+	case WSCloseTooBig:
+		return "message too big"
+	default:
+		return "protocol error"
+	}
+}
+
+// readFrame reads a client frame (always masked).
+func (c *WSConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var h [2]byte
+	_, err = io.ReadFull(c.br, h[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	fin = h[0]&0x80 != 0
+	opcode = h[0] & 0x0F
+	if h[0]&0x70 != 0 || h[1]&0x80 == 0 { // reserved bits (no extension) or unmasked
+		return false, 0, nil, wsCloseError(WSCloseProtocol)
+	}
+
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		_, err = io.ReadFull(c.br, ext[:])
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if err != nil {
+		return false, 0, nil, err
+	}
+	if opcode >= wsClose && (n > wsMaxControlPayload || !fin) {
+		return false, 0, nil, wsCloseError(WSCloseProtocol)
+	}
+	if n > uint64(c.u.MaxMessageSize) {
+		return false, 0, nil, wsCloseError(WSCloseTooBig)
+	}
+
+	var mask [4]byte
+	_, err = io.ReadFull(c.br, mask[:])
+	if err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, n)
+	_, err = io.ReadFull(c.br, payload)
+	if err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+func (h *WSHub) add(c *WSConn) {
+	h.mu.Lock()
+	h.conns[c] = struct{}{}
+	h.mu.Unlock()
+	h.accepted.Add(1)
+}
+
+func (h *WSHub) remove(c *WSConn) {
+	c.closeWith(WSCloseGoingAway, "")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.conns, c)
+	for room := range c.rooms {
+		h.leave(c, room)
+	}
+}
+
+// leave removes the connection from the room. The caller must lock h.mu.
+func (h *WSHub) leave(c *WSConn, room string) {
+	delete(c.rooms, room)
+	members := h.rooms[room]
+	delete(members, c)
+	if len(members) == 0 {
+		delete(h.rooms, room)
+	}
+}
+
+// Broadcast queues the text message for all the connections of the room
+// (all the connections when room is empty) and returns the number of recipients.
+// The slow clients are disconnected (see WSConn.Send).
+func (h *WSHub) Broadcast(room string, msg []byte) int {
+	h.mu.RLock()
+	var recipients []*WSConn
+	if room == "" {
+		recipients = make([]*WSConn, 0, len(h.conns))
+		for c := range h.conns {
+			recipients = append(recipients, c)
+		}
+	} else {
+		recipients = make([]*WSConn, 0, len(h.rooms[room]))
+		for c := range h.rooms[room] {
+			recipients = append(recipients, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	n := 0
+	for _, c := range recipients {
+		if c.Send(msg) == nil {
+			n++
+		}
+	}
+	h.broadcast.Add(uint64(n))
+	return n
+}
+
+// Close closes all the connections (going away), for the graceful shutdown.
+func (h *WSHub) Close() {
+	h.mu.RLock()
+	conns := make([]*WSConn, 0, len(h.conns))
+	for c := range h.conns {
+		conns = append(conns, c)
+	}
+	h.mu.RUnlock()
+	for _, c := range conns {
+		c.closeWith(WSCloseGoingAway, "server shutdown")
+	}
+}
+
+// Stats returns the counters since the creation of the WSHub.
+func (h *WSHub) Stats() WSHubStats {
+	h.mu.RLock()
+	s := WSHubStats{Connections: len(h.conns), Rooms: len(h.rooms)}
+	h.mu.RUnlock()
+	s.Accepted = h.accepted.Load()
+	s.Messages = h.messages.Load()
+	s.Broadcast = h.broadcast.Load()
+	s.Dropped = h.dropped.Load()
+	return s
+}
+
+// LogStats prints the counters in the logs.
+func (h *WSHub) LogStats() {
+	s := h.Stats()
+	log.Infof("WSHub connections=%d rooms=%d accepted=%d messages=%d broadcast=%d dropped=%d",
+		s.Connections, s.Rooms, s.Accepted, s.Messages, s.Broadcast, s.Dropped)
+}
+
+func (c *WSConn) String() string {
+	return fmt.Sprintf("WSConn{%s}", c.conn.RemoteAddr())
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gc_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// headerChecker accepts the requests having the header "Token: ok".
+type headerChecker struct{}
+
+func (headerChecker) Set(next http.Handler) http.Handler { return next }
+func (headerChecker) Chk(next http.Handler) http.Handler { return headerChecker{}.Vet(next) }
+func (headerChecker) Cookie(int) *http.Cookie            { return &http.Cookie{} }
+func (headerChecker) Vet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Token") != "ok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type wsClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialWS performs the handshake and returns the client, or the HTTP status on rejection.
+func dialWS(t *testing.T, serverURL string, header ...string) (*wsClient, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(serverURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+	for i := 0; i+1 < len(header); i += 2 {
+		req += header[i] + ": " + header[i+1] + "\r\n"
+	}
+	_, err = conn.Write([]byte(req + "\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, resp.StatusCode
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" { // RFC 6455 example
+		t.Errorf("Sec-WebSocket-Accept=%q", got)
+	}
+	return &wsClient{conn: conn, br: br}, resp.StatusCode
+}
+
+func (c *wsClient) write(opcode byte, payload string) error {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i := range len(payload) {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *wsClient) read() (byte, string, error) {
+	var h [2]byte
+	_, err := io.ReadFull(c.br, h[:])
+	if err != nil {
+		return 0, "", err
+	}
+	n := int(h[1] & 0x7F)
+	if n == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, n)
+	_, err = io.ReadFull(c.br, payload)
+	return h[0] & 0x0F, string(payload), err
+}
+
+func TestWebSocketUpgrader(t *testing.T) {
+	t.Parallel()
+
+	hub := gc.NewWSHub()
+	ws := gc.NewWebSocketUpgrader(gg.NewWriter(""), hub, headerChecker{}, "https://example.com")
+	ws.OnConnect = func(c *gc.WSConn) error {
+		c.Join("chat")
+		return nil
+	}
+	ws.OnMessage = func(c *gc.WSConn, msg []byte) {
+		if string(msg) == "echo" {
+			c.Send(msg)
+			return
+		}
+		hub.Broadcast("chat", msg)
+	}
+	server := httptest.NewServer(ws.Handler())
+	defer server.Close()
+
+	if _, status := dialWS(t, server.URL); status != http.StatusUnauthorized {
+		t.Errorf("want 401 without token, got %d", status)
+	}
+	if _, status := dialWS(t, server.URL, "Token", "ok", "Origin", "https://evil.com"); status != http.StatusForbidden {
+		t.Errorf("want 403 for a foreign origin, got %d", status)
+	}
+
+	a, _ := dialWS(t, server.URL, "Token", "ok", "Origin", "https://example.com")
+	b, _ := dialWS(t, server.URL, "Token", "ok")
+	if a == nil || b == nil {
+		t.Fatal("handshake failed")
+	}
+	defer a.conn.Close()
+	defer b.conn.Close()
+	waitFor(t, func() bool { return hub.Stats().Connections == 2 })
+
+	a.write(0x1, "echo")
+	if op, msg, err := a.read(); err != nil || op != 0x1 || msg != "echo" {
+		t.Errorf("echo: op=%d msg=%q err=%v", op, msg, err)
+	}
+
+	a.write(0x9, "ping")
+	if op, msg, _ := a.read(); op != 0xA || msg != "ping" {
+		t.Errorf("want pong, got op=%d msg=%q", op, msg)
+	}
+
+	a.write(0x1, "hello")
+	for _, c := range []*wsClient{a, b} {
+		if op, msg, err := c.read(); err != nil || op != 0x1 || msg != "hello" {
+			t.Errorf("broadcast: op=%d msg=%q err=%v", op, msg, err)
+		}
+	}
+
+	if n := hub.Broadcast("nobody", []byte("x")); n != 0 {
+		t.Errorf("want 0 recipient, got %d", n)
+	}
+
+	// invalid UTF-8 => close 1007
+	b.write(0x1, "\xff")
+	op, msg, _ := b.read()
+	if op != 0x8 || len(msg) < 2 || binary.BigEndian.Uint16([]byte(msg)) != gc.WSCloseInvalidData {
+		t.Errorf("want close 1007, got op=%d msg=%q", op, msg)
+	}
+	waitFor(t, func() bool { return hub.Stats().Connections == 1 })
+
+	hub.Close()
+	if op, _, _ := a.read(); op != 0x8 {
+		t.Errorf("want close frame on hub.Close, got op=%d", op)
+	}
+	waitFor(t, func() bool { s := hub.Stats(); return s.Connections == 0 && s.Rooms == 0 })
+	if s := hub.Stats(); s.Accepted != 2 || s.Messages != 2 {
+		t.Errorf("stats %+v", s)
+	}
+}
+
+func TestWebSocketUpgrader_closeCode(t *testing.T) {
+	t.Parallel()
+
+	hub := gc.NewWSHub()
+	defer hub.Close()
+	ws := gc.NewWebSocketUpgrader(gg.NewWriter(""), hub, headerChecker{}, "https://example.com")
+	server := httptest.NewServer(ws.Handler())
+	defer server.Close()
+
+	closeFrame := func(code uint16, reason string) string {
+		return string(binary.BigEndian.AppendUint16(nil, code)) + reason
+	}
+
+	cases := []struct {
+		name    string
+		payload string
+		want    uint16
+	}{
+		{"no code", "", gc.WSCloseNormal},
+		{"one byte", "\x03", gc.WSCloseProtocol},
+		{"normal", closeFrame(1000, "bye"), 1000},
+		{"going away", closeFrame(1001, ""), 1001},
+		{"try again later", closeFrame(1013, ""), 1013},
+		{"private", closeFrame(4000, ""), 4000},
+		{"below 1000", closeFrame(999, ""), gc.WSCloseProtocol},
+		{"reserved 1004", closeFrame(1004, ""), gc.WSCloseProtocol},
+		{"no status 1005", closeFrame(1005, ""), gc.WSCloseProtocol},
+		{"abnormal 1006", closeFrame(1006, ""), gc.WSCloseProtocol},
+		{"TLS 1015", closeFrame(1015, ""), gc.WSCloseProtocol},
+		{"unassigned", closeFrame(2000, ""), gc.WSCloseProtocol},
+		{"too high", closeFrame(5000, ""), gc.WSCloseProtocol},
+		{"invalid UTF-8 reason", closeFrame(1000, "\xff"), gc.WSCloseInvalidData},
+	}
+	for _, c := range cases {
+		client, _ := dialWS(t, server.URL, "Token", "ok")
+		if client == nil {
+			t.Fatal("handshake failed")
+		}
+		err := client.write(0x8, c.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		op, msg, _ := client.read()
+		if op != 0x8 || len(msg) < 2 || binary.BigEndian.Uint16([]byte(msg)) != c.want {
+			t.Errorf("%s: want close %d, got op=%d msg=%q", c.name, c.want, op, msg)
+		}
+		client.conn.Close()
+	}
+}
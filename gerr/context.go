@@ -0,0 +1,47 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr
+
+import "context"
+
+// RequestIDHeader is the HTTP header conveying the request ID
+// from the client or the upstream proxy, and to the upstream services.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx conveying the request ID.
+// The request ID is set by the logging middleware (see gc.MiddlewareLogRequest)
+// and lives in gerr so that all Garcon packages can read it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID from the context, or an empty string.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewCtx is like New but also adds the request ID (if any) in the error params.
+func NewCtx(ctx context.Context, code Code, msg string, args ...any) *Error {
+	return wrap(nil, code, msg, appendRequestID(ctx, args)...)
+}
+
+// WrapCtx is like Wrap but also adds the request ID (if any) in the error params.
+func WrapCtx(ctx context.Context, err error, code Code, msg string, args ...any) *Error {
+	return wrap(err, code, msg, appendRequestID(ctx, args)...)
+}
+
+func appendRequestID(ctx context.Context, args []any) []any {
+	id := RequestID(ctx)
+	if id == "" {
+		return args
+	}
+	return append([]any{"request_id", id}, args...)
+}
@@ -64,6 +64,10 @@ const (
 	Timeout
 	// NotFound indicates resource not found errors.
 	NotFound
+	// TooLarge indicates a payload exceeding a size limit.
+	TooLarge
+	// Unsupported indicates an unsupported media type.
+	Unsupported
 )
 
 // New creates a new gerr.Error.
@@ -128,7 +132,7 @@ func (e *Error) Error() string {
 	var builder strings.Builder
 	builder.Write(begin[:len(begin)+1])
 
-	for key, val := range e.Data.Params {
+	for key, val := range RedactParams(e.Data.Params) {
 		builder.WriteByte(byte(' '))
 		builder.WriteString(key)
 		builder.WriteByte(byte('='))
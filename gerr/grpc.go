@@ -0,0 +1,173 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+)
+
+// GRPCCode has the same values as google.golang.org/grpc/codes.Code,
+// so gerr interoperates with gRPC without depending on it:
+//
+//	s := gerr.ToGRPCStatus(err)
+//	details, _ := structpb.NewStruct(s.Details)
+//	st, _ := status.New(codes.Code(s.Code), s.Message).WithDetails(details)
+//
+//	err := gerr.FromGRPCStatus(st.Code(), st.Message(), details.AsMap())
+type GRPCCode uint32
+
+// The gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	GRPCOK GRPCCode = iota
+	GRPCCanceled
+	GRPCUnknown
+	GRPCInvalidArgument
+	GRPCDeadlineExceeded
+	GRPCNotFound
+	GRPCAlreadyExists
+	GRPCPermissionDenied
+	GRPCResourceExhausted
+	GRPCFailedPrecondition
+	GRPCAborted
+	GRPCOutOfRange
+	GRPCUnimplemented
+	GRPCInternal
+	GRPCUnavailable
+	GRPCDataLoss
+	GRPCUnauthenticated
+)
+
+// GRPCStatus contains the fields of a gRPC status.
+// Details contains the error params (redacted) and the gerr code (key "code")
+// to rebuild the same gerr.Error on the other side.
+// The params must be JSON-like values to be converted into a structpb.Struct.
+type GRPCStatus struct {
+	Details map[string]any
+	Message string
+	Code    GRPCCode
+}
+
+// ToGRPCStatus converts err into the fields of a gRPC status.
+// An error that is not a gerr.Error becomes an Internal status.
+func ToGRPCStatus(err error) GRPCStatus {
+	if err == nil {
+		return GRPCStatus{Code: GRPCOK}
+	}
+
+	var gErr *Error
+	if !errors.As(err, &gErr) {
+		gErr = wrap(err, ServerErr, "internal server error")
+	}
+
+	details := make(map[string]any, len(gErr.Data.Params)+1)
+	maps.Copy(details, RedactParams(gErr.Data.Params))
+	details["code"] = int64(gErr.Code)
+
+	return GRPCStatus{
+		Code:    grpcCode(gErr.Code),
+		Message: gErr.Message,
+		Details: details,
+	}
+}
+
+// FromGRPCStatus converts a gRPC status into a gerr.Error.
+// The gerr code is restored from the details (key "code") when registered,
+// else it is deduced from the gRPC code.
+// FromGRPCStatus returns nil for the OK code.
+func FromGRPCStatus[C ~uint32](code C, msg string, details map[string]any) *Error {
+	if GRPCCode(code) == GRPCOK {
+		return nil
+	}
+
+	gCode := codeFromGRPC(GRPCCode(code))
+	params := make(map[string]any, len(details))
+	for k, v := range details {
+		if k != "code" {
+			params[k] = v
+			continue
+		}
+		// structpb converts the numbers into float64
+		var c Code
+		switch n := v.(type) {
+		case float64:
+			c = Code(n)
+		case int64:
+			c = Code(n)
+		case int:
+			c = Code(n)
+		}
+		if _, ok := DefaultRegistry.Lookup(c); ok {
+			gCode = c
+		}
+	}
+
+	err := wrap(nil, gCode, msg)
+	err.Data.Params = params
+	return err
+}
+
+func grpcCode(code Code) GRPCCode {
+	switch code {
+	case Invalid:
+		return GRPCInvalidArgument
+	case ConfigErr:
+		return GRPCFailedPrecondition
+	case UserAbort:
+		return GRPCCanceled
+	case Timeout:
+		return GRPCDeadlineExceeded
+	case NotFound:
+		return GRPCNotFound
+	case TooLarge:
+		return GRPCResourceExhausted
+	case Unsupported:
+		return GRPCInvalidArgument
+	case InferErr, ServerErr:
+		return GRPCInternal
+	}
+
+	// codes registered by the applications: deduce from the HTTP status
+	switch code.Status() {
+	case http.StatusBadRequest:
+		return GRPCInvalidArgument
+	case http.StatusUnauthorized:
+		return GRPCUnauthenticated
+	case http.StatusForbidden:
+		return GRPCPermissionDenied
+	case http.StatusNotFound:
+		return GRPCNotFound
+	case http.StatusConflict:
+		return GRPCAborted
+	case http.StatusTooManyRequests:
+		return GRPCResourceExhausted
+	case http.StatusNotImplemented:
+		return GRPCUnimplemented
+	case http.StatusServiceUnavailable:
+		return GRPCUnavailable
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return GRPCDeadlineExceeded
+	default:
+		return GRPCUnknown
+	}
+}
+
+func codeFromGRPC(code GRPCCode) Code {
+	switch code {
+	case GRPCInvalidArgument, GRPCOutOfRange, GRPCAlreadyExists:
+		return Invalid
+	case GRPCFailedPrecondition:
+		return ConfigErr
+	case GRPCCanceled:
+		return UserAbort
+	case GRPCDeadlineExceeded:
+		return Timeout
+	case GRPCNotFound:
+		return NotFound
+	default:
+		return ServerErr
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+// grpcCode mimics google.golang.org/grpc/codes.Code.
+type grpcCode uint32
+
+func TestGRPCStatus_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		err      error
+		name     string
+		wantGRPC gerr.GRPCCode
+		wantCode gerr.Code
+	}{
+		{gerr.New(gerr.Invalid, "bad email", "field", "email"), "invalid", gerr.GRPCInvalidArgument, gerr.Invalid},
+		{gerr.New(gerr.InferErr, "model crashed"), "infer", gerr.GRPCInternal, gerr.InferErr},
+		{gerr.New(gerr.Timeout, "too slow"), "timeout", gerr.GRPCDeadlineExceeded, gerr.Timeout},
+		{gerr.New(gerr.NotFound, "no user"), "not-found", gerr.GRPCNotFound, gerr.NotFound},
+		{errors.New("plain"), "plain", gerr.GRPCInternal, gerr.ServerErr},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := gerr.ToGRPCStatus(c.err)
+			if s.Code != c.wantGRPC {
+				t.Errorf("gRPC code=%d want %d", s.Code, c.wantGRPC)
+			}
+
+			// simulate the structpb conversion of the numbers
+			details := make(map[string]any, len(s.Details))
+			for k, v := range s.Details {
+				if n, ok := v.(int64); ok {
+					details[k] = float64(n)
+				} else {
+					details[k] = v
+				}
+			}
+
+			got := gerr.FromGRPCStatus(grpcCode(s.Code), s.Message, details)
+			if got.Code != c.wantCode {
+				t.Errorf("gerr code=%v want %v", got.Code, c.wantCode)
+			}
+			if _, ok := got.Data.Params["code"]; ok {
+				t.Error("the code must not remain in the params")
+			}
+			var want *gerr.Error
+			if errors.As(c.err, &want) {
+				if got.Message != want.Message || len(got.Data.Params) != len(want.Data.Params) {
+					t.Errorf("got %v want %v", got, want)
+				}
+			}
+		})
+	}
+
+	if gerr.FromGRPCStatus(grpcCode(gerr.GRPCOK), "", nil) != nil {
+		t.Error("OK status must give a nil error")
+	}
+	if got := gerr.FromGRPCStatus(grpcCode(gerr.GRPCUnavailable), "down", nil); got.Code != gerr.ServerErr {
+		t.Errorf("Unavailable without details: got %v", got.Code)
+	}
+}
@@ -20,20 +20,12 @@ func HttpError(err error) (int, error) {
 	return statusCode(gErr.Code), gErr
 }
 
-// statusCode deduce the HTTP status code from an ErrorType.
+// statusCode deduce the HTTP status code from an ErrorType
+// registered in the DefaultRegistry.
 func statusCode(errType Code) int {
-	switch errType {
-	case Invalid:
-		return http.StatusBadRequest
-	case NotFound:
-		return http.StatusNotFound
-	case Timeout:
-		return http.StatusRequestTimeout
-	case UserAbort:
-		return http.StatusNoContent
-	case ConfigErr, InferErr, ServerErr:
-		fallthrough
-	default:
-		return http.StatusInternalServerError
+	info, ok := DefaultRegistry.Lookup(errType)
+	if ok && info.Status != 0 {
+		return info.Status
 	}
+	return http.StatusInternalServerError
 }
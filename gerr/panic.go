@@ -0,0 +1,78 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxStackDepth limits the number of frames captured by Recovered.
+const maxStackDepth = 32
+
+// Recovered converts the value returned by recover() into a ServerErr.
+// Function and FileLine locate the origin of the panic
+// (the first frame outside the Go runtime)
+// and Params["stack"] contains the call stack, one frame per line.
+// The panic value is the Cause, and also Params["panic"] when it is not an error.
+// Recovered must be called from the deferred function.
+func Recovered(v any) *Error {
+	cause, ok := v.(error)
+	if !ok {
+		cause = errors.New(fmt.Sprint(v))
+	}
+
+	err := &Error{
+		Code:    ServerErr,
+		Message: "internal server error",
+		Data: Data{
+			Time:   time.Now(),
+			Cause:  cause,
+			Params: map[string]any{"panic": cause.Error()},
+		},
+	}
+
+	var pcs [maxStackDepth]uintptr
+	n := runtime.Callers(2, pcs[:]) // skip [runtime.Callers, Recovered]
+	frames := runtime.CallersFrames(pcs[:n])
+
+	// skip the deferred function until runtime.gopanic (if any)
+	var stack []runtime.Frame
+	for {
+		f, more := frames.Next()
+		if f.Function == "runtime.gopanic" {
+			stack = stack[:0]
+		} else {
+			stack = append(stack, f)
+		}
+		if !more {
+			break
+		}
+	}
+
+	var sb strings.Builder
+	for _, f := range stack {
+		if strings.HasPrefix(f.Function, "runtime.") {
+			continue
+		}
+		if err.Data.Function == "" {
+			err.Data.Function = f.Function
+			err.Data.FileLine = f.File + ":" + strconv.Itoa(f.Line)
+		}
+		sb.WriteString(f.Function)
+		sb.WriteByte(' ')
+		sb.WriteString(f.File)
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(f.Line))
+		sb.WriteByte('\n')
+	}
+	err.Data.Params["stack"] = sb.String()
+
+	return err
+}
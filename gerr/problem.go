@@ -0,0 +1,54 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ProblemContentType is the media type of the RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem is the "problem details" object defined by RFC 7807.
+// Code and RequestID are extension members
+// preserving the gerr information for the clients.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	Status    int    `json:"status"`
+	Code      Code   `json:"code,omitempty"`
+}
+
+// ProblemDetails converts err into a RFC 7807 problem.
+// The Type is "about:blank", so the Title is the HTTP status text.
+// The Detail is the gerr message, the params are not exposed
+// (except the request ID) because they may contain internal information.
+// Instance is left empty, the caller may set it to the request path.
+func ProblemDetails(err error) *Problem {
+	status, e := HttpError(err)
+	gErr, _ := e.(*Error)
+
+	p := &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: gErr.Message,
+		Code:   gErr.Code,
+	}
+	if id, ok := gErr.Data.Params["request_id"].(string); ok {
+		p.RequestID = id
+	}
+	return p
+}
+
+// AcceptsProblem reports whether the Accept header of the request
+// prefers the problem details format.
+func AcceptsProblem(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ProblemContentType)
+}
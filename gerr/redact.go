@@ -0,0 +1,97 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the sensitive values in Error() and in the JSON payloads.
+const Redacted = "[REDACTED]"
+
+// secret wraps a sensitive value, see Secret.
+type secret struct{ v any }
+
+//nolint:gochecknoglobals // global redaction list, see RedactKeys
+var (
+	redactMu   sync.RWMutex
+	redactKeys = []string{"password", "passwd", "secret", "token", "authorization", "cookie", "apikey", "api_key", "private_key"}
+)
+
+// Secret marks a param value as sensitive: the key remains visible
+// but the value is replaced by [REDACTED] when printed or serialized.
+//
+//	gerr.New(gerr.Invalid, "cannot login", "user", user, "otp", gerr.Secret(otp))
+func Secret(v any) any { return secret{v} }
+
+// Reveal returns the original value wrapped by Secret, else v.
+func Reveal(v any) any {
+	if s, ok := v.(secret); ok {
+		return s.v
+	}
+	return v
+}
+
+// RedactKeys extends the global redaction list.
+// A param is redacted when its key contains one of the words (case-insensitive).
+// The default list is: password, passwd, secret, token, authorization, cookie, apikey, api_key, private_key.
+func RedactKeys(words ...string) {
+	redactMu.Lock()
+	defer redactMu.Unlock()
+	for _, w := range words {
+		redactKeys = append(redactKeys, strings.ToLower(w))
+	}
+}
+
+// IsSensitiveKey reports whether the param key matches the redaction list.
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	for _, w := range redactKeys {
+		if strings.Contains(key, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactParams returns the params with the sensitive values replaced by [REDACTED].
+// RedactParams returns the same map when nothing is sensitive.
+func RedactParams(params map[string]any) map[string]any {
+	var redacted map[string]any
+	for k, v := range params {
+		_, isSecret := v.(secret)
+		if !isSecret && !IsSensitiveKey(k) {
+			continue
+		}
+		if redacted == nil {
+			redacted = maps.Clone(params)
+		}
+		redacted[k] = Redacted
+	}
+	if redacted == nil {
+		return params
+	}
+	return redacted
+}
+
+// Format prints [REDACTED] whatever the verb.
+func (secret) Format(f fmt.State, _ rune) { _, _ = f.Write([]byte(Redacted)) }
+
+// MarshalJSON serializes [REDACTED].
+func (secret) MarshalJSON() ([]byte, error) { return json.Marshal(Redacted) }
+
+// MarshalJSON serializes the Data with the sensitive params redacted.
+func (d Data) MarshalJSON() ([]byte, error) {
+	type data Data // avoid the recursion
+	dd := data(d)
+	dd.Params = RedactParams(d.Params)
+	return json.Marshal(dd)
+}
@@ -0,0 +1,54 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+func TestRedaction(t *testing.T) {
+	t.Parallel()
+
+	err := gerr.New(gerr.Invalid, "cannot login",
+		"user", "jane",
+		"Password", "hunter2",
+		"access_token", "eyJhbGciOi",
+		"otp", gerr.Secret(123456))
+
+	js, e := json.Marshal(err)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	for name, out := range map[string]string{
+		"Error()": err.Error(),
+		"JSON":    string(js),
+		"%v":      fmt.Sprintf("%v %+v %#v", err.Data.Params["otp"], err.Data.Params["otp"], err.Data.Params["otp"]),
+	} {
+		for _, leak := range []string{"hunter2", "eyJhbGciOi", "123456"} {
+			if strings.Contains(out, leak) {
+				t.Errorf("%s leaks %q: %s", name, leak, out)
+			}
+		}
+	}
+
+	for _, key := range []string{"user", "Password", "access_token", "otp", "jane", gerr.Redacted} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Error() should contain %q: %s", key, err.Error())
+		}
+	}
+
+	if gerr.Reveal(err.Data.Params["otp"]) != 123456 {
+		t.Error("Reveal should return the original value")
+	}
+	if err.Data.Params["Password"] != "hunter2" {
+		t.Error("the params must not be modified")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+type (
+	// Registry is a catalog of the error codes used by the services.
+	// Each application reserves its own range of codes,
+	// then registers its codes with a stable name and a default HTTP status.
+	// The same Registry content should be shared by all the services of an API
+	// to emit consistent JSON-RPC error codes.
+	Registry struct {
+		codes  map[Code]CodeInfo
+		ranges []CodeRange
+		mu     sync.RWMutex
+	}
+
+	// CodeInfo describes a registered Code.
+	CodeInfo struct {
+		Name   string `json:"name"`
+		Status int    `json:"status"`
+	}
+
+	// CodeRange is a range of codes (Min and Max included) reserved by an Owner.
+	CodeRange struct {
+		Owner string `json:"owner"`
+		Min   Code   `json:"min"`
+		Max   Code   `json:"max"`
+	}
+)
+
+var (
+	ErrCodeRange      = errors.New("invalid or overlapping code range")
+	ErrCodeOutOfRange = errors.New("code is not within a registered range")
+	ErrCodeDuplicate  = errors.New("code already registered")
+
+	// DefaultRegistry contains the Garcon codes and is used by Code.String() and HttpError().
+	//
+	//nolint:gochecknoglobals // the default registry is global by design
+	DefaultRegistry = NewRegistry()
+)
+
+// NewRegistry creates a Registry containing the Garcon codes (Invalid to Unsupported).
+// The range of the owner "garcon" is -32149 to -32141:
+// RegisterRange rejects the ranges overlapping it (see ErrCodeRange).
+func NewRegistry() *Registry {
+	reg := &Registry{codes: map[Code]CodeInfo{}}
+	reg.ranges = []CodeRange{{Owner: "garcon", Min: Invalid, Max: Unsupported}}
+	reg.codes[Invalid] = CodeInfo{"Invalid", http.StatusBadRequest}
+	reg.codes[ConfigErr] = CodeInfo{"ConfigErr", http.StatusInternalServerError}
+	reg.codes[InferErr] = CodeInfo{"InferErr", http.StatusInternalServerError}
+	reg.codes[UserAbort] = CodeInfo{"UserAbort", http.StatusNoContent}
+	reg.codes[ServerErr] = CodeInfo{"ServerErr", http.StatusInternalServerError}
+	reg.codes[Timeout] = CodeInfo{"Timeout", http.StatusRequestTimeout}
+	reg.codes[NotFound] = CodeInfo{"NotFound", http.StatusNotFound}
+	reg.codes[TooLarge] = CodeInfo{"TooLarge", http.StatusRequestEntityTooLarge}
+	reg.codes[Unsupported] = CodeInfo{"Unsupported", http.StatusUnsupportedMediaType}
+	return reg
+}
+
+// RegisterRange reserves the codes from lowest to highest (included) for the owner.
+func (reg *Registry) RegisterRange(owner string, lowest, highest Code) error {
+	if lowest > highest {
+		return fmt.Errorf("%w: %s [%d..%d]", ErrCodeRange, owner, lowest, highest)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	for _, r := range reg.ranges {
+		if lowest <= r.Max && r.Min <= highest {
+			return fmt.Errorf("%w: %s [%d..%d] overlaps %s [%d..%d]",
+				ErrCodeRange, owner, lowest, highest, r.Owner, r.Min, r.Max)
+		}
+	}
+
+	reg.ranges = append(reg.ranges, CodeRange{Owner: owner, Min: lowest, Max: highest})
+	return nil
+}
+
+// Register names a code and sets its default HTTP status.
+// The code must be within a range registered by RegisterRange.
+func (reg *Registry) Register(code Code, name string, status int) error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if _, ok := reg.codes[code]; ok {
+		return fmt.Errorf("%w: %d", ErrCodeDuplicate, code)
+	}
+	if reg.rangeOf(code) == nil {
+		return fmt.Errorf("%w: %d %s", ErrCodeOutOfRange, code, name)
+	}
+
+	reg.codes[code] = CodeInfo{Name: name, Status: status}
+	return nil
+}
+
+// MustRegister is like Register but panics on error, convenient for package initialization.
+func (reg *Registry) MustRegister(code Code, name string, status int) Code {
+	err := reg.Register(code, name, status)
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+// Lookup returns the name and HTTP status of the code.
+func (reg *Registry) Lookup(code Code) (CodeInfo, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	info, ok := reg.codes[code]
+	return info, ok
+}
+
+// Owner returns the owner of the range containing the code.
+func (reg *Registry) Owner(code Code) string {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	if r := reg.rangeOf(code); r != nil {
+		return r.Owner
+	}
+	return ""
+}
+
+// Catalog returns a copy of all the registered codes, for documentation or API discovery.
+func (reg *Registry) Catalog() map[Code]CodeInfo {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	catalog := make(map[Code]CodeInfo, len(reg.codes))
+	for code, info := range reg.codes {
+		catalog[code] = info
+	}
+	return catalog
+}
+
+func (reg *Registry) rangeOf(code Code) *CodeRange {
+	for i := range reg.ranges {
+		if reg.ranges[i].Min <= code && code <= reg.ranges[i].Max {
+			return &reg.ranges[i]
+		}
+	}
+	return nil
+}
+
+// RegisterRange reserves a range of codes in the DefaultRegistry.
+func RegisterRange(owner string, lowest, highest Code) error {
+	return DefaultRegistry.RegisterRange(owner, lowest, highest)
+}
+
+// Register a code in the DefaultRegistry.
+func Register(code Code, name string, status int) error {
+	return DefaultRegistry.Register(code, name, status)
+}
+
+// String returns the registered name of the code,
+// or the number for the unregistered codes.
+func (c Code) String() string {
+	info, ok := DefaultRegistry.Lookup(c)
+	if ok {
+		return info.Name
+	}
+	return "Code(" + strconv.FormatInt(int64(c), 10) + ")"
+}
+
+// Status returns the default HTTP status of the code.
+func (c Code) Status() int {
+	return statusCode(c)
+}
+
+// As finds the first gerr.Error in the err chain.
+func As(err error) (*Error, bool) {
+	var gErr *Error
+	ok := errors.As(err, &gErr)
+	return gErr, ok
+}
+
+// Is reports whether a gerr.Error in the err chain has the code.
+func Is(err error, code Code) bool {
+	for err != nil {
+		gErr, ok := As(err)
+		if !ok {
+			return false
+		}
+		if gErr.Code == code {
+			return true
+		}
+		err = gErr.Data.Cause
+	}
+	return false
+}
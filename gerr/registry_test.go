@@ -0,0 +1,98 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gerr_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Parallel()
+
+	reg := gerr.NewRegistry()
+
+	err := reg.RegisterRange("billing", -31000, -30900)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = reg.RegisterRange("shop", -30950, -30800)
+	if !errors.Is(err, gerr.ErrCodeRange) {
+		t.Errorf("overlapping range: want ErrCodeRange, got %v", err)
+	}
+
+	// the "garcon" range is Invalid (-32149) to Unsupported (-32141)
+	err = reg.RegisterRange("app", gerr.Unsupported, -32100)
+	if !errors.Is(err, gerr.ErrCodeRange) || reg.Owner(-32141) != "garcon" {
+		t.Errorf("range overlapping the Garcon codes: want ErrCodeRange, got %v", err)
+	}
+	err = reg.RegisterRange("app", gerr.Unsupported+1, -32100)
+	if err != nil {
+		t.Errorf("range just above the Garcon codes: %v", err)
+	}
+
+	const paymentRequired gerr.Code = -31000
+	err = reg.Register(paymentRequired, "PaymentRequired", http.StatusPaymentRequired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = reg.Register(paymentRequired, "Again", http.StatusPaymentRequired)
+	if !errors.Is(err, gerr.ErrCodeDuplicate) {
+		t.Errorf("want ErrCodeDuplicate, got %v", err)
+	}
+
+	err = reg.Register(-20000, "Outside", http.StatusTeapot)
+	if !errors.Is(err, gerr.ErrCodeOutOfRange) {
+		t.Errorf("want ErrCodeOutOfRange, got %v", err)
+	}
+
+	info, ok := reg.Lookup(paymentRequired)
+	if !ok || info.Name != "PaymentRequired" || info.Status != http.StatusPaymentRequired {
+		t.Errorf("Lookup = %+v %v", info, ok)
+	}
+	if reg.Owner(-30950) != "billing" || reg.Owner(gerr.NotFound) != "garcon" {
+		t.Error("unexpected owner")
+	}
+}
+
+func TestCode_String(t *testing.T) {
+	t.Parallel()
+
+	if gerr.NotFound.String() != "NotFound" {
+		t.Errorf("got %q", gerr.NotFound.String())
+	}
+	if gerr.Code(-1).String() != "Code(-1)" {
+		t.Errorf("got %q", gerr.Code(-1).String())
+	}
+	if gerr.Timeout.Status() != http.StatusRequestTimeout {
+		t.Errorf("got %d", gerr.Timeout.Status())
+	}
+}
+
+func TestIsAs(t *testing.T) {
+	t.Parallel()
+
+	inner := gerr.New(gerr.NotFound, "no such item")
+	outer := gerr.Wrap(inner, gerr.ServerErr, "cannot process")
+	err := fmt.Errorf("handler: %w", outer)
+
+	if !gerr.Is(err, gerr.ServerErr) || !gerr.Is(err, gerr.NotFound) {
+		t.Error("Is must find both codes in the chain")
+	}
+	if gerr.Is(err, gerr.Timeout) || gerr.Is(errors.New("plain"), gerr.Timeout) {
+		t.Error("Is must not find Timeout")
+	}
+
+	gErr, ok := gerr.As(err)
+	if !ok || gErr.Code != gerr.ServerErr {
+		t.Errorf("As = %v %v", gErr, ok)
+	}
+}
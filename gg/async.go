@@ -0,0 +1,207 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotifierQueueFull is returned by AsyncNotifier.Notify when the message is dropped.
+var ErrNotifierQueueFull = errors.New("notifier queue full")
+
+// ErrNotifierClosed is returned by AsyncNotifier.Notify after Close.
+var ErrNotifierClosed = errors.New("notifier closed")
+
+type (
+	// AsyncNotifier wraps a Notifier to send the messages in background,
+	// so the HTTP handlers are not blocked by the remote hook.
+	// The messages are queued (bounded queue) and sent one by one,
+	// retrying with an exponential backoff. The messages that cannot be sent
+	// are passed to DeadLetter (default: logged, see DeadLetterFile to replay them).
+	// The fields must be set before the first call to Notify.
+	AsyncNotifier struct {
+		next Notifier
+		// DeadLetter receives the messages dropped (queue full)
+		// or failed after all the retries.
+		DeadLetter func(msg string, err error)
+		// Delivered (optional) is the delivery receipt of the sent messages,
+		// attempts is 1 when the message is sent without retry.
+		Delivered func(msg string, attempts int)
+		queue     chan string
+		stop      chan struct{}
+		done      chan struct{}
+		start     sync.Once
+		closeOnce sync.Once
+		stopOnce  sync.Once
+		// Timeout limits the duration of one Notify call of the wrapped Notifier.
+		Timeout time.Duration
+		// Backoff is the delay before the first retry, doubled at each retry up to MaxBackoff.
+		Backoff    time.Duration
+		MaxBackoff time.Duration
+		MaxRetries int
+		mu         sync.RWMutex // protects queue sending against close
+		closed     bool
+		sent       atomic.Uint64
+		retries    atomic.Uint64
+		failed     atomic.Uint64
+		dropped    atomic.Uint64
+	}
+
+	// AsyncNotifierStats is a snapshot of the AsyncNotifier counters.
+	AsyncNotifierStats struct {
+		Sent    uint64 `json:"sent"`
+		Retries uint64 `json:"retries"`
+		Failed  uint64 `json:"failed"`  // dead letters after all retries
+		Dropped uint64 `json:"dropped"` // dead letters because queue full
+		Queued  int    `json:"queued"`
+	}
+)
+
+// NewAsyncNotifier wraps the Notifier with a queue of queueSize messages (default 100).
+// Default settings: 5 retries, backoff from 1s to 1min, timeout 30s.
+func NewAsyncNotifier(n Notifier, queueSize int) *AsyncNotifier {
+	if queueSize <= 0 {
+		queueSize = 100
+	}
+	return &AsyncNotifier{
+		next:       n,
+		queue:      make(chan string, queueSize),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+		Timeout:    30 * time.Second,
+		Backoff:    time.Second,
+		MaxBackoff: time.Minute,
+		MaxRetries: 5,
+		DeadLetter: func(msg string, err error) {
+			log.Warn("AsyncNotifier dead letter:", err, "msg:", sanitize(msg))
+		},
+	}
+}
+
+// Notify queues the message without waiting.
+// When the queue is full, the message is passed to DeadLetter
+// and ErrNotifierQueueFull is returned.
+func (a *AsyncNotifier) Notify(msg string) error {
+	a.start.Do(func() { go a.run() })
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return ErrNotifierClosed
+	}
+
+	select {
+	case a.queue <- msg:
+		return nil
+	default:
+		a.dropped.Add(1)
+		a.DeadLetter(msg, ErrNotifierQueueFull)
+		return ErrNotifierQueueFull
+	}
+}
+
+// Close stops accepting messages and sends the queued ones.
+// When ctx is done before the end, the remaining messages
+// are passed to DeadLetter and ctx.Err() is returned.
+func (a *AsyncNotifier) Close(ctx context.Context) error {
+	a.closeOnce.Do(func() {
+		a.start.Do(func() { go a.run() })
+		a.mu.Lock()
+		a.closed = true
+		close(a.queue)
+		a.mu.Unlock()
+	})
+
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		a.stopOnce.Do(func() { close(a.stop) })
+		<-a.done
+		return ctx.Err()
+	}
+}
+
+// Stats returns the counters since the creation of the AsyncNotifier.
+func (a *AsyncNotifier) Stats() AsyncNotifierStats {
+	return AsyncNotifierStats{
+		Sent:    a.sent.Load(),
+		Retries: a.retries.Load(),
+		Failed:  a.failed.Load(),
+		Dropped: a.dropped.Load(),
+		Queued:  len(a.queue),
+	}
+}
+
+func (a *AsyncNotifier) run() {
+	defer close(a.done)
+	for msg := range a.queue {
+		select {
+		case <-a.stop:
+			a.failed.Add(1)
+			a.DeadLetter(msg, ErrNotifierClosed)
+			continue
+		default:
+		}
+		a.send(msg)
+	}
+}
+
+// send tries to send the message with retries.
+func (a *AsyncNotifier) send(msg string) {
+	backoff := a.Backoff
+	var err error
+	for try := 0; ; try++ {
+		err = a.notify(msg)
+		if err == nil {
+			a.sent.Add(1)
+			if a.Delivered != nil {
+				a.Delivered(msg, try+1)
+			}
+			return
+		}
+		if try >= a.MaxRetries {
+			break
+		}
+
+		a.retries.Add(1)
+		select {
+		case <-time.After(backoff):
+		case <-a.stop:
+			a.failed.Add(1)
+			a.DeadLetter(msg, errors.Join(err, ErrNotifierClosed))
+			return
+		}
+		backoff = min(2*backoff, max(a.MaxBackoff, a.Backoff))
+	}
+
+	a.failed.Add(1)
+	a.DeadLetter(msg, err)
+}
+
+// notify calls the wrapped Notifier within the Timeout.
+// The Notifier interface has no context: after the timeout,
+// the call is abandoned (it ends in background).
+func (a *AsyncNotifier) notify(msg string) error {
+	if a.Timeout <= 0 {
+		return a.next.Notify(msg)
+	}
+
+	result := make(chan error, 1)
+	go func() { result <- a.next.Notify(msg) }()
+
+	timer := time.NewTimer(a.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		return context.DeadlineExceeded
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// flakyNotifier fails the first calls.
+type flakyNotifier struct {
+	sent     []string
+	failures int
+	delay    time.Duration
+	mu       sync.Mutex
+}
+
+func (n *flakyNotifier) Notify(msg string) error {
+	time.Sleep(n.delay)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.failures > 0 {
+		n.failures--
+		return errors.New("hook unavailable")
+	}
+	n.sent = append(n.sent, msg)
+	return nil
+}
+
+func newTestAsync(next gg.Notifier, size int) (*gg.AsyncNotifier, *[]string) {
+	var dead []string
+	var mu sync.Mutex
+	a := gg.NewAsyncNotifier(next, size)
+	a.Backoff = time.Millisecond
+	a.MaxBackoff = 4 * time.Millisecond
+	a.DeadLetter = func(msg string, _ error) {
+		mu.Lock()
+		dead = append(dead, msg)
+		mu.Unlock()
+	}
+	return a, &dead
+}
+
+func TestAsyncNotifier_retry(t *testing.T) {
+	t.Parallel()
+
+	flaky := &flakyNotifier{failures: 2}
+	a, dead := newTestAsync(flaky, 10)
+	a.MaxRetries = 3
+
+	for _, msg := range []string{"a", "b", "c"} {
+		err := a.Notify(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := a.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flaky.sent) != 3 || len(*dead) != 0 {
+		t.Errorf("sent=%v dead=%v", flaky.sent, *dead)
+	}
+	s := a.Stats()
+	if s.Sent != 3 || s.Retries != 2 || s.Failed != 0 {
+		t.Errorf("stats=%+v", s)
+	}
+	if !errors.Is(a.Notify("late"), gg.ErrNotifierClosed) {
+		t.Error("Notify after Close must fail")
+	}
+}
+
+func TestAsyncNotifier_deadLetters(t *testing.T) {
+	t.Parallel()
+
+	// always failing after the timeout
+	slow := &flakyNotifier{failures: 1000, delay: 20 * time.Millisecond}
+	a, dead := newTestAsync(slow, 1)
+	a.MaxRetries = 1
+	a.Timeout = 5 * time.Millisecond
+
+	_ = a.Notify("first")
+	time.Sleep(2 * time.Millisecond) // let the worker take "first"
+	_ = a.Notify("second")           // queued
+	err := a.Notify("third")         // queue full
+	if !errors.Is(err, gg.ErrNotifierQueueFull) {
+		t.Fatalf("want ErrNotifierQueueFull, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = a.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := a.Stats()
+	if s.Dropped != 1 || s.Failed != 2 || len(*dead) != 3 {
+		t.Errorf("stats=%+v dead=%v", s, *dead)
+	}
+}
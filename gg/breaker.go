@@ -0,0 +1,319 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BreakerState is the state of the circuit of a host.
+type BreakerState int32
+
+const (
+	// StateClosed lets the requests pass, the failures are counted.
+	StateClosed BreakerState = iota
+	// StateOpen rejects the requests with ErrCircuitOpen during OpenTimeout.
+	StateOpen
+	// StateHalfOpen lets HalfOpenRequests probes pass: their success closes the circuit,
+	// a single failure opens it again.
+	StateHalfOpen
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type (
+	// CircuitBreaker stops sending requests to a failing host,
+	// so the callers fail fast instead of piling up timeouts,
+	// and the host can recover without being hammered.
+	// Each host (host:port) has its own circuit: a failing webhook
+	// does not block the requests to the other hosts.
+	//
+	// The circuit of a host opens when, within Window, at least MinRequests
+	// have been sent and the ratio of failures reaches FailureRate.
+	// After OpenTimeout, the circuit is half-open: a few probes decide
+	// whether the circuit is closed again or reopened.
+	//
+	//	cb := gg.NewCircuitBreaker()
+	//	cb.OnStateChange = func(host string, from, to gg.BreakerState) {
+	//		log.Warnf("circuit %s: %s -> %s", host, from, to)
+	//	}
+	//	ar.Client = cb.Client(nil)                                      // gc.AdaptiveRate
+	//	notifier := gg.NewSlackNotifier(url).WithClient(cb.Client(nil)) // notifiers
+	CircuitBreaker struct {
+		// IsFailure classifies the outcome of a request (default: no response or 5xx status).
+		// It is not called for the requests canceled by the caller (see Release).
+		IsFailure func(resp *http.Response, err error) bool
+		// OnStateChange is called (synchronously) when the circuit of a host changes state.
+		OnStateChange func(host string, from, to BreakerState)
+		circuits      map[string]*circuit
+		// FailureRate is the ratio of failed requests (0 < FailureRate ≤ 1) opening the circuit.
+		FailureRate float64
+		// MinRequests is the number of requests within Window below which the circuit stays closed.
+		MinRequests int
+		// HalfOpenRequests is the number of successful probes closing the circuit.
+		HalfOpenRequests int
+		// Window is the duration of the failure counting.
+		Window time.Duration
+		// OpenTimeout is the duration the requests are rejected before probing the host.
+		OpenTimeout time.Duration
+		mu          sync.Mutex
+		trips       atomic.Uint64
+		rejected    atomic.Uint64
+	}
+
+	// CircuitBreakerStats is a snapshot of the CircuitBreaker metrics.
+	CircuitBreakerStats struct {
+		Open     []string `json:"open"`     // hosts whose circuit is open or half-open
+		Trips    uint64   `json:"trips"`    // transitions to the open state
+		Rejected uint64   `json:"rejected"` // requests not sent because the circuit was open
+		Hosts    int      `json:"hosts"`
+	}
+
+	circuit struct {
+		windowStart time.Time
+		openedAt    time.Time
+		state       BreakerState
+		requests    int
+		failures    int
+		probes      int // probes sent in the half-open state
+		successes   int // successful probes
+	}
+)
+
+// NewCircuitBreaker creates a CircuitBreaker opening the circuit of a host
+// when half of at least 10 requests fail within one minute.
+// The requests are rejected during 30 seconds, then a single probe is sent.
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{
+		circuits:         map[string]*circuit{},
+		FailureRate:      0.5,
+		MinRequests:      10,
+		HalfOpenRequests: 1,
+		Window:           time.Minute,
+		OpenTimeout:      30 * time.Second,
+	}
+}
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int32(s))
+	}
+}
+
+// Middleware is a RTMiddleware rejecting the requests with ErrCircuitOpen
+// when the circuit of the destination host is open.
+func (cb *CircuitBreaker) Middleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		host := r.URL.Host
+		err := cb.Allow(host)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := next.RoundTrip(r)
+		if errors.Is(err, context.Canceled) {
+			cb.Release(host) // canceled by the caller, not an outcome of the host
+		} else {
+			cb.Report(host, cb.failed(resp, err))
+		}
+		return resp, err
+	})
+}
+
+// Client returns a copy of the client (nil means http.DefaultClient)
+// whose transport is protected by the CircuitBreaker.
+func (cb *CircuitBreaker) Client(client *http.Client) *http.Client {
+	c := http.Client{}
+	if client != nil {
+		c = *client
+	}
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c.Transport = cb.Middleware(transport)
+	return &c
+}
+
+func (cb *CircuitBreaker) failed(resp *http.Response, err error) bool {
+	if cb.IsFailure != nil {
+		return cb.IsFailure(resp, err)
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// Allow returns ErrCircuitOpen when the request to the host must not be sent.
+// Each allowed request must be followed by a call to Report, or to Release when abandoned.
+// Allow and Report are used by Middleware, they are exported for the non-HTTP clients.
+func (cb *CircuitBreaker) Allow(host string) error {
+	now := time.Now()
+
+	cb.mu.Lock()
+	c := cb.circuit(host, now)
+	from := c.state
+	if c.state == StateOpen {
+		if now.Sub(c.openedAt) < cb.OpenTimeout {
+			cb.mu.Unlock()
+			cb.rejected.Add(1)
+			return fmt.Errorf("%w for %s", ErrCircuitOpen, Sanitize(host))
+		}
+		c.state = StateHalfOpen
+		c.probes = 0
+		c.successes = 0
+	}
+	if c.state == StateHalfOpen {
+		if c.probes >= max(cb.HalfOpenRequests, 1) {
+			cb.mu.Unlock()
+			cb.rejected.Add(1)
+			return fmt.Errorf("%w for %s (probing)", ErrCircuitOpen, Sanitize(host))
+		}
+		c.probes++
+	}
+	to := c.state
+	cb.mu.Unlock()
+
+	cb.notify(host, from, to)
+	return nil
+}
+
+// Report records the outcome of a request allowed by Allow.
+func (cb *CircuitBreaker) Report(host string, failed bool) {
+	now := time.Now()
+
+	cb.mu.Lock()
+	c := cb.circuit(host, now)
+	from := c.state
+	switch c.state {
+	case StateClosed:
+		if now.Sub(c.windowStart) > cb.Window {
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+		c.requests++
+		if failed {
+			c.failures++
+			if c.requests >= cb.MinRequests && float64(c.failures) >= cb.FailureRate*float64(c.requests) {
+				cb.trip(c, now)
+			}
+		}
+	case StateHalfOpen:
+		if failed {
+			cb.trip(c, now)
+			break
+		}
+		c.successes++
+		if c.successes >= max(cb.HalfOpenRequests, 1) {
+			c.state = StateClosed
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+	case StateOpen:
+		// request sent before the circuit opened: ignored
+	}
+	to := c.state
+	cb.mu.Unlock()
+
+	cb.notify(host, from, to)
+}
+
+// Release records a request allowed by Allow but abandoned without outcome
+// (e.g. canceled by the caller): the request is neither a success nor a failure.
+// In the half-open state, the circuit stays half-open and the next request probes the host.
+func (cb *CircuitBreaker) Release(host string) {
+	cb.mu.Lock()
+	c := cb.circuit(host, time.Now())
+	if c.state == StateHalfOpen && c.probes > 0 {
+		c.probes--
+	}
+	cb.mu.Unlock()
+}
+
+// circuit returns the circuit of the host, created when missing.
+// The caller must lock cb.mu.
+func (cb *CircuitBreaker) circuit(host string, now time.Time) *circuit {
+	c, ok := cb.circuits[host]
+	if !ok {
+		c = &circuit{windowStart: now}
+		cb.circuits[host] = c
+	}
+	return c
+}
+
+// trip opens the circuit. The caller must lock cb.mu.
+func (cb *CircuitBreaker) trip(c *circuit, now time.Time) {
+	c.state = StateOpen
+	c.openedAt = now
+	cb.trips.Add(1)
+}
+
+func (cb *CircuitBreaker) notify(host string, from, to BreakerState) {
+	if from == to {
+		return
+	}
+	log.Warningf("CircuitBreaker %s: %s -> %s", Sanitize(host), from, to)
+	if cb.OnStateChange != nil {
+		cb.OnStateChange(host, from, to)
+	}
+}
+
+// State returns the current state of the circuit of the host (host:port).
+func (cb *CircuitBreaker) State(host string) BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c, ok := cb.circuits[host]
+	if !ok {
+		return StateClosed
+	}
+	return c.state
+}
+
+// Reset closes all the circuits, for example after a manual intervention.
+func (cb *CircuitBreaker) Reset() {
+	cb.mu.Lock()
+	clear(cb.circuits)
+	cb.mu.Unlock()
+}
+
+// Stats returns the counters since the creation of the CircuitBreaker.
+func (cb *CircuitBreaker) Stats() CircuitBreakerStats {
+	cb.mu.Lock()
+	s := CircuitBreakerStats{
+		Trips:    cb.trips.Load(),
+		Rejected: cb.rejected.Load(),
+		Hosts:    len(cb.circuits),
+	}
+	for host, c := range cb.circuits {
+		if c.state != StateClosed {
+			s.Open = append(s.Open, host)
+		}
+	}
+	cb.mu.Unlock()
+	slices.Sort(s.Open)
+	return s
+}
+
+// LogStats prints the counters in the logs.
+func (cb *CircuitBreaker) LogStats() {
+	s := cb.Stats()
+	log.Infof("CircuitBreaker trips=%d rejected=%d hosts=%d open=%v", s.Trips, s.Rejected, s.Hosts, s.Open)
+}
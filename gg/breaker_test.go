@@ -0,0 +1,170 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Parallel()
+
+	var failing atomic.Bool
+	failing.Store(true)
+	var calls atomic.Int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer flaky.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer healthy.Close()
+
+	cb := gg.NewCircuitBreaker()
+	cb.MinRequests = 4
+	cb.OpenTimeout = 50 * time.Millisecond
+	var mu sync.Mutex
+	var transitions []string
+	cb.OnStateChange = func(_ string, from, to gg.BreakerState) {
+		mu.Lock()
+		transitions = append(transitions, from.String()+">"+to.String())
+		mu.Unlock()
+	}
+	client := cb.Client(&http.Client{Timeout: time.Second})
+
+	get := func(url string) error {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for range 4 {
+		if err := get(flaky.URL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	host := strings.TrimPrefix(flaky.URL, "http://")
+	if cb.State(host) != gg.StateOpen {
+		t.Fatalf("want open circuit, got %s", cb.State(host))
+	}
+
+	err := get(flaky.URL)
+	if !errors.Is(err, gg.ErrCircuitOpen) || calls.Load() != 4 {
+		t.Fatalf("want ErrCircuitOpen without request, got %v calls=%d", err, calls.Load())
+	}
+	if err = get(healthy.URL); err != nil {
+		t.Fatalf("the other hosts must not be affected: %v", err)
+	}
+
+	// failed probe => open again
+	time.Sleep(60 * time.Millisecond)
+	if err = get(flaky.URL); err != nil {
+		t.Fatal(err)
+	}
+	if cb.State(host) != gg.StateOpen {
+		t.Fatalf("want open circuit after the failed probe, got %s", cb.State(host))
+	}
+
+	// successful probe => closed
+	failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if err = get(flaky.URL); err != nil {
+		t.Fatal(err)
+	}
+	if cb.State(host) != gg.StateClosed {
+		t.Fatalf("want closed circuit, got %s", cb.State(host))
+	}
+
+	mu.Lock()
+	got := strings.Join(transitions, " ")
+	mu.Unlock()
+	want := "closed>open open>half-open half-open>open open>half-open half-open>closed"
+	if got != want {
+		t.Errorf("transitions:\n got %s\nwant %s", got, want)
+	}
+
+	s := cb.Stats()
+	if s.Trips != 2 || s.Rejected != 1 || s.Hosts != 2 || len(s.Open) != 0 {
+		t.Errorf("stats=%+v", s)
+	}
+}
+
+func TestCircuitBreaker_canceledProbe(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	var failing atomic.Bool
+	failing.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-r.Context().Done()
+			return
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	cb := gg.NewCircuitBreaker()
+	cb.MinRequests = 1
+	cb.OpenTimeout = 10 * time.Millisecond
+	client := cb.Client(&http.Client{Timeout: time.Second})
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if cb.State(host) != gg.StateOpen {
+		t.Fatalf("want open circuit, got %s", cb.State(host))
+	}
+
+	// the probe canceled by the caller neither closes nor reopens the circuit
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/slow", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err = client.Do(req)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if cb.State(host) != gg.StateHalfOpen {
+		t.Fatalf("want half-open circuit after the canceled probe, got %s", cb.State(host))
+	}
+
+	// the next request is the probe
+	failing.Store(false)
+	resp, err = client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("want a new probe, got %v", err)
+	}
+	resp.Body.Close()
+	if cb.State(host) != gg.StateClosed {
+		t.Errorf("want closed circuit, got %s", cb.State(host))
+	}
+}
@@ -36,6 +36,10 @@ type (
 	// so in most cases you can just use somepackage.New().
 	RTMiddleware func(http.RoundTripper) http.RoundTripper
 
+	// Predicate decides, for each incoming request,
+	// whether the conditional middlewares of Chain.When apply.
+	Predicate func(*http.Request) bool
+
 	// RoundTripperFunc is to RoundTripper what HandlerFunc is to Handler.
 	// It is a higher-order function that enables chaining of RoundTrippers
 	// with the middleware pattern.
@@ -73,6 +77,34 @@ func (c Chain) Append(chain ...Middleware) Chain {
 	return append(c, chain...)
 }
 
+// When extends a chain with middlewares applied only
+// to the requests satisfying the predicate.
+// The other requests skip these middlewares and go directly
+// to the next handler.
+//
+//	chain = chain.When(gg.IsDev(dev), captureBody, liveReload)
+//	chain = chain.When(gg.PathPrefix("/api/"), rateLimiter)
+//
+// A nil predicate means the middlewares are never applied.
+func (c Chain) When(predicate Predicate, chain ...Middleware) Chain {
+	if predicate == nil || len(chain) == 0 {
+		return c
+	}
+
+	conditional := func(next http.Handler) http.Handler {
+		wrapped := NewChain(chain...).Then(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if predicate(r) {
+				wrapped.ServeHTTP(w, r)
+			} else {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+
+	return append(c, conditional)
+}
+
 // Append extends a chain, adding the specified middlewares
 // as the last ones in the request flow.
 //
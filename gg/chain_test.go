@@ -375,6 +375,47 @@ func TestRTChain_Append_RespectsImmutability2(t *testing.T) {
 	}
 }
 
+func TestChain_When_AppliesOnlyWhenPredicateIsTrue(t *testing.T) {
+	chain := gg.NewChain(tagMiddleware("t1\n")).
+		When(gg.PathPrefix("/api/"), tagMiddleware("t2\n"), tagMiddleware("t3\n")).
+		When(gg.HeaderEquals("X-Debug", "1"), tagMiddleware("t4\n")).
+		When(gg.IsDev(false), tagMiddleware("dev\n")).
+		When(nil, tagMiddleware("nil\n"))
+
+	if len(chain) != 4 {
+		t.Errorf("chain should have 4 middlewares, got %d", len(chain))
+	}
+
+	cases := []struct {
+		path   string
+		header string
+		want   string
+	}{
+		{"/", "", "t1\napp\n"},
+		{"/api/items", "", "t1\nt2\nt3\napp\n"},
+		{"/", "1", "t1\nt4\napp\n"},
+		{"/api/items", "1", wantedBodyResponse},
+	}
+
+	h := chain.Then(testApp)
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequestWithContext(context.Background(), http.MethodGet, c.path, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.header != "" {
+			r.Header.Set("X-Debug", c.header)
+		}
+
+		h.ServeHTTP(w, r)
+
+		if w.Body.String() != c.want {
+			t.Errorf("When path=%s header=%q got %q want %q", c.path, c.header, w.Body.String(), c.want)
+		}
+	}
+}
+
 // tagMiddleware and tagRTMiddleware are constructors for middleware
 // that writes its own "tag" into the request body and does nothing else.
 // Useful in checking if a chain is behaving in the right order.
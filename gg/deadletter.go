@@ -0,0 +1,191 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// DeadLetterFile appends the notifications that could not be sent to a JSONL file,
+	// so the alerts are not lost during an outage of the chat provider:
+	//
+	//	a := gg.NewAsyncNotifier(gg.NewNotifier(url), 100)
+	//	dlf := gg.NewDeadLetterFile("/var/lib/app/notifications.jsonl")
+	//	a.DeadLetter = dlf.Write
+	//	...
+	//	sent, err := dlf.Replay(ctx, gg.NewNotifier(url)) // once the provider is back
+	//
+	// The command "garcon replay-notifications" also replays the file.
+	DeadLetterFile struct {
+		Path string
+		mu   sync.Mutex
+	}
+
+	// DeadLetter is a line of the DeadLetterFile.
+	DeadLetter struct {
+		Time    time.Time `json:"time"`
+		Error   string    `json:"error"`
+		Message string    `json:"message"`
+		// Attempts counts the replays, the first failure excluded.
+		Attempts int `json:"attempts,omitempty"`
+	}
+)
+
+// NewDeadLetterFile creates the parent directory of the dead-letter file (the file is created on the first write).
+func NewDeadLetterFile(path string) *DeadLetterFile {
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	if err != nil {
+		log.Warn("DeadLetterFile", err)
+	}
+	return &DeadLetterFile{Path: path}
+}
+
+// Write appends the failed message, the signature matches AsyncNotifier.DeadLetter.
+// When the file cannot be written, the message is logged.
+func (d *DeadLetterFile) Write(msg string, err error) {
+	log.Warn("AsyncNotifier dead letter:", err, "msg:", sanitize(msg))
+
+	e := ""
+	if err != nil {
+		e = err.Error()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	err = appendLetters(d.Path, []DeadLetter{{Time: time.Now().UTC(), Error: e, Message: msg}})
+	if err != nil {
+		log.Warning("DeadLetterFile cannot store the message:", err, "msg:", sanitize(msg))
+	}
+}
+
+func appendLetters(path string, letters []DeadLetter) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, l := range letters {
+		err = enc.Encode(l)
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	_, err = f.Write(buf.Bytes())
+	return errors.Join(err, f.Close())
+}
+
+// Letters reads the dead letters (empty when the file does not exist).
+func (d *DeadLetterFile) Letters() ([]DeadLetter, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.read()
+}
+
+func (d *DeadLetterFile) read() ([]DeadLetter, error) {
+	return readLetters(d.Path)
+}
+
+func readLetters(path string) ([]DeadLetter, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var l DeadLetter
+		err = json.Unmarshal(scanner.Bytes(), &l)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		letters = append(letters, l)
+	}
+	return letters, scanner.Err()
+}
+
+// Replay resends the dead letters in order and returns the number of sent messages.
+// The messages failing again are appended back to the file (with the new error),
+// the file is removed when all the messages are sent.
+// When ctx is done, Replay stops and keeps the remaining letters.
+//
+// Replay first claims the letters by renaming the file to "<Path>.replaying":
+// the application may append new letters while "garcon replay-notifications"
+// replays the file in another process, the new letters go to a new file.
+// The claimed file of an interrupted replay is replayed by the next Replay.
+func (d *DeadLetterFile) Replay(ctx context.Context, n Notifier) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	claimed := d.Path + ".replaying"
+	_, err := os.Stat(claimed)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.Rename(d.Path, claimed)
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	letters, err := readLetters(claimed)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var failed []DeadLetter
+	var errs []error
+	for i, l := range letters {
+		if ctx.Err() != nil {
+			failed = append(failed, letters[i:]...)
+			errs = append(errs, ctx.Err())
+			break
+		}
+		err = n.Notify(l.Message)
+		if err != nil {
+			l.Attempts++
+			l.Error = err.Error()
+			failed = append(failed, l)
+			errs = append(errs, err)
+			continue
+		}
+		sent++
+	}
+
+	if len(failed) > 0 {
+		err = appendLetters(d.Path, failed)
+		if err != nil {
+			return sent, err // the claimed file is replayed again next time
+		}
+	}
+	err = os.Remove(claimed)
+	if err != nil {
+		return sent, err
+	}
+
+	log.Infof("DeadLetterFile replay sent=%d failed=%d", sent, len(failed))
+	return sent, errors.Join(errs...)
+}
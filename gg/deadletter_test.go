@@ -0,0 +1,131 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestDeadLetterFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "sub", "dead.jsonl")
+	dlf := gg.NewDeadLetterFile(path)
+
+	down := &flakyNotifier{failures: 1000}
+	a := gg.NewAsyncNotifier(down, 10)
+	a.Backoff = time.Millisecond
+	a.MaxRetries = 1
+	a.DeadLetter = dlf.Write
+	for _, msg := range []string{"disk full", "db down", "cert expired"} {
+		a.Notify(msg)
+	}
+	err := a.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	letters, err := dlf.Letters()
+	if err != nil || len(letters) != 3 || letters[1].Message != "db down" || letters[1].Error != "hook unavailable" {
+		t.Fatalf("letters=%+v err=%v", letters, err)
+	}
+
+	// the provider is back for the two first messages only
+	back := &flakyNotifier{}
+	fails := &failingOn{next: back, fail: "cert expired"}
+	sent, err := dlf.Replay(context.Background(), fails)
+	if sent != 2 || err == nil {
+		t.Errorf("sent=%d err=%v", sent, err)
+	}
+	if !slices.Equal(back.sent, []string{"disk full", "db down"}) {
+		t.Errorf("replayed %v", back.sent)
+	}
+	letters, _ = dlf.Letters()
+	if len(letters) != 1 || letters[0].Message != "cert expired" || letters[0].Attempts != 1 {
+		t.Errorf("remaining letters=%+v", letters)
+	}
+
+	sent, err = dlf.Replay(context.Background(), back)
+	if sent != 1 || err != nil {
+		t.Errorf("sent=%d err=%v", sent, err)
+	}
+	if _, err = os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want the file removed, got %v", err)
+	}
+}
+
+func TestDeadLetterFile_ReplayWhileWriting(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	app := gg.NewDeadLetterFile(path)
+	app.Write("disk full", errors.New("hook unavailable"))
+
+	// "garcon replay-notifications" runs in another process (another DeadLetterFile),
+	// the application appends a letter during the replay
+	cli := gg.NewDeadLetterFile(path)
+	during := &writingNotifier{write: func() { app.Write("db down", errors.New("hook unavailable")) }}
+	sent, err := cli.Replay(context.Background(), during)
+	if sent != 1 || err != nil {
+		t.Fatalf("sent=%d err=%v", sent, err)
+	}
+
+	letters, err := app.Letters()
+	if err != nil || len(letters) != 1 || letters[0].Message != "db down" {
+		t.Errorf("the letter written during the replay is lost: letters=%+v err=%v", letters, err)
+	}
+	if _, err = os.Stat(path + ".replaying"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("want the claimed file removed, got %v", err)
+	}
+}
+
+type writingNotifier struct {
+	write func()
+}
+
+func (n *writingNotifier) Notify(string) error {
+	n.write()
+	return nil
+}
+
+func TestAsyncNotifier_Delivered(t *testing.T) {
+	t.Parallel()
+
+	a, _ := newTestAsync(&flakyNotifier{failures: 2}, 10)
+	var attempts []int
+	var mu sync.Mutex
+	a.Delivered = func(_ string, n int) {
+		mu.Lock()
+		attempts = append(attempts, n)
+		mu.Unlock()
+	}
+	a.Notify("a")
+	a.Notify("b")
+	a.Close(context.Background())
+	if !slices.Equal(attempts, []int{3, 1}) {
+		t.Errorf("attempts=%v", attempts)
+	}
+}
+
+type failingOn struct {
+	next gg.Notifier
+	fail string
+}
+
+func (n *failingOn) Notify(msg string) error {
+	if msg == n.fail {
+		return errors.New("still failing")
+	}
+	return n.next.Notify(msg)
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+type (
+	// DecodeOption customizes DecodeJSONBody.
+	DecodeOption func(*decodeConfig)
+
+	decodeConfig struct {
+		disallowUnknownFields bool
+	}
+)
+
+// DisallowUnknownFields rejects the JSON objects having fields not present in the target struct.
+func DisallowUnknownFields() DecodeOption {
+	return func(c *decodeConfig) { c.disallowUnknownFields = true }
+}
+
+// DecodeJSONBody reads at most maxBytes of the request body and decodes it into a T value.
+// The errors are gerr.Invalid errors ready to be responded with Writer.WriteError:
+// syntax and type errors report the line and column of the faulty JSON,
+// a body larger than maxBytes, empty or containing several JSON values is rejected.
+//
+//	order, err := gg.DecodeJSONBody[Order](w, r, 1<<20, gg.DisallowUnknownFields())
+//	if err != nil {
+//		gw.WriteError(w, r, err)
+//		return
+//	}
+func DecodeJSONBody[T any](w http.ResponseWriter, r *http.Request, maxBytes int64, opts ...DecodeOption) (T, error) {
+	var v T
+	var cfg decodeConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	buf, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBytes))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return v, gerr.New(gerr.Invalid, "request body too large", "max_bytes", maxErr.Limit)
+		}
+		return v, gerr.Wrap(err, gerr.Invalid, "cannot read request body")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	if cfg.disallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	err = decoder.Decode(&v)
+	if err != nil {
+		return v, jsonError(buf, err)
+	}
+
+	if decoder.More() {
+		line, col := lineColumn(buf, decoder.InputOffset())
+		return v, gerr.New(gerr.Invalid, "request body must contain a single JSON value", "line", line, "column", col)
+	}
+
+	return v, nil
+}
+
+// jsonError converts the errors of encoding/json into gerr.Invalid errors.
+func jsonError(buf []byte, err error) *gerr.Error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return gerr.New(gerr.Invalid, "empty request body")
+
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		line, col := lineColumn(buf, int64(len(buf)))
+		return gerr.New(gerr.Invalid, "truncated JSON in request body", "line", line, "column", col)
+
+	case errors.As(err, &syntaxErr):
+		// Offset counts the faulty byte
+		line, col := lineColumn(buf, syntaxErr.Offset-1)
+		return gerr.Wrap(err, gerr.Invalid, "malformed JSON in request body", "line", line, "column", col)
+
+	case errors.As(err, &typeErr):
+		line, col := lineColumn(buf, typeErr.Offset)
+		return gerr.Wrap(err, gerr.Invalid, "invalid JSON value in request body",
+			"field", typeErr.Field, "expected", typeErr.Type.String(), "got", typeErr.Value, "line", line, "column", col)
+
+	default:
+		// the encoding/json package does not export the unknown field error
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return gerr.New(gerr.Invalid, "unknown field in request body", "field", strings.Trim(field, `"`))
+		}
+		return gerr.Wrap(err, gerr.Invalid, "cannot decode JSON request body")
+	}
+}
+
+// lineColumn converts the byte offset into line and column numbers (starting at 1).
+func lineColumn(buf []byte, offset int64) (line, column int) {
+	offset = min(max(offset, 0), int64(len(buf)))
+	before := buf[:offset]
+	line = 1 + bytes.Count(before, []byte{'\n'})
+	column = 1 + len(before) - (bytes.LastIndexByte(before, '\n') + 1)
+	return line, column
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type order struct {
+	Item     string `json:"item"`
+	Quantity int    `json:"quantity"`
+}
+
+func TestDecodeJSONBody(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name       string
+		body       string
+		wantErr    string
+		wantParams map[string]any
+		want       order
+		strict     bool
+	}{
+		{name: "ok", body: `{"item":"pen","quantity":2}`, want: order{"pen", 2}},
+		{name: "unknown-ok", body: `{"item":"pen","color":"red"}`, want: order{Item: "pen"}},
+		{name: "unknown", body: `{"item":"pen","color":"red"}`, strict: true, wantErr: "unknown field", wantParams: map[string]any{"field": "color"}},
+		{name: "empty", body: ``, wantErr: "empty request body"},
+		{name: "syntax", body: "{\n  \"item\": \"pen\",\n  \"quantity\": 2,\n}", wantErr: "malformed JSON", wantParams: map[string]any{"line": 4, "column": 1}},
+		{name: "type", body: "{\"item\":\"pen\",\n\"quantity\":\"two\"}", wantErr: "invalid JSON value", wantParams: map[string]any{"field": "quantity", "line": 2}},
+		{name: "truncated", body: `{"item":"pen"`, wantErr: "truncated JSON"},
+		{name: "two-values", body: `{"item":"pen"} {"item":"ink"}`, wantErr: "single JSON value"},
+		{name: "too-large", body: `{"item":"` + strings.Repeat("x", 100) + `"}`, wantErr: "too large", wantParams: map[string]any{"max_bytes": int64(64)}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(c.body))
+			var opts []gg.DecodeOption
+			if c.strict {
+				opts = append(opts, gg.DisallowUnknownFields())
+			}
+
+			got, err := gg.DecodeJSONBody[order](httptest.NewRecorder(), r, 64, opts...)
+
+			if c.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != c.want {
+					t.Errorf("got %+v want %+v", got, c.want)
+				}
+				return
+			}
+
+			gErr, ok := gerr.As(err)
+			if !ok || gErr.Code != gerr.Invalid || !strings.Contains(gErr.Message, c.wantErr) {
+				t.Fatalf("want gerr.Invalid %q, got %v", c.wantErr, err)
+			}
+			for k, v := range c.wantParams {
+				if gErr.Data.Params[k] != v {
+					t.Errorf("param %s=%v want %v", k, gErr.Data.Params[k], v)
+				}
+			}
+		})
+	}
+}
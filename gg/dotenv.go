@@ -0,0 +1,186 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// LoadDotEnv sets the environment variables defined in the .env files,
+// so EnvStr, EnvInt and EnvParse can read them during the local development.
+// The later files override the former ones,
+// but the variables already present in the process environment are never overridden.
+// Without paths, LoadDotEnv reads (when present) in this order:
+//
+//	.env  .env.local  .env.$ENV  .env.$ENV.local
+//
+// The missing files are ignored. The file format is:
+//
+//	# comment
+//	export PORT=8080           # "export" is optional, comment at end of line
+//	URL=http://localhost:${PORT}/api
+//	DB=${DATABASE_URL:-postgres://localhost/dev}
+//	GREETING="Hello\nWorld"   # double quotes: escapes and expansion
+//	PASSWORD='pa$$word'        # single quotes: literal value
+func LoadDotEnv(paths ...string) error {
+	if len(paths) == 0 {
+		paths = []string{".env", ".env.local"}
+		if env := os.Getenv("ENV"); env != "" {
+			paths = append(paths, ".env."+env, ".env."+env+".local")
+		}
+	}
+
+	vars := make(map[string]string)
+	lookup := func(key string) (string, bool) {
+		if v, ok := os.LookupEnv(key); ok {
+			return v, true
+		}
+		v, ok := vars[key]
+		return v, ok
+	}
+
+	var errs []error
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = parseDotEnv(f, p, vars, lookup)
+		f.Close()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for k, v := range vars {
+		if _, ok := os.LookupEnv(k); ok {
+			continue
+		}
+		err := os.Setenv(k, v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("setenv %s: %w", k, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// parseDotEnv adds the variables of the file into vars.
+// lookup resolves the variables used by the expansion.
+func parseDotEnv(r io.Reader, name string, vars map[string]string, lookup func(string) (string, bool)) error {
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !isEnvName(key) {
+			errs = append(errs, fmt.Errorf("%s:%d: want KEY=value", name, n))
+			continue
+		}
+
+		value, err := dotEnvValue(strings.TrimSpace(value), lookup)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %s: %w", name, n, key, err))
+			continue
+		}
+		vars[key] = value
+	}
+
+	errs = append(errs, scanner.Err())
+	return errors.Join(errs...)
+}
+
+func dotEnvValue(raw string, lookup func(string) (string, bool)) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+
+	switch raw[0] {
+	case '\'':
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", errors.New("missing closing single quote")
+		}
+		return raw[1 : end+1], nil
+
+	case '"':
+		// pending is expanded before each escaped \$ (a literal dollar) and at the end
+		var sb, pending strings.Builder
+		for i := 1; i < len(raw); i++ {
+			c := raw[i]
+			switch {
+			case c == '"':
+				sb.WriteString(expandEnv(pending.String(), lookup))
+				return sb.String(), nil
+			case c == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					pending.WriteByte('\n')
+				case 't':
+					pending.WriteByte('\t')
+				case 'r':
+					pending.WriteByte('\r')
+				case '$':
+					sb.WriteString(expandEnv(pending.String(), lookup))
+					pending.Reset()
+					sb.WriteByte('$')
+				default: // \" \\
+					pending.WriteByte(raw[i])
+				}
+			default:
+				pending.WriteByte(c)
+			}
+		}
+		return "", errors.New("missing closing double quote")
+
+	default:
+		if i := strings.Index(raw, " #"); i >= 0 {
+			raw = strings.TrimSpace(raw[:i])
+		}
+		return expandEnv(raw, lookup), nil
+	}
+}
+
+// expandEnv replaces $VAR, ${VAR} and ${VAR:-default}.
+func expandEnv(s string, lookup func(string) (string, bool)) string {
+	return os.Expand(s, func(key string) string {
+		key, def, hasDef := strings.Cut(key, ":-")
+		v, ok := lookup(key)
+		if hasDef && (!ok || v == "") {
+			return def
+		}
+		return v
+	})
+}
+
+func isEnvName(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, c := range s {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,88 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// TestLoadDotEnv is not parallel because it sets environment variables.
+func TestLoadDotEnv(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		err := os.WriteFile(p, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	base := write(".env", `
+# defaults
+export DOTENV_PORT=8080
+DOTENV_HOST=localhost # comment
+DOTENV_URL=http://${DOTENV_HOST}:$DOTENV_PORT/api
+DOTENV_DB=${DOTENV_UNSET:-postgres://localhost/dev}
+DOTENV_QUOTED="a\tb \"c\" ${DOTENV_PORT}"
+DOTENV_LITERAL='pa$$word # not a comment'
+DOTENV_ESCAPED="a\$DOTENV_PORT \$${DOTENV_PORT}"
+DOTENV_KEEP=from-file
+`)
+	local := write(".env.local", "DOTENV_PORT=9090\nDOTENV_URL=http://${DOTENV_HOST}:$DOTENV_PORT/v2\n")
+	missing := filepath.Join(dir, ".env.missing")
+
+	t.Setenv("DOTENV_KEEP", "from-env")
+	for _, k := range []string{"DOTENV_PORT", "DOTENV_HOST", "DOTENV_URL", "DOTENV_DB", "DOTENV_QUOTED", "DOTENV_LITERAL", "DOTENV_ESCAPED"} {
+		t.Setenv(k, "")
+		os.Unsetenv(k)
+	}
+
+	err := gg.LoadDotEnv(base, local, missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, want := range map[string]string{
+		"DOTENV_PORT":    "9090",
+		"DOTENV_URL":     "http://localhost:9090/v2",
+		"DOTENV_DB":      "postgres://localhost/dev",
+		"DOTENV_QUOTED":  "a\tb \"c\" 8080",
+		"DOTENV_LITERAL": "pa$$word # not a comment",
+		"DOTENV_ESCAPED": "a$DOTENV_PORT $8080",
+		"DOTENV_KEEP":    "from-env",
+	} {
+		if got := gg.EnvStr(k); got != want {
+			t.Errorf("%s=%q want %q", k, got, want)
+		}
+	}
+	if gg.EnvInt("DOTENV_PORT") != 9090 {
+		t.Error("EnvInt does not see the .env value")
+	}
+}
+
+func TestLoadDotEnv_errors(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, ".env")
+	err := os.WriteFile(p, []byte("OK=1\nnot a variable\nBAD=\"unterminated\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("OK", "")
+	os.Unsetenv("OK")
+
+	err = gg.LoadDotEnv(p)
+	if err == nil || !strings.Contains(err.Error(), ".env:2") || !strings.Contains(err.Error(), ".env:3") {
+		t.Fatalf("want errors at lines 2 and 3, got %v", err)
+	}
+	if os.Getenv("OK") != "1" {
+		t.Error("the valid lines must be loaded")
+	}
+}
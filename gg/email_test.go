@@ -0,0 +1,110 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// fakeSMTP accepts one session and returns the received envelope and data.
+func fakeSMTP(t *testing.T) (addr string, received chan string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	received = make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		tp := textproto.NewConn(conn)
+		var sb strings.Builder
+		tp.PrintfLine("220 localhost ESMTP")
+		for {
+			line, err := tp.ReadLine()
+			if err != nil {
+				received <- sb.String()
+				return
+			}
+			cmd := strings.ToUpper(strings.Fields(line + " x")[0])
+			switch cmd {
+			case "EHLO", "HELO":
+				tp.PrintfLine("250-localhost\r\n250 8BITMIME")
+			case "MAIL", "RCPT":
+				sb.WriteString(line + "\n")
+				tp.PrintfLine("250 OK")
+			case "DATA":
+				tp.PrintfLine("354 go ahead")
+				data, _ := tp.ReadDotLines()
+				sb.WriteString(strings.Join(data, "\n"))
+				tp.PrintfLine("250 OK")
+			case "QUIT":
+				tp.PrintfLine("221 bye")
+				received <- sb.String()
+				return
+			default:
+				tp.PrintfLine("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestEmailNotifier(t *testing.T) {
+	t.Parallel()
+
+	addr, received := fakeSMTP(t)
+	dsn := "smtp://" + addr + "?from=bot@example.com&to=ops@example.com,dev@example.com&subject=Contact:+{{.Line}}"
+	n := gg.NewNotifier(dsn)
+	if _, ok := n.(gg.EmailNotifier); !ok {
+		t.Fatalf("NewNotifier(%q) = %T", dsn, n)
+	}
+
+	err := n.Notify("Hello from the form\nsecond line\n.dot line")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := <-received
+	for _, want := range []string{
+		"MAIL FROM:<bot@example.com>",
+		"RCPT TO:<ops@example.com>",
+		"RCPT TO:<dev@example.com>",
+		"Subject: Contact: Hello from the form",
+		"To: ops@example.com, dev@example.com",
+		"second line\n.dot line",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in:\n%s", want, got)
+		}
+	}
+}
+
+func TestNewEmailNotifier_errors(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"smtp://mail.example.com?to=a@example.com":   "missing sender",
+		"smtp://mail.example.com?from=a@example.com": "missing recipients",
+		"mailto:a@example.com?from=b@example.com":    "missing SMTP server",
+		"imap://mail.example.com":                    "unsupported scheme",
+	}
+	for dsn, want := range cases {
+		_, err := gg.NewEmailNotifier(dsn)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("NewEmailNotifier(%q) err=%v want %q", dsn, err, want)
+		}
+	}
+}
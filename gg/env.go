@@ -0,0 +1,332 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+// ErrEnvMissing is returned (wrapped) by EnvParse when required variables are not set.
+var ErrEnvMissing = errors.New("missing environment variables")
+
+var (
+	durationType        = reflect.TypeFor[time.Duration]()
+	urlType             = reflect.TypeFor[url.URL]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// EnvParse populates the struct pointed by ptr from the environment variables
+// named by the "env" field tags:
+//
+//	type Config struct {
+//		Port    int           `env:"MAIN_PORT,default=8080"`
+//		Origins []string      `env:"ORIGINS,default=http://localhost:8080,http://localhost:1111"`
+//		Timeout time.Duration `env:"TIMEOUT,default=30s"`
+//		DB      *url.URL      `env:"DATABASE_URL,required"`
+//		Secret  string        `env:"SECRET,required"` // or SECRET_FILE=/run/secrets/secret
+//		Log     LogConfig     // nested structs without tag are parsed too
+//	}
+//
+// When VAR is not set, EnvParse reads the file given by VAR_FILE (Docker secrets)
+// without its trailing newline, else uses the default value.
+// The default value extends to the next option (may contain commas).
+// The options are:
+//
+//	required           the variable (or VAR_FILE) must be set
+//	secret             the value is redacted in the errors
+//	secret-file=PATH   the file read when neither VAR nor VAR_FILE is set (implies secret)
+//	default=VALUE      the value used when the variable is not set
+//
+// Supported types: string, bool, integers, floats, time.Duration, url.URL,
+// encoding.TextUnmarshaler, pointers and slices of these types (comma-separated values).
+// EnvParse reports all the missing variables and all the invalid values at once.
+func EnvParse(ptr any) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("EnvParse wants a pointer to a struct, got %T", ptr)
+	}
+
+	var p envParser
+	p.parseStruct(v.Elem())
+
+	errs := make([]error, 0, len(p.invalid)+1)
+	if len(p.missing) > 0 {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrEnvMissing, strings.Join(p.missing, ", ")))
+	}
+	for _, e := range p.invalid {
+		errs = append(errs, fmt.Errorf("%s=%s (%s): %w", e.name, e.quotedValue(), e.typ, e.err))
+	}
+	return errors.Join(errs...)
+}
+
+// LoadEnv returns the struct T populated from the environment variables
+// named by the "env" field tags prefixed by prefix (see EnvParse for the tag options):
+//
+//	type Config struct {
+//		Addr     string        `env:"ADDR,default=:8080"`
+//		Timeout  time.Duration `env:"TIMEOUT,default=30s"`
+//		Password string        `env:"DB_PASSWORD,required,secret-file=/run/secrets/db_password"`
+//	}
+//
+//	cfg, err := gg.LoadEnv[Config]("MYAPP_") // reads MYAPP_ADDR, MYAPP_TIMEOUT...
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//
+// The error is a gerr.ConfigErr listing the missing variables in the param "missing"
+// and wrapping one gerr.Invalid error per invalid value (the secrets are redacted).
+// errors.Is(err, ErrEnvMissing) reports the missing variables.
+func LoadEnv[T any](prefix string) (T, error) {
+	var cfg T
+	v := reflect.ValueOf(&cfg).Elem()
+	if v.Kind() != reflect.Struct {
+		return cfg, gerr.New(gerr.ConfigErr, "LoadEnv wants a struct type", "type", v.Type().String())
+	}
+
+	p := envParser{prefix: prefix}
+	p.parseStruct(v)
+	if len(p.missing) == 0 && len(p.invalid) == 0 {
+		return cfg, nil
+	}
+
+	errs := make([]error, 0, len(p.invalid)+1)
+	if len(p.missing) > 0 {
+		errs = append(errs, ErrEnvMissing)
+	}
+	for _, e := range p.invalid {
+		var value any = e.value
+		if e.secret {
+			value = gerr.Secret(e.value)
+		}
+		errs = append(errs, gerr.Wrap(e.err, gerr.Invalid, "invalid environment variable",
+			"name", e.name, "value", value, "type", e.typ.String()))
+	}
+
+	args := []any{"prefix", prefix}
+	if len(p.missing) > 0 {
+		args = append(args, "missing", p.missing)
+	}
+	return cfg, gerr.Wrap(errors.Join(errs...), gerr.ConfigErr, "invalid environment configuration", args...)
+}
+
+type (
+	// envParser collects all the missing and invalid variables.
+	envParser struct {
+		prefix  string
+		missing []string
+		invalid []envError
+	}
+
+	envError struct {
+		err    error
+		typ    reflect.Type
+		name   string
+		value  string
+		secret bool
+	}
+
+	envTag struct {
+		name       string
+		def        string
+		secretFile string
+		hasDef     bool
+		required   bool
+		secret     bool
+	}
+)
+
+func (e envError) quotedValue() string {
+	if e.secret {
+		return gerr.Redacted
+	}
+	return strconv.Quote(e.value)
+}
+
+func (p *envParser) parseStruct(v reflect.Value) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		str, ok := field.Tag.Lookup("env")
+		if !ok {
+			if field.Type.Kind() == reflect.Struct && field.Type != urlType {
+				p.parseStruct(v.Field(i))
+			}
+			continue
+		}
+
+		tag := parseEnvTag(str)
+		if tag.name == "" || tag.name == "-" {
+			continue
+		}
+		name := p.prefix + tag.name
+
+		value, found, err := lookupEnv(name, tag.secretFile)
+		if err != nil {
+			p.invalid = append(p.invalid, envError{err: err, typ: field.Type, name: name, secret: true})
+			continue
+		}
+		if !found {
+			if tag.required {
+				p.missing = append(p.missing, name)
+				continue
+			}
+			if !tag.hasDef {
+				continue
+			}
+			value = tag.def
+		}
+
+		err = setEnvValue(v.Field(i), value)
+		if err != nil && tag.secret {
+			err = errors.New("invalid value") // the parsing error may contain the secret
+		}
+		if err != nil {
+			p.invalid = append(p.invalid, envError{err: err, typ: field.Type, name: name, value: value, secret: tag.secret})
+		}
+	}
+}
+
+// parseEnvTag splits `env:"NAME,default=a,b,required,secret-file=/run/secrets/x"`.
+func parseEnvTag(str string) envTag {
+	parts := strings.Split(str, ",")
+	tag := envTag{name: strings.TrimSpace(parts[0])}
+	inDefault := false
+	for _, opt := range parts[1:] {
+		switch {
+		case opt == "required":
+			tag.required, inDefault = true, false
+		case opt == "secret":
+			tag.secret, inDefault = true, false
+		case strings.HasPrefix(opt, "secret-file="):
+			tag.secretFile = strings.TrimPrefix(opt, "secret-file=")
+			tag.secret, inDefault = true, false
+		case strings.HasPrefix(opt, "default=") && !tag.hasDef:
+			tag.def = strings.TrimPrefix(opt, "default=")
+			tag.hasDef, inDefault = true, true
+		case inDefault:
+			tag.def += "," + opt
+		}
+	}
+	return tag
+}
+
+// lookupEnv returns the value of the variable or the content of the file VAR_FILE,
+// else the content of the secretFile (if any and if it exists).
+func lookupEnv(name, secretFile string) (string, bool, error) {
+	if value, ok := os.LookupEnv(name); ok {
+		return value, true, nil
+	}
+	file, ok := os.LookupEnv(name + "_FILE")
+	if ok && file != "" {
+		buf, err := os.ReadFile(file)
+		if err != nil {
+			return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		return strings.TrimRight(string(buf), "\r\n"), true, nil
+	}
+	if secretFile == "" {
+		return "", false, nil
+	}
+	buf, err := os.ReadFile(secretFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimRight(string(buf), "\r\n"), true, nil
+}
+
+func setEnvValue(v reflect.Value, str string) error {
+	if v.Kind() == reflect.Pointer {
+		ptr := reflect.New(v.Type().Elem())
+		err := setEnvValue(ptr.Elem(), str)
+		if err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(str))
+	}
+
+	switch v.Type() {
+	case durationType:
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	case urlType:
+		u, err := url.Parse(str)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(*u))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(str)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(str)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(str, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(str, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(str, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		if str != "" {
+			items = strings.Split(str, ",")
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			err := setEnvValue(slice.Index(i), strings.TrimSpace(item))
+			if err != nil {
+				return fmt.Errorf("item #%d: %w", i, err)
+			}
+		}
+		v.Set(slice)
+	default:
+		return errors.New("unsupported type")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type envConfig struct {
+	DB      *url.URL `env:"ENVTEST_DB"`
+	Secret  string   `env:"ENVTEST_SECRET,required"`
+	Origins []string `env:"ENVTEST_ORIGINS,default=http://a,http://b"`
+	Ports   []int    `env:"ENVTEST_PORTS"`
+	Log     struct {
+		Level string `env:"ENVTEST_LOG_LEVEL,default=info"`
+	}
+	Timeout time.Duration `env:"ENVTEST_TIMEOUT,default=30s"`
+	Port    int           `env:"ENVTEST_PORT,default=8080"`
+	Debug   bool          `env:"ENVTEST_DEBUG"`
+}
+
+// TestEnvParse is not parallel because it sets environment variables.
+func TestEnvParse(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "secret")
+	err := os.WriteFile(secret, []byte("s3cr3t\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENVTEST_SECRET_FILE", secret)
+	t.Setenv("ENVTEST_PORTS", "80, 443")
+	t.Setenv("ENVTEST_DEBUG", "true")
+	t.Setenv("ENVTEST_DB", "postgres://db:5432/app")
+
+	var cfg envConfig
+	err = gg.EnvParse(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Secret != "s3cr3t" {
+		t.Errorf("Secret=%q", cfg.Secret)
+	}
+	if !slices.Equal(cfg.Origins, []string{"http://a", "http://b"}) {
+		t.Errorf("Origins=%q", cfg.Origins)
+	}
+	if !slices.Equal(cfg.Ports, []int{80, 443}) {
+		t.Errorf("Ports=%v", cfg.Ports)
+	}
+	if cfg.DB == nil || cfg.DB.Host != "db:5432" {
+		t.Errorf("DB=%v", cfg.DB)
+	}
+	if cfg.Timeout != 30*time.Second || cfg.Port != 8080 || !cfg.Debug || cfg.Log.Level != "info" {
+		t.Errorf("cfg=%+v", cfg)
+	}
+}
+
+func TestEnvParse_errors(t *testing.T) {
+	t.Setenv("ENVTEST_PORT", "http")
+	t.Setenv("ENVTEST_TIMEOUT", "10")
+
+	var cfg struct {
+		URL     *url.URL      `env:"ENVTEST_URL,required"`
+		A       string        `env:"ENVTEST_A,required"`
+		Timeout time.Duration `env:"ENVTEST_TIMEOUT"`
+		Port    int           `env:"ENVTEST_PORT"`
+	}
+	err := gg.EnvParse(&cfg)
+	if !errors.Is(err, gg.ErrEnvMissing) {
+		t.Fatalf("want ErrEnvMissing, got %v", err)
+	}
+	for _, want := range []string{"ENVTEST_URL, ENVTEST_A", "ENVTEST_PORT=", "ENVTEST_TIMEOUT="} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not report %q: %v", want, err)
+		}
+	}
+
+	if gg.EnvParse(cfg) == nil {
+		t.Error("want error for non-pointer")
+	}
+}
+
+type loadEnvConfig struct {
+	Addr     string   `env:"ADDR,default=localhost"`
+	Password string   `env:"DB_PASSWORD,required,secret-file=testdata/db_password"`
+	Token    string   `env:"TOKEN,secret"`
+	Hosts    []string `env:"HOSTS,default=a,b,secret"`
+	Port     int      `env:"PORT,required"`
+}
+
+// TestLoadEnv is not parallel because it sets environment variables.
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("LOADENV_PORT", "9090")
+
+	cfg, err := gg.LoadEnv[loadEnvConfig]("LOADENV_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != "localhost" || cfg.Password != "pa55" || cfg.Port != 9090 || !slices.Equal(cfg.Hosts, []string{"a", "b"}) {
+		t.Errorf("cfg=%+v", cfg)
+	}
+
+	t.Setenv("LOADENV_DB_PASSWORD", "env-pa55")
+	cfg, err = gg.LoadEnv[loadEnvConfig]("LOADENV_")
+	if err != nil || cfg.Password != "env-pa55" {
+		t.Errorf("want the variable before the secret-file, got Password=%q err=%v", cfg.Password, err)
+	}
+}
+
+func TestLoadEnv_errors(t *testing.T) {
+	t.Setenv("LOADENV2_HOSTS", "x")
+	t.Setenv("LOADENV2_TIMEOUT", "s3cr3t")
+
+	_, err := gg.LoadEnv[struct {
+		Timeout time.Duration `env:"TIMEOUT,secret"`
+		Port    int           `env:"PORT,required"`
+		Debug   bool          `env:"DEBUG,default=maybe"`
+	}]("LOADENV2_")
+
+	if !errors.Is(err, gg.ErrEnvMissing) {
+		t.Fatalf("want ErrEnvMissing, got %v", err)
+	}
+	if !gerr.Is(err, gerr.ConfigErr) {
+		t.Errorf("want gerr.ConfigErr, got %v", err)
+	}
+	msg := err.Error()
+	for _, want := range []string{"LOADENV2_PORT", "LOADENV2_DEBUG", "maybe", gerr.Redacted} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("error does not report %q: %v", want, msg)
+		}
+	}
+	if strings.Contains(msg, "s3cr3t") {
+		t.Errorf("error reveals the secret: %v", msg)
+	}
+
+	_, err = gg.LoadEnv[int]("")
+	if err == nil {
+		t.Error("want error for non-struct type")
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistogramSize is the default maximum number of buckets (as the OpenTelemetry SDK).
+	DefaultHistogramSize = 160
+	maxHistogramScale    = 20
+	minHistogramScale    = -10
+)
+
+type (
+	// ExpHistogram is a base-2 exponential histogram following the data model of
+	// the OpenTelemetry ExponentialHistogram: the bucket i contains the values
+	// in (base^i, base^(i+1)] where base = 2^(2^-Scale).
+	// The scale starts at the highest resolution and decreases (merging the buckets by pairs)
+	// when the values do not fit in the maximum number of buckets:
+	// 160 buckets cover the latencies from 100µs to 10s with a relative error below 5%.
+	//
+	//	h := gg.NewExpHistogram(0)
+	//	h.RecordDuration(time.Since(start))
+	//	p99 := h.QuantileDuration(0.99)
+	//
+	// The latencies are positive: the negative values are counted in the zero bucket.
+	// ExpHistogram is safe for concurrent use, a nil ExpHistogram ignores the values.
+	ExpHistogram struct {
+		counts  []uint64 // counts[i] is the bucket offset+i
+		sum     float64
+		min     float64
+		max     float64
+		count   uint64
+		zero    uint64
+		offset  int32
+		scale   int32
+		maxSize int
+		mu      sync.Mutex
+	}
+
+	// ExpHistogramSnapshot is a copy of the ExpHistogram state,
+	// its fields match the OpenTelemetry ExponentialHistogramDataPoint.
+	ExpHistogramSnapshot struct {
+		Positive  ExpBuckets `json:"positive"`
+		Count     uint64     `json:"count"`
+		Sum       float64    `json:"sum"`
+		Min       float64    `json:"min"`
+		Max       float64    `json:"max"`
+		ZeroCount uint64     `json:"zero_count"`
+		Scale     int32      `json:"scale"`
+	}
+
+	// ExpBuckets are the counts of the consecutive buckets starting at the index Offset.
+	ExpBuckets struct {
+		BucketCounts []uint64 `json:"bucket_counts"`
+		Offset       int32    `json:"offset"`
+	}
+)
+
+// NewExpHistogram creates an ExpHistogram limited to maxSize buckets
+// (zero means DefaultHistogramSize).
+func NewExpHistogram(maxSize int) *ExpHistogram {
+	if maxSize <= 0 {
+		maxSize = DefaultHistogramSize
+	}
+	return &ExpHistogram{maxSize: max(maxSize, 2), scale: maxHistogramScale}
+}
+
+// RecordDuration records the duration in seconds (the unit of the OpenTelemetry latencies).
+func (h *ExpHistogram) RecordDuration(d time.Duration) {
+	h.Record(d.Seconds())
+}
+
+// Record adds the value in its bucket.
+func (h *ExpHistogram) Record(v float64) {
+	if h == nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.recordStats(v)
+	if v <= 0 {
+		h.zero++
+		return
+	}
+	h.addToBucket(bucketIndex(v, h.scale), 1)
+}
+
+func (h *ExpHistogram) recordStats(v float64) {
+	if h.count == 0 || v < h.min {
+		h.min = v
+	}
+	if h.count == 0 || v > h.max {
+		h.max = v
+	}
+	h.count++
+	h.sum += v
+}
+
+// addToBucket downscales the histogram when the index does not fit in maxSize buckets.
+func (h *ExpHistogram) addToBucket(index int32, n uint64) {
+	if len(h.counts) == 0 {
+		h.offset = index
+		h.counts = append(h.counts, n)
+		return
+	}
+
+	low := min(h.offset, index)
+	high := max(h.offset+int32(len(h.counts))-1, index)
+	var change int32
+	for int(high>>change)-int(low>>change) >= h.maxSize && h.scale-change > minHistogramScale {
+		change++
+	}
+	if change > 0 {
+		h.downscale(change)
+		index >>= change
+	}
+
+	switch {
+	case index < h.offset:
+		grown := make([]uint64, int(h.offset-index)+len(h.counts))
+		copy(grown[h.offset-index:], h.counts)
+		h.counts = grown
+		h.offset = index
+	case int(index-h.offset) >= len(h.counts):
+		h.counts = append(h.counts, make([]uint64, int(index-h.offset)-len(h.counts)+1)...)
+	}
+	h.counts[index-h.offset] += n
+}
+
+// downscale merges the buckets to reduce the scale by change (each step halves the resolution).
+func (h *ExpHistogram) downscale(change int32) {
+	if change <= 0 {
+		return
+	}
+	h.scale -= change
+	if len(h.counts) == 0 {
+		return
+	}
+	offset := h.offset >> change
+	last := (h.offset + int32(len(h.counts)) - 1) >> change
+	counts := make([]uint64, last-offset+1)
+	for i, c := range h.counts {
+		counts[(h.offset+int32(i))>>change-offset] += c
+	}
+	h.counts = counts
+	h.offset = offset
+}
+
+// Merge adds the values of the other histogram, the scale becomes the lowest one.
+func (h *ExpHistogram) Merge(other *ExpHistogram) {
+	if h == nil || other == nil || h == other {
+		return
+	}
+	s := other.Snapshot()
+	if s.Count == 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.downscale(h.scale - s.Scale)
+	if h.count == 0 || s.Min < h.min {
+		h.min = s.Min
+	}
+	if h.count == 0 || s.Max > h.max {
+		h.max = s.Max
+	}
+	h.count += s.Count
+	h.sum += s.Sum
+	h.zero += s.ZeroCount
+	for i, c := range s.Positive.BucketCounts {
+		if c > 0 {
+			// addToBucket may downscale h: shift the index with the current scale
+			h.addToBucket((s.Positive.Offset+int32(i))>>(s.Scale-h.scale), c)
+		}
+	}
+}
+
+// Snapshot returns a copy of the histogram state.
+func (h *ExpHistogram) Snapshot() ExpHistogramSnapshot {
+	if h == nil {
+		return ExpHistogramSnapshot{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return ExpHistogramSnapshot{
+		Positive:  ExpBuckets{BucketCounts: append([]uint64(nil), h.counts...), Offset: h.offset},
+		Count:     h.count,
+		Sum:       h.sum,
+		Min:       h.min,
+		Max:       h.max,
+		ZeroCount: h.zero,
+		Scale:     h.scale,
+	}
+}
+
+// Count returns the number of recorded values.
+func (h *ExpHistogram) Count() uint64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// Mean returns the average of the recorded values (zero when empty).
+func (h *ExpHistogram) Mean() float64 {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / float64(h.count)
+}
+
+// Quantile estimates the q-quantile (0 ≤ q ≤ 1) of the recorded values:
+// the value is interpolated (log-linear) within its bucket and bounded by Min and Max.
+// Quantile returns zero when the histogram is empty.
+func (h *ExpHistogram) Quantile(q float64) float64 {
+	return h.Snapshot().Quantile(q)
+}
+
+// QuantileDuration estimates the q-quantile of the values recorded by RecordDuration.
+func (h *ExpHistogram) QuantileDuration(q float64) time.Duration {
+	return time.Duration(h.Quantile(q) * float64(time.Second))
+}
+
+// Quantile estimates the q-quantile of the snapshot (see ExpHistogram.Quantile).
+func (s ExpHistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 || math.IsNaN(q) {
+		return 0
+	}
+	q = min(max(q, 0), 1)
+	if q == 0 {
+		return s.Min
+	}
+	if q == 1 {
+		return s.Max
+	}
+
+	rank := q * float64(s.Count) // number of values below the quantile
+	if rank <= float64(s.ZeroCount) {
+		return min(0, s.Max)
+	}
+	rank -= float64(s.ZeroCount)
+
+	for i, c := range s.Positive.BucketCounts {
+		if c == 0 {
+			continue
+		}
+		if rank <= float64(c) {
+			index := s.Positive.Offset + int32(i)
+			lower := bucketLowerBound(index, s.Scale)
+			upper := bucketLowerBound(index+1, s.Scale)
+			v := lower * math.Pow(upper/lower, rank/float64(c))
+			return min(max(v, s.Min), s.Max)
+		}
+		rank -= float64(c)
+	}
+	return s.Max
+}
+
+// bucketIndex returns the index of the bucket (base^i, base^(i+1)] containing v > 0.
+func bucketIndex(v float64, scale int32) int32 {
+	frac, exp := math.Frexp(v) // v = frac × 2^exp with 0.5 ≤ frac < 1
+	if scale <= 0 {
+		if frac == 0.5 { // exact power of two => upper bound of the previous bucket
+			exp--
+		}
+		return int32(exp-1) >> -scale
+	}
+	if frac == 0.5 {
+		return int32(exp-1)<<scale - 1
+	}
+	return int32(math.Ceil(math.Log2(v)*math.Ldexp(1, int(scale)))) - 1
+}
+
+// bucketLowerBound returns base^index = 2^(index × 2^-scale).
+func bucketLowerBound(index, scale int32) float64 {
+	return math.Exp2(math.Ldexp(float64(index), -int(scale)))
+}
@@ -0,0 +1,102 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestExpHistogram_Quantile(t *testing.T) {
+	t.Parallel()
+
+	// log-uniform latencies from 100µs to 10s
+	rng := rand.New(rand.NewPCG(1, 2))
+	values := make([]float64, 100_000)
+	h := gg.NewExpHistogram(0)
+	for i := range values {
+		values[i] = 1e-4 * math.Pow(1e5, rng.Float64())
+		h.Record(values[i])
+	}
+	slices.Sort(values)
+
+	s := h.Snapshot()
+	if s.Count != uint64(len(values)) || len(s.Positive.BucketCounts) > gg.DefaultHistogramSize {
+		t.Fatalf("count=%d buckets=%d", s.Count, len(s.Positive.BucketCounts))
+	}
+	if s.Min != values[0] || s.Max != values[len(values)-1] {
+		t.Errorf("min=%v max=%v", s.Min, s.Max)
+	}
+
+	for _, q := range []float64{0.01, 0.5, 0.9, 0.99, 0.999} {
+		want := values[int(q*float64(len(values)))]
+		got := h.Quantile(q)
+		if relErr := math.Abs(got-want) / want; relErr > 0.05 {
+			t.Errorf("q=%v got=%v want=%v (error %.1f%%)", q, got, want, 100*relErr)
+		}
+	}
+}
+
+func TestExpHistogram_Boundaries(t *testing.T) {
+	t.Parallel()
+
+	// at scale 0, the bucket i contains (2^i, 2^(i+1)]: 4 is the upper bound of bucket 1
+	h := gg.NewExpHistogram(2)
+	for _, v := range []float64{3, 4, 5, 8} {
+		h.Record(v)
+	}
+	s := h.Snapshot()
+	if s.Scale != 0 || s.Positive.Offset != 1 || !slices.Equal(s.Positive.BucketCounts, []uint64{2, 2}) {
+		t.Errorf("scale=%d offset=%d counts=%v", s.Scale, s.Positive.Offset, s.Positive.BucketCounts)
+	}
+
+	h.Record(0)
+	h.Record(-1)
+	if s = h.Snapshot(); s.ZeroCount != 2 || s.Count != 6 || s.Min != -1 {
+		t.Errorf("zero=%d count=%d min=%v", s.ZeroCount, s.Count, s.Min)
+	}
+}
+
+func TestExpHistogram_Merge(t *testing.T) {
+	t.Parallel()
+
+	fast := gg.NewExpHistogram(0)
+	slow := gg.NewExpHistogram(0)
+	all := gg.NewExpHistogram(0)
+	for i := 1; i <= 1000; i++ {
+		d := time.Duration(i) * time.Millisecond
+		fast.RecordDuration(d)
+		slow.RecordDuration(100 * d)
+		all.RecordDuration(d)
+		all.RecordDuration(100 * d)
+	}
+
+	fast.Merge(slow)
+	got, want := fast.Snapshot(), all.Snapshot()
+	if got.Count != want.Count || got.Scale != want.Scale || got.Min != want.Min || got.Max != want.Max ||
+		got.Positive.Offset != want.Positive.Offset || !slices.Equal(got.Positive.BucketCounts, want.Positive.BucketCounts) {
+		t.Errorf("merged %+v\nwant %+v", got, want)
+	}
+
+	p50 := fast.QuantileDuration(0.5)
+	if p50 < 900*time.Millisecond || p50 > 1100*time.Millisecond {
+		t.Errorf("p50=%v want ~1s", p50)
+	}
+}
+
+func TestExpHistogram_Nil(t *testing.T) {
+	t.Parallel()
+
+	var h *gg.ExpHistogram
+	h.Record(1)
+	if h.Count() != 0 || h.Quantile(0.5) != 0 || h.Mean() != 0 {
+		t.Error("nil histogram must be empty")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"html"
+	"strings"
+	"text/template"
+)
+
+type (
+	// Message is a structured notification rendered by each Notifier
+	// in its own format (Markdown, Slack mrkdwn, Telegram HTML, plain text).
+	Message struct {
+		Title    string
+		Text     string
+		Link     string // URL of the dashboard, the logs, the build...
+		Fields   []Field
+		Severity Severity
+	}
+
+	// Field is a key/value line of the Message.
+	Field struct {
+		Name  string
+		Value string
+	}
+
+	// MessageNotifier is implemented by the notifiers rendering the structured messages.
+	MessageNotifier interface {
+		Notifier
+		NotifyMessage(m Message) error
+	}
+
+	// MessageFormat renders the Message as the text sent by a Notifier.
+	MessageFormat func(m Message) string
+)
+
+// Severity of the Message.
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	case SeverityCritical:
+		return "critical"
+	default:
+		return "unknown"
+	}
+}
+
+// Emoji prefixes the title to spot the severity in the chat rooms.
+func (s Severity) Emoji() string {
+	switch s {
+	case SeverityWarning:
+		return "⚠️"
+	case SeverityError:
+		return "❌"
+	case SeverityCritical:
+		return "🚨"
+	default:
+		return "ℹ️"
+	}
+}
+
+// NotifyMessage sends the structured message when the Notifier supports it,
+// else sends the message rendered as plain text with Notify.
+func NotifyMessage(n Notifier, m Message) error {
+	if mn, ok := n.(MessageNotifier); ok {
+		return mn.NotifyMessage(m)
+	}
+	return n.Notify(PlainFormat(m))
+}
+
+// PlainFormat renders the message as plain text (logs, emails...).
+func PlainFormat(m Message) string {
+	return renderMessage(m, func(s string) string { return s },
+		func(title string) string { return m.Severity.Emoji() + " " + title },
+		func(f Field) string { return f.Name + ": " + f.Value },
+		func(link string) string { return link })
+}
+
+// MarkdownFormat renders the message for Mattermost and Discord.
+func MarkdownFormat(m Message) string {
+	return renderMessage(m, func(s string) string { return s },
+		func(title string) string { return m.Severity.Emoji() + " **" + title + "**" },
+		func(f Field) string { return "**" + f.Name + ":** " + f.Value },
+		func(link string) string { return "[" + link + "](" + link + ")" })
+}
+
+// SlackFormat renders the message using the Slack mrkdwn syntax.
+func SlackFormat(m Message) string {
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+	return renderMessage(m, escape,
+		func(title string) string { return m.Severity.Emoji() + " *" + title + "*" },
+		func(f Field) string { return "*" + f.Name + ":* " + f.Value },
+		func(link string) string { return "<" + link + ">" })
+}
+
+// HTMLFormat renders the message using the subset of HTML supported by Telegram.
+func HTMLFormat(m Message) string {
+	return renderMessage(m, html.EscapeString,
+		func(title string) string { return m.Severity.Emoji() + " <b>" + title + "</b>" },
+		func(f Field) string { return "<b>" + f.Name + ":</b> " + f.Value },
+		func(link string) string { return `<a href="` + link + `">` + link + "</a>" })
+}
+
+// TemplateFormat renders the message with a template,
+// the template data is the Message, for example:
+//
+//	tmpl := template.Must(template.New("").Parse("[{{.Severity}}] {{.Title}} {{.Link}}"))
+//	n := gg.NewSlackNotifier(hook).WithFormat(gg.TemplateFormat(tmpl))
+//
+// When the template fails, the message is rendered with PlainFormat.
+func TemplateFormat(tmpl *template.Template) MessageFormat {
+	return func(m Message) string {
+		var sb strings.Builder
+		err := tmpl.Execute(&sb, m)
+		if err != nil {
+			log.Warn("TemplateFormat", err)
+			return PlainFormat(m)
+		}
+		return sb.String()
+	}
+}
+
+// renderMessage escapes the texts before decorating them.
+func renderMessage(m Message, escape func(string) string, title func(string) string, field func(Field) string, link func(string) string) string {
+	var lines []string
+	if m.Title != "" {
+		lines = append(lines, title(escape(m.Title)))
+	}
+	if m.Text != "" {
+		lines = append(lines, escape(m.Text))
+	}
+	for _, f := range m.Fields {
+		lines = append(lines, field(Field{Name: escape(f.Name), Value: escape(f.Value)}))
+	}
+	if m.Link != "" {
+		lines = append(lines, link(escape(m.Link)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func formatOr(f, def MessageFormat) MessageFormat {
+	if f == nil {
+		return def
+	}
+	return f
+}
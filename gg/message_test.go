@@ -0,0 +1,87 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+var deployFailed = gg.Message{
+	Title:    "Deploy <prod> failed",
+	Text:     "exit status 1",
+	Link:     "https://ci.example.com/builds/42",
+	Fields:   []gg.Field{{Name: "commit", Value: "a1b2c3"}},
+	Severity: gg.SeverityError,
+}
+
+func TestMessageFormats(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		format gg.MessageFormat
+		name   string
+		want   string
+	}{
+		{gg.PlainFormat, "plain", "❌ Deploy <prod> failed\nexit status 1\ncommit: a1b2c3\nhttps://ci.example.com/builds/42"},
+		{gg.MarkdownFormat, "markdown", "❌ **Deploy <prod> failed**\nexit status 1\n**commit:** a1b2c3\n[https://ci.example.com/builds/42](https://ci.example.com/builds/42)"},
+		{gg.SlackFormat, "slack", "❌ *Deploy &lt;prod&gt; failed*\nexit status 1\n*commit:* a1b2c3\n<https://ci.example.com/builds/42>"},
+		{gg.HTMLFormat, "html", "❌ <b>Deploy &lt;prod&gt; failed</b>\nexit status 1\n<b>commit:</b> a1b2c3\n<a href=\"https://ci.example.com/builds/42\">https://ci.example.com/builds/42</a>"},
+	}
+	for _, c := range cases {
+		got := c.format(deployFailed)
+		if got != c.want {
+			t.Errorf("%s:\ngot  %q\nwant %q", c.name, got, c.want)
+		}
+	}
+
+	tmpl := template.Must(template.New("").Parse("[{{.Severity}}] {{.Title}}"))
+	got := gg.TemplateFormat(tmpl)(deployFailed)
+	if got != "[error] Deploy <prod> failed" {
+		t.Errorf("TemplateFormat: %q", got)
+	}
+}
+
+type stringNotifier struct{ got string }
+
+func (n *stringNotifier) Notify(msg string) error {
+	n.got = msg
+	return nil
+}
+
+func TestNotifyMessage(t *testing.T) {
+	t.Parallel()
+
+	// fallback to plain text for the notifiers without NotifyMessage
+	var plain stringNotifier
+	err := gg.NotifyMessage(&plain, deployFailed)
+	if err != nil || plain.got != gg.PlainFormat(deployFailed) {
+		t.Errorf("fallback err=%v got=%q", err, plain.got)
+	}
+
+	var form map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = map[string]string{"text": r.PostForm.Get("text"), "parse_mode": r.PostForm.Get("parse_mode")}
+		json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	}))
+	defer server.Close()
+
+	telegram := gg.NewTelegramNotifier(server.URL, "123").WithClient(server.Client())
+	err = gg.NotifyMessage(telegram, deployFailed)
+	if err != nil || form["parse_mode"] != "HTML" || form["text"] != gg.HTMLFormat(deployFailed) {
+		t.Errorf("Telegram err=%v form=%v", err, form)
+	}
+
+	err = telegram.Notify("a < b")
+	if err != nil || form["parse_mode"] != "" || form["text"] != "a < b" {
+		t.Errorf("Telegram Notify must stay plain text: err=%v form=%v", err, form)
+	}
+}
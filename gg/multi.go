@@ -0,0 +1,123 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Muter is the hysteresis limiter implemented by gc.Muter.
+	Muter interface {
+		Increment() (ok bool, dropped int)
+		Decrement() (ok bool, quietSince time.Time, dropped int)
+	}
+
+	// Destination is a Notifier receiving the messages
+	// having at least the MinSeverity.
+	Destination struct {
+		Notifier Notifier
+		// Muter (optional) mutes the message repeated many times in a row:
+		// an identical message (same title and text) increments the Muter,
+		// a different message decrements it.
+		Muter       Muter
+		MinSeverity Severity
+	}
+
+	// MultiNotifier sends each message to several destinations.
+	//
+	//	n := gg.NewMultiNotifier(
+	//		gg.Destination{Notifier: telegram, MinSeverity: gg.SeverityError, Muter: &gc.Muter{Threshold: 3}},
+	//		gg.Destination{Notifier: mattermost}, // all the messages
+	//	)
+	//	err := gg.NotifyMessage(n, gg.Message{Title: "disk full", Severity: gg.SeverityCritical})
+	MultiNotifier struct {
+		dests []destination
+	}
+
+	destination struct {
+		mu   *sync.Mutex // protects the Muter and last
+		last string
+		Destination
+	}
+)
+
+// NewMultiNotifier creates a MultiNotifier. A nil Notifier is ignored.
+func NewMultiNotifier(dests ...Destination) *MultiNotifier {
+	m := &MultiNotifier{dests: make([]destination, 0, len(dests))}
+	for _, d := range dests {
+		if d.Notifier == nil {
+			continue
+		}
+		m.dests = append(m.dests, destination{Destination: d, mu: &sync.Mutex{}})
+	}
+	return m
+}
+
+// Notify sends the message with the SeverityInfo.
+func (m *MultiNotifier) Notify(msg string) error {
+	return m.NotifyMessage(Message{Text: msg})
+}
+
+// NotifyMessage sends the message concurrently to the destinations
+// accepting its severity and returns the joined errors.
+func (m *MultiNotifier) NotifyMessage(msg Message) error {
+	errs := make([]error, len(m.dests))
+
+	var wg sync.WaitGroup
+	for i := range m.dests {
+		d := &m.dests[i]
+		if msg.Severity < d.MinSeverity {
+			continue
+		}
+		wg.Go(func() {
+			out, ok := d.filter(msg)
+			if ok {
+				errs[i] = NotifyMessage(d.Notifier, out)
+			}
+		})
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// filter applies the Muter and reports the muted messages in the fields.
+func (d *destination) filter(msg Message) (Message, bool) {
+	if d.Muter == nil {
+		return msg, true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// the destinations share the backing array of msg.Fields: append must reallocate
+	msg.Fields = slices.Clip(msg.Fields)
+
+	key := msg.Title + "\x00" + msg.Text
+	if key != d.last {
+		d.last = key
+		unmuted, _, dropped := d.Muter.Decrement()
+		if unmuted && dropped > 0 {
+			msg.Fields = append(msg.Fields, Field{Name: "muted", Value: strconv.Itoa(dropped) + " repeated messages"})
+		}
+		return msg, true
+	}
+
+	ok, dropped := d.Muter.Increment()
+	switch {
+	case !ok:
+		return msg, false
+	case dropped == 1:
+		msg.Fields = append(msg.Fields, Field{Name: "muted", Value: "next repetitions are muted"})
+	case dropped > 1:
+		msg.Fields = append(msg.Fields, Field{Name: "muted", Value: strconv.Itoa(dropped) + " repetitions so far"})
+	}
+	return msg, true
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type recordNotifier struct {
+	err  error
+	msgs []string
+	mu   sync.Mutex
+}
+
+func (n *recordNotifier) Notify(msg string) error {
+	n.mu.Lock()
+	n.msgs = append(n.msgs, msg)
+	n.mu.Unlock()
+	return n.err
+}
+
+func TestMultiNotifier_Severity(t *testing.T) {
+	t.Parallel()
+
+	ops := &recordNotifier{}
+	team := &recordNotifier{}
+	n := gg.NewMultiNotifier(
+		gg.Destination{Notifier: ops, MinSeverity: gg.SeverityError},
+		gg.Destination{Notifier: team},
+		gg.Destination{}, // ignored
+	)
+
+	err := n.Notify("new release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = gg.NotifyMessage(n, gg.Message{Title: "disk full", Severity: gg.SeverityCritical})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ops.msgs) != 1 || !strings.Contains(ops.msgs[0], "disk full") {
+		t.Errorf("ops got %q", ops.msgs)
+	}
+	if len(team.msgs) != 2 || team.msgs[0] != "new release" {
+		t.Errorf("team got %q", team.msgs)
+	}
+
+	errDown := errors.New("down")
+	ops.err = errDown
+	err = gg.NotifyMessage(n, gg.Message{Title: "oom", Severity: gg.SeverityError})
+	if !errors.Is(err, errDown) || len(team.msgs) != 3 {
+		t.Errorf("err=%v team=%d messages", err, len(team.msgs))
+	}
+}
+
+func TestMultiNotifier_Muter(t *testing.T) {
+	t.Parallel()
+
+	rec := &recordNotifier{}
+	n := gg.NewMultiNotifier(gg.Destination{Notifier: rec, Muter: &gc.Muter{Threshold: 2}})
+
+	for _, msg := range []string{"A", "A", "A", "A", "A", "B", "C"} {
+		err := n.Notify(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"A", "A", "A",
+		"A\nmuted: next repetitions are muted",
+		// the fifth "A" is muted
+		"B",
+		"C\nmuted: 1 repeated messages",
+	}
+	if strings.Join(rec.msgs, "|") != strings.Join(want, "|") {
+		t.Errorf("got  %q\nwant %q", rec.msgs, want)
+	}
+}
+
+func TestMultiNotifier_SharedFields(t *testing.T) {
+	t.Parallel()
+
+	recs := []*recordNotifier{{}, {}}
+	n := gg.NewMultiNotifier(
+		gg.Destination{Notifier: recs[0], Muter: &gc.Muter{Threshold: 1}},
+		gg.Destination{Notifier: recs[1], Muter: &gc.Muter{Threshold: 1}},
+	)
+
+	// the spare capacity must not be shared by the destinations appending their "muted" field
+	fields := make([]gg.Field, 1, 4)
+	fields[0] = gg.Field{Name: "host", Value: "web1"}
+	for range 3 {
+		err := gg.NotifyMessage(n, gg.Message{Title: "disk full", Fields: fields})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, rec := range recs {
+		if len(rec.msgs) != 3 || !strings.HasSuffix(rec.msgs[2], "host: web1\nmuted: next repetitions are muted") {
+			t.Errorf("destination %d got %q", i, rec.msgs)
+		}
+	}
+	if fields[:2][1] != (gg.Field{}) {
+		t.Errorf("the caller's array is modified: %+v", fields[:2])
+	}
+}
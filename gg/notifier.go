@@ -6,12 +6,16 @@ package gg
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/lynxai-team/garcon/gerr"
 )
 
 type (
@@ -27,36 +31,142 @@ type (
 
 	// MattermostNotifier for sending messages to a Mattermost server.
 	MattermostNotifier struct {
+		client   *http.Client
+		format   MessageFormat
 		endpoint string
 	}
 )
 
 // NewMattermostNotifier creates a MattermostNotifier given a Mattermost server endpoint (see mattermost hooks).
 func NewMattermostNotifier(endpoint string) MattermostNotifier {
-	return MattermostNotifier{endpoint}
+	return MattermostNotifier{endpoint: endpoint}
+}
+
+// WithClient returns a copy of the notifier sending its messages with the given http.Client,
+// for example a client using a CachingResolver. The default client rejects the private IPs (see SSRFGuard).
+func (n MattermostNotifier) WithClient(client *http.Client) MattermostNotifier {
+	n.client = client
+	return n
+}
+
+// WithFormat returns a copy of the notifier rendering the messages with f
+// (default MarkdownFormat).
+func (n MattermostNotifier) WithFormat(f MessageFormat) MattermostNotifier {
+	n.format = f
+	return n
 }
 
 // NewNotifier selects the Notifier type depending on the parameter pattern.
+//
+// The webhook notifiers reject the private, loopback and link-local addresses (see SSRFGuard).
+// The DSN parameter "allow-private" (see CutAllowPrivate) disables this check
+// for an intranet server, for example:
+//
+//	https://mattermost.intranet/hooks/xxx?allow-private=true
 func NewNotifier(dataSourceName string) Notifier {
 	if dataSourceName == "" {
 		log.Info("empty dataSourceName => use the LogNotifier")
 		return NewLogNotifier()
 	}
 
+	dataSourceName, allowPrivate := CutAllowPrivate(dataSourceName)
+	var client *http.Client
+	if allowPrivate {
+		log.Info("allow-private => the notifier may send to private addresses")
+		client = privateClient
+	}
+
 	const telegramPrefix = "https://api.telegram.org/bot"
 	if strings.HasPrefix(dataSourceName, telegramPrefix) {
 		log.Info("URL has the Telegram prefix: " + dataSourceName)
 		p := SplitClean(dataSourceName)
 		if len(p) == 2 {
-			return NewTelegramNotifier(p[0], p[1])
+			return NewTelegramNotifier(p[0], p[1]).WithClient(client)
 		}
 
 		log.Error("Cannot retrieve ChatID from %v", p)
 		return NewLogNotifier()
 	}
 
+	if strings.HasPrefix(dataSourceName, "smtp://") ||
+		strings.HasPrefix(dataSourceName, "smtps://") ||
+		strings.HasPrefix(dataSourceName, "mailto:") {
+		n, err := NewEmailNotifier(dataSourceName)
+		if err != nil {
+			log.Error(err)
+			return NewLogNotifier()
+		}
+		log.Info("Email notifications to", strings.Join(n.to, ", "))
+		return n
+	}
+
+	u, err := url.Parse(dataSourceName)
+	if err == nil {
+		switch {
+		case u.Host == "hooks.slack.com":
+			log.Info("URL has the Slack host: " + u.Host)
+			return NewSlackNotifier(dataSourceName).WithClient(client)
+		case (u.Host == "discord.com" || u.Host == "discordapp.com") && strings.HasPrefix(u.Path, "/api/webhooks/"):
+			log.Info("URL has the Discord webhook prefix: " + u.Host + "/api/webhooks/")
+			return NewDiscordNotifier(dataSourceName).WithClient(client)
+		}
+	}
+
 	// default
-	return NewMattermostNotifier(dataSourceName)
+	return NewMattermostNotifier(dataSourceName).WithClient(client)
+}
+
+// AllowPrivateParam is the DSN query parameter disabling the SSRF check of the notifiers.
+const AllowPrivateParam = "allow-private"
+
+// CutAllowPrivate removes the "allow-private" parameter from the DSN query
+// and reports whether it enables the private addresses
+// ("allow-private", "allow-private=true", "allow-private=1"...).
+// The other parameters are kept in their original order.
+func CutAllowPrivate(dsn string) (string, bool) {
+	base, query, ok := strings.Cut(dsn, "?")
+	if !ok {
+		return dsn, false
+	}
+	query, fragment, hasFragment := strings.Cut(query, "#")
+
+	allow := false
+	kept := make([]string, 0, strings.Count(query, "&")+1)
+	for param := range strings.SplitSeq(query, "&") {
+		key, value, hasValue := strings.Cut(param, "=")
+		if key != AllowPrivateParam {
+			kept = append(kept, param)
+			continue
+		}
+		if !hasValue {
+			allow = true
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			log.Warnf("NewNotifier: invalid %s=%q => ignored", AllowPrivateParam, value)
+		}
+		allow = b
+	}
+
+	dsn = base
+	if q := strings.Join(kept, "&"); q != "" {
+		dsn += "?" + q
+	}
+	if hasFragment {
+		dsn += "#" + fragment
+	}
+	return dsn, allow
+}
+
+// NotifyCtx sends the message prefixed by the request ID (if any)
+// in order to correlate the alert with the logs of the originating request.
+func NotifyCtx(ctx context.Context, n Notifier, msg string) error {
+	id := gerr.RequestID(ctx)
+	if id != "" {
+		msg = "[" + id + "] " + msg
+	}
+	return n.Notify(msg)
 }
 
 // NewLogNotifier creates a LogNotifier.
@@ -70,13 +180,18 @@ func (n LogNotifier) Notify(msg string) error {
 	return nil
 }
 
+// NotifyMessage prints the message as plain text.
+func (n LogNotifier) NotifyMessage(m Message) error {
+	return n.Notify(PlainFormat(m))
+}
+
 // Notify sends a message to a Mattermost server.
 func (n MattermostNotifier) Notify(msg string) error {
-	buf := strconv.AppendQuoteToGraphic([]byte(`{"text":`), msg)
+	buf := appendJSONString([]byte(`{"text":`), msg)
 	buf = append(buf, byte('}'))
 	body := bytes.NewBuffer(buf)
 
-	resp, err := http.Post(n.endpoint, "application/json", body)
+	resp, err := httpClient(n.client).Post(n.endpoint, "application/json", body)
 	if err != nil {
 		return fmt.Errorf("MattermostNotifier: %w from host=%s", err, n.host())
 	}
@@ -88,8 +203,116 @@ func (n MattermostNotifier) Notify(msg string) error {
 	return nil
 }
 
+// NotifyMessage sends the message rendered in Markdown.
+func (n MattermostNotifier) NotifyMessage(m Message) error {
+	return n.Notify(formatOr(n.format, MarkdownFormat)(m))
+}
+
 func (n MattermostNotifier) host() string {
-	u, err := url.Parse(n.endpoint)
+	return hostname(n.endpoint)
+}
+
+// SlackNotifier sends messages to a Slack incoming webhook
+// (https://hooks.slack.com/services/...).
+type SlackNotifier struct {
+	client   *http.Client
+	format   MessageFormat
+	endpoint string
+}
+
+// NewSlackNotifier creates a SlackNotifier given the webhook URL.
+func NewSlackNotifier(endpoint string) SlackNotifier {
+	return SlackNotifier{endpoint: endpoint}
+}
+
+// WithClient returns a copy of the notifier sending its messages with the given http.Client.
+func (n SlackNotifier) WithClient(client *http.Client) SlackNotifier {
+	n.client = client
+	return n
+}
+
+// WithFormat returns a copy of the notifier rendering the messages with f
+// (default SlackFormat).
+func (n SlackNotifier) WithFormat(f MessageFormat) SlackNotifier {
+	n.format = f
+	return n
+}
+
+// Notify sends a message to the Slack webhook.
+func (n SlackNotifier) Notify(msg string) error {
+	return postJSON(n.client, n.endpoint, "SlackNotifier", map[string]string{"text": msg})
+}
+
+// NotifyMessage sends the message rendered in Slack mrkdwn.
+func (n SlackNotifier) NotifyMessage(m Message) error {
+	return n.Notify(formatOr(n.format, SlackFormat)(m))
+}
+
+// DiscordNotifier sends messages to a Discord webhook
+// (https://discord.com/api/webhooks/...).
+type DiscordNotifier struct {
+	client   *http.Client
+	format   MessageFormat
+	endpoint string
+}
+
+// discordMaxLen is the maximum length of a Discord message.
+const discordMaxLen = 2000
+
+// NewDiscordNotifier creates a DiscordNotifier given the webhook URL.
+func NewDiscordNotifier(endpoint string) DiscordNotifier {
+	return DiscordNotifier{endpoint: endpoint}
+}
+
+// WithClient returns a copy of the notifier sending its messages with the given http.Client.
+func (n DiscordNotifier) WithClient(client *http.Client) DiscordNotifier {
+	n.client = client
+	return n
+}
+
+// WithFormat returns a copy of the notifier rendering the messages with f
+// (default MarkdownFormat).
+func (n DiscordNotifier) WithFormat(f MessageFormat) DiscordNotifier {
+	n.format = f
+	return n
+}
+
+// Notify sends a message to the Discord webhook.
+// The message is truncated to the 2000 characters accepted by Discord.
+func (n DiscordNotifier) Notify(msg string) error {
+	if runes := []rune(msg); len(runes) > discordMaxLen {
+		msg = string(runes[:discordMaxLen-1]) + "…"
+	}
+	return postJSON(n.client, n.endpoint, "DiscordNotifier", map[string]string{"content": msg})
+}
+
+// NotifyMessage sends the message rendered in Markdown.
+func (n DiscordNotifier) NotifyMessage(m Message) error {
+	return n.Notify(formatOr(n.format, MarkdownFormat)(m))
+}
+
+// postJSON sends the webhook payload and accepts any 2xx status
+// (Slack responds 200, Discord responds 204).
+func postJSON(client *http.Client, endpoint, name string, payload any) error {
+	buf, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	resp, err := httpClient(client).Post(endpoint, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("%s: %w from host=%s", name, err, hostname(endpoint))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s from host=%s", name, resp.Status, hostname(endpoint))
+	}
+	return nil
+}
+
+func hostname(endpoint string) string {
+	u, err := url.Parse(endpoint)
 	if err == nil {
 		return u.Hostname()
 	}
@@ -98,6 +321,8 @@ func (n MattermostNotifier) host() string {
 
 // TelegramNotifier is a Notifier for a specific Telegram chat room.
 type TelegramNotifier struct {
+	client   *http.Client
+	format   MessageFormat
 	endpoint string
 	chatID   string
 }
@@ -110,14 +335,33 @@ func NewTelegramNotifier(endpoint, chatID string) TelegramNotifier {
 	}
 }
 
+// WithClient returns a copy of the notifier sending its messages with the given http.Client.
+func (n TelegramNotifier) WithClient(client *http.Client) TelegramNotifier {
+	n.client = client
+	return n
+}
+
+// WithFormat returns a copy of the notifier rendering the messages with f
+// (default HTMLFormat). The rendered text is parsed as HTML by Telegram,
+// so f must escape the texts.
+func (n TelegramNotifier) WithFormat(f MessageFormat) TelegramNotifier {
+	n.format = f
+	return n
+}
+
 // Notify sends a message to the Telegram server.
 func (n TelegramNotifier) Notify(msg string) error {
-	response, err := http.PostForm(
-		n.endpoint,
-		url.Values{
-			"chat_id": {n.chatID},
-			"text":    {msg},
-		})
+	return n.send(url.Values{"chat_id": {n.chatID}, "text": {msg}})
+}
+
+// NotifyMessage sends the message rendered in HTML.
+func (n TelegramNotifier) NotifyMessage(m Message) error {
+	text := formatOr(n.format, HTMLFormat)(m)
+	return n.send(url.Values{"chat_id": {n.chatID}, "text": {text}, "parse_mode": {"HTML"}})
+}
+
+func (n TelegramNotifier) send(form url.Values) error {
+	response, err := httpClient(n.client).PostForm(n.endpoint, form)
 	if err != nil {
 		return fmt.Errorf("TelegramNotifier chat_id=%s: %w", n.chatID, err)
 	}
@@ -137,6 +381,29 @@ func (n TelegramNotifier) Notify(msg string) error {
 	return nil
 }
 
+// notifierClient sends the notifications of the notifiers without WithClient.
+// It rejects the private, loopback and link-local addresses (see SSRFGuard)
+// because the webhook URL may come from user input.
+// An intranet server requires the DSN parameter "allow-private" (see NewNotifier)
+// or WithClient((&SSRFGuard{AllowPrivate: true}).Client(timeout)).
+//
+//nolint:gochecknoglobals // shared client reusing the connections
+var notifierClient = (&SSRFGuard{Resolver: DefaultResolver}).Client(notifierTimeout)
+
+// privateClient is the client of the notifiers created with "allow-private".
+//
+//nolint:gochecknoglobals // shared client reusing the connections
+var privateClient = (&SSRFGuard{Resolver: DefaultResolver, AllowPrivate: true}).Client(notifierTimeout)
+
+const notifierTimeout = 30 * time.Second
+
+func httpClient(client *http.Client) *http.Client {
+	if client == nil {
+		return notifierClient
+	}
+	return client
+}
+
 type telegramResponse struct {
 	Result struct {
 		Text string `json:"text"`
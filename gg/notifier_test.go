@@ -5,7 +5,13 @@
 package gg_test
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/lynxai-team/garcon/gg"
 )
@@ -23,3 +29,102 @@ func TestNotifier_Notify(t *testing.T) {
 		t.Error("want: " + want)
 	}
 }
+
+func TestNewNotifier_detection(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		url  string
+		want gg.Notifier
+	}{
+		{"", gg.LogNotifier{}},
+		{"https://hooks.slack.com/services/T000/B000/XXXX", gg.SlackNotifier{}},
+		{"https://discord.com/api/webhooks/123/abc", gg.DiscordNotifier{}},
+		{"https://discordapp.com/api/webhooks/123/abc", gg.DiscordNotifier{}},
+		{"https://discord.com/channels/123", gg.MattermostNotifier{}},
+		{"https://framateam.org/hooks/xxx", gg.MattermostNotifier{}},
+	}
+	for _, c := range cases {
+		got := gg.NewNotifier(c.url)
+		if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", c.want) {
+			t.Errorf("NewNotifier(%q) = %T want %T", c.url, got, c.want)
+		}
+	}
+}
+
+func TestWebhookNotifiers(t *testing.T) {
+	t.Parallel()
+
+	var got map[string]string
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = nil
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	err := gg.NewSlackNotifier(server.URL).WithClient(server.Client()).Notify("hello slack")
+	if err != nil || got["text"] != "hello slack" {
+		t.Errorf("Slack err=%v payload=%v", err, got)
+	}
+
+	long := strings.Repeat("é", 3000)
+	err = gg.NewDiscordNotifier(server.URL).WithClient(server.Client()).Notify(long)
+	if err != nil || utf8.RuneCountInString(got["content"]) != 2000 {
+		t.Errorf("Discord err=%v len=%d", err, utf8.RuneCountInString(got["content"]))
+	}
+
+	status = http.StatusForbidden
+	err = gg.NewDiscordNotifier(server.URL).WithClient(server.Client()).Notify("x")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("want 403 error, got %v", err)
+	}
+}
+
+func TestNewNotifier_allowPrivate(t *testing.T) {
+	t.Parallel()
+
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+	}))
+	defer server.Close()
+
+	err := gg.NewNotifier(server.URL + "/hooks/xxx").Notify("loopback")
+	if err == nil {
+		t.Error("want the loopback address rejected by default")
+	}
+
+	err = gg.NewNotifier(server.URL + "/hooks/xxx?a=1&allow-private=true&b=2").Notify("loopback")
+	if err != nil {
+		t.Errorf("allow-private=true: %v", err)
+	}
+	if query != "a=1&b=2" {
+		t.Errorf("query sent to the server = %q want %q", query, "a=1&b=2")
+	}
+}
+
+func TestCutAllowPrivate(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		dsn   string
+		want  string
+		allow bool
+	}{
+		{"https://h/hooks/x", "https://h/hooks/x", false},
+		{"https://h/hooks/x?allow-private", "https://h/hooks/x", true},
+		{"https://h/hooks/x?allow-private=1", "https://h/hooks/x", true},
+		{"https://h/hooks/x?allow-private=false", "https://h/hooks/x", false},
+		{"https://h/hooks/x?a=1&allow-private=true", "https://h/hooks/x?a=1", true},
+		{"https://h/hooks/x?allow-private=true#frag", "https://h/hooks/x#frag", true},
+		{"mailto:ops@example.com?smtp=10.0.0.1:25&allow-private", "mailto:ops@example.com?smtp=10.0.0.1:25", true},
+	}
+	for _, c := range cases {
+		got, allow := gg.CutAllowPrivate(c.dsn)
+		if got != c.want || allow != c.allow {
+			t.Errorf("CutAllowPrivate(%q) = %q %v want %q %v", c.dsn, got, allow, c.want, c.allow)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+type (
+	// Pool processes items with a bounded number of concurrent workers:
+	//
+	//	pool := gg.NewPool(4, func(ctx context.Context, file string) error {
+	//		return extract(ctx, file)
+	//	})
+	//	pool.OnProgress = func(p gg.PoolProgress) { log.Printf("%d/%d files", p.Done, p.Total) }
+	//	err := pool.Run(ctx, files) // the errors of all the items, in the items order
+	//
+	// The items not yet started are skipped when ctx is canceled,
+	// or after the first error with FailFast (the ctx of the running items is canceled).
+	// A panic of Work is recovered as a gerr.ServerErr.
+	Pool[T any] struct {
+		// Work processes one item.
+		Work func(ctx context.Context, item T) error
+		// OnProgress (optional) is called after each item, never concurrently.
+		OnProgress func(PoolProgress)
+		// Workers is the maximum number of concurrent items (default GOMAXPROCS).
+		Workers int
+		// FailFast stops the Pool on the first error.
+		FailFast bool
+	}
+
+	// PoolProgress is the state of the Pool after an item.
+	PoolProgress struct {
+		Err    error // error of the item, nil on success
+		Total  int   // number of items, zero when unknown (RunSeq)
+		Done   int   // processed items (successful or failed)
+		Failed int
+	}
+
+	indexedErr struct {
+		err   error
+		index int
+	}
+)
+
+// NewPool creates a Pool, zero workers means GOMAXPROCS.
+func NewPool[T any](workers int, work func(ctx context.Context, item T) error) *Pool[T] {
+	return &Pool[T]{Work: work, Workers: workers}
+}
+
+// Run processes the items and returns the joined errors (nil when all succeed).
+// Each error is prefixed by the item index.
+func (p *Pool[T]) Run(ctx context.Context, items []T) error {
+	return p.run(ctx, slices.Values(items), len(items))
+}
+
+// RunSeq is like Run for items produced on the fly (PoolProgress.Total is zero).
+func (p *Pool[T]) RunSeq(ctx context.Context, items iter.Seq[T]) error {
+	return p.run(ctx, items, 0)
+}
+
+func (p *Pool[T]) run(parent context.Context, items iter.Seq[T], total int) error {
+	ctx, cancel := context.WithCancelCause(parent)
+	defer cancel(nil)
+
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	sem := make(chan struct{}, workers)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		errs     []indexedErr
+		progress = PoolProgress{Total: total}
+	)
+
+	index := 0
+	for item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		i := index
+		index++
+		wg.Go(func() {
+			defer func() { <-sem }()
+			err := p.work(ctx, item)
+
+			mu.Lock()
+			defer mu.Unlock()
+			progress.Done++
+			progress.Err = err
+			if err != nil {
+				progress.Failed++
+				errs = append(errs, indexedErr{fmt.Errorf("item #%d: %w", i, err), i})
+				if p.FailFast {
+					cancel(err)
+				}
+			}
+			if p.OnProgress != nil {
+				p.OnProgress(progress)
+			}
+		})
+	}
+	wg.Wait()
+
+	slices.SortFunc(errs, func(a, b indexedErr) int { return cmp.Compare(a.index, b.index) })
+	joined := make([]error, 0, len(errs)+1)
+	for _, e := range errs {
+		joined = append(joined, e.err)
+	}
+	if parent.Err() != nil {
+		joined = append(joined, context.Cause(parent))
+	}
+	return errors.Join(joined...)
+}
+
+func (p *Pool[T]) work(ctx context.Context, item T) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = gerr.Recovered(v)
+		}
+	}()
+	return p.Work(ctx, item)
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestPool(t *testing.T) {
+	t.Parallel()
+
+	var running, peak atomic.Int32
+	pool := gg.NewPool(3, func(_ context.Context, n int) error {
+		cur := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); cur > p && !peak.CompareAndSwap(p, cur); p = peak.Load() {
+		}
+		time.Sleep(time.Millisecond)
+		switch n {
+		case 4:
+			return errors.New("four")
+		case 7:
+			panic("seven")
+		}
+		return nil
+	})
+	var progress []gg.PoolProgress
+	pool.OnProgress = func(p gg.PoolProgress) { progress = append(progress, p) }
+
+	err := pool.Run(context.Background(), []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
+	if peak.Load() > 3 {
+		t.Errorf("%d concurrent workers, want at most 3", peak.Load())
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "item #4: four\nitem #7: ") || !strings.Contains(err.Error(), "seven") {
+		t.Errorf("err=%v", err)
+	}
+	var ge *gerr.Error
+	if !errors.As(err, &ge) || ge.Code != gerr.ServerErr {
+		t.Errorf("the panic must be a gerr.ServerErr: %v", err)
+	}
+	last := progress[len(progress)-1]
+	if len(progress) != 10 || last.Done != 10 || last.Failed != 2 || last.Total != 10 {
+		t.Errorf("last progress=%+v (%d callbacks)", last, len(progress))
+	}
+}
+
+func TestPool_FailFast(t *testing.T) {
+	t.Parallel()
+
+	var started atomic.Int32
+	pool := gg.NewPool(2, func(ctx context.Context, n int) error {
+		started.Add(1)
+		if n == 0 {
+			return errors.New("first")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	pool.FailFast = true
+
+	err := pool.RunSeq(context.Background(), maps.Keys(map[int]bool{0: true}))
+	if err == nil {
+		t.Fatal("want the error of the first item")
+	}
+
+	started.Store(0)
+	err = pool.Run(context.Background(), slices.Repeat([]int{0}, 100))
+	if err == nil || started.Load() > 10 {
+		t.Errorf("FailFast must skip the remaining items: started=%d err=%v", started.Load(), err)
+	}
+}
+
+func TestPool_Canceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := gg.NewPool(1, func(context.Context, int) error {
+		cancel()
+		return nil
+	})
+	err := pool.Run(ctx, []int{1, 2, 3})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err=%v", err)
+	}
+}
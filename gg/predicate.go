@@ -0,0 +1,43 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"net/http"
+	"strings"
+)
+
+// IsDev returns a predicate satisfied when dev is true.
+// The value is fixed at chain construction,
+// typically from a command line flag or gc.Garcon.IsDevMode().
+func IsDev(dev bool) Predicate {
+	return func(*http.Request) bool { return dev }
+}
+
+// PathPrefix returns a predicate satisfied when the request URL path
+// starts with one of the given prefixes.
+func PathPrefix(prefixes ...string) Predicate {
+	return func(r *http.Request) bool {
+		for _, p := range prefixes {
+			if strings.HasPrefix(r.URL.Path, p) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// HeaderEquals returns a predicate satisfied when
+// the first value of the request header matches the given value.
+func HeaderEquals(header, value string) Predicate {
+	return func(r *http.Request) bool {
+		return r.Header.Get(header) == value
+	}
+}
+
+// Not inverts a predicate.
+func Not(predicate Predicate) Predicate {
+	return func(r *http.Request) bool { return !predicate(r) }
+}
@@ -0,0 +1,255 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+type (
+	// CachingResolver wraps a net.Resolver and caches the resolved addresses
+	// to avoid a DNS round trip for every outbound request (notifiers, API clients...).
+	// The standard resolver does not expose the record TTL,
+	// so TTL is the maximum duration an entry is kept in the cache.
+	// Failed lookups are also cached during NegativeTTL
+	// to avoid hammering the DNS server when a host is unreachable.
+	// The expired entries are removed when looked up, or when the cache is full.
+	CachingResolver struct {
+		Resolver    *net.Resolver
+		dialer      *net.Dialer
+		cache       map[string]dnsEntry
+		group       singleflight.Group
+		TTL         time.Duration
+		NegativeTTL time.Duration
+		// MaxEntries bounds the cache: when full, the expired entries are removed,
+		// else the entry expiring first. Zero means no bound.
+		MaxEntries int
+		mu         sync.RWMutex
+		hits       atomic.Uint64
+		misses     atomic.Uint64
+		negHits    atomic.Uint64
+		failures   atomic.Uint64
+	}
+
+	// ResolverStats is a snapshot of the CachingResolver metrics.
+	ResolverStats struct {
+		Hits         uint64 `json:"hits"`
+		Misses       uint64 `json:"misses"`
+		NegativeHits uint64 `json:"negative_hits"`
+		Failures     uint64 `json:"failures"`
+		Entries      int    `json:"entries"`
+	}
+
+	dnsEntry struct {
+		expires time.Time
+		err     error
+		addrs   []string
+	}
+)
+
+// DefaultResolver is the CachingResolver shared by the outbound clients of Garcon:
+// the default clients of the notifiers, of the AdaptiveRate retries,
+// of ServeOrigin, of the OTLP exporter...
+//
+//nolint:gochecknoglobals // shared cache
+var DefaultResolver = NewCachingResolver(0, 0)
+
+const (
+	defaultResolverMaxEntries = 1024
+	// lookupTimeout bounds the DNS query shared by the concurrent callers.
+	lookupTimeout = 10 * time.Second
+)
+
+// NewCachingResolver creates a CachingResolver using the default net.Resolver.
+// A zero ttl defaults to one minute, a zero negativeTTL defaults to five seconds.
+// The cache keeps up to 1024 hosts (see MaxEntries).
+func NewCachingResolver(ttl, negativeTTL time.Duration) *CachingResolver {
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	if negativeTTL <= 0 {
+		negativeTTL = 5 * time.Second
+	}
+	return &CachingResolver{
+		Resolver:    net.DefaultResolver,
+		dialer:      &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		cache:       map[string]dnsEntry{},
+		TTL:         ttl,
+		NegativeTTL: negativeTTL,
+		MaxEntries:  defaultResolverMaxEntries,
+	}
+}
+
+// LookupHost returns the addresses of the host, from the cache when still fresh.
+// Concurrent lookups of the same host share a single DNS query,
+// each caller returning as soon as its own ctx is done.
+func (cr *CachingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+
+	now := time.Now()
+	cr.mu.RLock()
+	entry, ok := cr.cache[host]
+	cr.mu.RUnlock()
+	if ok && now.Before(entry.expires) {
+		if entry.err != nil {
+			cr.negHits.Add(1)
+			return nil, entry.err
+		}
+		cr.hits.Add(1)
+		return entry.addrs, nil
+	}
+	if ok {
+		cr.removeExpired(host, now)
+	}
+
+	cr.misses.Add(1)
+	// The shared query does not depend on the context of the first caller:
+	// its cancellation must not fail the other callers waiting for the same host.
+	ch := cr.group.DoChan(host, func() (any, error) {
+		lookupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lookupTimeout)
+		defer cancel()
+		addrs, err := cr.Resolver.LookupHost(lookupCtx, host)
+		entry := dnsEntry{addrs: addrs, err: err, expires: time.Now().Add(cr.TTL)}
+		if err != nil {
+			cr.failures.Add(1)
+			entry.expires = time.Now().Add(cr.NegativeTTL)
+		}
+		cr.store(host, entry)
+		return addrs, err
+	})
+
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		addrs, _ := res.Val.([]string)
+		return addrs, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// removeExpired removes the entry of the host when still expired.
+func (cr *CachingResolver) removeExpired(host string, now time.Time) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if e, ok := cr.cache[host]; ok && !now.Before(e.expires) {
+		delete(cr.cache, host)
+	}
+}
+
+// store adds the entry, evicting the expired entries when the cache is full.
+func (cr *CachingResolver) store(host string, entry dnsEntry) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if _, ok := cr.cache[host]; !ok && cr.MaxEntries > 0 && len(cr.cache) >= cr.MaxEntries {
+		cr.evict(time.Now())
+	}
+	cr.cache[host] = entry
+}
+
+// evict removes the expired entries, or the entry expiring first when none is expired.
+// The caller holds the write lock.
+func (cr *CachingResolver) evict(now time.Time) {
+	n := len(cr.cache)
+	var first string
+	var firstExpires time.Time
+	for host, e := range cr.cache {
+		if !now.Before(e.expires) {
+			delete(cr.cache, host)
+		} else if first == "" || e.expires.Before(firstExpires) {
+			first, firstExpires = host, e.expires
+		}
+	}
+	if len(cr.cache) == n {
+		delete(cr.cache, first)
+	}
+}
+
+// DialContext resolves the host using the cache
+// and then tries each address until one connection succeeds.
+// DialContext can be used as http.Transport.DialContext.
+func (cr *CachingResolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return cr.dial(ctx, cr.dialer, network, address)
+}
+
+// dial resolves the host using the cache and connects with the dialer.
+func (cr *CachingResolver) dial(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := cr.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, addr := range addrs {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Transport returns a clone of http.DefaultTransport using the CachingResolver.
+func (cr *CachingResolver) Transport() *http.Transport {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		t = &http.Transport{}
+	} else {
+		t = t.Clone()
+	}
+	t.DialContext = cr.DialContext
+	return t
+}
+
+// Client returns a http.Client using the CachingResolver.
+func (cr *CachingResolver) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: cr.Transport(), Timeout: timeout}
+}
+
+// Flush removes all the cached entries.
+func (cr *CachingResolver) Flush() {
+	cr.mu.Lock()
+	clear(cr.cache)
+	cr.mu.Unlock()
+}
+
+// Stats returns the counters since the creation of the CachingResolver.
+func (cr *CachingResolver) Stats() ResolverStats {
+	cr.mu.RLock()
+	n := len(cr.cache)
+	cr.mu.RUnlock()
+	return ResolverStats{
+		Hits:         cr.hits.Load(),
+		Misses:       cr.misses.Load(),
+		NegativeHits: cr.negHits.Load(),
+		Failures:     cr.failures.Load(),
+		Entries:      n,
+	}
+}
+
+// LogStats prints the counters in the logs.
+func (cr *CachingResolver) LogStats() {
+	s := cr.Stats()
+	log.Infof("CachingResolver hits=%d misses=%d negative_hits=%d failures=%d entries=%d",
+		s.Hits, s.Misses, s.NegativeHits, s.Failures, s.Entries)
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// failingResolver returns a net.Resolver that never reaches a DNS server.
+func failingResolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			return nil, errors.New("no DNS server in tests")
+		},
+	}
+}
+
+func TestCachingResolver_LookupHost(t *testing.T) {
+	t.Parallel()
+
+	cr := gg.NewCachingResolver(time.Minute, time.Minute)
+	cr.Resolver = failingResolver()
+	ctx := context.Background()
+
+	// IP literals bypass the cache
+	addrs, err := cr.LookupHost(ctx, "127.0.0.1")
+	if err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+		t.Errorf("LookupHost(127.0.0.1) = %v, %v", addrs, err)
+	}
+
+	for range 3 {
+		_, err = cr.LookupHost(ctx, "unknown.invalid")
+		if err == nil {
+			t.Error("LookupHost(unknown.invalid) must fail")
+		}
+	}
+
+	s := cr.Stats()
+	if s.Misses != 1 || s.NegativeHits != 2 || s.Failures != 1 || s.Entries != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	cr.Flush()
+	if cr.Stats().Entries != 0 {
+		t.Error("Flush must remove all entries")
+	}
+}
+
+func TestCachingResolver_Client(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	cr := gg.NewCachingResolver(0, 0)
+	cr.Resolver = failingResolver()
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := cr.Client(time.Second).Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("got status %d", resp.StatusCode)
+	}
+}
+
+func TestCachingResolver_MaxEntries(t *testing.T) {
+	t.Parallel()
+
+	cr := gg.NewCachingResolver(time.Minute, time.Minute)
+	cr.Resolver = failingResolver()
+	cr.MaxEntries = 2
+	ctx := context.Background()
+
+	for _, host := range []string{"a.invalid", "b.invalid", "c.invalid"} {
+		_, _ = cr.LookupHost(ctx, host)
+	}
+	if n := cr.Stats().Entries; n != 2 {
+		t.Errorf("entries=%d want 2", n)
+	}
+
+	// the expired entries are removed first
+	cr.NegativeTTL = time.Nanosecond
+	_, _ = cr.LookupHost(ctx, "d.invalid")
+	time.Sleep(time.Millisecond)
+	_, _ = cr.LookupHost(ctx, "e.invalid")
+	if n := cr.Stats().Entries; n != 2 {
+		t.Errorf("entries=%d want 2", n)
+	}
+}
+
+func TestCachingResolver_sharedLookupCancel(t *testing.T) {
+	t.Parallel()
+
+	dialed := make(chan struct{}, 1)
+	release := make(chan struct{})
+	cr := gg.NewCachingResolver(time.Minute, time.Minute)
+	cr.Resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			select {
+			case dialed <- struct{}{}:
+			default:
+			}
+			<-release
+			return nil, errors.New("no DNS server in tests")
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cr.LookupHost(ctx, "shared.invalid")
+		first <- err
+	}()
+	<-dialed
+
+	second := make(chan error, 1)
+	go func() {
+		_, err := cr.LookupHost(context.Background(), "shared.invalid")
+		second <- err
+	}()
+	for cr.Stats().Misses < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	if err := <-first; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled caller: want context.Canceled, got %v", err)
+	}
+
+	close(release)
+	err := <-second
+	if err == nil || errors.Is(err, context.Canceled) {
+		t.Errorf("other caller: want the DNS error, got %v", err)
+	}
+}
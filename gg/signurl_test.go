@@ -0,0 +1,62 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestSignURL(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("0123456789abcdef0123456789abcdef")
+	link, err := gg.SignURL(secret, "/uploads/my file.pdf?download=1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "/uploads/my%20file.pdf?download=1&expires=") || !strings.Contains(link, "&signature=") {
+		t.Fatalf("link=%s", link)
+	}
+
+	verify := func(link string, secret []byte) error {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gg.VerifySignedURL(secret, u)
+	}
+	if err = verify(link, secret); err != nil {
+		t.Errorf("valid link: %v", err)
+	}
+
+	for name, tampered := range map[string]string{
+		"path":      strings.Replace(link, "my%20file", "other", 1),
+		"query":     strings.Replace(link, "download=1", "download=2", 1),
+		"expires":   strings.Replace(link, "expires=", "expires=9", 1),
+		"unsigned":  "/uploads/my%20file.pdf?download=1",
+		"signature": link[:len(link)-2] + "xx",
+	} {
+		if err = verify(tampered, secret); !errors.Is(err, gg.ErrURLSignature) {
+			t.Errorf("%s tampered: err=%v", name, err)
+		}
+	}
+	if err = verify(link, []byte("another secret of 32 bytes......")); !errors.Is(err, gg.ErrURLSignature) {
+		t.Errorf("other secret: err=%v", err)
+	}
+
+	expired, err := gg.SignURL(secret, "/uploads/a.pdf", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = verify(expired, secret); !errors.Is(err, gg.ErrURLExpired) {
+		t.Errorf("expired link: err=%v", err)
+	}
+}
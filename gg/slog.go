@@ -0,0 +1,90 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lynxai-team/emo"
+)
+
+// Log schema: the attribute keys of the records emitted by the emo bridge.
+// The standard slog keys (time, level, msg) are also present.
+//
+//	{"time":"...","level":"WARN","msg":"reject path with '..'","zone":"gg","emoji":"🔔","source":"gg/writer.go:58","func":"github.com/lynxai-team/garcon/gg.Writer.TraversalPath"}
+const (
+	LogKeyZone   = "zone"   // emo zone: garcon, gg, gwt...
+	LogKeyEmoji  = "emoji"  // emoji identifying the emo function (Info, Security, Out...)
+	LogKeySource = "source" // file:line of the caller
+	LogKeyFunc   = "func"   // function of the caller
+)
+
+// BridgeEmoToSlog emits all the emo events (of every zone) through the slog handler
+// and sets the handler as the slog default, so the emo and slog call sites
+// produce the same log format (e.g. slog.NewJSONHandler).
+// The level is deduced from the emo function:
+// Error → ERROR, Warn → WARN, Debug/Trace → DEBUG, others → INFO.
+// When quiet is true, the emo console output is disabled,
+// except for the errors (emo behavior).
+func BridgeEmoToSlog(h slog.Handler, quiet bool) {
+	slog.SetDefault(slog.New(h))
+	if quiet {
+		emo.GlobalVerbosity(false)
+	}
+	emo.GlobalHook(func(e emo.Event) {
+		err := emitEmoEvent(h, e)
+		if err != nil {
+			// not through slog: the failing handler is also the slog default
+			fmt.Fprintln(os.Stderr, "BridgeEmoToSlog:", err)
+		}
+	})
+}
+
+// emitEmoEvent converts the emo event into a slog record and returns the handler error.
+func emitEmoEvent(h slog.Handler, e emo.Event) error {
+	level := emoLevel(e)
+	ctx := context.Background()
+	if !h.Enabled(ctx, level) {
+		return nil
+	}
+
+	text := make([]string, len(e.Args))
+	for i, a := range e.Args {
+		text[i] = fmt.Sprint(a)
+	}
+
+	r := slog.NewRecord(time.Now(), level, strings.Join(text, " "), 0)
+	r.AddAttrs(
+		slog.String(LogKeyZone, e.Zone.Name),
+		slog.String(LogKeyEmoji, e.Emoji),
+	)
+	if e.File != "" {
+		r.AddAttrs(
+			slog.String(LogKeySource, e.File+":"+strconv.Itoa(e.Line)),
+			slog.String(LogKeyFunc, e.From),
+		)
+	}
+
+	return h.Handle(ctx, r)
+}
+
+func emoLevel(e emo.Event) slog.Level {
+	switch {
+	case e.IsError:
+		return slog.LevelError
+	case e.Emoji == "🔔": // Warn, Warning
+		return slog.LevelWarn
+	case e.Emoji == "💊", e.Emoji == "👣": // Debug, Trace
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
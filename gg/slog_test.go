@@ -0,0 +1,61 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/emo"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+//nolint:paralleltest // modifies the global emo hook and the default slog logger
+func TestBridgeEmoToSlog(t *testing.T) {
+	defaultLogger := slog.Default()
+	defer func() {
+		emo.GlobalHook(nil)
+		slog.SetDefault(defaultLogger)
+	}()
+
+	var buf bytes.Buffer
+	gg.BridgeEmoToSlog(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}), false)
+
+	zone := emo.NewZone("test-zone")
+	zone.Warn("disk", "almost", "full")
+	zone.Error("cannot write")
+	zone.Info("started")
+	slog.Info("direct", "k", "v")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("want 4 records, got %d:\n%s", len(lines), buf.String())
+	}
+
+	want := []struct{ level, msg, zone string }{
+		{"WARN", "disk almost full", "test-zone"},
+		{"ERROR", "cannot write", "test-zone"},
+		{"INFO", "started", "test-zone"},
+		{"INFO", "direct", ""},
+	}
+	for i, line := range lines {
+		var rec map[string]any
+		err := json.Unmarshal([]byte(line), &rec)
+		if err != nil {
+			t.Fatal(err, line)
+		}
+		zoneName, _ := rec[gg.LogKeyZone].(string)
+		if rec["level"] != want[i].level || rec["msg"] != want[i].msg || zoneName != want[i].zone {
+			t.Errorf("record #%d = %s", i, line)
+		}
+		if want[i].zone != "" && !strings.Contains(rec[gg.LogKeySource].(string), "slog_test.go:") {
+			t.Errorf("record #%d: bad source %v", i, rec[gg.LogKeySource])
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SSRFGuard protects the outbound requests built from user input
+// (webhook URLs, links submitted in a form...) against Server-Side Request Forgery.
+// The host must match the allow-list (when not empty) and
+// the resolved IP must not be private, loopback, link-local, multicast...
+// The IP is checked at connection time, after DNS resolution,
+// so a public hostname resolving to a private IP (DNS rebinding) is also rejected.
+type SSRFGuard struct {
+	// AllowHosts lists the accepted hostnames.
+	// "*.example.com" accepts all the sub-domains of example.com.
+	// An empty list accepts all hosts.
+	AllowHosts []string
+	// Resolver caches the DNS lookups (nil means no cache).
+	Resolver *CachingResolver
+	// AllowPrivate disables the IP check, useful for tests and intranet deployments.
+	AllowPrivate bool
+}
+
+var ErrSSRF = errors.New("SSRF guard blocked the outbound request")
+
+//nolint:gochecknoglobals // constant list of networks
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64 may reach IPv4 private ranges
+}
+
+// NewSSRFGuard creates a SSRFGuard accepting only the given hosts.
+func NewSSRFGuard(allowHosts ...string) *SSRFGuard {
+	return &SSRFGuard{AllowHosts: allowHosts}
+}
+
+// IsBlockedIP reports whether the IP must not be reached from user-provided URLs.
+func IsBlockedIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() ||
+		ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() {
+		return true
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedHost reports whether the hostname matches the allow-list.
+func (g *SSRFGuard) AllowedHost(host string) bool {
+	if len(g.AllowHosts) == 0 {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowed := range g.AllowHosts {
+		allowed = strings.ToLower(allowed)
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// control is called by the net.Dialer after DNS resolution, just before connecting.
+func (g *SSRFGuard) control(_, address string, _ syscall.RawConn) error {
+	if g.AllowPrivate {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSSRF, err)
+	}
+	if IsBlockedIP(addrPort.Addr()) {
+		return fmt.Errorf("%w: IP %s is not public", ErrSSRF, addrPort.Addr())
+	}
+	return nil
+}
+
+// DialContext connects only to the public IP addresses.
+// The host is resolved by g.Resolver when set.
+func (g *SSRFGuard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   g.control,
+	}
+	if g.Resolver != nil {
+		return g.Resolver.dial(ctx, dialer, network, address)
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// Middleware is a RTMiddleware rejecting the requests
+// having a non-HTTP scheme or a host outside the allow-list.
+// The IP check requires the underlying transport to use g.DialContext,
+// see Transport().
+func (g *SSRFGuard) Middleware(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Scheme != "http" && r.URL.Scheme != "https" {
+			return nil, fmt.Errorf("%w: scheme %q", ErrSSRF, r.URL.Scheme)
+		}
+		if !g.AllowedHost(r.URL.Hostname()) {
+			return nil, fmt.Errorf("%w: host %q is not in the allow-list", ErrSSRF, Sanitize(r.URL.Hostname()))
+		}
+		return next.RoundTrip(r)
+	})
+}
+
+// Transport returns a http.RoundTripper checking both the host and the resolved IP.
+// The proxy settings from the environment are ignored
+// because the IP check would apply to the proxy instead of the target.
+func (g *SSRFGuard) Transport() http.RoundTripper {
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if ok {
+		t = t.Clone()
+	} else {
+		t = &http.Transport{}
+	}
+	t.Proxy = nil
+	t.DialContext = g.DialContext
+	return g.Middleware(t)
+}
+
+// Client returns a http.Client protected against SSRF.
+// The redirections are also checked by the transport.
+func (g *SSRFGuard) Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: g.Transport(), Timeout: timeout}
+}
@@ -0,0 +1,137 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestIsBlockedIP(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.ip, func(t *testing.T) {
+			t.Parallel()
+			if got := gg.IsBlockedIP(netip.MustParseAddr(c.ip)); got != c.blocked {
+				t.Errorf("IsBlockedIP(%s) = %v, want %v", c.ip, got, c.blocked)
+			}
+		})
+	}
+}
+
+func TestSSRFGuard_AllowedHost(t *testing.T) {
+	t.Parallel()
+
+	g := gg.NewSSRFGuard("hooks.slack.com", "*.example.com")
+
+	for host, want := range map[string]bool{
+		"hooks.slack.com":  true,
+		"HOOKS.slack.com.": true,
+		"api.example.com":  true,
+		"example.com":      false,
+		"evil-example.com": false,
+		"slack.com":        false,
+	} {
+		if got := g.AllowedHost(host); got != want {
+			t.Errorf("AllowedHost(%s) = %v, want %v", host, got, want)
+		}
+	}
+
+	if !gg.NewSSRFGuard().AllowedHost("any.host") {
+		t.Error("empty allow-list must accept any host")
+	}
+}
+
+func TestSSRFGuard_Client(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	get := func(g *gg.SSRFGuard, url string) error {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, http.NoBody)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := g.Client(time.Second).Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	err := get(gg.NewSSRFGuard(), server.URL)
+	if !errors.Is(err, gg.ErrSSRF) {
+		t.Errorf("loopback must be blocked, got %v", err)
+	}
+
+	err = get(gg.NewSSRFGuard("example.com"), server.URL)
+	if !errors.Is(err, gg.ErrSSRF) {
+		t.Errorf("host outside allow-list must be blocked, got %v", err)
+	}
+
+	err = get(gg.NewSSRFGuard(), "file:///etc/passwd")
+	if !errors.Is(err, gg.ErrSSRF) {
+		t.Errorf("file scheme must be blocked, got %v", err)
+	}
+
+	err = get(&gg.SSRFGuard{AllowPrivate: true}, server.URL)
+	if err != nil {
+		t.Errorf("AllowPrivate must accept loopback, got %v", err)
+	}
+}
+
+func TestSSRFGuard_Notifiers(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	for _, endpoint := range []string{server.URL, "http://169.254.169.254/latest/meta-data/"} {
+		notifiers := []gg.Notifier{
+			gg.NewMattermostNotifier(endpoint),
+			gg.NewSlackNotifier(endpoint),
+			gg.NewDiscordNotifier(endpoint),
+			gg.NewTelegramNotifier(endpoint, "123"),
+		}
+		for _, n := range notifiers {
+			err := n.Notify("hello")
+			if !errors.Is(err, gg.ErrSSRF) {
+				t.Errorf("%T to %s must be blocked, got %v", n, endpoint, err)
+			}
+		}
+	}
+}
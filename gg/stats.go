@@ -0,0 +1,94 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg
+
+import "math"
+
+// EWMA is an exponentially weighted moving average.
+// Alpha is the weight of a new sample: 0 < Alpha ≤ 1,
+// higher values follow the samples faster, lower values smooth more.
+// The first sample (or Set) initializes the average.
+// EWMA is not safe for concurrent use.
+type EWMA struct {
+	Alpha  float64
+	value  float64
+	primed bool
+}
+
+// NewEWMA creates an EWMA with the given smoothing factor.
+func NewEWMA(alpha float64) EWMA {
+	return EWMA{Alpha: alpha}
+}
+
+// Add includes the sample and returns the new average.
+func (e *EWMA) Add(x float64) float64 {
+	return e.AddWeighted(x, 1)
+}
+
+// AddWeighted includes the sample as if it was added w times in a row
+// (the equivalent weight is 1 - (1-Alpha)^w).
+// A weight proportional to a duration gives a duration-weighted average.
+func (e *EWMA) AddWeighted(x, w float64) float64 {
+	if !e.primed {
+		e.Set(x)
+		return x
+	}
+	alpha := 1 - math.Pow(1-e.Alpha, w)
+	e.value += alpha * (x - e.value)
+	return e.value
+}
+
+// Value returns the current average (zero before the first sample).
+func (e *EWMA) Value() float64 { return e.value }
+
+// Primed reports whether the average has been initialized.
+func (e *EWMA) Primed() bool { return e.primed }
+
+// Set resets the average to x.
+func (e *EWMA) Set(x float64) {
+	e.value = x
+	e.primed = true
+}
+
+// GeoEWMA is an exponentially weighted geometric mean of positive samples
+// (the EWMA of the logarithms): a sample ten times higher
+// weighs as much as a sample ten times lower,
+// so a burst of high latencies moves the mean less than with an arithmetic EWMA.
+type GeoEWMA struct {
+	EWMA
+}
+
+// NewGeoEWMA creates a GeoEWMA with the given smoothing factor.
+func NewGeoEWMA(alpha float64) GeoEWMA {
+	return GeoEWMA{EWMA{Alpha: alpha}}
+}
+
+// Add includes the sample and returns the new geometric mean.
+func (g *GeoEWMA) Add(x float64) float64 {
+	return g.AddWeighted(x, 1)
+}
+
+// AddWeighted includes the sample with the weight w (see EWMA.AddWeighted).
+func (g *GeoEWMA) AddWeighted(x, w float64) float64 {
+	return math.Exp(g.EWMA.AddWeighted(logPositive(x), w))
+}
+
+// Value returns the current geometric mean (zero before the first sample).
+func (g *GeoEWMA) Value() float64 {
+	if !g.primed {
+		return 0
+	}
+	return math.Exp(g.value)
+}
+
+// Set resets the geometric mean to x.
+func (g *GeoEWMA) Set(x float64) {
+	g.EWMA.Set(logPositive(x))
+}
+
+// logPositive avoids -Inf for zero and negative samples.
+func logPositive(x float64) float64 {
+	return math.Log(max(x, math.SmallestNonzeroFloat64))
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestEWMA(t *testing.T) {
+	t.Parallel()
+
+	e := gg.NewEWMA(0.5)
+	for _, x := range []float64{10, 20, 20} {
+		e.Add(x)
+	}
+	if e.Value() != 17.5 {
+		t.Errorf("EWMA=%v want 17.5", e.Value())
+	}
+
+	// a weight of 2 is equivalent to two samples
+	a, b := gg.NewEWMA(0.3), gg.NewEWMA(0.3)
+	a.Set(1)
+	b.Set(1)
+	a.AddWeighted(5, 2)
+	b.Add(5)
+	b.Add(5)
+	if math.Abs(a.Value()-b.Value()) > 1e-12 {
+		t.Errorf("weighted=%v repeated=%v", a.Value(), b.Value())
+	}
+}
+
+func TestGeoEWMA(t *testing.T) {
+	t.Parallel()
+
+	g := gg.NewGeoEWMA(0.5)
+	g.Set(10)
+	up := g.Add(100) // ×10
+	g.Set(10)
+	down := g.Add(1) // ÷10
+	if math.Abs(up*down-100) > 1e-9 {
+		t.Errorf("geometric mean must be symmetric: up=%v down=%v", up, down)
+	}
+
+	var zero gg.GeoEWMA
+	if zero.Value() != 0 {
+		t.Errorf("zero value=%v", zero.Value())
+	}
+}
@@ -7,10 +7,14 @@ package gg
 import (
 	"encoding/json"
 	"fmt"
+	"html"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/lynxai-team/garcon/gerr"
 )
 
 // Writer enables writing useful JSON error message in the HTTP response body.
@@ -50,6 +54,10 @@ func WriteOK(w http.ResponseWriter, kv ...any) {
 	Writer("").WriteOK(w, kv...)
 }
 
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	Writer("").WriteError(w, r, err)
+}
+
 // TraversalPath returns true when path contains ".." to prevent path traversal attack.
 func (gw Writer) TraversalPath(w http.ResponseWriter, r *http.Request) bool {
 	if strings.Contains(r.URL.Path, "..") {
@@ -62,12 +70,24 @@ func (gw Writer) TraversalPath(w http.ResponseWriter, r *http.Request) bool {
 
 // WriteErr is a fast pretty-JSON marshaler dedicated to the HTTP error response.
 // WriteErr extends the JSON content when more than two key-values (kv) are provided.
+// The request ID of the request context (see gerr.RequestID) is added when not in kv.
 func (gw Writer) WriteErr(w http.ResponseWriter, r *http.Request, statusCode int, kv ...any) {
 	buf := make([]byte, 0, 1024)
 	buf = append(buf, '{')
 
 	buf, comma := appendMessages(buf, kv)
 
+	if r != nil && !hasRequestID(kv) {
+		if id := gerr.RequestID(r.Context()); id != "" {
+			if comma {
+				buf = append(buf, ',', '\n')
+			}
+			buf = append(buf, `"request_id":`...)
+			buf = appendJSONString(buf, id)
+			comma = true
+		}
+	}
+
 	if r != nil {
 		if comma {
 			buf = append(buf, ',', '\n')
@@ -90,6 +110,158 @@ func (gw Writer) WriteErr(w http.ResponseWriter, r *http.Request, statusCode int
 	w.Write(buf)
 }
 
+// hasRequestID reports whether the key-values of WriteErr contain the key "request_id"
+// (the first value is the message).
+func hasRequestID(kv []any) bool {
+	for i := 1; i+1 < len(kv); i += 2 {
+		if k, ok := kv[i].(string); ok && k == "request_id" {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteError converts err into an HTTP status and body (see gerr.HttpError).
+// The format depends on the Accept header of the request:
+// problem details (RFC 7807) when requested, HTML for the browsers,
+// else the usual JSON of WriteErr.
+// The documentation URL of the Writer (if any) is always provided,
+// as well as the request ID of the error or else of the request context.
+func (gw Writer) WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	p := gerr.ProblemDetails(err)
+	if p.RequestID == "" && r != nil {
+		p.RequestID = gerr.RequestID(r.Context())
+	}
+
+	switch negotiate(r) {
+	case formatProblem:
+		gw.WriteProblem(w, r, p)
+	case formatHTML:
+		gw.WriteHTMLError(w, r, p.Status, p.Detail)
+	default:
+		if p.RequestID != "" {
+			gw.WriteErr(w, r, p.Status, p.Detail, "request_id", p.RequestID)
+		} else {
+			gw.WriteErr(w, r, p.Status, p.Detail)
+		}
+	}
+}
+
+// WriteProblem writes the problem details (RFC 7807) with the "application/problem+json" Content-Type.
+// The empty fields are completed: the Type becomes the documentation URL of the Writer,
+// the Title the HTTP status text and the Instance the request path.
+func (gw Writer) WriteProblem(w http.ResponseWriter, r *http.Request, p *gerr.Problem) {
+	problem := *p // do not modify the caller's problem
+	if string(gw) != "" && (problem.Type == "" || problem.Type == "about:blank") {
+		problem.Type = string(gw)
+	}
+	if problem.Status == 0 {
+		problem.Status = http.StatusInternalServerError
+	}
+	if problem.Title == "" {
+		problem.Title = http.StatusText(problem.Status)
+	}
+	if problem.Instance == "" && r != nil {
+		problem.Instance = r.URL.Path
+	}
+	problem.Detail = sanitize(problem.Detail)
+
+	buf, err := json.Marshal(problem)
+	if err != nil {
+		gw.WriteErr(w, r, http.StatusInternalServerError, "Cannot serialize problem details", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", gerr.ProblemContentType)
+	w.WriteHeader(problem.Status)
+	w.Write(buf)
+}
+
+// WriteHTMLError writes a minimal HTML error page for the browsers.
+// The message is sanitized and HTML-escaped.
+func (gw Writer) WriteHTMLError(w http.ResponseWriter, _ *http.Request, statusCode int, msg string) {
+	title := strconv.Itoa(statusCode) + " " + http.StatusText(statusCode)
+
+	buf := make([]byte, 0, 512)
+	buf = append(buf, "<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><title>"...)
+	buf = append(buf, title...)
+	buf = append(buf, "</title></head>\n<body><h1>"...)
+	buf = append(buf, title...)
+	buf = append(buf, "</h1>\n"...)
+	if msg != "" {
+		buf = append(buf, "<p>"...)
+		buf = append(buf, html.EscapeString(sanitize(msg))...)
+		buf = append(buf, "</p>\n"...)
+	}
+	if string(gw) != "" {
+		buf = append(buf, "<p><a href=\""...)
+		buf = append(buf, html.EscapeString(string(gw))...)
+		buf = append(buf, "\">Documentation</a></p>\n"...)
+	}
+	buf = append(buf, "</body></html>\n"...)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	w.Write(buf)
+}
+
+// WriteJSON serializes v with the "application/json" Content-Type.
+func (gw Writer) WriteJSON(w http.ResponseWriter, statusCode int, v any) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		gw.WriteErr(w, nil, http.StatusInternalServerError, "Cannot serialize JSON response", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(buf)
+}
+
+// Redirect replies with the Location header.
+// The API clients (Accept: application/json) receive {"location":"..."}
+// instead of the HTML body written by http.Redirect.
+func (gw Writer) Redirect(w http.ResponseWriter, r *http.Request, location string, statusCode int) {
+	if !strings.Contains(r.Header.Get("Accept"), "application/json") {
+		http.Redirect(w, r, location, statusCode)
+		return
+	}
+
+	w.Header().Set("Location", location)
+	buf := appendJSONString([]byte(`{"location":`), location)
+	buf = append(buf, '}')
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(buf)
+}
+
+// format is the response format selected from the Accept header.
+type format int
+
+const (
+	formatJSON format = iota
+	formatProblem
+	formatHTML
+)
+
+func negotiate(r *http.Request) format {
+	if r == nil {
+		return formatJSON
+	}
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, gerr.ProblemContentType):
+		return formatProblem
+	case strings.Contains(accept, "application/json"):
+		return formatJSON
+	case strings.Contains(accept, "text/html"):
+		return formatHTML
+	default:
+		return formatJSON
+	}
+}
+
 // WriteOK is a fast pretty-JSON marshaler dedicated to the HTTP successful response.
 func (gw Writer) WriteOK(w http.ResponseWriter, kv ...any) {
 	var buf []byte
@@ -129,7 +301,7 @@ func appendMessages(buf []byte, kv []any) (_ []byte, comma bool) {
 
 	if len(kv) == 2 {
 		s := fmt.Sprintf("%v%v", kv[0], kv[1])
-		buf = strconv.AppendQuoteToGraphic(buf, s)
+		buf = appendJSONString(buf, s)
 		return buf, true
 	}
 
@@ -193,13 +365,13 @@ func appendValue(buf []byte, a any) []byte {
 	case uintptr:
 		return strconv.AppendUint(buf, uint64(val), 10)
 	case string:
-		return strconv.AppendQuoteToGraphic(buf, val)
+		return appendJSONString(buf, val)
 	case []byte:
-		return strconv.AppendQuoteToGraphic(buf, string(val))
+		return appendJSONString(buf, string(val))
 	case complex64, complex128:
-		return strconv.AppendQuoteToGraphic(buf, fmt.Sprint(val))
+		return appendJSONString(buf, fmt.Sprint(val))
 	case error:
-		return strconv.AppendQuoteToGraphic(buf, val.Error())
+		return appendJSONString(buf, val.Error())
 	default:
 		return appendJSON(buf, val)
 	}
@@ -216,20 +388,63 @@ func appendJSON(buf []byte, obj any) []byte {
 func appendKey(buf []byte, key any) []byte {
 	switch k := key.(type) {
 	case string:
-		return strconv.AppendQuoteToGraphic(buf, k)
+		return appendJSONString(buf, k)
 	case []byte:
-		return strconv.AppendQuoteToGraphic(buf, string(k))
+		return appendJSONString(buf, string(k))
 	default:
-		return strconv.AppendQuoteToGraphic(buf, fmt.Sprint(k))
+		return appendJSONString(buf, fmt.Sprint(k))
+	}
+}
+
+// appendJSONString appends s as a JSON string. The double quote, the backslash
+// and the control characters are escaped, as well as U+2028 and U+2029 (JavaScript line terminators).
+// The invalid UTF-8 bytes are replaced by U+FFFD.
+func appendJSONString(buf []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			switch c {
+			case '"', '\\':
+				buf = append(buf, '\\', c)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			default:
+				if c < ' ' {
+					buf = append(buf, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+				} else {
+					buf = append(buf, c)
+				}
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			buf = append(buf, `\ufffd`...)
+		case r == '\u2028' || r == '\u2029':
+			buf = append(buf, `\u202`...)
+			buf = append(buf, hex[r&0xf])
+		default:
+			buf = append(buf, s[i:i+size]...)
+		}
+		i += size
 	}
+	return append(buf, '"')
 }
 
 func appendURL(buf []byte, u *url.URL) []byte {
 	buf = append(buf, []byte(`"path":`)...)
-	buf = strconv.AppendQuote(buf, u.Path)
+	buf = appendJSONString(buf, u.Path)
 	if u.RawQuery != "" {
 		buf = append(buf, []byte(",\n"+`"query":`)...)
-		buf = strconv.AppendQuote(buf, u.RawQuery)
+		buf = appendJSONString(buf, u.RawQuery)
 	}
 	return buf
 }
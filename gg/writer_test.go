@@ -0,0 +1,154 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gg_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+func TestWriter_WriteError(t *testing.T) {
+	t.Parallel()
+
+	ctx := gerr.WithRequestID(context.Background(), "abc123")
+	err := gerr.NewCtx(ctx, gerr.NotFound, "no such user", "user", "bob")
+
+	cases := []struct {
+		name       string
+		accept     string
+		wantType   string
+		wantStatus int
+	}{
+		{"json", "application/json", "application/json", http.StatusNotFound},
+		{"problem", "application/problem+json, application/json;q=0.5", gerr.ProblemContentType, http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/users/bob", http.NoBody)
+			r.Header.Set("Accept", c.accept)
+			w := httptest.NewRecorder()
+			gg.NewWriter("https://example.com/doc").WriteError(w, r, err)
+
+			if w.Code != c.wantStatus {
+				t.Errorf("status=%d want %d", w.Code, c.wantStatus)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != c.wantType {
+				t.Errorf("Content-Type=%q want %q", ct, c.wantType)
+			}
+			if strings.Contains(w.Body.String(), `"user"`) && c.wantType == gerr.ProblemContentType {
+				t.Error("problem details must not expose the error params", w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), "abc123") {
+				t.Error("missing request ID", w.Body.String())
+			}
+
+			if c.wantType != gerr.ProblemContentType {
+				return
+			}
+			var p gerr.Problem
+			e := json.Unmarshal(w.Body.Bytes(), &p)
+			if e != nil {
+				t.Fatal(e)
+			}
+			want := gerr.Problem{
+				Type:      "https://example.com/doc",
+				Title:     "Not Found",
+				Status:    http.StatusNotFound,
+				Detail:    "no such user",
+				Instance:  "/users/bob",
+				RequestID: "abc123",
+				Code:      gerr.NotFound,
+			}
+			if p != want {
+				t.Errorf("got  %+v\nwant %+v", p, want)
+			}
+		})
+	}
+}
+
+func TestWriter_Negotiation(t *testing.T) {
+	t.Parallel()
+
+	gw := gg.NewWriter("https://example.com/doc")
+	err := gerr.New(gerr.Invalid, "<script>alert(1)</script>")
+
+	cases := []struct {
+		name, accept, wantType, wantBody string
+	}{
+		{"browser", "text/html,application/xhtml+xml,*/*;q=0.8", "text/html; charset=utf-8", "&lt;script&gt;"},
+		{"api", "application/json", "application/json", `"doc":"https://example.com/doc"`},
+		{"curl", "*/*", "application/json", `"message":`},
+		{"problem", gerr.ProblemContentType, gerr.ProblemContentType, `"type":"https://example.com/doc"`},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/x", http.NoBody)
+			r.Header.Set("Accept", c.accept)
+			w := httptest.NewRecorder()
+			gw.WriteError(w, r, err)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("status=%d want 400", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != c.wantType {
+				t.Errorf("Content-Type=%q want %q", ct, c.wantType)
+			}
+			if !strings.Contains(w.Body.String(), c.wantBody) {
+				t.Errorf("body should contain %q: %s", c.wantBody, w.Body.String())
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/old", http.NoBody)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	gw.Redirect(w, r, "/new", http.StatusFound)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/new" || w.Body.String() != `{"location":"/new"}` {
+		t.Errorf("JSON redirect: status=%d Location=%q body=%s", w.Code, w.Header().Get("Location"), w.Body.String())
+	}
+}
+
+func TestWriter_JSONEscaping(t *testing.T) {
+	t.Parallel()
+
+	gw := gg.NewWriter("")
+	msg := "bad\x01input\x1f \"quoted\" \\ line end \xff"
+
+	r := httptest.NewRequest(http.MethodGet, "/x", http.NoBody)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	gw.WriteErr(w, r, http.StatusBadRequest, msg)
+
+	var body map[string]any
+	err := json.Unmarshal(w.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatalf("invalid JSON %q: %v", w.Body.String(), err)
+	}
+	want := strings.ToValidUTF8(msg, "�")
+	if body["message"] != want {
+		t.Errorf("message=%q want %q", body["message"], want)
+	}
+
+	w = httptest.NewRecorder()
+	gw.Redirect(w, r, "/new?q=\x01", http.StatusFound)
+	var redirect struct{ Location string }
+	err = json.Unmarshal(w.Body.Bytes(), &redirect)
+	if err != nil || redirect.Location != "/new?q=\x01" {
+		t.Errorf("JSON redirect %q: %v", w.Body.String(), err)
+	}
+}
@@ -5,6 +5,7 @@
 package gwt
 
 import (
+	"crypto/rand"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -30,9 +31,10 @@ type (
 )
 
 // newAccessClaims creates a standard claim for a user access token.
+// The random JWT ID (jti) identifies the session of the token (see Sessions).
 func newAccessClaims(username string, groups, orgs []string, expiry time.Time) AccessClaims {
 	return AccessClaims{
-		jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(expiry)},
+		jwt.RegisteredClaims{ID: rand.Text(), ExpiresAt: jwt.NewNumericDate(expiry)},
 		username,
 		groups,
 		orgs,
@@ -0,0 +1,84 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt
+
+import (
+	"sync"
+	"time"
+)
+
+type (
+	// Clock is the time source used to compute and validate the token expiry.
+	// The default is the real time, tests can inject a ManualClock.
+	Clock interface {
+		Now() time.Time
+	}
+
+	// RealClock uses time.Now().
+	RealClock struct{}
+
+	// ManualClock is a Clock frozen at a given time, only changed by Set and Advance.
+	// ManualClock is safe for concurrent use.
+	ManualClock struct {
+		t  time.Time
+		mu sync.Mutex
+	}
+)
+
+func (RealClock) Now() time.Time { return time.Now() }
+
+// NewManualClock creates a ManualClock frozen at t.
+func NewManualClock(t time.Time) *ManualClock {
+	return &ManualClock{t: t}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Set freezes the clock at t.
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	c.t = t
+	c.mu.Unlock()
+}
+
+// Advance moves the clock forward by d.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// SetClock replaces the time source of the Tokenizer/Verifier.
+// SetClock must be called before using the Tokenizer/Verifier concurrently.
+func (b *Base) SetClock(c Clock) { b.clock = c }
+
+// SetClock replaces the time source of v when v is a ClockSetter
+// and reports whether the clock has been set.
+func SetClock(v Verifier, c Clock) bool {
+	cs, ok := v.(ClockSetter)
+	if ok {
+		cs.SetClock(c)
+	}
+	return ok
+}
+
+func (b Base) now() time.Time {
+	if b.clock == nil {
+		return time.Now()
+	}
+	return b.clock.Now()
+}
+
+// nowFunc returns the time source of the Verifier.
+func nowFunc(v any) func() time.Time {
+	if c, ok := v.(interface{ now() time.Time }); ok {
+		return c.now
+	}
+	return time.Now
+}
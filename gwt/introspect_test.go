@@ -0,0 +1,102 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/gg"
+	"github.com/lynxai-team/garcon/gwt"
+)
+
+func TestIntrospector(t *testing.T) {
+	t.Parallel()
+
+	var calls atomic.Int32
+	exp := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "api" || secret != "s3cr3t" || r.URL.RawQuery != "realm=x" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.PostFormValue("token") {
+		case "good":
+			w.Write([]byte(`{"active":true,"sub":"jane","scope":"Reader other","exp":` + exp + `}`))
+		case "expired":
+			w.Write([]byte(`{"active":true,"sub":"john","exp":1000}`))
+		default:
+			w.Write([]byte(`{"active":false}`))
+		}
+	}))
+	defer idp.Close()
+
+	dsn := strings.Replace(idp.URL, "http://", "http://api:s3cr3t@", 1) + "?realm=x&cache=1h&timeout=1s"
+	verifier, err := gwt.NewVerifier("introspect:"+dsn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 3 {
+		claims, err := verifier.Claims([]byte("good"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Username != "jane" || len(claims.Groups) != 2 || claims.Groups[0] != "Reader" {
+			t.Errorf("claims=%+v", claims)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("want 1 introspection request (cached), got %d", n)
+	}
+
+	for _, token := range []string{"expired", "unknown", "unknown"} {
+		_, err = verifier.Claims([]byte(token))
+		if !errors.Is(err, gwt.ErrInactiveToken) {
+			t.Errorf("%s: want ErrInactiveToken, got %v", token, err)
+		}
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("want inactive tokens cached, got %d requests", n)
+	}
+
+	bad, err := gwt.NewIntrospector(idp.URL, false) // no client credentials
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = bad.Claims([]byte("good"))
+	if !errors.Is(err, gwt.ErrIntrospection) {
+		t.Errorf("want ErrIntrospection, got %v", err)
+	}
+
+	// the JWTChecker selects the Introspector from the DSN
+	urls := gg.ParseURLs([]string{"http://my-dns.co"})
+	ck := gwt.NewJWTChecker(gg.NewWriter(""), urls, "introspect:"+dsn, "", "Reader", 1)
+	for token, status := range map[string]int{"good": http.StatusOK, "unknown": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "http://my-dns.co/", http.NoBody)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		ck.Vet(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if gwt.PermFromCtx(r).Value != 1 {
+				w.WriteHeader(http.StatusForbidden)
+			}
+		})).ServeHTTP(w, req)
+		if w.Code != status {
+			t.Errorf("%s: got status %d want %d", token, w.Code, status)
+		}
+	}
+
+	if _, err = gwt.NewIntrospector("ftp://idp", false); err == nil {
+		t.Error("want error for non-http DSN")
+	}
+}
@@ -12,7 +12,9 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"slices"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/lynxai-team/emo"
@@ -32,6 +34,13 @@ type (
 		perms    []Perm
 		plans    []string
 		cookies  []http.Cookie
+		claims   []*AccessClaims // claims of the default cookies
+		clock    Clock
+		// number of routes wired with Set, Chk and Vet (see SelfCheck)
+		wiredSet atomic.Int32
+		wiredChk atomic.Int32
+		wiredVet atomic.Int32
+		weakKey  bool
 	}
 )
 
@@ -84,12 +93,18 @@ var (
 func NewJWTChecker(writer gg.Writer, urls []*url.URL, keyTxt, cookieName string, permissions ...any) *JWTChecker {
 	plans, perms := optionalArgs(permissions...)
 
+	err := CheckKeyLength(keyTxt)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	var verifier Verifier
 	tokenizer, err := NewHMAC(keyTxt, true)
 	if err == nil {
-		verifier, err = NewVerifier(keyTxt, true)
-	} else {
 		verifier = tokenizer
+	} else {
+		// asymmetric public key or introspection endpoint: cannot generate the cookies
+		verifier, err = NewVerifier(keyTxt, true)
 	}
 	if err != nil {
 		log.Panic(err)
@@ -101,6 +116,16 @@ func NewJWTChecker(writer gg.Writer, urls []*url.URL, keyTxt, cookieName string,
 		plans:    plans,
 		perms:    perms,
 		cookies:  make([]http.Cookie, len(plans)),
+		claims:   make([]*AccessClaims, len(plans)),
+	}
+
+	switch t := tokenizer.(type) {
+	case *HS256:
+		ck.weakKey = IsWeakKey(t.key)
+	case *HS384:
+		ck.weakKey = IsWeakKey(t.key)
+	case *HS512:
+		ck.weakKey = IsWeakKey(t.key)
 	}
 
 	if tokenizer != nil {
@@ -108,6 +133,7 @@ func NewJWTChecker(writer gg.Writer, urls []*url.URL, keyTxt, cookieName string,
 		dns, cookieName = hardenCookieName(secure, dns, dir, cookieName)
 		for i := range plans {
 			ck.cookies[i] = NewCookie(tokenizer, cookieName, plans[i], "", secure, dns, dir)
+			ck.claims[i], _ = verifier.Claims([]byte(ck.cookies[i].Value))
 		}
 	}
 
@@ -132,6 +158,8 @@ func NewAccessToken(maxTTL, user string, groups, orgs []string, hexKey string) s
 	return token
 }
 
+// NewCookie creates a cookie conveying a one-year access token of the plan,
+// the expiry is computed with the clock of the tokenizer (see SetClock).
 func NewCookie(tokenizer Tokenizer, name, plan, user string, secure bool, dns, dir string) http.Cookie {
 	JWT, err := tokenizer.GenAccessToken("1y", "1y", user, []string{plan}, nil)
 	if err != nil || JWT == "" {
@@ -159,6 +187,31 @@ func NewCookie(tokenizer Tokenizer, name, plan, user string, secure bool, dns, d
 	}
 }
 
+// SetClock replaces the time source used to verify the tokens
+// and to set the cookie expiry. The default cookies are issued again
+// so their tokens expire one year after the time of the clock.
+// SetClock must be called before serving requests.
+func (ck *JWTChecker) SetClock(c Clock) {
+	ck.clock = c
+	SetClock(ck.verifier, c)
+
+	tokenizer, ok := ck.verifier.(Tokenizer)
+	if !ok {
+		return
+	}
+	for i, cookie := range ck.cookies {
+		ck.cookies[i] = NewCookie(tokenizer, cookie.Name, ck.plans[i], "", cookie.Secure, cookie.Domain, cookie.Path)
+		ck.claims[i], _ = ck.verifier.Claims([]byte(ck.cookies[i].Value))
+	}
+}
+
+func (ck *JWTChecker) now() time.Time {
+	if ck.clock == nil {
+		return time.Now()
+	}
+	return ck.clock.Now()
+}
+
 // Cookie returns a default cookie to facilitate testing.
 func (ck *JWTChecker) Cookie(i int) *http.Cookie {
 	if (i < 0) || (i >= len(ck.cookies)) {
@@ -175,34 +228,39 @@ func (ck *JWTChecker) Set(next http.Handler) http.Handler {
 	if len(ck.cookies) == 0 {
 		log.Panic("Middleware JWT requires at least one cookie")
 	}
+	ck.wiredSet.Add(1)
 	log.Infof("Middleware JWT.Set cookie %s=%s MaxAge=%d",
 		ck.cookies[0].Name, ck.cookies[0].Value, ck.cookies[0].MaxAge)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		perm, a := ck.PermFromCookie(req)
+		perm, claims, a := ck.permClaimsFromCookie(req)
 		if a != nil {
 			perm = ck.perms[0]
-			ck.cookies[0].Expires = time.Now().Add(timex.YearNs)
+			claims = ck.claims[0]
+			ck.cookies[0].Expires = ck.now().Add(timex.YearNs)
 			http.SetCookie(w, &ck.cookies[0])
 		}
+		timex.LapCtx(req.Context(), "auth")
 
-		next.ServeHTTP(w, perm.PutInCtx(req))
+		next.ServeHTTP(w, claims.PutInCtx(perm.PutInCtx(req)))
 	})
 }
 
 // Chk is a middleware to accept only HTTP requests having a valid cookie.
 // Then, Chk puts the permission (of the JWT) in the request context.
 func (ck *JWTChecker) Chk(next http.Handler) http.Handler {
+	ck.wiredChk.Add(1)
 	log.Info("Middleware JWT.Chk cookie")
 
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		perm, a := ck.PermFromCookie(req)
+		perm, claims, a := ck.permClaimsFromCookie(req)
 		if a != nil {
 			ck.gw.WriteErr(w, req, http.StatusUnauthorized, a...)
 			return
 		}
+		timex.LapCtx(req.Context(), "auth")
 
-		next.ServeHTTP(w, perm.PutInCtx(req))
+		next.ServeHTTP(w, claims.PutInCtx(perm.PutInCtx(req)))
 	})
 }
 
@@ -210,16 +268,18 @@ func (ck *JWTChecker) Chk(next http.Handler) http.Handler {
 // The JWT can be either in the cookie or in the first "Authorization" header.
 // Then, Vet puts the permission (of the JWT) in the request context.
 func (ck *JWTChecker) Vet(next http.Handler) http.Handler {
+	ck.wiredVet.Add(1)
 	log.Info("Middleware JWT.Vet cookie/bearer")
 
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		perm, a := ck.PermFromBearerOrCookie(req)
+		perm, claims, a := ck.permClaimsFromBearerOrCookie(req)
 		if a != nil {
 			ck.gw.WriteErr(w, req, http.StatusUnauthorized, a...)
 			return
 		}
+		timex.LapCtx(req.Context(), "auth")
 
-		next.ServeHTTP(w, perm.PutInCtx(req))
+		next.ServeHTTP(w, claims.PutInCtx(perm.PutInCtx(req)))
 	})
 }
 
@@ -319,11 +379,26 @@ func hardenCookieName(secure bool, dns, dir, cookieName string) (string, string)
 }
 
 func (ck *JWTChecker) PermFromBearerOrCookie(r *http.Request) (perm Perm, err []any) {
+	perm, _, err = ck.permClaimsFromBearerOrCookie(r)
+	return perm, err
+}
+
+func (ck *JWTChecker) PermFromCookie(r *http.Request) (perm Perm, err []any) {
+	perm, _, err = ck.permClaimsFromCookie(r)
+	return perm, err
+}
+
+func (ck *JWTChecker) PermFromJWT(jwt string) (Perm, []any) {
+	perm, _, err := ck.permClaimsFromJWT(jwt)
+	return perm, err
+}
+
+func (ck *JWTChecker) permClaimsFromBearerOrCookie(r *http.Request) (Perm, *AccessClaims, []any) {
 	JWT, errBearer := ck.jwtFromBearer(r)
 	if errBearer != nil {
 		c, errCookie := r.Cookie(ck.cookies[0].Name)
 		if errCookie != nil {
-			return perm, []any{
+			return Perm{}, nil, []any{
 				ErrNoValidJWT,
 				"expected_cookie_name", ck.cookies[0].Name,
 				"error_bearer", errBearer,
@@ -332,35 +407,78 @@ func (ck *JWTChecker) PermFromBearerOrCookie(r *http.Request) (perm Perm, err []
 		}
 		JWT = c.Value
 	}
-	return ck.PermFromJWT(JWT)
+	return ck.permClaimsFromJWT(JWT)
 }
 
-func (ck *JWTChecker) PermFromCookie(r *http.Request) (perm Perm, err []any) {
+func (ck *JWTChecker) permClaimsFromCookie(r *http.Request) (Perm, *AccessClaims, []any) {
 	c, e := r.Cookie(ck.cookies[0].Name)
 	if e != nil {
-		return perm, []any{e}
+		return Perm{}, nil, []any{e}
 	}
-	return ck.PermFromJWT(c.Value)
+	return ck.permClaimsFromJWT(c.Value)
 }
 
-func (ck *JWTChecker) PermFromJWT(jwt string) (Perm, []any) {
+func (ck *JWTChecker) permClaimsFromJWT(jwt string) (Perm, *AccessClaims, []any) {
 	for i := range ck.cookies {
 		if jwt == ck.cookies[i].Value {
-			return ck.perms[i], nil
+			return ck.perms[i], ck.claims[i], nil
 		}
 	}
 
 	claims, err := ck.verifier.Claims([]byte(jwt))
 	if err != nil {
-		return Perm{}, []any{err}
+		return Perm{}, nil, []any{err}
 	}
 
 	perm, err := ck.permFromAccessClaims(claims)
 	if err != nil {
-		return perm, []any{err}
+		return perm, nil, []any{err}
 	}
 
-	return perm, nil
+	return perm, claims, nil
+}
+
+// ClaimEquals returns a predicate for gg.Chain.When
+// satisfied when the JWT (bearer or cookie) of the request
+// is valid and its claim matches the value.
+// Supported claims are: sub, iss, jti, usr, aud, grp and org.
+// For the list claims (aud, grp, org), one item must match.
+func (ck *JWTChecker) ClaimEquals(claim, value string) gg.Predicate {
+	return func(r *http.Request) bool {
+		JWT, err := ck.jwtFromBearer(r)
+		if err != nil {
+			c, e := r.Cookie(ck.cookies[0].Name)
+			if e != nil {
+				return false
+			}
+			JWT = c.Value
+		}
+
+		claims, err := ck.verifier.Claims([]byte(JWT))
+		if err != nil {
+			return false
+		}
+
+		switch claim {
+		case "sub":
+			return claims.Subject == value
+		case "iss":
+			return claims.Issuer == value
+		case "jti":
+			return claims.ID == value
+		case "usr":
+			return claims.Username == value
+		case "aud":
+			return slices.Contains(claims.Audience, value)
+		case "grp":
+			return slices.Contains(claims.Groups, value)
+		case "org":
+			return slices.Contains(claims.Orgs, value)
+		default:
+			log.Warn("Middleware JWT ClaimEquals does not support claim", claim)
+			return false
+		}
+	}
 }
 
 func (ck *JWTChecker) jwtFromBearer(r *http.Request) (string, error) {
@@ -6,9 +6,11 @@ package gwt_test
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/lynxai-team/garcon/gg"
 	"github.com/lynxai-team/garcon/gwt"
@@ -230,3 +232,205 @@ func TestNewJWTChecker(t *testing.T) {
 		})
 	}
 }
+
+func TestJWTChecker_Require(t *testing.T) {
+	t.Parallel()
+
+	const (
+		read gwt.Permissions = 1 << iota
+		write
+		admin
+	)
+
+	const secretHex = "0a02123112dfb13d58a1bc0c8ce55b154878085035ae4d2e13383a79a3e3de1b"
+	urls := gg.ParseURLs([]string{"http://my-dns.co"})
+	ck := gwt.NewJWTChecker(gg.NewWriter(""), urls, secretHex, "", "Reader", int(read), "Editor", int(read|write))
+
+	tokenizer, err := gwt.NewHMAC(secretHex, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		mw     gg.Middleware
+		groups []string
+		orgs   []string
+		status int
+	}{
+		{"read", ck.RequirePerm(read), []string{"Reader"}, nil, http.StatusOK},
+		{"write", ck.RequirePerm(write), []string{"Reader"}, nil, http.StatusNotFound},
+		{"read+write", ck.RequirePerm(read | write), []string{"Editor"}, nil, http.StatusOK},
+		{"admin", ck.RequirePerm(admin), []string{"Editor"}, nil, http.StatusNotFound},
+		{"group", ck.RequireAnyGroup("ops", "Editor"), []string{"Editor"}, nil, http.StatusOK},
+		{"no-group", ck.RequireAnyGroup("ops"), []string{"Editor"}, nil, http.StatusNotFound},
+		{"org", ck.RequireOrg("acme"), []string{"Reader"}, []string{"acme"}, http.StatusOK},
+		{"no-org", ck.RequireOrg("acme"), []string{"Reader"}, []string{"other"}, http.StatusNotFound},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			jwt, err := tokenizer.GenAccessToken("1h", "1h", "jane", c.groups, c.orgs)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://my-dns.co/", http.NoBody)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer "+jwt)
+
+			w := httptest.NewRecorder()
+			next := &next{called: false, perm: 0}
+			ck.Vet(c.mw(next)).ServeHTTP(w, req)
+
+			if w.Code != c.status {
+				t.Errorf("got status %d want %d body=%s", w.Code, c.status, w.Body.String())
+			}
+			if next.called != (c.status == http.StatusOK) {
+				t.Errorf("next called=%v", next.called)
+			}
+		})
+	}
+
+	// without Vet/Chk/Set, the request context has no claims
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	w := httptest.NewRecorder()
+	ck.RequireOrg("acme")(&next{called: false, perm: 0}).ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("missing claims: got status %d want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+
+	const secretHex = "0a02123112dfb13d58a1bc0c8ce55b154878085035ae4d2e13383a79a3e3de1b"
+	verifier, err := gwt.NewHMAC(secretHex, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenizer, ok := verifier.(gwt.Tokenizer)
+	if !ok {
+		t.Fatal("the HMAC verifier must be a Tokenizer")
+	}
+
+	store := gwt.NewMemSessionStore()
+	sessions := gwt.NewSessions(gg.NewWriter(""), store)
+
+	// the sessions are identified by the jti generated by GenAccessToken
+	claims := make(map[string]*gwt.AccessClaims)
+	for _, device := range []string{"laptop", "phone", "admin"} {
+		token, err := tokenizer.GenAccessToken("1h", "1h", "jane", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := verifier.Claims([]byte(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.ID == "" {
+			t.Fatal("GenAccessToken must set the jti claim")
+		}
+		claims[device] = c
+	}
+	if claims["laptop"].ID == claims["phone"].ID {
+		t.Fatal("two tokens have the same jti", claims["laptop"].ID)
+	}
+
+	serve := func(device string, h http.HandlerFunc, method, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, http.NoBody)
+		r.Header.Set("User-Agent", "browser-"+device)
+		w := httptest.NewRecorder()
+		sessions.Middleware(h).ServeHTTP(w, claims[device].PutInCtx(r))
+		return w
+	}
+
+	ok200 := func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) }
+	serve("laptop", ok200, http.MethodGet, "/")
+	serve("phone", ok200, http.MethodGet, "/")
+
+	w := serve("laptop", sessions.List, http.MethodGet, "/sessions")
+	var list []gwt.Session
+	err = json.Unmarshal(w.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatal(err, w.Body.String())
+	}
+	if len(list) != 2 {
+		t.Fatalf("want 2 sessions, got %+v", list)
+	}
+	for _, s := range list {
+		device := "phone"
+		if s.ID == claims["laptop"].ID {
+			device = "laptop"
+		}
+		if s.Current != (device == "laptop") || s.Device != "browser-"+device || s.IP != "192.0.2.1" || s.Subject != "jane" {
+			t.Errorf("unexpected session %+v", s)
+		}
+	}
+
+	phone := "/sessions?id=" + claims["phone"].ID
+	w = serve("laptop", sessions.Revoke, http.MethodGet, phone)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("revoke with GET: status=%d want 405", w.Code)
+	}
+	w = serve("laptop", sessions.Revoke, http.MethodPost, phone)
+	if w.Code != http.StatusOK {
+		t.Errorf("revoke phone: status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = serve("phone", ok200, http.MethodGet, "/")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session should be rejected, got status=%d", w.Code)
+	}
+
+	w = serve("laptop", sessions.Revoke, http.MethodDelete, "/sessions?id=unknown")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("revoke unknown: status=%d want 404", w.Code)
+	}
+
+	w = serve("admin", sessions.AdminRevoke, http.MethodGet, "/admin/sessions?sub=jane&id=all")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("admin revoke with GET: status=%d want 405", w.Code)
+	}
+	w = serve("laptop", ok200, http.MethodGet, "/")
+	if w.Code != http.StatusOK {
+		t.Errorf("the GET must not revoke the sessions, got status=%d", w.Code)
+	}
+	w = serve("admin", sessions.AdminRevoke, http.MethodPost, "/admin/sessions?sub=jane&id=all")
+	if w.Code != http.StatusOK {
+		t.Errorf("admin revoke all: status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = serve("laptop", ok200, http.MethodGet, "/")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("all sessions should be revoked, got status=%d", w.Code)
+	}
+}
+
+func TestJWTChecker_SetClock(t *testing.T) {
+	t.Parallel()
+
+	const secretHex = "0a02123112dfb13d58a1bc0c8ce55b154878085035ae4d2e13383a79a3e3de1b"
+	urls := gg.ParseURLs([]string{"http://my-dns.co"})
+	ck := gwt.NewJWTChecker(gg.NewWriter(""), urls, secretHex, "", "Reader", 1)
+
+	clock := gwt.NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	ck.SetClock(clock)
+
+	verifier, err := gwt.NewHMAC(secretHex, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gwt.SetClock(verifier, clock)
+	claims, err := verifier.Claims([]byte(ck.Cookie(0).Value))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one year after the clock, not after the real time
+	if exp := claims.ExpiresAt.Time; exp.Before(clock.Now()) || exp.After(clock.Now().AddDate(1, 1, 0)) {
+		t.Errorf("cookie exp=%v want about one year after %v", exp, clock.Now())
+	}
+}
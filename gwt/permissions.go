@@ -0,0 +1,127 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// Permissions is a set of roles, one bit per role.
+// The Perm.Value of a plan can combine several roles:
+//
+//	const (
+//		Read gwt.Permissions = 1 << iota
+//		Write
+//		Admin
+//	)
+//	ck := gwt.NewJWTChecker(gw, urls, key, "", "Reader", int(Read), "Editor", int(Read|Write))
+type Permissions uint64
+
+type claimsCtxKey struct{}
+
+// Has reports whether p contains all the roles of required.
+func (p Permissions) Has(required Permissions) bool {
+	return p&required == required
+}
+
+// HasAny reports whether p contains at least one of the roles.
+func (p Permissions) HasAny(roles Permissions) bool {
+	return p&roles != 0
+}
+
+// Permissions converts the permission value into a set of roles.
+func (perm Perm) Permissions() Permissions {
+	return Permissions(perm.Value)
+}
+
+// ClaimsFromCtx gets the access token claims stored by the JWTChecker middlewares.
+// ClaimsFromCtx returns nil when the context has no claims.
+func ClaimsFromCtx(r *http.Request) *AccessClaims {
+	claims, _ := r.Context().Value(claimsCtxKey{}).(*AccessClaims)
+	return claims
+}
+
+// PutInCtx stores the claims within the request context.
+// PutInCtx does nothing when claims is nil.
+func (claims *AccessClaims) PutInCtx(r *http.Request) *http.Request {
+	if claims == nil {
+		return r
+	}
+	ctx := context.WithValue(r.Context(), claimsCtxKey{}, claims)
+	return r.WithContext(ctx)
+}
+
+// RequirePerm is a middleware accepting only the requests
+// having all the roles of the required permissions.
+// RequirePerm must be placed after Set, Chk or Vet.
+func (ck *JWTChecker) RequirePerm(required Permissions) gg.Middleware {
+	log.Infof("Middleware JWT.RequirePerm %b", required)
+	return ck.require(func(r *http.Request) *gerr.Error {
+		perm, ok := r.Context().Value(permKey).(Perm)
+		if !ok {
+			return gerr.New(gerr.Invalid, "missing permission, JWT middleware not called")
+		}
+		if !perm.Permissions().Has(required) {
+			return gerr.New(gerr.NotFound, "not found")
+		}
+		return nil
+	})
+}
+
+// RequireAnyGroup is a middleware accepting only the requests
+// having a JWT with at least one of the groups.
+func (ck *JWTChecker) RequireAnyGroup(groups ...string) gg.Middleware {
+	log.Info("Middleware JWT.RequireAnyGroup", groups)
+	return ck.require(func(r *http.Request) *gerr.Error {
+		claims := ClaimsFromCtx(r)
+		if claims == nil {
+			return gerr.New(gerr.Invalid, "missing JWT claims")
+		}
+		for _, g := range groups {
+			if slices.Contains(claims.Groups, g) {
+				return nil
+			}
+		}
+		return gerr.New(gerr.NotFound, "not found")
+	})
+}
+
+// RequireOrg is a middleware accepting only the requests
+// having a JWT belonging to the organization.
+func (ck *JWTChecker) RequireOrg(org string) gg.Middleware {
+	log.Info("Middleware JWT.RequireOrg", org)
+	return ck.require(func(r *http.Request) *gerr.Error {
+		claims := ClaimsFromCtx(r)
+		if claims == nil {
+			return gerr.New(gerr.Invalid, "missing JWT claims")
+		}
+		if !slices.Contains(claims.Orgs, org) {
+			return gerr.New(gerr.NotFound, "not found")
+		}
+		return nil
+	})
+}
+
+// require rejects the request when check returns an error.
+// Insufficient permissions are reported as NotFound
+// to avoid revealing the existence of the resource.
+func (ck *JWTChecker) require(check func(*http.Request) *gerr.Error) gg.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := check(r)
+			if err != nil {
+				status, _ := gerr.HttpError(err)
+				ck.gw.WriteErr(w, r, status, err.Message)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
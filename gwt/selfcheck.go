@@ -0,0 +1,127 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// devKeys are the example secrets of the documentation, the examples and the tests.
+//
+//nolint:gochecknoglobals // constant list
+var devKeys = []string{
+	"9d2e0a02121179a3c3de1b035ae1355b1548781c8ce8538a1dc0853a12dfb13d", // HS256 examples
+	"9d2e0a02121179a3c3de1b035ae1355b1548781c8ce8538a1dc0853a12dfb13d9d2e0a02121179a3c3de1b035ae1355b",
+	"9d2e0a02121179a3c3de1b035ae1355b1548781c8ce8538a1dc0853a12dfb13d9d2e0a02121179a3c3de1b035ae1355b1548781c8ce8538a1dc0853a12dfb13d",
+	"00112233445566778899aabbccddeeff", // Incorruptible examples
+}
+
+// hmacKeySizes is the key size (bytes) required by each HMAC algorithm.
+//
+//nolint:gochecknoglobals // constant map
+var hmacKeySizes = map[string]int{"HS256": 32, "HS384": 48, "HS512": 64}
+
+// IsWeakKey reports whether the secret key is an example constant
+// (documentation, examples, tests) or a trivial pattern (repeated or stepped bytes),
+// such a key must not be used in production.
+func IsWeakKey(key []byte) bool {
+	for _, k := range devKeys {
+		dev, _ := hex.DecodeString(k)
+		if bytes.Equal(key, dev) {
+			return true
+		}
+	}
+	if len(key) < 2 {
+		return true
+	}
+	step := key[1] - key[0]
+	for i := 2; i < len(key); i++ {
+		if key[i]-key[i-1] != step {
+			return false
+		}
+	}
+	return true // 000000..., 000102..., 00112233...
+}
+
+// CheckKeyLength explains why an HMAC key does not match the size required by the algorithm.
+// The keyTxt has the scheme of NewJWTChecker: `alg:xxxxxxxx` or just `xxxxxxxx`.
+// The asymmetric keys and the introspection endpoints are not checked.
+func CheckKeyLength(keyTxt string) error {
+	algo, key, found := strings.Cut(keyTxt, ":")
+	if !found {
+		algo, key = "HMAC", keyTxt
+	}
+	algo = strings.ToUpper(algo)
+
+	if algo == "" || algo == "HMAC" {
+		for _, size := range []int{32, 48, 64} {
+			if len(key) == hex.EncodedLen(size) || len(key) == base64.RawURLEncoding.EncodedLen(size) {
+				return nil
+			}
+		}
+		return fmt.Errorf("HMAC key has %d characters: want 64, 96 or 128 hexadecimal digits "+
+			"(HS256, HS384 or HS512) or 43, 64 or 86 Base64 characters", len(key))
+	}
+
+	size, ok := hmacKeySizes[algo]
+	if !ok {
+		return nil
+	}
+	hexLen := hex.EncodedLen(size)
+	b64Len := base64.RawURLEncoding.EncodedLen(size)
+	if len(key) == hexLen || len(key) == b64Len {
+		return nil
+	}
+	adjective := "long"
+	if len(key) < b64Len {
+		adjective = "short"
+	}
+	return fmt.Errorf("%s requires a %d-byte key (%d hexadecimal digits or %d Base64 characters) "+
+		"but the key has %d characters: too %s", algo, size, hexLen, b64Len, len(key), adjective)
+}
+
+// SelfCheck detects the common misconfigurations, to fail fast at startup
+// (once the routes are wired, see Garcon.JWTChecker registering it as a startup hook):
+//
+//   - Chk wired without any Set: the clients cannot obtain the cookie;
+//   - in production, the cookie is sent without the Secure flag (plain HTTP URL);
+//   - in production, the secret key is an example constant or a trivial pattern.
+//
+// Vet wired without Set is only logged because Vet also accepts the Authorization header.
+func (ck *JWTChecker) SelfCheck(prod bool) error {
+	var errs []error
+
+	set, chk, vet := ck.wiredSet.Load(), ck.wiredChk.Load(), ck.wiredVet.Load()
+	if set == 0 && chk > 0 {
+		errs = append(errs, fmt.Errorf("JWT Chk is used on %d routes but Set is never used: "+
+			"the clients cannot obtain the cookie, wrap the entry routes with Set", chk))
+	}
+	if set == 0 && vet > 0 {
+		log.Warnf("JWT Vet is used on %d routes but Set is never used: "+
+			"only the Authorization header can convey the token", vet)
+	}
+
+	if prod {
+		for i := range ck.cookies {
+			c := &ck.cookies[i]
+			if c.Value != "" && !c.Secure && c.Domain != "localhost" {
+				errs = append(errs, fmt.Errorf("JWT cookie %q is sent without the Secure flag in production: "+
+					"use an https URL in WithURLs (or WithDev in development)", c.Name))
+				break
+			}
+		}
+		if ck.weakKey {
+			errs = append(errs, errors.New("JWT secret key is an example constant or a trivial pattern: "+
+				"generate a random key (e.g. openssl rand -hex 32) or use WithDev in development"))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gg"
+	"github.com/lynxai-team/garcon/gwt"
+)
+
+func TestCheckKeyLength(t *testing.T) {
+	t.Parallel()
+
+	for key, want := range map[string]string{
+		hs256Hex:                   "",
+		"HS384:" + hs384Hex:        "",
+		"HS512:" + hs256Hex:        "HS512 requires a 64-byte key",
+		"HS256:" + hs512Hex:        "too long",
+		"abcd":                     "HMAC key has 4 characters",
+		"EdDSA:whatever":           "",
+		"introspect:https://idp/x": "",
+	} {
+		err := gwt.CheckKeyLength(key)
+		if (want == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), want)) {
+			t.Errorf("key %.12s: want %q got %v", key, want, err)
+		}
+	}
+}
+
+func TestIsWeakKey(t *testing.T) {
+	t.Parallel()
+
+	for key, weak := range map[string]bool{
+		"00112233445566778899aabbccddeeff": true,
+		"000102030405060708090a0b0c0d0e0f": true,
+		"ffffffffffffffffffffffffffffffff": true,
+		hs256Hex:                           true,
+		"3fa2c9d01b7e44a58c0f6e21d9b37a15": false,
+	} {
+		b, _ := gg.DecodeHexOrB64(key, len(key)/2)
+		if gwt.IsWeakKey(b) != weak {
+			t.Errorf("IsWeakKey(%s) want %v", key, weak)
+		}
+	}
+}
+
+func TestJWTChecker_SelfCheck(t *testing.T) {
+	t.Parallel()
+
+	const strongKey = "3fa2c9d01b7e44a58c0f6e21d9b37a153fa2c9d01b7e44a58c0f6e21d9b37a16"
+	next := http.NotFoundHandler()
+
+	ck := gwt.NewJWTChecker(gg.NewWriter(""), gg.ParseURLs([]string{"https://example.com"}), strongKey, "")
+	ck.Vet(next)
+	if err := ck.SelfCheck(true); err != nil {
+		t.Errorf("Vet without Set must not fail: %v", err)
+	}
+	ck.Chk(next)
+	if err := ck.SelfCheck(true); err == nil || !strings.Contains(err.Error(), "Set is never used") {
+		t.Errorf("want Chk-without-Set error, got %v", err)
+	}
+	ck.Set(next)
+	if err := ck.SelfCheck(true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	ck = gwt.NewJWTChecker(gg.NewWriter(""), gg.ParseURLs([]string{"http://example.com"}), hs256Hex, "")
+	err := ck.SelfCheck(true)
+	if err == nil || !strings.Contains(err.Error(), "Secure flag") || !strings.Contains(err.Error(), "example constant") {
+		t.Errorf("want insecure cookie and weak key errors, got %v", err)
+	}
+	if err = ck.SelfCheck(false); err != nil {
+		t.Errorf("dev mode: unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt
+
+import (
+	"cmp"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/gg"
+)
+
+type (
+	// Session is an active access token, identified by its JWT ID (jti).
+	Session struct {
+		Created  time.Time `json:"created"`
+		LastSeen time.Time `json:"last_seen"`
+		Expires  time.Time `json:"expires,omitzero"`
+		ID       string    `json:"id"`
+		Subject  string    `json:"sub"`
+		Device   string    `json:"device,omitempty"`
+		IP       string    `json:"ip,omitempty"`
+		Current  bool      `json:"current,omitempty"`
+	}
+
+	// SessionStore records the sessions and the revoked tokens.
+	// Implementations must be safe for concurrent use.
+	SessionStore interface {
+		// Touch creates or updates the session (LastSeen, IP, Device).
+		Touch(s Session) error
+		// List returns the non-expired sessions of the subject.
+		List(subject string) ([]Session, error)
+		// Revoke revokes the session of the subject.
+		// Revoke returns false when the session is unknown.
+		Revoke(subject, id string) (bool, error)
+		// RevokeAll revokes all the sessions of the subject and returns their number.
+		RevokeAll(subject string) (int, error)
+		// IsRevoked reports whether the token ID has been revoked.
+		IsRevoked(id string) bool
+	}
+
+	// MemSessionStore is an in-memory SessionStore for single-instance deployments.
+	MemSessionStore struct {
+		clock    Clock
+		sessions map[string]*Session
+		revoked  map[string]time.Time // jti -> token expiry
+		mu       sync.Mutex
+	}
+
+	// Sessions provides the middleware tracking the sessions
+	// and the HTTP handlers for the account security pages.
+	Sessions struct {
+		store SessionStore
+		gw    gg.Writer
+	}
+)
+
+// maxDeviceLen limits the size of the stored User-Agent.
+const maxDeviceLen = 200
+
+// NewMemSessionStore creates an empty MemSessionStore.
+func NewMemSessionStore() *MemSessionStore {
+	return &MemSessionStore{
+		clock:    RealClock{},
+		sessions: make(map[string]*Session),
+		revoked:  make(map[string]time.Time),
+	}
+}
+
+// SetClock replaces the time source (useful for tests).
+func (m *MemSessionStore) SetClock(c Clock) { m.clock = c }
+
+// Touch implements SessionStore.
+func (m *MemSessionStore) Touch(s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	if prev, ok := m.sessions[s.ID]; ok {
+		prev.LastSeen = now
+		prev.IP = s.IP
+		prev.Device = s.Device
+		return nil
+	}
+	s.Created = now
+	s.LastSeen = now
+	s.Current = false
+	m.sessions[s.ID] = &s
+	return nil
+}
+
+// List implements SessionStore. List also removes the expired sessions.
+func (m *MemSessionStore) List(subject string) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune()
+	var list []Session
+	for _, s := range m.sessions {
+		if s.Subject == subject {
+			list = append(list, *s)
+		}
+	}
+	slices.SortFunc(list, func(a, b Session) int { return b.LastSeen.Compare(a.LastSeen) })
+	return list, nil
+}
+
+// Revoke implements SessionStore.
+func (m *MemSessionStore) Revoke(subject, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.Subject != subject {
+		return false, nil
+	}
+	m.revoke(s)
+	return true, nil
+}
+
+// RevokeAll implements SessionStore.
+func (m *MemSessionStore) RevokeAll(subject string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for _, s := range m.sessions {
+		if s.Subject == subject {
+			m.revoke(s)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// IsRevoked implements SessionStore.
+func (m *MemSessionStore) IsRevoked(id string) bool {
+	m.mu.Lock()
+	_, ok := m.revoked[id]
+	m.mu.Unlock()
+	return ok
+}
+
+func (m *MemSessionStore) revoke(s *Session) {
+	m.revoked[s.ID] = s.Expires
+	delete(m.sessions, s.ID)
+}
+
+// prune forgets the expired sessions and revocations
+// because the expired tokens are rejected anyway.
+func (m *MemSessionStore) prune() {
+	now := m.clock.Now()
+	for id, s := range m.sessions {
+		if !s.Expires.IsZero() && s.Expires.Before(now) {
+			delete(m.sessions, id)
+		}
+	}
+	for id, exp := range m.revoked {
+		if !exp.IsZero() && exp.Before(now) {
+			delete(m.revoked, id)
+		}
+	}
+}
+
+// Sessions creates the session API using the Writer and the clock of the JWTChecker.
+func (ck *JWTChecker) Sessions(store SessionStore) *Sessions {
+	if cs, ok := store.(ClockSetter); ok && ck.clock != nil {
+		cs.SetClock(ck.clock)
+	}
+	return NewSessions(ck.gw, store)
+}
+
+// NewSessions creates the session API.
+func NewSessions(gw gg.Writer, store SessionStore) *Sessions {
+	return &Sessions{store: store, gw: gw}
+}
+
+// Middleware rejects the revoked tokens and records the session activity.
+// Middleware must be placed after Set, Chk or Vet.
+// Only the tokens having a JWT ID (jti) are tracked.
+func (s *Sessions) Middleware(next http.Handler) http.Handler {
+	log.Info("Middleware JWT.Sessions")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := ClaimsFromCtx(r)
+		if claims == nil || claims.ID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if s.store.IsRevoked(claims.ID) {
+			s.gw.WriteErr(w, r, http.StatusUnauthorized, "revoked session")
+			return
+		}
+
+		session := Session{
+			ID:      claims.ID,
+			Subject: subject(claims),
+			Device:  gg.Sanitize(truncate(r.UserAgent(), maxDeviceLen)),
+			IP:      remoteIP(r),
+		}
+		if claims.ExpiresAt != nil {
+			session.Expires = claims.ExpiresAt.Time
+		}
+		err := s.store.Touch(session)
+		if err != nil {
+			log.Warn("Sessions.Touch", err)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// List responds the active sessions of the current user.
+// The session of the request is flagged "current".
+func (s *Sessions) List(w http.ResponseWriter, r *http.Request) {
+	claims := ClaimsFromCtx(r)
+	if claims == nil {
+		s.gw.WriteErr(w, r, http.StatusUnauthorized, "missing JWT claims")
+		return
+	}
+	s.list(w, r, subject(claims), claims.ID)
+}
+
+// Revoke revokes the session given by the "id" query parameter
+// (id=all revokes all the sessions) of the current user.
+// Revoke accepts only POST and DELETE to prevent cross-site GET requests.
+func (s *Sessions) Revoke(w http.ResponseWriter, r *http.Request) {
+	if !s.allowRevoke(w, r) {
+		return
+	}
+	claims := ClaimsFromCtx(r)
+	if claims == nil {
+		s.gw.WriteErr(w, r, http.StatusUnauthorized, "missing JWT claims")
+		return
+	}
+	s.revoke(w, r, subject(claims))
+}
+
+// AdminList responds the active sessions of the "sub" query parameter.
+// AdminList must be protected, for example by RequirePerm.
+func (s *Sessions) AdminList(w http.ResponseWriter, r *http.Request) {
+	sub := r.URL.Query().Get("sub")
+	if sub == "" {
+		s.gw.WriteErr(w, r, http.StatusBadRequest, "missing query parameter sub")
+		return
+	}
+	s.list(w, r, sub, "")
+}
+
+// AdminRevoke revokes the session "id" (or all) of the subject "sub".
+// AdminRevoke must be protected, for example by RequirePerm.
+// AdminRevoke accepts only POST and DELETE, as Revoke.
+func (s *Sessions) AdminRevoke(w http.ResponseWriter, r *http.Request) {
+	if !s.allowRevoke(w, r) {
+		return
+	}
+	sub := r.URL.Query().Get("sub")
+	if sub == "" {
+		s.gw.WriteErr(w, r, http.StatusBadRequest, "missing query parameter sub")
+		return
+	}
+	log.Security("Sessions: admin revokes", gg.Sanitize(sub), gg.Sanitize(r.URL.Query().Get("id")))
+	s.revoke(w, r, sub)
+}
+
+func (s *Sessions) list(w http.ResponseWriter, r *http.Request, sub, current string) {
+	list, err := s.store.List(sub)
+	if err != nil {
+		s.writeErr(w, r, gerr.Wrap(err, gerr.ServerErr, "cannot list the sessions"))
+		return
+	}
+	for i := range list {
+		list[i].Current = list[i].ID == current && current != ""
+	}
+	if list == nil {
+		list = []Session{} // respond [] instead of null
+	}
+	s.gw.WriteOK(w, list)
+}
+
+func (s *Sessions) revoke(w http.ResponseWriter, r *http.Request, sub string) {
+	id := r.URL.Query().Get("id")
+	switch id {
+	case "":
+		s.gw.WriteErr(w, r, http.StatusBadRequest, "missing query parameter id")
+	case "all":
+		n, err := s.store.RevokeAll(sub)
+		if err != nil {
+			s.writeErr(w, r, gerr.Wrap(err, gerr.ServerErr, "cannot revoke the sessions"))
+			return
+		}
+		s.gw.WriteOK(w, "revoked", n)
+	default:
+		ok, err := s.store.Revoke(sub, id)
+		if err != nil {
+			s.writeErr(w, r, gerr.Wrap(err, gerr.ServerErr, "cannot revoke the session"))
+			return
+		}
+		if !ok {
+			s.gw.WriteErr(w, r, http.StatusNotFound, "session not found")
+			return
+		}
+		s.gw.WriteOK(w, "revoked", 1)
+	}
+}
+
+// allowRevoke rejects the methods other than POST and DELETE.
+func (s *Sessions) allowRevoke(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+		return true
+	}
+	w.Header().Set("Allow", http.MethodPost+", "+http.MethodDelete)
+	s.gw.WriteErr(w, r, http.StatusMethodNotAllowed, "revoke the sessions with POST or DELETE")
+	return false
+}
+
+func (s *Sessions) writeErr(w http.ResponseWriter, r *http.Request, err *gerr.Error) {
+	log.Warn("Sessions", err)
+	s.gw.WriteError(w, r, err)
+}
+
+// subject identifies the user: the "sub" claim, else the username.
+func subject(claims *AccessClaims) string {
+	return cmp.Or(claims.Subject, claims.Username)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,187 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+
+	turbo64 "github.com/cristalhq/base64"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Signer is a Tokenizer delegating the signature to a crypto.Signer,
+// so the private key may stay in a HSM, a TPM or a cloud KMS.
+// The algo is deduced from the public key:
+// ES256, ES384, ES512 (ECDSA P-256, P-384, P-521) or EdDSA (Ed25519).
+//
+// Example with a PKCS#11 token using github.com/ThalesGroup/crypto11:
+//
+//	hsm, err := crypto11.Configure(&crypto11.Config{
+//		Path:       "/usr/lib/softhsm/libsofthsm2.so",
+//		TokenLabel: "garcon",
+//		Pin:        os.Getenv("HSM_PIN"),
+//	})
+//	key, err := hsm.FindKeyPair(nil, []byte("access-token"))
+//	tokenizer, err := gwt.NewSigner(key, false)
+//	der, err := tokenizer.PublicDER() // to configure the verifiers: "ES256:" + hex(der)
+//
+// With a TPM, github.com/google/go-tpm-tools/client provides the crypto.Signer:
+//
+//	key, err := client.AttestationKeyECC(tpm)
+//	signer, err := key.GetSigner()
+//	tokenizer, err := gwt.NewSigner(signer, false)
+//
+//nolint:embeddedstructfieldcheck // avoid padding
+type Signer struct {
+	signer   crypto.Signer
+	verifier Verifier
+	method   signerMethod
+	hash     crypto.Hash
+	size     int // size of r and s in the ECDSA signature, zero for EdDSA
+	Base
+}
+
+// signerMethod lets golang-jwt build the header and the payload
+// while Signer computes the signature.
+type signerMethod struct{ alg string }
+
+var ErrSignerKey = errors.New("crypto.Signer public key must be ECDSA (P-256, P-384, P-521) or Ed25519")
+
+// NewSigner creates a Tokenizer signing with the crypto.Signer.
+func NewSigner(signer crypto.Signer, reuse bool) (*Signer, error) {
+	s := &Signer{signer: signer, Base: Base{reuse: reuse}}
+
+	switch pub := signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		ec := ECDSA{pub, Base{reuse: reuse}}
+		switch pub.Curve {
+		case elliptic.P256():
+			s.method.alg, s.hash, s.verifier = "ES256", crypto.SHA256, &ES256{ec}
+		case elliptic.P384():
+			s.method.alg, s.hash, s.verifier = "ES384", crypto.SHA384, &ES384{ec}
+		case elliptic.P521():
+			s.method.alg, s.hash, s.verifier = "ES512", crypto.SHA512, &ES512{ec}
+		default:
+			return nil, fmt.Errorf("%w, got curve %s", ErrSignerKey, pub.Curve.Params().Name)
+		}
+		s.size = (pub.Curve.Params().BitSize + 7) / 8
+	case ed25519.PublicKey:
+		s.method.alg = "EdDSA"
+		s.verifier = &EdDSA{BytesKey{pub, Base{reuse: reuse}}}
+	default:
+		return nil, fmt.Errorf("%w, got %T", ErrSignerKey, pub)
+	}
+
+	log.Security("NewSigner alg=" + s.method.alg)
+	return s, nil
+}
+
+// Algo returns the JWT "alg" deduced from the public key.
+func (s *Signer) Algo() string { return s.method.alg }
+
+// PublicDER returns the public key in PKIX DER form,
+// the verification key expected by NewVerifier (in hexadecimal or Base64 form).
+func (s *Signer) PublicDER() ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(s.signer.Public())
+}
+
+// GenAccessToken creates an Access Token signed by the crypto.Signer.
+func (s *Signer) GenAccessToken(timeout, maxTTL, user string, groups, orgs []string) (string, error) {
+	expiry, err := authorizedExpiry(s.now(), timeout, maxTTL)
+	if err != nil {
+		return "", err
+	}
+
+	claims := newAccessClaims(user, groups, orgs, expiry)
+	token, err := jwt.NewWithClaims(s.method, claims).SignedString(s)
+	if err != nil {
+		log.EncryptError(err)
+		return "", err
+	}
+
+	log.AccessToken("Issued "+s.method.alg+" AccessToken exp="+timeout+" usr="+user+" grp:", groups, "org:", orgs, "key: crypto.Signer")
+	return token, nil
+}
+
+// Sign returns the Base64 signature, or nil when the crypto.Signer fails.
+func (s *Signer) Sign(headerPayload []byte) []byte {
+	sig, err := s.sign(headerPayload)
+	if err != nil {
+		log.EncryptError("Signer", err)
+		return nil
+	}
+	sigB64 := make([]byte, turbo64.RawURLEncoding.EncodedLen(len(sig)))
+	turbo64.RawURLEncoding.Encode(sigB64, sig)
+	return sigB64
+}
+
+func (s *Signer) Verify(hp, sig []byte) bool { return s.verifier.Verify(hp, sig) }
+
+func (s *Signer) Claims(jwt []byte) (*AccessClaims, error) { return claims(s, jwt) }
+
+func (s *Signer) ClaimsBatch(jwts [][]byte) ([]*AccessClaims, []error) { return claimsBatch(s, jwts) }
+
+// sign returns the binary signature in the JWS form:
+// ECDSA signatures are converted from ASN.1 DER to the fixed-size r||s.
+func (s *Signer) sign(headerPayload []byte) ([]byte, error) {
+	digest := headerPayload
+	if s.hash != 0 {
+		h := s.hash.New()
+		h.Write(headerPayload)
+		digest = h.Sum(nil)
+	}
+
+	sig, err := s.signer.Sign(rand.Reader, digest, s.hash)
+	if err != nil || s.size == 0 {
+		return sig, err
+	}
+
+	var rs struct{ R, S *big.Int }
+	rest, err := asn1.Unmarshal(sig, &rs)
+	if err != nil {
+		return nil, fmt.Errorf("ECDSA signature: %w", err)
+	}
+	if len(rest) > 0 || rs.R.Sign() <= 0 || rs.S.Sign() <= 0 ||
+		rs.R.BitLen() > 8*s.size || rs.S.BitLen() > 8*s.size {
+		return nil, errors.New("ECDSA signature: invalid ASN.1 sequence")
+	}
+
+	out := make([]byte, 2*s.size)
+	rs.R.FillBytes(out[:s.size])
+	rs.S.FillBytes(out[s.size:])
+	return out, nil
+}
+
+func (m signerMethod) Alg() string { return m.alg }
+
+func (signerMethod) Sign(signingString string, key any) ([]byte, error) {
+	s, ok := key.(*Signer)
+	if !ok {
+		return nil, jwt.ErrInvalidKeyType
+	}
+	return s.sign([]byte(signingString))
+}
+
+func (signerMethod) Verify(signingString string, sig []byte, key any) error {
+	s, ok := key.(*Signer)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sigB64 := make([]byte, turbo64.RawURLEncoding.EncodedLen(len(sig)))
+	turbo64.RawURLEncoding.Encode(sigB64, sig)
+	if !s.Verify([]byte(signingString), sigB64) {
+		return ErrJWTSignature
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gwt"
+)
+
+func TestSigner(t *testing.T) {
+	t.Parallel()
+
+	signers := map[string]crypto.Signer{}
+	for algo, curve := range map[string]elliptic.Curve{"ES256": elliptic.P256(), "ES384": elliptic.P384(), "ES512": elliptic.P521()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[algo] = key
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers["EdDSA"] = edKey
+
+	for algo, signer := range signers {
+		t.Run(algo, func(t *testing.T) {
+			t.Parallel()
+
+			tokenizer, err := gwt.NewSigner(signer, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tokenizer.Algo() != algo {
+				t.Errorf("Algo() = %s, want %s", tokenizer.Algo(), algo)
+			}
+
+			token, err := tokenizer.GenAccessToken("1h", "1d", "jane", []string{"dev"}, []string{"lynx"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// the verifiers only need the public key
+			der, err := tokenizer.PublicDER()
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier, err := gwt.NewVerifier(algo+":"+hex.EncodeToString(der), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range []gwt.Verifier{verifier, tokenizer} {
+				claims, err := v.Claims([]byte(token))
+				if err != nil {
+					t.Fatalf("%T.Claims: %v", v, err)
+				}
+				if claims.Username != "jane" {
+					t.Errorf("%T: usr = %q, want jane", v, claims.Username)
+				}
+			}
+
+			tampered := []byte(token)
+			tampered[len(tampered)-3] ^= 1
+			_, err = verifier.Claims(tampered)
+			if !errors.Is(err, gwt.ErrJWTSignature) {
+				t.Errorf("tampered token: got %v, want ErrJWTSignature", err)
+			}
+		})
+	}
+}
+
+func TestNewSigner_RSA(t *testing.T) {
+	t.Parallel()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = gwt.NewSigner(key, false)
+	if !errors.Is(err, gwt.ErrSignerKey) {
+		t.Errorf("got %v, want ErrSignerKey", err)
+	}
+}
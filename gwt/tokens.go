@@ -33,7 +33,7 @@ const notSupportedNotice = " not yet supported. " +
 
 // GenRefreshToken generates a refresh token for a user in a namespace.
 func GenRefreshToken(timeout, maxTTL, namespace, user string, secretKey []byte) (string, error) {
-	expiry, err := authorizedExpiry(timeout, maxTTL)
+	expiry, err := authorizedExpiry(time.Now(), timeout, maxTTL)
 	if err != nil {
 		return "", err
 	}
@@ -54,7 +54,11 @@ func GenRefreshToken(timeout, maxTTL, namespace, user string, secretKey []byte)
 // GenAccessToken generates an access token with HS256 signing algo.
 // Deprecated: Use `GenAccessTokenWithAlgo("HMAC", ...)`.
 func GenAccessToken(timeout, maxTTL, user string, groups, orgs []string, secretKey []byte) (string, error) {
-	expiry, err := authorizedExpiry(timeout, maxTTL)
+	return genAccessToken(time.Now(), timeout, maxTTL, user, groups, orgs, secretKey)
+}
+
+func genAccessToken(now time.Time, timeout, maxTTL, user string, groups, orgs []string, secretKey []byte) (string, error) {
+	expiry, err := authorizedExpiry(now, timeout, maxTTL)
 	if err != nil {
 		return "", err
 	}
@@ -76,7 +80,11 @@ func GenAccessToken(timeout, maxTTL, user string, groups, orgs []string, secretK
 
 // GenAccessTokenWithAlgo creates an Access Token with the JSON fields "exp", "usr", "grp" and "org".
 func GenAccessTokenWithAlgo(algo, timeout, maxTTL, user string, groups, orgs []string, keyDER []byte) (string, error) {
-	expiry, err := authorizedExpiry(timeout, maxTTL)
+	return genAccessTokenWithAlgo(time.Now(), algo, timeout, maxTTL, user, groups, orgs, keyDER)
+}
+
+func genAccessTokenWithAlgo(now time.Time, algo, timeout, maxTTL, user string, groups, orgs []string, keyDER []byte) (string, error) {
+	expiry, err := authorizedExpiry(now, timeout, maxTTL)
 	if err != nil {
 		return "", err
 	}
@@ -337,7 +345,7 @@ func GenerateEdDSAKey() []byte {
 	return private
 }
 
-func authorizedExpiry(timeout, maxTTL string) (time.Time, error) {
+func authorizedExpiry(now time.Time, timeout, maxTTL string) (time.Time, error) {
 	d, err := timex.ParseDuration(timeout)
 	if err != nil {
 		log.ParamError("timeout", err)
@@ -356,7 +364,7 @@ func authorizedExpiry(timeout, maxTTL string) (time.Time, error) {
 		return time.Time{}, err
 	}
 
-	expiry := time.Now().Add(d).UTC()
+	expiry := now.Add(d).UTC()
 	return expiry, nil
 }
 
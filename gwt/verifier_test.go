@@ -0,0 +1,307 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package gwt_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"errors"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/lynxai-team/garcon/gwt"
+)
+
+const (
+	hs256Hex = "9d2e0a02121179a3c3de1b035ae1355b1548781c8ce8538a1dc0853a12dfb13d"
+	hs384Hex = "9d2e0a02121179a3c3de1b035ae1355b1548781c8ce8538a1dc0853a12dfb13d9d2e0a02121179a3c3de1b035ae1355b"
+	hs512Hex = "9d2e0a02121179a3c3de1b035ae1355b1548781c8ce8538a1dc0853a12dfb13d9d2e0a02121179a3c3de1b035ae1355b1548781c8ce8538a1dc0853a12dfb13d"
+)
+
+func hmacTokenizers(t testing.TB) map[string]gwt.Tokenizer {
+	t.Helper()
+
+	hs256, err := gwt.NewHS256(hs256Hex, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs384, err := gwt.NewHS384(hs384Hex, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs512, err := gwt.NewHS512(hs512Hex, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]gwt.Tokenizer{"HS256": hs256, "HS384": hs384, "HS512": hs512}
+}
+
+func TestVerify_HMAC(t *testing.T) {
+	t.Parallel()
+
+	hp := []byte("eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJ1c3IiOiJqYW5lIn0")
+
+	for name, tokenizer := range hmacTokenizers(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			sig := tokenizer.Sign(hp)
+			if !tokenizer.Verify(hp, sig) {
+				t.Error("valid signature rejected")
+			}
+
+			first := bytes.Clone(sig)
+			first[0] ^= 1
+			last := bytes.Clone(sig)
+			last[len(last)-1] ^= 1
+
+			for _, bad := range [][]byte{first, last, sig[:len(sig)-1], append(bytes.Clone(sig), 'A'), nil} {
+				if tokenizer.Verify(hp, bad) {
+					t.Errorf("invalid signature accepted: %q", bad)
+				}
+			}
+		})
+	}
+}
+
+// TestVerify_ConstantTime parses the source code to ensure
+// the HMAC signatures (BytesKey verifiers) are compared in constant time.
+func TestVerify_ConstantTime(t *testing.T) {
+	t.Parallel()
+
+	file, err := parser.ParseFile(token.NewFileSet(), "verifier.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found bool
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || fn.Name.Name != "verify" {
+			continue
+		}
+		found = true
+		ast.Inspect(fn.Body, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			pkg, ok := sel.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			switch pkg.Name + "." + sel.Sel.Name {
+			case "bytes.Equal", "bytes.Compare", "reflect.DeepEqual":
+				t.Errorf("verify() must not use %s.%s (not constant time)", pkg.Name, sel.Sel.Name)
+			}
+			return true
+		})
+		if !usesConstantTime(fn.Body) {
+			t.Error("verify() must use hmac.Equal or subtle.ConstantTimeCompare")
+		}
+	}
+
+	if !found {
+		t.Error("cannot find function verify() in verifier.go")
+	}
+}
+
+func usesConstantTime(body *ast.BlockStmt) bool {
+	var ok bool
+	ast.Inspect(body, func(n ast.Node) bool {
+		sel, isSel := n.(*ast.SelectorExpr)
+		if !isSel {
+			return true
+		}
+		if pkg, isIdent := sel.X.(*ast.Ident); isIdent {
+			name := pkg.Name + "." + sel.Sel.Name
+			if name == "hmac.Equal" || name == "subtle.ConstantTimeCompare" {
+				ok = true
+			}
+		}
+		return true
+	})
+	return ok
+}
+
+/*
+$ go test -bench Verify ./gwt
+BenchmarkVerifyCompare/bytes.Equal   31001751     7.3 ns/op
+BenchmarkVerifyCompare/hmac.Equal    13033854    20.3 ns/op
+BenchmarkVerify/HS256                  306078   780.0 ns/op
+BenchmarkVerify/HS384                  137446  1914   ns/op
+BenchmarkVerify/HS512                  115335  1884   ns/op
+
+The constant-time comparison costs a few nanoseconds,
+negligible compared to the HMAC computation.
+*/
+
+func BenchmarkVerifyCompare(b *testing.B) {
+	tokenizers := hmacTokenizers(b)
+	sig := tokenizers["HS256"].Sign([]byte(jwtSample))
+	other := bytes.Clone(sig)
+	other[len(other)-1] ^= 1
+
+	b.Run("bytes.Equal", func(b *testing.B) {
+		for b.Loop() {
+			if bytes.Equal(sig, other) {
+				b.Fatal("unexpected equality")
+			}
+		}
+	})
+
+	b.Run("hmac.Equal", func(b *testing.B) {
+		for b.Loop() {
+			if hmac.Equal(sig, other) {
+				b.Fatal("unexpected equality")
+			}
+		}
+	})
+}
+
+func BenchmarkVerify(b *testing.B) {
+	hp := []byte(jwtSample)
+	for name, tokenizer := range hmacTokenizers(b) {
+		sig := tokenizer.Sign(hp)
+		b.Run(name, func(b *testing.B) {
+			for b.Loop() {
+				if !tokenizer.Verify(hp, sig) {
+					b.Fatal("signature rejected")
+				}
+			}
+		})
+	}
+}
+
+func TestClaimsBatch(t *testing.T) {
+	t.Parallel()
+
+	for name, tokenizer := range hmacTokenizers(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tokens := make([][]byte, 0, 4)
+			for _, user := range []string{"alice", "bob"} {
+				token, err := tokenizer.GenAccessToken("1h", "1h", user, []string{"dev"}, nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				tokens = append(tokens, []byte(token))
+			}
+			tampered := bytes.Clone(tokens[0])
+			tampered[len(tampered)-1] ^= 1
+			tokens = append(tokens, tampered, []byte("no-period"))
+
+			claims, errs := gwt.ClaimsBatch(tokenizer, tokens)
+			if len(claims) != len(tokens) || len(errs) != len(tokens) {
+				t.Fatalf("got %d claims and %d errors for %d tokens", len(claims), len(errs), len(tokens))
+			}
+
+			for i, user := range []string{"alice", "bob"} {
+				if errs[i] != nil {
+					t.Errorf("#%d unexpected error %v", i, errs[i])
+				} else if claims[i].Username != user {
+					t.Errorf("#%d got user %q want %q", i, claims[i].Username, user)
+				}
+			}
+			if claims[2] != nil || !errors.Is(errs[2], gwt.ErrJWTSignature) {
+				t.Errorf("tampered token: claims=%v err=%v", claims[2], errs[2])
+			}
+			if claims[3] != nil || !errors.Is(errs[3], gwt.ErrThreeParts) {
+				t.Errorf("malformed token: claims=%v err=%v", claims[3], errs[3])
+			}
+		})
+	}
+}
+
+func benchTokens(b *testing.B, tokenizer gwt.Tokenizer, n int) [][]byte {
+	b.Helper()
+	tokens := make([][]byte, n)
+	for i := range tokens {
+		token, err := tokenizer.GenAccessToken("1h", "1h", "user", []string{"dev", "ops"}, []string{"org"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		tokens[i] = []byte(token)
+	}
+	return tokens
+}
+
+/*
+Verifying 1000 HS256 tokens:
+
+$ go test -bench Claims -benchmem ./gwt
+BenchmarkClaims       3153580 ns/op   1344373 B/op   20002 allocs/op
+BenchmarkClaimsBatch  2326633 ns/op    589667 B/op    9015 allocs/op
+*/
+
+func BenchmarkClaims(b *testing.B) {
+	tokenizer := hmacTokenizers(b)["HS256"]
+	tokens := benchTokens(b, tokenizer, 1000)
+	for b.Loop() {
+		for _, token := range tokens {
+			_, err := tokenizer.Claims(token)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkClaimsBatch(b *testing.B) {
+	tokenizer := hmacTokenizers(b)["HS256"]
+	tokens := benchTokens(b, tokenizer, 1000)
+	for b.Loop() {
+		_, errs := gwt.ClaimsBatch(tokenizer, tokens)
+		if errs[0] != nil {
+			b.Fatal(errs[0])
+		}
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	t.Parallel()
+
+	for name, tokenizer := range hmacTokenizers(t) {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			clock := gwt.NewManualClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+			if !gwt.SetClock(tokenizer, clock) {
+				t.Fatal("the tokenizer must be a ClockSetter")
+			}
+
+			token, err := tokenizer.GenAccessToken("10m", "1h", "jane", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := tokenizer.Claims([]byte(token))
+			if err != nil {
+				t.Fatalf("token issued in the frozen past must be valid: %v", err)
+			}
+			if want := clock.Now().Add(10 * time.Minute); !claims.ExpiresAt.Equal(want) {
+				t.Errorf("exp=%v want %v", claims.ExpiresAt, want)
+			}
+
+			clock.Advance(11 * time.Minute)
+			_, err = tokenizer.Claims([]byte(token))
+			if !errors.Is(err, jwt.ErrTokenExpired) {
+				t.Errorf("want expired token, got %v", err)
+			}
+
+			_, errs := gwt.ClaimsBatch(tokenizer, [][]byte{[]byte(token)})
+			if !errors.Is(errs[0], jwt.ErrTokenExpired) {
+				t.Errorf("ClaimsBatch: want expired token, got %v", errs[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+// Package mcp implements a Model Context Protocol server (tools and resources)
+// on top of the Garcon JSON-RPC server, to write LLM tool servers
+// protected by the Garcon middlewares (authentication, logging, rate limiting):
+//
+//	srv := mcp.New("weather", "1.0.0")
+//	mcp.Tool(srv, "forecast", "Weather forecast of a city", func(ctx context.Context, in Forecast) (any, error) {
+//		return fetchForecast(ctx, in.City, in.Days)
+//	})
+//	mcp.Resource(srv, "file:///cities.json", "cities", "application/json", "Supported cities", readCities)
+//
+//	chain := gg.NewChain(g.MiddlewareRateLimiter(), ck.Vet)
+//	h := chain.Then(srv.Handler("/mcp"))
+//	mux.Handle("/mcp", h)  // Streamable HTTP
+//	mux.Handle("/mcp/", h) // HTTP+SSE
+//
+// The tool functions receive the context of the HTTP request:
+// the values set by the middlewares (e.g. the JWT claims) are available.
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/lynxai-team/emo"
+
+	"github.com/lynxai-team/garcon/gc"
+	"github.com/lynxai-team/garcon/gerr"
+)
+
+var log = emo.NewZone("mcp")
+
+// ProtocolVersions are the supported revisions of the MCP specification, latest first.
+//
+//nolint:gochecknoglobals // constant list
+var ProtocolVersions = []string{"2025-03-26", "2024-11-05"}
+
+type (
+	// Server is a MCP server, use Handler to serve it over HTTP.
+	Server struct {
+		rpc       *gc.JSONRPC
+		tools     map[string]tool
+		resources map[string]resource
+		sessions  map[string]*session
+		Name      string
+		Version   string
+		// Instructions (optional) explains to the LLM how to use the server.
+		Instructions string
+		mu           sync.RWMutex
+	}
+
+	// ToolInfo describes a tool in the response of "tools/list".
+	ToolInfo struct {
+		InputSchema *Schema `json:"inputSchema"`
+		Name        string  `json:"name"`
+		Description string  `json:"description,omitempty"`
+	}
+
+	// ResourceInfo describes a resource in the response of "resources/list".
+	ResourceInfo struct {
+		URI         string `json:"uri"`
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+		MIMEType    string `json:"mimeType,omitempty"`
+	}
+
+	// Content is a block of the result of a tool call.
+	Content struct {
+		Type string `json:"type"` // "text"
+		Text string `json:"text"`
+	}
+
+	// ToolResult is the response of "tools/call":
+	// the errors of the tool are reported to the LLM with IsError (not as protocol errors).
+	ToolResult struct {
+		Content []Content `json:"content"`
+		IsError bool      `json:"isError,omitempty"`
+	}
+
+	// ResourceContents is a content of the response of "resources/read".
+	ResourceContents struct {
+		URI      string `json:"uri"`
+		MIMEType string `json:"mimeType,omitempty"`
+		Text     string `json:"text"`
+	}
+
+	tool struct {
+		call func(ctx context.Context, args json.RawMessage) (any, error)
+		info ToolInfo
+	}
+
+	resource struct {
+		read func(ctx context.Context) (string, error)
+		info ResourceInfo
+	}
+
+	initializeParams struct {
+		ClientInfo      map[string]any `json:"clientInfo"`
+		ProtocolVersion string         `json:"protocolVersion"`
+	}
+
+	initializeResult struct {
+		Capabilities    map[string]any    `json:"capabilities"`
+		ServerInfo      map[string]string `json:"serverInfo"`
+		ProtocolVersion string            `json:"protocolVersion"`
+		Instructions    string            `json:"instructions,omitempty"`
+	}
+
+	callParams struct {
+		Arguments json.RawMessage `json:"arguments"`
+		Name      string          `json:"name"`
+	}
+
+	readParams struct {
+		URI string `json:"uri"`
+	}
+)
+
+// New creates a MCP server answering "initialize", "ping",
+// "tools/list", "tools/call", "resources/list" and "resources/read".
+func New(name, version string) *Server {
+	s := &Server{
+		rpc:       gc.NewJSONRPC(),
+		tools:     map[string]tool{},
+		resources: map[string]resource{},
+		sessions:  map[string]*session{},
+		Name:      name,
+		Version:   version,
+	}
+
+	gc.RPCMethod(s.rpc, "initialize", s.initialize)
+	gc.RPCMethod(s.rpc, "notifications/initialized", func(context.Context, gc.NoBody) (any, error) { return nil, nil })
+	gc.RPCMethod(s.rpc, "ping", func(context.Context, gc.NoBody) (struct{}, error) { return struct{}{}, nil })
+	gc.RPCMethod(s.rpc, "tools/list", s.listTools)
+	gc.RPCMethod(s.rpc, "tools/call", s.callTool)
+	gc.RPCMethod(s.rpc, "resources/list", s.listResources)
+	gc.RPCMethod(s.rpc, "resources/read", s.readResource)
+	return s
+}
+
+// Tool registers a tool, the input schema is generated from the type P:
+// the struct fields are named by their json tag, the fields without "omitempty"
+// are required, the tag `desc:"..."` documents the field for the LLM.
+// A string result is returned as is, the other results are encoded in JSON.
+func Tool[P, R any](s *Server, name, description string, fn func(ctx context.Context, args P) (R, error)) {
+	t := tool{
+		info: ToolInfo{Name: name, Description: description, InputSchema: schemaOf(reflect.TypeFor[P]())},
+		call: func(ctx context.Context, raw json.RawMessage) (any, error) {
+			var args P
+			if len(raw) > 0 {
+				err := json.Unmarshal(raw, &args)
+				if err != nil {
+					return nil, gerr.Wrap(err, gc.RPCInvalidParams, "invalid tool arguments", "tool", name)
+				}
+			}
+			return fn(ctx, args)
+		},
+	}
+	if t.info.InputSchema.Type != "object" {
+		log.Panic("MCP tool " + name + " arguments must be a struct or a map, got " + t.info.InputSchema.Type)
+	}
+
+	s.mu.Lock()
+	s.tools[name] = t
+	s.mu.Unlock()
+}
+
+// Resource registers a resource read by the function fn.
+func Resource(s *Server, uri, name, mimeType, description string, fn func(ctx context.Context) (string, error)) {
+	s.mu.Lock()
+	s.resources[uri] = resource{
+		info: ResourceInfo{URI: uri, Name: name, Description: description, MIMEType: mimeType},
+		read: fn,
+	}
+	s.mu.Unlock()
+}
+
+// Dispatch answers a JSON-RPC message (see gc.JSONRPC.Dispatch).
+func (s *Server) Dispatch(ctx context.Context, msg []byte) []byte {
+	return s.rpc.Dispatch(ctx, msg)
+}
+
+func (s *Server) initialize(_ context.Context, p initializeParams) (initializeResult, error) {
+	version := ProtocolVersions[0]
+	if slices.Contains(ProtocolVersions, p.ProtocolVersion) {
+		version = p.ProtocolVersion
+	}
+	log.Infof("MCP initialize client=%v protocol=%s", p.ClientInfo["name"], version)
+	return initializeResult{
+		ProtocolVersion: version,
+		Capabilities: map[string]any{
+			"tools":     map[string]bool{"listChanged": false},
+			"resources": map[string]bool{"listChanged": false, "subscribe": false},
+		},
+		ServerInfo:   map[string]string{"name": s.Name, "version": s.Version},
+		Instructions: s.Instructions,
+	}, nil
+}
+
+func (s *Server) listTools(context.Context, gc.NoBody) (map[string][]ToolInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]ToolInfo, 0, len(s.tools))
+	for _, t := range s.tools {
+		list = append(list, t.info)
+	}
+	slices.SortFunc(list, func(a, b ToolInfo) int { return strings.Compare(a.Name, b.Name) })
+	return map[string][]ToolInfo{"tools": list}, nil
+}
+
+func (s *Server) callTool(ctx context.Context, p callParams) (*ToolResult, error) {
+	s.mu.RLock()
+	t, ok := s.tools[p.Name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, gerr.New(gc.RPCInvalidParams, "unknown tool", "tool", p.Name)
+	}
+
+	out, err := t.call(ctx, p.Arguments)
+	if err != nil {
+		var gErr *gerr.Error
+		if errors.As(err, &gErr) && gErr.Code == gc.RPCInvalidParams {
+			return nil, err // protocol error
+		}
+		return toolError(p.Name, err), nil
+	}
+
+	text, ok := out.(string)
+	if !ok {
+		b, err := json.Marshal(out)
+		if err != nil {
+			return toolError(p.Name, err), nil
+		}
+		text = string(b)
+	}
+	return &ToolResult{Content: []Content{{Type: "text", Text: text}}}, nil
+}
+
+// toolError reports the message of a gerr.Error to the LLM,
+// the other errors are logged and hidden like the JSON-RPC internal errors.
+func toolError(name string, err error) *ToolResult {
+	msg := "internal error"
+	var gErr *gerr.Error
+	if errors.As(err, &gErr) && gErr.Code != gerr.ServerErr {
+		msg = gErr.Message
+	} else {
+		log.Warn("MCP tool", name, err)
+	}
+	return &ToolResult{Content: []Content{{Type: "text", Text: msg}}, IsError: true}
+}
+
+func (s *Server) listResources(context.Context, gc.NoBody) (map[string][]ResourceInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]ResourceInfo, 0, len(s.resources))
+	for _, r := range s.resources {
+		list = append(list, r.info)
+	}
+	slices.SortFunc(list, func(a, b ResourceInfo) int { return strings.Compare(a.URI, b.URI) })
+	return map[string][]ResourceInfo{"resources": list}, nil
+}
+
+func (s *Server) readResource(ctx context.Context, p readParams) (map[string][]ResourceContents, error) {
+	s.mu.RLock()
+	r, ok := s.resources[p.URI]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, gerr.New(gerr.NotFound, "resource not found", "uri", p.URI)
+	}
+	text, err := r.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contents := []ResourceContents{{URI: p.URI, MIMEType: r.info.MIMEType, Text: text}}
+	return map[string][]ResourceContents{"contents": contents}, nil
+}
@@ -0,0 +1,189 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package mcp_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lynxai-team/garcon/gerr"
+	"github.com/lynxai-team/garcon/mcp"
+)
+
+type sumArgs struct {
+	Label string `json:"label,omitempty" desc:"optional label"`
+	A     int    `json:"a"`
+	B     int    `json:"b"`
+}
+
+func newServer() *mcp.Server {
+	srv := mcp.New("test", "1.0.0")
+	mcp.Tool(srv, "sum", "Add two integers", func(_ context.Context, in sumArgs) (map[string]int, error) {
+		return map[string]int{"sum": in.A + in.B}, nil
+	})
+	mcp.Tool(srv, "user", "Get a user", func(context.Context, struct{}) (string, error) {
+		return "", gerr.New(gerr.NotFound, "unknown user")
+	})
+	mcp.Tool(srv, "db", "Query the database", func(context.Context, struct{}) (string, error) {
+		return "", errors.New("password=secret")
+	})
+	mcp.Resource(srv, "file:///readme.md", "readme", "text/markdown", "", func(context.Context) (string, error) {
+		return "# Hello", nil
+	})
+	return srv
+}
+
+func TestServer_StreamableHTTP(t *testing.T) {
+	t.Parallel()
+
+	h := newServer().Handler("/mcp")
+	post := func(body string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body)))
+		return strings.TrimSpace(w.Body.String())
+	}
+
+	cases := []struct{ body, want string }{
+		{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2024-11-05","clientInfo":{"name":"c"}}}`, `"serverInfo":{"name":"test","version":"1.0.0"},"protocolVersion":"2024-11-05"`},
+		{`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`, `"inputSchema":{"properties":{"a":{"type":"integer"},"b":{"type":"integer"},"label":{"type":"string","description":"optional label"}},"type":"object","required":["a","b"]},"name":"sum"`},
+		{`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"sum","arguments":{"a":2,"b":3}}}`, `{"content":[{"type":"text","text":"{\"sum\":5}"}]}`},
+		{`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"user"}}`, `{"content":[{"type":"text","text":"unknown user"}],"isError":true}`},
+		{`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"db"}}`, `{"content":[{"type":"text","text":"internal error"}],"isError":true}`},
+		{`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"nope"}}`, `"code":-32602`},
+		{`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"sum","arguments":{"a":"x"}}}`, `"code":-32602`},
+		{`{"jsonrpc":"2.0","id":8,"method":"resources/read","params":{"uri":"file:///readme.md"}}`, `{"contents":[{"uri":"file:///readme.md","mimeType":"text/markdown","text":"# Hello"}]}`},
+		{`{"jsonrpc":"2.0","id":9,"method":"ping"}`, `"result":{}`},
+	}
+	for _, c := range cases {
+		if got := post(c.body); !strings.Contains(got, c.want) {
+			t.Errorf("POST %s\n got  %s\n want %s", c.body, got, c.want)
+		}
+	}
+}
+
+func TestServer_SSE(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(newServer().Handler("/mcp"))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/mcp/sse", http.NoBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	stream := bufio.NewReader(resp.Body)
+
+	readData := func(event string) string {
+		t.Helper()
+		for {
+			line, err := stream.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "event: "+event+"\n" {
+				data, err := stream.ReadString('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				return strings.TrimSpace(strings.TrimPrefix(data, "data: "))
+			}
+		}
+	}
+
+	endpoint := readData("endpoint")
+	if !strings.HasPrefix(endpoint, "/mcp/message?sessionId=") {
+		t.Fatalf("endpoint = %q", endpoint)
+	}
+
+	body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"sum","arguments":{"a":1,"b":1}}}`
+	post, err := http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+endpoint, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	postResp, err := ts.Client().Do(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postResp.Body.Close()
+	if postResp.StatusCode != http.StatusAccepted {
+		t.Fatalf("POST status = %d", postResp.StatusCode)
+	}
+
+	want := `{"jsonrpc":"2.0","result":{"content":[{"type":"text","text":"{\"sum\":2}"}]},"id":1}`
+	if got := readData("message"); got != want {
+		t.Errorf("message = %s want %s", got, want)
+	}
+
+	post, err = http.NewRequestWithContext(ctx, http.MethodPost, ts.URL+"/mcp/message?sessionId=unknown", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	postResp, err = ts.Client().Do(post)
+	if err != nil {
+		t.Fatal(err)
+	}
+	postResp.Body.Close()
+	if postResp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown session status = %d want 404", postResp.StatusCode)
+	}
+}
+
+func TestServer_Handler_mux(t *testing.T) {
+	t.Parallel()
+
+	// the wiring of the package documentation
+	h := newServer().Handler("/mcp")
+	mux := http.NewServeMux()
+	mux.Handle("/mcp", h)
+	mux.Handle("/mcp/", h)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	post := func(body string) (int, string) {
+		t.Helper()
+		resp, err := ts.Client().Post(ts.URL+"/mcp", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		buf, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(buf)
+	}
+
+	status, body := post(`{"jsonrpc":"2.0","id":1,"method":"ping"}`)
+	if status != http.StatusOK || !strings.Contains(body, `"result":{}`) {
+		t.Errorf("POST /mcp ping: %d %s", status, body)
+	}
+
+	status, body = post(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	if status != http.StatusAccepted || body != "" {
+		t.Errorf("POST /mcp notification: %d %q want 202 without body", status, body)
+	}
+
+	resp, err := ts.Client().Get(ts.URL + "/mcp/sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("GET /mcp/sse: %d %v", resp.StatusCode, resp.Header)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package mcp
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// heartbeat is the period of the SSE comments keeping the idle streams alive through the proxies.
+	heartbeat = 15 * time.Second
+	// sessionBuffer is the number of responses waiting for a slow SSE client.
+	sessionBuffer = 32
+	// maxMessageSize limits the body of the POST requests.
+	maxMessageSize = 1 << 20
+)
+
+// session is a SSE stream receiving the responses of the messages posted with its ID.
+type session struct {
+	events  chan []byte
+	dropped chan struct{}
+	once    sync.Once
+}
+
+// Handler serves the MCP transports below the path prefix (e.g. "/mcp"):
+//
+//	GET  /mcp/sse                  HTTP+SSE transport: the stream sends the "endpoint" event,
+//	POST /mcp/message?sessionId=…  then the responses of the posted messages ("202 Accepted").
+//	POST /mcp                      Streamable HTTP transport (without streaming): JSON response,
+//	                               or "202 Accepted" for the notifications.
+//
+// Wrap the Handler with the Garcon middlewares to authenticate, log and rate limit
+// the MCP clients, the tools receive the context of the POST request.
+// Register the Handler for both the prefix and its subtree,
+// else the ServeMux redirects "POST /mcp" to "/mcp/":
+//
+//	h := chain.Then(srv.Handler("/mcp"))
+//	mux.Handle("/mcp", h)  // Streamable HTTP
+//	mux.Handle("/mcp/", h) // HTTP+SSE
+func (s *Server) Handler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+prefix+"/sse", s.serveSSE(prefix+"/message"))
+	mux.HandleFunc("POST "+prefix+"/message", s.serveMessage)
+	mux.HandleFunc("POST "+prefix, s.serveStreamable)
+	log.Info("MCP server " + s.Name + " " + s.Version + " on " + prefix)
+	return mux
+}
+
+func (s *Server) serveSSE(endpoint string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := rand.Text()
+		sess := &session{events: make(chan []byte, sessionBuffer), dropped: make(chan struct{})}
+		s.mu.Lock()
+		s.sessions[id] = sess
+		s.mu.Unlock()
+		defer func() {
+			s.mu.Lock()
+			delete(s.sessions, id)
+			s.mu.Unlock()
+		}()
+
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no") // disable the Nginx buffering
+		w.WriteHeader(http.StatusOK)
+
+		rc := http.NewResponseController(w)
+		send := func(chunks ...string) error {
+			err := rc.SetWriteDeadline(time.Now().Add(2 * heartbeat))
+			if err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
+			}
+			for _, c := range chunks {
+				_, err = io.WriteString(w, c)
+				if err != nil {
+					return err
+				}
+			}
+			err = rc.Flush()
+			if errors.Is(err, http.ErrNotSupported) {
+				return nil
+			}
+			return err
+		}
+
+		if send("event: endpoint\ndata: "+endpoint+"?sessionId="+id+"\n\n") != nil {
+			return
+		}
+
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case <-sess.dropped:
+				return
+			case msg := <-sess.events:
+				err = send("event: message\ndata: ", strings.ReplaceAll(string(msg), "\n", "\ndata: "), "\n\n")
+			case <-ticker.C:
+				err = send(": ping\n\n")
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// serveStreamable answers the posted message in the response body (Streamable HTTP transport).
+func (s *Server) serveStreamable(w http.ResponseWriter, r *http.Request) {
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "cannot read the MCP message", http.StatusBadRequest)
+		return
+	}
+
+	resp := s.rpc.Dispatch(r.Context(), msg)
+	if resp == nil { // only notifications
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(resp)
+	if err != nil {
+		log.Warn("MCP response", err)
+	}
+}
+
+// serveMessage dispatches the posted message and sends the response to the SSE stream of the session.
+func (s *Server) serveMessage(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("sessionId")
+	s.mu.RLock()
+	sess, ok := s.sessions[id]
+	s.mu.RUnlock()
+	if !ok {
+		http.Error(w, "unknown MCP session", http.StatusNotFound)
+		return
+	}
+
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageSize))
+	if err != nil {
+		http.Error(w, "cannot read the MCP message", http.StatusBadRequest)
+		return
+	}
+
+	resp := s.rpc.Dispatch(r.Context(), msg)
+	if resp != nil {
+		select {
+		case sess.events <- resp:
+		default:
+			sess.once.Do(func() { close(sess.dropped) })
+			log.Warn("MCP session", id, "dropped: the SSE client is too slow")
+			http.Error(w, "MCP session dropped", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
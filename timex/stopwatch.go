@@ -0,0 +1,160 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package timex
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Stopwatch measures the phases of a processing with the monotonic clock
+	// (the wall clock adjustments do not alter the durations):
+	//
+	//	sw := timex.StartStopwatch()
+	//	authenticate()
+	//	sw.Lap("auth")
+	//	handle()
+	//	sw.Lap("handler")
+	//	total := sw.Stop("write")
+	//	log.Print(sw) // 16.5ms (auth=1.2ms handler=15ms write=300µs)
+	//
+	// Stopwatch is safe for concurrent use.
+	Stopwatch struct {
+		start time.Time
+		last  time.Time
+		laps  []Lap
+		total time.Duration
+		mu    sync.Mutex
+	}
+
+	// Lap is the duration of a named phase.
+	Lap struct {
+		Name     string
+		Duration time.Duration
+	}
+
+	stopwatchKey struct{}
+)
+
+// StartStopwatch creates a running Stopwatch.
+func StartStopwatch() *Stopwatch {
+	var sw Stopwatch
+	sw.Start()
+	return &sw
+}
+
+// Start (re)starts the Stopwatch and clears the laps.
+func (sw *Stopwatch) Start() {
+	now := time.Now()
+	sw.mu.Lock()
+	sw.start, sw.last = now, now
+	sw.laps = sw.laps[:0]
+	sw.total = 0
+	sw.mu.Unlock()
+}
+
+// Lap records the duration since the previous lap (or the start) as the phase name,
+// and returns this duration. Lap does nothing once the Stopwatch is stopped.
+func (sw *Stopwatch) Lap(name string) time.Duration {
+	now := time.Now()
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.total > 0 {
+		return 0
+	}
+	d := now.Sub(sw.last)
+	sw.last = now
+	sw.laps = append(sw.laps, Lap{Name: name, Duration: d})
+	return d
+}
+
+// Stop records the last lap (when name is not empty) and returns the total duration.
+// The next calls return the same total.
+func (sw *Stopwatch) Stop(name string) time.Duration {
+	if name != "" {
+		sw.Lap(name)
+	}
+	now := time.Now()
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.total == 0 {
+		sw.total = max(now.Sub(sw.start), 1)
+	}
+	return sw.total
+}
+
+// Elapsed returns the total duration when stopped, else the duration since the start.
+func (sw *Stopwatch) Elapsed() time.Duration {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.total > 0 {
+		return sw.total
+	}
+	return time.Since(sw.start)
+}
+
+// Laps returns a copy of the recorded laps.
+func (sw *Stopwatch) Laps() []Lap {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return append([]Lap(nil), sw.laps...)
+}
+
+// String formats the elapsed duration followed by the lap breakdown (see FormatLaps).
+func (sw *Stopwatch) String() string {
+	total := sw.Elapsed()
+	laps := sw.Laps()
+	if len(laps) == 0 {
+		return total.String()
+	}
+	return total.String() + " (" + FormatLaps(laps) + ")"
+}
+
+// FormatLaps formats the laps as "auth=1.2ms handler=15ms write=300µs",
+// the laps having the same name are summed (at the position of the first one).
+func FormatLaps(laps []Lap) string {
+	var b strings.Builder
+	for i, lap := range laps {
+		if slices.ContainsFunc(laps[:i], func(l Lap) bool { return l.Name == lap.Name }) {
+			continue
+		}
+		d := lap.Duration
+		for _, next := range laps[i+1:] {
+			if next.Name == lap.Name {
+				d += next.Duration
+			}
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(lap.Name)
+		b.WriteByte('=')
+		b.WriteString(d.String())
+	}
+	return b.String()
+}
+
+// WithStopwatch returns a copy of ctx conveying the Stopwatch,
+// the middlewares and the handlers record their phases with LapCtx.
+func WithStopwatch(ctx context.Context, sw *Stopwatch) context.Context {
+	return context.WithValue(ctx, stopwatchKey{}, sw)
+}
+
+// StopwatchFrom returns the Stopwatch conveyed by ctx, or nil.
+func StopwatchFrom(ctx context.Context) *Stopwatch {
+	sw, _ := ctx.Value(stopwatchKey{}).(*Stopwatch)
+	return sw
+}
+
+// LapCtx records the phase name in the Stopwatch of ctx, if any.
+func LapCtx(ctx context.Context, name string) {
+	if sw := StopwatchFrom(ctx); sw != nil {
+		sw.Lap(name)
+	}
+}
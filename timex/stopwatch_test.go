@@ -0,0 +1,75 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package timex_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/timex"
+)
+
+func TestStopwatch(t *testing.T) {
+	t.Parallel()
+
+	sw := timex.StartStopwatch()
+	time.Sleep(2 * time.Millisecond)
+	auth := sw.Lap("auth")
+	sw.Lap("db")
+	sw.Lap("db")
+	total := sw.Stop("write")
+
+	if auth < 2*time.Millisecond {
+		t.Errorf("auth lap = %v want >= 2ms", auth)
+	}
+	if total < auth {
+		t.Errorf("total %v < auth lap %v", total, auth)
+	}
+	if sw.Lap("late") != 0 || sw.Stop("") != total {
+		t.Error("a stopped Stopwatch must not change")
+	}
+
+	laps := sw.Laps()
+	if len(laps) != 4 {
+		t.Fatalf("got %d laps want 4: %v", len(laps), laps)
+	}
+	var sum time.Duration
+	for _, l := range laps {
+		sum += l.Duration
+	}
+	if sum > total {
+		t.Errorf("sum of the laps %v > total %v", sum, total)
+	}
+
+	s := sw.String()
+	if !strings.HasPrefix(s, total.String()+" (auth=") || strings.Count(s, "db=") != 1 || !strings.Contains(s, " write=") {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestFormatLaps(t *testing.T) {
+	t.Parallel()
+
+	laps := []timex.Lap{{"auth", time.Millisecond}, {"db", 2 * time.Millisecond}, {"handler", 3 * time.Millisecond}, {"db", 4 * time.Millisecond}}
+	want := "auth=1ms db=6ms handler=3ms"
+	if got := timex.FormatLaps(laps); got != want {
+		t.Errorf("timex.FormatLaps() = %q want %q", got, want)
+	}
+}
+
+func TestLapCtx(t *testing.T) {
+	t.Parallel()
+
+	timex.LapCtx(context.Background(), "noop") // no Stopwatch: no panic
+
+	sw := timex.StartStopwatch()
+	ctx := timex.WithStopwatch(context.Background(), sw)
+	timex.LapCtx(ctx, "auth")
+	if timex.StopwatchFrom(ctx) != sw || len(sw.Laps()) != 1 {
+		t.Errorf("laps = %v", sw.Laps())
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 	"unicode/utf8"
 
 	"github.com/lynxai-team/emo"
@@ -25,18 +26,27 @@ type Notifier interface {
 }
 
 // NewNotifier selects the Notifier type depending on the parameter pattern.
+// The URL parameter "allow-private" disables the SSRF check for an intranet server
+// (see gg.CutAllowPrivate).
 func NewNotifier(notifierURL string) Notifier {
 	if len(notifierURL) == 0 {
 		emo.Info("empty dataSourceName => only log the received messages (LogNotifier)")
 		return NewLogNotifier()
 	}
 
+	notifierURL, allowPrivate := gg.CutAllowPrivate(notifierURL)
+	var client *http.Client
+	if allowPrivate {
+		emo.Info("allow-private => the notifier may send to private addresses")
+		client = privateClient
+	}
+
 	const telegramPrefix = "https://api.telegram.org/bot"
 	if strings.HasPrefix(notifierURL, telegramPrefix) {
 		emo.Info("URL has the Telegram prefix: " + notifierURL)
 		p := gg.SplitClean(notifierURL)
 		if len(p) == 2 {
-			return NewTelegramNotifier(p[0], p[1])
+			return NewTelegramNotifier(p[0], p[1]).WithClient(client)
 		}
 
 		emo.Error("Cannot retrieve ChatID from %v", p)
@@ -44,7 +54,7 @@ func NewNotifier(notifierURL string) Notifier {
 	}
 
 	// default
-	return NewMattermostNotifier(notifierURL)
+	return NewMattermostNotifier(notifierURL).WithClient(client)
 }
 
 // LogNotifier implements a Notifier interface that logs the received notifications.
@@ -65,12 +75,20 @@ func (n LogNotifier) Notify(msg []byte) error {
 
 // MattermostNotifier for sending messages to a Mattermost server.
 type MattermostNotifier struct {
+	client   *http.Client
 	endpoint string
 }
 
 // NewMattermostNotifier creates a MattermostNotifier given a Mattermost server endpoint (see mattermost hooks).
 func NewMattermostNotifier(endpoint string) MattermostNotifier {
-	return MattermostNotifier{endpoint}
+	return MattermostNotifier{endpoint: endpoint}
+}
+
+// WithClient returns a copy of the notifier sending its messages with the given http.Client.
+// The default client rejects the private IPs (see gg.SSRFGuard).
+func (n MattermostNotifier) WithClient(client *http.Client) MattermostNotifier {
+	n.client = client
+	return n
 }
 
 // Notify sends a message to a Mattermost server.
@@ -88,7 +106,7 @@ func (n MattermostNotifier) Notify(msg []byte) error {
 	buf = append(buf, '"', '}')
 
 	// Send the request using bytes.NewReader for zero-allocation reading.
-	resp, err := http.Post(n.endpoint, "application/json", bytes.NewReader(buf))
+	resp, err := httpClient(n.client).Post(n.endpoint, "application/json", bytes.NewReader(buf))
 	if err != nil {
 		return fmt.Errorf("MattermostNotifier: %w from host=%s", err, n.host())
 	}
@@ -154,6 +172,7 @@ func (n MattermostNotifier) host() string {
 
 // TelegramNotifier is a Notifier for a specific Telegram chat room.
 type TelegramNotifier struct {
+	client   *http.Client
 	endpoint string
 	chatID   string
 }
@@ -166,9 +185,16 @@ func NewTelegramNotifier(endpoint, chatID string) TelegramNotifier {
 	}
 }
 
+// WithClient returns a copy of the notifier sending its messages with the given http.Client.
+// The default client rejects the private IPs (see gg.SSRFGuard).
+func (n TelegramNotifier) WithClient(client *http.Client) TelegramNotifier {
+	n.client = client
+	return n
+}
+
 // Notify sends a message to the Telegram server.
 func (n TelegramNotifier) Notify(msg []byte) error {
-	response, err := http.PostForm(
+	response, err := httpClient(n.client).PostForm(
 		n.endpoint,
 		url.Values{
 			"chat_id": {n.chatID},
@@ -193,6 +219,27 @@ func (n TelegramNotifier) Notify(msg []byte) error {
 	return nil
 }
 
+// outboundClient sends the notifications and the captcha verifications
+// when no client is provided. The URLs may come from user input,
+// so the private, loopback and link-local addresses are rejected.
+//
+//nolint:gochecknoglobals // shared client reusing the connections
+var outboundClient = (&gg.SSRFGuard{Resolver: gg.DefaultResolver}).Client(outboundTimeout)
+
+// privateClient is the client of the notifiers created with "allow-private".
+//
+//nolint:gochecknoglobals // shared client reusing the connections
+var privateClient = (&gg.SSRFGuard{Resolver: gg.DefaultResolver, AllowPrivate: true}).Client(outboundTimeout)
+
+const outboundTimeout = 30 * time.Second
+
+func httpClient(client *http.Client) *http.Client {
+	if client == nil {
+		return outboundClient
+	}
+	return client
+}
+
 type telegramResponse struct {
 	Result struct {
 		Text string `json:"text"`
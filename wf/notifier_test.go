@@ -65,7 +65,7 @@ func TestNotify_Functional2(t *testing.T) {
 	defer server.Close()
 
 	t.Run("Success", func(t *testing.T) {
-		notifier := wf.NewMattermostNotifier(server.URL)
+		notifier := wf.NewMattermostNotifier(server.URL).WithClient(server.Client())
 		msg := []byte("Hello, World! \n \" \u00A0")
 		err := notifier.Notify(msg)
 		if err != nil {
@@ -80,7 +80,7 @@ func TestNotify_Functional2(t *testing.T) {
 		}))
 		defer failServer.Close()
 
-		notifier := wf.NewMattermostNotifier(failServer.URL)
+		notifier := wf.NewMattermostNotifier(failServer.URL).WithClient(failServer.Client())
 		err := notifier.Notify([]byte("test"))
 		if err == nil {
 			t.Errorf("Expected error for 500 status, got nil")
@@ -181,7 +181,7 @@ func TestNotify_Functional(t *testing.T) {
 	defer server.Close()
 
 	t.Run("Success", func(t *testing.T) {
-		notifier := wf.NewMattermostNotifier(server.URL)
+		notifier := wf.NewMattermostNotifier(server.URL).WithClient(server.Client())
 		msg := []byte("Hello, World! \n \" \u00A0")
 		err := notifier.Notify(msg)
 		if err != nil {
@@ -196,7 +196,7 @@ func TestNotify_Functional(t *testing.T) {
 		}))
 		defer failServer.Close()
 
-		notifier := wf.NewMattermostNotifier(failServer.URL)
+		notifier := wf.NewMattermostNotifier(failServer.URL).WithClient(failServer.Client())
 		err := notifier.Notify([]byte("test"))
 		if err == nil {
 			t.Errorf("Expected error for 500 status, got nil")
@@ -327,7 +327,7 @@ func TestNotify_Functional3(t *testing.T) {
 	defer server.Close()
 
 	// Mock the notifier struct.
-	notifier := wf.NewMattermostNotifier(server.URL)
+	notifier := wf.NewMattermostNotifier(server.URL).WithClient(server.Client())
 
 	t.Run("Success", func(t *testing.T) {
 		msg := []byte("Hello, World! \n \" \u00A0")
@@ -344,7 +344,7 @@ func TestNotify_Functional3(t *testing.T) {
 		}))
 		defer failServer.Close()
 
-		notifier := wf.NewMattermostNotifier(failServer.URL)
+		notifier := wf.NewMattermostNotifier(failServer.URL).WithClient(failServer.Client())
 		err := notifier.Notify([]byte("test"))
 		if err == nil {
 			t.Errorf("Expected error for 500 status, got nil")
@@ -0,0 +1,265 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package wf
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/time/rate"
+
+	"github.com/lynxai-team/garcon/gg"
+)
+
+// Option configures the spam protection and the storage of the WebForm.
+type Option func(*WebForm)
+
+// TimeTokenField is the hidden input field containing the TimeToken.
+const TimeTokenField = "form-ts"
+
+// WithHoneypot rejects the forms having a value in the hidden field:
+// humans do not see the field, but the bots fill it.
+//
+//	<input name="website" style="display:none" tabindex="-1" autocomplete="off">
+func WithHoneypot(field string) Option {
+	return func(wf *WebForm) { wf.honeypot = field }
+}
+
+// WithMinFillTime rejects the forms submitted faster than a human can do.
+// The page must contain the hidden field "form-ts" filled with wf.TimeToken()
+// when the page is rendered. The token is signed when secret is not empty,
+// preventing the bots to forge an old timestamp.
+func WithMinFillTime(d time.Duration, secret []byte) Option {
+	return func(wf *WebForm) {
+		wf.minFillTime = d
+		wf.timeSecret = secret
+	}
+}
+
+// WithCaptcha verifies the hCaptcha or Turnstile response before notifying.
+func WithCaptcha(c Captcha) Option {
+	return func(wf *WebForm) { wf.captcha = &c }
+}
+
+// WithRateLimit limits the submissions per IP: burst submissions, then one every period.
+func WithRateLimit(burst int, every time.Duration) Option {
+	return func(wf *WebForm) {
+		wf.limiter = &ipLimiter{
+			visitors: make(map[string]*visitor),
+			limit:    rate.Every(every),
+			burst:    burst,
+			forget:   time.Duration(burst+1) * every,
+		}
+	}
+}
+
+// TimeToken returns the value of the hidden field "form-ts" required by WithMinFillTime.
+func (wf *WebForm) TimeToken() string {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	if len(wf.timeSecret) == 0 {
+		return ts
+	}
+	return ts + "." + wf.timeMAC(ts)
+}
+
+func (wf *WebForm) timeMAC(ts string) string {
+	mac := hmac.New(sha256.New, wf.timeSecret)
+	mac.Write([]byte(ts))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// spam returns the reason why the form looks sent by a bot, or an empty string.
+func (wf *WebForm) spam(form url.Values) string {
+	if wf.honeypot != "" && form.Get(wf.honeypot) != "" {
+		return "honeypot field " + wf.honeypot + " is filled"
+	}
+
+	if wf.minFillTime > 0 {
+		token := form.Get(TimeTokenField)
+		ts, mac, signed := strings.Cut(token, ".")
+		if len(wf.timeSecret) > 0 && (!signed || !hmac.Equal([]byte(mac), []byte(wf.timeMAC(ts)))) {
+			return "invalid " + TimeTokenField + " signature"
+		}
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return "missing or invalid " + TimeTokenField
+		}
+		elapsed := time.Since(time.Unix(unix, 0))
+		if elapsed < wf.minFillTime {
+			return "filled in " + elapsed.Round(time.Millisecond).String()
+		}
+	}
+
+	return ""
+}
+
+// removeMeta removes the anti-spam fields before the conversion to Markdown.
+func (wf *WebForm) removeMeta(form url.Values) {
+	if wf.honeypot != "" {
+		form.Del(wf.honeypot)
+	}
+	if wf.minFillTime > 0 {
+		form.Del(TimeTokenField)
+	}
+	if wf.captcha != nil {
+		form.Del(wf.captcha.Field)
+	}
+}
+
+// validate rejects the values containing invalid UTF-8 or control characters
+// (except line breaks and tabulations) and the invalid email address.
+func (wf *WebForm) validate(form url.Values) error {
+	for name, values := range form {
+		if _, ok := wf.TextLimits[name]; !ok {
+			continue
+		}
+		for _, v := range values {
+			if !utf8.ValidString(v) {
+				return fmt.Errorf("field %s is not valid UTF-8", name)
+			}
+			for i, r := range v {
+				if r != '\n' && r != '\r' && r != '\t' && !gg.PrintableRune(r) {
+					return fmt.Errorf("field %s contains a bad character at position %d", name, i)
+				}
+			}
+		}
+	}
+
+	if email := form.Get("email"); email != "" {
+		_, err := mail.ParseAddress(email)
+		if err != nil {
+			return fmt.Errorf("field email: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Captcha verifies the response token of hCaptcha or Cloudflare Turnstile
+// (both share the same "siteverify" protocol).
+type Captcha struct {
+	Client    *http.Client // default: rejects the private IPs (see gg.SSRFGuard)
+	VerifyURL string
+	Secret    string
+	Field     string // input field containing the response token
+}
+
+// HCaptcha creates the Captcha verifying the field "h-captcha-response".
+func HCaptcha(secret string) Captcha {
+	return Captcha{
+		VerifyURL: "https://api.hcaptcha.com/siteverify",
+		Secret:    secret,
+		Field:     "h-captcha-response",
+	}
+}
+
+// Turnstile creates the Captcha verifying the field "cf-turnstile-response".
+func Turnstile(secret string) Captcha {
+	return Captcha{
+		VerifyURL: "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		Secret:    secret,
+		Field:     "cf-turnstile-response",
+	}
+}
+
+var ErrCaptcha = errors.New("captcha verification failed")
+
+// Verify asks the captcha provider to validate the token.
+func (c *Captcha) Verify(ctx context.Context, token, remoteIP string) error {
+	if token == "" {
+		return fmt.Errorf("%w: missing %s", ErrCaptcha, c.Field)
+	}
+
+	form := url.Values{"secret": {c.Secret}, "response": {token}}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient(c.Client).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		ErrorCodes []string `json:"error-codes"`
+		Success    bool     `json:"success"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return fmt.Errorf("captcha response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %v", ErrCaptcha, result.ErrorCodes)
+	}
+	return nil
+}
+
+type (
+	// ipLimiter is a token bucket per IP, the idle IPs are forgotten.
+	ipLimiter struct {
+		pruned   time.Time
+		visitors map[string]*visitor
+		limit    rate.Limit
+		burst    int
+		forget   time.Duration
+		mu       sync.Mutex
+	}
+
+	visitor struct {
+		lastSeen time.Time
+		limiter  *rate.Limiter
+	}
+)
+
+func (l *ipLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.pruned) > time.Minute {
+		l.pruned = now
+		for k, v := range l.visitors {
+			if now.Sub(v.lastSeen) > l.forget {
+				delete(l.visitors, k)
+			}
+		}
+	}
+
+	v, ok := l.visitors[ip]
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.visitors[ip] = v
+	}
+	v.lastSeen = now
+	return v.limiter.AllowN(now, 1)
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package wf_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lynxai-team/garcon/wf"
+)
+
+type countNotifier struct{ n int }
+
+func (c *countNotifier) Notify([]byte) error {
+	c.n++
+	return nil
+}
+
+func postForm(form *wf.WebForm, values url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/contact", strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	form.Notify(w, r)
+	return w
+}
+
+func TestWebForm_Spam(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("form-secret")
+	old := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+	valid := func() url.Values {
+		return url.Values{"name": {"Jane"}, "email": {"jane@example.com"}, "text": {"Hello\nworld"}}
+	}
+
+	form := wf.NewContactForm("/thanks", "", wf.WithHoneypot("website"), wf.WithMinFillTime(3*time.Second, secret))
+	notifier := &countNotifier{}
+	form.Notifier = notifier
+
+	cases := []struct {
+		edit     func(url.Values)
+		name     string
+		status   int
+		notified bool
+	}{
+		{func(v url.Values) { v.Set(wf.TimeTokenField, form.TimeToken()) }, "too fast", http.StatusFound, false},
+		{func(v url.Values) { v.Set(wf.TimeTokenField, old) }, "unsigned", http.StatusFound, false},
+		{func(url.Values) {}, "missing token", http.StatusFound, false},
+		{func(v url.Values) {
+			v.Set(wf.TimeTokenField, form.TimeToken())
+			v.Set("website", "http://spam.example")
+		}, "honeypot", http.StatusFound, false},
+		{func(v url.Values) {
+			v.Set(wf.TimeTokenField, signToken(secret, old))
+			v.Set("text", "bell\a")
+		}, "control character", http.StatusBadRequest, false},
+		{func(v url.Values) {
+			v.Set(wf.TimeTokenField, signToken(secret, old))
+			v.Set("email", "not an email")
+		}, "invalid email", http.StatusBadRequest, false},
+		{func(v url.Values) { v.Set(wf.TimeTokenField, signToken(secret, old)) }, "human", http.StatusFound, true},
+	}
+	for _, c := range cases {
+		values := valid()
+		c.edit(values)
+		before := notifier.n
+		w := postForm(&form, values)
+		if w.Code != c.status {
+			t.Errorf("%s: status %d want %d", c.name, w.Code, c.status)
+		}
+		if notified := notifier.n > before; notified != c.notified {
+			t.Errorf("%s: notified=%v want %v", c.name, notified, c.notified)
+		}
+	}
+}
+
+// signToken signs a timestamp the same way as TimeToken.
+func signToken(secret []byte, ts string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	return ts + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebForm_CaptchaAndRateLimit(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		ok := r.PostForm.Get("response") == "good" && r.PostForm.Get("secret") == "s3cr3t" && r.PostForm.Get("remoteip") == "192.0.2.1"
+		json.NewEncoder(w).Encode(map[string]any{"success": ok})
+	}))
+	defer server.Close()
+
+	captcha := wf.Turnstile("s3cr3t")
+	captcha.VerifyURL = server.URL
+	captcha.Client = server.Client()
+	form := wf.NewContactForm("/thanks", "", wf.WithCaptcha(captcha), wf.WithRateLimit(2, time.Hour))
+	notifier := &countNotifier{}
+	form.Notifier = notifier
+
+	w := postForm(&form, url.Values{"name": {"Jane"}, "cf-turnstile-response": {"bad"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad captcha: status %d", w.Code)
+	}
+	w = postForm(&form, url.Values{"name": {"Jane"}, "cf-turnstile-response": {"good"}})
+	if w.Code != http.StatusFound || notifier.n != 1 {
+		t.Errorf("good captcha: status %d notified %d", w.Code, notifier.n)
+	}
+	w = postForm(&form, url.Values{"name": {"Jane"}, "cf-turnstile-response": {"good"}})
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("rate limit: status %d", w.Code)
+	}
+}
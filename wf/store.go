@@ -0,0 +1,324 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package wf
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type (
+	// Submission is a received contact form.
+	Submission struct {
+		Time        time.Time         `json:"time"`
+		Fields      map[string]string `json:"fields,omitempty"`
+		ID          string            `json:"id"`
+		IP          string            `json:"ip,omitempty"`
+		Fingerprint string            `json:"fingerprint,omitempty"`
+		Notified    bool              `json:"notified"`
+	}
+
+	// ContactStore persists the submissions before the notification,
+	// so a failed Notify does not lose the message.
+	// Implementations must be safe for concurrent use.
+	ContactStore interface {
+		// Save stores a new submission.
+		Save(ctx context.Context, s *Submission) error
+		// MarkNotified records the successful notification of the submission.
+		MarkNotified(ctx context.Context, id string) error
+		// List returns the submissions (the most recent first) and the total number.
+		List(ctx context.Context, offset, limit int) ([]Submission, int, error)
+	}
+)
+
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+)
+
+// WithStore saves the submissions in the ContactStore before notifying them.
+func WithStore(store ContactStore) Option {
+	return func(wf *WebForm) { wf.store = store }
+}
+
+// newSubmission keeps the allowed text fields (see TextLimits).
+func (wf *WebForm) newSubmission(r *http.Request, fingerprint string) *Submission {
+	s := &Submission{
+		Time:        time.Now().UTC(),
+		ID:          newSubmissionID(),
+		IP:          remoteIP(r),
+		Fingerprint: fingerprint,
+		Fields:      make(map[string]string, len(r.Form)),
+	}
+	for name, values := range r.Form {
+		if _, ok := wf.TextLimits[name]; ok && len(values) == 1 && values[0] != "" {
+			s.Fields[name] = values[0]
+		}
+	}
+	return s
+}
+
+func newSubmissionID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return strconv.FormatInt(time.Now().Unix(), 36) + "-" + hex.EncodeToString(b[:])
+}
+
+// ListHandler responds the stored submissions:
+//
+//	GET /admin/contacts?page=1&per_page=50
+//
+// ListHandler must be protected, for example by gwt.JWTChecker.Vet and RequirePerm.
+func (wf *WebForm) ListHandler(w http.ResponseWriter, r *http.Request) {
+	if wf.store == nil {
+		http.Error(w, "no ContactStore configured", http.StatusNotFound)
+		return
+	}
+
+	page, err1 := queryInt(r, "page", 1)
+	perPage, err2 := queryInt(r, "per_page", defaultPerPage)
+	if err := errors.Join(err1, err2); err != nil || page < 1 || perPage < 1 {
+		http.Error(w, "page and per_page must be positive integers", http.StatusBadRequest)
+		return
+	}
+	perPage = min(perPage, maxPerPage)
+
+	list, total, err := wf.store.List(r.Context(), (page-1)*perPage, perPage)
+	if err != nil {
+		log.Warn("WebForm ContactStore.List:", err)
+		http.Error(w, "cannot list the submissions", http.StatusInternalServerError)
+		return
+	}
+	if list == nil {
+		list = []Submission{} // respond [] instead of null
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(struct {
+		Submissions []Submission `json:"submissions"`
+		Total       int          `json:"total"`
+		Page        int          `json:"page"`
+		PerPage     int          `json:"per_page"`
+	}{list, total, page, perPage})
+	if err != nil {
+		log.Warn("WebForm ListHandler:", err)
+	}
+}
+
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	txt := r.URL.Query().Get(key)
+	if txt == "" {
+		return def, nil
+	}
+	return strconv.Atoi(txt)
+}
+
+// JSONLStore appends the submissions to a JSONL file,
+// convenient for the small sites without database.
+// MarkNotified appends a line {"id":...,"notified":true} merged by List.
+type JSONLStore struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewJSONLStore creates the JSONL file if it does not exist.
+func NewJSONLStore(path string) (*JSONLStore, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONLStore{path: path}, f.Close()
+}
+
+// Save implements ContactStore.
+func (js *JSONLStore) Save(_ context.Context, s *Submission) error {
+	return js.append(s)
+}
+
+// MarkNotified implements ContactStore.
+func (js *JSONLStore) MarkNotified(_ context.Context, id string) error {
+	return js.append(&Submission{ID: id, Notified: true})
+}
+
+// List implements ContactStore by reading the whole file.
+func (js *JSONLStore) List(_ context.Context, offset, limit int) ([]Submission, int, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	f, err := os.Open(js.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	var all []Submission
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for n := 1; scanner.Scan(); n++ {
+		var s Submission
+		err = json.Unmarshal(scanner.Bytes(), &s)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s:%d: %w", js.path, n, err)
+		}
+		if i, ok := index[s.ID]; ok {
+			all[i].Notified = all[i].Notified || s.Notified
+			continue
+		}
+		index[s.ID] = len(all)
+		all = append(all, s)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	slices.Reverse(all)
+	total := len(all)
+	offset = min(offset, total)
+	return all[offset:min(offset+limit, total)], total, nil
+}
+
+func (js *JSONLStore) append(s *Submission) error {
+	line, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	f, err := os.OpenFile(js.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	return errors.Join(err, f.Close())
+}
+
+// SQLStore stores the submissions in a SQL table using database/sql,
+// the application imports the driver, for example:
+//
+//	import _ "modernc.org/sqlite"
+//	db, err := sql.Open("sqlite", "contacts.db")
+//	store, err := wf.NewSQLStore(ctx, db, "sqlite")
+//
+//	import _ "github.com/jackc/pgx/v5/stdlib"
+//	db, err := sql.Open("pgx", os.Getenv("DATABASE_URL"))
+//	store, err := wf.NewSQLStore(ctx, db, "postgres")
+type SQLStore struct {
+	db     *sql.DB
+	dollar bool // PostgreSQL placeholders: $1, $2...
+}
+
+// NewSQLStore creates the table "contact_submissions" if it does not exist.
+// The dialect is "sqlite" or "postgres".
+func NewSQLStore(ctx context.Context, db *sql.DB, dialect string) (*SQLStore, error) {
+	var st SQLStore
+	switch dialect {
+	case "sqlite", "sqlite3":
+	case "postgres", "postgresql", "pgx":
+		st.dollar = true
+	default:
+		return nil, fmt.Errorf("SQLStore: unsupported dialect %q, want sqlite or postgres", dialect)
+	}
+	st.db = db
+
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS contact_submissions (
+		id          TEXT PRIMARY KEY,
+		created_ms  BIGINT NOT NULL,
+		ip          TEXT NOT NULL,
+		fingerprint TEXT NOT NULL,
+		fields      TEXT NOT NULL,
+		notified    BOOLEAN NOT NULL DEFAULT FALSE
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("SQLStore: create table: %w", err)
+	}
+	return &st, nil
+}
+
+// Save implements ContactStore.
+func (st *SQLStore) Save(ctx context.Context, s *Submission) error {
+	fields, err := json.Marshal(s.Fields)
+	if err != nil {
+		return err
+	}
+	_, err = st.db.ExecContext(ctx, st.query(
+		`INSERT INTO contact_submissions (id, created_ms, ip, fingerprint, fields, notified) VALUES (?, ?, ?, ?, ?, ?)`),
+		s.ID, s.Time.UnixMilli(), s.IP, s.Fingerprint, string(fields), s.Notified)
+	return err
+}
+
+// MarkNotified implements ContactStore.
+func (st *SQLStore) MarkNotified(ctx context.Context, id string) error {
+	_, err := st.db.ExecContext(ctx, st.query(`UPDATE contact_submissions SET notified = ? WHERE id = ?`), true, id)
+	return err
+}
+
+// List implements ContactStore.
+func (st *SQLStore) List(ctx context.Context, offset, limit int) ([]Submission, int, error) {
+	var total int
+	err := st.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM contact_submissions`).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := st.db.QueryContext(ctx, st.query(
+		`SELECT id, created_ms, ip, fingerprint, fields, notified FROM contact_submissions
+		ORDER BY created_ms DESC, id DESC LIMIT ? OFFSET ?`), limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var list []Submission
+	for rows.Next() {
+		var s Submission
+		var ms int64
+		var fields string
+		err = rows.Scan(&s.ID, &ms, &s.IP, &s.Fingerprint, &fields, &s.Notified)
+		if err != nil {
+			return nil, 0, err
+		}
+		s.Time = time.UnixMilli(ms).UTC()
+		err = json.Unmarshal([]byte(fields), &s.Fields)
+		if err != nil {
+			return nil, 0, fmt.Errorf("submission %s: %w", s.ID, err)
+		}
+		list = append(list, s)
+	}
+	return list, total, rows.Err()
+}
+
+// query converts the "?" placeholders into "$n" for PostgreSQL.
+func (st *SQLStore) query(q string) string {
+	if !st.dollar {
+		return q
+	}
+	out := make([]byte, 0, len(q)+8)
+	n := 0
+	for i := range len(q) {
+		if q[i] == '?' {
+			n++
+			out = strconv.AppendInt(append(out, '$'), int64(n), 10)
+			continue
+		}
+		out = append(out, q[i])
+	}
+	return string(out)
+}
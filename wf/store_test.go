@@ -0,0 +1,102 @@
+// Copyright 2021 The contributors of Garcon.
+// This file is part of Garcon, an automatic static-site builder, API server, middlewares and messy functions.
+// SPDX-License-Identifier: MIT
+
+package wf_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/lynxai-team/garcon/wf"
+)
+
+type failNotifier struct{}
+
+func (failNotifier) Notify([]byte) error { return errors.New("notifier down") }
+
+func TestWebForm_Store(t *testing.T) {
+	t.Parallel()
+
+	store, err := wf.NewJSONLStore(filepath.Join(t.TempDir(), "contacts.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	form := wf.NewContactForm("/thanks", "", wf.WithStore(store))
+	form.Notifier = failNotifier{}
+	postForm(&form, url.Values{"name": {"Jane"}, "text": {"first"}, "unknown": {"dropped"}})
+
+	form.Notifier = &countNotifier{}
+	postForm(&form, url.Values{"name": {"John"}, "text": {"second"}})
+
+	list, total, err := store.List(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 || len(list) != 2 {
+		t.Fatalf("want 2 submissions, got total=%d len=%d", total, len(list))
+	}
+
+	// most recent first
+	if list[0].Fields["text"] != "second" || !list[0].Notified {
+		t.Errorf("want second submission notified, got %+v", list[0])
+	}
+	if list[1].Fields["text"] != "first" || list[1].Notified {
+		t.Errorf("want first submission not notified, got %+v", list[1])
+	}
+	if _, ok := list[1].Fields["unknown"]; ok {
+		t.Error("field not in TextLimits should not be stored")
+	}
+	if list[1].IP != "192.0.2.1" || list[1].Fingerprint == "" || list[1].Time.IsZero() {
+		t.Errorf("missing metadata in %+v", list[1])
+	}
+
+	cases := []struct {
+		query  string
+		status int
+		texts  []string
+	}{
+		{"", http.StatusOK, []string{"second", "first"}},
+		{"?page=1&per_page=1", http.StatusOK, []string{"second"}},
+		{"?page=2&per_page=1", http.StatusOK, []string{"first"}},
+		{"?page=3&per_page=1", http.StatusOK, nil},
+		{"?page=0", http.StatusBadRequest, nil},
+		{"?per_page=x", http.StatusBadRequest, nil},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		form.ListHandler(w, httptest.NewRequest(http.MethodGet, "/admin/contacts"+c.query, http.NoBody))
+		if w.Code != c.status {
+			t.Errorf("%q: want status %d, got %d", c.query, c.status, w.Code)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+
+		var page struct {
+			Submissions []wf.Submission `json:"submissions"`
+			Total       int             `json:"total"`
+		}
+		err = json.Unmarshal(w.Body.Bytes(), &page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 2 || len(page.Submissions) != len(c.texts) {
+			t.Errorf("%q: want %d of 2 submissions, got %d of %d", c.query, len(c.texts), len(page.Submissions), page.Total)
+			continue
+		}
+		for i, text := range c.texts {
+			if page.Submissions[i].Fields["text"] != text {
+				t.Errorf("%q: #%d want text %q, got %q", c.query, i, text, page.Submissions[i].Fields["text"])
+			}
+		}
+	}
+}
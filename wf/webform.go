@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/lynxai-team/emo"
 	"github.com/lynxai-team/garcon/gg"
@@ -44,6 +45,15 @@ type WebForm struct {
 	// Zero (or negative) value disables this security check.
 	MaxMDBytes int
 
+	// spam protection, see the Option functions
+	captcha     *Captcha
+	limiter     *ipLimiter
+	honeypot    string
+	timeSecret  []byte
+	minFillTime time.Duration
+
+	store ContactStore // see WithStore
+
 	maxFieldNameLength int
 }
 
@@ -55,7 +65,16 @@ const (
 
 var log = emo.NewZone("wf")
 
-func NewContactForm(redirectURL, notifierURL string) WebForm {
+// NewContactForm creates a WebForm notifying the received contact forms.
+// The options enable the spam protections and the storage of the submissions:
+//
+//	form := wf.NewContactForm("/thanks", notifierURL,
+//		wf.WithHoneypot("website"),
+//		wf.WithMinFillTime(3*time.Second, secret),
+//		wf.WithCaptcha(wf.Turnstile(turnstileSecret)),
+//		wf.WithRateLimit(3, 10*time.Minute),
+//		wf.WithStore(store))
+func NewContactForm(redirectURL, notifierURL string, opts ...Option) WebForm {
 	wf := WebForm{
 		Notifier:           NewNotifier(notifierURL),
 		Redirect:           redirectURL,
@@ -65,6 +84,9 @@ func NewContactForm(redirectURL, notifierURL string) WebForm {
 		MaxMDBytes:         4000,
 		maxFieldNameLength: 0,
 	}
+	for _, opt := range opts {
+		opt(&wf)
+	}
 	wf.init()
 	return wf
 }
@@ -98,7 +120,16 @@ func (wf *WebForm) NotifyHandler() func(w http.ResponseWriter, r *http.Request)
 
 // Notify converts the received web-form into markdown format
 // and sends it to the notifierURL.
+// The suspected spam (honeypot, too fast) is dropped but redirected as a success
+// to not help the bots, whereas the invalid captcha and fields are rejected.
 func (wf *WebForm) Notify(w http.ResponseWriter, r *http.Request) {
+	ip := remoteIP(r)
+	if wf.limiter != nil && !wf.limiter.allow(ip) {
+		log.Security("WebForm: too many forms from", ip)
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return
+	}
+
 	if wf.MaxBodyBytes > 0 {
 		r.Body = http.MaxBytesReader(w, r.Body, wf.MaxBodyBytes)
 	}
@@ -110,10 +141,51 @@ func (wf *WebForm) Notify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if reason := wf.spam(r.Form); reason != "" {
+		log.Security("WebForm: drop spam from", ip, reason)
+		http.Redirect(w, r, wf.Redirect, http.StatusFound)
+		return
+	}
+
+	if wf.captcha != nil {
+		err = wf.captcha.Verify(r.Context(), r.Form.Get(wf.captcha.Field), ip)
+		if err != nil {
+			log.Security("WebForm: reject form from", ip, err)
+			http.Error(w, "captcha verification failed", http.StatusBadRequest)
+			return
+		}
+	}
+
+	wf.removeMeta(r.Form)
+	err = wf.validate(r.Form)
+	if err != nil {
+		log.Warn("WebForm: reject form from", ip, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var sub *Submission
+	if wf.store != nil {
+		sub = wf.newSubmission(r, gg.FingerprintTxt(r))
+		err = wf.store.Save(r.Context(), sub)
+		if err != nil {
+			log.Error("WebForm ContactStore.Save:", err)
+			sub = nil
+		}
+	}
+
 	md := wf.toMarkdown(r)
 	err = wf.Notifier.Notify([]byte(md))
-	if err != nil {
+	switch {
+	case err != nil && sub != nil:
+		log.Warn("WebForm Notify:", err, "=> submission", sub.ID, "kept as not notified")
+	case err != nil:
 		log.Warn("WebForm Notify:", err)
+	case sub != nil:
+		err = wf.store.MarkNotified(r.Context(), sub.ID)
+		if err != nil {
+			log.Warn("WebForm ContactStore.MarkNotified:", err)
+		}
 	}
 
 	http.Redirect(w, r, wf.Redirect, http.StatusFound)